@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/app"
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+)
+
+// backupCmd represents the backup command
+var backupCmd = &cobra.Command{
+	Use:   "backup <file>",
+	Short: "Back up the managed records of every zone to a file",
+	Long: `Read the managed records of every zone back from the DNS server and write them to a JSON file that the restore
+command can re-apply, e.g. before a risky change or to rebuild a DNS server. Zones are transferred with a TSIG-signed
+AXFR when the key is allowed to, otherwise only the names the tailnet's machines would be published under are looked
+up. With bind.owner_id only the names carrying this instance's owner record are backed up, without it every A, AAAA,
+CNAME, TXT and PTR record of a transferred zone is.
+
+Use - as the file to write the backup to stdout.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Set up logging
+		if err := setupLogging(); err != nil {
+			return fmt.Errorf("setting up logging: %w", err)
+		}
+
+		application, err := app.NewApp(cfg)
+		if err != nil {
+			return fmt.Errorf("creating application: %w", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+
+		backup, err := application.Backup(ctx)
+		if err != nil {
+			return fmt.Errorf("backing up records: %w", err)
+		}
+		data, err := json.MarshalIndent(backup, "", "  ")
+		if err != nil {
+			return fmt.Errorf("encoding backup: %w", err)
+		}
+		data = append(data, '\n')
+
+		if args[0] == "-" {
+			if _, err := os.Stdout.Write(data); err != nil {
+				return fmt.Errorf("writing backup: %w", err)
+			}
+			return nil
+		}
+		if err := os.WriteFile(args[0], data, 0o600); err != nil {
+			return fmt.Errorf("writing backup: %w", err)
+		}
+
+		records := 0
+		for _, zone := range backup.Zones {
+			records += len(zone.Records)
+		}
+		klog.Infof("✓ Backed up %d records of %d zones to %s", records, len(backup.Zones), args[0])
+		return nil
+	},
+}
@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/app"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.yaml.in/yaml/v3"
+)
+
+var (
+	migrateWrite bool
+	checkOnline  bool
+	checkOutput  string
+)
+
+// configCmd groups the commands working on the configuration file
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Work with the configuration file",
+}
+
+// configMigrateCmd represents the config migrate command
+var configMigrateCmd = &cobra.Command{
+	Use:   "migrate [file]",
+	Short: "Migrate a configuration file written for an older version",
+	Long: `Read a configuration file written for an older version and print it with every key that was renamed or moved
+in its new place, keeping comments and values as written. Keys that no longer have any effect are dropped. Warnings
+about every migrated key and every key the configuration doesn't know, which would be silently ignored, are printed
+to stderr.
+
+The file defaults to the one given with --config. With --write it is migrated in place.`,
+	Args:        cobra.MaximumNArgs(1),
+	Annotations: map[string]string{skipConfigValidation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := viper.ConfigFileUsed()
+		if len(args) > 0 {
+			path = args[0]
+		}
+		if path == "" {
+			return fmt.Errorf("no configuration file given")
+		}
+
+		data, warnings, err := config.MigrateFile(path)
+		if err != nil {
+			return err
+		}
+		for _, warning := range warnings {
+			fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+		}
+
+		if !migrateWrite {
+			if _, err := os.Stdout.Write(data); err != nil {
+				return fmt.Errorf("writing migrated config: %w", err)
+			}
+			return nil
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("reading config file: %w", err)
+		}
+		if err := os.WriteFile(path, data, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("writing config file: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "Configuration file %s migrated\n", path)
+		return nil
+	},
+}
+
+// configCheckCmd represents the config check command
+var configCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check the configuration for problems without running anything",
+	Long: `Load the configuration from the file, the environment and flags, and report every problem found instead of
+stopping at the first one: settings the validation rejects, keys of the file that are unknown or were moved, and
+settings that are valid on their own but don't fit together, such as a PTR zone that doesn't match the subnet or subnet
+size its records are generated for. Nothing is contacted, so the check also works offline, e.g. in CI.
+
+With --online Tailscale, the DNS provider and every target are also contacted with the configured credentials, once
+the configuration has no errors. --output selects a table (the default), json or yaml. The command exits with 1 when
+an error was found, warnings alone don't fail it.`,
+	Annotations: map[string]string{skipConfigValidation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch checkOutput {
+		case listOutputTable, listOutputJSON, listOutputYAML:
+		default:
+			return fmt.Errorf("unsupported output format %q (supported: %s, %s, %s)", checkOutput, listOutputTable,
+				listOutputJSON, listOutputYAML)
+		}
+
+		problems := cfg.Check(viper.ConfigFileUsed())
+		if checkOnline && !config.HasErrors(problems) {
+			if err := setupLogging(); err != nil {
+				return fmt.Errorf("setting up logging: %w", err)
+			}
+			application, err := app.NewApp(cfg)
+			if err != nil {
+				return fmt.Errorf("creating application: %w", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+			defer cancel()
+			problems = append(problems, application.CheckConnections(ctx)...)
+		}
+
+		if err := printProblems(problems); err != nil {
+			return err
+		}
+		if config.HasErrors(problems) {
+			return fmt.Errorf("the configuration has errors")
+		}
+		return nil
+	},
+}
+
+// printProblems prints the problems found in the configuration in the selected output format
+func printProblems(problems []config.Problem) error {
+	if problems == nil {
+		problems = []config.Problem{}
+	}
+	switch checkOutput {
+	case listOutputJSON:
+		data, err := json.MarshalIndent(problems, "", "  ")
+		if err != nil {
+			return fmt.Errorf("encoding problems: %w", err)
+		}
+		_, err = fmt.Fprintln(os.Stdout, string(data))
+		return err
+	case listOutputYAML:
+		data, err := yaml.Marshal(problems)
+		if err != nil {
+			return fmt.Errorf("encoding problems: %w", err)
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+
+	if len(problems) == 0 {
+		fmt.Println("✓ No problems found")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SEVERITY\tKEY\tPROBLEM")
+	for _, problem := range problems {
+		key := problem.Key
+		if key == "" {
+			key = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", problem.Severity, key, problem.Message)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("writing problems: %w", err)
+	}
+	return nil
+}
+
+//nolint:gochecknoinits // This is a command line tool
+func init() {
+	configMigrateCmd.Flags().BoolVar(&migrateWrite, "write", false,
+		"Write the migrated configuration back to the file instead of printing it")
+	configCmd.AddCommand(configMigrateCmd)
+
+	configCheckCmd.Flags().BoolVar(&checkOnline, "online", false,
+		"Also contact Tailscale, the DNS provider and the targets with the configured credentials")
+	configCheckCmd.Flags().StringVarP(&checkOutput, "output", "o", listOutputTable,
+		fmt.Sprintf("Output format (%s, %s or %s)", listOutputTable, listOutputJSON, listOutputYAML))
+	configCmd.AddCommand(configCheckCmd)
+}
@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var (
+	initForce   bool
+	initNoInput bool
+	initPTR     bool
+	initValues  = make(map[string]*string)
+)
+
+// initPrompts are the settings config init asks for, in order, with the flag setting each of them
+var initPrompts = []struct {
+	key    string
+	flag   string
+	prompt string
+	secret bool
+}{
+	{key: "tailscale.tailnet", flag: "tailnet", prompt: "Tailnet name (e.g. example.com)"},
+	{key: "tailscale.api_key", flag: "api-key", prompt: "Tailscale API key (empty to use an OAuth client)",
+		secret: true},
+	{key: "tailscale.client_id", flag: "client-id", prompt: "Tailscale OAuth client ID"},
+	{key: "tailscale.client_secret", flag: "client-secret", prompt: "Tailscale OAuth client secret", secret: true},
+	{key: "bind.server", flag: "server", prompt: "DNS server accepting the updates"},
+	{key: "bind.zone", flag: "zone", prompt: "Zone the records are published in (e.g. ts.example.com.)"},
+	{key: "bind.key_name", flag: "key-name", prompt: "TSIG key name"},
+	{key: "bind.key_secret", flag: "key-secret", prompt: "TSIG key secret (base64)", secret: true},
+}
+
+// configInitCmd represents the config init command
+var configInitCmd = &cobra.Command{
+	Use:   "init [file]",
+	Short: "Write a commented starter configuration file",
+	Long: `Write a starter configuration file documenting every option with its default, with the settings every setup
+needs filled in: the tailnet and its credentials, and the DNS server, zone and TSIG key records are published with.
+Settings not given as flags are asked for when run in a terminal; with --no-input, or when stdin isn't a terminal,
+they are left as placeholders to fill in.
+
+The file defaults to the one given with --config, or config.yaml; "-" prints the configuration instead. An existing
+file is only replaced with --force.`,
+	Args:        cobra.MaximumNArgs(1),
+	Annotations: map[string]string{skipConfigValidation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := "config.yaml"
+		if cfgFile != "" {
+			path = cfgFile
+		}
+		if len(args) > 0 {
+			path = args[0]
+		}
+		if path != "-" && !initForce {
+			if _, err := os.Stat(path); err == nil {
+				return fmt.Errorf("%s already exists, use --force to replace it", path)
+			}
+		}
+
+		var p *prompter
+		if !initNoInput && term.IsTerminal(int(os.Stdin.Fd())) {
+			p = &prompter{in: bufio.NewReader(os.Stdin), out: os.Stderr}
+		}
+		settings, missing, err := initSettings(cmd, p)
+		if err != nil {
+			return err
+		}
+
+		data, err := config.StarterConfig(exampleConfig, settings)
+		if err != nil {
+			return fmt.Errorf("writing starter configuration: %w", err)
+		}
+		if path == "-" {
+			_, err := os.Stdout.Write(data)
+			return err
+		}
+		// The file holds credentials
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			return fmt.Errorf("writing config file: %w", err)
+		}
+
+		fmt.Fprintf(os.Stderr, "Configuration written to %s\n", path)
+		if len(missing) > 0 {
+			fmt.Fprintf(os.Stderr, "Fill in the placeholders of %s before starting\n", strings.Join(missing, ", "))
+		}
+		fmt.Fprintf(os.Stderr, "Check it with: tailscale-bind-ddns config check --config %s\n", path)
+		return nil
+	},
+}
+
+// initSettings returns the settings written into the starter configuration, taken from the flags and, with a
+// prompter, asked for, along with the keys left as placeholders
+func initSettings(cmd *cobra.Command, p *prompter) ([]config.Setting, []string, error) {
+	values := make(map[string]string)
+	for _, prompt := range initPrompts {
+		value := *initValues[prompt.key]
+		oauth := prompt.key == "tailscale.client_id" || prompt.key == "tailscale.client_secret"
+		if p != nil && !cmd.Flags().Changed(prompt.flag) && !(oauth && values["tailscale.api_key"] != "") {
+			var err error
+			if value, err = p.ask(prompt.prompt, prompt.secret); err != nil {
+				return nil, nil, err
+			}
+		}
+		if value != "" {
+			values[prompt.key] = value
+		}
+	}
+
+	ptr := initPTR
+	if p != nil && !cmd.Flags().Changed("ptr") {
+		answer, err := p.ask("Publish PTR records in the reverse zone 64.100.in-addr.arpa? [y/N]", false)
+		if err != nil {
+			return nil, nil, err
+		}
+		ptr = strings.EqualFold(answer, "y") || strings.EqualFold(answer, "yes")
+	}
+
+	var (
+		settings []config.Setting
+		missing  []string
+	)
+	for _, prompt := range initPrompts {
+		value, ok := values[prompt.key]
+		switch {
+		case ok:
+			settings = append(settings, config.Setting{Key: prompt.key, Value: value})
+		case prompt.key == "tailscale.api_key":
+		case strings.HasPrefix(prompt.key, "tailscale.client_") && values["tailscale.api_key"] != "":
+			// The API key replaces the OAuth client
+			settings = append(settings, config.Setting{Key: prompt.key})
+		default:
+			missing = append(missing, prompt.key)
+		}
+	}
+	settings = append(settings, config.Setting{Key: "bind.ptr.enabled", Value: ptr})
+	return settings, missing, nil
+}
+
+// prompter asks for settings on a terminal
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// ask shows a prompt and returns the trimmed answer, secrets aren't echoed
+func (p *prompter) ask(prompt string, secret bool) (string, error) {
+	fmt.Fprintf(p.out, "%s: ", prompt)
+	if secret {
+		answer, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(p.out)
+		if err != nil {
+			return "", fmt.Errorf("reading answer: %w", err)
+		}
+		return strings.TrimSpace(string(answer)), nil
+	}
+
+	answer, err := p.in.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("reading answer: %w", err)
+	}
+	return strings.TrimSpace(answer), nil
+}
+
+//nolint:gochecknoinits // This is a command line tool
+func init() {
+	for _, prompt := range initPrompts {
+		initValues[prompt.key] = configInitCmd.Flags().String(prompt.flag, "", prompt.prompt)
+	}
+	configInitCmd.Flags().BoolVar(&initPTR, "ptr", false, "Publish PTR records in 64.100.in-addr.arpa")
+	configInitCmd.Flags().BoolVar(&initForce, "force", false, "Replace an existing configuration file")
+	configInitCmd.Flags().BoolVar(&initNoInput, "no-input", false, "Don't ask for settings not given as flags")
+	configCmd.AddCommand(configInitCmd)
+}
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/app"
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+)
+
+var (
+	diffApply  bool
+	diffPrune  bool
+	diffDryRun bool
+)
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Compare the managed zones on the DNS server to what the tailnet says they should hold",
+	Long: `Read the managed zones from the DNS server and print how they differ from the records the tailnet's machines
+would currently be published as. Every zone is transferred with a TSIG-signed AXFR when the key is allowed to,
+otherwise only the names of the desired records are looked up, so that extra records can't be found:
+  +  missing: desired record the zone doesn't hold
+  ~  mismatch: desired record set the zone holds with other values or TTLs, shown as server -> desired
+  -  extra: record the zone holds that isn't desired, e.g. a leftover or one added by hand (transferred zones only)
+
+With --apply the missing and mismatched records are published, and with --prune the extra records are removed as well.
+Only A, AAAA, CNAME, TXT and PTR records are compared, so records of other types are never touched.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Set up logging
+		if err := setupLogging(); err != nil {
+			return fmt.Errorf("setting up logging: %w", err)
+		}
+		if diffPrune && !diffApply {
+			return fmt.Errorf("--prune requires --apply")
+		}
+
+		if diffDryRun {
+			cfg.General.DryRun = true
+		}
+		application, err := app.NewApp(cfg)
+		if err != nil {
+			return fmt.Errorf("creating application: %w", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+
+		diff, err := application.Diff(ctx)
+		if err != nil {
+			return fmt.Errorf("comparing records: %w", err)
+		}
+		for _, zone := range diff.Zones {
+			printZoneDiff(zone)
+		}
+
+		if !diffApply {
+			return nil
+		}
+		if diff.Empty() {
+			klog.Info("✓ Every zone is up to date, nothing to apply")
+			return nil
+		}
+		result, err := application.ApplyDiff(ctx, diff, diffPrune)
+		if err != nil {
+			return fmt.Errorf("applying differences: %w", err)
+		}
+		if result.DryRun {
+			klog.Info("DRY RUN: No records were sent")
+			return nil
+		}
+		sent, removed := 0, 0
+		for _, zone := range result.Zones {
+			sent += zone.Sent
+			removed += zone.Removed
+		}
+		klog.Infof("✓ Applied the differences: %d records sent, %d removed", sent, removed)
+		return nil
+	},
+}
+
+// printZoneDiff prints the missing, mismatched and extra records of a zone
+func printZoneDiff(zone bind.ZoneContents) {
+	source := "transferred"
+	if !zone.Transferred {
+		source = "looked up by name, transfer not permitted"
+	}
+	fmt.Printf("%s (%s):\n", zone.Zone, source)
+
+	if zone.Diff.Empty() {
+		fmt.Println("  up to date")
+	}
+	for _, record := range zone.Diff.Added {
+		printRecord("+ ", record)
+	}
+	printed := make(map[bind.RecordKey]bool)
+	for _, record := range zone.Diff.Changed {
+		if printed[record.Key()] {
+			continue
+		}
+		printed[record.Key()] = true
+		fmt.Printf("  ~ %-5s  %s  %s -> %s\n", record.Key().Type, record.Name,
+			recordSetValues(zone.Records, record.Key()), recordSetValues(zone.Diff.Changed, record.Key()))
+	}
+	for _, record := range zone.Diff.Removed {
+		printRecord("- ", record)
+	}
+}
+
+// recordSetValues returns the values and TTLs of the records of a record set, sorted
+func recordSetValues(records []bind.DNSRecord, key bind.RecordKey) string {
+	var values []string
+	for _, record := range records {
+		if record.Key() == key {
+			values = append(values, fmt.Sprintf("%s (TTL %d)", record.Value, record.TTL))
+		}
+	}
+	slices.Sort(values)
+	return strings.Join(values, ", ")
+}
+
+//nolint:gochecknoinits // This is a command line tool
+func init() {
+	diffCmd.Flags().BoolVar(&diffApply, "apply", false, "Publish the missing and mismatched records")
+	diffCmd.Flags().BoolVar(&diffPrune, "prune", false, "With --apply, also remove the extra records")
+	diffCmd.Flags().BoolVar(&diffDryRun, "dry-run", false,
+		"With --apply, log the records that would be published and removed without sending them")
+}
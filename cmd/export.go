@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/app"
+	"github.com/spf13/cobra"
+)
+
+var exportFormat string
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the tailnet's machines or their records in a format other tools understand",
+	Long: `Fetch the online machines from Tailscale and print them or the records they would be published as in the
+requested format, using the same names the DNS records are published under. Nothing is sent to the DNS server.
+
+Supported formats:
+  ansible  Dynamic inventory JSON with hosts grouped by Tailscale tag and ansible_host set to the Tailscale IP.
+           Point Ansible at a small wrapper script such as:
+             #!/bin/sh
+             exec tailscale-bind-ddns export --format ansible
+  bind     Zone file fragment with every record, one section per zone, e.g. to bootstrap a static zone.
+  dnsmasq  dnsmasq configuration: host-record, cname, txt-record and ptr-record lines.
+  hosts    Hosts file with a line per address, CNAME records pointing at a name added as its aliases.
+
+Only Tailscale credentials are required, and bind.zone for the formats rendering records.`,
+	Annotations: map[string]string{skipConfigValidation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Set up logging
+		if err := setupLogging(); err != nil {
+			return fmt.Errorf("setting up logging: %w", err)
+		}
+
+		application, err := app.NewApp(cfg)
+		if err != nil {
+			return fmt.Errorf("creating application: %w", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+
+		data, err := application.Export(ctx, exportFormat)
+		if err != nil {
+			return fmt.Errorf("exporting machines: %w", err)
+		}
+
+		if _, err := fmt.Fprintln(os.Stdout, strings.TrimSuffix(string(data), "\n")); err != nil {
+			return fmt.Errorf("writing export: %w", err)
+		}
+		return nil
+	},
+}
+
+//nolint:gochecknoinits // This is a command line tool
+func init() {
+	exportCmd.Flags().StringVar(&exportFormat, "format", app.ExportFormatAnsible,
+		fmt.Sprintf("Export format (%s)", strings.Join(app.ExportFormats(), ", ")))
+}
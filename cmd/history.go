@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/app"
+	"github.com/spf13/cobra"
+)
+
+var historyAddress string
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history <hostname>",
+	Short: "Show the recent transitions of a device",
+	Long: `Show when a device recently came online, went offline or changed addresses, as recorded by a running daemon.
+
+The device can be given by its Tailscale name, the name of its DNS record or its ID. Use this to see whether a host is
+flapping; general.history_size sets how many transitions the daemon keeps per device.`,
+	Args:        cobra.ExactArgs(1),
+	Annotations: map[string]string{skipConfigValidation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Set up logging
+		if err := setupLogging(); err != nil {
+			return fmt.Errorf("setting up logging: %w", err)
+		}
+
+		address := historyAddress
+		if address == "" {
+			address = cfg.General.StatusAddress
+		}
+		if address == "" {
+			return fmt.Errorf("a status address is required (--address or general.status_address)")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+
+		history, err := app.FetchHistory(ctx, address, args[0])
+		if err != nil {
+			return fmt.Errorf("fetching device history: %w", err)
+		}
+
+		state := "offline"
+		if history.Online {
+			state = "online"
+		}
+		fmt.Printf("%s (%s, record %s) is %s, %d flaps in %d transitions:\n", history.Name, history.ID,
+			history.RecordName, state, history.Flaps, len(history.Transitions))
+		for _, transition := range history.Transitions {
+			fmt.Printf("  %s  %-15s  %s %s\n", transition.Time.Local().Format(time.RFC3339), transition.Kind,
+				transition.IPv4Address, transition.IPv6Address)
+		}
+		return nil
+	},
+}
+
+//nolint:gochecknoinits // This is a command line tool
+func init() {
+	historyCmd.Flags().StringVar(&historyAddress, "address", "",
+		"Status address of the running daemon (host:port or unix:/path), defaults to general.status_address")
+}
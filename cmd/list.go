@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/app"
+	"github.com/spf13/cobra"
+	"go.yaml.in/yaml/v3"
+)
+
+// Output formats of the list command
+const (
+	listOutputTable = "table"
+	listOutputJSON  = "json"
+	listOutputYAML  = "yaml"
+)
+
+var listOutput string
+
+// listCmd represents the list command
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the tailnet's machines and the records they would be published as",
+	Long: `Fetch every machine of the tailnet, offline ones included, run them through the filters, naming rules and
+plugins of the configuration and print the machines along with the records the online ones would be published as.
+Machines that get no records show the first stage of the record pipeline they failed. Nothing is sent to DNS, so name
+templates and filters can be tried out without running the daemon.
+
+Only Tailscale credentials are required. --output selects a table (the default), json or yaml.`,
+	Annotations: map[string]string{skipConfigValidation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Set up logging
+		if err := setupLogging(); err != nil {
+			return fmt.Errorf("setting up logging: %w", err)
+		}
+
+		switch listOutput {
+		case listOutputTable, listOutputJSON, listOutputYAML:
+		default:
+			return fmt.Errorf("unsupported output format %q (supported: %s, %s, %s)", listOutput, listOutputTable,
+				listOutputJSON, listOutputYAML)
+		}
+
+		application, err := app.NewApp(cfg)
+		if err != nil {
+			return fmt.Errorf("creating application: %w", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+
+		listing, err := application.List(ctx)
+		if err != nil {
+			return fmt.Errorf("listing machines: %w", err)
+		}
+
+		switch listOutput {
+		case listOutputJSON:
+			data, err := json.MarshalIndent(listing, "", "  ")
+			if err != nil {
+				return fmt.Errorf("encoding listing: %w", err)
+			}
+			_, err = fmt.Fprintln(os.Stdout, string(data))
+			return err
+		case listOutputYAML:
+			data, err := yaml.Marshal(listing)
+			if err != nil {
+				return fmt.Errorf("encoding listing: %w", err)
+			}
+			_, err = os.Stdout.Write(data)
+			return err
+		}
+		return printListing(listing)
+	},
+}
+
+// printListing prints the machines and records of a listing as tables
+func printListing(listing *app.Listing) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MACHINE\tONLINE\tADDRESSES\tPUBLISHED AS")
+	for _, machine := range listing.Machines {
+		online := "no"
+		if machine.Online {
+			online = "yes"
+		}
+		published := machine.FQDN
+		if !machine.Published {
+			published = "- (" + machine.Reason + ")"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", machine.Name, online, strings.Join(machine.Addresses, ","), published)
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "TYPE\tNAME\tVALUE\tTTL\tMACHINE")
+	for _, record := range listing.Records {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", record.Type, record.Name, record.Value, record.TTL, record.Machine)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("writing listing: %w", err)
+	}
+	return nil
+}
+
+//nolint:gochecknoinits // This is a command line tool
+func init() {
+	listCmd.Flags().StringVarP(&listOutput, "output", "o", listOutputTable,
+		fmt.Sprintf("Output format (%s, %s or %s)", listOutputTable, listOutputJSON, listOutputYAML))
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/app"
+	"github.com/spf13/cobra"
+)
+
+var (
+	listMachinesExplain bool
+	listMachinesLive    bool
+	listMachinesAddress string
+)
+
+// listMachinesCmd represents the list-machines command
+var listMachinesCmd = &cobra.Command{
+	Use:   "list-machines",
+	Short: "List the tailnet's machines and whether they are published in DNS",
+	Long: `List every machine of the tailnet, offline ones included, with the name and zone its records are published
+under. With --explain every stage of the record pipeline is shown for each machine (online state, include and exclude
+hostname patterns, published addresses and zone selection) along with whether it passed, which answers "why isn't my
+machine in DNS?".
+
+By default the machines are fetched from Tailscale with the local configuration, which only needs Tailscale
+credentials. With --live a running daemon is asked instead, via its status address, which has to be a unix socket.`,
+	Annotations: map[string]string{skipConfigValidation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Set up logging
+		if err := setupLogging(); err != nil {
+			return fmt.Errorf("setting up logging: %w", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+
+		var explanations []app.MachineExplanation
+		if listMachinesLive {
+			address := listMachinesAddress
+			if address == "" {
+				address = cfg.General.StatusAddress
+			}
+			if address == "" {
+				return fmt.Errorf("--live requires a status address (--address or general.status_address)")
+			}
+
+			var err error
+			if explanations, err = app.FetchExplanations(ctx, address); err != nil {
+				return fmt.Errorf("fetching machines from daemon: %w", err)
+			}
+		} else {
+			application, err := app.NewApp(cfg)
+			if err != nil {
+				return fmt.Errorf("creating application: %w", err)
+			}
+			if explanations, err = application.ExplainMachines(ctx); err != nil {
+				return fmt.Errorf("listing machines: %w", err)
+			}
+		}
+
+		for _, machine := range explanations {
+			if machine.Published {
+				fmt.Printf("%s (%s): published as %s.%s\n", machine.Name, machine.ID, machine.RecordName, machine.Zone)
+			} else {
+				fmt.Printf("%s (%s): not published\n", machine.Name, machine.ID)
+			}
+			if !listMachinesExplain {
+				continue
+			}
+			for _, step := range machine.Steps {
+				result := "pass"
+				if !step.Passed {
+					result = "FAIL"
+				}
+				fmt.Printf("  %s  %-17s  %s\n", result, step.Filter, step.Detail)
+			}
+		}
+		return nil
+	},
+}
+
+//nolint:gochecknoinits // This is a command line tool
+func init() {
+	listMachinesCmd.Flags().BoolVar(&listMachinesExplain, "explain", false,
+		"Show which stages of the record pipeline each machine passed or failed")
+	listMachinesCmd.Flags().BoolVar(&listMachinesLive, "live", false, "Ask a running daemon instead of Tailscale")
+	listMachinesCmd.Flags().StringVar(&listMachinesAddress, "address", "",
+		"Status address of the running daemon, a unix:/path socket, defaults to general.status_address")
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/app"
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/spf13/cobra"
+)
+
+var listRecordsDiff bool
+
+// listRecordsCmd represents the list-records command
+var listRecordsCmd = &cobra.Command{
+	Use:   "list-records",
+	Short: "List the records the DNS server holds in the managed zones",
+	Long: `Read the records of the managed zones back from the DNS server. Every zone is transferred with a TSIG-signed
+AXFR when the key is allowed to, otherwise only the names the tailnet's machines would be published under are looked
+up. Only A, AAAA, CNAME, TXT and PTR records are listed, without the ownership records of bind.owner_id.
+
+With --diff the records are compared to the ones the machines would currently be published as instead:
+  +  desired record missing from the zone
+  ~  desired record whose record set on the server holds other values or TTLs
+  -  record in the zone that isn't desired, e.g. a leftover or one added by hand (transferred zones only)`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Set up logging
+		if err := setupLogging(); err != nil {
+			return fmt.Errorf("setting up logging: %w", err)
+		}
+
+		application, err := app.NewApp(cfg)
+		if err != nil {
+			return fmt.Errorf("creating application: %w", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+
+		zones, err := application.ListRecords(ctx)
+		if err != nil {
+			return fmt.Errorf("listing records: %w", err)
+		}
+
+		for _, zone := range zones {
+			source := "transferred"
+			if !zone.Transferred {
+				source = "looked up by name, transfer not permitted"
+			}
+			fmt.Printf("%s (%s):\n", zone.Zone, source)
+
+			if !listRecordsDiff {
+				for _, record := range zone.Records {
+					printRecord("", record)
+				}
+				continue
+			}
+			if zone.Diff.Empty() {
+				fmt.Println("  up to date")
+			}
+			for _, record := range zone.Diff.Added {
+				printRecord("+ ", record)
+			}
+			for _, record := range zone.Diff.Changed {
+				printRecord("~ ", record)
+			}
+			for _, record := range zone.Diff.Removed {
+				printRecord("- ", record)
+			}
+		}
+		return nil
+	},
+}
+
+// printRecord prints a record on its own indented line
+func printRecord(prefix string, record bind.DNSRecord) {
+	fmt.Printf("  %s%-5s  %s  %s  (TTL %d)\n", prefix, record.Key().Type, record.Name, record.Value, record.TTL)
+}
+
+//nolint:gochecknoinits // This is a command line tool
+func init() {
+	listRecordsCmd.Flags().BoolVar(&listRecordsDiff, "diff", false,
+		"Compare the records on the server to the records the machines would be published as")
+}
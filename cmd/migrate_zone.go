@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/app"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/klog/v2"
+)
+
+var (
+	migrateDryRun        bool
+	migrateVerifyTimeout time.Duration
+	migrateWriteConfig   bool
+)
+
+// migrateZoneCmd represents the migrate-zone command
+var migrateZoneCmd = &cobra.Command{
+	Use:   "migrate-zone <old-zone> <new-zone>",
+	Short: "Move the published records from one zone to another",
+	Long: `Move the records of the tailnet's machines from the old zone to the new one in steps that each have to
+succeed before the next one starts, so that the machines resolve in at least one of the zones throughout:
+
+  1. The records of the online machines are published in the new zone, PTR records now pointing to the new names
+  2. The new records are looked up until all of them resolve
+  3. The records of every machine, online or not, are withdrawn from the old zone
+  4. The old records are looked up until none of them resolves anymore
+
+Records are looked up through bind.verify_resolvers when set, otherwise on the DNS server. If a step fails the
+migration stops, leaving the records of the old zone in place until the new ones resolve. Records of the additional
+zones of bind.zones stay where they are, and the state and queue files aren't touched.
+
+Stop the daemon first, otherwise it keeps publishing the records of its zone. With --write-config bind.zone is set to
+the new zone in the configuration file once the migration succeeded.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Set up logging
+		if err := setupLogging(); err != nil {
+			return fmt.Errorf("setting up logging: %w", err)
+		}
+
+		if migrateDryRun {
+			cfg.General.DryRun = true
+		}
+		application, err := app.NewApp(cfg)
+		if err != nil {
+			return fmt.Errorf("creating application: %w", err)
+		}
+
+		// Both verification steps may take the whole verify timeout
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout+2*migrateVerifyTimeout)
+		defer cancel()
+
+		migration, err := application.MigrateZone(ctx, args[0], args[1], migrateVerifyTimeout)
+		if err != nil {
+			return fmt.Errorf("migrating records: %w", err)
+		}
+		if cfg.General.DryRun {
+			klog.Info("DRY RUN: No records were sent")
+			return nil
+		}
+		klog.Infof("✓ Published %d records in zone %s", migration.Published, migration.To)
+		klog.Infof("✓ Withdrew %d records from zone %s", migration.Withdrawn, migration.From)
+		if !migration.Verified {
+			klog.Warningf("Provider %s can't look records up, the migration wasn't verified", cfg.General.Provider)
+		}
+
+		if !migrateWriteConfig {
+			klog.Infof("Set bind.zone to %s before starting the daemon again", migration.To)
+			return nil
+		}
+		path := viper.ConfigFileUsed()
+		if path == "" {
+			return fmt.Errorf("--write-config requires a configuration file")
+		}
+		if err := config.SetFileValues(path, map[string]string{"bind.zone": migration.To}); err != nil {
+			return fmt.Errorf("writing new zone to config file: %w", err)
+		}
+		klog.Infof("✓ Configuration file %s updated with zone %s", path, migration.To)
+		return nil
+	},
+}
+
+//nolint:gochecknoinits // This is a command line tool
+func init() {
+	migrateZoneCmd.Flags().BoolVar(&migrateDryRun, "dry-run", false,
+		"Log the records that would be published and withdrawn without sending them")
+	migrateZoneCmd.Flags().DurationVar(&migrateVerifyTimeout, "verify-timeout", 2*time.Minute,
+		"How long to wait for the records of each step to resolve or disappear")
+	migrateZoneCmd.Flags().BoolVar(&migrateWriteConfig, "write-config", false,
+		"Set bind.zone to the new zone in the configuration file once the migration succeeded")
+}
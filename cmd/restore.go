@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/app"
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+)
+
+var restoreDryRun bool
+
+// restoreCmd represents the restore command
+var restoreCmd = &cobra.Command{
+	Use:   "restore <file>",
+	Short: "Re-apply the records of a backup with dynamic updates",
+	Long: `Re-apply the records of a file written by the backup command with dynamic updates, replacing the record sets
+they belong to. Record sets the server already holds as backed up are left alone, so restoring twice changes nothing.
+Records added since the backup are kept. The update policy and the ownership registry of bind.owner_id apply as they
+do to regular updates.
+
+A running daemon doesn't take the restored records as its own: it keeps publishing its desired records over them and
+never removes restored records as stale.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Set up logging
+		if err := setupLogging(); err != nil {
+			return fmt.Errorf("setting up logging: %w", err)
+		}
+
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return fmt.Errorf("reading backup: %w", err)
+		}
+		backup, err := bind.ParseBackup(data)
+		if err != nil {
+			return err
+		}
+		klog.Infof("Restoring %d zones backed up at %s", len(backup.Zones), backup.Created.Format(time.RFC3339))
+
+		if restoreDryRun {
+			cfg.General.DryRun = true
+		}
+		application, err := app.NewApp(cfg)
+		if err != nil {
+			return fmt.Errorf("creating application: %w", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+
+		sent, err := application.Restore(ctx, backup)
+		if err != nil {
+			return fmt.Errorf("restoring after sending %d records: %w", sent, err)
+		}
+		if cfg.General.DryRun {
+			klog.Info("DRY RUN: No records were sent")
+			return nil
+		}
+		klog.Infof("✓ Restored %d records", sent)
+		return nil
+	},
+}
+
+//nolint:gochecknoinits // This is a command line tool
+func init() {
+	restoreCmd.Flags().BoolVar(&restoreDryRun, "dry-run", false,
+		"Log the records that would be restored without sending them")
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
 	"github.com/aauren/tailscale-bind-ddns/pkg/config"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -18,11 +19,21 @@ const (
 	defaultTTL            = 300 * time.Second
 	defaultUpdateInterval = 60 * time.Second
 	testTimeout           = 30 * time.Second
+	selfUpdateTimeout     = 10 * time.Minute
+	syncTimeout           = 5 * time.Minute
+
+	// skipConfigValidation is a command annotation marking commands that work with partial configuration
+	skipConfigValidation = "skip-config-validation"
 )
 
 var (
 	cfgFile string
 	cfg     *config.Config
+
+	// buildVersion is the release version the binary was built as, set by SetVersion
+	buildVersion = "dev"
+	// exampleConfig is the example configuration documenting every option, set by SetExampleConfig
+	exampleConfig []byte
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -43,8 +54,16 @@ Example usage:
   tailscale-bind-ddns run --config config.yaml
   TSBD_TAILSCALE_API_KEY=your_key tailscale-bind-ddns run`,
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if cfgFile != "" {
+			viper.SetConfigFile(cfgFile)
+		}
+
 		var err error
-		cfg, err = config.LoadConfig()
+		if cmd.Annotations[skipConfigValidation] == "true" {
+			cfg, err = config.LoadPartialConfig()
+		} else {
+			cfg, err = config.LoadConfig()
+		}
 		if err != nil {
 			return fmt.Errorf("loading configuration: %w", err)
 		}
@@ -89,8 +108,24 @@ func setupLogging() error {
 func initializeCommands() {
 	// Add all commands
 	rootCmd.AddCommand(runCmd)
+	rootCmd.AddCommand(syncCmd)
 	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(watchCmd)
 	rootCmd.AddCommand(testCmd)
+	rootCmd.AddCommand(rotateKeyCmd)
+	rootCmd.AddCommand(simulateCmd)
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(historyCmd)
+	rootCmd.AddCommand(listCmd)
+	rootCmd.AddCommand(listMachinesCmd)
+	rootCmd.AddCommand(listRecordsCmd)
+	rootCmd.AddCommand(diffCmd)
+	rootCmd.AddCommand(backupCmd)
+	rootCmd.AddCommand(restoreCmd)
+	rootCmd.AddCommand(migrateZoneCmd)
+	rootCmd.AddCommand(selfUpdateCmd)
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(snmpPassPersistCmd)
 
 	// Global flags
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is config.yaml)")
@@ -109,11 +144,23 @@ func bindGlobalFlagsToViper() {
 	}
 }
 
+// SetVersion records the version information the binary was built with
+func SetVersion(version, commit, date string) {
+	buildVersion = version
+	bind.Version = version
+	rootCmd.Version = fmt.Sprintf("%s (commit %s, built %s)", version, commit, date)
+}
+
+// SetExampleConfig sets the example configuration starter configurations are written from
+func SetExampleConfig(example []byte) {
+	exampleConfig = example
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 func Execute() {
 	initializeCommands()
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 }
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/app"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/klog/v2"
+)
+
+var (
+	rotateKeyName     string
+	rotateKeySecret   string
+	rotateAlgorithm   string
+	rotateLive        bool
+	rotateAddress     string
+	rotateWriteConfig bool
+)
+
+// rotateKeyCmd represents the rotate-key command
+var rotateKeyCmd = &cobra.Command{
+	Use:   "rotate-key",
+	Short: "Verify and switch to a new TSIG key",
+	Long: `Verify that the Bind server accepts updates signed with a new TSIG key by sending a probe update, then switch
+to it. With --live the key is swapped into a running daemon via its unix socket status address, and with
+--write-config the key is written back to the configuration file. Nothing is changed if the probe fails.
+
+Keys read from bind.key_secret_file are rotated by writing the new secret to the file instead, the daemon verifies and
+swaps it in with its next check.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Set up logging
+		if err := setupLogging(); err != nil {
+			return fmt.Errorf("setting up logging: %w", err)
+		}
+
+		if err := app.CheckKeyRotation(&cfg.Bind); err != nil {
+			return err
+		}
+
+		rotation := app.KeyRotation{
+			KeyName:   rotateKeyName,
+			KeySecret: rotateKeySecret,
+			Algorithm: rotateAlgorithm,
+		}
+		if rotation.Algorithm == "" {
+			rotation.Algorithm = cfg.Bind.Algorithm
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+
+		klog.Infof("Verifying new TSIG key %s with a probe update...", rotation.KeyName)
+		if err := app.VerifyTSIGKey(ctx, cfg.Bind, rotation); err != nil {
+			return err
+		}
+		klog.Info("✓ Probe update accepted with new key")
+
+		if rotateLive {
+			address := rotateAddress
+			if address == "" {
+				address = cfg.General.StatusAddress
+			}
+			if err := app.RotateDaemonKey(ctx, address, rotation); err != nil {
+				return fmt.Errorf("rotating key in running daemon: %w", err)
+			}
+			klog.Info("✓ Running daemon switched to new key")
+		}
+
+		if rotateWriteConfig {
+			path := viper.ConfigFileUsed()
+			if path == "" {
+				return fmt.Errorf("--write-config requires a configuration file")
+			}
+			err := config.SetFileValues(path, map[string]string{
+				"bind.key_name":   rotation.KeyName,
+				"bind.key_secret": rotation.KeySecret,
+				"bind.algorithm":  rotation.Algorithm,
+			})
+			if err != nil {
+				return fmt.Errorf("writing new key to config file: %w", err)
+			}
+			klog.Infof("✓ Configuration file %s updated with new key", path)
+		}
+
+		klog.Info("Key rotation completed successfully!")
+		return nil
+	},
+}
+
+//nolint:gochecknoinits // This is a command line tool
+func init() {
+	rotateKeyCmd.Flags().StringVar(&rotateKeyName, "key-name", "", "Name of the new TSIG key")
+	rotateKeyCmd.Flags().StringVar(&rotateKeySecret, "key-secret", "", "Secret of the new TSIG key")
+	rotateKeyCmd.Flags().StringVar(&rotateAlgorithm, "algorithm", "",
+		"Algorithm of the new TSIG key (defaults to the configured algorithm)")
+	rotateKeyCmd.Flags().BoolVar(&rotateLive, "live", false,
+		"Swap the key into a running daemon via its unix socket status address")
+	rotateKeyCmd.Flags().StringVar(&rotateAddress, "address", "",
+		"Unix socket status address of the running daemon, defaults to general.status_address")
+	rotateKeyCmd.Flags().BoolVar(&rotateWriteConfig, "write-config", false,
+		"Write the new key back to the configuration file")
+	for _, flag := range []string{"key-name", "key-secret"} {
+		if err := rotateKeyCmd.MarkFlagRequired(flag); err != nil {
+			klog.Errorf("Failed to mark %s flag required: %v", flag, err)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"syscall"
 
 	"github.com/aauren/tailscale-bind-ddns/pkg/app"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"k8s.io/klog/v2"
@@ -56,22 +57,136 @@ to Bind DNS records. The application will run until interrupted.`,
 //nolint:gochecknoinits // This is a command line tool
 func init() {
 	// Run command flags
+	runCmd.Flags().String("tailscale-mode", config.TailscaleModeAPI, "How devices are discovered (api, tsnet or local)")
+	runCmd.Flags().String("tailscale-tsnet-hostname", config.DefaultTSNetHostname,
+		"Name the node joining the tailnet in tsnet mode registers with")
+	runCmd.Flags().String("tailscale-tsnet-state-dir", "", "Directory the tsnet node keeps its state in")
+	runCmd.Flags().String("tailscale-local-socket", "",
+		"Socket of the local tailscaled read in local mode (default: the platform's socket)")
+	runCmd.Flags().Bool("self-only", false,
+		"Publish only this machine, read from its tailscaled, e.g. as a sidecar on every node (implies local mode)")
 	runCmd.Flags().String("tailscale-api-key", "", "Tailscale API key")
 	runCmd.Flags().String("tailscale-client-id", "", "Tailscale OAuth client ID")
 	runCmd.Flags().String("tailscale-client-secret", "", "Tailscale OAuth client secret")
 	runCmd.Flags().String("tailscale-tailnet", "", "Tailscale tailnet name")
 	runCmd.Flags().Duration("tailscale-poll-interval", defaultPollInterval, "Tailscale polling interval")
+	runCmd.Flags().String("tailscale-online-heuristic", config.OnlineHeuristicLastSeen,
+		"How devices are determined to be online (last_seen, connectivity or authorized)")
+	runCmd.Flags().Duration("tailscale-online-threshold", config.DefaultOnlineThreshold,
+		"How recently a device must have been seen to count as online")
+	runCmd.Flags().Int("tailscale-online-polls", 1, "Consecutive polls a device must be online for to be published")
+	runCmd.Flags().Int("tailscale-offline-polls", 1,
+		"Consecutive polls a device must be offline for before its records are withdrawn")
+	runCmd.Flags().Bool("tailscale-device-attributes", false,
+		"Honor per-device DNS preferences stored in custom posture attributes")
+	runCmd.Flags().StringSlice("tailscale-include-hostnames", nil,
+		"Only publish devices whose hostname matches one of these regular expressions")
+	runCmd.Flags().StringSlice("tailscale-exclude-hostnames", nil,
+		"Never publish devices whose hostname matches one of these regular expressions")
+	runCmd.Flags().String("tailscale-ipv4-addresses", config.AddressSelectionFirst,
+		"IPv4 addresses published for devices reporting several (first, all, tailscale or subnet)")
+	runCmd.Flags().StringSlice("tailscale-ipv4-subnets", nil,
+		"Preferred subnets, in order, of the subnet IPv4 address selection")
+	runCmd.Flags().String("tailscale-ipv6-addresses", config.AddressSelectionFirst,
+		"IPv6 addresses published for devices reporting several (first, all, tailscale or subnet)")
+	runCmd.Flags().StringSlice("tailscale-ipv6-subnets", nil,
+		"Preferred subnets, in order, of the subnet IPv6 address selection")
+	runCmd.Flags().StringSlice("tailscale-address-ranges", []string{config.AddressRangesTailscale},
+		"CIDRs device addresses are accepted from, tailscale for the Tailscale ranges or any for every address")
+	runCmd.Flags().String("tailscale-cache-file", "",
+		"File keeping the machines of the last successful poll, used when Tailscale is unavailable at startup")
 
 	runCmd.Flags().String("bind-server", "", "Bind DNS server address")
 	runCmd.Flags().Int("bind-port", defaultBindPort, "Bind DNS server port")
+	runCmd.Flags().StringSlice("bind-servers", nil, "Fallback DNS servers tried in order when the server is unreachable")
+	runCmd.Flags().Bool("bind-update-all-servers", false, "Send updates to the server and every fallback server")
+	runCmd.Flags().String("bind-queue-file", "", "File keeping records of failed updates until they were published")
+	runCmd.Flags().String("bind-state-file", "", "File recording every published record across restarts")
+	runCmd.Flags().String("bind-name-template", "", "Go template rendering the record name of a machine")
+	runCmd.Flags().String("bind-name-collisions", config.NameCollisionSuffix,
+		"What happens when devices get the same record name (suffix, id, skip or fail)")
+	runCmd.Flags().Bool("bind-user-subdomains", false, "Publish machines under a subdomain per owner")
+	runCmd.Flags().Bool("bind-publish-metadata", false, "Publish a TXT record with the metadata of every machine")
+	runCmd.Flags().String("bind-metadata-template", config.DefaultMetadataTemplate,
+		"Go template rendering the metadata TXT record of a machine")
 	runCmd.Flags().String("bind-zone", "", "DNS zone to update")
 	runCmd.Flags().String("bind-key-name", "", "TSIG key name")
 	runCmd.Flags().String("bind-key-secret", "", "TSIG key secret")
+	runCmd.Flags().String("bind-key-command", "", "Command computing the TSIG MACs instead of bind-key-secret")
+	runCmd.Flags().String("bind-auth", config.AuthTSIG, "How updates are authenticated (tsig or gss-tsig)")
+	runCmd.Flags().String("bind-gss-principal", "", "Kerberos principal (user@REALM) GSS-TSIG logs in as")
+	runCmd.Flags().String("bind-gss-keytab", "", "Keytab holding the keys of bind-gss-principal")
+	runCmd.Flags().Bool("bind-active-directory", false, "Update Active Directory integrated DNS")
 	runCmd.Flags().String("bind-algorithm", "hmac-sha256", "TSIG algorithm")
 	runCmd.Flags().Duration("bind-ttl", defaultTTL, "DNS record TTL")
+	runCmd.Flags().Duration("bind-min-ttl", 0, "Lowest TTL tags and device owners may request, 0 for no bound")
+	runCmd.Flags().Duration("bind-max-ttl", 0, "Highest TTL tags and device owners may request, 0 for no bound")
 	runCmd.Flags().Duration("bind-update-interval", defaultUpdateInterval, "DNS update interval")
+	runCmd.Flags().Bool("bind-verify-serial", false, "Verify that the zone SOA serial advances after record changes")
+	runCmd.Flags().String("bind-statistics-url", "", "BIND statistics channel URL used to detect journal errors")
+	runCmd.Flags().StringSlice("bind-verify-resolvers", nil, "Recursive resolvers used by consistency checks")
+	runCmd.Flags().Bool("bind-remove-stale", true, "Remove records for machines that went offline or left the tailnet")
+	runCmd.Flags().Bool("bind-remove-on-shutdown", false, "Remove every record this tool published when it shuts down")
+	runCmd.Flags().Duration("bind-max-record-age", 0,
+		"Withdraw records not refreshed for this long, even without bind-remove-stale (0 to disable)")
+	runCmd.Flags().Int("bind-lease-intervals", 0,
+		"Withdraw records not refreshed by a poll within this many poll intervals (0 to disable)")
+	runCmd.Flags().String("bind-offline-policy", config.OfflinePolicyDelete,
+		"What happens to the records of a device that goes offline (delete, keep or grace)")
+	runCmd.Flags().Duration("bind-offline-grace-period", 0,
+		"How long a device may be offline before its records are withdrawn with bind-offline-policy grace")
+	runCmd.Flags().Duration("bind-quarantine", 0,
+		"Keep withdrawn names published with a low TTL and a TXT tombstone for this long before deleting them")
+	runCmd.Flags().String("bind-transport", config.TransportUDP,
+		"Transport used to reach the DNS server (udp, tcp or tcp-tls)")
+	runCmd.Flags().Bool("bind-query-before-update", false,
+		"Skip sending records the server already holds with the desired value and TTL")
+	runCmd.Flags().Duration("bind-ttl-tolerance", 0,
+		"How far the TTL of a record on the server may differ from the desired TTL with query-before-update")
+	runCmd.Flags().Bool("bind-strict-rrset-removal", false,
+		"Only delete the individual records this tool published, never whole record sets")
+	runCmd.Flags().Bool("bind-use-prerequisites", false,
+		"Make updates conditional on the record sets still holding what this tool last published")
+	runCmd.Flags().Bool("bind-zone-transfer", false,
+		"Read zones with TSIG-signed AXFR/IXFR transfers instead of looking names up one by one")
+	runCmd.Flags().StringSlice("bind-update-policy", nil,
+		"Grant and deny rules of the server's update-policy, records they don't allow are skipped")
+	runCmd.Flags().String("bind-owner-id", "",
+		"Owner ID published in TXT records next to managed names, enables the ownership registry")
+	runCmd.Flags().String("bind-zone-order", config.ZoneOrderName,
+		"Order zones are updated in (name, forward_first or reverse_first)")
+	runCmd.Flags().Int("bind-zone-concurrency", 1, "How many zones of the same group are updated at once")
+	runCmd.Flags().String("bind-tls-ca-file", "", "CA bundle used to verify the DNS server with tcp-tls")
+	runCmd.Flags().String("bind-tls-cert-file", "", "Client certificate presented to the DNS server with tcp-tls")
+	runCmd.Flags().String("bind-tls-key-file", "", "Private key of the client certificate")
+	runCmd.Flags().String("bind-tls-server-name", "",
+		"Server name sent and verified with tcp-tls (default: the server address)")
 
+	runCmd.Flags().Bool("ipv6-enabled", true, "Publish AAAA and IPv6 PTR records and send to IPv6 servers")
 	runCmd.Flags().Bool("dry-run", false, "Run in dry-run mode (don't actually update DNS)")
+	runCmd.Flags().String("provider", config.ProviderBind, "DNS provider to publish records to")
+	runCmd.Flags().String("status-address", "", "Address to serve daemon status on (host:port or unix:/path)")
+	runCmd.Flags().String("grpc-address", "", "Address to serve the gRPC admin API on (host:port or unix:/path)")
+
+	runCmd.Flags().String("powerdns-api-url", "", "Base URL of the PowerDNS API, e.g. http://127.0.0.1:8081")
+	runCmd.Flags().String("powerdns-api-key", "", "PowerDNS API key")
+	runCmd.Flags().String("powerdns-server-id", config.DefaultPowerDNSServerID, "PowerDNS server ID")
+	runCmd.Flags().Bool("powerdns-adopt-unmarked", false,
+		"Take over PowerDNS record sets holding desired names without the ownership comment")
+
+	runCmd.Flags().String("zonefile-path", "", "Zone file to write, {zone} is replaced by the zone name")
+	runCmd.Flags().Bool("zonefile-full-zone", false, "Write complete zones with SOA and NS records instead of fragments")
+	runCmd.Flags().StringSlice("zonefile-nameservers", nil, "Name servers of full zones, the first is the primary")
+	runCmd.Flags().String("zonefile-reload-command", "", "Shell command run after zone files changed")
+
+	runCmd.Flags().String("route53-profile", "", "AWS shared configuration profile of the Route53 credentials")
+	runCmd.Flags().String("route53-role-arn", "", "AWS role assumed to update Route53")
+	runCmd.Flags().Int("route53-batch-size", config.DefaultRoute53BatchSize, "Changes sent per Route53 request")
+
+	runCmd.Flags().String("forwarder-address", "", "Address to answer DNS queries for the managed zones on (host:port)")
+	runCmd.Flags().StringSlice("forwarder-upstreams", nil, "DNS servers the forwarder sends other queries to, in order")
+
+	runCmd.Flags().String("webhook-address", "", "Address to receive Tailscale webhook events on (host:port)")
 
 	// Bind flags to viper
 	bindRunFlagsToViper()
@@ -80,6 +195,22 @@ func init() {
 // bindRunFlagsToViper binds run command flags to viper configuration
 func bindRunFlagsToViper() {
 	// Tailscale flags
+	if err := viper.BindPFlag("tailscale.mode", runCmd.Flags().Lookup("tailscale-mode")); err != nil {
+		klog.Errorf("Failed to bind tailscale-mode flag: %v", err)
+	}
+	if err := viper.BindPFlag("tailscale.tsnet.hostname", runCmd.Flags().Lookup("tailscale-tsnet-hostname")); err != nil {
+		klog.Errorf("Failed to bind tailscale-tsnet-hostname flag: %v", err)
+	}
+	tsnetStateDirFlag := runCmd.Flags().Lookup("tailscale-tsnet-state-dir")
+	if err := viper.BindPFlag("tailscale.tsnet.state_dir", tsnetStateDirFlag); err != nil {
+		klog.Errorf("Failed to bind tailscale-tsnet-state-dir flag: %v", err)
+	}
+	if err := viper.BindPFlag("tailscale.local.socket", runCmd.Flags().Lookup("tailscale-local-socket")); err != nil {
+		klog.Errorf("Failed to bind tailscale-local-socket flag: %v", err)
+	}
+	if err := viper.BindPFlag("tailscale.local.self_only", runCmd.Flags().Lookup("self-only")); err != nil {
+		klog.Errorf("Failed to bind self-only flag: %v", err)
+	}
 	if err := viper.BindPFlag("tailscale.api_key", runCmd.Flags().Lookup("tailscale-api-key")); err != nil {
 		klog.Errorf("Failed to bind tailscale-api-key flag: %v", err)
 	}
@@ -95,6 +226,50 @@ func bindRunFlagsToViper() {
 	if err := viper.BindPFlag("tailscale.poll_interval", runCmd.Flags().Lookup("tailscale-poll-interval")); err != nil {
 		klog.Errorf("Failed to bind tailscale-poll-interval flag: %v", err)
 	}
+	onlineHeuristicFlag := runCmd.Flags().Lookup("tailscale-online-heuristic")
+	if err := viper.BindPFlag("tailscale.online_heuristic", onlineHeuristicFlag); err != nil {
+		klog.Errorf("Failed to bind tailscale-online-heuristic flag: %v", err)
+	}
+	onlineThresholdFlag := runCmd.Flags().Lookup("tailscale-online-threshold")
+	if err := viper.BindPFlag("tailscale.online_threshold", onlineThresholdFlag); err != nil {
+		klog.Errorf("Failed to bind tailscale-online-threshold flag: %v", err)
+	}
+	if err := viper.BindPFlag("tailscale.online_polls", runCmd.Flags().Lookup("tailscale-online-polls")); err != nil {
+		klog.Errorf("Failed to bind tailscale-online-polls flag: %v", err)
+	}
+	if err := viper.BindPFlag("tailscale.offline_polls", runCmd.Flags().Lookup("tailscale-offline-polls")); err != nil {
+		klog.Errorf("Failed to bind tailscale-offline-polls flag: %v", err)
+	}
+	deviceAttributesFlag := runCmd.Flags().Lookup("tailscale-device-attributes")
+	if err := viper.BindPFlag("tailscale.device_attributes", deviceAttributesFlag); err != nil {
+		klog.Errorf("Failed to bind tailscale-device-attributes flag: %v", err)
+	}
+	includeHostnamesFlag := runCmd.Flags().Lookup("tailscale-include-hostnames")
+	if err := viper.BindPFlag("tailscale.include_hostnames", includeHostnamesFlag); err != nil {
+		klog.Errorf("Failed to bind tailscale-include-hostnames flag: %v", err)
+	}
+	excludeHostnamesFlag := runCmd.Flags().Lookup("tailscale-exclude-hostnames")
+	if err := viper.BindPFlag("tailscale.exclude_hostnames", excludeHostnamesFlag); err != nil {
+		klog.Errorf("Failed to bind tailscale-exclude-hostnames flag: %v", err)
+	}
+	if err := viper.BindPFlag("tailscale.ipv4_addresses", runCmd.Flags().Lookup("tailscale-ipv4-addresses")); err != nil {
+		klog.Errorf("Failed to bind tailscale-ipv4-addresses flag: %v", err)
+	}
+	if err := viper.BindPFlag("tailscale.ipv4_subnets", runCmd.Flags().Lookup("tailscale-ipv4-subnets")); err != nil {
+		klog.Errorf("Failed to bind tailscale-ipv4-subnets flag: %v", err)
+	}
+	if err := viper.BindPFlag("tailscale.ipv6_addresses", runCmd.Flags().Lookup("tailscale-ipv6-addresses")); err != nil {
+		klog.Errorf("Failed to bind tailscale-ipv6-addresses flag: %v", err)
+	}
+	if err := viper.BindPFlag("tailscale.ipv6_subnets", runCmd.Flags().Lookup("tailscale-ipv6-subnets")); err != nil {
+		klog.Errorf("Failed to bind tailscale-ipv6-subnets flag: %v", err)
+	}
+	if err := viper.BindPFlag("tailscale.address_ranges", runCmd.Flags().Lookup("tailscale-address-ranges")); err != nil {
+		klog.Errorf("Failed to bind tailscale-address-ranges flag: %v", err)
+	}
+	if err := viper.BindPFlag("tailscale.cache_file", runCmd.Flags().Lookup("tailscale-cache-file")); err != nil {
+		klog.Errorf("Failed to bind tailscale-cache-file flag: %v", err)
+	}
 
 	// Bind flags
 	if err := viper.BindPFlag("bind.server", runCmd.Flags().Lookup("bind-server")); err != nil {
@@ -112,18 +287,199 @@ func bindRunFlagsToViper() {
 	if err := viper.BindPFlag("bind.key_secret", runCmd.Flags().Lookup("bind-key-secret")); err != nil {
 		klog.Errorf("Failed to bind bind-key-secret flag: %v", err)
 	}
+	if err := viper.BindPFlag("bind.key_command", runCmd.Flags().Lookup("bind-key-command")); err != nil {
+		klog.Errorf("Failed to bind bind-key-command flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.auth", runCmd.Flags().Lookup("bind-auth")); err != nil {
+		klog.Errorf("Failed to bind bind-auth flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.gss.principal", runCmd.Flags().Lookup("bind-gss-principal")); err != nil {
+		klog.Errorf("Failed to bind bind-gss-principal flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.gss.keytab", runCmd.Flags().Lookup("bind-gss-keytab")); err != nil {
+		klog.Errorf("Failed to bind bind-gss-keytab flag: %v", err)
+	}
+	activeDirectoryFlag := runCmd.Flags().Lookup("bind-active-directory")
+	if err := viper.BindPFlag("bind.active_directory.enabled", activeDirectoryFlag); err != nil {
+		klog.Errorf("Failed to bind bind-active-directory flag: %v", err)
+	}
 	if err := viper.BindPFlag("bind.algorithm", runCmd.Flags().Lookup("bind-algorithm")); err != nil {
 		klog.Errorf("Failed to bind bind-algorithm flag: %v", err)
 	}
 	if err := viper.BindPFlag("bind.ttl", runCmd.Flags().Lookup("bind-ttl")); err != nil {
 		klog.Errorf("Failed to bind bind-ttl flag: %v", err)
 	}
+	if err := viper.BindPFlag("bind.min_ttl", runCmd.Flags().Lookup("bind-min-ttl")); err != nil {
+		klog.Errorf("Failed to bind bind-min-ttl flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.max_ttl", runCmd.Flags().Lookup("bind-max-ttl")); err != nil {
+		klog.Errorf("Failed to bind bind-max-ttl flag: %v", err)
+	}
 	if err := viper.BindPFlag("bind.update_interval", runCmd.Flags().Lookup("bind-update-interval")); err != nil {
 		klog.Errorf("Failed to bind bind-update-interval flag: %v", err)
 	}
+	if err := viper.BindPFlag("bind.verify_serial", runCmd.Flags().Lookup("bind-verify-serial")); err != nil {
+		klog.Errorf("Failed to bind bind-verify-serial flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.statistics_url", runCmd.Flags().Lookup("bind-statistics-url")); err != nil {
+		klog.Errorf("Failed to bind bind-statistics-url flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.verify_resolvers", runCmd.Flags().Lookup("bind-verify-resolvers")); err != nil {
+		klog.Errorf("Failed to bind bind-verify-resolvers flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.remove_stale", runCmd.Flags().Lookup("bind-remove-stale")); err != nil {
+		klog.Errorf("Failed to bind bind-remove-stale flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.remove_on_shutdown", runCmd.Flags().Lookup("bind-remove-on-shutdown")); err != nil {
+		klog.Errorf("Failed to bind bind-remove-on-shutdown flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.max_record_age", runCmd.Flags().Lookup("bind-max-record-age")); err != nil {
+		klog.Errorf("Failed to bind bind-max-record-age flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.lease_intervals", runCmd.Flags().Lookup("bind-lease-intervals")); err != nil {
+		klog.Errorf("Failed to bind bind-lease-intervals flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.offline_policy", runCmd.Flags().Lookup("bind-offline-policy")); err != nil {
+		klog.Errorf("Failed to bind bind-offline-policy flag: %v", err)
+	}
+	offlineGracePeriodFlag := runCmd.Flags().Lookup("bind-offline-grace-period")
+	if err := viper.BindPFlag("bind.offline_grace_period", offlineGracePeriodFlag); err != nil {
+		klog.Errorf("Failed to bind bind-offline-grace-period flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.quarantine", runCmd.Flags().Lookup("bind-quarantine")); err != nil {
+		klog.Errorf("Failed to bind bind-quarantine flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.transport", runCmd.Flags().Lookup("bind-transport")); err != nil {
+		klog.Errorf("Failed to bind bind-transport flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.servers", runCmd.Flags().Lookup("bind-servers")); err != nil {
+		klog.Errorf("Failed to bind bind-servers flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.update_all_servers", runCmd.Flags().Lookup("bind-update-all-servers")); err != nil {
+		klog.Errorf("Failed to bind bind-update-all-servers flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.name_template", runCmd.Flags().Lookup("bind-name-template")); err != nil {
+		klog.Errorf("Failed to bind bind-name-template flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.name_collisions", runCmd.Flags().Lookup("bind-name-collisions")); err != nil {
+		klog.Errorf("Failed to bind bind-name-collisions flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.user_subdomains.enabled", runCmd.Flags().Lookup("bind-user-subdomains")); err != nil {
+		klog.Errorf("Failed to bind bind-user-subdomains flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.publish_metadata", runCmd.Flags().Lookup("bind-publish-metadata")); err != nil {
+		klog.Errorf("Failed to bind bind-publish-metadata flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.metadata_template", runCmd.Flags().Lookup("bind-metadata-template")); err != nil {
+		klog.Errorf("Failed to bind bind-metadata-template flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.queue_file", runCmd.Flags().Lookup("bind-queue-file")); err != nil {
+		klog.Errorf("Failed to bind bind-queue-file flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.state_file", runCmd.Flags().Lookup("bind-state-file")); err != nil {
+		klog.Errorf("Failed to bind bind-state-file flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.query_before_update", runCmd.Flags().Lookup("bind-query-before-update")); err != nil {
+		klog.Errorf("Failed to bind bind-query-before-update flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.ttl_tolerance", runCmd.Flags().Lookup("bind-ttl-tolerance")); err != nil {
+		klog.Errorf("Failed to bind bind-ttl-tolerance flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.strict_rrset_removal",
+		runCmd.Flags().Lookup("bind-strict-rrset-removal")); err != nil {
+		klog.Errorf("Failed to bind bind-strict-rrset-removal flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.use_prerequisites", runCmd.Flags().Lookup("bind-use-prerequisites")); err != nil {
+		klog.Errorf("Failed to bind bind-use-prerequisites flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.zone_transfer", runCmd.Flags().Lookup("bind-zone-transfer")); err != nil {
+		klog.Errorf("Failed to bind bind-zone-transfer flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.update_policy", runCmd.Flags().Lookup("bind-update-policy")); err != nil {
+		klog.Errorf("Failed to bind bind-update-policy flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.owner_id", runCmd.Flags().Lookup("bind-owner-id")); err != nil {
+		klog.Errorf("Failed to bind bind-owner-id flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.zone_order", runCmd.Flags().Lookup("bind-zone-order")); err != nil {
+		klog.Errorf("Failed to bind bind-zone-order flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.zone_concurrency", runCmd.Flags().Lookup("bind-zone-concurrency")); err != nil {
+		klog.Errorf("Failed to bind bind-zone-concurrency flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.tls.ca_file", runCmd.Flags().Lookup("bind-tls-ca-file")); err != nil {
+		klog.Errorf("Failed to bind bind-tls-ca-file flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.tls.cert_file", runCmd.Flags().Lookup("bind-tls-cert-file")); err != nil {
+		klog.Errorf("Failed to bind bind-tls-cert-file flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.tls.key_file", runCmd.Flags().Lookup("bind-tls-key-file")); err != nil {
+		klog.Errorf("Failed to bind bind-tls-key-file flag: %v", err)
+	}
+	if err := viper.BindPFlag("bind.tls.server_name", runCmd.Flags().Lookup("bind-tls-server-name")); err != nil {
+		klog.Errorf("Failed to bind bind-tls-server-name flag: %v", err)
+	}
+
+	if err := viper.BindPFlag("ipv6.enabled", runCmd.Flags().Lookup("ipv6-enabled")); err != nil {
+		klog.Errorf("Failed to bind ipv6-enabled flag: %v", err)
+	}
 
 	// General flags
 	if err := viper.BindPFlag("general.dry_run", runCmd.Flags().Lookup("dry-run")); err != nil {
 		klog.Errorf("Failed to bind dry-run flag: %v", err)
 	}
+	if err := viper.BindPFlag("general.provider", runCmd.Flags().Lookup("provider")); err != nil {
+		klog.Errorf("Failed to bind provider flag: %v", err)
+	}
+	if err := viper.BindPFlag("providers.powerdns.api_url", runCmd.Flags().Lookup("powerdns-api-url")); err != nil {
+		klog.Errorf("Failed to bind powerdns-api-url flag: %v", err)
+	}
+	if err := viper.BindPFlag("providers.powerdns.api_key", runCmd.Flags().Lookup("powerdns-api-key")); err != nil {
+		klog.Errorf("Failed to bind powerdns-api-key flag: %v", err)
+	}
+	if err := viper.BindPFlag("providers.powerdns.server_id", runCmd.Flags().Lookup("powerdns-server-id")); err != nil {
+		klog.Errorf("Failed to bind powerdns-server-id flag: %v", err)
+	}
+	adoptUnmarkedFlag := runCmd.Flags().Lookup("powerdns-adopt-unmarked")
+	if err := viper.BindPFlag("providers.powerdns.adopt_unmarked", adoptUnmarkedFlag); err != nil {
+		klog.Errorf("Failed to bind powerdns-adopt-unmarked flag: %v", err)
+	}
+	if err := viper.BindPFlag("providers.zonefile.path", runCmd.Flags().Lookup("zonefile-path")); err != nil {
+		klog.Errorf("Failed to bind zonefile-path flag: %v", err)
+	}
+	if err := viper.BindPFlag("providers.zonefile.full_zone", runCmd.Flags().Lookup("zonefile-full-zone")); err != nil {
+		klog.Errorf("Failed to bind zonefile-full-zone flag: %v", err)
+	}
+	if err := viper.BindPFlag("providers.zonefile.nameservers",
+		runCmd.Flags().Lookup("zonefile-nameservers")); err != nil {
+		klog.Errorf("Failed to bind zonefile-nameservers flag: %v", err)
+	}
+	if err := viper.BindPFlag("providers.zonefile.reload_command",
+		runCmd.Flags().Lookup("zonefile-reload-command")); err != nil {
+		klog.Errorf("Failed to bind zonefile-reload-command flag: %v", err)
+	}
+	if err := viper.BindPFlag("providers.route53.profile", runCmd.Flags().Lookup("route53-profile")); err != nil {
+		klog.Errorf("Failed to bind route53-profile flag: %v", err)
+	}
+	if err := viper.BindPFlag("providers.route53.role_arn", runCmd.Flags().Lookup("route53-role-arn")); err != nil {
+		klog.Errorf("Failed to bind route53-role-arn flag: %v", err)
+	}
+	if err := viper.BindPFlag("providers.route53.batch_size", runCmd.Flags().Lookup("route53-batch-size")); err != nil {
+		klog.Errorf("Failed to bind route53-batch-size flag: %v", err)
+	}
+	if err := viper.BindPFlag("general.status_address", runCmd.Flags().Lookup("status-address")); err != nil {
+		klog.Errorf("Failed to bind status-address flag: %v", err)
+	}
+	if err := viper.BindPFlag("general.grpc_address", runCmd.Flags().Lookup("grpc-address")); err != nil {
+		klog.Errorf("Failed to bind grpc-address flag: %v", err)
+	}
+	if err := viper.BindPFlag("forwarder.address", runCmd.Flags().Lookup("forwarder-address")); err != nil {
+		klog.Errorf("Failed to bind forwarder-address flag: %v", err)
+	}
+	if err := viper.BindPFlag("forwarder.upstreams", runCmd.Flags().Lookup("forwarder-upstreams")); err != nil {
+		klog.Errorf("Failed to bind forwarder-upstreams flag: %v", err)
+	}
+	if err := viper.BindPFlag("webhook.address", runCmd.Flags().Lookup("webhook-address")); err != nil {
+		klog.Errorf("Failed to bind webhook-address flag: %v", err)
+	}
 }
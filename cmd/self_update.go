@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/update"
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+)
+
+var (
+	selfUpdateCheck          bool
+	selfUpdateForce          bool
+	selfUpdateRestartCommand string
+)
+
+// selfUpdateCmd represents the self-update command
+var selfUpdateCmd = &cobra.Command{
+	Use:   "self-update",
+	Short: "Update the binary to the latest release",
+	Long: `Download the latest release for this platform from GitHub, verify it against the checksums published with the
+release and replace the running binary with it. Meant for hosts without a package manager.
+
+The checksums must carry a valid signature of the key releases are signed with, which release builds embed. Releases
+without a signature are refused, and builds without the key, such as development builds, can't update themselves.
+
+The running daemon keeps using the old binary until it is restarted, pass --restart-command to restart it once the
+binary was replaced, e.g. --restart-command "systemctl restart tailscale-bind-ddns".`,
+	Annotations: map[string]string{skipConfigValidation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Set up logging
+		if err := setupLogging(); err != nil {
+			return fmt.Errorf("setting up logging: %w", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), selfUpdateTimeout)
+		defer cancel()
+
+		updater := update.NewUpdater()
+		release, err := updater.Latest(ctx)
+		if err != nil {
+			return err
+		}
+
+		if !update.Newer(release.TagName, buildVersion) && !selfUpdateForce {
+			klog.Infof("✓ Already up to date (running %s, latest release %s)", buildVersion, release.TagName)
+			return nil
+		}
+		if selfUpdateCheck {
+			klog.Infof("Release %s is available (running %s)", release.TagName, buildVersion)
+			return nil
+		}
+
+		path, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("locating running binary: %w", err)
+		}
+		if path, err = filepath.EvalSymlinks(path); err != nil {
+			return fmt.Errorf("locating running binary: %w", err)
+		}
+
+		klog.Infof("Downloading %s for %s/%s...", release.TagName, updater.OS, updater.Arch)
+		binary, err := updater.Download(ctx, release)
+		if err != nil {
+			return fmt.Errorf("downloading release %s: %w", release.TagName, err)
+		}
+		klog.Info("✓ Signature and checksum verified")
+
+		if err := update.Replace(path, binary); err != nil {
+			return err
+		}
+		klog.Infof("✓ Replaced %s with release %s", path, release.TagName)
+
+		if selfUpdateRestartCommand == "" {
+			klog.Info("Restart the daemon to run the new release")
+			return nil
+		}
+		klog.Infof("Running restart command: %s", selfUpdateRestartCommand)
+		if err := restartCommand(ctx, selfUpdateRestartCommand).Run(); err != nil {
+			return fmt.Errorf("running restart command: %w", err)
+		}
+		return nil
+	},
+}
+
+// restartCommand builds the shell command run after the binary was replaced
+func restartCommand(ctx context.Context, command string) *exec.Cmd {
+	var restart *exec.Cmd
+	if runtime.GOOS == "windows" {
+		restart = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		restart = exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	}
+	restart.Stdout = os.Stdout
+	restart.Stderr = os.Stderr
+	return restart
+}
+
+//nolint:gochecknoinits // This is a command line tool
+func init() {
+	selfUpdateCmd.Flags().BoolVar(&selfUpdateCheck, "check", false,
+		"Only report whether a newer release is available")
+	selfUpdateCmd.Flags().BoolVar(&selfUpdateForce, "force", false,
+		"Install the latest release even if it isn't newer than the running version")
+	selfUpdateCmd.Flags().StringVar(&selfUpdateRestartCommand, "restart-command", "",
+		"Shell command run after the binary was replaced, e.g. \"systemctl restart tailscale-bind-ddns\"")
+}
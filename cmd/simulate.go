@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/app"
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+)
+
+var simulateSnapshots string
+
+// simulateCmd represents the simulate command
+var simulateCmd = &cobra.Command{
+	Use:   "simulate",
+	Short: "Replay recorded device snapshots and show the DNS changes they would produce",
+	Long: `Replay a recorded sequence of device snapshots through the record pipeline in dry-run and print the DNS changes
+each step would produce. Snapshots go through the same stages as live polls, timed by their "time": online_polls and
+offline_polls, bind.offline_policy, bind.max_record_age, bind.name_collisions and bind.quarantine. No connection is
+made to Tailscale or the DNS server, so this can be used to validate configuration changes before enabling them live.
+
+The snapshot file is a JSON array of objects with a "time" and a list of "machines", for example:
+
+  [{"time": "2025-01-01T00:00:00Z",
+    "machines": [{"id": "1", "name": "laptop", "ipv4_address": "100.64.0.1", "online": true}]}]`,
+	Annotations: map[string]string{skipConfigValidation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Set up logging
+		if err := setupLogging(); err != nil {
+			return fmt.Errorf("setting up logging: %w", err)
+		}
+
+		snapshots, err := app.LoadSnapshots(simulateSnapshots)
+		if err != nil {
+			return err
+		}
+
+		application, err := app.NewApp(cfg)
+		if err != nil {
+			return fmt.Errorf("creating application: %w", err)
+		}
+
+		steps, err := application.Simulate(snapshots)
+		if err != nil {
+			return fmt.Errorf("simulating: %w", err)
+		}
+		for i, step := range steps {
+			fmt.Printf("Step %d (%s): %d machines, %d records\n",
+				i+1, step.Snapshot.Time.Format("2006-01-02T15:04:05Z07:00"), len(step.Snapshot.Machines), len(step.Records))
+			if step.Error != nil {
+				fmt.Printf("  not published: %v\n", step.Error)
+				continue
+			}
+			if step.Diff.Empty() {
+				fmt.Println("  no changes")
+				continue
+			}
+			printRecordChanges("+", step.Diff.Added)
+			printRecordChanges("~", step.Diff.Changed)
+			printRecordChanges("-", step.Diff.Removed)
+		}
+
+		return nil
+	},
+}
+
+// printRecordChanges prints records prefixed with a change marker
+func printRecordChanges(marker string, records []bind.DNSRecord) {
+	for _, record := range records {
+		fmt.Printf("  %s %s\n", marker, formatRecord(record))
+	}
+}
+
+// formatRecord renders a record as a single human readable line using fully qualified names
+func formatRecord(record bind.DNSRecord) string {
+	name := record.Name
+	if record.Type != bind.TypePTR {
+		zone := cfg.Bind.Zone
+		if record.Zone != "" {
+			zone = record.Zone
+		}
+		name = record.Name + "." + zone
+	}
+	return fmt.Sprintf("%s %s -> %s (TTL: %d)", record.Type, name, record.Value, record.TTL)
+}
+
+//nolint:gochecknoinits // This is a command line tool
+func init() {
+	simulateCmd.Flags().StringVar(&simulateSnapshots, "snapshots", "",
+		"JSON file containing the recorded device snapshots")
+	if err := simulateCmd.MarkFlagRequired("snapshots"); err != nil {
+		klog.Errorf("Failed to mark snapshots flag required: %v", err)
+	}
+}
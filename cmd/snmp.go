@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/app"
+	"github.com/aauren/tailscale-bind-ddns/pkg/snmp"
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+)
+
+// snmpCacheTTL is how long the gauges of the daemon are reused, so that a walk of the subtree queries it only once
+const snmpCacheTTL = time.Second
+
+var (
+	snmpAddress string
+	snmpBaseOID string
+)
+
+// snmpPassPersistCmd represents the snmp-pass-persist command
+var snmpPassPersistCmd = &cobra.Command{
+	Use:   "snmp-pass-persist",
+	Short: "Publish the gauges of a running daemon over SNMP through snmpd",
+	Long: `Answer the requests of net-snmp's snmpd for the gauges of a running daemon over the pass_persist protocol on
+stdin and stdout. Add it to snmpd.conf with
+
+  pass_persist .1.3.6.1.4.1.8072.9999.9999.1 /usr/local/bin/tailscale-bind-ddns snmp-pass-persist
+
+The gauges are queried from the daemon via its status address, the variables are missing while it can't be reached.
+See the README for the OIDs of the gauges.`,
+	Annotations: map[string]string{skipConfigValidation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Set up logging, which goes to stderr so that it doesn't mix with the answers
+		if err := setupLogging(); err != nil {
+			return fmt.Errorf("setting up logging: %w", err)
+		}
+		if err := snmp.ValidateOID(snmpBaseOID); err != nil {
+			return fmt.Errorf("invalid --base-oid: %w", err)
+		}
+
+		address := snmpAddress
+		if address == "" {
+			address = cfg.General.StatusAddress
+		}
+		if address == "" {
+			return fmt.Errorf("a status address is required (--address or general.status_address)")
+		}
+
+		var (
+			cached  []snmp.Variable
+			fetched time.Time
+		)
+		source := func() ([]snmp.Variable, error) {
+			if cached != nil && time.Since(fetched) < snmpCacheTTL {
+				return cached, nil
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+			defer cancel()
+
+			gauges, err := app.FetchGauges(ctx, address)
+			if err != nil {
+				klog.Errorf("Failed to fetch gauges: %v", err)
+				return nil, err
+			}
+			cached, fetched = gauges.SNMPVariables(snmpBaseOID), time.Now()
+			return cached, nil
+		}
+
+		return snmp.PassPersist(os.Stdin, os.Stdout, source)
+	},
+}
+
+//nolint:gochecknoinits // This is a command line tool
+func init() {
+	snmpPassPersistCmd.Flags().StringVar(&snmpAddress, "address", "",
+		"Status address of the running daemon (host:port or unix:/path), defaults to general.status_address")
+	snmpPassPersistCmd.Flags().StringVar(&snmpBaseOID, "base-oid", ".1.3.6.1.4.1.8072.9999.9999.1",
+		"OID the gauges are published under, the one given to pass_persist in snmpd.conf")
+}
@@ -1,35 +1,77 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"slices"
 
 	"github.com/aauren/tailscale-bind-ddns/pkg/app"
 	"github.com/spf13/cobra"
 )
 
+var (
+	statusLive    bool
+	statusAddress string
+)
+
 // statusCmd represents the status command
 var statusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "Show application status",
-	Long:  `Show the current status and configuration of the application.`,
+	Long: `Show the current status and configuration of the application.
+
+By default only the configuration is shown, which works even when the configuration is incomplete (e.g. missing
+Tailscale credentials). With --live the status is queried from a running daemon via its status address.`,
+	Annotations: map[string]string{skipConfigValidation: "true"},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// Set up logging
 		if err := setupLogging(); err != nil {
 			return fmt.Errorf("setting up logging: %w", err)
 		}
 
-		// Create application to get status
-		application, err := app.NewApp(cfg)
-		if err != nil {
-			return fmt.Errorf("creating application: %w", err)
+		if !statusLive {
+			printStatus("Configured Status", app.ConfigStatus(cfg))
+			return nil
 		}
 
-		status := application.GetStatus()
-		fmt.Println("Application Status:")
-		for key, value := range status {
-			fmt.Printf("  %s: %v\n", key, value)
+		address := statusAddress
+		if address == "" {
+			address = cfg.General.StatusAddress
 		}
+		if address == "" {
+			return fmt.Errorf("--live requires a status address (--address or general.status_address)")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+
+		status, err := app.FetchStatus(ctx, address)
+		if err != nil {
+			return fmt.Errorf("fetching live status: %w", err)
+		}
+		printStatus("Application Status", status)
 
 		return nil
 	},
 }
+
+// printStatus prints a status map with its keys in a stable order
+func printStatus(title string, status map[string]interface{}) {
+	fmt.Printf("%s:\n", title)
+	keys := make([]string, 0, len(status))
+	for key := range status {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		fmt.Printf("  %s: %v\n", key, status[key])
+	}
+}
+
+//nolint:gochecknoinits // This is a command line tool
+func init() {
+	statusCmd.Flags().BoolVar(&statusLive, "live", false,
+		"Query the status of a running daemon instead of the configuration")
+	statusCmd.Flags().StringVar(&statusAddress, "address", "",
+		"Status address of the running daemon (host:port or unix:/path), defaults to general.status_address")
+}
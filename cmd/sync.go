@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/signal"
+	"syscall"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/app"
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+)
+
+// Exit codes of a single sync, besides 0 for success and 1 for any other error such as an invalid configuration
+const (
+	exitPollFailed    = 2
+	exitPublishFailed = 3
+)
+
+var (
+	syncOnce   bool
+	syncDryRun bool
+)
+
+// syncCmd represents the sync command
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Sync the Tailscale machines to DNS, once with --once",
+	Long: `Sync the tailnet's machines to DNS. With --once Tailscale is polled a single time, the records are published
+to the DNS provider and the targets and the command exits, for cron jobs and systemd timers that don't keep a daemon
+running. The records go through the same filters, naming rules, PTR records and plugins as those of the run command.
+Without --once the command runs continuously like run.
+
+Stale records are only removed when the provider knows what it published in earlier runs, with bind.state_file for
+BIND. Settings come from the configuration file and the environment.
+
+Exit codes of --once:
+  0  the records were published, or were already up to date
+  1  any other error, e.g. an invalid configuration
+  2  Tailscale couldn't be polled, nothing was published
+  3  the records couldn't be published to the DNS provider or a target`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Set up logging
+		if err := setupLogging(); err != nil {
+			return fmt.Errorf("setting up logging: %w", err)
+		}
+
+		if syncDryRun {
+			cfg.General.DryRun = true
+		}
+		application, err := app.NewApp(cfg)
+		if err != nil {
+			return fmt.Errorf("creating application: %w", err)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		if !syncOnce {
+			return application.Run(ctx)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, syncTimeout)
+		defer cancel()
+
+		report, err := application.Sync(ctx)
+		switch {
+		case errors.Is(err, app.ErrPollFailed):
+			return &exitError{code: exitPollFailed, err: err}
+		case errors.Is(err, app.ErrPublishFailed):
+			return &exitError{code: exitPublishFailed, err: err}
+		case err != nil:
+			return fmt.Errorf("syncing records: %w", err)
+		}
+
+		if report.Result != nil && report.Result.DryRun {
+			klog.Infof("DRY RUN: %d records of %d online machines were not sent", report.Records, report.Machines)
+			return nil
+		}
+		sent, removed := 0, 0
+		if report.Result != nil {
+			for _, zone := range report.Result.Zones {
+				sent += zone.Sent
+				removed += zone.Removed
+			}
+		}
+		klog.Infof("✓ Synced %d records of %d online machines: %d sent, %d removed", report.Records, report.Machines,
+			sent, removed)
+		return nil
+	},
+}
+
+// exitError is an error that exits the process with a specific code
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// exitCode returns the code the process exits with after err
+func exitCode(err error) int {
+	var exit *exitError
+	if errors.As(err, &exit) {
+		return exit.code
+	}
+	return 1
+}
+
+//nolint:gochecknoinits // This is a command line tool
+func init() {
+	syncCmd.Flags().BoolVar(&syncOnce, "once", false,
+		"Poll Tailscale and publish the records a single time, then exit")
+	syncCmd.Flags().BoolVar(&syncDryRun, "dry-run", false,
+		"Log the records that would be published without sending them")
+}
@@ -26,17 +26,11 @@ to verify configuration is correct.`,
 
 		// Test Tailscale connection
 		klog.Info("Testing Tailscale connection...")
-		var tsClient *tailscale.Client
-		var err error
-
-		if cfg.Tailscale.APIKey != "" {
-			tsClient, err = tailscale.NewClient(cfg.Tailscale.APIKey, cfg.Tailscale.Tailnet)
-		} else {
-			tsClient, err = tailscale.NewOAuthClient(cfg.Tailscale.ClientID, cfg.Tailscale.ClientSecret, cfg.Tailscale.Tailnet)
-		}
+		tsClient, err := tailscale.NewClientFromConfig(&cfg.Tailscale)
 		if err != nil {
 			return fmt.Errorf("creating Tailscale client: %w", err)
 		}
+		defer tsClient.Close()
 
 		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
 		defer cancel()
@@ -49,16 +43,7 @@ to verify configuration is correct.`,
 
 		// Test Bind connection
 		klog.Info("Testing Bind connection...")
-		bindClient, err := bind.NewClient(
-			cfg.Bind.Server,
-			cfg.Bind.Port,
-			cfg.Bind.Zone,
-			cfg.Bind.KeyName,
-			cfg.Bind.KeySecret,
-			cfg.Bind.Algorithm,
-			cfg.Bind.TTL,
-			&cfg.Bind.PTR,
-		)
+		bindClient, err := bind.NewClientFromConfig(&cfg.Bind)
 		if err != nil {
 			return fmt.Errorf("creating Bind client: %w", err)
 		}
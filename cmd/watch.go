@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/app"
+	"github.com/aauren/tailscale-bind-ddns/pkg/web"
+	"github.com/spf13/cobra"
+)
+
+// clearScreen moves the cursor home and clears the terminal, so that every refresh replaces the previous one
+const clearScreen = "\x1b[H\x1b[2J"
+
+var (
+	watchAddress  string
+	watchInterval time.Duration
+)
+
+// watchCmd represents the watch command
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Show a live view of the machines, records and errors of a running daemon",
+	Long: `Show a continuously refreshing terminal view of a running daemon, queried via its status address: when the
+records were last synced, the records that aren't synced yet as pending changes, the machines with the names they're
+published under, every record with the outcome of its most recent update and the most recent errors. It's the
+terminal version of the status page and runs until interrupted.`,
+	Annotations: map[string]string{skipConfigValidation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Set up logging
+		if err := setupLogging(); err != nil {
+			return fmt.Errorf("setting up logging: %w", err)
+		}
+		if watchInterval <= 0 {
+			return fmt.Errorf("--interval must be positive")
+		}
+
+		address := watchAddress
+		if address == "" {
+			address = cfg.General.StatusAddress
+		}
+		if address == "" {
+			return fmt.Errorf("a status address is required (--address or general.status_address)")
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+
+		ticker := time.NewTicker(watchInterval)
+		defer ticker.Stop()
+
+		var last *web.Dashboard
+		for {
+			last = refreshWatch(ctx, address, last)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	},
+}
+
+// refreshWatch fetches the dashboard of the daemon and redraws the view, returning the dashboard shown. While the
+// daemon can't be reached the last dashboard is shown below the error.
+func refreshWatch(ctx context.Context, address string, last *web.Dashboard) *web.Dashboard {
+	fetchCtx, cancel := context.WithTimeout(ctx, watchInterval)
+	defer cancel()
+	dashboard, err := app.FetchDashboard(fetchCtx, address)
+	if ctx.Err() != nil {
+		return last
+	}
+
+	// Rendered into a buffer first, so that the screen is only cleared once the new view is ready
+	var view bytes.Buffer
+	view.WriteString(clearScreen)
+	fmt.Fprintf(&view, "tailscale-bind-ddns at %s, %s, refreshing every %v (Ctrl-C to quit)\n", address,
+		time.Now().Format(time.TimeOnly), watchInterval)
+	if err != nil {
+		fmt.Fprintf(&view, "! Can't reach the daemon: %v\n", err)
+		if last == nil {
+			_, _ = view.WriteTo(os.Stdout)
+			return nil
+		}
+		dashboard = last
+		fmt.Fprintf(&view, "! Showing the view of %s\n", dashboard.Generated.Local().Format(time.TimeOnly))
+	}
+	fmt.Fprintln(&view)
+	if err := web.WriteText(&view, *dashboard); err != nil {
+		fmt.Fprintf(&view, "! Rendering the view: %v\n", err)
+	}
+	_, _ = view.WriteTo(os.Stdout)
+	return dashboard
+}
+
+//nolint:gochecknoinits // This is a command line tool
+func init() {
+	watchCmd.Flags().StringVar(&watchAddress, "address", "",
+		"Status address of the running daemon (host:port or unix:/path), defaults to general.status_address")
+	watchCmd.Flags().DurationVar(&watchInterval, "interval", 2*time.Second, "How often the view is refreshed")
+}
@@ -1,9 +1,25 @@
 package main
 
 import (
+	_ "embed"
+
 	"github.com/aauren/tailscale-bind-ddns/cmd"
 )
 
+// exampleConfig documents every option, `config init` writes starter configurations from it
+//
+//go:embed config.yaml.example
+var exampleConfig []byte
+
+// Set at build time through -ldflags "-X main.version=..."
+var (
+	version = "dev"
+	commit  = "none"
+	date    = "unknown"
+)
+
 func main() {
+	cmd.SetVersion(version, commit, date)
+	cmd.SetExampleConfig(exampleConfig)
 	cmd.Execute()
 }
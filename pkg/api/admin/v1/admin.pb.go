@@ -0,0 +1,1000 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: admin/v1/admin.proto
+
+package adminv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type StatusRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StatusRequest) Reset() {
+	*x = StatusRequest{}
+	mi := &file_admin_v1_admin_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatusRequest) ProtoMessage() {}
+
+func (x *StatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_v1_admin_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatusRequest.ProtoReflect.Descriptor instead.
+func (*StatusRequest) Descriptor() ([]byte, []int) {
+	return file_admin_v1_admin_proto_rawDescGZIP(), []int{0}
+}
+
+type StatusResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Tailnet       string                 `protobuf:"bytes,1,opt,name=tailnet,proto3" json:"tailnet,omitempty"`
+	Provider      string                 `protobuf:"bytes,2,opt,name=provider,proto3" json:"provider,omitempty"`
+	BindServer    string                 `protobuf:"bytes,3,opt,name=bind_server,json=bindServer,proto3" json:"bind_server,omitempty"`
+	BindZone      string                 `protobuf:"bytes,4,opt,name=bind_zone,json=bindZone,proto3" json:"bind_zone,omitempty"`
+	DryRun        bool                   `protobuf:"varint,5,opt,name=dry_run,json=dryRun,proto3" json:"dry_run,omitempty"`
+	Paused        bool                   `protobuf:"varint,6,opt,name=paused,proto3" json:"paused,omitempty"`
+	LastSync      *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=last_sync,json=lastSync,proto3" json:"last_sync,omitempty"`
+	Zones         []*ZoneStatus          `protobuf:"bytes,8,rep,name=zones,proto3" json:"zones,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StatusResponse) Reset() {
+	*x = StatusResponse{}
+	mi := &file_admin_v1_admin_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatusResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatusResponse) ProtoMessage() {}
+
+func (x *StatusResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_v1_admin_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatusResponse.ProtoReflect.Descriptor instead.
+func (*StatusResponse) Descriptor() ([]byte, []int) {
+	return file_admin_v1_admin_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *StatusResponse) GetTailnet() string {
+	if x != nil {
+		return x.Tailnet
+	}
+	return ""
+}
+
+func (x *StatusResponse) GetProvider() string {
+	if x != nil {
+		return x.Provider
+	}
+	return ""
+}
+
+func (x *StatusResponse) GetBindServer() string {
+	if x != nil {
+		return x.BindServer
+	}
+	return ""
+}
+
+func (x *StatusResponse) GetBindZone() string {
+	if x != nil {
+		return x.BindZone
+	}
+	return ""
+}
+
+func (x *StatusResponse) GetDryRun() bool {
+	if x != nil {
+		return x.DryRun
+	}
+	return false
+}
+
+func (x *StatusResponse) GetPaused() bool {
+	if x != nil {
+		return x.Paused
+	}
+	return false
+}
+
+func (x *StatusResponse) GetLastSync() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LastSync
+	}
+	return nil
+}
+
+func (x *StatusResponse) GetZones() []*ZoneStatus {
+	if x != nil {
+		return x.Zones
+	}
+	return nil
+}
+
+type ZoneStatus struct {
+	state               protoimpl.MessageState `protogen:"open.v1"`
+	Zone                string                 `protobuf:"bytes,1,opt,name=zone,proto3" json:"zone,omitempty"`
+	Records             int64                  `protobuf:"varint,2,opt,name=records,proto3" json:"records,omitempty"`
+	LastAttempt         *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=last_attempt,json=lastAttempt,proto3" json:"last_attempt,omitempty"`
+	LastSuccess         *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=last_success,json=lastSuccess,proto3" json:"last_success,omitempty"`
+	LastError           string                 `protobuf:"bytes,5,opt,name=last_error,json=lastError,proto3" json:"last_error,omitempty"`
+	ConsecutiveFailures int64                  `protobuf:"varint,6,opt,name=consecutive_failures,json=consecutiveFailures,proto3" json:"consecutive_failures,omitempty"`
+	Serial              uint32                 `protobuf:"varint,7,opt,name=serial,proto3" json:"serial,omitempty"`
+	unknownFields       protoimpl.UnknownFields
+	sizeCache           protoimpl.SizeCache
+}
+
+func (x *ZoneStatus) Reset() {
+	*x = ZoneStatus{}
+	mi := &file_admin_v1_admin_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ZoneStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ZoneStatus) ProtoMessage() {}
+
+func (x *ZoneStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_v1_admin_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ZoneStatus.ProtoReflect.Descriptor instead.
+func (*ZoneStatus) Descriptor() ([]byte, []int) {
+	return file_admin_v1_admin_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ZoneStatus) GetZone() string {
+	if x != nil {
+		return x.Zone
+	}
+	return ""
+}
+
+func (x *ZoneStatus) GetRecords() int64 {
+	if x != nil {
+		return x.Records
+	}
+	return 0
+}
+
+func (x *ZoneStatus) GetLastAttempt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LastAttempt
+	}
+	return nil
+}
+
+func (x *ZoneStatus) GetLastSuccess() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LastSuccess
+	}
+	return nil
+}
+
+func (x *ZoneStatus) GetLastError() string {
+	if x != nil {
+		return x.LastError
+	}
+	return ""
+}
+
+func (x *ZoneStatus) GetConsecutiveFailures() int64 {
+	if x != nil {
+		return x.ConsecutiveFailures
+	}
+	return 0
+}
+
+func (x *ZoneStatus) GetSerial() uint32 {
+	if x != nil {
+		return x.Serial
+	}
+	return 0
+}
+
+type TriggerSyncRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TriggerSyncRequest) Reset() {
+	*x = TriggerSyncRequest{}
+	mi := &file_admin_v1_admin_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TriggerSyncRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TriggerSyncRequest) ProtoMessage() {}
+
+func (x *TriggerSyncRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_v1_admin_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TriggerSyncRequest.ProtoReflect.Descriptor instead.
+func (*TriggerSyncRequest) Descriptor() ([]byte, []int) {
+	return file_admin_v1_admin_proto_rawDescGZIP(), []int{3}
+}
+
+type TriggerSyncResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Records       int64                  `protobuf:"varint,1,opt,name=records,proto3" json:"records,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TriggerSyncResponse) Reset() {
+	*x = TriggerSyncResponse{}
+	mi := &file_admin_v1_admin_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TriggerSyncResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TriggerSyncResponse) ProtoMessage() {}
+
+func (x *TriggerSyncResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_v1_admin_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TriggerSyncResponse.ProtoReflect.Descriptor instead.
+func (*TriggerSyncResponse) Descriptor() ([]byte, []int) {
+	return file_admin_v1_admin_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *TriggerSyncResponse) GetRecords() int64 {
+	if x != nil {
+		return x.Records
+	}
+	return 0
+}
+
+type PauseRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PauseRequest) Reset() {
+	*x = PauseRequest{}
+	mi := &file_admin_v1_admin_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PauseRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PauseRequest) ProtoMessage() {}
+
+func (x *PauseRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_v1_admin_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PauseRequest.ProtoReflect.Descriptor instead.
+func (*PauseRequest) Descriptor() ([]byte, []int) {
+	return file_admin_v1_admin_proto_rawDescGZIP(), []int{5}
+}
+
+type PauseResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PauseResponse) Reset() {
+	*x = PauseResponse{}
+	mi := &file_admin_v1_admin_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PauseResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PauseResponse) ProtoMessage() {}
+
+func (x *PauseResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_v1_admin_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PauseResponse.ProtoReflect.Descriptor instead.
+func (*PauseResponse) Descriptor() ([]byte, []int) {
+	return file_admin_v1_admin_proto_rawDescGZIP(), []int{6}
+}
+
+type ResumeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResumeRequest) Reset() {
+	*x = ResumeRequest{}
+	mi := &file_admin_v1_admin_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResumeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResumeRequest) ProtoMessage() {}
+
+func (x *ResumeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_v1_admin_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResumeRequest.ProtoReflect.Descriptor instead.
+func (*ResumeRequest) Descriptor() ([]byte, []int) {
+	return file_admin_v1_admin_proto_rawDescGZIP(), []int{7}
+}
+
+type ResumeResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResumeResponse) Reset() {
+	*x = ResumeResponse{}
+	mi := &file_admin_v1_admin_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResumeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResumeResponse) ProtoMessage() {}
+
+func (x *ResumeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_v1_admin_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResumeResponse.ProtoReflect.Descriptor instead.
+func (*ResumeResponse) Descriptor() ([]byte, []int) {
+	return file_admin_v1_admin_proto_rawDescGZIP(), []int{8}
+}
+
+type ListManagedRecordsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListManagedRecordsRequest) Reset() {
+	*x = ListManagedRecordsRequest{}
+	mi := &file_admin_v1_admin_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListManagedRecordsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListManagedRecordsRequest) ProtoMessage() {}
+
+func (x *ListManagedRecordsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_v1_admin_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListManagedRecordsRequest.ProtoReflect.Descriptor instead.
+func (*ListManagedRecordsRequest) Descriptor() ([]byte, []int) {
+	return file_admin_v1_admin_proto_rawDescGZIP(), []int{9}
+}
+
+type ListManagedRecordsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Records       []*Record              `protobuf:"bytes,1,rep,name=records,proto3" json:"records,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListManagedRecordsResponse) Reset() {
+	*x = ListManagedRecordsResponse{}
+	mi := &file_admin_v1_admin_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListManagedRecordsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListManagedRecordsResponse) ProtoMessage() {}
+
+func (x *ListManagedRecordsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_v1_admin_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListManagedRecordsResponse.ProtoReflect.Descriptor instead.
+func (*ListManagedRecordsResponse) Descriptor() ([]byte, []int) {
+	return file_admin_v1_admin_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ListManagedRecordsResponse) GetRecords() []*Record {
+	if x != nil {
+		return x.Records
+	}
+	return nil
+}
+
+type Record struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Type          string                 `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	Value         string                 `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	Ttl           uint32                 `protobuf:"varint,4,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Record) Reset() {
+	*x = Record{}
+	mi := &file_admin_v1_admin_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Record) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Record) ProtoMessage() {}
+
+func (x *Record) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_v1_admin_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Record.ProtoReflect.Descriptor instead.
+func (*Record) Descriptor() ([]byte, []int) {
+	return file_admin_v1_admin_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *Record) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Record) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Record) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+func (x *Record) GetTtl() uint32 {
+	if x != nil {
+		return x.Ttl
+	}
+	return 0
+}
+
+type SetExternalRecordsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Identifies the external system, each source owns its own set of records
+	Source        string    `protobuf:"bytes,1,opt,name=source,proto3" json:"source,omitempty"`
+	Records       []*Record `protobuf:"bytes,2,rep,name=records,proto3" json:"records,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetExternalRecordsRequest) Reset() {
+	*x = SetExternalRecordsRequest{}
+	mi := &file_admin_v1_admin_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetExternalRecordsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetExternalRecordsRequest) ProtoMessage() {}
+
+func (x *SetExternalRecordsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_v1_admin_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetExternalRecordsRequest.ProtoReflect.Descriptor instead.
+func (*SetExternalRecordsRequest) Descriptor() ([]byte, []int) {
+	return file_admin_v1_admin_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *SetExternalRecordsRequest) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *SetExternalRecordsRequest) GetRecords() []*Record {
+	if x != nil {
+		return x.Records
+	}
+	return nil
+}
+
+type SetExternalRecordsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Records       int64                  `protobuf:"varint,1,opt,name=records,proto3" json:"records,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetExternalRecordsResponse) Reset() {
+	*x = SetExternalRecordsResponse{}
+	mi := &file_admin_v1_admin_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetExternalRecordsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetExternalRecordsResponse) ProtoMessage() {}
+
+func (x *SetExternalRecordsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_v1_admin_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetExternalRecordsResponse.ProtoReflect.Descriptor instead.
+func (*SetExternalRecordsResponse) Descriptor() ([]byte, []int) {
+	return file_admin_v1_admin_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *SetExternalRecordsResponse) GetRecords() int64 {
+	if x != nil {
+		return x.Records
+	}
+	return 0
+}
+
+type ListExternalRecordsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListExternalRecordsRequest) Reset() {
+	*x = ListExternalRecordsRequest{}
+	mi := &file_admin_v1_admin_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListExternalRecordsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListExternalRecordsRequest) ProtoMessage() {}
+
+func (x *ListExternalRecordsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_v1_admin_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListExternalRecordsRequest.ProtoReflect.Descriptor instead.
+func (*ListExternalRecordsRequest) Descriptor() ([]byte, []int) {
+	return file_admin_v1_admin_proto_rawDescGZIP(), []int{14}
+}
+
+type ListExternalRecordsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Sources       []*ExternalRecordSet   `protobuf:"bytes,1,rep,name=sources,proto3" json:"sources,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListExternalRecordsResponse) Reset() {
+	*x = ListExternalRecordsResponse{}
+	mi := &file_admin_v1_admin_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListExternalRecordsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListExternalRecordsResponse) ProtoMessage() {}
+
+func (x *ListExternalRecordsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_v1_admin_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListExternalRecordsResponse.ProtoReflect.Descriptor instead.
+func (*ListExternalRecordsResponse) Descriptor() ([]byte, []int) {
+	return file_admin_v1_admin_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *ListExternalRecordsResponse) GetSources() []*ExternalRecordSet {
+	if x != nil {
+		return x.Sources
+	}
+	return nil
+}
+
+type ExternalRecordSet struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Source        string                 `protobuf:"bytes,1,opt,name=source,proto3" json:"source,omitempty"`
+	Records       []*Record              `protobuf:"bytes,2,rep,name=records,proto3" json:"records,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExternalRecordSet) Reset() {
+	*x = ExternalRecordSet{}
+	mi := &file_admin_v1_admin_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExternalRecordSet) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExternalRecordSet) ProtoMessage() {}
+
+func (x *ExternalRecordSet) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_v1_admin_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExternalRecordSet.ProtoReflect.Descriptor instead.
+func (*ExternalRecordSet) Descriptor() ([]byte, []int) {
+	return file_admin_v1_admin_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *ExternalRecordSet) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *ExternalRecordSet) GetRecords() []*Record {
+	if x != nil {
+		return x.Records
+	}
+	return nil
+}
+
+var File_admin_v1_admin_proto protoreflect.FileDescriptor
+
+const file_admin_v1_admin_proto_rawDesc = "" +
+	"\n" +
+	"\x14admin/v1/admin.proto\x12\x1ctailscale_bind_ddns.admin.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\x0f\n" +
+	"\rStatusRequest\"\xae\x02\n" +
+	"\x0eStatusResponse\x12\x18\n" +
+	"\atailnet\x18\x01 \x01(\tR\atailnet\x12\x1a\n" +
+	"\bprovider\x18\x02 \x01(\tR\bprovider\x12\x1f\n" +
+	"\vbind_server\x18\x03 \x01(\tR\n" +
+	"bindServer\x12\x1b\n" +
+	"\tbind_zone\x18\x04 \x01(\tR\bbindZone\x12\x17\n" +
+	"\adry_run\x18\x05 \x01(\bR\x06dryRun\x12\x16\n" +
+	"\x06paused\x18\x06 \x01(\bR\x06paused\x127\n" +
+	"\tlast_sync\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\blastSync\x12>\n" +
+	"\x05zones\x18\b \x03(\v2(.tailscale_bind_ddns.admin.v1.ZoneStatusR\x05zones\"\xa2\x02\n" +
+	"\n" +
+	"ZoneStatus\x12\x12\n" +
+	"\x04zone\x18\x01 \x01(\tR\x04zone\x12\x18\n" +
+	"\arecords\x18\x02 \x01(\x03R\arecords\x12=\n" +
+	"\flast_attempt\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\vlastAttempt\x12=\n" +
+	"\flast_success\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\vlastSuccess\x12\x1d\n" +
+	"\n" +
+	"last_error\x18\x05 \x01(\tR\tlastError\x121\n" +
+	"\x14consecutive_failures\x18\x06 \x01(\x03R\x13consecutiveFailures\x12\x16\n" +
+	"\x06serial\x18\a \x01(\rR\x06serial\"\x14\n" +
+	"\x12TriggerSyncRequest\"/\n" +
+	"\x13TriggerSyncResponse\x12\x18\n" +
+	"\arecords\x18\x01 \x01(\x03R\arecords\"\x0e\n" +
+	"\fPauseRequest\"\x0f\n" +
+	"\rPauseResponse\"\x0f\n" +
+	"\rResumeRequest\"\x10\n" +
+	"\x0eResumeResponse\"\x1b\n" +
+	"\x19ListManagedRecordsRequest\"\\\n" +
+	"\x1aListManagedRecordsResponse\x12>\n" +
+	"\arecords\x18\x01 \x03(\v2$.tailscale_bind_ddns.admin.v1.RecordR\arecords\"X\n" +
+	"\x06Record\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x12\n" +
+	"\x04type\x18\x02 \x01(\tR\x04type\x12\x14\n" +
+	"\x05value\x18\x03 \x01(\tR\x05value\x12\x10\n" +
+	"\x03ttl\x18\x04 \x01(\rR\x03ttl\"s\n" +
+	"\x19SetExternalRecordsRequest\x12\x16\n" +
+	"\x06source\x18\x01 \x01(\tR\x06source\x12>\n" +
+	"\arecords\x18\x02 \x03(\v2$.tailscale_bind_ddns.admin.v1.RecordR\arecords\"6\n" +
+	"\x1aSetExternalRecordsResponse\x12\x18\n" +
+	"\arecords\x18\x01 \x01(\x03R\arecords\"\x1c\n" +
+	"\x1aListExternalRecordsRequest\"h\n" +
+	"\x1bListExternalRecordsResponse\x12I\n" +
+	"\asources\x18\x01 \x03(\v2/.tailscale_bind_ddns.admin.v1.ExternalRecordSetR\asources\"k\n" +
+	"\x11ExternalRecordSet\x12\x16\n" +
+	"\x06source\x18\x01 \x01(\tR\x06source\x12>\n" +
+	"\arecords\x18\x02 \x03(\v2$.tailscale_bind_ddns.admin.v1.RecordR\arecords2\xcf\x06\n" +
+	"\fAdminService\x12c\n" +
+	"\x06Status\x12+.tailscale_bind_ddns.admin.v1.StatusRequest\x1a,.tailscale_bind_ddns.admin.v1.StatusResponse\x12r\n" +
+	"\vTriggerSync\x120.tailscale_bind_ddns.admin.v1.TriggerSyncRequest\x1a1.tailscale_bind_ddns.admin.v1.TriggerSyncResponse\x12`\n" +
+	"\x05Pause\x12*.tailscale_bind_ddns.admin.v1.PauseRequest\x1a+.tailscale_bind_ddns.admin.v1.PauseResponse\x12c\n" +
+	"\x06Resume\x12+.tailscale_bind_ddns.admin.v1.ResumeRequest\x1a,.tailscale_bind_ddns.admin.v1.ResumeResponse\x12\x87\x01\n" +
+	"\x12ListManagedRecords\x127.tailscale_bind_ddns.admin.v1.ListManagedRecordsRequest\x1a8.tailscale_bind_ddns.admin.v1.ListManagedRecordsResponse\x12\x87\x01\n" +
+	"\x12SetExternalRecords\x127.tailscale_bind_ddns.admin.v1.SetExternalRecordsRequest\x1a8.tailscale_bind_ddns.admin.v1.SetExternalRecordsResponse\x12\x8a\x01\n" +
+	"\x13ListExternalRecords\x128.tailscale_bind_ddns.admin.v1.ListExternalRecordsRequest\x1a9.tailscale_bind_ddns.admin.v1.ListExternalRecordsResponseB@Z>github.com/aauren/tailscale-bind-ddns/pkg/api/admin/v1;adminv1b\x06proto3"
+
+var (
+	file_admin_v1_admin_proto_rawDescOnce sync.Once
+	file_admin_v1_admin_proto_rawDescData []byte
+)
+
+func file_admin_v1_admin_proto_rawDescGZIP() []byte {
+	file_admin_v1_admin_proto_rawDescOnce.Do(func() {
+		file_admin_v1_admin_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_admin_v1_admin_proto_rawDesc), len(file_admin_v1_admin_proto_rawDesc)))
+	})
+	return file_admin_v1_admin_proto_rawDescData
+}
+
+var file_admin_v1_admin_proto_msgTypes = make([]protoimpl.MessageInfo, 17)
+var file_admin_v1_admin_proto_goTypes = []any{
+	(*StatusRequest)(nil),               // 0: tailscale_bind_ddns.admin.v1.StatusRequest
+	(*StatusResponse)(nil),              // 1: tailscale_bind_ddns.admin.v1.StatusResponse
+	(*ZoneStatus)(nil),                  // 2: tailscale_bind_ddns.admin.v1.ZoneStatus
+	(*TriggerSyncRequest)(nil),          // 3: tailscale_bind_ddns.admin.v1.TriggerSyncRequest
+	(*TriggerSyncResponse)(nil),         // 4: tailscale_bind_ddns.admin.v1.TriggerSyncResponse
+	(*PauseRequest)(nil),                // 5: tailscale_bind_ddns.admin.v1.PauseRequest
+	(*PauseResponse)(nil),               // 6: tailscale_bind_ddns.admin.v1.PauseResponse
+	(*ResumeRequest)(nil),               // 7: tailscale_bind_ddns.admin.v1.ResumeRequest
+	(*ResumeResponse)(nil),              // 8: tailscale_bind_ddns.admin.v1.ResumeResponse
+	(*ListManagedRecordsRequest)(nil),   // 9: tailscale_bind_ddns.admin.v1.ListManagedRecordsRequest
+	(*ListManagedRecordsResponse)(nil),  // 10: tailscale_bind_ddns.admin.v1.ListManagedRecordsResponse
+	(*Record)(nil),                      // 11: tailscale_bind_ddns.admin.v1.Record
+	(*SetExternalRecordsRequest)(nil),   // 12: tailscale_bind_ddns.admin.v1.SetExternalRecordsRequest
+	(*SetExternalRecordsResponse)(nil),  // 13: tailscale_bind_ddns.admin.v1.SetExternalRecordsResponse
+	(*ListExternalRecordsRequest)(nil),  // 14: tailscale_bind_ddns.admin.v1.ListExternalRecordsRequest
+	(*ListExternalRecordsResponse)(nil), // 15: tailscale_bind_ddns.admin.v1.ListExternalRecordsResponse
+	(*ExternalRecordSet)(nil),           // 16: tailscale_bind_ddns.admin.v1.ExternalRecordSet
+	(*timestamppb.Timestamp)(nil),       // 17: google.protobuf.Timestamp
+}
+var file_admin_v1_admin_proto_depIdxs = []int32{
+	17, // 0: tailscale_bind_ddns.admin.v1.StatusResponse.last_sync:type_name -> google.protobuf.Timestamp
+	2,  // 1: tailscale_bind_ddns.admin.v1.StatusResponse.zones:type_name -> tailscale_bind_ddns.admin.v1.ZoneStatus
+	17, // 2: tailscale_bind_ddns.admin.v1.ZoneStatus.last_attempt:type_name -> google.protobuf.Timestamp
+	17, // 3: tailscale_bind_ddns.admin.v1.ZoneStatus.last_success:type_name -> google.protobuf.Timestamp
+	11, // 4: tailscale_bind_ddns.admin.v1.ListManagedRecordsResponse.records:type_name -> tailscale_bind_ddns.admin.v1.Record
+	11, // 5: tailscale_bind_ddns.admin.v1.SetExternalRecordsRequest.records:type_name -> tailscale_bind_ddns.admin.v1.Record
+	16, // 6: tailscale_bind_ddns.admin.v1.ListExternalRecordsResponse.sources:type_name -> tailscale_bind_ddns.admin.v1.ExternalRecordSet
+	11, // 7: tailscale_bind_ddns.admin.v1.ExternalRecordSet.records:type_name -> tailscale_bind_ddns.admin.v1.Record
+	0,  // 8: tailscale_bind_ddns.admin.v1.AdminService.Status:input_type -> tailscale_bind_ddns.admin.v1.StatusRequest
+	3,  // 9: tailscale_bind_ddns.admin.v1.AdminService.TriggerSync:input_type -> tailscale_bind_ddns.admin.v1.TriggerSyncRequest
+	5,  // 10: tailscale_bind_ddns.admin.v1.AdminService.Pause:input_type -> tailscale_bind_ddns.admin.v1.PauseRequest
+	7,  // 11: tailscale_bind_ddns.admin.v1.AdminService.Resume:input_type -> tailscale_bind_ddns.admin.v1.ResumeRequest
+	9,  // 12: tailscale_bind_ddns.admin.v1.AdminService.ListManagedRecords:input_type -> tailscale_bind_ddns.admin.v1.ListManagedRecordsRequest
+	12, // 13: tailscale_bind_ddns.admin.v1.AdminService.SetExternalRecords:input_type -> tailscale_bind_ddns.admin.v1.SetExternalRecordsRequest
+	14, // 14: tailscale_bind_ddns.admin.v1.AdminService.ListExternalRecords:input_type -> tailscale_bind_ddns.admin.v1.ListExternalRecordsRequest
+	1,  // 15: tailscale_bind_ddns.admin.v1.AdminService.Status:output_type -> tailscale_bind_ddns.admin.v1.StatusResponse
+	4,  // 16: tailscale_bind_ddns.admin.v1.AdminService.TriggerSync:output_type -> tailscale_bind_ddns.admin.v1.TriggerSyncResponse
+	6,  // 17: tailscale_bind_ddns.admin.v1.AdminService.Pause:output_type -> tailscale_bind_ddns.admin.v1.PauseResponse
+	8,  // 18: tailscale_bind_ddns.admin.v1.AdminService.Resume:output_type -> tailscale_bind_ddns.admin.v1.ResumeResponse
+	10, // 19: tailscale_bind_ddns.admin.v1.AdminService.ListManagedRecords:output_type -> tailscale_bind_ddns.admin.v1.ListManagedRecordsResponse
+	13, // 20: tailscale_bind_ddns.admin.v1.AdminService.SetExternalRecords:output_type -> tailscale_bind_ddns.admin.v1.SetExternalRecordsResponse
+	15, // 21: tailscale_bind_ddns.admin.v1.AdminService.ListExternalRecords:output_type -> tailscale_bind_ddns.admin.v1.ListExternalRecordsResponse
+	15, // [15:22] is the sub-list for method output_type
+	8,  // [8:15] is the sub-list for method input_type
+	8,  // [8:8] is the sub-list for extension type_name
+	8,  // [8:8] is the sub-list for extension extendee
+	0,  // [0:8] is the sub-list for field type_name
+}
+
+func init() { file_admin_v1_admin_proto_init() }
+func file_admin_v1_admin_proto_init() {
+	if File_admin_v1_admin_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_admin_v1_admin_proto_rawDesc), len(file_admin_v1_admin_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   17,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_admin_v1_admin_proto_goTypes,
+		DependencyIndexes: file_admin_v1_admin_proto_depIdxs,
+		MessageInfos:      file_admin_v1_admin_proto_msgTypes,
+	}.Build()
+	File_admin_v1_admin_proto = out.File
+	file_admin_v1_admin_proto_goTypes = nil
+	file_admin_v1_admin_proto_depIdxs = nil
+}
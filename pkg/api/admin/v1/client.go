@@ -0,0 +1,39 @@
+package adminv1
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// TokenMetadataKey is the gRPC metadata key carrying the bearer token when the daemon has general.grpc_token set
+const TokenMetadataKey = "authorization"
+
+// tokenCredentials attaches a bearer token to every call. The admin API is meant to be reached over a unix socket or
+// the tailnet itself, which already encrypts the connection, so the token doesn't require transport security.
+type tokenCredentials string
+
+func (t tokenCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{TokenMetadataKey: "Bearer " + string(t)}, nil
+}
+
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// Dial connects to the admin API of a daemon listening on address, which is either host:port or unix:/path/to/socket.
+// The token may be empty when the daemon doesn't require one. Callers must close the returned connection.
+func Dial(address, token string) (AdminServiceClient, *grpc.ClientConn, error) {
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	if token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(tokenCredentials(token)))
+	}
+
+	conn, err := grpc.NewClient(address, opts...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connecting to admin API at %s: %w", address, err)
+	}
+	return NewAdminServiceClient(conn), conn, nil
+}
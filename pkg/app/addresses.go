@@ -0,0 +1,135 @@
+package app
+
+import (
+	"fmt"
+	"net"
+	"slices"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/aauren/tailscale-bind-ddns/pkg/tailscale"
+)
+
+// Ranges Tailscale assigns addresses from, the CGNAT range for IPv4 and its ULA range for IPv6
+var (
+	tailscaleRange     = &net.IPNet{IP: net.IPv4(100, 64, 0, 0).To4(), Mask: net.CIDRMask(10, 32)}
+	tailscaleIPv6Range = &net.IPNet{IP: net.ParseIP("fd7a:115c:a1e0::"), Mask: net.CIDRMask(48, 128)}
+)
+
+// addressSelector picks the addresses of one family published for devices reporting several, e.g. subnet routers and
+// multi-homed nodes
+type addressSelector struct {
+	tags      []string // Devices the selector applies to, empty for the default selector
+	selection string
+	subnets   []*net.IPNet
+	tailscale *net.IPNet // Range of the tailscale selection
+}
+
+// newAddressSelectors returns the IPv4 selectors of the address policies followed by the default IPv4 selector
+func newAddressSelectors(cfg *config.TailscaleConfig) ([]*addressSelector, error) {
+	policies := append(slices.Clone(cfg.AddressPolicies), config.AddressPolicy{
+		Selection: cfg.IPv4Addresses,
+		Subnets:   cfg.IPv4Subnets,
+	})
+
+	selectors := make([]*addressSelector, 0, len(policies))
+	for _, policy := range policies {
+		selector, err := newAddressSelector(policy.Tags, policy.Selection, policy.Subnets, tailscaleRange)
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, selector)
+	}
+	return selectors, nil
+}
+
+// newAddressSelector returns a selector of the addresses in the given subnets or Tailscale range
+func newAddressSelector(tags []string, selection string, subnets []string,
+	tailscale *net.IPNet) (*addressSelector, error) {
+	selector := &addressSelector{tags: tags, selection: selection, tailscale: tailscale}
+	for _, subnet := range subnets {
+		_, network, err := net.ParseCIDR(subnet)
+		if err != nil {
+			return nil, fmt.Errorf("parsing preferred subnet %q: %w", subnet, err)
+		}
+		selector.subnets = append(selector.subnets, network)
+	}
+	return selector, nil
+}
+
+// applies reports whether the selector applies to a machine
+func (s *addressSelector) applies(machine tailscale.Machine) bool {
+	if len(s.tags) == 0 {
+		return true
+	}
+	for _, tag := range machine.Tags {
+		if slices.Contains(s.tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// pick returns the selected addresses in the order the device reports them
+func (s *addressSelector) pick(addresses []string) []string {
+	within := func(network *net.IPNet) []string {
+		var selected []string
+		for _, address := range addresses {
+			if ip := net.ParseIP(address); ip != nil && network.Contains(ip) {
+				selected = append(selected, address)
+			}
+		}
+		return selected
+	}
+
+	switch s.selection {
+	case config.AddressSelectionAll:
+		return addresses
+	case config.AddressSelectionTailscale:
+		return within(s.tailscale)
+	case config.AddressSelectionSubnet:
+		for _, subnet := range s.subnets {
+			if selected := within(subnet); len(selected) > 0 {
+				return selected
+			}
+		}
+	}
+	// The first address, also for subnet selections none of whose subnets holds an address
+	return addresses[:1]
+}
+
+// machineIPv4Addresses returns the IPv4 addresses published for a machine
+func (a *App) machineIPv4Addresses(machine tailscale.Machine) []string {
+	addresses := machine.IPv4Addresses
+	if len(addresses) == 0 {
+		if machine.IPv4Address == "" {
+			return nil
+		}
+		addresses = []string{machine.IPv4Address}
+	}
+
+	for _, selector := range a.addresses {
+		if selector.applies(machine) {
+			return selector.pick(addresses)
+		}
+	}
+	return addresses[:1]
+}
+
+// machineIPv6Addresses returns the IPv6 addresses published for a machine
+func (a *App) machineIPv6Addresses(machine tailscale.Machine) []string {
+	if !a.config.IPv6Enabled() {
+		return nil
+	}
+	addresses := machine.IPv6Addresses
+	if len(addresses) == 0 {
+		if machine.IPv6Address == "" {
+			return nil
+		}
+		addresses = []string{machine.IPv6Address}
+	}
+
+	if a.ipv6Addresses == nil {
+		return addresses[:1]
+	}
+	return a.ipv6Addresses.pick(addresses)
+}
@@ -0,0 +1,222 @@
+//go:build !no_grpc
+
+package app
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	adminv1 "github.com/aauren/tailscale-bind-ddns/pkg/api/admin/v1"
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"k8s.io/klog/v2"
+)
+
+// adminServer implements the gRPC admin API on top of the application
+type adminServer struct {
+	adminv1.UnimplementedAdminServiceServer
+	app *App
+}
+
+// startAdminAPI starts serving the gRPC admin API on the configured address until the context is cancelled
+func (a *App) startAdminAPI(ctx context.Context) error {
+	address := a.config.General.GRPCAddress
+	listener, err := listenAddress(address)
+	if err != nil {
+		return fmt.Errorf("listening for admin requests: %w", err)
+	}
+
+	token := a.config.General.GRPCToken
+	if token == "" && !strings.HasPrefix(address, config.UnixAddressPrefix) {
+		klog.Warningf("gRPC admin API on %s accepts unauthenticated requests, consider setting general.grpc_token",
+			address)
+	}
+
+	server := newAdminGRPCServer(a, token)
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		klog.Infof("Serving gRPC admin API on %s", listener.Addr())
+		if err := server.Serve(listener); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			klog.Errorf("gRPC admin API failed: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// newAdminGRPCServer builds a gRPC server exposing the admin API, requiring the token on every call when it is set
+func newAdminGRPCServer(a *App, token string) *grpc.Server {
+	var opts []grpc.ServerOption
+	if token != "" {
+		opts = append(opts, grpc.UnaryInterceptor(tokenInterceptor(token)))
+	}
+
+	server := grpc.NewServer(opts...)
+	adminv1.RegisterAdminServiceServer(server, &adminServer{app: a})
+	return server
+}
+
+// tokenInterceptor rejects calls that don't carry the expected bearer token
+func tokenInterceptor(token string) grpc.UnaryServerInterceptor {
+	expected := []byte("Bearer " + token)
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		values := md.Get(adminv1.TokenMetadataKey)
+		if len(values) != 1 || subtle.ConstantTimeCompare([]byte(values[0]), expected) != 1 {
+			return nil, status.Error(codes.Unauthenticated, "missing or invalid admin token")
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (s *adminServer) Status(context.Context, *adminv1.StatusRequest) (*adminv1.StatusResponse, error) {
+	cfg := s.app.config
+	resp := &adminv1.StatusResponse{
+		Tailnet:    cfg.Tailscale.Tailnet,
+		Provider:   cfg.General.Provider,
+		BindServer: cfg.Bind.Server,
+		BindZone:   cfg.Bind.Zone,
+		DryRun:     cfg.General.DryRun,
+		Paused:     s.app.Paused(),
+	}
+
+	if _, lastSync := s.app.ManagedRecords(); !lastSync.IsZero() {
+		resp.LastSync = timestamppb.New(lastSync)
+	}
+
+	s.app.clientsMu.Lock()
+	provider := s.app.provider
+	s.app.clientsMu.Unlock()
+
+	if reporter, ok := provider.(zoneStatusReporter); ok {
+		zones := reporter.ZoneStatuses()
+		for _, zone := range slices.Sorted(maps.Keys(zones)) {
+			resp.Zones = append(resp.Zones, zoneStatusToProto(zone, zones[zone]))
+		}
+	}
+
+	return resp, nil
+}
+
+func (s *adminServer) TriggerSync(
+	ctx context.Context,
+	_ *adminv1.TriggerSyncRequest,
+) (*adminv1.TriggerSyncResponse, error) {
+	records, err := s.app.TriggerSync(ctx)
+	if errors.Is(err, ErrPaused) {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &adminv1.TriggerSyncResponse{Records: int64(records)}, nil
+}
+
+func (s *adminServer) Pause(context.Context, *adminv1.PauseRequest) (*adminv1.PauseResponse, error) {
+	s.app.Pause()
+	return &adminv1.PauseResponse{}, nil
+}
+
+func (s *adminServer) Resume(context.Context, *adminv1.ResumeRequest) (*adminv1.ResumeResponse, error) {
+	s.app.Resume()
+	return &adminv1.ResumeResponse{}, nil
+}
+
+func (s *adminServer) ListManagedRecords(
+	context.Context,
+	*adminv1.ListManagedRecordsRequest,
+) (*adminv1.ListManagedRecordsResponse, error) {
+	records, _ := s.app.ManagedRecords()
+	return &adminv1.ListManagedRecordsResponse{Records: recordsToProto(records)}, nil
+}
+
+func (s *adminServer) SetExternalRecords(
+	_ context.Context,
+	req *adminv1.SetExternalRecordsRequest,
+) (*adminv1.SetExternalRecordsResponse, error) {
+	records := make([]bind.DNSRecord, 0, len(req.GetRecords()))
+	for _, record := range req.GetRecords() {
+		records = append(records, bind.DNSRecord{
+			Name:  record.GetName(),
+			Type:  bind.RecordType(strings.ToUpper(record.GetType())),
+			Value: record.GetValue(),
+			TTL:   record.GetTtl(),
+		})
+	}
+
+	if err := s.app.SetExternalRecords(req.GetSource(), records); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &adminv1.SetExternalRecordsResponse{Records: int64(len(records))}, nil
+}
+
+func (s *adminServer) ListExternalRecords(
+	context.Context,
+	*adminv1.ListExternalRecordsRequest,
+) (*adminv1.ListExternalRecordsResponse, error) {
+	external := s.app.ExternalRecords()
+
+	resp := &adminv1.ListExternalRecordsResponse{}
+	for _, source := range slices.Sorted(maps.Keys(external)) {
+		resp.Sources = append(resp.Sources, &adminv1.ExternalRecordSet{
+			Source:  source,
+			Records: recordsToProto(external[source]),
+		})
+	}
+	return resp, nil
+}
+
+// recordsToProto converts records into their API representation
+func recordsToProto(records []bind.DNSRecord) []*adminv1.Record {
+	converted := make([]*adminv1.Record, 0, len(records))
+	for _, record := range records {
+		converted = append(converted, &adminv1.Record{
+			Name:  record.Name,
+			Type:  string(record.Key().Type),
+			Value: record.Value,
+			Ttl:   record.TTL,
+		})
+	}
+	return converted
+}
+
+// zoneStatusToProto converts a provider zone status into its API representation
+func zoneStatusToProto(zone string, zs bind.ZoneStatus) *adminv1.ZoneStatus {
+	return &adminv1.ZoneStatus{
+		Zone:                zone,
+		Records:             int64(zs.Records),
+		LastAttempt:         optionalTimestamp(zs.LastAttempt),
+		LastSuccess:         optionalTimestamp(zs.LastSuccess),
+		LastError:           zs.LastError,
+		ConsecutiveFailures: int64(zs.Failures),
+		Serial:              zs.Serial,
+	}
+}
+
+// optionalTimestamp converts a time to a timestamp, leaving it unset for the zero time
+func optionalTimestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
@@ -0,0 +1,13 @@
+//go:build no_grpc
+
+package app
+
+import (
+	"context"
+	"fmt"
+)
+
+// startAdminAPI reports that the gRPC admin API was left out of this build
+func (a *App) startAdminAPI(context.Context) error {
+	return fmt.Errorf("the gRPC admin API is not available in this build (built with the no_grpc tag)")
+}
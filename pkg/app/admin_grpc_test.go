@@ -0,0 +1,97 @@
+//go:build !no_grpc
+
+package app
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	adminv1 "github.com/aauren/tailscale-bind-ddns/pkg/api/admin/v1"
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAdminAPI(t *testing.T) {
+	address := config.UnixAddressPrefix + filepath.Join(t.TempDir(), "admin.sock")
+	app := &App{
+		config: &config.Config{
+			Tailscale: config.TailscaleConfig{Tailnet: "test.example.com"},
+			Bind:      config.BindConfig{Server: "dns.example.com", Zone: "test.example.com"},
+			General: config.GeneralConfig{
+				Provider:    config.ProviderBind,
+				GRPCAddress: address,
+				GRPCToken:   "secret-token",
+			},
+		},
+	}
+	app.setManagedRecords([]bind.DNSRecord{{Name: "machine1", Value: "100.64.1.1", TTL: 300, Type: bind.TypeA}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, app.startAdminAPI(ctx))
+	t.Cleanup(func() {
+		cancel()
+		app.wg.Wait()
+	})
+
+	// Calls without the token are rejected
+	unauthenticated, conn, err := adminv1.Dial(address, "")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	_, err = unauthenticated.Status(ctx, &adminv1.StatusRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	client, conn, err := adminv1.Dial(address, "secret-token")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	resp, err := client.Status(ctx, &adminv1.StatusRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "test.example.com", resp.GetTailnet())
+	assert.Equal(t, "dns.example.com", resp.GetBindServer())
+	assert.False(t, resp.GetPaused())
+	assert.NotNil(t, resp.GetLastSync())
+
+	records, err := client.ListManagedRecords(ctx, &adminv1.ListManagedRecordsRequest{})
+	require.NoError(t, err)
+	require.Len(t, records.GetRecords(), 1)
+	assert.Equal(t, "machine1", records.GetRecords()[0].GetName())
+	assert.Equal(t, "A", records.GetRecords()[0].GetType())
+
+	_, err = client.Pause(ctx, &adminv1.PauseRequest{})
+	require.NoError(t, err)
+	assert.True(t, app.Paused())
+
+	// Syncs are refused while paused
+	_, err = client.TriggerSync(ctx, &adminv1.TriggerSyncRequest{})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	_, err = client.Resume(ctx, &adminv1.ResumeRequest{})
+	require.NoError(t, err)
+	assert.False(t, app.Paused())
+
+	_, err = client.SetExternalRecords(ctx, &adminv1.SetExternalRecordsRequest{
+		Source:  "inventory",
+		Records: []*adminv1.Record{{Name: "printer", Type: "A", Value: "not-an-ip"}},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	set, err := client.SetExternalRecords(ctx, &adminv1.SetExternalRecordsRequest{
+		Source:  "inventory",
+		Records: []*adminv1.Record{{Name: "printer", Type: "a", Value: "192.0.2.10", Ttl: 60}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), set.GetRecords())
+
+	external, err := client.ListExternalRecords(ctx, &adminv1.ListExternalRecordsRequest{})
+	require.NoError(t, err)
+	require.Len(t, external.GetSources(), 1)
+	assert.Equal(t, "inventory", external.GetSources()[0].GetSource())
+	require.Len(t, external.GetSources()[0].GetRecords(), 1)
+	assert.Equal(t, "A", external.GetSources()[0].GetRecords()[0].GetType())
+	assert.Equal(t, uint32(60), external.GetSources()[0].GetRecords()[0].GetTtl())
+}
@@ -0,0 +1,52 @@
+package app
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/tailscale"
+	"k8s.io/klog/v2"
+)
+
+// aliasTagPrefix marks tags adding an alias of a device, e.g. tag:dns-alias--grafana. Whoever may apply the tag under
+// the tagOwners of the tailnet policy can add the alias, without changes to the configuration.
+const aliasTagPrefix = "tag:dns-alias--"
+
+// tagAliases returns the aliases requested by tag:dns-alias--<alias> tags, mapped to the record name of the device
+// carrying them. Aliases claimed by several devices are skipped, as a CNAME can only point at one of them.
+func (a *App) tagAliases(machines []tailscale.Machine, names map[string]string) map[string]string {
+	aliases := make(map[string]string)
+	var contested []string
+	for _, machine := range machines {
+		name, ok := names[machine.ID]
+		if !ok {
+			continue
+		}
+		for _, tag := range machine.Tags {
+			alias, ok := strings.CutPrefix(strings.ToLower(tag), aliasTagPrefix)
+			if !ok {
+				continue
+			}
+			if alias == "" || strings.HasPrefix(alias, "-") || strings.HasSuffix(alias, "-") {
+				klog.Warningf("Ignoring tag %s of %s (%s): not a valid alias", tag, machine.Name, machine.ID)
+				continue
+			}
+			if a.config.Bind.OwnerID != "" {
+				// Same as for bind.aliases, the CNAME record can't share its name with the owner TXT record
+				klog.Warningf("Ignoring tag %s of %s (%s): aliases can't be used together with owner_id", tag,
+					machine.Name, machine.ID)
+				continue
+			}
+			if other, ok := aliases[alias]; ok && !strings.EqualFold(other, name) {
+				contested = append(contested, alias)
+			}
+			aliases[alias] = name
+		}
+	}
+
+	for _, alias := range slices.Compact(slices.Sorted(slices.Values(contested))) {
+		klog.Warningf("Not publishing alias %s: several devices carry the tag %s%s", alias, aliasTagPrefix, alias)
+		delete(aliases, alias)
+	}
+	return aliases
+}
@@ -3,72 +3,305 @@ package app
 import (
 	"context"
 	"fmt"
+	"maps"
+	"net"
 	"regexp"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+	"unicode/utf8"
 
 	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
 	"github.com/aauren/tailscale-bind-ddns/pkg/config"
 	"github.com/aauren/tailscale-bind-ddns/pkg/tailscale"
+	"github.com/aauren/tailscale-bind-ddns/pkg/web"
+	"golang.org/x/net/idna"
 	"k8s.io/klog/v2"
 )
 
 // App represents the main application
 type App struct {
-	config          *config.Config
+	config      *config.Config
+	machineChan chan []tailscale.Machine
+	recordChan  chan []bind.DNSRecord
+	wg          sync.WaitGroup
+
+	// Clients are constructed lazily so that only the ones the configuration requires are ever initialized
+	clientsMu       sync.Mutex
 	tailscaleClient *tailscale.Client
-	bindClient      *bind.Client
-	machineChan     chan []tailscale.Machine
-	recordChan      chan []bind.DNSRecord
-	wg              sync.WaitGroup
+	provider        Provider
+
+	// Runtime control, see control.go
+	paused         atomic.Bool
+	managedMu      sync.Mutex
+	managedRecords []bind.DNSRecord
+	lastSync       time.Time
+
+	// Records injected by external systems keyed by source and the machines of the most recent poll they are merged
+	// with, see external.go
+	externalMu      sync.Mutex
+	external        map[string][]bind.DNSRecord
+	lastMachines    []tailscale.Machine
+	polled          bool
+	externalChanged chan struct{}
+
+	// Transitions of devices between polls, see history.go
+	history *history
+
+	// Hostname include/exclude patterns applied before records are built, see filter.go
+	filter *hostnameFilter
+
+	// Selectors of the additional forward zones, see zones.go
+	zones []*zoneSelector
+
+	// Selection of the published IPv4 and IPv6 addresses of devices reporting several, see addresses.go
+	addresses     []*addressSelector
+	ipv6Addresses *addressSelector
+
+	// Names records are published under, see names.go
+	namer *recordNamer
+
+	// Template of the metadata TXT records, nil when they aren't published, see metadata.go
+	metadata *template.Template
+
+	// Template of the names of subnet route records, nil when they aren't published, see routes.go
+	routes *template.Template
+
+	// Additional providers machines are published to, see targets.go
+	targets []*target
+
+	// WASM modules the desired records pass through, see plugins.go
+	plugins []recordPlugin
+
+	// Names withdrawn from the desired records that stay published for a while, nil when disabled, see quarantine.go
+	quarantine *quarantine
+
+	// Built-in DNS server answering from the managed records, nil when disabled, see forwarder.go
+	forwarder *forwarder
+
+	// Requests for an immediate poll, e.g. from Tailscale webhooks, see webhook.go
+	pollNow chan struct{}
+
+	// Clock of the pollers, updaters and history, the real clock when nil. It's handed to the clients as they are
+	// constructed, so that time-dependent behavior can be tested with a fake clock.
+	clock clock.Clock
+
+	// Timings of the cycle whose update is underway and of the most recent cycle that finished, see timing.go
+	timingsMu    sync.Mutex
+	pendingCycle CycleTimings
+	lastCycle    *CycleTimings
+	// Outcome of the most recent update and the most recent update errors, for the status page, see dashboard.go
+	lastResult   *bind.SyncResult
+	recentErrors []web.Error
+	// Zone updates that failed since the start, see gauges.go
+	failedUpdates atomic.Uint64
+	// Names several devices got in the most recent poll, see names.go
+	nameCollisions atomic.Int64
+
+	// Objectives the zone updates are judged against, nil when none is set, see slo.go
+	slo *sloTracker
+
+	// Devices reported as joined by webhooks and the latencies until their records resolved, nil without webhooks,
+	// see joins.go
+	joins *joinTracker
+
+	// Most recent forward/reverse consistency check, see consistency.go
+	consistencyMu sync.Mutex
+	consistency   *bind.ConsistencyReport
+
+	// Devices by the record names and fully qualified names they were last published under, see devices.go
+	devicesMu   sync.Mutex
+	deviceNames map[string]string
+	deviceFQDNs map[string]string
+
+	// How IPv6 is handled, detected when Run starts, see ipv6.go
+	ipv6 *IPv6Status
 }
 
 // NewApp creates a new application instance
 func NewApp(cfg *config.Config) (*App, error) {
-	// Create Tailscale client
-	var tsClient *tailscale.Client
-	var err error
+	if cfg == nil {
+		return nil, fmt.Errorf("configuration is required")
+	}
 
-	if cfg.Tailscale.APIKey != "" {
-		tsClient, err = tailscale.NewClient(cfg.Tailscale.APIKey, cfg.Tailscale.Tailnet)
-	} else {
-		tsClient, err = tailscale.NewOAuthClient(cfg.Tailscale.ClientID, cfg.Tailscale.ClientSecret, cfg.Tailscale.Tailnet)
+	filter, err := newHostnameFilter(cfg.Tailscale.IncludeHostnames, cfg.Tailscale.ExcludeHostnames)
+	if err != nil {
+		return nil, err
+	}
+	zones, err := newZoneSelectors(cfg.Bind.Zones)
+	if err != nil {
+		return nil, err
+	}
+	addresses, err := newAddressSelectors(&cfg.Tailscale)
+	if err != nil {
+		return nil, err
 	}
+	ipv6Addresses, err := newAddressSelector(nil, cfg.Tailscale.IPv6Addresses, cfg.Tailscale.IPv6Subnets,
+		tailscaleIPv6Range)
 	if err != nil {
-		return nil, fmt.Errorf("creating tailscale client: %w", err)
-	}
-
-	// Create Bind client
-	bindClient, err := bind.NewClient(
-		cfg.Bind.Server,
-		cfg.Bind.Port,
-		cfg.Bind.Zone,
-		cfg.Bind.KeyName,
-		cfg.Bind.KeySecret,
-		cfg.Bind.Algorithm,
-		cfg.Bind.TTL,
-		&cfg.Bind.PTR,
-	)
+		return nil, err
+	}
+	metadata, err := newMetadataTemplate(&cfg.Bind)
 	if err != nil {
-		return nil, fmt.Errorf("creating bind client: %w", err)
+		return nil, err
+	}
+	routes, err := newRouteTemplate(&cfg.Tailscale.Routes)
+	if err != nil {
+		return nil, err
+	}
+	namer, err := newRecordNamer(&cfg.Bind)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := newTargets(cfg)
+	if err != nil {
+		return nil, err
+	}
+	plugins, err := loadPlugins(cfg)
+	if err != nil {
+		return nil, err
 	}
 
 	return &App{
-		config:          cfg,
-		tailscaleClient: tsClient,
-		bindClient:      bindClient,
-		machineChan:     make(chan []tailscale.Machine, 10),
-		recordChan:      make(chan []bind.DNSRecord, 10),
+		config:      cfg,
+		machineChan: make(chan []tailscale.Machine, 10),
+		recordChan:  make(chan []bind.DNSRecord, 10),
+
+		externalChanged: make(chan struct{}, 1),
+		history:         newHistory(cfg.General.HistorySize, namer),
+		filter:          filter,
+		zones:           zones,
+		addresses:       addresses,
+		ipv6Addresses:   ipv6Addresses,
+		namer:           namer,
+		metadata:        metadata,
+		routes:          routes,
+		targets:         targets,
+		plugins:         plugins,
+		quarantine:      newQuarantine(cfg.Bind.Quarantine, cfg.Bind.QuarantineTTL),
+		forwarder:       newForwarder(cfg),
+		slo:             newSLOTracker(cfg.Bind.SLO),
+		joins:           newJoinTracker(cfg.Webhook.Address),
+		pollNow:         make(chan struct{}, 1),
 	}, nil
 }
 
+// getTailscaleClient returns the Tailscale client, constructing it on first use
+func (a *App) getTailscaleClient() (*tailscale.Client, error) {
+	a.clientsMu.Lock()
+	defer a.clientsMu.Unlock()
+
+	if a.tailscaleClient == nil {
+		tsClient, err := tailscale.NewClientFromConfig(&a.config.Tailscale)
+		if err != nil {
+			return nil, fmt.Errorf("creating tailscale client: %w", err)
+		}
+		tsClient.SetClock(a.clock)
+		tsClient.SetOfflinePolicy(a.config.Bind.OfflinePolicy, a.config.Bind.OfflineGracePeriod)
+		tsClient.SetCacheFile(a.config.Tailscale.CacheFile)
+		a.tailscaleClient = tsClient
+	}
+	return a.tailscaleClient, nil
+}
+
+// getProvider returns the configured DNS provider, constructing it on first use
+func (a *App) getProvider() (Provider, error) {
+	a.clientsMu.Lock()
+	defer a.clientsMu.Unlock()
+
+	if a.provider == nil {
+		provider, err := newProvider(a.config)
+		if err != nil {
+			return nil, err
+		}
+		if setter, ok := provider.(clockSetter); ok {
+			setter.SetClock(a.clock)
+		}
+		if tracker, ok := provider.(deviceTracker); ok {
+			tracker.SetDeviceLookup(a.recordDevice)
+		}
+		a.provider = provider
+	}
+	return a.provider, nil
+}
+
 // Run starts the application
 func (a *App) Run(ctx context.Context) error {
 	klog.Info("Starting Tailscale-Bind DDNS application")
+	a.ipv6 = detectIPv6(a.config)
 
-	// Validate Bind connection
-	if err := a.bindClient.ValidateConnection(ctx); err != nil {
-		return fmt.Errorf("bind connection validation failed: %w", err)
+	tsClient, err := a.getTailscaleClient()
+	if err != nil {
+		return err
+	}
+	provider, err := a.getProvider()
+	if err != nil {
+		return err
+	}
+
+	// Validate DNS provider connection
+	if err := provider.ValidateConnection(ctx); err != nil {
+		return fmt.Errorf("dns provider connection validation failed: %w", err)
+	}
+
+	// Start the updates of the targets, whose providers have to be reachable as well
+	if err := a.startTargets(ctx); err != nil {
+		return err
+	}
+
+	// Back-populate the reverse zones before regular updates start
+	if a.config.Bind.PTR.Enabled && a.config.Bind.PTR.Bootstrap {
+		a.bootstrapPTR(ctx, tsClient, provider)
+	}
+
+	// Start the status endpoint if one is configured
+	if a.config.General.StatusAddress != "" {
+		listener, err := listenAddress(a.config.General.StatusAddress)
+		if err != nil {
+			return fmt.Errorf("listening for status requests: %w", err)
+		}
+		a.wg.Add(1)
+		go func() {
+			defer a.wg.Done()
+			a.serveStatus(ctx, listener)
+		}()
+	}
+
+	// Start the gRPC admin API if one is configured
+	if a.config.General.GRPCAddress != "" {
+		if err := a.startAdminAPI(ctx); err != nil {
+			return fmt.Errorf("starting gRPC admin API: %w", err)
+		}
+	}
+
+	// Start the DNS forwarder if one is configured
+	if a.forwarder != nil {
+		pc, listener, err := listenForwarder(a.config.Forwarder.Address)
+		if err != nil {
+			return fmt.Errorf("listening for DNS queries: %w", err)
+		}
+		a.wg.Add(1)
+		go func() {
+			defer a.wg.Done()
+			a.serveForwarder(ctx, pc, listener)
+		}()
+	}
+
+	// Start the webhook receiver if one is configured
+	if a.config.Webhook.Address != "" {
+		listener, err := net.Listen("tcp", a.config.Webhook.Address)
+		if err != nil {
+			return fmt.Errorf("listening for webhook requests: %w", err)
+		}
+		a.wg.Add(1)
+		go func() {
+			defer a.wg.Done()
+			a.serveWebhook(ctx, listener)
+		}()
 	}
 
 	// Start the machine-to-record converter
@@ -78,18 +311,43 @@ func (a *App) Run(ctx context.Context) error {
 		a.convertMachinesToRecords(ctx)
 	}()
 
-	// Start Tailscale polling
+	// Start Tailscale polling, reading the zones at the same time
+	if prefetcher, ok := provider.(zonePrefetcher); ok {
+		tsClient.SetPollHook(prefetcher.PrefetchZones)
+	}
 	a.wg.Add(1)
 	go func() {
 		defer a.wg.Done()
-		a.tailscaleClient.StartPolling(ctx, a.config.Tailscale.PollInterval, a.machineChan)
+		tsClient.StartPolling(ctx, a.config.Tailscale.PollInterval, a.machineChan, a.pollNow)
 	}()
 
-	// Start Bind DDNS updating
+	// Start forward/reverse consistency checks if enabled
+	if a.config.Bind.PTR.Enabled && a.config.Bind.PTR.ConsistencyCheckInterval > 0 {
+		if checker, ok := provider.(consistencyChecker); ok {
+			a.wg.Add(1)
+			go func() {
+				defer a.wg.Done()
+				a.runConsistencyChecks(ctx, checker, a.config.Bind.PTR.ConsistencyCheckInterval)
+			}()
+		} else {
+			klog.Warningf("Provider %s doesn't support consistency checks", a.config.General.Provider)
+		}
+	}
+
+	// Start withdrawing records whose lease ran out if leases are enabled
+	a.startLeases(ctx, "", a.config, provider)
+
+	// Start re-reading the secrets configured as files so that they can be rotated
+	a.startSecretFiles(ctx)
+
+	// Start DNS updating
+	if reporter, ok := provider.(resultReporter); ok {
+		reporter.SetResultHook(a.finishCycle)
+	}
 	a.wg.Add(1)
 	go func() {
 		defer a.wg.Done()
-		a.bindClient.StartUpdating(ctx, a.config.Bind.UpdateInterval, a.recordChan, a.config.General.DryRun)
+		provider.StartUpdating(ctx, a.config.Bind.UpdateInterval, a.recordChan, a.config.General.DryRun)
 	}()
 
 	// Wait for context cancellation
@@ -99,9 +357,16 @@ func (a *App) Run(ctx context.Context) error {
 	// Close channels to signal goroutines to stop
 	close(a.machineChan)
 	close(a.recordChan)
+	a.closeTargets()
 
 	// Wait for all goroutines to finish
 	a.wg.Wait()
+	a.removeOnShutdown()
+	a.closePlugins()
+
+	if err := tsClient.Close(); err != nil {
+		klog.Errorf("Failed to close Tailscale client: %v", err)
+	}
 
 	klog.Info("Application stopped")
 	return nil
@@ -119,22 +384,18 @@ func (a *App) convertMachinesToRecords(ctx context.Context) {
 				return
 			}
 
-			records := a.machinesToRecords(machines)
-			ptrRecords := a.createPTRRecords(machines)
-
-			// Combine A/AAAA and PTR records
-			allRecords := make([]bind.DNSRecord, 0, len(records)+len(ptrRecords))
-			allRecords = append(allRecords, records...)
-			allRecords = append(allRecords, ptrRecords...)
+			a.externalMu.Lock()
+			a.lastMachines, a.polled = machines, true
+			a.externalMu.Unlock()
+			a.history.observe(machines, clock.Or(a.clock).Now())
 
-			if len(allRecords) > 0 {
-				select {
-				case a.recordChan <- allRecords:
-				case <-ctx.Done():
-					return
-				}
+			if !a.publish(ctx, machines, a.pollDuration()) {
+				return
 			}
 
+		case <-a.externalChanged:
+			a.republish(ctx)
+
 		case <-ctx.Done():
 			klog.Info("Machine-to-record converter stopped")
 			return
@@ -142,9 +403,71 @@ func (a *App) convertMachinesToRecords(ctx context.Context) {
 	}
 }
 
+// publish hands the desired records for machines to the provider and the targets unless publishing is paused, fetch is
+// how long getting the machines took. It returns false when the context was cancelled first.
+func (a *App) publish(ctx context.Context, machines []tailscale.Machine, fetch time.Duration) bool {
+	allRecords, timings := a.timedDesiredRecords(machines)
+	timings.Fetch = fetch
+
+	if a.Paused() {
+		klog.V(1).Infof("Publishing paused, holding back %d records", len(allRecords))
+		return true
+	}
+	now := clock.Or(a.clock).Now()
+	if err := a.checkNameCollisions(machines, now); err != nil {
+		klog.Errorf("Not publishing %d records: %v", len(allRecords), err)
+		return true
+	}
+	allRecords = a.quarantine.apply(allRecords, now)
+	a.startCycle(timings)
+
+	a.setDevices(machines)
+
+	// An empty record set is still sent so that records of machines that are all gone get removed
+	select {
+	case a.recordChan <- allRecords:
+		a.setManagedRecords(allRecords)
+	case <-ctx.Done():
+		return false
+	}
+	return a.publishTargets(ctx, machines, now)
+}
+
+// buildRecords converts machines into the full desired record set, combining A/AAAA, CNAME, TXT and PTR records.
+// Machines the hostname filter rejects and skipped Funnel machines get no records.
+func (a *App) buildRecords(machines []tailscale.Machine) []bind.DNSRecord {
+	return a.convertMachines(a.publishedMachines(machines))
+}
+
+// publishedMachines returns the machines that get records, those the hostname filter accepts aside from skipped
+// Funnel machines and machines skipped for their colliding name
+func (a *App) publishedMachines(machines []tailscale.Machine) []tailscale.Machine {
+	return a.withoutNameCollisions(a.withoutSkippedFunnel(a.filter.apply(machines)))
+}
+
+// convertMachines converts machines that passed the filters into A/AAAA, CNAME, TXT and PTR records, passed through
+// the plugins
+func (a *App) convertMachines(machines []tailscale.Machine) []bind.DNSRecord {
+	records := a.machinesToRecords(machines)
+	aliasRecords := a.createAliasRecords(machines)
+	metadataRecords := a.createMetadataRecords(machines)
+	routeRecords := a.createRouteRecords(machines)
+	ptrRecords := a.createPTRRecords(machines)
+
+	allRecords := make([]bind.DNSRecord, 0,
+		len(records)+len(aliasRecords)+len(metadataRecords)+len(routeRecords)+len(ptrRecords))
+	allRecords = append(allRecords, records...)
+	allRecords = append(allRecords, aliasRecords...)
+	allRecords = append(allRecords, metadataRecords...)
+	allRecords = append(allRecords, routeRecords...)
+	allRecords = append(allRecords, ptrRecords...)
+	return a.applyPlugins(machines, allRecords)
+}
+
 // machinesToRecords converts a list of machines to DNS records
 func (a *App) machinesToRecords(machines []tailscale.Machine) []bind.DNSRecord {
 	var records []bind.DNSRecord
+	names := a.namer.names(machines)
 
 	for _, machine := range machines {
 		// Only create records for online machines
@@ -152,39 +475,44 @@ func (a *App) machinesToRecords(machines []tailscale.Machine) []bind.DNSRecord {
 			continue
 		}
 
-		// Use machine name as DNS record name
-		recordName := machine.Name
-		if recordName == "" {
-			recordName = machine.ID
-		}
+		recordName := names[machine.ID]
+		ttl := a.recordTTL(machine)
+		zone := a.machineZone(machine)
 
-		// Sanitize record name for DNS (replace invalid characters)
-		recordName = sanitizeDNSName(recordName)
+		// Funnel machines point at their public hostname instead of their addresses
+		if a.funnelMode(machine) == config.FunnelCNAME {
+			records = append(records, a.funnelRecord(machine, recordName))
+			klog.V(2).Infof("Converted Funnel machine %s (%s) to CNAME record %s -> %s",
+				machine.Name, machine.ID, recordName, machine.Name)
+			continue
+		}
 
-		// Create A record for IPv4 address
-		if machine.IPv4Address != "" {
+		// Create an A record for every selected IPv4 address
+		for _, address := range a.machineIPv4Addresses(machine) {
 			aRecord := bind.DNSRecord{
 				Name:  recordName,
-				Value: machine.IPv4Address,
-				TTL:   uint32(a.config.Bind.TTL.Seconds()),
-				Type:  "A",
+				Value: address,
+				TTL:   ttl,
+				Type:  bind.TypeA,
+				Zone:  zone,
 			}
 			records = append(records, aRecord)
 			klog.V(2).Infof("Converted machine %s (%s) to A record %s -> %s",
-				machine.Name, machine.ID, recordName, machine.IPv4Address)
+				machine.Name, machine.ID, recordName, address)
 		}
 
-		// Create AAAA record for IPv6 address if available
-		if machine.IPv6Address != "" {
+		// Create an AAAA record for every selected IPv6 address
+		for _, address := range a.machineIPv6Addresses(machine) {
 			aaaaRecord := bind.DNSRecord{
 				Name:  recordName,
-				Value: machine.IPv6Address,
-				TTL:   uint32(a.config.Bind.TTL.Seconds()),
-				Type:  "AAAA",
+				Value: address,
+				TTL:   ttl,
+				Type:  bind.TypeAAAA,
+				Zone:  zone,
 			}
 			records = append(records, aaaaRecord)
 			klog.V(2).Infof("Converted machine %s (%s) to AAAA record %s -> %s",
-				machine.Name, machine.ID, recordName, machine.IPv6Address)
+				machine.Name, machine.ID, recordName, address)
 		}
 	}
 
@@ -192,6 +520,62 @@ func (a *App) machinesToRecords(machines []tailscale.Machine) []bind.DNSRecord {
 	return records
 }
 
+// createAliasRecords creates the CNAME records of the configured aliases and of alias tags whose machine is online and
+// has an address. Aliases are matched against the record name of machines and published in the machine's zone. An
+// alias that clashes with the record name of a machine is skipped, since a CNAME can't share its name with other
+// records, and configured aliases take precedence over alias tags.
+func (a *App) createAliasRecords(machines []tailscale.Machine) []bind.DNSRecord {
+	var aliasRecords []bind.DNSRecord
+	names := a.namer.names(machines)
+	aliases := a.tagAliases(machines, names)
+	if len(a.config.Bind.Aliases) == 0 && len(aliases) == 0 {
+		return aliasRecords
+	}
+	for alias, target := range a.config.Bind.Aliases {
+		if tagTarget, ok := aliases[alias]; ok && !strings.EqualFold(tagTarget, target) {
+			klog.V(1).Infof("Ignoring the alias tag of %s for %s: bind.aliases points it at %s", tagTarget, alias,
+				target)
+		}
+		aliases[alias] = target
+	}
+
+	byName := make(map[string]tailscale.Machine, len(names))
+	taken := make(map[string]bool, len(names))
+	for _, machine := range machines {
+		name, ok := names[machine.ID]
+		if !ok || (len(a.machineIPv4Addresses(machine)) == 0 && len(a.machineIPv6Addresses(machine)) == 0) {
+			continue
+		}
+		byName[strings.ToLower(name)] = machine
+		taken[strings.ToLower(a.zoneFQDN(name, a.machineZone(machine)))] = true
+	}
+
+	for _, alias := range slices.Sorted(maps.Keys(aliases)) {
+		target := aliases[alias]
+		machine, ok := byName[strings.ToLower(target)]
+		if !ok {
+			klog.V(1).Infof("Not publishing alias %s: machine %s is offline or unknown", alias, target)
+			continue
+		}
+
+		zone := a.machineZone(machine)
+		if taken[strings.ToLower(a.zoneFQDN(alias, zone))] {
+			klog.Warningf("Not publishing alias %s: a machine already uses the name", alias)
+			continue
+		}
+		aliasRecords = append(aliasRecords, bind.DNSRecord{
+			Name:  alias,
+			Value: a.zoneFQDN(names[machine.ID], zone),
+			TTL:   a.recordTTL(machine),
+			Type:  bind.TypeCNAME,
+			Zone:  zone,
+		})
+	}
+
+	klog.V(1).Infof("Created %d alias records", len(aliasRecords))
+	return aliasRecords
+}
+
 // createPTRRecords creates PTR records for the given machines
 func (a *App) createPTRRecords(machines []tailscale.Machine) []bind.DNSRecord {
 	var ptrRecords []bind.DNSRecord
@@ -200,68 +584,185 @@ func (a *App) createPTRRecords(machines []tailscale.Machine) []bind.DNSRecord {
 		return ptrRecords
 	}
 
+	names := a.namer.names(machines)
+
 	for _, machine := range machines {
-		// Only create PTR records for online machines
-		if !machine.Online {
+		// Only create PTR records for online machines, which Funnel machines can't point at
+		if !machine.Online || a.funnelMode(machine) == config.FunnelCNAME {
 			continue
 		}
+		ptrRecords = append(ptrRecords, a.machinePTRRecords(machine, names[machine.ID])...)
+	}
 
-		// Use machine name as DNS record name
-		recordName := machine.Name
-		if recordName == "" {
-			recordName = machine.ID
-		}
-
-		// Sanitize record name for DNS (replace invalid characters)
-		recordName = sanitizeDNSName(recordName)
+	klog.V(1).Infof("Created %d PTR records", len(ptrRecords))
+	return ptrRecords
+}
 
-		// Create PTR record for IPv4 address
-		if machine.IPv4Address != "" {
-			ptrRecord, err := a.bindClient.CreatePTRRecord(machine.IPv4Address, recordName+"."+a.config.Bind.Zone)
-			if err != nil {
-				klog.Warningf("Failed to create PTR record for IPv4 %s: %v", machine.IPv4Address, err)
-				continue
-			}
-			if ptrRecord != nil {
-				ptrRecords = append(ptrRecords, *ptrRecord)
-			}
+// machinePTRRecords creates the PTR records pointing the addresses of a machine at its record name
+func (a *App) machinePTRRecords(machine tailscale.Machine, recordName string) []bind.DNSRecord {
+	var ptrRecords []bind.DNSRecord
+	ttl := a.recordTTL(machine)
+	hostname := a.zoneFQDN(recordName, a.machineZone(machine))
+
+	// Create a PTR record for every selected IPv4 address
+	for _, address := range a.machineIPv4Addresses(machine) {
+		ptrRecord, err := bind.NewPTRRecord(&a.config.Bind.PTR, ttl, address, hostname)
+		if err != nil {
+			klog.Warningf("Failed to create PTR record for IPv4 %s: %v", address, err)
+			continue
 		}
-
-		// Create PTR record for IPv6 address if available
-		if machine.IPv6Address != "" {
-			ptrRecord, err := a.bindClient.CreatePTRRecord(machine.IPv6Address, recordName+"."+a.config.Bind.Zone)
-			if err != nil {
-				klog.Warningf("Failed to create PTR record for IPv6 %s: %v", machine.IPv6Address, err)
-				continue
-			}
-			if ptrRecord != nil {
-				ptrRecords = append(ptrRecords, *ptrRecord)
-			}
+		if ptrRecord != nil {
+			ptrRecords = append(ptrRecords, *ptrRecord)
 		}
 	}
 
-	klog.V(1).Infof("Created %d PTR records", len(ptrRecords))
+	// Create a PTR record for every selected IPv6 address
+	for _, address := range a.machineIPv6Addresses(machine) {
+		ptrRecord, err := bind.NewPTRRecord(&a.config.Bind.PTR, ttl, address, hostname)
+		if err != nil {
+			klog.Warningf("Failed to create PTR record for IPv6 %s: %v", address, err)
+			continue
+		}
+		if ptrRecord != nil {
+			ptrRecords = append(ptrRecords, *ptrRecord)
+		}
+	}
 	return ptrRecords
 }
 
+// zoneStatusReporter is implemented by providers that track the outcome of updates per zone
+type zoneStatusReporter interface {
+	ZoneStatuses() map[string]bind.ZoneStatus
+}
+
+// shardReporter is implemented by providers that move the records of crowded reverse zones to finer zones
+type shardReporter interface {
+	ShardedZones() []string
+}
+
+// serverHealthReporter is implemented by providers that track the health of the servers they send to
+type serverHealthReporter interface {
+	ServerHealth() []bind.ServerHealth
+}
+
+// ConfigStatus returns the status that can be derived from configuration alone, without constructing any clients
+func ConfigStatus(cfg *config.Config) map[string]interface{} {
+	return map[string]interface{}{
+		"tailscale_tailnet": cfg.Tailscale.Tailnet,
+		"bind_server":       cfg.Bind.Server,
+		"bind_zone":         cfg.Bind.Zone,
+		"dry_run":           cfg.General.DryRun,
+		"log_level":         cfg.General.LogLevel,
+		"ipv6_enabled":      cfg.IPv6Enabled(),
+	}
+}
+
 // GetStatus returns the current status of the application
 func (a *App) GetStatus() map[string]interface{} {
-	return map[string]interface{}{
-		"tailscale_tailnet": a.config.Tailscale.Tailnet,
-		"bind_server":       a.config.Bind.Server,
-		"bind_zone":         a.config.Bind.Zone,
-		"dry_run":           a.config.General.DryRun,
-		"log_level":         a.config.General.LogLevel,
+	status := ConfigStatus(a.config)
+
+	a.clientsMu.Lock()
+	provider := a.provider
+	tsClient := a.tailscaleClient
+	a.clientsMu.Unlock()
+
+	if tsClient != nil {
+		status["tailscale_api_usage"] = tsClient.Usage()
+		if cache := tsClient.CacheStatus(); cache != nil {
+			status["machine_cache"] = cache
+		}
+	}
+
+	if reporter, ok := provider.(zoneStatusReporter); ok {
+		if zones := reporter.ZoneStatuses(); len(zones) > 0 {
+			status["zones"] = zones
+		}
+	}
+
+	if reporter, ok := provider.(shardReporter); ok {
+		if zones := reporter.ShardedZones(); len(zones) > 0 {
+			status["sharded_reverse_zones"] = zones
+		}
+	}
+
+	// Server health is only interesting once there is a server to fail over to
+	if reporter, ok := provider.(serverHealthReporter); ok {
+		if servers := reporter.ServerHealth(); len(servers) > 1 {
+			status["servers"] = servers
+		}
+	}
+
+	if report := a.ConsistencyReport(); report != nil {
+		status["consistency"] = report
+	}
+
+	if targets := a.targetStatuses(); len(targets) > 0 {
+		status["targets"] = targets
+	}
+
+	if cycle := a.LastCycle(); cycle != nil {
+		status["last_cycle"] = cycle
+	}
+
+	records, lastSync := a.ManagedRecords()
+	if !lastSync.IsZero() {
+		status["last_sync"] = lastSync
+	}
+	counts := map[string]int{"total": len(records)}
+	for _, record := range records {
+		counts[string(record.Key().Type)]++
+	}
+	status["record_counts"] = counts
+	status["failed_updates"] = a.failedUpdates.Load()
+	if errs := a.recentUpdateErrors(); len(errs) > 0 {
+		status["recent_errors"] = errs
+	}
+	if machines := a.machineStatuses(records); len(machines) > 0 {
+		status["machines"] = machines
+	}
+
+	if a.ipv6 != nil {
+		status["ipv6"] = a.ipv6
 	}
+
+	if slo := a.slo.status(clock.Or(a.clock).Now()); slo != nil {
+		status["slo"] = slo
+	}
+
+	if joins := a.joins.status(); joins != nil {
+		status["join_latency"] = joins
+	}
+
+	if quarantined := a.quarantine.size(); quarantined > 0 {
+		status["quarantined_names"] = quarantined
+	}
+
+	if external := a.ExternalRecords(); len(external) > 0 {
+		counts := make(map[string]int, len(external))
+		for source, records := range external {
+			counts[source] = len(records)
+		}
+		status["external_records"] = counts
+	}
+
+	return status
 }
 
-// sanitizeDNSName sanitizes a string to be a valid DNS record name
+// sanitizeDNSName sanitizes a string to be a valid DNS record name. Unicode hostnames are converted to their punycode
+// (xn--) form, and characters that can't be represented are replaced with hyphens. Only ASCII letters are lowercased,
+// so that the result never depends on Unicode case mappings.
 func sanitizeDNSName(name string) string {
 	// Extract only the hostname (leftmost part) from FQDN
 	// Split by dots and take only the first part
 	parts := strings.Split(name, ".")
 	hostname := parts[0]
 
+	if !isASCII(hostname) {
+		if punycode, ok := punycodeHostname(hostname); ok {
+			return punycode
+		}
+	}
+
 	// Replace invalid DNS characters with hyphens
 	// DNS names can only contain letters, digits, hyphens, and dots
 	reg := regexp.MustCompile(`[^a-zA-Z0-9.-]`)
@@ -280,5 +781,44 @@ func sanitizeDNSName(name string) string {
 	}
 
 	// Convert to lowercase for consistency
-	return strings.ToLower(sanitized)
+	return asciiLower(sanitized)
+}
+
+// punycodeHostname converts a hostname with non-ASCII characters into its punycode form. Invalid ASCII characters are
+// replaced with hyphens first, like sanitizeDNSName does. Hostnames IDNA doesn't allow are reported as not convertible.
+func punycodeHostname(hostname string) (string, bool) {
+	hostname = strings.Map(func(r rune) rune {
+		if r < utf8.RuneSelf && r != '-' && !('a' <= r && r <= 'z') && !('A' <= r && r <= 'Z') && !('0' <= r && r <= '9') {
+			return '-'
+		}
+		return r
+	}, hostname)
+	hostname = strings.Trim(regexp.MustCompile(`-+`).ReplaceAllString(hostname, "-"), "-")
+
+	punycode, err := idna.Lookup.ToASCII(hostname)
+	if err != nil || punycode == "" || !isASCII(punycode) {
+		klog.V(1).Infof("Hostname %q has no punycode form, replacing its non-ASCII characters: %v", hostname, err)
+		return "", false
+	}
+	return asciiLower(punycode), true
+}
+
+// isASCII reports whether s consists of ASCII characters only
+func isASCII(s string) bool {
+	for i := range len(s) {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// asciiLower lowercases the ASCII letters of s and leaves every other byte alone
+func asciiLower(s string) string {
+	return strings.Map(func(r rune) rune {
+		if 'A' <= r && r <= 'Z' {
+			return r + ('a' - 'A')
+		}
+		return r
+	}, s)
 }
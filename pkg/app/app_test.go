@@ -1,11 +1,24 @@
 package app
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
 	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/aauren/tailscale-bind-ddns/pkg/snmp"
 	"github.com/aauren/tailscale-bind-ddns/pkg/tailscale"
+	"github.com/aauren/tailscale-bind-ddns/pkg/web"
+	"github.com/aauren/tailscale-bind-ddns/pkg/webhook"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -111,23 +124,60 @@ func TestNewApp(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			app, err := NewApp(tt.config)
+			require.NoError(t, err)
+			require.NotNil(t, app)
+			assert.Equal(t, tt.config, app.config)
+			assert.NotNil(t, app.machineChan)
+			assert.NotNil(t, app.recordChan)
+
+			// Clients are only constructed on first use
+			assert.Nil(t, app.tailscaleClient)
+			assert.Nil(t, app.provider)
+
+			_, tsErr := app.getTailscaleClient()
+			_, providerErr := app.getProvider()
 
 			if tt.wantErr {
-				assert.Error(t, err)
-				assert.Nil(t, app)
+				assert.Error(t, errors.Join(tsErr, providerErr))
 			} else {
-				assert.NoError(t, err)
-				assert.NotNil(t, app)
-				assert.Equal(t, tt.config, app.config)
+				assert.NoError(t, tsErr)
+				assert.NoError(t, providerErr)
 				assert.NotNil(t, app.tailscaleClient)
-				assert.NotNil(t, app.bindClient)
-				assert.NotNil(t, app.machineChan)
-				assert.NotNil(t, app.recordChan)
+				assert.NotNil(t, app.provider)
 			}
 		})
 	}
 }
 
+func TestNewAppOnlyBuildsRequiredClients(t *testing.T) {
+	// A configuration without Tailscale credentials can still construct its DNS provider
+	app, err := NewApp(&config.Config{
+		Bind: config.BindConfig{
+			Server:    "dns.example.com",
+			Port:      53,
+			Zone:      "test.example.com",
+			KeyName:   "test-key",
+			KeySecret: "test-secret",
+		},
+	})
+	require.NoError(t, err)
+
+	provider, err := app.getProvider()
+	require.NoError(t, err)
+	assert.NotNil(t, provider)
+	assert.Nil(t, app.tailscaleClient)
+
+	_, err = app.getTailscaleClient()
+	assert.Error(t, err)
+}
+
+func TestNewProviderUnknown(t *testing.T) {
+	_, err := newProvider(&config.Config{General: config.GeneralConfig{Provider: "does-not-exist"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not available in this build")
+	assert.Contains(t, AvailableProviders(), config.ProviderBind)
+}
+
 func TestMachinesToRecords(t *testing.T) {
 	app := &App{
 		config: &config.Config{
@@ -308,40 +358,2241 @@ func TestConvertMachinesToRecords(t *testing.T) {
 	assert.Equal(t, uint32(300), records[0].TTL)
 }
 
-func TestAppRun(t *testing.T) {
-	// This test would require mocking the clients
-	// For now, we'll test the basic structure
+func TestDeviceDNSPreferences(t *testing.T) {
+	app := &App{
+		config: &config.Config{
+			Bind: config.BindConfig{
+				Zone: "test.example.com",
+				TTL:  300 * time.Second,
+				PTR: config.PTRConfig{
+					Enabled:    true,
+					IPv4Zone:   "64.100.in-addr.arpa",
+					IPv4Subnet: "100.64.0.0/10",
+				},
+			},
+		},
+	}
 
-	config := &config.Config{
+	machines := []tailscale.Machine{
+		{ID: "n1", Name: "laptop", IPv4Address: "100.64.1.1", Online: true, DNSName: "Dev Box", DNSTTL: time.Minute},
+		{ID: "n2", Name: "builder", IPv4Address: "100.64.1.2", Online: true},
+		// Requesting another machine's name must not take over its records
+		{ID: "n3", Name: "intruder", IPv4Address: "100.64.1.3", Online: true, DNSName: "builder"},
+	}
+
+	records := app.buildRecords(machines)
+	byValue := make(map[string]bind.DNSRecord)
+	for _, record := range records {
+		byValue[string(record.Type)+" "+record.Value] = record
+	}
+
+	assert.Equal(t, "dev-box", byValue["A 100.64.1.1"].Name)
+	assert.Equal(t, uint32(60), byValue["A 100.64.1.1"].TTL)
+	assert.Equal(t, "builder", byValue["A 100.64.1.2"].Name)
+	assert.Equal(t, uint32(300), byValue["A 100.64.1.2"].TTL)
+	assert.Equal(t, "intruder", byValue["A 100.64.1.3"].Name)
+	assert.Equal(t, "1.1.64.100.in-addr.arpa.", byValue["PTR dev-box.test.example.com"].Name)
+	assert.Equal(t, uint32(60), byValue["PTR dev-box.test.example.com"].TTL)
+}
+
+func TestRecordTTL(t *testing.T) {
+	app := &App{
+		config: &config.Config{
+			Bind: config.BindConfig{
+				Zone:   "test.example.com",
+				TTL:    300 * time.Second,
+				MinTTL: 30 * time.Second,
+				MaxTTL: time.Hour,
+				TTLOverrides: map[string]time.Duration{
+					"builder":    10 * time.Second,
+					"tag:web":    120 * time.Second,
+					"tag:static": 30 * time.Minute,
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		machine  tailscale.Machine
+		expected uint32
+	}{
+		{
+			name:     "global TTL",
+			machine:  tailscale.Machine{Name: "laptop"},
+			expected: 300,
+		},
+		{
+			name:     "hostname override",
+			machine:  tailscale.Machine{Name: "Builder.tail1234.ts.net", Tags: []string{"tag:web"}, DNSTTL: time.Minute},
+			expected: 10,
+		},
+		{
+			name:     "lowest tag override",
+			machine:  tailscale.Machine{Name: "web-1", Tags: []string{"tag:static", "tag:web", "tag:ttl-45"}},
+			expected: 120,
+		},
+		{
+			name:     "TTL tag in seconds",
+			machine:  tailscale.Machine{Name: "db", Tags: []string{"tag:ttl-60"}, DNSTTL: 10 * time.Minute},
+			expected: 60,
+		},
+		{
+			name:     "TTL tag as duration",
+			machine:  tailscale.Machine{Name: "db", Tags: []string{"tag:ttl-5m"}},
+			expected: 300,
+		},
+		{
+			name:     "invalid TTL tag",
+			machine:  tailscale.Machine{Name: "db", Tags: []string{"tag:ttl-soon"}},
+			expected: 300,
+		},
+		{
+			name:     "TTL tag clamped to the minimum",
+			machine:  tailscale.Machine{Name: "db", Tags: []string{"tag:ttl-5"}},
+			expected: 30,
+		},
+		{
+			name:     "device owner TTL clamped to the maximum",
+			machine:  tailscale.Machine{Name: "db", DNSTTL: 24 * time.Hour},
+			expected: 3600,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, app.recordTTL(tt.machine))
+		})
+	}
+}
+
+func TestAddressSelection(t *testing.T) {
+	router := tailscale.Machine{
+		ID: "n1", Name: "router", Online: true, Tags: []string{"tag:router"},
+		IPv4Address:   "192.168.1.1",
+		IPv4Addresses: []string{"192.168.1.1", "100.64.0.1", "10.0.0.1"},
+	}
+
+	tests := []struct {
+		name     string
+		config   config.TailscaleConfig
+		machine  tailscale.Machine
+		expected []string
+	}{
+		{
+			name:     "first by default",
+			machine:  router,
+			expected: []string{"192.168.1.1"},
+		},
+		{
+			name:     "all",
+			config:   config.TailscaleConfig{IPv4Addresses: config.AddressSelectionAll},
+			machine:  router,
+			expected: []string{"192.168.1.1", "100.64.0.1", "10.0.0.1"},
+		},
+		{
+			name:     "tailscale range only",
+			config:   config.TailscaleConfig{IPv4Addresses: config.AddressSelectionTailscale},
+			machine:  router,
+			expected: []string{"100.64.0.1"},
+		},
+		{
+			name: "first preferred subnet holding an address",
+			config: config.TailscaleConfig{
+				IPv4Addresses: config.AddressSelectionSubnet,
+				IPv4Subnets:   []string{"172.16.0.0/12", "10.0.0.0/8", "192.168.0.0/16"},
+			},
+			machine:  router,
+			expected: []string{"10.0.0.1"},
+		},
+		{
+			name: "no preferred subnet holds an address",
+			config: config.TailscaleConfig{
+				IPv4Addresses: config.AddressSelectionSubnet,
+				IPv4Subnets:   []string{"172.16.0.0/12"},
+			},
+			machine:  router,
+			expected: []string{"192.168.1.1"},
+		},
+		{
+			name: "policy of a tag",
+			config: config.TailscaleConfig{
+				IPv4Addresses: config.AddressSelectionTailscale,
+				AddressPolicies: []config.AddressPolicy{
+					{Tags: []string{"tag:router"}, Selection: config.AddressSelectionAll},
+				},
+			},
+			machine:  router,
+			expected: []string{"192.168.1.1", "100.64.0.1", "10.0.0.1"},
+		},
+		{
+			name:     "machine without address list",
+			config:   config.TailscaleConfig{IPv4Addresses: config.AddressSelectionAll},
+			machine:  tailscale.Machine{ID: "n2", Name: "laptop", IPv4Address: "100.64.0.2", Online: true},
+			expected: []string{"100.64.0.2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, err := NewApp(&config.Config{
+				Tailscale: tt.config,
+				Bind: config.BindConfig{
+					Zone: "test.example.com",
+					TTL:  300 * time.Second,
+					PTR:  config.PTRConfig{Enabled: true, IPv4Subnet: "0.0.0.0/0", IPv4SubnetSize: 8},
+				},
+			})
+			require.NoError(t, err)
+
+			var aRecords, ptrRecords []string
+			for _, record := range app.buildRecords([]tailscale.Machine{tt.machine}) {
+				switch record.Type {
+				case "A":
+					aRecords = append(aRecords, record.Value)
+				case "PTR":
+					ptrRecords = append(ptrRecords, record.Name)
+				}
+			}
+			assert.Equal(t, tt.expected, aRecords)
+			assert.Len(t, ptrRecords, len(tt.expected))
+		})
+	}
+}
+
+func TestIPv6AddressSelection(t *testing.T) {
+	router := tailscale.Machine{
+		ID: "n1", Name: "router", Online: true, Tags: []string{"tag:router"},
+		IPv6Address:   "fd7a:115c:a1e0::1",
+		IPv6Addresses: []string{"fd7a:115c:a1e0::1", "2001:db8::1", "fd00::1"},
+	}
+
+	tests := []struct {
+		name     string
+		config   config.TailscaleConfig
+		expected []string
+	}{
+		{
+			name:     "first by default",
+			expected: []string{"fd7a:115c:a1e0::1"},
+		},
+		{
+			name:     "all",
+			config:   config.TailscaleConfig{IPv6Addresses: config.AddressSelectionAll},
+			expected: []string{"fd7a:115c:a1e0::1", "2001:db8::1", "fd00::1"},
+		},
+		{
+			name: "tailscale range only",
+			config: config.TailscaleConfig{
+				IPv6Addresses: config.AddressSelectionTailscale,
+				// Address policies don't apply to IPv6 addresses
+				AddressPolicies: []config.AddressPolicy{{Tags: []string{"tag:router"}, Selection: "all"}},
+			},
+			expected: []string{"fd7a:115c:a1e0::1"},
+		},
+		{
+			name: "first preferred subnet holding an address",
+			config: config.TailscaleConfig{
+				IPv6Addresses: config.AddressSelectionSubnet,
+				IPv6Subnets:   []string{"2001:db8::/32"},
+			},
+			expected: []string{"2001:db8::1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, err := NewApp(&config.Config{
+				Tailscale: tt.config,
+				Bind: config.BindConfig{
+					Zone: "test.example.com",
+					TTL:  300 * time.Second,
+					PTR: config.PTRConfig{
+						Enabled: true, IPv6Enabled: true, IPv6Subnet: "::/0", IPv6SubnetSize: 64,
+					},
+				},
+			})
+			require.NoError(t, err)
+
+			var aaaaRecords, ptrRecords []string
+			for _, record := range app.buildRecords([]tailscale.Machine{router}) {
+				switch record.Type {
+				case "AAAA":
+					aaaaRecords = append(aaaaRecords, record.Value)
+				case "PTR":
+					ptrRecords = append(ptrRecords, record.Name)
+				}
+			}
+			assert.Equal(t, tt.expected, aaaaRecords)
+			assert.Len(t, ptrRecords, len(tt.expected))
+		})
+	}
+}
+
+func TestMachinePTRRecords(t *testing.T) {
+	tests := []struct {
+		name     string
+		machine  tailscale.Machine
+		expected []string
+	}{
+		{
+			name: "every address",
+			machine: tailscale.Machine{ID: "n1", Name: "router", Online: true,
+				IPv4Addresses: []string{"100.64.0.1", "10.0.0.1"}, IPv6Addresses: []string{"fd7a:115c:a1e0::1"}},
+			expected: []string{"1.0.64.100.in-addr.arpa.", "1.0.0.10.in-addr.arpa.",
+				"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.e.1.a.c.5.1.1.a.7.d.f.ip6.arpa."},
+		},
+		{
+			name: "invalid IPv4 address doesn't drop the others",
+			machine: tailscale.Machine{ID: "n1", Name: "router", Online: true,
+				IPv4Addresses: []string{"not an address", "10.0.0.1"}, IPv6Addresses: []string{"fd7a:115c:a1e0::1"}},
+			expected: []string{"1.0.0.10.in-addr.arpa.",
+				"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.e.1.a.c.5.1.1.a.7.d.f.ip6.arpa."},
+		},
+		{
+			name: "invalid IPv6 address doesn't drop the others",
+			machine: tailscale.Machine{ID: "n1", Name: "router", Online: true,
+				IPv6Addresses: []string{"not an address", "fd7a:115c:a1e0::1"}},
+			expected: []string{"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.e.1.a.c.5.1.1.a.7.d.f.ip6.arpa."},
+		},
+	}
+
+	app, err := NewApp(&config.Config{
 		Tailscale: config.TailscaleConfig{
-			APIKey:       "test-api-key",
-			Tailnet:      "test.example.com",
-			PollInterval: 30 * time.Second,
+			IPv4Addresses: config.AddressSelectionAll,
+			IPv6Addresses: config.AddressSelectionAll,
 		},
 		Bind: config.BindConfig{
-			Server:         "dns.example.com",
-			Port:           53,
-			Zone:           "test.example.com",
-			KeyName:        "test-key",
-			KeySecret:      "test-secret",
-			Algorithm:      "hmac-sha256",
-			TTL:            300 * time.Second,
-			UpdateInterval: 60 * time.Second,
-		},
-		General: config.GeneralConfig{
-			LogLevel: "info",
-			DryRun:   true,
+			Zone: "test.example.com",
+			TTL:  300 * time.Second,
+			PTR: config.PTRConfig{Enabled: true, IPv4Subnet: "0.0.0.0/0", IPv4SubnetSize: 8,
+				IPv6Enabled: true, IPv6Subnet: "::/0", IPv6SubnetSize: 64},
 		},
+	})
+	require.NoError(t, err)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var names []string
+			for _, record := range app.machinePTRRecords(tt.machine, "router") {
+				names = append(names, record.Name)
+			}
+			assert.Equal(t, tt.expected, names)
+		})
 	}
+}
 
-	app, err := NewApp(config)
+func TestIPv6Disabled(t *testing.T) {
+	disabled := false
+	cfg := &config.Config{
+		Bind: config.BindConfig{
+			Server:  "[fd00::53]:53",
+			Servers: []string{"192.0.2.53"},
+			Zone:    "test.example.com",
+			TTL:     300 * time.Second,
+			PTR: config.PTRConfig{
+				Enabled: true, IPv4Subnet: "100.64.0.0/10", IPv4Zone: "64.100.in-addr.arpa.",
+				IPv6Enabled: true, IPv6Subnet: "::/0", IPv6SubnetSize: 64,
+			},
+		},
+		IPv6: config.IPv6Config{Enabled: &disabled},
+	}
+	app, err := NewApp(cfg)
 	require.NoError(t, err)
-	require.NotNil(t, app)
 
-	// Test that the app can be created and has the expected structure
-	assert.Equal(t, config, app.config)
-	assert.NotNil(t, app.tailscaleClient)
-	assert.NotNil(t, app.bindClient)
-	assert.NotNil(t, app.machineChan)
-	assert.NotNil(t, app.recordChan)
+	// Neither AAAA nor IPv6 PTR records are published, nor aliases of devices with only IPv6 addresses
+	app.config.Bind.Aliases = map[string]string{"v6": "v6only"}
+	records := app.buildRecords([]tailscale.Machine{
+		{
+			ID: "n1", Name: "laptop", Online: true,
+			IPv4Address: "100.64.0.1", IPv6Address: "fd7a:115c:a1e0::1",
+		},
+		{ID: "n2", Name: "v6only", Online: true, IPv6Address: "fd7a:115c:a1e0::2"},
+	})
+	var types []bind.RecordType
+	for _, record := range records {
+		types = append(types, record.Type)
+	}
+	assert.ElementsMatch(t, []bind.RecordType{bind.TypeA, bind.TypePTR}, types)
+
+	// The startup detection reports the IPv6 servers whether or not the host has a route to them
+	route := hasIPv6Route
+	t.Cleanup(func() { hasIPv6Route = route })
+	hasIPv6Route = func() bool { return false }
+	assert.Equal(t, &IPv6Status{Servers: []string{"[fd00::53]:53"}}, detectIPv6(cfg))
+
+	cfg.IPv6.Enabled = nil
+	assert.Equal(t, &IPv6Status{Enabled: true, AAAA: true, PTR: true, Servers: []string{"[fd00::53]:53"}},
+		detectIPv6(cfg))
+}
+
+func TestRouteRecords(t *testing.T) {
+	machines := []tailscale.Machine{
+		{
+			ID: "n1", Name: "router-a", IPv4Address: "100.64.0.1", IPv6Address: "fd7a:115c:a1e0::1", Online: true,
+			AdvertisedRoutes: []string{"192.168.1.0/24", "0.0.0.0/0", "10.0.0.0/8", "fd00::/64"},
+			ApprovedRoutes:   []string{"192.168.1.0/24", "0.0.0.0/0", "fd00::/64"},
+		},
+		{
+			ID: "n2", Name: "router-b", IPv4Address: "100.64.0.2", Online: true,
+			AdvertisedRoutes: []string{"192.168.1.0/24"}, ApprovedRoutes: []string{"192.168.1.0/24"},
+		},
+		{
+			ID: "n3", Name: "router-c", IPv4Address: "100.64.0.3",
+			AdvertisedRoutes: []string{"172.16.0.0/12"}, ApprovedRoutes: []string{"172.16.0.0/12"},
+		},
+		// A device named like a route keeps its name
+		{ID: "n4", Name: "route-fd00-64", IPv4Address: "100.64.0.4", Online: true},
+	}
+
+	tests := []struct {
+		name     string
+		routes   config.RoutesConfig
+		expected []string
+	}{
+		{
+			name: "disabled",
+		},
+		{
+			name:   "approved routes",
+			routes: config.RoutesConfig{Enabled: true},
+			expected: []string{
+				"route-192-168-1-0-24 A 100.64.0.1",
+				"route-192-168-1-0-24 A 100.64.0.2",
+				"route-192-168-1-0-24 AAAA fd7a:115c:a1e0::1",
+			},
+		},
+		{
+			name:   "advertised routes",
+			routes: config.RoutesConfig{Enabled: true, Selection: config.RouteSelectionAdvertised},
+			expected: []string{
+				"route-10-0-0-0-8 A 100.64.0.1",
+				"route-10-0-0-0-8 AAAA fd7a:115c:a1e0::1",
+				"route-192-168-1-0-24 A 100.64.0.1",
+				"route-192-168-1-0-24 A 100.64.0.2",
+				"route-192-168-1-0-24 AAAA fd7a:115c:a1e0::1",
+			},
+		},
+		{
+			name:   "name template",
+			routes: config.RoutesConfig{Enabled: true, NameTemplate: "{{ .Name }}.{{ .Route }}"},
+			expected: []string{
+				"router-a.192.168.1.0-24 A 100.64.0.1",
+				"router-a.192.168.1.0-24 AAAA fd7a:115c:a1e0::1",
+				"router-a.fd00-64 A 100.64.0.1",
+				"router-a.fd00-64 AAAA fd7a:115c:a1e0::1",
+				"router-b.192.168.1.0-24 A 100.64.0.2",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, err := NewApp(&config.Config{
+				Tailscale: config.TailscaleConfig{Routes: tt.routes},
+				Bind:      config.BindConfig{Zone: "test.example.com", TTL: 300 * time.Second},
+			})
+			require.NoError(t, err)
+
+			var records []string
+			for _, record := range app.createRouteRecords(machines) {
+				records = append(records, record.Name+" "+string(record.Type)+" "+record.Value)
+			}
+			assert.Equal(t, tt.expected, records)
+		})
+	}
+
+	_, err := NewApp(&config.Config{
+		Tailscale: config.TailscaleConfig{Routes: config.RoutesConfig{Enabled: true, NameTemplate: "{{ .Route"}},
+	})
+	assert.Error(t, err)
+}
+
+func TestMetadataRecords(t *testing.T) {
+	machines := []tailscale.Machine{
+		{
+			ID: "n1", Name: "laptop", IPv4Address: "100.64.0.1", Online: true, OS: "linux",
+			Tags: []string{"tag:dev", "tag:server"}, LastSeen: time.Date(2025, 1, 1, 12, 34, 56, 0, time.UTC),
+		},
+		{ID: "n2", Name: "phone", IPv4Address: "100.64.0.2", OS: "iOS"},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected []bind.DNSRecord
+	}{
+		{
+			name: "default template",
+			expected: []bind.DNSRecord{{
+				Name:  "laptop",
+				Value: "id=n1 os=linux tags=tag:dev,tag:server last_seen=2025-01-01T12:00:00Z",
+				TTL:   300,
+				Type:  "TXT",
+			}},
+		},
+		{
+			name:     "custom template",
+			template: `{{.Name}} runs {{.OS}}`,
+			expected: []bind.DNSRecord{{Name: "laptop", Value: "laptop runs linux", TTL: 300, Type: "TXT"}},
+		},
+		{
+			name:     "template failing to render",
+			template: `{{truncate .LastSeen "soon"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, err := NewApp(&config.Config{
+				Bind: config.BindConfig{
+					Zone:             "test.example.com",
+					TTL:              300 * time.Second,
+					PublishMetadata:  true,
+					MetadataTemplate: tt.template,
+				},
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, app.createMetadataRecords(machines))
+		})
+	}
+
+	_, err := NewApp(&config.Config{Bind: config.BindConfig{PublishMetadata: true, MetadataTemplate: "{{.ID"}})
+	assert.Error(t, err)
+}
+
+func TestNameTemplate(t *testing.T) {
+	laptop := tailscale.Machine{
+		ID: "n1", Name: "laptop.tail1234.ts.net", IPv4Address: "100.64.0.1", Online: true,
+		User: "Alice.Smith@example.com",
+	}
+	server := tailscale.Machine{ID: "n2", Name: "db", IPv4Address: "100.64.0.2", Online: true, Tags: []string{"tag:db"}}
+
+	tests := []struct {
+		name     string
+		template string
+		machine  tailscale.Machine
+		expected string
+	}{
+		{
+			name:     "no template",
+			machine:  laptop,
+			expected: "laptop",
+		},
+		{
+			name:     "suffix",
+			template: "{{ .Name }}-ts",
+			machine:  laptop,
+			expected: "laptop-ts",
+		},
+		{
+			name:     "user subdomain",
+			template: "{{ .Name }}.{{ .User }}",
+			machine:  laptop,
+			expected: "laptop.alice.smith",
+		},
+		{
+			name:     "user subdomain of a tagged device",
+			template: "{{ .Name }}.{{ .User }}",
+			machine:  server,
+			expected: "db",
+		},
+		{
+			name:     "invalid characters",
+			template: "{{ .Name }} (via {{ .OS }})",
+			machine:  laptop,
+			expected: "laptop-via",
+		},
+		{
+			name:     "template rendering nothing",
+			template: "{{ .User }}",
+			machine:  server,
+			expected: "db",
+		},
+		{
+			name:     "template failing to render",
+			template: "{{ .Missing }}",
+			machine:  laptop,
+			expected: "laptop",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, err := NewApp(&config.Config{
+				Bind: config.BindConfig{Zone: "test.example.com", TTL: 300 * time.Second, NameTemplate: tt.template},
+			})
+			require.NoError(t, err)
+
+			records := app.buildRecords([]tailscale.Machine{tt.machine})
+			require.Len(t, records, 1)
+			assert.Equal(t, tt.expected, records[0].Name)
+		})
+	}
+
+	_, err := NewApp(&config.Config{Bind: config.BindConfig{Zone: "test.example.com", NameTemplate: "{{ .Name"}})
+	assert.Error(t, err)
+}
+
+func TestUserSubdomains(t *testing.T) {
+	app, err := NewApp(&config.Config{
+		Bind: config.BindConfig{
+			Zone: "test.example.com",
+			TTL:  300 * time.Second,
+			UserSubdomains: config.UserSubdomainsConfig{
+				Enabled: true,
+				Users:   []config.UserSubdomainConfig{{User: "Bob@Example.com", Label: "Platform.Bob"}},
+			},
+			Aliases: map[string]string{"nas": "storage.platform.bob"},
+		},
+	})
+	require.NoError(t, err)
+
+	machines := []tailscale.Machine{
+		{ID: "n1", Name: "laptop.tail1234.ts.net", IPv4Address: "100.64.0.1", Online: true,
+			User: "alice.smith@example.com"},
+		{ID: "n2", Name: "laptop.tail1234.ts.net", IPv4Address: "100.64.0.2", Online: true, User: "bob@example.com"},
+		{ID: "n3", Name: "storage", IPv4Address: "100.64.0.3", Online: true, User: "bob@example.com"},
+		{ID: "n4", Name: "db", IPv4Address: "100.64.0.4", Online: true, Tags: []string{"tag:db"}},
+	}
+
+	records := make(map[string]string)
+	for _, record := range app.buildRecords(machines) {
+		if record.Type != "PTR" {
+			records[string(record.Type)+" "+record.Name] = record.Value
+		}
+	}
+	assert.Equal(t, map[string]string{
+		"A laptop.alice-smith":   "100.64.0.1",
+		"A laptop.platform.bob":  "100.64.0.2",
+		"A storage.platform.bob": "100.64.0.3",
+		"A db":                   "100.64.0.4",
+		"CNAME nas":              "storage.platform.bob.test.example.com",
+	}, records, "devices of different owners don't collide, tagged devices stay in the zone")
+}
+
+func TestNameCollisions(t *testing.T) {
+	machines := []tailscale.Machine{
+		{ID: "n2", Name: "laptop", IPv4Address: "100.64.0.2", Online: true},
+		{ID: "n1", Name: "Laptop.", IPv4Address: "100.64.0.1", Online: true},
+		{ID: "n3", Name: "laptop-2", IPv4Address: "100.64.0.3", Online: true},
+		{ID: "nAbCdEfGh", Name: "LAPTOP", IPv4Address: "100.64.0.4", Online: true},
+		{ID: "n5", Name: "server", IPv4Address: "100.64.0.5", Online: true},
+	}
+
+	tests := []struct {
+		strategy string
+		expected map[string]string // Addresses by record name
+		wantErr  bool
+	}{
+		{
+			// The machine with the lowest ID keeps the name, suffixes in use by other machines are skipped
+			strategy: config.NameCollisionSuffix,
+			expected: map[string]string{
+				"laptop": "100.64.0.1", "laptop-3": "100.64.0.2", "laptop-2": "100.64.0.3", "laptop-4": "100.64.0.4",
+				"server": "100.64.0.5",
+			},
+		},
+		{
+			strategy: config.NameCollisionID,
+			expected: map[string]string{
+				"laptop": "100.64.0.1", "laptop-n2": "100.64.0.2", "laptop-2": "100.64.0.3",
+				"laptop-nabcde": "100.64.0.4", "server": "100.64.0.5",
+			},
+		},
+		{
+			strategy: config.NameCollisionSkip,
+			expected: map[string]string{"laptop": "100.64.0.1", "laptop-2": "100.64.0.3", "server": "100.64.0.5"},
+		},
+		{
+			strategy: config.NameCollisionFail,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			app, err := NewApp(&config.Config{
+				Bind: config.BindConfig{Zone: "test.example.com", TTL: 300 * time.Second, NameCollisions: tt.strategy},
+			})
+			require.NoError(t, err)
+
+			err = app.checkNameCollisions(machines, time.Now())
+			assert.Equal(t, 1, app.Gauges().NameCollisions)
+			if tt.wantErr {
+				assert.ErrorContains(t, err, "laptop (n1, n2, nAbCdEfGh)")
+				return
+			}
+			require.NoError(t, err)
+
+			records := make(map[string]string)
+			for _, record := range app.buildRecords(machines) {
+				records[record.Name] = record.Value
+			}
+			assert.Equal(t, tt.expected, records)
+		})
+	}
+
+	// explain tells which machine kept the name
+	app, err := NewApp(&config.Config{
+		Bind: config.BindConfig{
+			Zone: "test.example.com", TTL: 300 * time.Second, NameCollisions: config.NameCollisionSkip,
+		},
+	})
+	require.NoError(t, err)
+	steps := make(map[string][]FilterStep)
+	for _, explanation := range app.explain(machines, time.Now()) {
+		steps[explanation.ID] = explanation.Steps
+	}
+	assert.Contains(t, steps["n1"], FilterStep{
+		Filter: "name", Passed: true, Detail: "keeps laptop, shared with n2, nAbCdEfGh",
+	})
+	assert.Contains(t, steps["n2"], FilterStep{Filter: "name", Detail: "laptop already used by n1"})
+	assert.False(t, slices.ContainsFunc(steps["n5"], func(step FilterStep) bool { return step.Filter == "name" }))
+}
+
+func TestSanitizeDNSName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "laptop.tailnet.ts.net", want: "laptop"},
+		{name: "KITCHEN-Pi", want: "kitchen-pi"},
+		{name: "web_1", want: "web-1"},
+		{name: "--", want: "machine"},
+		// Unicode hostnames get their punycode form, independent of locale specific case mappings
+		{name: "Müller-PC.tailnet.ts.net", want: "xn--mller-pc-65a"},
+		{name: "İSTANBUL", want: "xn--istanbul-o0e"},
+		{name: "café laptop", want: "xn--caf-laptop-d7a"},
+		// Hostnames IDNA rejects lose their non-ASCII characters
+		{name: "a\u200db", want: "a-b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, sanitizeDNSName(tt.name))
+		})
+	}
+}
+
+func TestHostnameFilter(t *testing.T) {
+	machines := []tailscale.Machine{
+		{ID: "n1", Name: "web-1.tailnet.ts.net", IPv4Address: "100.64.0.1", Online: true},
+		{ID: "n2", Name: "web-2.tailnet.ts.net", IPv4Address: "100.64.0.2", Online: true},
+		{ID: "n3", Name: "db-1.tailnet.ts.net", IPv4Address: "100.64.0.3", Online: true},
+		{ID: "n4", Name: "phone", IPv4Address: "100.64.0.4", Online: true},
+	}
+
+	tests := []struct {
+		name      string
+		include   []string
+		exclude   []string
+		wantNames []string
+	}{
+		{
+			name:      "no filters publishes everything",
+			wantNames: []string{"web-1", "web-2", "db-1", "phone"},
+		},
+		{
+			name:      "include only",
+			include:   []string{"^web-", "^db-"},
+			wantNames: []string{"web-1", "web-2", "db-1"},
+		},
+		{
+			name:      "exclude only",
+			exclude:   []string{"^phone$"},
+			wantNames: []string{"web-1", "web-2", "db-1"},
+		},
+		{
+			name:      "exclude wins over include",
+			include:   []string{"^web-"},
+			exclude:   []string{"-2$"},
+			wantNames: []string{"web-1"},
+		},
+		{
+			name:    "patterns match the hostname without the tailnet domain",
+			exclude: []string{"ts\\.net"},
+			// Nothing matches, as the domain is stripped before matching
+			wantNames: []string{"web-1", "web-2", "db-1", "phone"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, err := NewApp(&config.Config{
+				Tailscale: config.TailscaleConfig{IncludeHostnames: tt.include, ExcludeHostnames: tt.exclude},
+				Bind:      config.BindConfig{Zone: "test.example.com", TTL: 300 * time.Second},
+			})
+			require.NoError(t, err)
+
+			var names []string
+			for _, record := range app.buildRecords(machines) {
+				names = append(names, record.Name)
+			}
+			assert.Equal(t, tt.wantNames, names)
+		})
+	}
+
+	_, err := NewApp(&config.Config{Tailscale: config.TailscaleConfig{IncludeHostnames: []string{"("}}})
+	assert.Error(t, err)
+}
+
+func TestExplain(t *testing.T) {
+	app, err := NewApp(&config.Config{
+		Tailscale: config.TailscaleConfig{
+			IncludeHostnames: []string{"^web-", "^db-"},
+			ExcludeHostnames: []string{"-staging$"},
+		},
+		Bind: config.BindConfig{
+			Zone:  "ts.example.com",
+			TTL:   300 * time.Second,
+			Zones: []config.ZoneConfig{{Name: "servers.example.com", Tags: []string{"tag:server"}}},
+		},
+	})
+	require.NoError(t, err)
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	machines := []tailscale.Machine{
+		{ID: "n1", Name: "web-1.tail1234.ts.net", IPv4Address: "100.64.0.1", Online: true, LastSeen: now},
+		{
+			ID: "n2", Name: "db-1.tail1234.ts.net", IPv4Address: "100.64.0.2", Online: true, LastSeen: now,
+			Tags: []string{"tag:server"},
+		},
+		{ID: "n3", Name: "web-2-staging", IPv4Address: "100.64.0.3", Online: true, LastSeen: now},
+		{ID: "n4", Name: "laptop", IPv4Address: "100.64.0.4", Online: true, LastSeen: now},
+		{ID: "n5", Name: "web-3", IPv4Address: "100.64.0.5", LastSeen: now.Add(-time.Hour)},
+		{ID: "n6", Name: "web-4", Online: true, LastSeen: now},
+	}
+
+	failed := func(explanation MachineExplanation) []string {
+		var filters []string
+		for _, step := range explanation.Steps {
+			if !step.Passed {
+				filters = append(filters, step.Filter+": "+step.Detail)
+			}
+		}
+		return filters
+	}
+
+	explanations := app.explain(machines, now)
+	require.Len(t, explanations, 6)
+	byID := make(map[string]MachineExplanation, len(explanations))
+	for _, explanation := range explanations {
+		byID[explanation.ID] = explanation
+	}
+
+	assert.True(t, byID["n1"].Published)
+	assert.Equal(t, "web-1", byID["n1"].RecordName)
+	assert.Equal(t, "ts.example.com", byID["n1"].Zone)
+	assert.Empty(t, failed(byID["n1"]))
+
+	assert.True(t, byID["n2"].Published)
+	assert.Equal(t, "servers.example.com", byID["n2"].Zone)
+	assert.Contains(t, byID["n2"].Steps, FilterStep{
+		Filter: "zone", Passed: true, Detail: "zone servers.example.com, selected by tag tag:server",
+	})
+
+	assert.False(t, byID["n3"].Published)
+	assert.Empty(t, byID["n3"].RecordName)
+	assert.Equal(t, []string{"exclude_hostnames: matches -staging$"}, failed(byID["n3"]))
+
+	assert.Equal(t, []string{"include_hostnames: matches none of the patterns"}, failed(byID["n4"]))
+	assert.Equal(t, []string{"online: offline by the last_seen heuristic, last seen 1h0m0s ago"}, failed(byID["n5"]))
+	assert.Equal(t, []string{"addresses: no addresses"}, failed(byID["n6"]))
+}
+
+func TestMaxRecordAge(t *testing.T) {
+	app, err := NewApp(&config.Config{
+		Bind: config.BindConfig{Zone: "ts.example.com", TTL: 300 * time.Second, MaxRecordAge: time.Hour},
+	})
+	require.NoError(t, err)
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	machines := []tailscale.Machine{
+		{ID: "n1", Name: "web-1", IPv4Address: "100.64.0.1", Online: true, LastSeen: now.Add(-time.Minute)},
+		{ID: "n2", Name: "web-2", IPv4Address: "100.64.0.2", Online: true, LastSeen: now.Add(-2 * time.Hour)},
+		{ID: "n3", Name: "web-3", IPv4Address: "100.64.0.3", Online: true},
+	}
+
+	// Machines still reported online but not seen for longer than the maximum age are withdrawn
+	var ids []string
+	for _, machine := range app.withoutUnseen(machines, now) {
+		ids = append(ids, machine.ID)
+	}
+	assert.Equal(t, []string{"n1", "n3"}, ids)
+
+	assert.Equal(t, FilterStep{Filter: "max_record_age", Passed: true, Detail: "seen within 1h0m0s"},
+		app.explainMaxAge(machines[0], now))
+	assert.Equal(t, FilterStep{Filter: "max_record_age", Detail: "not seen for more than 1h0m0s"},
+		app.explainMaxAge(machines[1], now))
+	assert.Equal(t, FilterStep{Filter: "max_record_age", Passed: true, Detail: "never seen"},
+		app.explainMaxAge(machines[2], now))
+}
+
+func TestQuarantine(t *testing.T) {
+	app, err := NewApp(&config.Config{
+		Bind: config.BindConfig{
+			Zone:          "ts.example.com",
+			TTL:           300 * time.Second,
+			Quarantine:    time.Hour,
+			QuarantineTTL: 30 * time.Second,
+		},
+	})
+	require.NoError(t, err)
+
+	web := bind.DNSRecord{Name: "web", Value: "100.64.0.1", TTL: 300, Type: "A"}
+	laptop := []bind.DNSRecord{
+		{Name: "laptop", Value: "100.64.0.2", TTL: 300, Type: "A"},
+		{Name: "laptop", Value: "owner=alice", TTL: 300, Type: "TXT"},
+	}
+	blog := bind.DNSRecord{Name: "blog", Value: "blog.tail1234.ts.net", TTL: 300, Type: "CNAME"}
+	all := append([]bind.DNSRecord{web, blog}, laptop...)
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, all, app.quarantine.apply(all, now))
+	assert.Equal(t, 0, app.quarantine.size())
+
+	// Withdrawn names stay published with a low TTL, TXT records give way to the tombstone and CNAMEs get none
+	tombstone := bind.DNSRecord{
+		Name:  "laptop",
+		Value: "tailscale-bind-ddns: withdrawn 2025-01-01T12:01:00Z, removed after 2025-01-01T13:01:00Z",
+		TTL:   30,
+		Type:  "TXT",
+	}
+	quarantined := []bind.DNSRecord{
+		web,
+		{Name: "blog", Value: "blog.tail1234.ts.net", TTL: 30, Type: "CNAME"},
+		{Name: "laptop", Value: "100.64.0.2", TTL: 30, Type: "A"},
+		tombstone,
+	}
+	assert.Equal(t, quarantined, app.quarantine.apply([]bind.DNSRecord{web}, now.Add(time.Minute)))
+	assert.Equal(t, 2, app.quarantine.size())
+	assert.Equal(t, quarantined, app.quarantine.apply([]bind.DNSRecord{web}, now.Add(30*time.Minute)))
+
+	// A name desired again leaves the quarantine, the others are deleted once the period is over
+	assert.Equal(t, []bind.DNSRecord{web, blog, {Name: "laptop", Value: "100.64.0.2", TTL: 30, Type: "A"}, tombstone},
+		app.quarantine.apply([]bind.DNSRecord{web, blog}, now.Add(45*time.Minute)))
+	assert.Equal(t, 1, app.quarantine.size())
+	assert.Equal(t, []bind.DNSRecord{web, blog},
+		app.quarantine.apply([]bind.DNSRecord{web, blog}, now.Add(time.Hour+time.Minute)))
+	assert.Equal(t, 0, app.quarantine.size())
+
+	// Without a quarantine, records are passed through
+	app, err = NewApp(&config.Config{Bind: config.BindConfig{Zone: "ts.example.com", TTL: 300 * time.Second}})
+	require.NoError(t, err)
+	assert.Nil(t, app.quarantine)
+	assert.Equal(t, []bind.DNSRecord{web}, app.quarantine.apply([]bind.DNSRecord{web}, now))
+}
+
+func TestCycleTimings(t *testing.T) {
+	app, err := NewApp(&config.Config{Bind: config.BindConfig{Zone: "ts.example.com", TTL: 300 * time.Second}})
+	require.NoError(t, err)
+	assert.Nil(t, app.LastCycle())
+
+	started := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	result := &bind.SyncResult{
+		Started:  started,
+		Duration: 300 * time.Millisecond,
+		Zones: []bind.ZoneResult{
+			{Zone: "ts.example.com", Duration: 200 * time.Millisecond, Verify: 50 * time.Millisecond},
+			{Zone: "64.100.in-addr.arpa", Duration: 100 * time.Millisecond},
+		},
+	}
+
+	// The stages before the update are completed by the provider's result
+	app.startCycle(CycleTimings{Fetch: time.Second, Filter: 10 * time.Millisecond, Convert: 20 * time.Millisecond})
+	app.finishCycle(result)
+	want := &CycleTimings{
+		Finished: started.Add(300 * time.Millisecond),
+		Total:    1330 * time.Millisecond,
+		Fetch:    time.Second,
+		Filter:   10 * time.Millisecond,
+		Convert:  20 * time.Millisecond,
+		Update:   300 * time.Millisecond,
+		Zones: []ZoneTiming{
+			{Zone: "ts.example.com", Update: 200 * time.Millisecond, Verify: 50 * time.Millisecond},
+			{Zone: "64.100.in-addr.arpa", Update: 100 * time.Millisecond},
+		},
+	}
+	assert.Equal(t, want, app.LastCycle())
+	assert.Equal(t, "1.33s: fetch 1s, filter 10ms, convert 20ms, update 300ms, zone ts.example.com 200ms "+
+		"(verify 50ms), zone 64.100.in-addr.arpa 100ms", want.String())
+	assert.Equal(t, want, app.GetStatus()["last_cycle"])
+
+	// A retried update without a new cycle only reports the update
+	app.finishCycle(result)
+	assert.Equal(t, 300*time.Millisecond, app.LastCycle().Total)
+	assert.Zero(t, app.LastCycle().Fetch)
+}
+
+func TestGauges(t *testing.T) {
+	app, err := NewApp(&config.Config{Bind: config.BindConfig{Zone: "ts.example.com", TTL: 300 * time.Second}})
+	require.NoError(t, err)
+	clk := clock.NewFake(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC))
+	app.clock = clk
+
+	gauges := app.Gauges()
+	assert.Equal(t, Gauges{SecondsSinceSync: -1}, gauges)
+
+	app.setManagedRecords([]bind.DNSRecord{
+		{Name: "laptop", Value: "100.64.0.1", TTL: 300, Type: "A"},
+		{Name: "server", Value: "100.64.0.2", TTL: 300, Type: "A"},
+	})
+	app.completeCycle(CycleTimings{}, &bind.SyncResult{Zones: []bind.ZoneResult{
+		{Zone: "ts.example.com", Error: "update refused: REFUSED"},
+		{Zone: "64.100.in-addr.arpa"},
+	}})
+	app.Pause()
+	clk.Advance(90 * time.Second)
+
+	gauges = app.Gauges()
+	assert.Equal(t, Gauges{ManagedRecords: 2, SecondsSinceSync: 90, FailedUpdates: 1, Paused: true}, gauges)
+	assert.Equal(t, []snmp.Variable{
+		{OID: ".1.3.6.1.4.1.8072.9999.9999.1.1", Type: snmp.TypeGauge, Value: "0"},
+		{OID: ".1.3.6.1.4.1.8072.9999.9999.1.2", Type: snmp.TypeGauge, Value: "0"},
+		{OID: ".1.3.6.1.4.1.8072.9999.9999.1.3", Type: snmp.TypeGauge, Value: "2"},
+		{OID: ".1.3.6.1.4.1.8072.9999.9999.1.4", Type: snmp.TypeInteger, Value: "90"},
+		{OID: ".1.3.6.1.4.1.8072.9999.9999.1.5", Type: snmp.TypeCounter, Value: "1"},
+		{OID: ".1.3.6.1.4.1.8072.9999.9999.1.6", Type: snmp.TypeGauge, Value: "0"},
+		{OID: ".1.3.6.1.4.1.8072.9999.9999.1.7", Type: snmp.TypeGauge, Value: "0"},
+		{OID: ".1.3.6.1.4.1.8072.9999.9999.1.8", Type: snmp.TypeGauge, Value: "0"},
+		{OID: ".1.3.6.1.4.1.8072.9999.9999.1.9", Type: snmp.TypeInteger, Value: "1"},
+		{OID: ".1.3.6.1.4.1.8072.9999.9999.1.10", Type: snmp.TypeInteger, Value: "0"},
+		{OID: ".1.3.6.1.4.1.8072.9999.9999.1.11", Type: snmp.TypeGauge, Value: "0"},
+		{OID: ".1.3.6.1.4.1.8072.9999.9999.1.12", Type: snmp.TypeGauge, Value: "0"},
+	}, gauges.SNMPVariables("1.3.6.1.4.1.8072.9999.9999.1"))
+}
+
+func TestSLO(t *testing.T) {
+	app, err := NewApp(&config.Config{Bind: config.BindConfig{
+		Zone: "ts.example.com",
+		TTL:  300 * time.Second,
+		SLO:  config.SLOConfig{Window: time.Hour, SuccessRate: 0.95, P95Latency: time.Second},
+	}})
+	require.NoError(t, err)
+	clk := clock.NewFake(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC))
+	app.clock = clk
+
+	// Without updates the objectives are met
+	assert.Equal(t, &SLOStatus{Window: time.Hour, SuccessRate: 1}, app.GetStatus()["slo"])
+
+	update := func(duration time.Duration, failure string) {
+		app.completeCycle(CycleTimings{}, &bind.SyncResult{Zones: []bind.ZoneResult{
+			{Zone: "ts.example.com", Duration: duration, Error: failure},
+		}})
+	}
+	for range 19 {
+		update(100*time.Millisecond, "")
+	}
+	update(3*time.Second, "")
+	slo := app.slo.status(clk.Now())
+	assert.Equal(t, 20, slo.Updates)
+	assert.Equal(t, 100*time.Millisecond, slo.P95Latency)
+	assert.False(t, slo.Violated)
+
+	// Slow and failing updates violate both objectives
+	update(3*time.Second, "update refused: REFUSED")
+	update(3*time.Second, "update refused: REFUSED")
+	slo = app.slo.status(clk.Now())
+	assert.Equal(t, 3*time.Second, slo.P95Latency)
+	assert.InDelta(t, 20.0/22, slo.SuccessRate, 1e-9)
+	assert.Equal(t, []string{"success rate 0.9091 below 0.95", "p95 latency 3s above 1s"}, slo.Violations)
+	assert.Equal(t, clk.Now(), slo.ViolatedSince)
+	assert.True(t, app.Gauges().SLOViolated)
+
+	// Dry runs aren't judged
+	app.completeCycle(CycleTimings{}, &bind.SyncResult{DryRun: true, Zones: []bind.ZoneResult{{Zone: "ts.example.com"}}})
+	assert.Equal(t, 22, app.slo.status(clk.Now()).Updates)
+
+	// Once the bad updates left the window the objectives are met again
+	clk.Advance(time.Hour + time.Second)
+	update(100*time.Millisecond, "")
+	slo = app.slo.status(clk.Now())
+	assert.Equal(t, 1, slo.Updates)
+	assert.False(t, slo.Violated)
+	assert.True(t, slo.ViolatedSince.IsZero())
+	assert.False(t, app.Gauges().SLOViolated)
+
+	// Without objectives nothing is tracked
+	app, err = NewApp(&config.Config{Bind: config.BindConfig{Zone: "ts.example.com", TTL: 300 * time.Second}})
+	require.NoError(t, err)
+	assert.NotContains(t, app.GetStatus(), "slo")
+}
+
+func TestDashboard(t *testing.T) {
+	app, err := NewApp(&config.Config{
+		Tailscale: config.TailscaleConfig{ExcludeHostnames: []string{"printer"}},
+		Bind: config.BindConfig{
+			Zone: "ts.example.com",
+			TTL:  300 * time.Second,
+			PTR:  config.PTRConfig{Enabled: true, IPv4Subnet: "100.64.0.0/10", IPv4SubnetSize: 16},
+		},
+	})
+	require.NoError(t, err)
+	clk := clock.NewFake(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC))
+	app.clock = clk
+
+	machines := []tailscale.Machine{
+		{ID: "n1", Name: "laptop.tailnet.ts.net", IPv4Address: "100.64.0.1", IPv4Addresses: []string{"100.64.0.1"},
+			Online: true},
+		{ID: "n2", Name: "printer", IPv4Address: "100.64.0.2", IPv4Addresses: []string{"100.64.0.2"}, Online: true},
+	}
+	app.lastMachines = machines
+	app.setManagedRecords([]bind.DNSRecord{
+		{Name: "laptop", Value: "100.64.0.1", TTL: 300, Type: "A"},
+		{Name: "1.0.64.100.in-addr.arpa.", Value: "laptop.ts.example.com", TTL: 300, Type: "PTR"},
+	})
+
+	dashboard := app.Dashboard()
+	assert.Equal(t, []web.Machine{
+		{ID: "n1", Name: "laptop.tailnet.ts.net", Online: true, Addresses: []string{"100.64.0.1"},
+			RecordName: "laptop", Zone: "ts.example.com"},
+		{ID: "n2", Name: "printer", Online: true, Addresses: []string{"100.64.0.2"}},
+	}, dashboard.Machines, "filtered machines get no record name")
+	assert.Equal(t, web.RecordPending, dashboard.Records[0].Status, "no update covered the records yet")
+
+	app.completeCycle(CycleTimings{}, &bind.SyncResult{Started: clk.Now(), Zones: []bind.ZoneResult{
+		{Zone: "ts.example.com"},
+		{Zone: "64.100.in-addr.arpa", Error: "update refused: REFUSED"},
+	}})
+	dashboard = app.Dashboard()
+	assert.Equal(t, []web.Record{
+		{Name: "laptop", Type: "A", Value: "100.64.0.1", TTL: 300, Zone: "ts.example.com", Status: web.RecordSynced},
+		{Name: "1.0.64.100.in-addr.arpa.", Type: "PTR", Value: "laptop.ts.example.com", TTL: 300,
+			Zone: "64.100.in-addr.arpa", Status: web.RecordFailed, Detail: "update refused: REFUSED"},
+	}, dashboard.Records)
+	assert.Equal(t, []web.Error{{Time: clk.Now(), Source: "zone 64.100.in-addr.arpa",
+		Message: "update refused: REFUSED"}}, dashboard.Errors)
+
+	// Skipped records report why
+	app.completeCycle(CycleTimings{}, &bind.SyncResult{Started: clk.Now(), Zones: []bind.ZoneResult{{
+		Zone: "ts.example.com",
+		Skipped: []bind.SkippedRecord{{
+			Record: bind.DNSRecord{Name: "laptop", Value: "100.64.0.1", TTL: 300, Type: "A"},
+			Reason: bind.SkipForeignOwner,
+		}},
+	}}})
+	dashboard = app.Dashboard()
+	assert.Equal(t, web.RecordSkipped, dashboard.Records[0].Status)
+	assert.Equal(t, bind.SkipForeignOwner, dashboard.Records[0].Detail)
+	assert.Equal(t, web.RecordPending, dashboard.Records[1].Status, "zones the update didn't cover are pending")
+	assert.Len(t, dashboard.Errors, 1)
+
+	// The status server serves the page
+	rec := httptest.NewRecorder()
+	app.statusHandler(false).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, DashboardPath, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "1.0.64.100.in-addr.arpa.")
+}
+
+func TestStatusRecordState(t *testing.T) {
+	app, err := NewApp(&config.Config{
+		Bind: config.BindConfig{
+			Zone: "ts.example.com",
+			TTL:  300 * time.Second,
+			PTR:  config.PTRConfig{Enabled: true, IPv4Subnet: "100.64.0.0/10", IPv4SubnetSize: 16},
+		},
+	})
+	require.NoError(t, err)
+	clk := clock.NewFake(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC))
+	app.clock = clk
+
+	machines := []tailscale.Machine{
+		{ID: "n1", Name: "laptop", IPv4Address: "100.64.0.1", Online: true},
+		{ID: "n2", Name: "server", IPv4Address: "100.64.0.2", Online: true},
+		{ID: "n3", Name: "phone", Online: true},
+	}
+	app.lastMachines = machines
+	app.setDevices(machines)
+	app.setManagedRecords(app.buildRecords(machines))
+	app.completeCycle(CycleTimings{}, &bind.SyncResult{Started: clk.Now(), Zones: []bind.ZoneResult{
+		{Zone: "ts.example.com"},
+		{Zone: "64.100.in-addr.arpa", Error: "update refused: REFUSED", Skipped: []bind.SkippedRecord{{
+			Record: bind.DNSRecord{Name: "2.0.64.100.in-addr.arpa.", Value: "server.ts.example.com", TTL: 300,
+				Type: "PTR"},
+			Reason: bind.SkipForeignOwner,
+		}}},
+	}})
+
+	status := app.GetStatus()
+	assert.Equal(t, clk.Now(), status["last_sync"])
+	assert.Equal(t, map[string]int{"total": 4, "A": 2, "PTR": 2}, status["record_counts"])
+	assert.Equal(t, uint64(1), status["failed_updates"])
+	assert.Len(t, status["recent_errors"], 1)
+	assert.Equal(t, []MachineStatus{
+		{ID: "n1", Name: "laptop", Online: true, RecordName: "laptop", Records: 2, State: web.RecordFailed},
+		{ID: "n3", Name: "phone", Online: true, RecordName: "phone", State: MachineUnpublished},
+		{ID: "n2", Name: "server", Online: true, RecordName: "server", Records: 2, State: web.RecordSkipped},
+	}, status["machines"])
+}
+
+// resolvingProvider serves the records whose names are in served
+type resolvingProvider struct {
+	Provider
+	served map[string]bool
+}
+
+func (p *resolvingProvider) Resolves(_ context.Context, record bind.DNSRecord) (bool, error) {
+	return p.served[record.Name], nil
+}
+
+func TestJoinLatency(t *testing.T) {
+	app, err := NewApp(&config.Config{
+		Bind:    config.BindConfig{Zone: "ts.example.com", TTL: 300 * time.Second},
+		Webhook: config.WebhookConfig{Address: "127.0.0.1:0", Secret: "secret"},
+	})
+	require.NoError(t, err)
+	clk := clock.NewFake(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC))
+	app.clock = clk
+	provider := &resolvingProvider{served: map[string]bool{}}
+	app.provider = provider
+
+	app.observeJoin(webhook.Join{DeviceName: "Laptop.tailnet.ts.net", Created: clk.Now()})
+	app.observeJoin(webhook.Join{DeviceName: "phone.tailnet.ts.net", Created: clk.Now()})
+	assert.Equal(t, &JoinLatencyStatus{Pending: 2}, app.GetStatus()["join_latency"])
+
+	machines := []tailscale.Machine{
+		{ID: "n1", Name: "laptop.tailnet.ts.net", IPv4Address: "100.64.0.1", Online: true},
+		{ID: "n2", Name: "phone.tailnet.ts.net", IPv4Address: "100.64.0.2", Online: true},
+	}
+	app.lastMachines = machines
+	app.setDevices(machines)
+	app.setManagedRecords(app.buildRecords(machines))
+
+	// Devices are only measured once all their records resolve, and never by dry runs
+	clk.Advance(3 * time.Second)
+	provider.served["laptop"] = true
+	app.finishCycle(&bind.SyncResult{DryRun: true})
+	assert.Equal(t, 2, app.joins.status().Pending)
+	app.finishCycle(&bind.SyncResult{})
+	assert.Equal(t, &JoinLatencyStatus{Measured: 1, Pending: 1, Last: 3 * time.Second, P50: 3 * time.Second,
+		P95: 3 * time.Second, Max: 3 * time.Second}, app.joins.status())
+	assert.Equal(t, int64(3000), app.Gauges().JoinLatencyP95)
+
+	// Devices whose records don't resolve in time are given up
+	clk.Advance(joinTimeout)
+	app.finishCycle(&bind.SyncResult{})
+	status := app.joins.status()
+	assert.Equal(t, 1, status.TimedOut)
+	assert.Zero(t, status.Pending)
+
+	// Without webhooks nothing is tracked
+	app, err = NewApp(&config.Config{Bind: config.BindConfig{Zone: "ts.example.com", TTL: 300 * time.Second}})
+	require.NoError(t, err)
+	assert.NotContains(t, app.GetStatus(), "join_latency")
+}
+
+func TestList(t *testing.T) {
+	app, err := NewApp(&config.Config{
+		Tailscale: config.TailscaleConfig{ExcludeHostnames: []string{"-staging$"}},
+		Bind: config.BindConfig{
+			Zone:    "ts.example.com",
+			TTL:     300 * time.Second,
+			PTR:     config.PTRConfig{Enabled: true, IPv4Subnet: "100.64.0.0/10", IPv4SubnetSize: 16},
+			Zones:   []config.ZoneConfig{{Name: "servers.example.com", Tags: []string{"tag:server"}}},
+			Aliases: map[string]string{"www": "web"},
+		},
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+	machines := []tailscale.Machine{
+		{ID: "n1", Name: "web.tail1234.ts.net", IPv4Address: "100.64.0.1", Online: true, LastSeen: now},
+		{ID: "n2", Name: "db", IPv4Address: "100.64.0.2", Online: true, LastSeen: now, Tags: []string{"tag:server"}},
+		{ID: "n3", Name: "web-staging", IPv4Address: "100.64.0.3", Online: true, LastSeen: now},
+		{ID: "n4", Name: "laptop", IPv4Address: "100.64.0.4", LastSeen: now.Add(-time.Hour)},
+	}
+
+	// Machines are sorted by name, unpublished ones with the first stage they failed
+	listing := app.list(machines, now)
+	assert.Equal(t, []ListedMachine{
+		{ID: "n2", Name: "db", Online: true, Addresses: []string{"100.64.0.2"}, Published: true,
+			FQDN: "db.servers.example.com"},
+		{ID: "n4", Name: "laptop", Addresses: []string{"100.64.0.4"},
+			Reason: "online: offline by the last_seen heuristic, last seen 1h0m0s ago"},
+		{ID: "n3", Name: "web-staging", Online: true, Addresses: []string{"100.64.0.3"},
+			Reason: "exclude_hostnames: matches -staging$"},
+		{ID: "n1", Name: "web.tail1234.ts.net", Online: true, Addresses: []string{"100.64.0.1"}, Published: true,
+			FQDN: "web.ts.example.com"},
+	}, listing.Machines)
+
+	// Records are fully qualified and tied to their machine
+	assert.ElementsMatch(t, []ListedRecord{
+		{Name: "web.ts.example.com", Type: "A", Value: "100.64.0.1", TTL: 300, Zone: "ts.example.com",
+			Machine: "web.tail1234.ts.net"},
+		{Name: "db.servers.example.com", Type: "A", Value: "100.64.0.2", TTL: 300, Zone: "servers.example.com",
+			Machine: "db"},
+		{Name: "www.ts.example.com", Type: "CNAME", Value: "web.ts.example.com", TTL: 300, Zone: "ts.example.com",
+			Machine: "web.tail1234.ts.net"},
+		{Name: "1.0.64.100.in-addr.arpa.", Type: "PTR", Value: "web.ts.example.com", TTL: 300,
+			Machine: "web.tail1234.ts.net"},
+		{Name: "2.0.64.100.in-addr.arpa.", Type: "PTR", Value: "db.servers.example.com", TTL: 300, Machine: "db"},
+	}, listing.Records)
+}
+
+// zoneProvider serves the records of its most recent update
+type zoneProvider struct {
+	Provider
+	served  map[string]bool
+	updates [][]bind.DNSRecord
+}
+
+func (p *zoneProvider) UpdateRecords(_ context.Context, records []bind.DNSRecord, dryRun bool) (*bind.SyncResult,
+	error) {
+	p.updates = append(p.updates, records)
+	if !dryRun {
+		p.served = make(map[string]bool)
+		for _, record := range records {
+			p.served[record.Name] = true
+		}
+	}
+	return &bind.SyncResult{DryRun: dryRun}, nil
+}
+
+func (p *zoneProvider) Resolves(_ context.Context, record bind.DNSRecord) (bool, error) {
+	return p.served[record.Name], nil
+}
+
+func TestMigrateZone(t *testing.T) {
+	app, err := NewApp(&config.Config{
+		Bind: config.BindConfig{
+			Zone:      "old.example.com",
+			TTL:       300 * time.Second,
+			StateFile: "/var/lib/tailscale-bind-ddns/state.json",
+			PTR:       config.PTRConfig{Enabled: true, IPv4Subnet: "100.64.0.0/10", IPv4SubnetSize: 16},
+		},
+	})
+	require.NoError(t, err)
+	machines := []tailscale.Machine{
+		{ID: "n1", Name: "laptop", IPv4Address: "100.64.0.1", Online: true},
+		{ID: "n2", Name: "phone", IPv4Address: "100.64.0.2"},
+	}
+	zoneApps := func() (*App, *zoneProvider, *App, *zoneProvider) {
+		fromApp, err := app.zoneApp("old.example.com")
+		require.NoError(t, err)
+		toApp, err := app.zoneApp("new.example.com")
+		require.NoError(t, err)
+		from := &zoneProvider{served: map[string]bool{"laptop": true, "phone": true}}
+		to := &zoneProvider{}
+		fromApp.provider, toApp.provider = from, to
+		return fromApp, from, toApp, to
+	}
+
+	// Zone providers neither read the state file nor keep records they were handed
+	fromApp, from, toApp, to := zoneApps()
+	assert.Equal(t, "new.example.com", toApp.config.Bind.Zone)
+	assert.Empty(t, toApp.config.Bind.StateFile)
+	assert.True(t, fromApp.config.Bind.RemoveStale)
+	assert.Equal(t, "/var/lib/tailscale-bind-ddns/state.json", app.config.Bind.StateFile)
+
+	// The new zone gets the records of online machines, PTR records pointing to it, and the old zone loses the
+	// records of every machine
+	migration, err := migrateZone(context.Background(), machines, fromApp, toApp, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, &ZoneMigration{From: "old.example.com", To: "new.example.com", Published: 2, Withdrawn: 2,
+		Verified: true}, migration)
+	assert.Equal(t, [][]bind.DNSRecord{{
+		{Name: "laptop", Value: "100.64.0.1", TTL: 300, Type: "A"},
+		{Name: "1.0.64.100.in-addr.arpa.", Value: "laptop.new.example.com", TTL: 300, Type: "PTR"},
+	}}, to.updates)
+	assert.Equal(t, [][]bind.DNSRecord{{
+		{Name: "laptop", Value: "100.64.0.1", TTL: 300, Type: "A"},
+		{Name: "phone", Value: "100.64.0.2", TTL: 300, Type: "A"},
+	}, nil}, from.updates)
+	assert.Empty(t, from.served)
+
+	// The old zone is left alone while the new records don't resolve
+	fromApp, from, toApp, _ = zoneApps()
+	toApp.provider = &unservedProvider{}
+	_, err = migrateZone(context.Background(), machines, fromApp, toApp, 10*time.Millisecond)
+	require.ErrorContains(t, err, "verifying records in zone new.example.com: 1 records don't resolve after 10ms")
+	assert.Empty(t, from.updates)
+}
+
+// unservedProvider never serves the records it's handed
+type unservedProvider struct {
+	zoneProvider
+}
+
+func (p *unservedProvider) Resolves(context.Context, bind.DNSRecord) (bool, error) {
+	return false, nil
+}
+
+func TestPublishNow(t *testing.T) {
+	app, err := NewApp(&config.Config{Bind: config.BindConfig{Zone: "ts.example.com", TTL: 300 * time.Second}})
+	require.NoError(t, err)
+	machines := []tailscale.Machine{
+		{ID: "n1", Name: "laptop", IPv4Address: "100.64.0.1", Online: true},
+		{ID: "n2", Name: "phone", IPv4Address: "100.64.0.2", Online: true},
+	}
+
+	// A single sync publishes the records of the machines and reports them
+	provider := &zoneProvider{}
+	report, err := app.publishNow(context.Background(), provider, machines, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Machines)
+	assert.Equal(t, 2, report.Records)
+	assert.NotNil(t, report.Result)
+	require.Len(t, provider.updates, 1)
+	assert.Len(t, provider.updates[0], 2)
+	records, _ := app.ManagedRecords()
+	assert.Len(t, records, 2)
+	assert.Equal(t, "n1", app.recordDevice(provider.updates[0][0]))
+
+	// Failed updates are told apart from failed polls
+	_, err = app.publishNow(context.Background(), &failingProvider{}, machines, time.Second)
+	require.ErrorIs(t, err, ErrPublishFailed)
+	assert.NotErrorIs(t, err, ErrPollFailed)
+	assert.ErrorContains(t, err, "publishing records: server unreachable")
+}
+
+// failingProvider fails every update
+type failingProvider struct {
+	Provider
+}
+
+func (failingProvider) UpdateRecords(context.Context, []bind.DNSRecord, bool) (*bind.SyncResult, error) {
+	return nil, errors.New("server unreachable")
+}
+
+func TestApplyDiff(t *testing.T) {
+	laptop := bind.DNSRecord{Name: "laptop", Value: "100.64.0.1", TTL: 300, Type: "A"}
+	db := bind.DNSRecord{Name: "db", Value: "100.64.0.2", TTL: 300, Type: "A", Zone: "servers.example.com"}
+	diff := &LiveDiff{
+		Zones: []bind.ZoneContents{
+			{Zone: "servers.example.com", Transferred: true, Diff: bind.RecordDiff{
+				Added:   []bind.DNSRecord{db},
+				Removed: []bind.DNSRecord{{Name: "old", Value: "100.64.0.9", TTL: 300, Type: "A"}},
+			}},
+			{Zone: "ts.example.com", Transferred: true, Diff: bind.RecordDiff{
+				Removed: []bind.DNSRecord{{Name: "manual", Value: "192.0.2.1", TTL: 300, Type: "A"}},
+			}},
+		},
+		desired: []bind.DNSRecord{laptop, db},
+	}
+	assert.False(t, diff.Empty())
+
+	// Without pruning only the desired records are published
+	provider := &zoneProvider{}
+	_, err := applyDiff(context.Background(), provider, diff, false, "ts.example.com", false)
+	require.NoError(t, err)
+	assert.Equal(t, [][]bind.DNSRecord{{laptop, db}}, provider.updates)
+
+	// Pruning hands the extra records of every zone to the provider first, so that the next update removes them
+	provider = &zoneProvider{}
+	_, err = applyDiff(context.Background(), provider, diff, true, "ts.example.com", false)
+	require.NoError(t, err)
+	assert.Equal(t, [][]bind.DNSRecord{{
+		{Name: "old", Value: "100.64.0.9", TTL: 300, Type: "A", Zone: "servers.example.com"},
+		{Name: "manual", Value: "192.0.2.1", TTL: 300, Type: "A"},
+		laptop, db,
+	}, {laptop, db}}, provider.updates)
+
+	assert.True(t, (&LiveDiff{Zones: []bind.ZoneContents{{Zone: "ts.example.com"}}}).Empty())
+}
+
+func TestTargetRecords(t *testing.T) {
+	app, err := NewApp(&config.Config{
+		Bind: config.BindConfig{
+			Zone:  "ts.example.com",
+			TTL:   300 * time.Second,
+			Zones: []config.ZoneConfig{{Name: "servers.example.com", Tags: []string{"tag:server"}}},
+		},
+		Targets: []config.TargetConfig{
+			{Name: "public", Zone: "example.com", Tags: []string{"tag:public"}},
+			{Name: "mirror", Zone: "mirror.example.com"},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, app.targets, 2)
+
+	machines := []tailscale.Machine{
+		{ID: "n1", Name: "web", IPv4Address: "100.64.0.1", Online: true, Tags: []string{"tag:public", "tag:server"}},
+		{ID: "n2", Name: "laptop", IPv4Address: "100.64.0.2", Online: true},
+		{ID: "n3", Name: "desktop", IPv4Address: "100.64.0.3", Tags: []string{"tag:public"}},
+	}
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Targets get the records of the machines their selector picks, all in the target's zone
+	web := bind.DNSRecord{Name: "web", Value: "100.64.0.1", TTL: 300, Type: "A"}
+	laptop := bind.DNSRecord{Name: "laptop", Value: "100.64.0.2", TTL: 300, Type: "A"}
+	assert.Equal(t, []bind.DNSRecord{web}, app.targetRecords(app.targets[0], machines, now))
+	assert.Equal(t, []bind.DNSRecord{web, laptop}, app.targetRecords(app.targets[1], machines, now))
+	assert.Equal(t, "example.com", app.targets[0].config.Bind.Zone)
+
+	// Every poll fans out to the targets
+	require.True(t, app.publishTargets(context.Background(), machines, now))
+	assert.Equal(t, []bind.DNSRecord{web}, <-app.targets[0].recordChan)
+	assert.Equal(t, []bind.DNSRecord{web, laptop}, <-app.targets[1].recordChan)
+}
+
+func TestViews(t *testing.T) {
+	app, err := NewApp(&config.Config{
+		Bind: config.BindConfig{Zone: "ts.example.com", TTL: 300 * time.Second},
+		Views: []config.ViewConfig{
+			{
+				Name: "admin",
+				Zones: []config.ViewZoneConfig{
+					{Zone: "admin.example.com"},
+					{Zone: "admin.example.net", Provider: config.ProviderZoneFile},
+				},
+			},
+			{
+				Name:  "users",
+				Tags:  []string{"tag:service"},
+				Zones: []config.ViewZoneConfig{{Zone: "users.example.com"}},
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, app.targets, 3)
+	assert.Equal(t, "admin@admin.example.com", app.targets[0].name)
+	assert.Equal(t, config.ProviderZoneFile, app.targets[1].config.General.Provider)
+	assert.Equal(t, "users@users.example.com", app.targets[2].name)
+
+	machines := []tailscale.Machine{
+		{ID: "n1", Name: "wiki", IPv4Address: "100.64.0.1", Online: true, Tags: []string{"tag:service"}},
+		{ID: "n2", Name: "laptop", IPv4Address: "100.64.0.2", Online: true},
+	}
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// A single poll fans out to every zone of every view, each getting the machines of its view
+	wiki := bind.DNSRecord{Name: "wiki", Value: "100.64.0.1", TTL: 300, Type: "A"}
+	laptop := bind.DNSRecord{Name: "laptop", Value: "100.64.0.2", TTL: 300, Type: "A"}
+	require.True(t, app.publishTargets(context.Background(), machines, now))
+	assert.Equal(t, []bind.DNSRecord{wiki, laptop}, <-app.targets[0].recordChan)
+	assert.Equal(t, []bind.DNSRecord{wiki, laptop}, <-app.targets[1].recordChan)
+	assert.Equal(t, []bind.DNSRecord{wiki}, <-app.targets[2].recordChan)
+}
+
+func TestAliasRecords(t *testing.T) {
+	app, err := NewApp(&config.Config{
+		Bind: config.BindConfig{
+			Zone: "ts.example.com",
+			TTL:  300 * time.Second,
+			Zones: []config.ZoneConfig{
+				{Name: "servers.example.com", Tags: []string{"tag:server"}},
+			},
+			Aliases: map[string]string{
+				"nas":     "Storage-Box",
+				"files":   "storage-box",
+				"git":     "forge",
+				"printer": "office-printer",
+				"laptop":  "forge",
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	machines := []tailscale.Machine{
+		{ID: "n1", Name: "storage-box.tailnet.ts.net", IPv4Address: "100.64.0.1", Online: true},
+		{ID: "n2", Name: "forge", IPv4Address: "100.64.0.2", Online: true, Tags: []string{"tag:server"}},
+		{ID: "n3", Name: "office-printer", IPv4Address: "100.64.0.3"},
+		{ID: "n4", Name: "laptop", IPv4Address: "100.64.0.4", Online: true, Tags: []string{"tag:server"}},
+	}
+
+	// Aliases follow their machine into its zone, offline machines get none and names in use are never aliased
+	assert.Equal(t, []bind.DNSRecord{
+		{Name: "files", Value: "storage-box.ts.example.com", TTL: 300, Type: "CNAME"},
+		{Name: "git", Value: "forge.servers.example.com", TTL: 300, Type: "CNAME", Zone: "servers.example.com"},
+		{Name: "nas", Value: "storage-box.ts.example.com", TTL: 300, Type: "CNAME"},
+	}, app.createAliasRecords(machines))
+
+	// Machines whose addresses are all left out by the address selection get no A record to point an alias at
+	app, err = NewApp(&config.Config{
+		Tailscale: config.TailscaleConfig{IPv4Addresses: config.AddressSelectionTailscale},
+		Bind: config.BindConfig{
+			Zone:    "ts.example.com",
+			TTL:     300 * time.Second,
+			Aliases: map[string]string{"nas": "storage-box", "git": "forge"},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []bind.DNSRecord{
+		{Name: "git", Value: "forge.ts.example.com", TTL: 300, Type: "CNAME"},
+	}, app.createAliasRecords([]tailscale.Machine{
+		{ID: "n1", Name: "storage-box", IPv4Address: "192.168.1.5", Online: true},
+		{ID: "n2", Name: "forge", IPv4Address: "100.64.0.2", Online: true},
+	}))
+}
+
+func TestTagAliasRecords(t *testing.T) {
+	app, err := NewApp(&config.Config{
+		Bind: config.BindConfig{
+			Zone:    "ts.example.com",
+			TTL:     300 * time.Second,
+			Aliases: map[string]string{"git": "forge"},
+		},
+	})
+	require.NoError(t, err)
+
+	machines := []tailscale.Machine{
+		{
+			ID: "n1", Name: "monitoring", IPv4Address: "100.64.0.1", Online: true,
+			Tags: []string{"tag:dns-alias--grafana", "tag:DNS-Alias--Prometheus", "tag:dns-alias--git"},
+		},
+		{ID: "n2", Name: "forge", IPv4Address: "100.64.0.2", Online: true, Tags: []string{"tag:dns-alias--wiki"}},
+		{ID: "n3", Name: "docs", IPv4Address: "100.64.0.3", Online: true, Tags: []string{"tag:dns-alias--wiki"}},
+		{ID: "n4", Name: "nas", IPv4Address: "100.64.0.4", Online: true, Tags: []string{"tag:dns-alias--forge"}},
+		{ID: "n5", Name: "printer", IPv4Address: "100.64.0.5", Online: true, Tags: []string{"tag:dns-alias--"}},
+	}
+
+	// bind.aliases wins over tags, aliases claimed by several devices or in use by a machine aren't published
+	assert.Equal(t, []bind.DNSRecord{
+		{Name: "git", Value: "forge.ts.example.com", TTL: 300, Type: "CNAME"},
+		{Name: "grafana", Value: "monitoring.ts.example.com", TTL: 300, Type: "CNAME"},
+		{Name: "prometheus", Value: "monitoring.ts.example.com", TTL: 300, Type: "CNAME"},
+	}, app.createAliasRecords(machines))
+
+	// Tags can't add aliases alongside owner TXT records
+	app.config.Bind.Aliases = nil
+	app.config.Bind.OwnerID = "tailscale-bind-ddns"
+	assert.Empty(t, app.createAliasRecords(machines))
+}
+
+func TestRecordDevice(t *testing.T) {
+	app, err := NewApp(&config.Config{
+		Bind: config.BindConfig{
+			Zone:    "ts.example.com",
+			TTL:     300 * time.Second,
+			Zones:   []config.ZoneConfig{{Name: "servers.example.com", Tags: []string{"tag:server"}}},
+			Aliases: map[string]string{"git": "forge"},
+			PTR:     config.PTRConfig{Enabled: true, IPv4Subnet: "100.64.0.0/10", IPv4SubnetSize: 16},
+		},
+	})
+	require.NoError(t, err)
+
+	machines := []tailscale.Machine{
+		{ID: "n1", Name: "storage-box.tailnet.ts.net", IPv4Address: "100.64.0.1", Online: true},
+		{ID: "n2", Name: "forge", IPv4Address: "100.64.0.2", Online: true, Tags: []string{"tag:server"}},
+		{ID: "n3", Name: "office-printer", IPv4Address: "100.64.0.3"},
+	}
+	app.setDevices(machines)
+
+	devices := make(map[string]string)
+	for _, record := range app.buildRecords(machines) {
+		devices[string(record.Type)+" "+record.Name] = app.recordDevice(record)
+	}
+	assert.Equal(t, map[string]string{
+		"A storage-box":                "n1",
+		"A forge":                      "n2",
+		"CNAME git":                    "n2",
+		"PTR 1.0.64.100.in-addr.arpa.": "n1",
+		"PTR 2.0.64.100.in-addr.arpa.": "n2",
+	}, devices)
+
+	// Records of devices that are gone aren't tied to any device anymore
+	app.setDevices(nil)
+	assert.Empty(t, app.recordDevice(bind.DNSRecord{Name: "forge", Value: "100.64.0.2", Type: "A"}))
+}
+
+func TestFunnelRecords(t *testing.T) {
+	app, err := NewApp(&config.Config{
+		Bind: config.BindConfig{
+			Zone:            "ts.example.com",
+			TTL:             300 * time.Second,
+			PublishMetadata: true,
+			Aliases:         map[string]string{"blog": "web"},
+			Funnel:          map[string]string{"tag:funnel": config.FunnelCNAME, "tag:public": config.FunnelSkip},
+			PTR: config.PTRConfig{
+				Enabled:        true,
+				IPv4Subnet:     "100.64.0.0/10",
+				IPv4SubnetSize: 16,
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	machines := []tailscale.Machine{
+		{ID: "n1", Name: "web.tail1234.ts.net", IPv4Address: "100.64.0.1", Online: true, Tags: []string{"tag:Funnel"}},
+		{
+			ID: "n2", Name: "demo.tail1234.ts.net", IPv4Address: "100.64.0.2", Online: true,
+			Tags: []string{"tag:funnel", "tag:public"},
+		},
+		{ID: "n3", Name: "nas.tail1234.ts.net", IPv4Address: "100.64.0.3", Online: true},
+	}
+
+	// Funnel machines point at their public hostname without any records next to it, skipped ones get none at all
+	records := app.buildRecords(machines)
+	var funnel, nas []bind.DNSRecord
+	for _, record := range records {
+		switch {
+		case record.Name == "web" || record.Name == "blog":
+			funnel = append(funnel, record)
+		case record.Value == "web.ts.example.com":
+			t.Errorf("unexpected record pointing at the Funnel machine: %+v", record)
+		case record.Name == "demo" || record.Value == "demo.ts.example.com":
+			t.Errorf("unexpected record of the skipped machine: %+v", record)
+		default:
+			nas = append(nas, record)
+		}
+	}
+	assert.Equal(t, []bind.DNSRecord{
+		{Name: "web", Value: "web.tail1234.ts.net", TTL: 300, Type: "CNAME"},
+		{Name: "blog", Value: "web.ts.example.com", TTL: 300, Type: "CNAME"},
+	}, funnel)
+	assert.Len(t, nas, 3)
+
+	assert.Empty(t, app.bootstrapRecords(machines[:2]))
+
+	explanations := app.explain(machines, time.Now())
+	assert.Contains(t, explanations[0].Steps, FilterStep{Filter: "funnel", Detail: "Funnel device, not published"})
+	assert.False(t, explanations[0].Published)
+	assert.Contains(t, explanations[2].Steps, FilterStep{
+		Filter: "funnel", Passed: true, Detail: "Funnel device, CNAME to web.tail1234.ts.net",
+	})
+	assert.Equal(t, "web", explanations[2].RecordName)
+}
+
+func TestBootstrapRecords(t *testing.T) {
+	app, err := NewApp(&config.Config{
+		Tailscale: config.TailscaleConfig{ExcludeHostnames: []string{"^phone$"}},
+		Bind: config.BindConfig{
+			Zone: "test.example.com",
+			TTL:  300 * time.Second,
+			PTR: config.PTRConfig{
+				Enabled:        true,
+				IPv4Subnet:     "100.64.0.0/10",
+				IPv4SubnetSize: 16,
+				Bootstrap:      true,
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	machines := []tailscale.Machine{
+		{ID: "n1", Name: "web-1.tailnet.ts.net", IPv4Address: "100.64.0.1", Online: true},
+		{ID: "n2", Name: "nas.tailnet.ts.net", IPv4Address: "100.64.0.2", Online: false},
+		{ID: "n3", Name: "phone.tailnet.ts.net", IPv4Address: "100.64.0.3", Online: false},
+	}
+
+	// Offline devices get PTR records too, filtered ones don't
+	assert.Equal(t, []bind.DNSRecord{
+		{Name: "1.0.64.100.in-addr.arpa.", Value: "web-1.test.example.com", TTL: 300, Type: "PTR"},
+		{Name: "2.0.64.100.in-addr.arpa.", Value: "nas.test.example.com", TTL: 300, Type: "PTR"},
+	}, app.bootstrapRecords(machines))
+
+	// Regular updates only publish the online device
+	assert.Len(t, app.createPTRRecords(machines), 1)
+}
+
+func TestExternalRecords(t *testing.T) {
+	app, err := NewApp(&config.Config{
+		Bind: config.BindConfig{Zone: "test.example.com", TTL: 300 * time.Second},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go app.convertMachinesToRecords(ctx)
+
+	receive := func() map[bind.RecordKey]bind.DNSRecord {
+		t.Helper()
+		select {
+		case records := <-app.recordChan:
+			byKey := make(map[bind.RecordKey]bind.DNSRecord, len(records))
+			for _, record := range records {
+				byKey[record.Key()] = record
+			}
+			return byKey
+		case <-time.After(time.Second):
+			t.Fatal("no records were published")
+			return nil
+		}
+	}
+
+	// External records are held back until the first poll so that machine records aren't removed
+	require.NoError(t, app.SetExternalRecords("inventory", []bind.DNSRecord{
+		{Name: "printer", Type: "A", Value: "192.0.2.10"},
+		{Name: "machine1", Type: "A", Value: "192.0.2.11"},
+	}))
+	select {
+	case <-app.recordChan:
+		t.Fatal("records were published before the first poll")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	app.machineChan <- []tailscale.Machine{{ID: "n1", Name: "machine1", IPv4Address: "100.64.1.1", Online: true}}
+	records := receive()
+	require.Len(t, records, 2)
+	assert.Equal(t, "192.0.2.10", records[bind.RecordKey{Type: "A", Name: "printer"}].Value)
+	assert.Equal(t, uint32(300), records[bind.RecordKey{Type: "A", Name: "printer"}].TTL)
+	// Tailscale wins the conflict over machine1
+	assert.Equal(t, "100.64.1.1", records[bind.RecordKey{Type: "A", Name: "machine1"}].Value)
+
+	// Changing a source republishes straight away
+	require.NoError(t, app.SetExternalRecords("inventory", nil))
+	records = receive()
+	require.Len(t, records, 1)
+	assert.Empty(t, app.ExternalRecords())
+
+	assert.Error(t, app.SetExternalRecords("", nil))
+	assert.Error(t, app.SetExternalRecords("inventory", []bind.DNSRecord{{Name: "host", Type: "AAAA", Value: "192.0.2.1"}}))
+	assert.Error(t, app.SetExternalRecords("inventory", []bind.DNSRecord{{Name: "host.", Type: "A", Value: "192.0.2.1"}}))
+	assert.Error(t, app.SetExternalRecords("inventory", []bind.DNSRecord{{Name: "host", Type: "PTR", Value: "a.example."}}))
+	assert.Error(t, app.SetExternalRecords("inventory", []bind.DNSRecord{{Name: "host", Type: "MX", Value: "a.example."}}))
+}
+
+func TestAppRun(t *testing.T) {
+	// This test would require mocking the clients
+	// For now, we'll test the basic structure
+
+	config := &config.Config{
+		Tailscale: config.TailscaleConfig{
+			APIKey:       "test-api-key",
+			Tailnet:      "test.example.com",
+			PollInterval: 30 * time.Second,
+		},
+		Bind: config.BindConfig{
+			Server:         "dns.example.com",
+			Port:           53,
+			Zone:           "test.example.com",
+			KeyName:        "test-key",
+			KeySecret:      "test-secret",
+			Algorithm:      "hmac-sha256",
+			TTL:            300 * time.Second,
+			UpdateInterval: 60 * time.Second,
+		},
+		General: config.GeneralConfig{
+			LogLevel: "info",
+			DryRun:   true,
+		},
+	}
+
+	app, err := NewApp(config)
+	require.NoError(t, err)
+	require.NotNil(t, app)
+
+	// Test that the app can be created and has the expected structure
+	assert.Equal(t, config, app.config)
+	_, err = app.getTailscaleClient()
+	assert.NoError(t, err)
+	_, err = app.getProvider()
+	assert.NoError(t, err)
+	assert.NotNil(t, app.machineChan)
+	assert.NotNil(t, app.recordChan)
+}
+
+func TestConfigStatus(t *testing.T) {
+	// Configuration without any Tailscale credentials must still produce a status
+	cfg := &config.Config{
+		Bind: config.BindConfig{
+			Server: "dns.example.com",
+			Zone:   "test.example.com",
+		},
+	}
+
+	status := ConfigStatus(cfg)
+
+	assert.Equal(t, "", status["tailscale_tailnet"])
+	assert.Equal(t, "dns.example.com", status["bind_server"])
+	assert.Equal(t, "test.example.com", status["bind_zone"])
+	assert.Equal(t, true, status["ipv6_enabled"])
+}
+
+func TestStatusServer(t *testing.T) {
+	address := config.UnixAddressPrefix + filepath.Join(t.TempDir(), "status.sock")
+	app := &App{
+		config: &config.Config{
+			Tailscale: config.TailscaleConfig{Tailnet: "test.example.com"},
+			Bind:      config.BindConfig{Server: "dns.example.com", Zone: "test.example.com"},
+			General:   config.GeneralConfig{StatusAddress: address},
+		},
+		history: newHistory(10, nil),
+	}
+	app.history.observe([]tailscale.Machine{{ID: "n1", Name: "laptop", IPv4Address: "100.64.0.1", Online: true}},
+		time.Now())
+
+	listener, err := listenAddress(address)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		app.serveStatus(ctx, listener)
+	}()
+
+	status, err := FetchStatus(ctx, address)
+	require.NoError(t, err)
+	assert.Equal(t, "test.example.com", status["tailscale_tailnet"])
+	assert.Equal(t, "dns.example.com", status["bind_server"])
+
+	history, err := FetchHistory(ctx, address, "laptop.tailnet.ts.net")
+	require.NoError(t, err)
+	assert.Equal(t, "n1", history.ID)
+	require.Len(t, history.Transitions, 1)
+
+	_, err = FetchHistory(ctx, address, "phone")
+	assert.ErrorContains(t, err, "no history for phone")
+
+	gauges, err := FetchGauges(ctx, address)
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), gauges.SecondsSinceSync)
+
+	dashboard, err := FetchDashboard(ctx, address)
+	require.NoError(t, err)
+	assert.True(t, dashboard.LastSync.IsZero())
+	assert.Empty(t, dashboard.Machines)
+
+	// Explanations look the devices up through the Tailscale API, so TCP listeners don't serve them
+	recorder := httptest.NewRecorder()
+	app.statusHandler(false).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, ExplainPath, nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	_, err = FetchExplanations(ctx, "127.0.0.1:8080")
+	assert.ErrorContains(t, err, "requires a unix socket")
+
+	cancel()
+	<-done
+}
+
+func TestRotateKeySecretFile(t *testing.T) {
+	host, port, _ := startZoneTestServer(t, "main-key.")
+	secretFile := filepath.Join(t.TempDir(), "tsig.secret")
+	require.NoError(t, os.WriteFile(secretFile, []byte("b2xkLXNlY3JldA==\n"), 0o600))
+
+	app, err := NewApp(&config.Config{
+		Bind: config.BindConfig{
+			Server:        host,
+			Port:          port,
+			Zone:          "ts.example.com",
+			KeyName:       "main-key.",
+			KeySecret:     "b2xkLXNlY3JldA==",
+			KeySecretFile: secretFile,
+			Algorithm:     "hmac-sha256",
+			TTL:           300 * time.Second,
+		},
+	})
+	require.NoError(t, err)
+
+	// The secret file holds the key, a key rotated in would be rotated back to it with the next check
+	err = app.RotateKey(context.Background(), KeyRotation{KeyName: "new-key.", KeySecret: testTSIGSecret,
+		Algorithm: "hmac-sha256"})
+	assert.ErrorContains(t, err, "rotate it in "+secretFile)
+	assert.Equal(t, "main-key.", app.config.Bind.KeyName)
+	assert.Equal(t, "b2xkLXNlY3JldA==", app.config.Bind.KeySecret)
+
+	// A secret written to the file is verified and swapped in, and stays in use
+	require.NoError(t, os.WriteFile(secretFile, []byte(testTSIGSecret+"\n"), 0o600))
+	app.reloadKeySecretFile(context.Background())
+	assert.Equal(t, testTSIGSecret, app.config.Bind.KeySecret)
+	app.reloadKeySecretFile(context.Background())
+	assert.Equal(t, "main-key.", app.config.Bind.KeyName)
+	assert.Equal(t, testTSIGSecret, app.config.Bind.KeySecret)
+
+	assert.NoError(t, CheckKeyRotation(&config.BindConfig{KeySecret: testTSIGSecret}))
+	assert.ErrorContains(t, CheckKeyRotation(&config.BindConfig{KeyCommand: "sign"}), "bind.key_command")
+}
+
+func TestHistory(t *testing.T) {
+	h := newHistory(4, nil)
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	laptop := tailscale.Machine{ID: "n1", Name: "laptop", IPv4Address: "100.64.0.1", Online: true}
+	phone := tailscale.Machine{ID: "n2", Name: "phone", IPv4Address: "100.64.0.2", Online: true}
+	moved := laptop
+	moved.IPv4Address = "100.64.0.9"
+
+	polls := [][]tailscale.Machine{
+		{laptop, phone},
+		{laptop, phone},
+		{phone},
+		{laptop, phone},
+		{moved, phone},
+		{phone},
+	}
+	for i, machines := range polls {
+		h.observe(machines, start.Add(time.Duration(i)*time.Minute))
+	}
+
+	history, ok := h.lookup("LAPTOP")
+	require.True(t, ok)
+	assert.False(t, history.Online)
+	assert.Equal(t, "laptop", history.RecordName)
+	// The first online transition was dropped to keep the history at its size
+	kinds := make([]TransitionKind, 0, len(history.Transitions))
+	for _, transition := range history.Transitions {
+		kinds = append(kinds, transition.Kind)
+	}
+	assert.Equal(t, []TransitionKind{TransitionOffline, TransitionOnline, TransitionAddressChanged, TransitionOffline}, kinds)
+	assert.Equal(t, "100.64.0.9", history.Transitions[2].IPv4Address)
+	assert.Equal(t, start.Add(5*time.Minute), history.Transitions[3].Time)
+	assert.Equal(t, 1, history.Flaps)
+
+	history, ok = h.lookup("n2")
+	require.True(t, ok)
+	assert.True(t, history.Online)
+	assert.Len(t, history.Transitions, 1)
+
+	_, ok = h.lookup("tablet")
+	assert.False(t, ok)
+
+	// A disabled history records nothing
+	disabled := newHistory(0, nil)
+	disabled.observe([]tailscale.Machine{laptop}, start)
+	_, ok = disabled.lookup("laptop")
+	assert.False(t, ok)
+}
+
+func TestSimulate(t *testing.T) {
+	snapshotFile := filepath.Join(t.TempDir(), "snapshots.json")
+	require.NoError(t, os.WriteFile(snapshotFile, []byte(`[
+		{"time": "2025-01-01T00:00:00Z", "machines": [
+			{"id": "1", "name": "laptop", "ipv4_address": "100.64.0.1", "online": true},
+			{"id": "2", "name": "phone", "ipv4_address": "100.64.0.2", "online": true}
+		]},
+		{"time": "2025-01-01T00:05:00Z", "machines": [
+			{"id": "1", "name": "laptop", "ipv4_address": "100.64.0.3", "online": true},
+			{"id": "2", "name": "phone", "ipv4_address": "100.64.0.2", "online": false}
+		]},
+		{"time": "2025-01-01T00:10:00Z", "machines": [
+			{"id": "1", "name": "laptop", "ipv4_address": "100.64.0.3", "online": true}
+		]}
+	]`), 0o600))
+
+	snapshots, err := LoadSnapshots(snapshotFile)
+	require.NoError(t, err)
+	require.Len(t, snapshots, 3)
+
+	app := &App{config: &config.Config{Bind: config.BindConfig{TTL: 300 * time.Second}}}
+	steps, err := app.Simulate(snapshots)
+	require.NoError(t, err)
+	require.Len(t, steps, 3)
+
+	assert.Len(t, steps[0].Diff.Added, 2)
+	assert.Empty(t, steps[0].Diff.Removed)
+
+	require.Len(t, steps[1].Diff.Changed, 1)
+	assert.Equal(t, "100.64.0.3", steps[1].Diff.Changed[0].Value)
+	require.Len(t, steps[1].Diff.Removed, 1)
+	assert.Equal(t, "phone", steps[1].Diff.Removed[0].Name)
+
+	assert.True(t, steps[2].Diff.Empty())
+
+	_, err = LoadSnapshots(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestSimulatePublishingStages(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	machine := func(id, name, address string, online bool, lastSeen time.Duration) tailscale.Machine {
+		return tailscale.Machine{ID: id, Name: name, IPv4Address: address, Online: online,
+			LastSeen: start.Add(lastSeen)}
+	}
+	snapshot := func(at time.Duration, machines ...tailscale.Machine) Snapshot {
+		return Snapshot{Time: start.Add(at), Machines: machines}
+	}
+
+	app, err := NewApp(&config.Config{
+		Tailscale: config.TailscaleConfig{OfflinePolls: 2},
+		Bind: config.BindConfig{
+			Zone:               "ts.example.com",
+			TTL:                300 * time.Second,
+			MaxRecordAge:       time.Hour,
+			OfflinePolicy:      config.OfflinePolicyGrace,
+			OfflineGracePeriod: 10 * time.Minute,
+			Quarantine:         15 * time.Minute,
+			QuarantineTTL:      30 * time.Second,
+			NameCollisions:     config.NameCollisionFail,
+		},
+	})
+	require.NoError(t, err)
+
+	steps, err := app.Simulate([]Snapshot{
+		snapshot(0, machine("1", "laptop", "100.64.0.1", true, 0), machine("2", "phone", "100.64.0.2", true, 0),
+			machine("3", "server", "100.64.0.3", true, 0)),
+		// A single poll finding the phone offline doesn't take it offline with offline_polls
+		snapshot(5*time.Minute, machine("1", "laptop", "100.64.0.1", true, 5*time.Minute),
+			machine("2", "phone", "100.64.0.2", false, 0), machine("3", "server", "100.64.0.3", true, 0)),
+		// Offline now, but kept for the grace period
+		snapshot(10*time.Minute, machine("1", "laptop", "100.64.0.1", true, 10*time.Minute),
+			machine("2", "phone", "100.64.0.2", false, 0), machine("3", "server", "100.64.0.3", true, 0)),
+		// Past the grace period the phone is withdrawn into the quarantine
+		snapshot(25*time.Minute, machine("1", "laptop", "100.64.0.1", true, 25*time.Minute),
+			machine("2", "phone", "100.64.0.2", false, 0), machine("3", "server", "100.64.0.3", true, 0)),
+		// The server wasn't seen for longer than max_record_age and the quarantine of the phone is over
+		snapshot(90*time.Minute, machine("1", "laptop", "100.64.0.1", true, 90*time.Minute),
+			machine("3", "server", "100.64.0.3", true, 0)),
+		// Colliding names aren't published with name_collisions fail
+		snapshot(95*time.Minute, machine("1", "laptop", "100.64.0.1", true, 95*time.Minute),
+			machine("4", "laptop", "100.64.0.4", true, 95*time.Minute)),
+	})
+	require.NoError(t, err)
+	require.Len(t, steps, 6)
+
+	assert.Len(t, steps[0].Diff.Added, 3)
+	assert.True(t, steps[1].Diff.Empty())
+	assert.True(t, steps[2].Diff.Empty())
+
+	require.Len(t, steps[3].Diff.Changed, 1)
+	assert.Equal(t, "phone", steps[3].Diff.Changed[0].Name)
+	assert.Equal(t, uint32(30), steps[3].Diff.Changed[0].TTL)
+	require.Len(t, steps[3].Diff.Added, 1)
+	assert.Equal(t, bind.TypeTXT, steps[3].Diff.Added[0].Type)
+
+	var removed []string
+	for _, record := range steps[4].Diff.Removed {
+		removed = append(removed, record.Name)
+	}
+	assert.ElementsMatch(t, []string{"phone", "phone"}, removed)
+	require.Len(t, steps[4].Diff.Changed, 1)
+	assert.Equal(t, "server", steps[4].Diff.Changed[0].Name)
+
+	require.ErrorContains(t, steps[5].Error, "devices share record names")
+	assert.True(t, steps[5].Diff.Empty())
+	assert.Equal(t, steps[4].Records, steps[5].Records)
+}
+
+func TestAnsibleInventory(t *testing.T) {
+	app := &App{config: &config.Config{Bind: config.BindConfig{Zone: "ts.example.com."}}}
+
+	data, err := app.ansibleInventory([]tailscale.Machine{
+		{ID: "1", Name: "web1.tailnet.ts.net", IPv4Address: "100.64.0.1", Online: true, Tags: []string{"tag:web"}},
+		{ID: "2", Name: "web2", IPv4Address: "100.64.0.2", IPv6Address: "fd7a:115c:a1e0::2", Online: true,
+			Tags: []string{"tag:web", "tag:prod-eu"}},
+		{ID: "3", Name: "laptop", IPv6Address: "fd7a:115c:a1e0::3", Online: true},
+		{ID: "4", Name: "offline", IPv4Address: "100.64.0.4", Online: false, Tags: []string{"tag:web"}},
+	})
+	require.NoError(t, err)
+
+	var inventory struct {
+		Meta struct {
+			Hostvars map[string]map[string]string `json:"hostvars"`
+		} `json:"_meta"`
+		All       ansibleGroup `json:"all"`
+		Web       ansibleGroup `json:"tag_web"`
+		ProdEU    ansibleGroup `json:"tag_prod_eu"`
+		Ungrouped ansibleGroup `json:"ungrouped"`
+	}
+	require.NoError(t, json.Unmarshal(data, &inventory))
+
+	assert.Equal(t, []string{"web1", "web2"}, inventory.Web.Hosts)
+	assert.Equal(t, []string{"web2"}, inventory.ProdEU.Hosts)
+	assert.Equal(t, []string{"laptop"}, inventory.Ungrouped.Hosts)
+	assert.Equal(t, []string{"tag_prod_eu", "tag_web", "ungrouped"}, inventory.All.Children)
+
+	require.Len(t, inventory.Meta.Hostvars, 3)
+	assert.Equal(t, "100.64.0.1", inventory.Meta.Hostvars["web1"]["ansible_host"])
+	assert.Equal(t, "web1.ts.example.com", inventory.Meta.Hostvars["web1"]["dns_name"])
+	assert.Equal(t, "fd7a:115c:a1e0::3", inventory.Meta.Hostvars["laptop"]["ansible_host"])
+}
+
+func TestExportRecords(t *testing.T) {
+	app, err := NewApp(&config.Config{
+		Bind: config.BindConfig{
+			Zone:    "ts.example.com",
+			TTL:     300 * time.Second,
+			Aliases: map[string]string{"nas": "storage"},
+			PTR: config.PTRConfig{Enabled: true, IPv4Zone: "64.100.in-addr.arpa", IPv4Subnet: "100.64.0.0/16",
+				IPv4SubnetSize: 16},
+		},
+	})
+	require.NoError(t, err)
+	machines := []tailscale.Machine{
+		{ID: "1", Name: "storage.tailnet.ts.net", IPv4Address: "100.64.0.2", Online: true},
+		{ID: "2", Name: "laptop", IPv4Address: "100.64.0.1", Online: true},
+	}
+
+	data, err := app.zoneFragment(machines)
+	require.NoError(t, err)
+	assert.Equal(t, `; Generated by tailscale-bind-ddns
+
+; 64.100.in-addr.arpa.
+1.0.64.100.in-addr.arpa.	300	IN	PTR	laptop.ts.example.com.
+2.0.64.100.in-addr.arpa.	300	IN	PTR	storage.ts.example.com.
+
+; ts.example.com.
+laptop.ts.example.com.	300	IN	A	100.64.0.1
+nas.ts.example.com.	300	IN	CNAME	storage.ts.example.com.
+storage.ts.example.com.	300	IN	A	100.64.0.2
+`, string(data))
+
+	// The host-records answer the reverse lookups already
+	data, err = app.dnsmasqConfig(machines)
+	require.NoError(t, err)
+	assert.Equal(t, `# Generated by tailscale-bind-ddns
+host-record=laptop.ts.example.com,100.64.0.1,300
+host-record=storage.ts.example.com,100.64.0.2,300
+cname=nas.ts.example.com,storage.ts.example.com,300
+`, string(data))
+
+	data, err = app.hostsFile(machines)
+	require.NoError(t, err)
+	assert.Equal(t, `# Generated by tailscale-bind-ddns
+100.64.0.1	laptop.ts.example.com
+100.64.0.2	storage.ts.example.com nas.ts.example.com
+`, string(data))
+
+	// Without a zone the names of the records aren't complete
+	app.config.Bind.Zone = ""
+	_, err = app.hostsFile(machines)
+	assert.ErrorContains(t, err, "bind.zone is required")
+}
+
+func TestExportUnknownFormat(t *testing.T) {
+	app, err := NewApp(&config.Config{})
+	require.NoError(t, err)
+
+	_, err = app.Export(context.Background(), "does-not-exist")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported export format")
+}
+
+// fakePlugin is a recordPlugin running a function
+type fakePlugin struct {
+	name      string
+	transform func([]tailscale.Machine, []bind.DNSRecord) ([]bind.DNSRecord, error)
+}
+
+func (p *fakePlugin) Name() string { return p.name }
+
+func (p *fakePlugin) Transform(
+	_ context.Context,
+	machines []tailscale.Machine,
+	records []bind.DNSRecord,
+) ([]bind.DNSRecord, error) {
+	return p.transform(machines, records)
+}
+
+func (p *fakePlugin) Close(context.Context) error { return nil }
+
+func TestPlugins(t *testing.T) {
+	app, err := NewApp(&config.Config{
+		Bind: config.BindConfig{Zone: "ts.example.com", TTL: 300 * time.Second},
+	})
+	require.NoError(t, err)
+
+	var seen []tailscale.Machine
+	app.plugins = []recordPlugin{
+		// Adds a record without a TTL for every machine
+		&fakePlugin{name: "vpn", transform: func(machines []tailscale.Machine, records []bind.DNSRecord) (
+			[]bind.DNSRecord, error,
+		) {
+			seen = machines
+			for _, machine := range machines {
+				records = append(records, bind.DNSRecord{
+					Name:  "vpn-" + machine.Name,
+					Value: machine.Name,
+					Type:  "CNAME",
+				})
+			}
+			return records, nil
+		}},
+		&fakePlugin{name: "broken", transform: func([]tailscale.Machine, []bind.DNSRecord) ([]bind.DNSRecord, error) {
+			return nil, errors.New("trap")
+		}},
+	}
+
+	machines := []tailscale.Machine{
+		{ID: "n1", Name: "laptop", IPv4Address: "100.64.0.1", Online: true},
+		{ID: "n2", Name: "web-staging", IPv4Address: "100.64.0.2", Online: true},
+	}
+	app.filter, err = newHostnameFilter(nil, []string{"-staging$"})
+	require.NoError(t, err)
+
+	// Plugins get the machines that passed the filters, records of failing plugins pass on as they were
+	assert.Equal(t, []bind.DNSRecord{
+		{Name: "laptop", Value: "100.64.0.1", TTL: 300, Type: "A"},
+		{Name: "vpn-laptop", Value: "laptop", TTL: 300, Type: "CNAME"},
+	}, app.buildRecords(machines))
+	assert.Equal(t, machines[:1], seen)
 }
@@ -0,0 +1,67 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+)
+
+// zoneBackuper is implemented by providers that can back up the managed records of their zones and restore them
+type zoneBackuper interface {
+	BackupZones(ctx context.Context, records []bind.DNSRecord) ([]bind.ZoneBackup, error)
+	RestoreZones(ctx context.Context, zones []bind.ZoneBackup, dryRun bool) (int, error)
+}
+
+// getBackuper returns the provider if it supports backups
+func (a *App) getBackuper() (zoneBackuper, error) {
+	provider, err := a.getProvider()
+	if err != nil {
+		return nil, err
+	}
+	backuper, ok := provider.(zoneBackuper)
+	if !ok {
+		return nil, fmt.Errorf("provider %q doesn't support backups", a.config.General.Provider)
+	}
+	return backuper, nil
+}
+
+// Backup reads the managed records of every zone from the DNS server. The tailnet's machines are needed for the names
+// to look up in zones the server doesn't permit transferring.
+func (a *App) Backup(ctx context.Context) (*bind.Backup, error) {
+	backuper, err := a.getBackuper()
+	if err != nil {
+		return nil, err
+	}
+
+	tsClient, err := a.getTailscaleClient()
+	if err != nil {
+		return nil, err
+	}
+	machines, err := tsClient.GetMachines(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting machines: %w", err)
+	}
+
+	zones, err := backuper.BackupZones(ctx, a.desiredRecords(machines))
+	if err != nil {
+		return nil, fmt.Errorf("reading records: %w", err)
+	}
+	return &bind.Backup{Version: bind.BackupVersion, Created: clock.Or(a.clock).Now().UTC(), Zones: zones}, nil
+}
+
+// Restore re-applies the records of a backup with dynamic updates and returns the number of records sent. Nothing is
+// sent in dry-run mode.
+func (a *App) Restore(ctx context.Context, backup *bind.Backup) (int, error) {
+	backuper, err := a.getBackuper()
+	if err != nil {
+		return 0, err
+	}
+
+	sent, err := backuper.RestoreZones(ctx, backup.Zones, a.config.General.DryRun)
+	if err != nil {
+		return sent, fmt.Errorf("restoring records: %w", err)
+	}
+	return sent, nil
+}
@@ -0,0 +1,62 @@
+package app
+
+import (
+	"context"
+	"slices"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/aauren/tailscale-bind-ddns/pkg/tailscale"
+	"k8s.io/klog/v2"
+)
+
+// ptrBootstrapper is implemented by providers that can back-populate reverse zones
+type ptrBootstrapper interface {
+	BootstrapPTR(ctx context.Context, records []bind.DNSRecord, dryRun bool) (int, error)
+}
+
+// bootstrapPTR publishes PTR records for every device of the tailnet whose reverse name is still empty. Failures are
+// logged rather than returned, regular updates publish the PTR records of online devices either way.
+func (a *App) bootstrapPTR(ctx context.Context, tsClient *tailscale.Client, provider Provider) {
+	bootstrapper, ok := provider.(ptrBootstrapper)
+	if !ok {
+		klog.Warningf("Provider %s doesn't support bootstrapping PTR records", a.config.General.Provider)
+		return
+	}
+
+	machines, err := tsClient.GetMachines(ctx)
+	if err != nil {
+		klog.Errorf("PTR bootstrap failed: getting machines: %v", err)
+		return
+	}
+
+	records := a.bootstrapRecords(machines)
+	created, err := bootstrapper.BootstrapPTR(ctx, records, a.config.General.DryRun)
+	if err != nil {
+		klog.Errorf("PTR bootstrap failed after creating %d records: %v", created, err)
+		return
+	}
+	klog.Infof("PTR bootstrap created %d of %d PTR records", created, len(records))
+}
+
+// bootstrapRecords creates PTR records for every machine the hostname filter allows, whether it is online or not,
+// except Funnel machines
+func (a *App) bootstrapRecords(machines []tailscale.Machine) []bind.DNSRecord {
+	machines = a.withoutSkippedFunnel(a.filter.apply(machines))
+
+	// Names are only handed out to online devices, so every device is named as if it was online
+	named := slices.Clone(machines)
+	for i := range named {
+		named[i].Online = true
+	}
+	names := a.namer.names(named)
+
+	var records []bind.DNSRecord
+	for _, machine := range machines {
+		if a.funnelMode(machine) == config.FunnelCNAME {
+			continue
+		}
+		records = append(records, a.machinePTRRecords(machine, names[machine.ID])...)
+	}
+	return records
+}
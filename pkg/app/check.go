@@ -0,0 +1,49 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"k8s.io/klog/v2"
+)
+
+// CheckConnections dials Tailscale, the DNS provider and every target with the configured credentials, without
+// publishing anything, and returns the problems found as errors
+func (a *App) CheckConnections(ctx context.Context) []config.Problem {
+	defer a.closePlugins()
+
+	var problems []config.Problem
+	fail := func(key, format string, args ...any) {
+		problems = append(problems, config.Problem{Severity: config.SeverityError, Key: key,
+			Message: fmt.Sprintf(format, args...)})
+	}
+
+	if tsClient, err := a.getTailscaleClient(); err != nil {
+		fail("tailscale", "creating the Tailscale client: %v", err)
+	} else {
+		defer func() {
+			if err := tsClient.Close(); err != nil {
+				klog.Errorf("Failed to close Tailscale client: %v", err)
+			}
+		}()
+		if _, err := tsClient.GetMachines(ctx); err != nil {
+			fail("tailscale", "getting the machines from Tailscale: %v", err)
+		}
+	}
+
+	if provider, err := a.getProvider(); err != nil {
+		fail("general.provider", "creating the %s provider: %v", a.config.General.Provider, err)
+	} else if err := provider.ValidateConnection(ctx); err != nil {
+		fail("general.provider", "connecting to the %s provider: %v", a.config.General.Provider, err)
+	}
+
+	for _, t := range a.targets {
+		if provider, err := a.getTargetProvider(t); err != nil {
+			fail("targets", "%v", err)
+		} else if err := provider.ValidateConnection(ctx); err != nil {
+			fail("targets", "connecting to target %s: %v", t.name, err)
+		}
+	}
+	return problems
+}
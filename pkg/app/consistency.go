@@ -0,0 +1,62 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"k8s.io/klog/v2"
+)
+
+// consistencyChecker is implemented by providers that can verify forward and reverse records against each other
+type consistencyChecker interface {
+	CheckConsistency(ctx context.Context, repair bool) (bind.ConsistencyReport, error)
+}
+
+// runConsistencyChecks periodically checks the published forward and reverse records until the context is cancelled
+func (a *App) runConsistencyChecks(ctx context.Context, checker consistencyChecker, interval time.Duration) {
+	ticker := clock.Or(a.clock).NewTicker(interval)
+	defer ticker.Stop()
+
+	klog.Infof("Starting forward/reverse consistency checks with interval %v", interval)
+
+	for {
+		select {
+		case <-ticker.C():
+			a.checkConsistency(ctx, checker)
+		case <-ctx.Done():
+			klog.Info("Consistency checks stopped")
+			return
+		}
+	}
+}
+
+// checkConsistency runs a single consistency check and stores its report
+func (a *App) checkConsistency(ctx context.Context, checker consistencyChecker) {
+	// Never write to DNS in dry-run mode, mismatches are still reported
+	repair := a.config.Bind.PTR.ConsistencyRepair && !a.config.General.DryRun
+
+	report, err := checker.CheckConsistency(ctx, repair)
+	if err != nil {
+		klog.Errorf("Forward/reverse consistency check failed: %v", err)
+	}
+	if len(report.Mismatches) > 0 {
+		klog.Warningf("Found %d forward/reverse mismatches in %d records, repaired %d",
+			len(report.Mismatches), report.Checked, report.Repaired)
+	} else {
+		klog.V(1).Infof("Forward/reverse consistency check of %d records found no mismatches", report.Checked)
+	}
+
+	a.consistencyMu.Lock()
+	defer a.consistencyMu.Unlock()
+	a.consistency = &report
+}
+
+// ConsistencyReport returns the report of the most recent consistency check, or nil when none has run
+func (a *App) ConsistencyReport() *bind.ConsistencyReport {
+	a.consistencyMu.Lock()
+	defer a.consistencyMu.Unlock()
+
+	return a.consistency
+}
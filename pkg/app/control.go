@@ -0,0 +1,136 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"github.com/aauren/tailscale-bind-ddns/pkg/tailscale"
+	"k8s.io/klog/v2"
+)
+
+var (
+	// ErrPaused is returned when a sync is requested while publishing is paused
+	ErrPaused = errors.New("publishing is paused")
+	// ErrPollFailed and ErrPublishFailed are wrapped by the errors of syncs that failed to poll Tailscale and to publish
+	// the records
+	ErrPollFailed    = errors.New("polling Tailscale")
+	ErrPublishFailed = errors.New("publishing records")
+)
+
+// Pause stops record changes from being handed to the DNS provider. Tailscale is still polled so that a later Resume
+// publishes the current state straight away on the next poll.
+func (a *App) Pause() {
+	if !a.paused.Swap(true) {
+		klog.Info("Publishing paused")
+	}
+}
+
+// Resume starts handing record changes to the DNS provider again
+func (a *App) Resume() {
+	if a.paused.Swap(false) {
+		klog.Info("Publishing resumed")
+	}
+}
+
+// Paused reports whether publishing is paused
+func (a *App) Paused() bool {
+	return a.paused.Load()
+}
+
+// TriggerSync polls Tailscale and publishes the resulting records immediately, returning the number of records sent
+func (a *App) TriggerSync(ctx context.Context) (int, error) {
+	if a.Paused() {
+		return 0, ErrPaused
+	}
+
+	report, err := a.syncOnce(ctx)
+	if report == nil {
+		return 0, err
+	}
+	return report.Records, err
+}
+
+// syncOnce polls Tailscale once and publishes the resulting records to the provider and the targets. The report is nil
+// when nothing was published.
+func (a *App) syncOnce(ctx context.Context) (*SyncReport, error) {
+	tsClient, err := a.getTailscaleClient()
+	if err != nil {
+		return nil, err
+	}
+	provider, err := a.getProvider()
+	if err != nil {
+		return nil, err
+	}
+
+	clk := clock.Or(a.clock)
+	started := clk.Now()
+	machines, err := tsClient.GetOnlineMachines(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPollFailed, err)
+	}
+	return a.publishNow(ctx, provider, machines, clk.Since(started))
+}
+
+// publishNow publishes the records of machines to the provider and the targets straight away, fetch is how long
+// getting the machines took
+func (a *App) publishNow(ctx context.Context, provider Provider, machines []tailscale.Machine,
+	fetch time.Duration) (*SyncReport, error) {
+	clk := clock.Or(a.clock)
+	a.externalMu.Lock()
+	a.lastMachines, a.polled = machines, true
+	a.externalMu.Unlock()
+	a.history.observe(machines, clk.Now())
+
+	records, timings := a.timedDesiredRecords(machines)
+	timings.Fetch = fetch
+	if err := a.checkNameCollisions(machines, clk.Now()); err != nil {
+		return nil, err
+	}
+	records = a.quarantine.apply(records, clk.Now())
+	a.setDevices(machines)
+	klog.Infof("Triggered sync of %d records", len(records))
+	report := &SyncReport{Machines: len(machines), Records: len(records)}
+	result, err := provider.UpdateRecords(ctx, records, a.config.General.DryRun)
+	if result != nil {
+		a.completeCycle(timings, result)
+		report.Result = result
+	}
+	if err != nil {
+		return report, fmt.Errorf("%w: %w", ErrPublishFailed, err)
+	}
+
+	a.setManagedRecords(records)
+	if !result.DryRun {
+		a.verifyJoins(ctx)
+	}
+	if err := a.syncTargets(ctx, machines, clk.Now()); err != nil {
+		return report, fmt.Errorf("%w to targets: %w", ErrPublishFailed, err)
+	}
+	return report, nil
+}
+
+// ManagedRecords returns the records most recently handed to the DNS provider and when that happened
+func (a *App) ManagedRecords() ([]bind.DNSRecord, time.Time) {
+	a.managedMu.Lock()
+	defer a.managedMu.Unlock()
+
+	return slices.Clone(a.managedRecords), a.lastSync
+}
+
+// setManagedRecords stores the records most recently handed to the DNS provider, which the forwarder answers with
+func (a *App) setManagedRecords(records []bind.DNSRecord) {
+	if a.forwarder != nil {
+		a.forwarder.update(records)
+	}
+
+	a.managedMu.Lock()
+	defer a.managedMu.Unlock()
+
+	a.managedRecords = records
+	a.lastSync = clock.Or(a.clock).Now()
+}
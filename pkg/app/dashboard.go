@@ -0,0 +1,229 @@
+package app
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"github.com/aauren/tailscale-bind-ddns/pkg/web"
+	"github.com/miekg/dns"
+)
+
+// DashboardPath is the HTTP path serving the status page
+const DashboardPath = "/dashboard"
+
+// maxRecentErrors is how many of the most recent update errors the status page shows
+const maxRecentErrors = 20
+
+// MachineUnpublished is the record state of machines without records
+const MachineUnpublished = "unpublished"
+
+// recordStateSeverity orders the statuses of records, the state of a machine being the most severe of its records
+var recordStateSeverity = map[string]int{
+	web.RecordSynced:  0,
+	web.RecordDryRun:  1,
+	web.RecordPending: 2,
+	web.RecordSkipped: 3,
+	web.RecordFailed:  4,
+}
+
+// MachineStatus is the state of the records of a machine of the most recent poll
+type MachineStatus struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Online     bool   `json:"online"`
+	RecordName string `json:"record_name,omitempty"`
+	Records    int    `json:"records"`
+	// State is the most severe status of its records (synced, dry-run, pending, skipped or failed), unpublished
+	// without records
+	State string `json:"state"`
+}
+
+// recordUpdateErrors keeps the zone updates of a result that failed for the status page. It must be called with
+// timingsMu held.
+func (a *App) recordUpdateErrors(result *bind.SyncResult) {
+	finished := result.Started.Add(result.Duration)
+	for _, zone := range result.Zones {
+		if zone.Error == "" {
+			continue
+		}
+		a.recentErrors = slices.Insert(a.recentErrors, 0, web.Error{
+			Time:    finished,
+			Source:  "zone " + zone.Zone,
+			Message: zone.Error,
+		})
+	}
+	if len(a.recentErrors) > maxRecentErrors {
+		a.recentErrors = a.recentErrors[:maxRecentErrors]
+	}
+}
+
+// Dashboard returns what the status page shows: the machines of the most recent poll, the records most recently handed
+// to the DNS provider with the outcome of the most recent update and the most recent update errors
+func (a *App) Dashboard() web.Dashboard {
+	records, lastSync := a.ManagedRecords()
+
+	a.externalMu.Lock()
+	machines := a.lastMachines
+	a.externalMu.Unlock()
+
+	dashboard := web.Dashboard{
+		Generated: clock.Or(a.clock).Now(),
+		Paused:    a.Paused(),
+		DryRun:    a.config.General.DryRun,
+		LastSync:  lastSync,
+		Errors:    a.recentUpdateErrors(),
+		Records:   a.recordStatuses(records),
+	}
+
+	a.clientsMu.Lock()
+	tsClient := a.tailscaleClient
+	a.clientsMu.Unlock()
+	if tsClient != nil {
+		if cache := tsClient.CacheStatus(); cache != nil && cache.Stale {
+			dashboard.StaleMachines = cache.Polled
+		}
+	}
+
+	names := a.namer.names(a.publishedMachines(machines))
+	for _, machine := range machines {
+		entry := web.Machine{
+			ID:         machine.ID,
+			Name:       machine.Name,
+			Online:     machine.Online,
+			LastSeen:   machine.LastSeen,
+			Addresses:  append(slices.Clone(machine.IPv4Addresses), machine.IPv6Addresses...),
+			RecordName: names[machine.ID],
+		}
+		if entry.RecordName != "" {
+			entry.Zone = a.recordZoneName(a.machineZone(machine))
+		}
+		dashboard.Machines = append(dashboard.Machines, entry)
+	}
+	return dashboard
+}
+
+// recentUpdateErrors returns the most recent update errors, newest first
+func (a *App) recentUpdateErrors() []web.Error {
+	a.timingsMu.Lock()
+	defer a.timingsMu.Unlock()
+
+	return slices.Clone(a.recentErrors)
+}
+
+// recordStatuses describes every record with the outcome of the most recent update for it
+func (a *App) recordStatuses(records []bind.DNSRecord) []web.Record {
+	a.timingsMu.Lock()
+	result := a.lastResult
+	a.timingsMu.Unlock()
+
+	statuses := make([]web.Record, 0, len(records))
+	for _, record := range records {
+		statuses = append(statuses, a.recordSyncStatus(record, result))
+	}
+	return statuses
+}
+
+// machineStatuses returns the state of the records of every machine of the most recent poll, sorted by name
+func (a *App) machineStatuses(records []bind.DNSRecord) []MachineStatus {
+	a.externalMu.Lock()
+	machines := a.lastMachines
+	a.externalMu.Unlock()
+
+	names := a.namer.names(a.publishedMachines(machines))
+	byID := make(map[string]*MachineStatus, len(machines))
+	statuses := make([]MachineStatus, 0, len(machines))
+	for _, machine := range machines {
+		statuses = append(statuses, MachineStatus{
+			ID:         machine.ID,
+			Name:       machine.Name,
+			Online:     machine.Online,
+			RecordName: names[machine.ID],
+			State:      MachineUnpublished,
+		})
+	}
+	for i := range statuses {
+		byID[statuses[i].ID] = &statuses[i]
+	}
+
+	for i, status := range a.recordStatuses(records) {
+		machine, ok := byID[a.recordDevice(records[i])]
+		if !ok {
+			continue
+		}
+		if machine.Records == 0 || recordStateSeverity[status.Status] > recordStateSeverity[machine.State] {
+			machine.State = status.Status
+		}
+		machine.Records++
+	}
+
+	slices.SortFunc(statuses, func(x, y MachineStatus) int { return strings.Compare(x.Name, y.Name) })
+	return statuses
+}
+
+// recordZoneName returns the name of the forward zone a record is published in, the main zone for an empty zone
+func (a *App) recordZoneName(zone string) string {
+	if zone == "" {
+		return a.config.Bind.Zone
+	}
+	return zone
+}
+
+// recordSyncStatus describes a record and the outcome of the most recent update for it, result being nil before the
+// first update. Records belong to the most specific zone of the update holding their name.
+func (a *App) recordSyncStatus(record bind.DNSRecord, result *bind.SyncResult) web.Record {
+	entry := web.Record{
+		Name:   record.Name,
+		Type:   string(record.Type),
+		Value:  record.Value,
+		TTL:    record.TTL,
+		Status: web.RecordPending,
+	}
+	fqdn := dns.CanonicalName(record.Name)
+	if record.Type != bind.TypePTR {
+		entry.Zone = a.recordZoneName(record.Zone)
+		fqdn = dns.CanonicalName(a.zoneFQDN(record.Name, record.Zone))
+	}
+	if result == nil {
+		return entry
+	}
+
+	skipped := func(skipped []bind.SkippedRecord) (string, bool) {
+		for _, skip := range skipped {
+			if skip.Record.Key() == record.Key() && skip.Record.Value == record.Value {
+				return skip.Reason, true
+			}
+		}
+		return "", false
+	}
+	if reason, ok := skipped(result.Skipped); ok {
+		entry.Status, entry.Detail = web.RecordSkipped, reason
+		return entry
+	}
+
+	var zone *bind.ZoneResult
+	for i := range result.Zones {
+		candidate := &result.Zones[i]
+		if dns.IsSubDomain(dns.CanonicalName(candidate.Zone), fqdn) &&
+			(zone == nil || len(candidate.Zone) > len(zone.Zone)) {
+			zone = candidate
+		}
+	}
+	if zone == nil {
+		return entry
+	}
+
+	entry.Zone = zone.Zone
+	switch reason, ok := skipped(zone.Skipped); {
+	case ok:
+		entry.Status, entry.Detail = web.RecordSkipped, reason
+	case zone.Error != "":
+		entry.Status, entry.Detail = web.RecordFailed, zone.Error
+	case result.DryRun:
+		entry.Status = web.RecordDryRun
+	default:
+		entry.Status = web.RecordSynced
+	}
+	return entry
+}
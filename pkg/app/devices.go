@@ -0,0 +1,48 @@
+package app
+
+import (
+	"strings"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/tailscale"
+	"github.com/miekg/dns"
+)
+
+// setDevices stores the names the online machines are published under, which recordDevice looks records up by
+func (a *App) setDevices(machines []tailscale.Machine) {
+	names := a.namer.names(machines)
+	byName := make(map[string]string, len(names))
+	byFQDN := make(map[string]string, len(names))
+	for _, machine := range machines {
+		name, ok := names[machine.ID]
+		if !ok {
+			continue
+		}
+		byName[strings.ToLower(name)] = machine.ID
+		byFQDN[dns.CanonicalName(a.zoneFQDN(name, a.machineZone(machine)))] = machine.ID
+	}
+
+	a.devicesMu.Lock()
+	defer a.devicesMu.Unlock()
+
+	a.deviceNames = byName
+	a.deviceFQDNs = byFQDN
+}
+
+// recordDevice returns the ID of the device a record was published for: the device published under its name or, for
+// CNAME and PTR records such as aliases, the device it points at. It returns an empty string for records of devices
+// that are gone and records not tied to a device.
+func (a *App) recordDevice(record bind.DNSRecord) string {
+	a.devicesMu.Lock()
+	defer a.devicesMu.Unlock()
+
+	if record.Type != bind.TypePTR {
+		if device, ok := a.deviceNames[strings.ToLower(record.Name)]; ok {
+			return device
+		}
+	}
+	if record.Type == bind.TypeCNAME || record.Type == bind.TypePTR {
+		return a.deviceFQDNs[dns.CanonicalName(record.Value)]
+	}
+	return ""
+}
@@ -0,0 +1,49 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/tailscale"
+	"k8s.io/klog/v2"
+)
+
+// With bind.max_record_age, machines that haven't been seen for longer than the maximum age aren't published even when
+// the online heuristic or the offline_polls hysteresis still reports them online, e.g. because the connection state of
+// a vanished device never got updated. Machines that were never seen aren't affected, the age can't be told for them.
+
+// withoutUnseen returns the machines seen within the maximum record age, all of them when it's disabled
+func (a *App) withoutUnseen(machines []tailscale.Machine, now time.Time) []tailscale.Machine {
+	maxAge := a.config.Bind.MaxRecordAge
+	if maxAge <= 0 {
+		return machines
+	}
+
+	seen := make([]tailscale.Machine, 0, len(machines))
+	for _, machine := range machines {
+		if !machine.LastSeen.IsZero() && now.Sub(machine.LastSeen) > maxAge {
+			klog.Warningf("Withdrawing machine %s (%s): not seen for %v, longer than max_record_age", machine.Name,
+				machine.ID, now.Sub(machine.LastSeen).Truncate(time.Second))
+			continue
+		}
+		seen = append(seen, machine)
+	}
+	return seen
+}
+
+// explainMaxAge describes whether a machine was seen recently enough to be published
+func (a *App) explainMaxAge(machine tailscale.Machine, now time.Time) FilterStep {
+	maxAge := a.config.Bind.MaxRecordAge
+	step := FilterStep{Filter: "max_record_age", Passed: true, Detail: "no maximum age"}
+	switch {
+	case maxAge <= 0:
+	case machine.LastSeen.IsZero():
+		step.Detail = "never seen"
+	case now.Sub(machine.LastSeen) > maxAge:
+		step.Passed = false
+		step.Detail = fmt.Sprintf("not seen for more than %v", maxAge)
+	default:
+		step.Detail = fmt.Sprintf("seen within %v", maxAge)
+	}
+	return step
+}
@@ -0,0 +1,153 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/aauren/tailscale-bind-ddns/pkg/tailscale"
+)
+
+// FilterStep is the outcome of one stage of the record pipeline for a machine
+type FilterStep struct {
+	Filter string `json:"filter"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail"`
+}
+
+// MachineExplanation describes how the record pipeline treats a machine: every stage it passed or failed and, when
+// it's published, the name and zone of its records
+type MachineExplanation struct {
+	ID         string       `json:"id"`
+	Name       string       `json:"name"`
+	Published  bool         `json:"published"`
+	RecordName string       `json:"record_name,omitempty"`
+	Zone       string       `json:"zone,omitempty"`
+	Steps      []FilterStep `json:"steps"`
+}
+
+// ExplainMachines fetches every machine of the tailnet, offline ones included, and explains how the record pipeline
+// treats it. Online states are as of this lookup, without the smoothing of online_polls and offline_polls.
+func (a *App) ExplainMachines(ctx context.Context) ([]MachineExplanation, error) {
+	tsClient, err := a.getTailscaleClient()
+	if err != nil {
+		return nil, err
+	}
+
+	machines, err := tsClient.GetMachines(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting machines: %w", err)
+	}
+	return a.explain(machines, clock.Or(a.clock).Now()), nil
+}
+
+// explain explains how the record pipeline treats each of the machines, sorted by name
+func (a *App) explain(machines []tailscale.Machine, now time.Time) []MachineExplanation {
+	names, collisions := a.namer.resolveNames(
+		a.withoutSkippedFunnel(a.filter.apply(a.withoutUnseen(onlineMachines(machines), now))))
+
+	explanations := make([]MachineExplanation, 0, len(machines))
+	for _, machine := range machines {
+		explanation := MachineExplanation{ID: machine.ID, Name: machine.Name}
+
+		hostname, _, _ := strings.Cut(machine.Name, ".")
+		include, exclude := a.filter.explain(hostname)
+		explanation.Steps = []FilterStep{
+			a.explainOnline(machine, now), a.explainMaxAge(machine, now), include, exclude, a.explainFunnel(machine),
+			a.explainAddresses(machine),
+		}
+
+		zone, reason := a.explainZone(machine)
+		zoneStep := FilterStep{Filter: "zone", Passed: true, Detail: "main zone " + a.config.Bind.Zone}
+		if zone != "" {
+			zoneStep.Detail = fmt.Sprintf("zone %s, selected by %s", zone, reason)
+		}
+		explanation.Steps = append(explanation.Steps, zoneStep)
+		if nameStep, ok := a.explainName(machine, names, collisions); ok {
+			explanation.Steps = append(explanation.Steps, nameStep)
+		}
+
+		explanation.Published = !slices.ContainsFunc(explanation.Steps, func(step FilterStep) bool {
+			return !step.Passed
+		})
+		if explanation.Published {
+			explanation.RecordName = names[machine.ID]
+			explanation.Zone = zone
+			if zone == "" {
+				explanation.Zone = a.config.Bind.Zone
+			}
+		}
+		explanations = append(explanations, explanation)
+	}
+
+	slices.SortFunc(explanations, func(x, y MachineExplanation) int {
+		return strings.Compare(x.Name, y.Name)
+	})
+	return explanations
+}
+
+// explainOnline describes the online state of a machine and the heuristic that decided it
+func (a *App) explainOnline(machine tailscale.Machine, now time.Time) FilterStep {
+	heuristic := a.config.Tailscale.OnlineHeuristic
+	if heuristic == "" {
+		heuristic = config.OnlineHeuristicLastSeen
+	}
+
+	lastSeen := "never seen"
+	if !machine.LastSeen.IsZero() {
+		lastSeen = fmt.Sprintf("last seen %v ago", now.Sub(machine.LastSeen).Truncate(time.Second))
+	}
+	state := "offline"
+	if machine.Online {
+		state = "online"
+	}
+	return FilterStep{
+		Filter: "online",
+		Passed: machine.Online,
+		Detail: fmt.Sprintf("%s by the %s heuristic, %s", state, heuristic, lastSeen),
+	}
+}
+
+// explainAddresses describes the addresses published for a machine, which fails machines without any
+func (a *App) explainAddresses(machine tailscale.Machine) FilterStep {
+	addresses := append(slices.Clone(a.machineIPv4Addresses(machine)), a.machineIPv6Addresses(machine)...)
+	if len(addresses) == 0 {
+		return FilterStep{Filter: "addresses", Detail: "no addresses"}
+	}
+	return FilterStep{Filter: "addresses", Passed: true, Detail: strings.Join(addresses, ", ")}
+}
+
+// explainName describes the name collision a machine is part of and how bind.name_collisions resolves it, false when
+// its name is its own
+func (a *App) explainName(machine tailscale.Machine, names map[string]string,
+	collisions map[string][]string) (FilterStep, bool) {
+	for _, name := range slices.Sorted(maps.Keys(collisions)) {
+		ids := collisions[name]
+		index := slices.Index(ids, machine.ID)
+		if index < 0 {
+			continue
+		}
+		others := strings.Join(slices.Delete(slices.Clone(ids), index, index+1), ", ")
+
+		step := FilterStep{Filter: "name", Passed: true}
+		switch strategy := a.namer.collisionStrategy(); {
+		case strategy == config.NameCollisionFail:
+			step.Passed = false
+			step.Detail = fmt.Sprintf("%s shared with %s, nothing is published until that's resolved", name, others)
+		case index == 0:
+			step.Detail = fmt.Sprintf("keeps %s, shared with %s", name, others)
+		case strategy == config.NameCollisionSkip:
+			step.Passed = false
+			step.Detail = fmt.Sprintf("%s already used by %s", name, ids[0])
+		default:
+			step.Detail = fmt.Sprintf("%s already used by %s, published as %s", name, ids[0], names[machine.ID])
+		}
+		return step, true
+	}
+	return FilterStep{}, false
+}
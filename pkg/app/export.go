@@ -0,0 +1,308 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/tailscale"
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// Export formats
+const (
+	// ExportFormatAnsible renders machines as an Ansible dynamic inventory
+	ExportFormatAnsible = "ansible"
+	// ExportFormatBind renders the records as a BIND zone file fragment
+	ExportFormatBind = "bind"
+	// ExportFormatDnsmasq renders the records as dnsmasq configuration
+	ExportFormatDnsmasq = "dnsmasq"
+	// ExportFormatHosts renders the addresses as a hosts file
+	ExportFormatHosts = "hosts"
+)
+
+// exporter renders the machines that would receive DNS records into an external format
+type exporter func(a *App, machines []tailscale.Machine) ([]byte, error)
+
+// exporters holds every supported export format
+var exporters = map[string]exporter{
+	ExportFormatAnsible: (*App).ansibleInventory,
+	ExportFormatBind:    (*App).zoneFragment,
+	ExportFormatDnsmasq: (*App).dnsmasqConfig,
+	ExportFormatHosts:   (*App).hostsFile,
+}
+
+// ExportFormats returns the names of the supported export formats
+func ExportFormats() []string {
+	formats := make([]string, 0, len(exporters))
+	for format := range exporters {
+		formats = append(formats, format)
+	}
+	slices.Sort(formats)
+	return formats
+}
+
+// Export fetches the online machines from Tailscale and renders them in the given format
+func (a *App) Export(ctx context.Context, format string) ([]byte, error) {
+	export, ok := exporters[format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported export format %q (supported: %v)", format, ExportFormats())
+	}
+
+	tsClient, err := a.getTailscaleClient()
+	if err != nil {
+		return nil, err
+	}
+
+	machines, err := tsClient.GetOnlineMachines(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting machines: %w", err)
+	}
+
+	return export(a, a.filter.apply(machines))
+}
+
+// ansibleGroup is a group in an Ansible dynamic inventory
+type ansibleGroup struct {
+	Hosts    []string `json:"hosts,omitempty"`
+	Children []string `json:"children,omitempty"`
+}
+
+// ansibleGroupPattern matches characters that aren't valid in Ansible group names
+var ansibleGroupPattern = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// ansibleInventory renders machines as Ansible dynamic inventory JSON. Hosts are named after their DNS record so
+// that automation targets stay stable, grouped by Tailscale tag (tag:web becomes tag_web) and reached via their
+// Tailscale IP. Untagged machines end up in the ungrouped group.
+func (a *App) ansibleInventory(machines []tailscale.Machine) ([]byte, error) {
+	hostvars := make(map[string]map[string]string)
+	groups := map[string]*ansibleGroup{"ungrouped": {}}
+	names := a.namer.names(machines)
+
+	for _, machine := range machines {
+		if !machine.Online {
+			continue
+		}
+
+		address := machine.IPv4Address
+		if address == "" {
+			address = machine.IPv6Address
+		}
+		if address == "" {
+			continue
+		}
+
+		host := names[machine.ID]
+		if _, exists := hostvars[host]; exists {
+			// Machines whose names sanitize to the same record would overwrite each other in DNS as well
+			continue
+		}
+
+		vars := map[string]string{
+			"ansible_host": address,
+			"tailscale_id": machine.ID,
+		}
+		if zone := a.machineZone(machine); zone != "" || a.config.Bind.Zone != "" {
+			vars["dns_name"] = strings.TrimSuffix(a.zoneFQDN(host, zone), ".")
+		}
+		if machine.IPv4Address != "" {
+			vars["tailscale_ipv4"] = machine.IPv4Address
+		}
+		if machine.IPv6Address != "" {
+			vars["tailscale_ipv6"] = machine.IPv6Address
+		}
+		hostvars[host] = vars
+
+		if len(machine.Tags) == 0 {
+			groups["ungrouped"].Hosts = append(groups["ungrouped"].Hosts, host)
+			continue
+		}
+		for _, tag := range machine.Tags {
+			name := ansibleGroupPattern.ReplaceAllString(strings.Replace(tag, ":", "_", 1), "_")
+			if groups[name] == nil {
+				groups[name] = &ansibleGroup{}
+			}
+			groups[name].Hosts = append(groups[name].Hosts, host)
+		}
+	}
+
+	inventory := map[string]interface{}{
+		"_meta": map[string]interface{}{"hostvars": hostvars},
+	}
+	all := &ansibleGroup{}
+	for name, group := range groups {
+		slices.Sort(group.Hosts)
+		inventory[name] = group
+		all.Children = append(all.Children, name)
+	}
+	slices.Sort(all.Children)
+	inventory["all"] = all
+
+	data, err := json.MarshalIndent(inventory, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encoding ansible inventory: %w", err)
+	}
+	return data, nil
+}
+
+// exportRecords returns the records the machines would be published as, sorted by name and type. Their names are
+// only complete with the main zone, which the formats rendering records require.
+func (a *App) exportRecords(machines []tailscale.Machine) ([]bind.DNSRecord, error) {
+	if a.config.Bind.Zone == "" {
+		return nil, fmt.Errorf("bind.zone is required to export records")
+	}
+	records := slices.Clone(a.desiredRecords(machines))
+	slices.SortStableFunc(records, func(x, y bind.DNSRecord) int {
+		return cmp.Or(
+			strings.Compare(a.recordFQDN(x), a.recordFQDN(y)),
+			strings.Compare(string(x.Key().Type), string(y.Key().Type)),
+			strings.Compare(x.Value, y.Value),
+		)
+	})
+	return records, nil
+}
+
+// recordFQDN returns the fully qualified name of a record, with the trailing dot
+func (a *App) recordFQDN(record bind.DNSRecord) string {
+	if record.Key().Type == bind.TypePTR {
+		return dns.CanonicalName(record.Name)
+	}
+	return dns.CanonicalName(a.zoneFQDN(record.Name, record.Zone))
+}
+
+// exportZone returns the managed zone a record belongs to: the zone of its name for forward records and the most
+// specific managed zone holding the name for PTR records, empty when there is none
+func (a *App) exportZone(record bind.DNSRecord) string {
+	if record.Key().Type != bind.TypePTR {
+		return dns.CanonicalName(cmp.Or(record.Zone, a.config.Bind.Zone))
+	}
+	name, best := a.recordFQDN(record), ""
+	for _, zone := range a.config.ManagedZones() {
+		zone = dns.CanonicalName(zone)
+		if zone != "." && dns.IsSubDomain(zone, name) && dns.CountLabel(zone) > dns.CountLabel(best) {
+			best = zone
+		}
+	}
+	return best
+}
+
+// zoneFragment renders the records as BIND zone file fragments in presentation format, one section per zone with
+// fully qualified names, ready to be pasted into a static zone or included with $INCLUDE
+func (a *App) zoneFragment(machines []tailscale.Machine) ([]byte, error) {
+	records, err := a.exportRecords(machines)
+	if err != nil {
+		return nil, err
+	}
+
+	zones := make(map[string][]string)
+	for _, record := range records {
+		zone := a.exportZone(record)
+		if zone == "" {
+			klog.Warningf("Skipping PTR record %s: no managed zone holds its name", record.Name)
+			continue
+		}
+		rr := record.RR(zone)
+		if rr == nil {
+			klog.Warningf("Skipping %s record %s: invalid value %q", record.Key().Type, record.Name, record.Value)
+			continue
+		}
+		zones[zone] = append(zones[zone], rr.String())
+	}
+
+	var out strings.Builder
+	out.WriteString("; Generated by tailscale-bind-ddns\n")
+	for _, zone := range slices.Sorted(maps.Keys(zones)) {
+		fmt.Fprintf(&out, "\n; %s\n", zone)
+		for _, rr := range slices.Compact(zones[zone]) {
+			out.WriteString(rr + "\n")
+		}
+	}
+	return []byte(out.String()), nil
+}
+
+// dnsmasqConfig renders the records as dnsmasq configuration. The addresses of a name share a host-record, which also
+// answers the reverse lookups of its addresses, so only the PTR records pointing elsewhere get a ptr-record.
+func (a *App) dnsmasqConfig(machines []tailscale.Machine) ([]byte, error) {
+	records, err := a.exportRecords(machines)
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []string
+	addresses := make(map[string][]string)
+	ttls := make(map[string]uint32)
+	reverse := make(map[string]string)
+	for _, record := range records {
+		if record.Key().Type != bind.TypeA && record.Key().Type != bind.TypeAAAA {
+			continue
+		}
+		name := strings.TrimSuffix(a.recordFQDN(record), ".")
+		if _, seen := addresses[name]; !seen {
+			hosts = append(hosts, name)
+		}
+		addresses[name] = append(addresses[name], record.Value)
+		ttls[name] = max(ttls[name], record.TTL)
+		if ptr, err := dns.ReverseAddr(record.Value); err == nil {
+			reverse[ptr] = name
+		}
+	}
+
+	var out strings.Builder
+	out.WriteString("# Generated by tailscale-bind-ddns\n")
+	for _, host := range hosts {
+		fmt.Fprintf(&out, "host-record=%s,%s,%d\n", host, strings.Join(addresses[host], ","), ttls[host])
+	}
+	for _, record := range records {
+		name := strings.TrimSuffix(a.recordFQDN(record), ".")
+		value := strings.TrimSuffix(record.Value, ".")
+		switch record.Type {
+		case bind.TypeA, bind.TypeAAAA:
+			// Written as host-records above
+		case bind.TypeCNAME:
+			fmt.Fprintf(&out, "cname=%s,%s,%d\n", name, value, record.TTL)
+		case bind.TypeTXT:
+			fmt.Fprintf(&out, "txt-record=%s,%q\n", name, record.Value)
+		case bind.TypePTR:
+			if reverse[dns.CanonicalName(record.Name)] != strings.ToLower(value) {
+				fmt.Fprintf(&out, "ptr-record=%s,%s\n", name, value)
+			}
+		}
+	}
+	return []byte(out.String()), nil
+}
+
+// hostsFile renders the addresses as a hosts file, one line per address. The CNAME records pointing at a name are
+// added to its lines as aliases, the other records can't be expressed in a hosts file and are left out.
+func (a *App) hostsFile(machines []tailscale.Machine) ([]byte, error) {
+	records, err := a.exportRecords(machines)
+	if err != nil {
+		return nil, err
+	}
+
+	aliases := make(map[string][]string)
+	for _, record := range records {
+		if record.Key().Type == bind.TypeCNAME {
+			target := dns.CanonicalName(record.Value)
+			aliases[target] = append(aliases[target], strings.TrimSuffix(a.recordFQDN(record), "."))
+		}
+	}
+
+	var out strings.Builder
+	out.WriteString("# Generated by tailscale-bind-ddns\n")
+	for _, record := range records {
+		if record.Key().Type != bind.TypeA && record.Key().Type != bind.TypeAAAA {
+			continue
+		}
+		name := a.recordFQDN(record)
+		names := append([]string{strings.TrimSuffix(name, ".")}, aliases[name]...)
+		fmt.Fprintf(&out, "%s\t%s\n", record.Value, strings.Join(names, " "))
+	}
+	return []byte(out.String()), nil
+}
@@ -0,0 +1,147 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/tailscale"
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// SetExternalRecords replaces the desired records injected by an external source and republishes the merged record
+// set. Records without a TTL get the configured one. An empty set removes the source, after which the records it
+// injected are cleaned up like those of a machine that went away.
+func (a *App) SetExternalRecords(source string, records []bind.DNSRecord) error {
+	if source == "" {
+		return fmt.Errorf("source is required")
+	}
+
+	records = slices.Clone(records)
+	for i := range records {
+		if err := validateExternalRecord(records[i]); err != nil {
+			return fmt.Errorf("record %d: %w", i, err)
+		}
+		if records[i].TTL == 0 {
+			records[i].TTL = uint32(a.config.Bind.TTL.Seconds())
+		}
+	}
+
+	a.externalMu.Lock()
+	if len(records) == 0 {
+		delete(a.external, source)
+	} else {
+		if a.external == nil {
+			a.external = make(map[string][]bind.DNSRecord)
+		}
+		a.external[source] = records
+	}
+	a.externalMu.Unlock()
+
+	klog.Infof("External source %s now provides %d records", source, len(records))
+
+	// Ask the converter to republish, a pending request already covers this change
+	select {
+	case a.externalChanged <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// ExternalRecords returns the desired records injected by each external source
+func (a *App) ExternalRecords() map[string][]bind.DNSRecord {
+	a.externalMu.Lock()
+	defer a.externalMu.Unlock()
+
+	sources := make(map[string][]bind.DNSRecord, len(a.external))
+	for source, records := range a.external {
+		sources[source] = slices.Clone(records)
+	}
+	return sources
+}
+
+// desiredRecords returns the full record set to publish: the records derived from machines merged with the external
+// records, see timedDesiredRecords
+func (a *App) desiredRecords(machines []tailscale.Machine) []bind.DNSRecord {
+	records, _ := a.timedDesiredRecords(machines)
+	return records
+}
+
+// withExternalRecords merges the external records into the records derived from machines. Records derived from
+// Tailscale win conflicts, and between external sources the first in sorted order wins.
+func (a *App) withExternalRecords(records []bind.DNSRecord) []bind.DNSRecord {
+	a.externalMu.Lock()
+	defer a.externalMu.Unlock()
+
+	if len(a.external) == 0 {
+		return records
+	}
+
+	owners := make(map[bind.RecordKey]string, len(records))
+	for _, record := range records {
+		owners[record.Key()] = "tailscale"
+	}
+
+	for _, source := range slices.Sorted(maps.Keys(a.external)) {
+		for _, record := range a.external[source] {
+			if owner, taken := owners[record.Key()]; taken && owner != source {
+				klog.Warningf("Ignoring %s record %s from external source %s: already provided by %s",
+					record.Key().Type, record.Name, source, owner)
+				continue
+			}
+			owners[record.Key()] = source
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// validateExternalRecord checks that an injected record is one the provider knows how to publish
+func validateExternalRecord(record bind.DNSRecord) error {
+	if _, ok := dns.IsDomainName(record.Name); !ok || record.Name == "" {
+		return fmt.Errorf("invalid name %q", record.Name)
+	}
+
+	switch record.Type {
+	case bind.TypeA, bind.TypeAAAA:
+		if strings.HasSuffix(record.Name, ".") {
+			return fmt.Errorf("%s record name %q must be relative to the zone", record.Key().Type, record.Name)
+		}
+		ip := net.ParseIP(record.Value)
+		if ip == nil || (ip.To4() != nil) != (record.Key().Type == bind.TypeA) {
+			return fmt.Errorf("invalid %s record value %q", record.Key().Type, record.Value)
+		}
+	case bind.TypePTR:
+		name := strings.ToLower(dns.Fqdn(record.Name))
+		if !strings.HasSuffix(name, ".in-addr.arpa.") && !strings.HasSuffix(name, ".ip6.arpa.") {
+			return fmt.Errorf("PTR record name %q is not a reverse name", record.Name)
+		}
+		if _, ok := dns.IsDomainName(record.Value); !ok || record.Value == "" {
+			return fmt.Errorf("invalid PTR record value %q", record.Value)
+		}
+	case bind.TypeCNAME, bind.TypeTXT:
+		return fmt.Errorf("unsupported record type %q", record.Type)
+	default:
+		return fmt.Errorf("unsupported record type %q", record.Type)
+	}
+	return nil
+}
+
+// republish rebuilds the desired records from the most recent machines and hands them to the provider. Nothing is
+// sent before the first poll, since publishing without machines would remove all of their records.
+func (a *App) republish(ctx context.Context) {
+	a.externalMu.Lock()
+	machines, polled := a.lastMachines, a.polled
+	a.externalMu.Unlock()
+
+	if !polled {
+		klog.V(1).Info("Holding back external records until the first Tailscale poll")
+		return
+	}
+	a.publish(ctx, machines, 0)
+}
@@ -0,0 +1,111 @@
+package app
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/tailscale"
+	"k8s.io/klog/v2"
+)
+
+// hostnameFilter opts machines in or out of DNS publication by hostname
+type hostnameFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// newHostnameFilter compiles the include and exclude patterns. A nil filter, returned when no patterns are set,
+// publishes every machine.
+func newHostnameFilter(include, exclude []string) (*hostnameFilter, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+
+	filter := &hostnameFilter{}
+	for _, pattern := range include {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("compiling include_hostnames pattern %q: %w", pattern, err)
+		}
+		filter.include = append(filter.include, re)
+	}
+	for _, pattern := range exclude {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("compiling exclude_hostnames pattern %q: %w", pattern, err)
+		}
+		filter.exclude = append(filter.exclude, re)
+	}
+	return filter, nil
+}
+
+// allows reports whether a hostname is published: it must match an include pattern, if any are set, and no exclude
+// pattern
+func (f *hostnameFilter) allows(hostname string) bool {
+	if f == nil {
+		return true
+	}
+
+	if len(f.include) > 0 && !matchesAny(f.include, hostname) {
+		return false
+	}
+	return !matchesAny(f.exclude, hostname)
+}
+
+// apply returns the machines whose hostname the filter allows. Hostnames are the machine's Tailscale name without the
+// tailnet domain.
+func (f *hostnameFilter) apply(machines []tailscale.Machine) []tailscale.Machine {
+	if f == nil {
+		return machines
+	}
+
+	filtered := make([]tailscale.Machine, 0, len(machines))
+	for _, machine := range machines {
+		hostname, _, _ := strings.Cut(machine.Name, ".")
+		if !f.allows(hostname) {
+			klog.V(1).Infof("Skipping machine %s (%s): filtered by hostname", machine.Name, machine.ID)
+			continue
+		}
+		filtered = append(filtered, machine)
+	}
+	return filtered
+}
+
+// explain returns the outcome of the include and exclude patterns for a hostname
+func (f *hostnameFilter) explain(hostname string) (FilterStep, FilterStep) {
+	include := FilterStep{Filter: "include_hostnames", Passed: true, Detail: "no include patterns"}
+	exclude := FilterStep{Filter: "exclude_hostnames", Passed: true, Detail: "no exclude patterns"}
+	if f == nil {
+		return include, exclude
+	}
+
+	if len(f.include) > 0 {
+		include.Passed, include.Detail = false, "matches none of the patterns"
+		if re := firstMatch(f.include, hostname); re != nil {
+			include.Passed, include.Detail = true, "matches "+re.String()
+		}
+	}
+	if len(f.exclude) > 0 {
+		exclude.Detail = "matches none of the patterns"
+		if re := firstMatch(f.exclude, hostname); re != nil {
+			exclude.Passed, exclude.Detail = false, "matches "+re.String()
+		}
+	}
+	return include, exclude
+}
+
+// firstMatch returns the first of the patterns matching s, nil when none does
+func firstMatch(patterns []*regexp.Regexp, s string) *regexp.Regexp {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return re
+		}
+	}
+	return nil
+}
+
+// matchesAny reports whether any of the patterns match s
+func matchesAny(patterns []*regexp.Regexp, s string) bool {
+	return firstMatch(patterns, s) != nil
+}
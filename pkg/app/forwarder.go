@@ -0,0 +1,234 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// The forwarder is a small DNS server that answers queries for the managed zones straight from the desired records,
+// without any BIND server involved, and forwards every other query to the upstream servers. Pointed at by MagicDNS
+// split DNS, it resolves tailnet names in environments that have no DNS server taking dynamic updates. Only names
+// holding records are known to it, so queries for other names in the managed zones get NXDOMAIN.
+
+const (
+	forwarderTimeout = 5 * time.Second
+	// maxCNAMEChain bounds how many aliases are followed within the managed zones when answering a query
+	maxCNAMEChain = 8
+)
+
+// forwarder answers queries for the managed zones and forwards the rest
+type forwarder struct {
+	zone      string   // Zone of records without one of their own
+	zones     []string // Canonical names of every managed zone
+	upstreams []string
+
+	mu      sync.RWMutex
+	records map[string][]dns.RR // Desired records by canonical owner name
+}
+
+// newForwarder returns the forwarder of the configuration, nil when it's disabled
+func newForwarder(cfg *config.Config) *forwarder {
+	if cfg.Forwarder.Address == "" {
+		return nil
+	}
+
+	zones := cfg.ManagedZones()
+	for i, zone := range zones {
+		zones[i] = dns.CanonicalName(zone)
+	}
+
+	upstreams := make([]string, 0, len(cfg.Forwarder.Upstreams))
+	for _, upstream := range cfg.Forwarder.Upstreams {
+		// Upstreams without a port are standard DNS servers
+		if _, _, err := net.SplitHostPort(upstream); err != nil {
+			upstream = net.JoinHostPort(upstream, "53")
+		}
+		upstreams = append(upstreams, upstream)
+	}
+
+	return &forwarder{
+		zone:      cfg.Bind.Zone,
+		zones:     zones,
+		upstreams: upstreams,
+		records:   map[string][]dns.RR{},
+	}
+}
+
+// update replaces the records the forwarder answers with
+func (f *forwarder) update(records []bind.DNSRecord) {
+	byName := make(map[string][]dns.RR, len(records))
+	for _, record := range records {
+		zone := record.Zone
+		if zone == "" {
+			zone = f.zone
+		}
+		rr := record.RR(zone)
+		if rr == nil {
+			klog.Warningf("Forwarder can't serve %s record %s -> %s", record.Type, record.Name, record.Value)
+			continue
+		}
+		name := rr.Header().Name
+		byName[name] = append(byName[name], rr)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.records = byName
+}
+
+// managedZone returns the managed zone a name belongs to, the empty string when it doesn't belong to any
+func (f *forwarder) managedZone(name string) string {
+	var match string
+	for _, zone := range f.zones {
+		// The most specific zone wins, e.g. a sub-zone configured next to its parent
+		if dns.IsSubDomain(zone, name) && len(zone) > len(match) {
+			match = zone
+		}
+	}
+	return match
+}
+
+// ServeDNS answers a query from the desired records or forwards it to the upstream servers
+func (f *forwarder) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	var resp *dns.Msg
+	if len(req.Question) != 1 || req.Opcode != dns.OpcodeQuery {
+		resp = new(dns.Msg)
+		resp.SetRcode(req, dns.RcodeFormatError)
+	} else if zone := f.managedZone(dns.CanonicalName(req.Question[0].Name)); zone != "" {
+		resp = f.answer(req, zone)
+	} else {
+		resp = f.forward(req, w.RemoteAddr().Network())
+	}
+
+	if err := w.WriteMsg(resp); err != nil {
+		klog.V(1).Infof("Failed to answer DNS query from %s: %v", w.RemoteAddr(), err)
+	}
+}
+
+// answer builds the authoritative answer to a query for a name in a managed zone. Aliases are followed as long as
+// their targets stay within the managed zones.
+func (f *forwarder) answer(req *dns.Msg, zone string) *dns.Msg {
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Authoritative = true
+	resp.RecursionAvailable = len(f.upstreams) > 0
+
+	question := req.Question[0]
+	name := dns.CanonicalName(question.Name)
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if len(f.records[name]) == 0 && name != zone {
+		resp.Rcode = dns.RcodeNameError
+		return resp
+	}
+
+	for range maxCNAMEChain {
+		var cname *dns.CNAME
+		matched := false
+		for _, rr := range f.records[name] {
+			if question.Qtype == dns.TypeANY || rr.Header().Rrtype == question.Qtype {
+				resp.Answer = append(resp.Answer, dns.Copy(rr))
+				matched = true
+			} else if alias, ok := rr.(*dns.CNAME); ok {
+				cname = alias
+			}
+		}
+		if matched || cname == nil {
+			break
+		}
+
+		resp.Answer = append(resp.Answer, dns.Copy(cname))
+		name = dns.CanonicalName(cname.Target)
+		if f.managedZone(name) == "" {
+			break
+		}
+	}
+	return resp
+}
+
+// forward relays a query to the upstream servers in order, over the network it was received on, and returns the first
+// response. Queries are refused when there are no upstreams.
+func (f *forwarder) forward(req *dns.Msg, network string) *dns.Msg {
+	resp := new(dns.Msg)
+	if len(f.upstreams) == 0 {
+		resp.SetRcode(req, dns.RcodeRefused)
+		return resp
+	}
+
+	client := &dns.Client{Net: network, Timeout: forwarderTimeout}
+	for _, upstream := range f.upstreams {
+		upstreamResp, _, err := client.Exchange(req, upstream)
+		if err == nil {
+			return upstreamResp
+		}
+		klog.V(1).Infof("Forwarding query for %s to %s failed: %v", req.Question[0].Name, upstream, err)
+	}
+
+	resp.SetRcode(req, dns.RcodeServerFailure)
+	return resp
+}
+
+// listenForwarder opens the UDP and TCP listeners of the forwarder
+func listenForwarder(address string) (net.PacketConn, net.Listener, error) {
+	pc, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, nil, err
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		pc.Close()
+		return nil, nil, err
+	}
+	return pc, listener, nil
+}
+
+// serveForwarder serves the forwarder on both listeners until the context is cancelled
+func (a *App) serveForwarder(ctx context.Context, pc net.PacketConn, listener net.Listener) {
+	servers := []*dns.Server{
+		{PacketConn: pc, Handler: a.forwarder},
+		{Listener: listener, Handler: a.forwarder},
+	}
+
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := server.ActivateAndServe(); err != nil && !errors.Is(err, net.ErrClosed) {
+				klog.Errorf("DNS forwarder failed: %v", err)
+			}
+		}()
+	}
+
+	klog.Infof("Serving DNS for %s on %s, forwarding to %s", strings.Join(a.forwarder.zones, ", "), pc.LocalAddr(),
+		forwarderUpstreams(a.forwarder.upstreams))
+	<-ctx.Done()
+
+	for _, server := range servers {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), statusShutdownTimeout)
+		if err := server.ShutdownContext(shutdownCtx); err != nil {
+			klog.Errorf("Failed to shut down DNS forwarder: %v", err)
+		}
+		cancel()
+	}
+	wg.Wait()
+}
+
+// forwarderUpstreams describes the upstreams for logging
+func forwarderUpstreams(upstreams []string) string {
+	if len(upstreams) == 0 {
+		return "nowhere"
+	}
+	return strings.Join(upstreams, ", ")
+}
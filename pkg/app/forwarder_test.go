@@ -0,0 +1,180 @@
+package app
+
+import (
+	"net"
+	"testing"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startForwarderTestServer serves handler over UDP on localhost and returns its address
+func startForwarderTestServer(t *testing.T, handler dns.Handler) string {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: pc, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+	go func() {
+		_ = server.ActivateAndServe()
+	}()
+	<-started
+	t.Cleanup(func() {
+		_ = server.Shutdown()
+	})
+	return pc.LocalAddr().String()
+}
+
+func TestForwarder(t *testing.T) {
+	upstream := startForwarderTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		rr, _ := dns.NewRR(r.Question[0].Name + " 60 IN A 192.0.2.1")
+		m.Answer = append(m.Answer, rr)
+		_ = w.WriteMsg(m)
+	}))
+
+	cfg := &config.Config{
+		Bind: config.BindConfig{
+			Zone:  "ts.example.com",
+			Zones: []config.ZoneConfig{{Name: "servers.example.com"}},
+			PTR:   config.PTRConfig{Enabled: true, IPv4Zone: "64.100.in-addr.arpa"},
+		},
+		Forwarder: config.ForwarderConfig{Address: "127.0.0.1:0", Upstreams: []string{upstream}},
+	}
+	fwd := newForwarder(cfg)
+	require.NotNil(t, fwd)
+	fwd.update([]bind.DNSRecord{
+		{Name: "laptop", Value: "100.64.0.1", TTL: 300, Type: "A"},
+		{Name: "laptop", Value: "100.64.0.2", TTL: 300, Type: "A"},
+		{Name: "laptop", Value: "fd7a:115c:a1e0::1", TTL: 300, Type: "AAAA"},
+		{Name: "notes", Value: "laptop.ts.example.com", TTL: 300, Type: "CNAME"},
+		{Name: "db", Value: "100.64.0.3", TTL: 300, Type: "A", Zone: "servers.example.com"},
+		{Name: "1.0.64.100.in-addr.arpa.", Value: "laptop.ts.example.com", TTL: 300, Type: "PTR"},
+	})
+	address := startForwarderTestServer(t, fwd)
+
+	tests := []struct {
+		name      string
+		question  string
+		qtype     uint16
+		wantRcode int
+		wantAuth  bool
+		want      []string
+	}{
+		{
+			name:      "A records of a machine",
+			question:  "Laptop.TS.example.com.",
+			qtype:     dns.TypeA,
+			wantRcode: dns.RcodeSuccess,
+			wantAuth:  true,
+			want:      []string{"100.64.0.1", "100.64.0.2"},
+		},
+		{
+			name:      "AAAA record of a machine",
+			question:  "laptop.ts.example.com.",
+			qtype:     dns.TypeAAAA,
+			wantRcode: dns.RcodeSuccess,
+			wantAuth:  true,
+			want:      []string{"fd7a:115c:a1e0::1"},
+		},
+		{
+			name:      "alias followed to its target",
+			question:  "notes.ts.example.com.",
+			qtype:     dns.TypeA,
+			wantRcode: dns.RcodeSuccess,
+			wantAuth:  true,
+			want:      []string{"laptop.ts.example.com.", "100.64.0.1", "100.64.0.2"},
+		},
+		{
+			name:      "record in an additional zone",
+			question:  "db.servers.example.com.",
+			qtype:     dns.TypeA,
+			wantRcode: dns.RcodeSuccess,
+			wantAuth:  true,
+			want:      []string{"100.64.0.3"},
+		},
+		{
+			name:      "PTR record",
+			question:  "1.0.64.100.in-addr.arpa.",
+			qtype:     dns.TypePTR,
+			wantRcode: dns.RcodeSuccess,
+			wantAuth:  true,
+			want:      []string{"laptop.ts.example.com."},
+		},
+		{
+			name:      "name without records of the type",
+			question:  "laptop.ts.example.com.",
+			qtype:     dns.TypeTXT,
+			wantRcode: dns.RcodeSuccess,
+			wantAuth:  true,
+		},
+		{
+			name:      "unknown name in a managed zone",
+			question:  "desktop.ts.example.com.",
+			qtype:     dns.TypeA,
+			wantRcode: dns.RcodeNameError,
+			wantAuth:  true,
+		},
+		{
+			name:      "name outside the managed zones is forwarded",
+			question:  "www.example.org.",
+			qtype:     dns.TypeA,
+			wantRcode: dns.RcodeSuccess,
+			want:      []string{"192.0.2.1"},
+		},
+	}
+
+	client := &dns.Client{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := new(dns.Msg)
+			req.SetQuestion(tt.question, tt.qtype)
+			resp, _, err := client.Exchange(req, address)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantRcode, resp.Rcode)
+			assert.Equal(t, tt.wantAuth, resp.Authoritative)
+			var got []string
+			for _, rr := range resp.Answer {
+				switch rr := rr.(type) {
+				case *dns.A:
+					got = append(got, rr.A.String())
+				case *dns.AAAA:
+					got = append(got, rr.AAAA.String())
+				case *dns.CNAME:
+					got = append(got, rr.Target)
+				case *dns.PTR:
+					got = append(got, rr.Ptr)
+				}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestForwarderWithoutUpstreams(t *testing.T) {
+	fwd := newForwarder(&config.Config{
+		Bind:      config.BindConfig{Zone: "ts.example.com"},
+		Forwarder: config.ForwarderConfig{Address: "127.0.0.1:0"},
+	})
+	address := startForwarderTestServer(t, fwd)
+
+	req := new(dns.Msg)
+	req.SetQuestion("www.example.org.", dns.TypeA)
+	resp, _, err := new(dns.Client).Exchange(req, address)
+	require.NoError(t, err)
+	assert.Equal(t, dns.RcodeRefused, resp.Rcode)
+
+	// The zone apex exists even before the first records were published
+	req.SetQuestion("ts.example.com.", dns.TypeA)
+	resp, _, err = new(dns.Client).Exchange(req, address)
+	require.NoError(t, err)
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	assert.Empty(t, resp.Answer)
+}
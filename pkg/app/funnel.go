@@ -0,0 +1,76 @@
+package app
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/aauren/tailscale-bind-ddns/pkg/tailscale"
+	"k8s.io/klog/v2"
+)
+
+// The Tailscale API doesn't tell which devices have Funnel enabled, so bind.funnel selects them by tag. In cname mode
+// the internal name of a device becomes a CNAME record pointing at its public ts.net hostname, e.g.
+//
+//	web.ts.example.com. 300 IN CNAME web.tail1234.ts.net.
+//
+// so that internal clients take the same path as public ones. Since a CNAME can't share its name with other records,
+// such devices get no A/AAAA or metadata records, and no PTR records either, which shouldn't point at a CNAME. In skip
+// mode devices get no records at all.
+
+// funnelMode returns how a machine is published according to the funnel tags it carries, "" when it has none. Skipping
+// wins over a CNAME when several of its tags are configured.
+func (a *App) funnelMode(machine tailscale.Machine) string {
+	mode := ""
+	// Tags are matched case-insensitively, the configuration file loader lowercases keys
+	for tag, tagMode := range a.config.Bind.Funnel {
+		if !slices.ContainsFunc(machine.Tags, func(t string) bool { return strings.EqualFold(t, tag) }) {
+			continue
+		}
+		if mode == "" || tagMode == config.FunnelSkip {
+			mode = tagMode
+		}
+	}
+	return mode
+}
+
+// withoutSkippedFunnel returns the machines that aren't left out of DNS as Funnel devices
+func (a *App) withoutSkippedFunnel(machines []tailscale.Machine) []tailscale.Machine {
+	if len(a.config.Bind.Funnel) == 0 {
+		return machines
+	}
+
+	published := make([]tailscale.Machine, 0, len(machines))
+	for _, machine := range machines {
+		if a.funnelMode(machine) == config.FunnelSkip {
+			klog.V(2).Infof("Not publishing Funnel machine %s (%s)", machine.Name, machine.ID)
+			continue
+		}
+		published = append(published, machine)
+	}
+	return published
+}
+
+// funnelRecord returns the CNAME record pointing the record name of a Funnel machine at its public hostname
+func (a *App) funnelRecord(machine tailscale.Machine, recordName string) bind.DNSRecord {
+	return bind.DNSRecord{
+		Name:  recordName,
+		Value: machine.Name,
+		TTL:   a.recordTTL(machine),
+		Type:  bind.TypeCNAME,
+		Zone:  a.machineZone(machine),
+	}
+}
+
+// explainFunnel describes how a machine is published as a Funnel device, which fails machines that are skipped
+func (a *App) explainFunnel(machine tailscale.Machine) FilterStep {
+	switch a.funnelMode(machine) {
+	case config.FunnelSkip:
+		return FilterStep{Filter: "funnel", Detail: "Funnel device, not published"}
+	case config.FunnelCNAME:
+		return FilterStep{Filter: "funnel", Passed: true, Detail: "Funnel device, CNAME to " + machine.Name}
+	default:
+		return FilterStep{Filter: "funnel", Passed: true, Detail: "not a Funnel device"}
+	}
+}
@@ -0,0 +1,128 @@
+package app
+
+import (
+	"strconv"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"github.com/aauren/tailscale-bind-ddns/pkg/snmp"
+)
+
+// Gauges are the key numbers of the running daemon, served on GaugesPath for monitoring systems that can't make sense
+// of the status, e.g. SNMP through the snmp-pass-persist command
+type Gauges struct {
+	// Devices is the number of devices in the tailnet and OnlineMachines the number of them online as of the most
+	// recent poll
+	Devices        int `json:"devices"`
+	OnlineMachines int `json:"online_machines"`
+	// ManagedRecords is the number of records most recently handed to the DNS provider
+	ManagedRecords int `json:"managed_records"`
+	// SecondsSinceSync is how long ago the records were last handed to the DNS provider, -1 before the first time
+	SecondsSinceSync int64 `json:"seconds_since_sync"`
+	// FailedUpdates counts the zone updates that failed since the start and ConsecutiveFailures is how often in a row
+	// the update of the zone failing the longest failed
+	FailedUpdates       uint64 `json:"failed_updates"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	// RateLimited counts the Tailscale API requests refused for rate limiting today
+	RateLimited      int  `json:"rate_limited"`
+	QuarantinedNames int  `json:"quarantined_names"`
+	Paused           bool `json:"paused"`
+	// SLOViolated reports whether the zone updates currently violate the objectives of bind.slo
+	SLOViolated bool `json:"slo_violated"`
+	// NameCollisions is the number of names several devices got in the most recent poll
+	NameCollisions int `json:"name_collisions"`
+	// JoinLatencyP95 is the 95th percentile in milliseconds of the latencies from devices joining the tailnet to
+	// their records resolving, 0 before any was measured
+	JoinLatencyP95 int64 `json:"join_latency_p95_ms"`
+}
+
+// Gauges returns the current gauges of the application
+func (a *App) Gauges() Gauges {
+	gauges := Gauges{
+		FailedUpdates:    a.failedUpdates.Load(),
+		QuarantinedNames: a.quarantine.size(),
+		NameCollisions:   int(a.nameCollisions.Load()),
+		Paused:           a.Paused(),
+		SecondsSinceSync: -1,
+	}
+	if slo := a.slo.status(clock.Or(a.clock).Now()); slo != nil {
+		gauges.SLOViolated = slo.Violated
+	}
+	if joins := a.joins.status(); joins != nil {
+		gauges.JoinLatencyP95 = joins.P95.Milliseconds()
+	}
+
+	records, lastSync := a.ManagedRecords()
+	gauges.ManagedRecords = len(records)
+	if !lastSync.IsZero() {
+		gauges.SecondsSinceSync = int64(clock.Or(a.clock).Since(lastSync).Seconds())
+	}
+
+	a.externalMu.Lock()
+	gauges.OnlineMachines = len(a.lastMachines)
+	a.externalMu.Unlock()
+
+	a.clientsMu.Lock()
+	provider := a.provider
+	tsClient := a.tailscaleClient
+	a.clientsMu.Unlock()
+
+	if tsClient != nil {
+		usage := tsClient.Usage()
+		gauges.Devices = usage.Devices
+		gauges.RateLimited = usage.RateLimited
+	}
+	if reporter, ok := provider.(zoneStatusReporter); ok {
+		for _, zone := range reporter.ZoneStatuses() {
+			gauges.ConsecutiveFailures = max(gauges.ConsecutiveFailures, zone.Failures)
+		}
+	}
+	return gauges
+}
+
+// countFailedUpdates adds the zones whose update failed to the failed updates
+func (a *App) countFailedUpdates(result *bind.SyncResult) {
+	for _, zone := range result.Zones {
+		if zone.Error != "" {
+			a.failedUpdates.Add(1)
+		}
+	}
+}
+
+// SNMPVariables returns the gauges as SNMP variables under the base OID
+func (g Gauges) SNMPVariables(base string) []snmp.Variable {
+	flag := func(set bool) int64 {
+		if set {
+			return 1
+		}
+		return 0
+	}
+	values := []struct {
+		kind  string
+		value int64
+	}{
+		{snmp.TypeGauge, int64(g.Devices)},
+		{snmp.TypeGauge, int64(g.OnlineMachines)},
+		{snmp.TypeGauge, int64(g.ManagedRecords)},
+		{snmp.TypeInteger, g.SecondsSinceSync},
+		// Counters wrap around at 2^32
+		{snmp.TypeCounter, int64(uint32(g.FailedUpdates))},
+		{snmp.TypeGauge, int64(g.ConsecutiveFailures)},
+		{snmp.TypeGauge, int64(g.RateLimited)},
+		{snmp.TypeGauge, int64(g.QuarantinedNames)},
+		{snmp.TypeInteger, flag(g.Paused)},
+		{snmp.TypeInteger, flag(g.SLOViolated)},
+		{snmp.TypeGauge, int64(g.NameCollisions)},
+		{snmp.TypeGauge, g.JoinLatencyP95},
+	}
+
+	variables := make([]snmp.Variable, 0, len(values))
+	for i, v := range values {
+		variables = append(variables, snmp.Variable{
+			OID:   snmp.JoinOID(base, i+1),
+			Type:  v.kind,
+			Value: strconv.FormatInt(v.value, 10),
+		})
+	}
+	return variables
+}
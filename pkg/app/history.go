@@ -0,0 +1,179 @@
+package app
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/tailscale"
+)
+
+// maxHistoryDevices bounds how many devices the history tracks. Beyond it, the devices that have been offline the
+// longest are forgotten first.
+const maxHistoryDevices = 4096
+
+// TransitionKind describes how a device changed between two polls
+type TransitionKind string
+
+const (
+	TransitionOnline         TransitionKind = "online"
+	TransitionOffline        TransitionKind = "offline"
+	TransitionAddressChanged TransitionKind = "address_changed"
+)
+
+// Transition is a single change of a device, with the addresses it had afterwards
+type Transition struct {
+	Time        time.Time      `json:"time"`
+	Kind        TransitionKind `json:"kind"`
+	IPv4Address string         `json:"ipv4_address,omitempty"`
+	IPv6Address string         `json:"ipv6_address,omitempty"`
+}
+
+// DeviceHistory holds the most recent transitions of a device, oldest first
+type DeviceHistory struct {
+	ID          string       `json:"id"`
+	Name        string       `json:"name"`
+	RecordName  string       `json:"record_name"`
+	Online      bool         `json:"online"`
+	Transitions []Transition `json:"transitions"`
+	// Flaps is how often the device came back online after going offline within the kept transitions
+	Flaps int `json:"flaps"`
+}
+
+// countFlaps counts the offline to online transitions
+func countFlaps(transitions []Transition) int {
+	flaps := 0
+	for i := 1; i < len(transitions); i++ {
+		if transitions[i].Kind == TransitionOnline && transitions[i-1].Kind == TransitionOffline {
+			flaps++
+		}
+	}
+	return flaps
+}
+
+// deviceState is what the history tracks per device
+type deviceState struct {
+	history  DeviceHistory
+	lastSeen tailscale.Machine
+	changed  time.Time
+}
+
+// history keeps a bounded record of device transitions between polls so that flapping hosts can be spotted
+type history struct {
+	mu      sync.Mutex
+	size    int
+	namer   *recordNamer
+	devices map[string]*deviceState
+}
+
+// newHistory returns a history keeping size transitions per device, named by namer
+func newHistory(size int, namer *recordNamer) *history {
+	return &history{size: size, namer: namer, devices: make(map[string]*deviceState)}
+}
+
+// observe compares the machines of a poll with the previous poll and records the transitions
+func (h *history) observe(machines []tailscale.Machine, now time.Time) {
+	if h == nil || h.size <= 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	names := h.namer.names(machines)
+	present := make(map[string]bool, len(machines))
+	for _, machine := range machines {
+		if !machine.Online {
+			continue
+		}
+		present[machine.ID] = true
+
+		state, ok := h.devices[machine.ID]
+		if !ok {
+			state = &deviceState{history: DeviceHistory{ID: machine.ID}}
+			h.devices[machine.ID] = state
+		}
+		state.history.Name = machine.Name
+		state.history.RecordName = names[machine.ID]
+
+		switch {
+		case !state.history.Online:
+			h.record(state, machine, TransitionOnline, now)
+		case machine.IPv4Address != state.lastSeen.IPv4Address || machine.IPv6Address != state.lastSeen.IPv6Address:
+			h.record(state, machine, TransitionAddressChanged, now)
+		}
+		state.history.Online = true
+		state.lastSeen = machine
+	}
+
+	for id, state := range h.devices {
+		if state.history.Online && !present[id] {
+			state.history.Online = false
+			h.record(state, state.lastSeen, TransitionOffline, now)
+		}
+	}
+
+	h.evict()
+}
+
+// record appends a transition to a device, dropping its oldest transitions beyond the history size
+func (h *history) record(state *deviceState, machine tailscale.Machine, kind TransitionKind, now time.Time) {
+	state.history.Transitions = append(state.history.Transitions, Transition{
+		Time:        now,
+		Kind:        kind,
+		IPv4Address: machine.IPv4Address,
+		IPv6Address: machine.IPv6Address,
+	})
+	if excess := len(state.history.Transitions) - h.size; excess > 0 {
+		state.history.Transitions = slices.Delete(state.history.Transitions, 0, excess)
+	}
+	state.changed = now
+}
+
+// evict forgets the devices that have been offline the longest once more than maxHistoryDevices are tracked
+func (h *history) evict() {
+	excess := len(h.devices) - maxHistoryDevices
+	if excess <= 0 {
+		return
+	}
+
+	var offline []*deviceState
+	for _, state := range h.devices {
+		if !state.history.Online {
+			offline = append(offline, state)
+		}
+	}
+	slices.SortFunc(offline, func(a, b *deviceState) int { return a.changed.Compare(b.changed) })
+	for _, state := range offline[:min(excess, len(offline))] {
+		delete(h.devices, state.history.ID)
+	}
+}
+
+// lookup returns the history of the device whose ID, name or record name matches host, ignoring case and any domain
+func (h *history) lookup(host string) (DeviceHistory, bool) {
+	if h == nil {
+		return DeviceHistory{}, false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hostname, _, _ := strings.Cut(host, ".")
+	for _, state := range h.devices {
+		name, _, _ := strings.Cut(state.history.Name, ".")
+		if state.history.ID == host || strings.EqualFold(name, hostname) ||
+			strings.EqualFold(state.history.RecordName, hostname) {
+			found := state.history
+			found.Transitions = slices.Clone(found.Transitions)
+			found.Flaps = countFlaps(found.Transitions)
+			return found, true
+		}
+	}
+	return DeviceHistory{}, false
+}
+
+// DeviceHistory returns the recorded transitions of the device matching host by ID, name or record name
+func (a *App) DeviceHistory(host string) (DeviceHistory, bool) {
+	return a.history.lookup(host)
+}
@@ -0,0 +1,70 @@
+package app
+
+import (
+	"net"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"k8s.io/klog/v2"
+)
+
+// ipv6Probe is the address whose route tells whether the host reaches IPv6 destinations at all, from the
+// documentation range so that it's never contacted: dialing UDP only looks the route up
+const ipv6Probe = "[2001:db8::1]:53"
+
+// IPv6Status reports how IPv6 is handled, as detected at startup
+type IPv6Status struct {
+	Enabled bool `json:"enabled"` // ipv6.enabled
+	Route   bool `json:"route"`   // Whether the host has a route to IPv6 destinations
+	AAAA    bool `json:"aaaa"`    // Whether AAAA records are published
+	PTR     bool `json:"ptr"`     // Whether IPv6 PTR records are published
+
+	// IPv6 servers updates are sent to, which fail without a route
+	Servers []string `json:"servers,omitempty"`
+}
+
+// hasIPv6Route reports whether the host has a route to IPv6 destinations, replaced in tests
+var hasIPv6Route = func() bool {
+	conn, err := net.Dial("udp6", ipv6Probe)
+	if err != nil {
+		return false
+	}
+	_ = conn.Close()
+	return true
+}
+
+// detectIPv6 works out how IPv6 is handled and logs it, warning about IPv6 servers the host can't reach
+func detectIPv6(cfg *config.Config) *IPv6Status {
+	status := &IPv6Status{
+		Enabled: cfg.IPv6Enabled(),
+		Route:   hasIPv6Route(),
+	}
+	status.AAAA = status.Enabled
+	status.PTR = status.Enabled && cfg.Bind.PTR.Enabled && cfg.Bind.PTR.IPv6Enabled
+	if cfg.UsesBind() {
+		for _, server := range append([]string{cfg.Bind.Server}, cfg.Bind.Servers...) {
+			if config.IsIPv6Server(server) {
+				status.Servers = append(status.Servers, server)
+			}
+		}
+	}
+
+	if !status.Enabled {
+		klog.Info("IPv6 is disabled, publishing no AAAA or IPv6 PTR records")
+		return status
+	}
+	klog.Infof("IPv6 is enabled: AAAA records %s, IPv6 PTR records %s, IPv6 route present: %t", onOff(status.AAAA),
+		onOff(status.PTR), status.Route)
+	if !status.Route && len(status.Servers) > 0 {
+		klog.Warningf("The host has no IPv6 route, updates to %v will fail; set ipv6.enabled to false or use IPv4 "+
+			"servers", status.Servers)
+	}
+	return status
+}
+
+// onOff describes whether a kind of record is published
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,203 @@
+package app
+
+import (
+	"context"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"github.com/aauren/tailscale-bind-ddns/pkg/tailscale"
+	"github.com/aauren/tailscale-bind-ddns/pkg/webhook"
+	"k8s.io/klog/v2"
+)
+
+// With webhooks, every device a nodeCreated event reports is followed until its records resolve: after each update the
+// A and AAAA records of the device are looked up, through bind.verify_resolvers when set, and once all of them are
+// served the time since Tailscale created the device is taken as its join latency. This is the freshness of the whole
+// pipeline, webhook delivery, poll, update and propagation included. Devices whose records don't resolve within
+// joinTimeout, e.g. because they never come online or are filtered, are given up.
+
+const (
+	// joinTimeout is how long a device that joined is followed before it's given up
+	joinTimeout = 15 * time.Minute
+	// joinLookupTimeout bounds the lookups of the records of devices that joined after an update
+	joinLookupTimeout = 5 * time.Second
+	// joinLatencySamples is how many of the most recent join latencies the percentiles are computed over
+	joinLatencySamples = 100
+)
+
+// recordResolver is implemented by providers that can tell whether a record is served
+type recordResolver interface {
+	Resolves(ctx context.Context, record bind.DNSRecord) (bool, error)
+}
+
+// JoinLatencyStatus reports the latencies from devices joining the tailnet to their records resolving
+type JoinLatencyStatus struct {
+	// Measured counts the devices whose records resolved, TimedOut those given up and Pending those still followed
+	Measured int `json:"measured"`
+	TimedOut int `json:"timed_out"`
+	Pending  int `json:"pending"`
+	// Latencies of the most recent device and over the most recent joinLatencySamples devices
+	Last time.Duration `json:"last"`
+	P50  time.Duration `json:"p50"`
+	P95  time.Duration `json:"p95"`
+	Max  time.Duration `json:"max"`
+}
+
+// joinTracker follows the devices that joined until their records resolve
+type joinTracker struct {
+	mu sync.Mutex
+	// When the followed devices were created, by lower case MagicDNS name
+	pending   map[string]time.Time
+	latencies []time.Duration // Oldest first
+	measured  int
+	timedOut  int
+}
+
+// newJoinTracker returns the tracker of join latencies, nil without webhooks reporting joins
+func newJoinTracker(webhookAddress string) *joinTracker {
+	if webhookAddress == "" {
+		return nil
+	}
+	return &joinTracker{pending: make(map[string]time.Time)}
+}
+
+// joinKey returns the key a device is followed under
+func joinKey(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// add starts following a device, repeated events for a device keep the first creation time
+func (j *joinTracker) add(join webhook.Join) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	key := joinKey(join.DeviceName)
+	if _, ok := j.pending[key]; !ok {
+		j.pending[key] = join.Created
+	}
+}
+
+// followed gives up the devices followed for longer than joinTimeout and returns the others
+func (j *joinTracker) followed(now time.Time) map[string]time.Time {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for name, created := range j.pending {
+		if now.Sub(created) > joinTimeout {
+			klog.Warningf("Giving up on the records of device %s, which joined %v ago and doesn't resolve yet", name,
+				now.Sub(created).Round(time.Second))
+			delete(j.pending, name)
+			j.timedOut++
+		}
+	}
+	return maps.Clone(j.pending)
+}
+
+// resolved stops following a device whose records resolved with latency
+func (j *joinTracker) resolved(name string, latency time.Duration) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, ok := j.pending[name]; !ok {
+		return
+	}
+	delete(j.pending, name)
+	j.measured++
+	j.latencies = append(j.latencies, latency)
+	if len(j.latencies) > joinLatencySamples {
+		j.latencies = j.latencies[len(j.latencies)-joinLatencySamples:]
+	}
+}
+
+// status returns the join latencies, nil without webhooks
+func (j *joinTracker) status() *JoinLatencyStatus {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	status := &JoinLatencyStatus{Measured: j.measured, TimedOut: j.timedOut, Pending: len(j.pending)}
+	if len(j.latencies) == 0 {
+		return status
+	}
+	status.Last = j.latencies[len(j.latencies)-1]
+	sorted := slices.Sorted(slices.Values(j.latencies))
+	// Nearest rank, like the latency objective
+	rank := func(p float64) time.Duration { return sorted[int(math.Ceil(p*float64(len(sorted))))-1] }
+	status.P50, status.P95, status.Max = rank(0.5), rank(sloPercentile), sorted[len(sorted)-1]
+	return status
+}
+
+// observeJoin starts following a device a webhook reported as created
+func (a *App) observeJoin(join webhook.Join) {
+	klog.V(1).Infof("Device %s (%s) joined the tailnet at %v", join.DeviceName, join.NodeID, join.Created)
+	a.joins.add(join)
+}
+
+// verifyJoins looks up the records of the devices that joined and measures the latency of those that resolve. It's
+// called after every update that wasn't a dry run.
+func (a *App) verifyJoins(ctx context.Context) {
+	now := clock.Or(a.clock).Now()
+	pending := a.joins.followed(now)
+	if len(pending) == 0 {
+		return
+	}
+
+	a.clientsMu.Lock()
+	resolver, ok := a.provider.(recordResolver)
+	a.clientsMu.Unlock()
+	if !ok {
+		return
+	}
+
+	records, _ := a.ManagedRecords()
+	a.externalMu.Lock()
+	machines := a.lastMachines
+	a.externalMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, joinLookupTimeout)
+	defer cancel()
+
+	for _, machine := range machines {
+		key := joinKey(machine.Name)
+		created, ok := pending[key]
+		if !ok || !a.joinResolves(ctx, resolver, machine, records) {
+			continue
+		}
+		latency := now.Sub(created)
+		a.joins.resolved(key, latency)
+		klog.Infof("Records of device %s resolve %v after it joined the tailnet", machine.Name, latency)
+	}
+}
+
+// joinResolves reports whether every A and AAAA record published for a machine is served, false while it has none
+func (a *App) joinResolves(ctx context.Context, resolver recordResolver, machine tailscale.Machine,
+	records []bind.DNSRecord) bool {
+	found := false
+	for _, record := range records {
+		if (record.Type != bind.TypeA && record.Type != bind.TypeAAAA) || a.recordDevice(record) != machine.ID {
+			continue
+		}
+		resolves, err := resolver.Resolves(ctx, record)
+		if err != nil {
+			klog.V(1).Infof("Looking up %s record %s of device %s: %v", record.Type, record.Name, machine.Name, err)
+		}
+		if !resolves {
+			return false
+		}
+		found = true
+	}
+	return found
+}
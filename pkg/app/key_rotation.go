@@ -0,0 +1,94 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"k8s.io/klog/v2"
+)
+
+// KeyRotation describes a replacement TSIG key
+type KeyRotation struct {
+	KeyName   string `json:"key_name"`
+	KeySecret string `json:"key_secret"`
+	Algorithm string `json:"algorithm"`
+}
+
+// tsigKeyRotator is implemented by providers whose TSIG key can be replaced at runtime
+type tsigKeyRotator interface {
+	SetTSIGKey(keyName, keySecret, algorithm string) error
+}
+
+// VerifyTSIGKey checks that the Bind server accepts updates signed with the rotated key by sending a probe update
+func VerifyTSIGKey(ctx context.Context, bindCfg config.BindConfig, rotation KeyRotation) error {
+	bindCfg.KeyName = rotation.KeyName
+	bindCfg.KeySecret = rotation.KeySecret
+	bindCfg.Algorithm = rotation.Algorithm
+
+	client, err := bind.NewClientFromConfig(&bindCfg)
+	if err != nil {
+		return fmt.Errorf("creating bind client for new key: %w", err)
+	}
+
+	if err := client.ProbeUpdate(ctx); err != nil {
+		return fmt.Errorf("verifying new key %s: %w", rotation.KeyName, err)
+	}
+	return nil
+}
+
+// CheckKeyRotation returns why the TSIG key of the configuration can't be rotated with rotate-key, nil when it can.
+// With bind.key_secret_file the file holds the key: a rotated secret would be rotated back to the one in the file with
+// its next check, and writing it to the configuration file would clash with the secret file.
+func CheckKeyRotation(bindCfg *config.BindConfig) error {
+	switch {
+	case bindCfg.GSSTSIG():
+		return fmt.Errorf("updates are authenticated with GSS-TSIG, there is no TSIG key to rotate")
+	case bindCfg.KeyCommand != "":
+		return fmt.Errorf("the TSIG key is held by bind.key_command, rotate it there")
+	case bindCfg.KeySecretFile != "":
+		return fmt.Errorf("the TSIG secret is read from bind.key_secret_file, rotate it in %s", bindCfg.KeySecretFile)
+	}
+	return nil
+}
+
+// RotateKey verifies a new TSIG key and, only if it works, swaps it into the running provider and configuration
+func (a *App) RotateKey(ctx context.Context, rotation KeyRotation) error {
+	a.clientsMu.Lock()
+	bindCfg := a.config.Bind
+	a.clientsMu.Unlock()
+
+	if err := CheckKeyRotation(&bindCfg); err != nil {
+		return err
+	}
+	return a.rotateKey(ctx, bindCfg, rotation)
+}
+
+// rotateKey verifies a new TSIG key and swaps it in like RotateKey, for keys of bind.key_secret_file as well
+func (a *App) rotateKey(ctx context.Context, bindCfg config.BindConfig, rotation KeyRotation) error {
+	if err := VerifyTSIGKey(ctx, bindCfg, rotation); err != nil {
+		return err
+	}
+
+	provider, err := a.getProvider()
+	if err != nil {
+		return err
+	}
+	rotator, ok := provider.(tsigKeyRotator)
+	if !ok {
+		return fmt.Errorf("provider %s does not support TSIG key rotation", a.config.General.Provider)
+	}
+	if err := rotator.SetTSIGKey(rotation.KeyName, rotation.KeySecret, rotation.Algorithm); err != nil {
+		return fmt.Errorf("swapping TSIG key: %w", err)
+	}
+
+	a.clientsMu.Lock()
+	a.config.Bind.KeyName = rotation.KeyName
+	a.config.Bind.KeySecret = rotation.KeySecret
+	a.config.Bind.Algorithm = rotation.Algorithm
+	a.clientsMu.Unlock()
+
+	klog.Infof("Rotated TSIG key to %s", rotation.KeyName)
+	return nil
+}
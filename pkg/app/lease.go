@@ -0,0 +1,71 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"k8s.io/klog/v2"
+)
+
+// With bind.lease_intervals every published record is leased for that many poll intervals, and every poll that still
+// wants a record refreshes its lease. The provider tracks the leases, the app checks them once every poll interval, so
+// that records are withdrawn even when no poll comes along anymore. Leases don't expire while publishing is paused,
+// nor on the first check after it resumed, which gives the next poll the chance to refresh them first.
+
+// leaseExpirer is implemented by providers that withdraw the records whose lease ran out
+type leaseExpirer interface {
+	ExpireLeases(ctx context.Context, dryRun bool) (int, error)
+}
+
+// startLeases starts the lease checks of a provider, named by its target, when its configuration enables leases
+func (a *App) startLeases(ctx context.Context, name string, cfg *config.Config, provider Provider) {
+	if cfg.Lease() <= 0 {
+		return
+	}
+	expirer, ok := provider.(leaseExpirer)
+	if !ok {
+		klog.Warningf("Provider %s doesn't support leases, bind.lease_intervals is ignored", cfg.General.Provider)
+		return
+	}
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		a.runLeases(ctx, name, cfg, expirer)
+	}()
+}
+
+// runLeases withdraws the records whose lease ran out once every poll interval until the context is cancelled
+func (a *App) runLeases(ctx context.Context, name string, cfg *config.Config, expirer leaseExpirer) {
+	where := "the DNS provider"
+	if name != "" {
+		where = "target " + name
+	}
+	ticker := clock.Or(a.clock).NewTicker(cfg.Tailscale.PollInterval)
+	defer ticker.Stop()
+
+	klog.Infof("Leasing the records of %s for %v", where, cfg.Lease().Truncate(time.Second))
+
+	wasPaused := false
+	for {
+		select {
+		case <-ticker.C():
+			paused := a.Paused()
+			if paused || wasPaused {
+				wasPaused = paused
+				continue
+			}
+			removed, err := expirer.ExpireLeases(ctx, cfg.General.DryRun)
+			if err != nil {
+				klog.Errorf("Failed to remove the records of %s with an expired lease: %v", where, err)
+			}
+			if removed > 0 {
+				klog.Infof("Removed %d records with an expired lease from %s", removed, where)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
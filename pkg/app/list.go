@@ -0,0 +1,104 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"github.com/aauren/tailscale-bind-ddns/pkg/tailscale"
+)
+
+// Listing holds the machines of the tailnet and the records they would be published as
+type Listing struct {
+	Machines []ListedMachine `json:"machines" yaml:"machines"`
+	Records  []ListedRecord  `json:"records" yaml:"records"`
+}
+
+// ListedMachine is a machine of the tailnet and the name its records would be published under
+type ListedMachine struct {
+	ID     string `json:"id" yaml:"id"`
+	Name   string `json:"name" yaml:"name"`
+	Online bool   `json:"online" yaml:"online"`
+	// Addresses are the addresses that would be published, after the address selection
+	Addresses []string `json:"addresses,omitempty" yaml:"addresses,omitempty"`
+	Published bool     `json:"published" yaml:"published"`
+	// FQDN is the fully qualified name of a published machine and Reason why an unpublished one gets no records
+	FQDN   string `json:"fqdn,omitempty" yaml:"fqdn,omitempty"`
+	Reason string `json:"reason,omitempty" yaml:"reason,omitempty"`
+}
+
+// ListedRecord is a record that would be published
+type ListedRecord struct {
+	// Name is fully qualified, Zone the forward zone of the record and empty for PTR records
+	Name  string `json:"name" yaml:"name"`
+	Type  string `json:"type" yaml:"type"`
+	Value string `json:"value" yaml:"value"`
+	TTL   uint32 `json:"ttl" yaml:"ttl"`
+	Zone  string `json:"zone,omitempty" yaml:"zone,omitempty"`
+	// Machine is the name of the machine the record belongs to, empty for records of no single machine such as
+	// route records
+	Machine string `json:"machine,omitempty" yaml:"machine,omitempty"`
+}
+
+// List fetches every machine of the tailnet, offline ones included, and returns them with the records the online ones
+// would be published as, after the filters, naming rules and plugins. Online states are as of this lookup, without the
+// smoothing of online_polls and offline_polls.
+func (a *App) List(ctx context.Context) (*Listing, error) {
+	tsClient, err := a.getTailscaleClient()
+	if err != nil {
+		return nil, err
+	}
+
+	machines, err := tsClient.GetMachines(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting machines: %w", err)
+	}
+	return a.list(machines, clock.Or(a.clock).Now()), nil
+}
+
+// list lists the machines sorted by name and the records of the online ones
+func (a *App) list(machines []tailscale.Machine, now time.Time) *Listing {
+	online := onlineMachines(machines)
+	a.setDevices(online)
+	byID := make(map[string]tailscale.Machine, len(machines))
+	for _, machine := range machines {
+		byID[machine.ID] = machine
+	}
+
+	listing := &Listing{Machines: []ListedMachine{}, Records: []ListedRecord{}}
+	for _, explanation := range a.explain(machines, now) {
+		machine := byID[explanation.ID]
+		listed := ListedMachine{
+			ID:        machine.ID,
+			Name:      machine.Name,
+			Online:    machine.Online,
+			Addresses: append(slices.Clone(a.machineIPv4Addresses(machine)), a.machineIPv6Addresses(machine)...),
+			Published: explanation.Published,
+		}
+		if explanation.Published {
+			listed.FQDN = a.zoneFQDN(explanation.RecordName, explanation.Zone)
+		} else if i := slices.IndexFunc(explanation.Steps, func(step FilterStep) bool { return !step.Passed }); i >= 0 {
+			listed.Reason = explanation.Steps[i].Filter + ": " + explanation.Steps[i].Detail
+		}
+		listing.Machines = append(listing.Machines, listed)
+	}
+
+	for _, record := range a.desiredRecords(online) {
+		listed := ListedRecord{
+			Name:    record.Name,
+			Type:    string(record.Key().Type),
+			Value:   record.Value,
+			TTL:     record.TTL,
+			Machine: byID[a.recordDevice(record)].Name,
+		}
+		if record.Type != bind.TypePTR {
+			listed.Name = a.zoneFQDN(record.Name, record.Zone)
+			listed.Zone = a.recordZoneName(record.Zone)
+		}
+		listing.Records = append(listing.Records, listed)
+	}
+	return listing
+}
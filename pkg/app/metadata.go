@@ -0,0 +1,87 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/aauren/tailscale-bind-ddns/pkg/tailscale"
+	"k8s.io/klog/v2"
+)
+
+// With bind.publish_metadata every machine gets a TXT record next to its A/AAAA records, rendered from the machine by
+// the metadata template, e.g.
+//
+//	laptop.ts.example.com. 300 IN TXT "id=n1 os=linux tags=tag:server last_seen=2025-01-01T12:00:00Z"
+
+// metadataFuncs are the functions available to metadata templates
+var metadataFuncs = template.FuncMap{
+	"join": strings.Join,
+	"rfc3339": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	},
+	"truncate": func(t time.Time, d string) (time.Time, error) {
+		duration, err := time.ParseDuration(d)
+		if err != nil {
+			return t, err
+		}
+		return t.Truncate(duration), nil
+	},
+}
+
+// newMetadataTemplate parses the metadata template, returning nil when metadata isn't published
+func newMetadataTemplate(cfg *config.BindConfig) (*template.Template, error) {
+	if !cfg.PublishMetadata {
+		return nil, nil
+	}
+
+	text := cfg.MetadataTemplate
+	if text == "" {
+		text = config.DefaultMetadataTemplate
+	}
+	tmpl, err := template.New("metadata").Funcs(metadataFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing metadata template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// createMetadataRecords creates the metadata TXT records of the given machines. Machines whose template fails to
+// render get no record.
+func (a *App) createMetadataRecords(machines []tailscale.Machine) []bind.DNSRecord {
+	var metadataRecords []bind.DNSRecord
+	if a.metadata == nil {
+		return metadataRecords
+	}
+
+	names := a.namer.names(machines)
+
+	for _, machine := range machines {
+		// Only create metadata records for online machines, the CNAME of Funnel machines can't have any next to it
+		if !machine.Online || a.funnelMode(machine) == config.FunnelCNAME {
+			continue
+		}
+
+		var value strings.Builder
+		if err := a.metadata.Execute(&value, machine); err != nil {
+			klog.Warningf("Failed to render metadata of %s (%s): %v", machine.Name, machine.ID, err)
+			continue
+		}
+		metadataRecords = append(metadataRecords, bind.DNSRecord{
+			Name:  names[machine.ID],
+			Value: value.String(),
+			TTL:   a.recordTTL(machine),
+			Type:  bind.TypeTXT,
+			Zone:  a.machineZone(machine),
+		})
+	}
+
+	klog.V(1).Infof("Created %d metadata records", len(metadataRecords))
+	return metadataRecords
+}
@@ -0,0 +1,230 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"github.com/aauren/tailscale-bind-ddns/pkg/tailscale"
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// Moving the records to another zone when bind.zone changes goes through four steps, each of which has to succeed
+// before the next one starts, so that the names of the machines resolve in at least one of the zones throughout:
+//
+//  1. the records of the online machines are published in the new zone, PTR records now pointing to the new names
+//  2. the new A, AAAA, CNAME and TXT records are looked up until all of them resolve
+//  3. the records of every machine are withdrawn from the old zone
+//  4. the old records are looked up until none of them resolves anymore
+//
+// Providers only remove records they published themselves, so the old records are handed to the provider of the old
+// zone first, which leaves the zone as it is, and then removed. Records of the additional zones of bind.zones stay
+// where they are.
+
+// migrationVerifyInterval is how often records are looked up while a migration waits for them
+const migrationVerifyInterval = 2 * time.Second
+
+// ZoneMigration reports a migration of the records from one zone to another
+type ZoneMigration struct {
+	From string
+	To   string
+	// Published counts the records published in the new zone, PTR records included, and Withdrawn those removed from
+	// the old zone
+	Published int
+	Withdrawn int
+	// Verified is set when the records were looked up after each step, which providers that can't resolve records and
+	// dry runs skip
+	Verified bool
+}
+
+// MigrateZone moves the records of the tailnet's machines from zone from to zone to, waiting up to verifyTimeout for
+// the records of each step to show. Nothing is sent in dry-run mode.
+func (a *App) MigrateZone(ctx context.Context, from, to string, verifyTimeout time.Duration) (*ZoneMigration, error) {
+	if dns.CanonicalName(from) == dns.CanonicalName(to) {
+		return nil, fmt.Errorf("old and new zone are both %s", from)
+	}
+
+	tsClient, err := a.getTailscaleClient()
+	if err != nil {
+		return nil, err
+	}
+	machines, err := tsClient.GetMachines(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting machines: %w", err)
+	}
+
+	fromApp, err := a.zoneApp(from)
+	if err != nil {
+		return nil, err
+	}
+	defer fromApp.closePlugins()
+	toApp, err := a.zoneApp(to)
+	if err != nil {
+		return nil, err
+	}
+	defer toApp.closePlugins()
+
+	return migrateZone(ctx, machines, fromApp, toApp, verifyTimeout)
+}
+
+// zoneApp returns an application publishing to zone instead of bind.zone. Its provider starts without the state and
+// queue files, so that it doesn't withdraw or republish what they hold on its own, and removes stale records, so that
+// the records handed to it can be withdrawn again.
+func (a *App) zoneApp(zone string) (*App, error) {
+	cfg := *a.config
+	cfg.Bind.Zone = zone
+	cfg.Bind.StateFile, cfg.Bind.QueueFile = "", ""
+	cfg.Bind.RemoveStale = true
+	cfg.Targets, cfg.Views = nil, nil
+
+	zoneApp, err := NewApp(&cfg)
+	if err != nil {
+		return nil, fmt.Errorf("zone %s: %w", zone, err)
+	}
+	zoneApp.clock = a.clock
+	return zoneApp, nil
+}
+
+// migrateZone moves the records of machines from the zone of fromApp to the zone of toApp
+func migrateZone(ctx context.Context, machines []tailscale.Machine, fromApp, toApp *App,
+	verifyTimeout time.Duration) (*ZoneMigration, error) {
+	from, to := fromApp.config.Bind.Zone, toApp.config.Bind.Zone
+	dryRun := toApp.config.General.DryRun
+	migration := &ZoneMigration{From: from, To: to, Verified: !dryRun}
+
+	toProvider, err := toApp.getProvider()
+	if err != nil {
+		return migration, err
+	}
+	fromProvider, err := fromApp.getProvider()
+	if err != nil {
+		return migration, err
+	}
+
+	// 1. Publish the records in the new zone
+	published := toApp.desiredRecords(machines)
+	klog.Infof("Publishing %d records in zone %s", len(published), to)
+	if _, err := toProvider.UpdateRecords(ctx, published, dryRun); err != nil {
+		return migration, fmt.Errorf("publishing records in zone %s: %w", to, err)
+	}
+	migration.Published = len(published)
+
+	// 2. Wait for them to resolve
+	if !dryRun {
+		verified, err := toApp.awaitRecords(ctx, toProvider, mainZoneRecords(published), true, verifyTimeout)
+		if err != nil {
+			return migration, fmt.Errorf("verifying records in zone %s: %w", to, err)
+		}
+		migration.Verified = verified
+	}
+
+	// 3. Withdraw the records from the old zone, of offline machines as well
+	named := slices.Clone(machines)
+	for i := range named {
+		named[i].Online = true
+	}
+	withdrawn := mainZoneRecords(fromApp.desiredRecords(named))
+	klog.Infof("Withdrawing %d records from zone %s", len(withdrawn), from)
+	if _, err := fromProvider.UpdateRecords(ctx, withdrawn, dryRun); err != nil {
+		return migration, fmt.Errorf("taking over records in zone %s: %w", from, err)
+	}
+	if remover, ok := fromProvider.(recordRemover); ok {
+		_, err = remover.RemoveAll(ctx, dryRun)
+	} else {
+		_, err = fromProvider.UpdateRecords(ctx, nil, dryRun)
+	}
+	if err != nil {
+		return migration, fmt.Errorf("withdrawing records from zone %s: %w", from, err)
+	}
+	migration.Withdrawn = len(withdrawn)
+
+	// 4. Wait for them to disappear
+	if !dryRun {
+		verified, err := fromApp.awaitRecords(ctx, fromProvider, withdrawn, false, verifyTimeout)
+		if err != nil {
+			return migration, fmt.Errorf("verifying records in zone %s: %w", from, err)
+		}
+		migration.Verified = migration.Verified && verified
+	}
+	return migration, nil
+}
+
+// mainZoneRecords returns the forward records of the main zone, leaving out PTR records and the records of additional
+// zones
+func mainZoneRecords(records []bind.DNSRecord) []bind.DNSRecord {
+	var main []bind.DNSRecord
+	for _, record := range records {
+		if record.Type != bind.TypePTR && record.Zone == "" {
+			main = append(main, record)
+		}
+	}
+	return main
+}
+
+// awaitRecords looks records up until all of them resolve, or none of them does when served is false, and returns
+// false when the provider can't resolve records. It fails once the records didn't get there within timeout.
+func (a *App) awaitRecords(ctx context.Context, provider Provider, records []bind.DNSRecord, served bool,
+	timeout time.Duration) (bool, error) {
+	resolver, ok := provider.(recordResolver)
+	if !ok {
+		klog.Warningf("Provider %s can't look records up, not verifying zone %s", a.config.General.Provider,
+			a.config.Bind.Zone)
+		return false, nil
+	}
+
+	clk := clock.Or(a.clock)
+	deadline := clk.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		pending, err := a.pendingRecords(ctx, resolver, records, served)
+		if err != nil {
+			return true, err
+		}
+		if len(pending) == 0 {
+			return true, nil
+		}
+		klog.V(1).Infof("Waiting for %d records in zone %s, e.g. %s record %s", len(pending), a.config.Bind.Zone,
+			pending[0].Type, pending[0].Name)
+
+		interval := clk.NewTimer(migrationVerifyInterval)
+		select {
+		case <-interval.C():
+		case <-deadline.C():
+			interval.Stop()
+			state := "don't resolve"
+			if !served {
+				state = "are still served"
+			}
+			return true, fmt.Errorf("%d records %s after %v, e.g. %s record %s", len(pending), state, timeout,
+				pending[0].Type, pending[0].Name)
+		case <-ctx.Done():
+			interval.Stop()
+			return true, ctx.Err()
+		}
+	}
+}
+
+// pendingRecords returns the records that aren't served yet, or are still served when served is false. Records that
+// can't be looked up are pending either way.
+func (a *App) pendingRecords(ctx context.Context, resolver recordResolver, records []bind.DNSRecord,
+	served bool) ([]bind.DNSRecord, error) {
+	var pending []bind.DNSRecord
+	for _, record := range records {
+		resolves, err := resolver.Resolves(ctx, record)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			klog.V(1).Infof("Looking up %s record %s in zone %s: %v", record.Type, record.Name, a.config.Bind.Zone, err)
+		}
+		if err != nil || resolves != served {
+			pending = append(pending, record)
+		}
+	}
+	return pending, nil
+}
@@ -0,0 +1,301 @@
+package app
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/aauren/tailscale-bind-ddns/pkg/tailscale"
+	"k8s.io/klog/v2"
+)
+
+// With bind.name_template the record name of a machine is rendered from the machine rather than taken from its
+// hostname, e.g. "{{ .Name }}-ts" publishes laptop as laptop-ts and "{{ .Name }}.{{ .User }}" publishes it in a
+// subdomain per user, laptop.alice. Every label of the result is sanitized like a hostname.
+//
+// Different devices can end up with the same name, e.g. laptop and Laptop. on different accounts. bind.name_collisions
+// decides what happens to all but the device with the lowest ID, which keeps the name so that it doesn't move from one
+// device to another between polls: suffix publishes them as laptop-2, laptop-3, ..., id suffixes their short device
+// ID, skip publishes no records for them and fail publishes nothing at all until the collision is resolved.
+//
+// With bind.user_subdomains every name gets a subdomain per owner appended, e.g. laptop.alice, or the label mapped to
+// the owner in its users. Devices without an owner, such as tagged devices, get no subdomain.
+
+// recordNamer derives the names machine records are published under, nil publishes machines under their hostname and
+// suffixes colliding names
+type recordNamer struct {
+	// Template of the record names, nil for the hostname
+	template *template.Template
+	// Strategy for colliding names, one of the config.NameCollision constants
+	collisions string
+	// Whether names get a subdomain per owner and the labels of the owners mapped in bind.user_subdomains, by lower
+	// case login name
+	userSubdomains bool
+	userLabels     map[string]string
+}
+
+// nameData is what name templates are rendered from
+type nameData struct {
+	tailscale.Machine
+	Name string // Hostname of the machine without the tailnet domain, its ID when it has none
+	User string // Local part of the owner's login name, e.g. alice for alice@example.com
+}
+
+// newRecordNamer parses the name template and sets up the collision strategy
+func newRecordNamer(cfg *config.BindConfig) (*recordNamer, error) {
+	namer := &recordNamer{
+		collisions:     cmp.Or(cfg.NameCollisions, config.NameCollisionSuffix),
+		userSubdomains: cfg.UserSubdomains.Enabled,
+		userLabels:     make(map[string]string, len(cfg.UserSubdomains.Users)),
+	}
+	for _, user := range cfg.UserSubdomains.Users {
+		namer.userLabels[strings.ToLower(user.User)] = user.Label
+	}
+	if cfg.NameTemplate == "" {
+		return namer, nil
+	}
+
+	tmpl, err := template.New("name").Parse(cfg.NameTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("parsing name template: %w", err)
+	}
+	namer.template = tmpl
+	return namer, nil
+}
+
+// names maps the IDs of online machines to the names their records are published under. A name requested by a
+// device owner is only honored when no other machine already uses it, so that one device can't take over another's
+// records. Colliding names are resolved with the suffix strategies, the others leave them as they are.
+func (n *recordNamer) names(machines []tailscale.Machine) map[string]string {
+	names, _ := n.resolveNames(machines)
+	return names
+}
+
+// resolveNames returns the names of online machines like names, together with the collisions between them before
+// they were resolved
+func (n *recordNamer) resolveNames(machines []tailscale.Machine) (map[string]string, map[string][]string) {
+	names := make(map[string]string, len(machines))
+	taken := make(map[string]int, len(machines))
+	for _, machine := range machines {
+		if !machine.Online {
+			continue
+		}
+		name := n.machineName(machine)
+		names[machine.ID] = name
+		taken[strings.ToLower(name)]++
+	}
+
+	for _, machine := range machines {
+		if !machine.Online || machine.DNSName == "" {
+			continue
+		}
+		requested := sanitizeDNSName(machine.DNSName)
+		if requested == "" || strings.EqualFold(requested, names[machine.ID]) {
+			continue
+		}
+		if taken[strings.ToLower(requested)] > 0 {
+			klog.Warningf("Ignoring DNS name %q requested by %s (%s): the name is already in use", requested,
+				machine.Name, machine.ID)
+			continue
+		}
+		taken[strings.ToLower(names[machine.ID])]--
+		taken[strings.ToLower(requested)]++
+		names[machine.ID] = requested
+	}
+
+	collisions := nameCollisions(names)
+	n.resolveCollisions(names, taken, collisions)
+	return names, collisions
+}
+
+// collisionStrategy returns the strategy for colliding names
+func (n *recordNamer) collisionStrategy() string {
+	if n == nil {
+		return config.NameCollisionSuffix
+	}
+	return n.collisions
+}
+
+// machineName returns the record name of a machine, in the subdomain of its owner with bind.user_subdomains
+func (n *recordNamer) machineName(machine tailscale.Machine) string {
+	name := n.baseName(machine)
+	if label := n.userLabel(machine); label != "" {
+		return name + "." + label
+	}
+	return name
+}
+
+// userLabel returns the sanitized label of the subdomain of a machine's owner, an empty string when names get no
+// subdomain or the machine has no owner
+func (n *recordNamer) userLabel(machine tailscale.Machine) string {
+	if n == nil || !n.userSubdomains || machine.User == "" {
+		return ""
+	}
+	if label, ok := n.userLabels[strings.ToLower(machine.User)]; ok {
+		return sanitizeRecordName(label)
+	}
+	// A single label even for login names such as alice.smith@example.com
+	local, _, _ := strings.Cut(machine.User, "@")
+	return sanitizeDNSName(strings.ReplaceAll(local, ".", "-"))
+}
+
+// baseName returns the record name of a machine without the subdomain of its owner. Machines whose template fails to
+// render or renders nothing usable fall back to their hostname.
+func (n *recordNamer) baseName(machine tailscale.Machine) string {
+	hostname := machine.Name
+	if hostname == "" {
+		hostname = machine.ID
+	}
+	if n == nil || n.template == nil {
+		return sanitizeDNSName(hostname)
+	}
+
+	data := nameData{
+		Machine: machine,
+		Name:    strings.Split(hostname, ".")[0],
+		User:    strings.Split(machine.User, "@")[0],
+	}
+	var name strings.Builder
+	if err := n.template.Execute(&name, data); err != nil {
+		klog.Warningf("Failed to render the record name of %s (%s), using its hostname: %v", machine.Name, machine.ID,
+			err)
+		return sanitizeDNSName(hostname)
+	}
+
+	sanitized := sanitizeRecordName(name.String())
+	if sanitized == "" {
+		klog.Warningf("Name template rendered no name for %s (%s), using its hostname", machine.Name, machine.ID)
+		return sanitizeDNSName(hostname)
+	}
+	return sanitized
+}
+
+// sanitizeRecordName sanitizes every label of a rendered record name, dropping empty labels such as the one left by
+// a user-based subdomain of a tagged device
+func sanitizeRecordName(name string) string {
+	var labels []string
+	for label := range strings.SplitSeq(name, ".") {
+		if label = strings.TrimSpace(label); label != "" {
+			labels = append(labels, sanitizeDNSName(label))
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+// nameCollisions returns the names several machines got before collisions were resolved, with the IDs of the machines
+// sorted so that the one keeping the name comes first
+func nameCollisions(names map[string]string) map[string][]string {
+	byName := make(map[string][]string, len(names))
+	for id, name := range names {
+		byName[strings.ToLower(name)] = append(byName[strings.ToLower(name)], id)
+	}
+
+	collisions := make(map[string][]string)
+	for name, ids := range byName {
+		if len(ids) > 1 {
+			slices.Sort(ids)
+			collisions[name] = ids
+		}
+	}
+	return collisions
+}
+
+// resolveCollisions renames all but the first machine of every collision with the suffix strategies. Taken counts the
+// machines using every lowercased name.
+func (n *recordNamer) resolveCollisions(names map[string]string, taken map[string]int,
+	collisions map[string][]string) {
+	strategy := n.collisionStrategy()
+	if strategy != config.NameCollisionSuffix && strategy != config.NameCollisionID {
+		return
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(collisions)) {
+		next := 2
+		for _, id := range collisions[name][1:] {
+			renamed := ""
+			if strategy == config.NameCollisionID {
+				renamed = suffixedName(names[id], shortID(id))
+			}
+			// Names in use by other machines are skipped, e.g. a device actually called laptop-2
+			for renamed == "" || taken[strings.ToLower(renamed)] > 0 {
+				renamed = suffixedName(names[id], strconv.Itoa(next))
+				next++
+			}
+			klog.V(1).Infof("Publishing %s as %s: the name %s is already in use", id, renamed, names[id])
+			taken[name]--
+			taken[strings.ToLower(renamed)]++
+			names[id] = renamed
+		}
+	}
+}
+
+// suffixedName appends a suffix to the first label of a record name, e.g. laptop.alice becomes laptop-2.alice
+func suffixedName(name, suffix string) string {
+	first, rest, found := strings.Cut(name, ".")
+	if !found {
+		return first + "-" + suffix
+	}
+	return first + "-" + suffix + "." + rest
+}
+
+// shortID returns the first characters of a device ID, enough to tell the devices of a tailnet apart
+func shortID(id string) string {
+	short := sanitizeDNSName(id)
+	if len(short) > 6 {
+		short = strings.TrimRight(short[:6], "-")
+	}
+	return short
+}
+
+// withoutNameCollisions returns the machines that aren't left out of DNS with bind.name_collisions skip, which leaves
+// out all but the first machine of every collision
+func (a *App) withoutNameCollisions(machines []tailscale.Machine) []tailscale.Machine {
+	if a.namer.collisionStrategy() != config.NameCollisionSkip {
+		return machines
+	}
+
+	_, collisions := a.namer.resolveNames(machines)
+	skipped := make(map[string]bool)
+	for _, ids := range collisions {
+		for _, id := range ids[1:] {
+			skipped[id] = true
+		}
+	}
+	if len(skipped) == 0 {
+		return machines
+	}
+	return slices.DeleteFunc(slices.Clone(machines), func(machine tailscale.Machine) bool {
+		return skipped[machine.ID]
+	})
+}
+
+// checkNameCollisions logs the name collisions between the machines that get records and counts them for the gauges.
+// With bind.name_collisions fail it returns an error, so that nothing is published until they're resolved.
+func (a *App) checkNameCollisions(machines []tailscale.Machine, now time.Time) error {
+	_, collisions := a.namer.resolveNames(a.withoutSkippedFunnel(a.filter.apply(a.withoutUnseen(machines, now))))
+	a.nameCollisions.Store(int64(len(collisions)))
+
+	strategy := a.namer.collisionStrategy()
+	var failed []string
+	for _, name := range slices.Sorted(maps.Keys(collisions)) {
+		ids := collisions[name]
+		switch strategy {
+		case config.NameCollisionFail:
+			failed = append(failed, fmt.Sprintf("%s (%s)", name, strings.Join(ids, ", ")))
+		case config.NameCollisionSkip:
+			klog.Warningf("Devices %s share the name %s, only publishing %s", strings.Join(ids, ", "), name, ids[0])
+		default:
+			klog.Warningf("Devices %s share the name %s, renaming all but %s", strings.Join(ids, ", "), name, ids[0])
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("devices share record names: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
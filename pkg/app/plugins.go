@@ -0,0 +1,49 @@
+package app
+
+import (
+	"context"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/tailscale"
+	"k8s.io/klog/v2"
+)
+
+// The desired records of the main provider pass through the configured plugins in order, each getting the records the
+// previous one returned, see pkg/plugin. A plugin that fails is skipped for the cycle, the records passing on as they
+// were. Build with -tags no_plugins to leave the WASM runtime out.
+
+// recordPlugin transforms the desired records of the machines, e.g. a plugin.Plugin
+type recordPlugin interface {
+	Name() string
+	Transform(ctx context.Context, machines []tailscale.Machine, records []bind.DNSRecord) ([]bind.DNSRecord, error)
+	Close(ctx context.Context) error
+}
+
+// applyPlugins passes the records of the machines through every plugin. Records the plugins return without a TTL get
+// the configured one.
+func (a *App) applyPlugins(machines []tailscale.Machine, records []bind.DNSRecord) []bind.DNSRecord {
+	for _, plugin := range a.plugins {
+		transformed, err := plugin.Transform(context.Background(), machines, records)
+		if err != nil {
+			klog.Errorf("Skipping plugin %s: %v", plugin.Name(), err)
+			continue
+		}
+		for i := range transformed {
+			if transformed[i].TTL == 0 {
+				transformed[i].TTL = uint32(a.config.Bind.TTL.Seconds())
+			}
+		}
+		klog.V(2).Infof("Plugin %s turned %d records into %d", plugin.Name(), len(records), len(transformed))
+		records = transformed
+	}
+	return records
+}
+
+// closePlugins releases the plugins once nothing is published anymore
+func (a *App) closePlugins() {
+	for _, plugin := range a.plugins {
+		if err := plugin.Close(context.Background()); err != nil {
+			klog.Errorf("Failed to close plugin %s: %v", plugin.Name(), err)
+		}
+	}
+}
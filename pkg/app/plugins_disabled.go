@@ -0,0 +1,17 @@
+//go:build no_plugins
+
+package app
+
+import (
+	"fmt"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+)
+
+// loadPlugins reports that plugins were left out of this build when any are configured
+func loadPlugins(cfg *config.Config) ([]recordPlugin, error) {
+	if len(cfg.Plugins) > 0 {
+		return nil, fmt.Errorf("plugins are not available in this build (built with the no_plugins tag)")
+	}
+	return nil, nil
+}
@@ -0,0 +1,27 @@
+//go:build !no_plugins
+
+package app
+
+import (
+	"cmp"
+	"context"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/aauren/tailscale-bind-ddns/pkg/plugin"
+)
+
+// loadPlugins compiles the configured plugins
+func loadPlugins(cfg *config.Config) ([]recordPlugin, error) {
+	plugins := make([]recordPlugin, 0, len(cfg.Plugins))
+	for _, pluginCfg := range cfg.Plugins {
+		p, err := plugin.Load(context.Background(), pluginCfg.Path, cmp.Or(pluginCfg.Timeout, config.DefaultPluginTimeout))
+		if err != nil {
+			for _, loaded := range plugins {
+				_ = loaded.Close(context.Background())
+			}
+			return nil, err
+		}
+		plugins = append(plugins, p)
+	}
+	return plugins, nil
+}
@@ -0,0 +1,130 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/aauren/tailscale-bind-ddns/pkg/powerdns"
+	"github.com/aauren/tailscale-bind-ddns/pkg/zonefile"
+)
+
+// Provider publishes DNS records to a DNS backend
+type Provider interface {
+	// ValidateConnection checks that the backend is reachable with the configured credentials
+	ValidateConnection(ctx context.Context) error
+	// UpdateRecords publishes the given records to the backend and returns the per-zone outcome
+	UpdateRecords(ctx context.Context, records []bind.DNSRecord, dryRun bool) (*bind.SyncResult, error)
+	// StartUpdating publishes record sets received on recordChan until the context is cancelled
+	StartUpdating(ctx context.Context, updateInterval time.Duration, recordChan <-chan []bind.DNSRecord, dryRun bool)
+}
+
+// clockSetter is implemented by providers whose timings and retry backoff follow an injectable clock
+type clockSetter interface {
+	SetClock(clk clock.Clock)
+}
+
+// zonePrefetcher is implemented by providers that can read the zones an update diffs against ahead of it, while the
+// machines are still being polled
+type zonePrefetcher interface {
+	PrefetchZones(ctx context.Context)
+}
+
+// resultReporter is implemented by providers that report the outcome of every update of StartUpdating
+type resultReporter interface {
+	SetResultHook(hook func(*bind.SyncResult))
+}
+
+// deviceTracker is implemented by providers that record which device every record was published for
+type deviceTracker interface {
+	SetDeviceLookup(lookup bind.DeviceLookup)
+}
+
+// ProviderFactory constructs a Provider from the application configuration
+type ProviderFactory func(cfg *config.Config) (Provider, error)
+
+// providerFactories holds every provider compiled into this binary. Providers that pull in heavyweight dependencies
+// live in their own file guarded by a `//go:build !no_<name>` tag and add themselves here from an init function, so
+// that they can be left out of small builds with `go build -tags no_<name>`.
+var providerFactories = map[string]ProviderFactory{
+	config.ProviderBind:     newBindProvider,
+	config.ProviderNone:     newNoneProvider,
+	config.ProviderPowerDNS: newPowerDNSProvider,
+	config.ProviderZoneFile: newZoneFileProvider,
+}
+
+// AvailableProviders returns the names of the providers compiled into this binary
+func AvailableProviders() []string {
+	names := make([]string, 0, len(providerFactories))
+	for name := range providerFactories {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// newProvider constructs the provider selected by the configuration
+func newProvider(cfg *config.Config) (Provider, error) {
+	name := cfg.General.Provider
+	if name == "" {
+		name = config.ProviderBind
+	}
+
+	factory, ok := providerFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("provider %q is not available in this build (available: %v)", name, AvailableProviders())
+	}
+
+	provider, err := factory(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating %s provider: %w", name, err)
+	}
+	return provider, nil
+}
+
+// newPowerDNSProvider returns the provider publishing records through the PowerDNS API
+func newPowerDNSProvider(cfg *config.Config) (Provider, error) {
+	return powerdns.NewClientFromConfig(&cfg.Providers.PowerDNS, &cfg.Bind)
+}
+
+// newZoneFileProvider returns the provider writing records into zone files
+func newZoneFileProvider(cfg *config.Config) (Provider, error) {
+	return zonefile.NewClientFromConfig(cfg)
+}
+
+// noneProvider publishes records nowhere. It's used when the built-in forwarder answers queries for the managed zones
+// and there is no DNS server to update.
+type noneProvider struct{}
+
+// newNoneProvider returns the provider that publishes records nowhere
+func newNoneProvider(*config.Config) (Provider, error) {
+	return noneProvider{}, nil
+}
+
+// ValidateConnection always succeeds, there is no backend to reach
+func (noneProvider) ValidateConnection(context.Context) error {
+	return nil
+}
+
+// UpdateRecords accepts the records without publishing them anywhere
+func (noneProvider) UpdateRecords(_ context.Context, _ []bind.DNSRecord, dryRun bool) (*bind.SyncResult, error) {
+	return &bind.SyncResult{Started: time.Now(), DryRun: dryRun}, nil
+}
+
+// StartUpdating drains recordChan until the context is cancelled or the channel is closed
+func (noneProvider) StartUpdating(ctx context.Context, _ time.Duration, recordChan <-chan []bind.DNSRecord, _ bool) {
+	for {
+		select {
+		case _, ok := <-recordChan:
+			if !ok {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !no_route53
+
+package app
+
+import (
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/aauren/tailscale-bind-ddns/pkg/route53"
+)
+
+// The Route53 provider pulls in the AWS SDK for credentials and request signing, build with -tags no_route53 to leave
+// it out
+
+func init() {
+	providerFactories[config.ProviderRoute53] = newRoute53Provider
+}
+
+// newRoute53Provider returns the provider publishing records into Route53 hosted zones
+func newRoute53Provider(cfg *config.Config) (Provider, error) {
+	return route53.NewClientFromConfig(&cfg.Providers.Route53, &cfg.Bind)
+}
@@ -0,0 +1,154 @@
+package app
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"k8s.io/klog/v2"
+)
+
+// With bind.quarantine, names that drop out of the desired record set aren't deleted right away. Their records stay
+// published for the quarantine period with their TTL lowered to bind.quarantine_ttl, next to a TXT tombstone telling
+// when they were withdrawn and when they go, e.g.
+//
+//	laptop.ts.example.com. 30 IN A   100.64.1.1
+//	laptop.ts.example.com. 30 IN TXT "tailscale-bind-ddns: withdrawn 2025-01-01T12:00:00Z, removed after ..."
+//
+// so that an accidental removal, e.g. by a too broad exclude pattern, can be noticed and reverted before clients lose
+// the name. A name that is desired again leaves the quarantine, one still quarantined after the period is deleted like
+// any stale record. Quarantined TXT records are replaced by the tombstone, names holding a CNAME or PTR records get
+// none, a TXT record can't share its name with a CNAME and a reverse name has no place for it.
+//
+// Quarantines are kept in memory, names withdrawn while the process isn't running are deleted right away.
+
+// quarantineTombstonePrefix starts the value of tombstone records
+const quarantineTombstonePrefix = "tailscale-bind-ddns: withdrawn "
+
+// quarantineName identifies a name of the published records
+type quarantineName struct {
+	zone string
+	name string
+}
+
+// quarantinedName holds the records of a withdrawn name and when it was withdrawn
+type quarantinedName struct {
+	records []bind.DNSRecord
+	since   time.Time
+}
+
+// quarantine tracks the names withdrawn from the desired record set
+type quarantine struct {
+	period time.Duration
+	ttl    uint32
+
+	mu sync.Mutex
+	// Records of the names of the most recent desired record set, quarantined records aside
+	desired map[quarantineName][]bind.DNSRecord
+	// Names withdrawn within the quarantine period
+	withdrawn map[quarantineName]quarantinedName
+}
+
+// newQuarantine returns the quarantine of withdrawn names, nil when it's disabled
+func newQuarantine(period, ttl time.Duration) *quarantine {
+	if period <= 0 {
+		return nil
+	}
+	return &quarantine{
+		period:    period,
+		ttl:       uint32(ttl.Seconds()),
+		withdrawn: make(map[quarantineName]quarantinedName),
+	}
+}
+
+// apply returns the desired records along with the quarantined records and their tombstones. Names that were desired
+// last time but aren't anymore enter the quarantine, those desired again or withdrawn longer than the period leave it.
+func (q *quarantine) apply(records []bind.DNSRecord, now time.Time) []bind.DNSRecord {
+	if q == nil {
+		return records
+	}
+
+	desired := make(map[quarantineName][]bind.DNSRecord)
+	for _, record := range records {
+		name := quarantineName{zone: record.Zone, name: record.Name}
+		desired[name] = append(desired[name], record)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for name, previous := range q.desired {
+		if _, ok := desired[name]; ok {
+			continue
+		}
+		if _, ok := q.withdrawn[name]; !ok {
+			klog.Warningf("Quarantining withdrawn name %s for %v before deleting its %d records", name.name,
+				q.period, len(previous))
+			q.withdrawn[name] = quarantinedName{records: previous, since: now}
+		}
+	}
+	q.desired = desired
+
+	// Sorted so that the records are published in a stable order
+	names := slices.SortedFunc(maps.Keys(q.withdrawn), func(a, b quarantineName) int {
+		return cmp.Or(cmp.Compare(a.zone, b.zone), cmp.Compare(a.name, b.name))
+	})
+
+	published := slices.Clone(records)
+	for _, name := range names {
+		entry := q.withdrawn[name]
+		switch {
+		case desired[name] != nil:
+			klog.Infof("Withdrawn name %s is desired again, releasing it from quarantine", name.name)
+			delete(q.withdrawn, name)
+			continue
+		case now.Sub(entry.since) >= q.period:
+			klog.Infof("Quarantine of withdrawn name %s is over, deleting its records", name.name)
+			delete(q.withdrawn, name)
+			continue
+		}
+		published = append(published, q.quarantinedRecords(name, entry)...)
+	}
+	return published
+}
+
+// quarantinedRecords returns the records a quarantined name is published with
+func (q *quarantine) quarantinedRecords(name quarantineName, entry quarantinedName) []bind.DNSRecord {
+	records := make([]bind.DNSRecord, 0, len(entry.records)+1)
+	tombstone := true
+	for _, record := range entry.records {
+		switch record.Type {
+		case bind.TypeTXT:
+			continue
+		case bind.TypeCNAME, bind.TypePTR:
+			tombstone = false
+		case bind.TypeA, bind.TypeAAAA:
+		}
+		record.TTL = min(record.TTL, q.ttl)
+		records = append(records, record)
+	}
+	if tombstone {
+		records = append(records, bind.DNSRecord{
+			Name: name.name,
+			Value: quarantineTombstonePrefix + entry.since.UTC().Format(time.RFC3339) + ", removed after " +
+				entry.since.Add(q.period).UTC().Format(time.RFC3339),
+			TTL:  q.ttl,
+			Type: bind.TypeTXT,
+			Zone: name.zone,
+		})
+	}
+	return records
+}
+
+// size returns the number of names currently quarantined
+func (q *quarantine) size() int {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.withdrawn)
+}
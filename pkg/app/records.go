@@ -0,0 +1,123 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// recordLister is implemented by providers that can read back the records the DNS server holds
+type recordLister interface {
+	ServerRecords(ctx context.Context, records []bind.DNSRecord) ([]bind.ZoneContents, error)
+}
+
+// LiveDiff compares the records the DNS server holds in the managed zones to the desired records
+type LiveDiff struct {
+	Zones []bind.ZoneContents
+	// desired are the records the tailnet's machines would currently be published as
+	desired []bind.DNSRecord
+}
+
+// Empty reports whether every zone holds exactly the desired records
+func (d *LiveDiff) Empty() bool {
+	for _, zone := range d.Zones {
+		if !zone.Diff.Empty() {
+			return false
+		}
+	}
+	return true
+}
+
+// extraRecords returns the records the zones hold without them being desired, with the zone of additional zones set so
+// that they are handed to the client of their zone
+func (d *LiveDiff) extraRecords(mainZone string) []bind.DNSRecord {
+	var extra []bind.DNSRecord
+	for _, zone := range d.Zones {
+		for _, record := range zone.Diff.Removed {
+			if record.Type != bind.TypePTR && dns.CanonicalName(zone.Zone) != dns.CanonicalName(mainZone) {
+				record.Zone = zone.Zone
+			}
+			extra = append(extra, record)
+		}
+	}
+	return extra
+}
+
+// ListRecords reads the records the DNS server holds in the managed zones and compares them to the records the
+// tailnet's machines would currently be published as
+func (a *App) ListRecords(ctx context.Context) ([]bind.ZoneContents, error) {
+	diff, err := a.Diff(ctx)
+	if diff == nil {
+		return nil, err
+	}
+	return diff.Zones, err
+}
+
+// Diff reads the records the DNS server holds in the managed zones, transferring them when the server permits it, and
+// compares them to the records the tailnet's machines would currently be published as
+func (a *App) Diff(ctx context.Context) (*LiveDiff, error) {
+	provider, err := a.getProvider()
+	if err != nil {
+		return nil, err
+	}
+	lister, ok := provider.(recordLister)
+	if !ok {
+		return nil, fmt.Errorf("provider %q can't read back records", a.config.General.Provider)
+	}
+
+	tsClient, err := a.getTailscaleClient()
+	if err != nil {
+		return nil, err
+	}
+	machines, err := tsClient.GetMachines(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting machines: %w", err)
+	}
+
+	diff := &LiveDiff{desired: a.desiredRecords(machines)}
+	diff.Zones, err = lister.ServerRecords(ctx, diff.desired)
+	if err != nil {
+		return diff, fmt.Errorf("reading records: %w", err)
+	}
+	return diff, nil
+}
+
+// ApplyDiff publishes the desired records of a diff, adding the missing ones and replacing the record sets that hold
+// other values. With prune the records the zones hold without them being desired are removed as well, which takes
+// transferred zones. It goes through a provider without the state and queue files, like MigrateZone, and sends nothing
+// in dry-run mode.
+func (a *App) ApplyDiff(ctx context.Context, diff *LiveDiff, prune bool) (*bind.SyncResult, error) {
+	fixApp, err := a.zoneApp(a.config.Bind.Zone)
+	if err != nil {
+		return nil, err
+	}
+	defer fixApp.closePlugins()
+
+	provider, err := fixApp.getProvider()
+	if err != nil {
+		return nil, err
+	}
+	return applyDiff(ctx, provider, diff, prune, a.config.Bind.Zone, a.config.General.DryRun)
+}
+
+// applyDiff publishes the desired records of a diff through provider, which removes stale records. The extra records
+// are handed to it first when pruning, so that it removes them with the next update.
+func applyDiff(ctx context.Context, provider Provider, diff *LiveDiff, prune bool, mainZone string,
+	dryRun bool) (*bind.SyncResult, error) {
+	if extra := diff.extraRecords(mainZone); prune && len(extra) > 0 {
+		klog.Infof("Removing %d records that aren't desired", len(extra))
+		if _, err := provider.UpdateRecords(ctx, append(extra, diff.desired...), dryRun); err != nil {
+			return nil, fmt.Errorf("taking over records: %w", err)
+		}
+	}
+
+	klog.Infof("Publishing %d desired records", len(diff.desired))
+	result, err := provider.UpdateRecords(ctx, diff.desired, dryRun)
+	if err != nil {
+		return result, fmt.Errorf("publishing records: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,140 @@
+package app
+
+import (
+	"cmp"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/aauren/tailscale-bind-ddns/pkg/tailscale"
+	"k8s.io/klog/v2"
+)
+
+// With tailscale.routes every subnet route of an online device gets records at the name rendered from
+// tailscale.routes.name_template, pointing at the addresses of the device routing it, e.g. route-192-168-1-0-24 A
+// 100.64.0.5. Devices routing the same subnet for high availability share the name, each adding its addresses. Exit
+// node routes (0.0.0.0/0 and ::/0) get no records.
+
+// routeData is what route name templates are rendered from
+type routeData struct {
+	tailscale.Machine
+	Name    string // Hostname of the device routing the subnet, without the tailnet domain
+	Route   string // The route as advertised, e.g. 192.168.1.0/24
+	Network string // Network address of the route with dashes instead of dots and colons, e.g. 192-168-1-0
+	Bits    int    // Prefix length of the route, e.g. 24
+}
+
+// newRouteTemplate parses the route name template, returning nil when no route records are published
+func newRouteTemplate(cfg *config.RoutesConfig) (*template.Template, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	tmpl, err := template.New("route").Parse(cmp.Or(cfg.NameTemplate, config.DefaultRouteNameTemplate))
+	if err != nil {
+		return nil, fmt.Errorf("parsing route name template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// machineRoutes returns the subnet routes of a machine records are published for
+func (a *App) machineRoutes(machine tailscale.Machine) []netip.Prefix {
+	routes := machine.ApprovedRoutes
+	if a.config.Tailscale.Routes.Selection == config.RouteSelectionAdvertised {
+		routes = machine.AdvertisedRoutes
+	}
+
+	var prefixes []netip.Prefix
+	for _, route := range routes {
+		prefix, err := netip.ParsePrefix(route)
+		if err != nil {
+			klog.V(1).Infof("Ignoring invalid route %q of %s (%s): %v", route, machine.Name, machine.ID, err)
+			continue
+		}
+		if prefix.Bits() == 0 {
+			continue // Exit node
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes
+}
+
+// createRouteRecords creates the records of the subnet routes of the given machines. Routes whose name template fails
+// to render or whose name is taken by a device get no records.
+func (a *App) createRouteRecords(machines []tailscale.Machine) []bind.DNSRecord {
+	var routeRecords []bind.DNSRecord
+	if a.routes == nil {
+		return routeRecords
+	}
+
+	names := a.namer.names(machines)
+	taken := make(map[string]bool, len(names))
+	for _, name := range names {
+		taken[strings.ToLower(name)] = true
+	}
+
+	for _, machine := range machines {
+		if !machine.Online {
+			continue
+		}
+		for _, route := range a.machineRoutes(machine) {
+			name, err := a.routeName(machine, route)
+			if err != nil {
+				klog.Warningf("Failed to render the record name of route %s of %s (%s): %v", route, machine.Name,
+					machine.ID, err)
+				continue
+			}
+			if taken[strings.ToLower(name)] {
+				klog.Warningf("Not publishing route %s of %s: its name %s is taken by a device", route, machine.Name,
+					name)
+				continue
+			}
+			routeRecords = append(routeRecords, a.gatewayRecords(machine, name)...)
+		}
+	}
+
+	// Devices sharing a route may report it in different orders, sorted the records don't change between polls
+	slices.SortFunc(routeRecords, func(x, y bind.DNSRecord) int {
+		return cmp.Or(cmp.Compare(x.Name, y.Name), cmp.Compare(x.Type, y.Type), cmp.Compare(x.Value, y.Value))
+	})
+	klog.V(1).Infof("Created %d route records", len(routeRecords))
+	return routeRecords
+}
+
+// routeName renders the record name of a route of a machine
+func (a *App) routeName(machine tailscale.Machine, route netip.Prefix) (string, error) {
+	network := strings.NewReplacer(".", "-", ":", "-").Replace(route.Addr().String())
+	data := routeData{
+		Machine: machine,
+		Name:    strings.Split(cmp.Or(machine.Name, machine.ID), ".")[0],
+		Route:   route.String(),
+		Network: network,
+		Bits:    route.Bits(),
+	}
+	var name strings.Builder
+	if err := a.routes.Execute(&name, data); err != nil {
+		return "", err
+	}
+	sanitized := sanitizeRecordName(name.String())
+	if sanitized == "" {
+		return "", fmt.Errorf("the template rendered no name")
+	}
+	return sanitized, nil
+}
+
+// gatewayRecords returns the A and AAAA records pointing a route name at the addresses of the machine routing it
+func (a *App) gatewayRecords(machine tailscale.Machine, name string) []bind.DNSRecord {
+	var records []bind.DNSRecord
+	ttl := a.recordTTL(machine)
+	zone := a.machineZone(machine)
+	for _, address := range a.machineIPv4Addresses(machine) {
+		records = append(records, bind.DNSRecord{Name: name, Value: address, TTL: ttl, Type: bind.TypeA, Zone: zone})
+	}
+	for _, address := range a.machineIPv6Addresses(machine) {
+		records = append(records, bind.DNSRecord{Name: name, Value: address, TTL: ttl, Type: bind.TypeAAAA, Zone: zone})
+	}
+	return records
+}
@@ -0,0 +1,113 @@
+package app
+
+import (
+	"context"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"k8s.io/klog/v2"
+)
+
+// With bind.key_secret_file, tailscale.api_key_file or tailscale.client_secret_file secrets are read from files, e.g.
+// mounted Kubernetes or Docker secrets, which are re-read every poll interval. A changed TSIG secret is verified and
+// swapped in like one given to the rotate-key command, so the previous key stays in use until the server accepts the
+// new one and the swap is retried with the next check. Changed Tailscale credentials are used for the next request.
+
+// startSecretFiles starts checking the secret files for changes when any are configured
+func (a *App) startSecretFiles(ctx context.Context) {
+	bindFile := a.config.UsesBind() && a.config.Bind.KeySecretFile != ""
+	tailscaleFiles := (a.config.Tailscale.Mode == "" || a.config.Tailscale.Mode == config.TailscaleModeAPI) &&
+		(a.config.Tailscale.APIKeyFile != "" || a.config.Tailscale.ClientSecretFile != "")
+	if !bindFile && !tailscaleFiles {
+		return
+	}
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		ticker := clock.Or(a.clock).NewTicker(a.config.Tailscale.PollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+				if bindFile {
+					a.reloadKeySecretFile(ctx)
+				}
+				if tailscaleFiles {
+					a.reloadTailscaleSecretFiles()
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// reloadKeySecretFile rotates the TSIG key to the secret of bind.key_secret_file when it changed
+func (a *App) reloadKeySecretFile(ctx context.Context) {
+	a.clientsMu.Lock()
+	bindCfg := a.config.Bind
+	a.clientsMu.Unlock()
+
+	secret, err := config.ReadSecretFile(bindCfg.KeySecretFile)
+	if err != nil {
+		klog.Errorf("Failed to read bind.key_secret_file, keeping the current TSIG key: %v", err)
+		return
+	}
+	if secret == bindCfg.KeySecret {
+		return
+	}
+
+	klog.Infof("TSIG secret in %s changed, rotating key %s", bindCfg.KeySecretFile, bindCfg.KeyName)
+	rotation := KeyRotation{KeyName: bindCfg.KeyName, KeySecret: secret, Algorithm: bindCfg.Algorithm}
+	if err := a.rotateKey(ctx, bindCfg, rotation); err != nil {
+		klog.Errorf("Failed to rotate to the TSIG secret of bind.key_secret_file, keeping the current key: %v", err)
+	}
+}
+
+// reloadTailscaleSecretFiles swaps the credentials of tailscale.api_key_file and tailscale.client_secret_file into the
+// Tailscale client when they changed
+func (a *App) reloadTailscaleSecretFiles() {
+	a.clientsMu.Lock()
+	tsCfg := a.config.Tailscale
+	a.clientsMu.Unlock()
+
+	rotated := tsCfg
+	for _, secret := range []struct {
+		key   string
+		file  string
+		value *string
+	}{
+		{"tailscale.api_key_file", tsCfg.APIKeyFile, &rotated.APIKey},
+		{"tailscale.client_secret_file", tsCfg.ClientSecretFile, &rotated.ClientSecret},
+	} {
+		if secret.file == "" {
+			continue
+		}
+		value, err := config.ReadSecretFile(secret.file)
+		if err != nil {
+			klog.Errorf("Failed to read %s, keeping the current Tailscale credentials: %v", secret.key, err)
+			return
+		}
+		*secret.value = value
+	}
+	if rotated.APIKey == tsCfg.APIKey && rotated.ClientSecret == tsCfg.ClientSecret {
+		return
+	}
+
+	tsClient, err := a.getTailscaleClient()
+	if err != nil {
+		klog.Errorf("Failed to rotate the Tailscale credentials: %v", err)
+		return
+	}
+	if err := tsClient.SetCredentials(&rotated); err != nil {
+		klog.Errorf("Failed to rotate the Tailscale credentials: %v", err)
+		return
+	}
+
+	a.clientsMu.Lock()
+	a.config.Tailscale.APIKey = rotated.APIKey
+	a.config.Tailscale.ClientSecret = rotated.ClientSecret
+	a.clientsMu.Unlock()
+	klog.Info("Rotated the Tailscale credentials")
+}
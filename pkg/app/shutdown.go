@@ -0,0 +1,71 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"k8s.io/klog/v2"
+)
+
+// shutdownRemovalTimeout bounds how long removing the published records may delay the shutdown
+const shutdownRemovalTimeout = 30 * time.Second
+
+// recordRemover is implemented by providers that can remove every record they published, whatever bind.remove_stale
+// says
+type recordRemover interface {
+	RemoveAll(ctx context.Context, dryRun bool) (int, error)
+}
+
+// removeOnShutdown removes the records published to the provider and the targets configured with
+// bind.remove_on_shutdown. It runs once the updaters stopped, so that no update publishes records again afterwards.
+func (a *App) removeOnShutdown() {
+	a.clientsMu.Lock()
+	provider := a.provider
+	a.clientsMu.Unlock()
+
+	if a.config.Bind.RemoveOnShutdown && provider != nil {
+		a.removeAll("", a.config, provider)
+	}
+	for _, t := range a.targets {
+		a.clientsMu.Lock()
+		provider := t.provider
+		a.clientsMu.Unlock()
+		if t.config.Bind.RemoveOnShutdown && provider != nil {
+			a.removeAll(t.name, t.config, provider)
+		}
+	}
+}
+
+// removeAll removes every record published to a provider, named by its target. Providers that can't remove their
+// records otherwise are sent an empty record set, which withdraws them as stale records.
+func (a *App) removeAll(name string, cfg *config.Config, provider Provider) {
+	where := "the DNS provider"
+	if name != "" {
+		where = "target " + name
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownRemovalTimeout)
+	defer cancel()
+
+	if remover, ok := provider.(recordRemover); ok {
+		removed, err := remover.RemoveAll(ctx, cfg.General.DryRun)
+		if err != nil {
+			klog.Errorf("Failed to remove the records of %s on shutdown: %v", where, err)
+			return
+		}
+		klog.Infof("Removed %d records from %s on shutdown", removed, where)
+		return
+	}
+
+	if !cfg.Bind.RemoveStale {
+		klog.Warningf("Not removing the records of %s on shutdown: provider %s only removes them with remove_stale",
+			where, cfg.General.Provider)
+		return
+	}
+	if _, err := provider.UpdateRecords(ctx, nil, cfg.General.DryRun); err != nil {
+		klog.Errorf("Failed to remove the records of %s on shutdown: %v", where, err)
+		return
+	}
+	klog.Infof("Removed the records of %s on shutdown", where)
+}
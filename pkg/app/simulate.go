@@ -0,0 +1,96 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"github.com/aauren/tailscale-bind-ddns/pkg/tailscale"
+)
+
+// Snapshot is a recorded view of the tailnet's machines at a point in time
+type Snapshot struct {
+	Time     time.Time           `json:"time"`
+	Machines []tailscale.Machine `json:"machines"`
+}
+
+// SimulationStep holds the DNS changes a snapshot would produce relative to the snapshot before it
+type SimulationStep struct {
+	Snapshot Snapshot
+	Records  []bind.DNSRecord
+	Diff     bind.RecordDiff
+	// Error is why nothing was published for the snapshot, e.g. a name collision with bind.name_collisions fail
+	Error error
+}
+
+// LoadSnapshots reads a JSON array of snapshots from a file
+func LoadSnapshots(path string) ([]Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading snapshots: %w", err)
+	}
+
+	var snapshots []Snapshot
+	if err := json.Unmarshal(data, &snapshots); err != nil {
+		return nil, fmt.Errorf("parsing snapshots from %s: %w", path, err)
+	}
+	return snapshots, nil
+}
+
+// Simulate replays snapshots through the record pipeline without contacting any server, returning the changes each
+// step would make to DNS. The first step is diffed against an empty zone. Snapshots take the path of a poll: through
+// the online smoothing and offline policy of the Tailscale client, then through the stages of publishing,
+// max_record_age, the name collision check and the quarantine, with the clock of the app following the times of the
+// snapshots. It's meant for an app that isn't running.
+func (a *App) Simulate(snapshots []Snapshot) ([]SimulationStep, error) {
+	replay, err := tailscale.NewReplay(&a.config.Tailscale, a.config.Bind.OfflinePolicy,
+		a.config.Bind.OfflineGracePeriod)
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshots) == 0 {
+		return nil, nil
+	}
+
+	clk := clock.NewFake(snapshots[0].Time)
+	defer func(previous clock.Clock) { a.clock = previous }(a.clock)
+	a.clock = clk
+	quarantine := newQuarantine(a.config.Bind.Quarantine, a.config.Bind.QuarantineTTL)
+
+	steps := make([]SimulationStep, 0, len(snapshots))
+	var previous []bind.DNSRecord
+	for _, snapshot := range snapshots {
+		if elapsed := snapshot.Time.Sub(clk.Now()); elapsed > 0 {
+			clk.Advance(elapsed)
+		}
+		now := clk.Now()
+		machines := replay.Poll(snapshot.Machines, now)
+
+		// Like publish, nothing is published while devices collide with bind.name_collisions fail
+		step := SimulationStep{Snapshot: snapshot, Records: previous}
+		records, _ := a.timedDesiredRecords(machines)
+		if err := a.checkNameCollisions(machines, now); err != nil {
+			step.Error = err
+		} else {
+			step.Records = quarantine.apply(records, now)
+			step.Diff = bind.DiffRecords(previous, step.Records)
+			previous = step.Records
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// onlineMachines filters machines down to the online ones, mirroring what the Tailscale poller hands to the pipeline
+func onlineMachines(machines []tailscale.Machine) []tailscale.Machine {
+	var online []tailscale.Machine
+	for _, machine := range machines {
+		if machine.Online {
+			online = append(online, machine)
+		}
+	}
+	return online
+}
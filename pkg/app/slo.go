@@ -0,0 +1,130 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"k8s.io/klog/v2"
+)
+
+// With bind.slo, every zone update is judged against a success rate and a 95th percentile latency over the updates of
+// a rolling window. The status and the gauges report whether the objectives are currently violated, so that alerting
+// can watch a single flag instead of knowing the thresholds. Dry runs aren't judged, and without any update in the
+// window the objectives count as met.
+
+// sloPercentile is the percentile of the update latencies the latency objective applies to
+const sloPercentile = 0.95
+
+// sloSample is the outcome of a single zone update
+type sloSample struct {
+	finished time.Time
+	duration time.Duration
+	failed   bool
+}
+
+// SLOStatus is how the zone updates of the window measure up against the objectives
+type SLOStatus struct {
+	Window      time.Duration `json:"window"`
+	Updates     int           `json:"updates"`
+	SuccessRate float64       `json:"success_rate"`
+	P95Latency  time.Duration `json:"p95_latency"`
+	Violated    bool          `json:"violated"`
+	// Violations describes every objective that is violated
+	Violations    []string  `json:"violations,omitempty"`
+	ViolatedSince time.Time `json:"violated_since,omitzero"`
+}
+
+// sloTracker judges the zone updates against the objectives
+type sloTracker struct {
+	objectives config.SLOConfig
+
+	mu      sync.Mutex
+	samples []sloSample // Oldest first
+	// When the objectives started being violated, zero while they're met
+	violatedSince time.Time
+}
+
+// newSLOTracker returns the tracker of the objectives, nil when none is set
+func newSLOTracker(objectives config.SLOConfig) *sloTracker {
+	if !objectives.Enabled() {
+		return nil
+	}
+	return &sloTracker{objectives: objectives}
+}
+
+// observe adds the zone updates of a result and logs when the objectives become violated or are met again
+func (s *sloTracker) observe(result *bind.SyncResult, now time.Time) {
+	if s == nil || result.DryRun || len(result.Zones) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, zone := range result.Zones {
+		s.samples = append(s.samples, sloSample{finished: now, duration: zone.Duration, failed: zone.Error != ""})
+	}
+	s.evaluate(now)
+}
+
+// status returns how the updates of the window measure up against the objectives, nil when none is set
+func (s *sloTracker) status(now time.Time) *SLOStatus {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := s.evaluate(now)
+	return &status
+}
+
+// evaluate drops the updates that left the window and judges the others. It must be called with mu held.
+func (s *sloTracker) evaluate(now time.Time) SLOStatus {
+	cutoff := now.Add(-s.objectives.Window)
+	s.samples = slices.DeleteFunc(s.samples, func(sample sloSample) bool { return sample.finished.Before(cutoff) })
+
+	status := SLOStatus{Window: s.objectives.Window, Updates: len(s.samples), SuccessRate: 1}
+	if len(s.samples) > 0 {
+		failed := 0
+		durations := make([]time.Duration, 0, len(s.samples))
+		for _, sample := range s.samples {
+			if sample.failed {
+				failed++
+			}
+			durations = append(durations, sample.duration)
+		}
+		slices.Sort(durations)
+		status.SuccessRate = float64(len(s.samples)-failed) / float64(len(s.samples))
+		// Nearest rank, so that the percentile is always one of the measured latencies
+		status.P95Latency = durations[int(math.Ceil(sloPercentile*float64(len(durations))))-1]
+	}
+
+	if s.objectives.SuccessRate > 0 && status.SuccessRate < s.objectives.SuccessRate {
+		status.Violations = append(status.Violations, fmt.Sprintf("success rate %.4g below %.4g",
+			status.SuccessRate, s.objectives.SuccessRate))
+	}
+	if s.objectives.P95Latency > 0 && status.P95Latency > s.objectives.P95Latency {
+		status.Violations = append(status.Violations, fmt.Sprintf("p95 latency %v above %v", status.P95Latency,
+			s.objectives.P95Latency))
+	}
+	status.Violated = len(status.Violations) > 0
+
+	switch {
+	case status.Violated && s.violatedSince.IsZero():
+		s.violatedSince = now
+		klog.Warningf("Zone updates violate their SLO: %s", strings.Join(status.Violations, ", "))
+	case !status.Violated && !s.violatedSince.IsZero():
+		s.violatedSince = time.Time{}
+		klog.Infof("Zone updates meet their SLO again")
+	}
+	status.ViolatedSince = s.violatedSince
+	return status
+}
@@ -0,0 +1,287 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/aauren/tailscale-bind-ddns/pkg/web"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// StatusPath is the HTTP path the daemon serves its status on
+	StatusPath = "/status"
+	// RotateKeyPath is the HTTP path accepting TSIG key rotations, only served on unix sockets
+	RotateKeyPath = "/rotate-key"
+	// HistoryPath is the HTTP path serving the transitions of the device given by the host query parameter
+	HistoryPath = "/history"
+	// ExplainPath is the HTTP path explaining which stages of the record pipeline each device passed, only served on
+	// unix sockets since every request looks the devices up through the Tailscale API
+	ExplainPath = "/explain"
+	// GaugesPath is the HTTP path serving the key numbers of the daemon
+	GaugesPath = "/gauges"
+
+	statusReadHeaderTimeout = 5 * time.Second
+	statusShutdownTimeout   = 5 * time.Second
+)
+
+// listenAddress opens a listener for a status or admin address, which is either host:port or unix:/path/to/socket
+func listenAddress(address string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(address, config.UnixAddressPrefix); ok {
+		// Remove a stale socket left behind by a previous run
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("removing stale socket %s: %w", path, err)
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", address)
+}
+
+// statusHandler returns the HTTP handler serving the application status as JSON. Administrative endpoints, those that
+// accept secrets or spend the API rate limit of the tailnet, are only added when admin is set, which is the case for
+// unix sockets protected by filesystem permissions.
+func (a *App) statusHandler(admin bool) http.Handler {
+	mux := http.NewServeMux()
+	if admin {
+		mux.HandleFunc(RotateKeyPath, a.handleRotateKey)
+		mux.HandleFunc(ExplainPath, a.handleExplain)
+	}
+	mux.HandleFunc(StatusPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(a.GetStatus()); err != nil {
+			klog.Errorf("Failed to encode status response: %v", err)
+		}
+	})
+	mux.HandleFunc(HistoryPath, a.handleHistory)
+	mux.Handle(DashboardPath, web.NewHandler(a.Dashboard))
+	mux.HandleFunc(GaugesPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(a.Gauges()); err != nil {
+			klog.Errorf("Failed to encode gauges response: %v", err)
+		}
+	})
+	return mux
+}
+
+// handleHistory serves the recorded transitions of a single device
+func (a *App) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	host := r.URL.Query().Get("host")
+	if host == "" {
+		http.Error(w, "host query parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	history, ok := a.DeviceHistory(host)
+	if !ok {
+		http.Error(w, fmt.Sprintf("no history for %s", host), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(history); err != nil {
+		klog.Errorf("Failed to encode history response: %v", err)
+	}
+}
+
+// handleExplain serves the explanation of how the record pipeline treats every device
+func (a *App) handleExplain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	explanations, err := a.ExplainMachines(r.Context())
+	if err != nil {
+		klog.Errorf("Failed to explain machines: %v", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(explanations); err != nil {
+		klog.Errorf("Failed to encode explain response: %v", err)
+	}
+}
+
+// handleRotateKey verifies and applies a TSIG key rotation posted by the rotate-key command
+func (a *App) handleRotateKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var rotation KeyRotation
+	if err := json.NewDecoder(r.Body).Decode(&rotation); err != nil {
+		http.Error(w, fmt.Sprintf("decoding key rotation: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := a.RotateKey(r.Context(), rotation); err != nil {
+		klog.Errorf("TSIG key rotation failed: %v", err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveStatus serves the application status on the configured address until the context is cancelled
+func (a *App) serveStatus(ctx context.Context, listener net.Listener) {
+	server := &http.Server{
+		Handler:           a.statusHandler(listener.Addr().Network() == "unix"),
+		ReadHeaderTimeout: statusReadHeaderTimeout,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), statusShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			klog.Errorf("Failed to shut down status server: %v", err)
+		}
+	}()
+
+	klog.Infof("Serving status on %s", listener.Addr())
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		klog.Errorf("Status server failed: %v", err)
+	}
+}
+
+// newDaemonClient returns an HTTP client and base URL for talking to a running daemon at its status address
+func newDaemonClient(address string) (*http.Client, string) {
+	transport := &http.Transport{}
+	baseURL := "http://" + address
+	if path, ok := strings.CutPrefix(address, config.UnixAddressPrefix); ok {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", path)
+		}
+		baseURL = "http://unix"
+	}
+	return &http.Client{Transport: transport}, baseURL
+}
+
+// getJSON queries path, with its query string, on a running daemon and decodes the JSON response into out. The body
+// of error responses holds the reason the daemon gives.
+func getJSON(ctx context.Context, address, path string, out any) error {
+	client, baseURL := newDaemonClient(address)
+	defer client.CloseIdleConnections()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+path, nil)
+	if err != nil {
+		return fmt.Errorf("building request for %s: %w", path, err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("querying daemon at %s: %w", address, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("daemon request for %s failed (%s): %s", path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding daemon response for %s: %w", path, err)
+	}
+	return nil
+}
+
+// FetchStatus queries the status endpoint of a running daemon
+func FetchStatus(ctx context.Context, address string) (map[string]interface{}, error) {
+	var status map[string]interface{}
+	err := getJSON(ctx, address, StatusPath, &status)
+	return status, err
+}
+
+// FetchGauges queries the key numbers of a running daemon
+func FetchGauges(ctx context.Context, address string) (*Gauges, error) {
+	var gauges Gauges
+	err := getJSON(ctx, address, GaugesPath, &gauges)
+	return &gauges, err
+}
+
+// FetchDashboard queries a running daemon for what its status page shows
+func FetchDashboard(ctx context.Context, address string) (*web.Dashboard, error) {
+	var dashboard web.Dashboard
+	err := getJSON(ctx, address, DashboardPath+"?format=json", &dashboard)
+	return &dashboard, err
+}
+
+// FetchHistory queries a running daemon for the recorded transitions of the device matching host
+func FetchHistory(ctx context.Context, address, host string) (*DeviceHistory, error) {
+	var history DeviceHistory
+	err := getJSON(ctx, address, HistoryPath+"?"+url.Values{"host": []string{host}}.Encode(), &history)
+	return &history, err
+}
+
+// FetchExplanations asks a running daemon how the record pipeline treats every device. Only unix socket addresses
+// are accepted, the daemon doesn't serve explanations on TCP.
+func FetchExplanations(ctx context.Context, address string) ([]MachineExplanation, error) {
+	if !strings.HasPrefix(address, config.UnixAddressPrefix) {
+		return nil, fmt.Errorf("explaining machines of a running daemon requires a unix socket status address, got %q",
+			address)
+	}
+	var explanations []MachineExplanation
+	err := getJSON(ctx, address, ExplainPath, &explanations)
+	return explanations, err
+}
+
+// RotateDaemonKey asks a running daemon to verify and swap in a new TSIG key. Only unix socket addresses are accepted
+// so that the secret never crosses the network.
+func RotateDaemonKey(ctx context.Context, address string, rotation KeyRotation) error {
+	if !strings.HasPrefix(address, config.UnixAddressPrefix) {
+		return fmt.Errorf("live key rotation requires a unix socket status address, got %q", address)
+	}
+
+	body, err := json.Marshal(rotation)
+	if err != nil {
+		return fmt.Errorf("encoding key rotation: %w", err)
+	}
+
+	client, baseURL := newDaemonClient(address)
+	defer client.CloseIdleConnections()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+RotateKeyPath, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("building key rotation request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending key rotation to daemon at %s: %w", address, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("daemon rejected key rotation (%s): %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"k8s.io/klog/v2"
+)
+
+// A single sync runs the pipeline of Run once, for cron jobs and systemd timers that don't keep a daemon running. The
+// records go through the same filters, naming rules, plugins and quarantine as those of the daemon and are published
+// to the provider and the targets. Stale records are only removed when the provider knows what it published before,
+// i.e. with bind.state_file or from the comments of PowerDNS record sets, since every sync starts a new process.
+
+// SyncReport reports a single sync
+type SyncReport struct {
+	// Machines counts the online machines polled and Records the records handed to the provider
+	Machines int
+	Records  int
+	// Result is the outcome of the update of the provider, nil when it didn't get there
+	Result *bind.SyncResult
+}
+
+// Sync validates the connection to the DNS provider, back-populates the reverse zones when bind.ptr.bootstrap is set,
+// polls Tailscale once and publishes the resulting records to the provider and the targets. Errors of polling and
+// publishing wrap ErrPollFailed and ErrPublishFailed, and the report is nil when nothing was published.
+func (a *App) Sync(ctx context.Context) (*SyncReport, error) {
+	a.ipv6 = detectIPv6(a.config)
+	defer a.closePlugins()
+
+	tsClient, err := a.getTailscaleClient()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := tsClient.Close(); err != nil {
+			klog.Errorf("Failed to close Tailscale client: %v", err)
+		}
+	}()
+	provider, err := a.getProvider()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := provider.ValidateConnection(ctx); err != nil {
+		return nil, fmt.Errorf("%w: dns provider connection validation failed: %w", ErrPublishFailed, err)
+	}
+	if a.config.Bind.PTR.Enabled && a.config.Bind.PTR.Bootstrap {
+		a.bootstrapPTR(ctx, tsClient, provider)
+	}
+	return a.syncOnce(ctx)
+}
@@ -0,0 +1,175 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/aauren/tailscale-bind-ddns/pkg/tailscale"
+	"k8s.io/klog/v2"
+)
+
+// Besides the main provider, the machines can be published to targets, each a provider and zone of its own with a
+// selector deciding which machines it gets, e.g. an internal zone with every machine and a public one with only the
+// tagged ones (split horizon). Every poll fans out to the main provider and every target, each of which keeps its own
+// update loop, retries and zone status. Targets get the address, Funnel CNAME and metadata records of their machines
+// in their zone; aliases, PTR records, plugins, external records and quarantines only apply to the main provider.
+// Every zone of a view is a target of its own, named <view>@<zone>, with the selector of its view, so that the views
+// are published from the same machines as the main provider.
+
+// target is an additional provider machines are published to
+type target struct {
+	name string
+	// Configuration the provider is constructed from, see config.Config.ForTarget
+	config *config.Config
+	// Selector of the machines published to the target, nil when every machine is
+	selector *zoneSelector
+
+	// Constructed in Run or on the first sync, guarded by App.clientsMu
+	provider   Provider
+	recordChan chan []bind.DNSRecord
+}
+
+// newTargets compiles the selectors of the targets and the zones of the views
+func newTargets(cfg *config.Config) ([]*target, error) {
+	targetCfgs := slices.Concat(cfg.Targets, cfg.ViewTargets())
+	targets := make([]*target, 0, len(targetCfgs))
+	for _, targetCfg := range targetCfgs {
+		t := &target{
+			name:       targetCfg.Name,
+			config:     cfg.ForTarget(targetCfg),
+			recordChan: make(chan []bind.DNSRecord, 10),
+		}
+		if len(targetCfg.Tags) > 0 || len(targetCfg.Hostnames) > 0 || len(targetCfg.Users) > 0 {
+			selectors, err := newZoneSelectors([]config.ZoneConfig{{
+				Name:      targetCfg.Zone,
+				Tags:      targetCfg.Tags,
+				Hostnames: targetCfg.Hostnames,
+				Users:     targetCfg.Users,
+			}})
+			if err != nil {
+				return nil, fmt.Errorf("target %s: %w", targetCfg.Name, err)
+			}
+			t.selector = selectors[0]
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// getTargetProvider returns the provider of a target, constructing it on first use
+func (a *App) getTargetProvider(t *target) (Provider, error) {
+	a.clientsMu.Lock()
+	defer a.clientsMu.Unlock()
+
+	if t.provider == nil {
+		provider, err := newProvider(t.config)
+		if err != nil {
+			return nil, fmt.Errorf("target %s: %w", t.name, err)
+		}
+		if setter, ok := provider.(clockSetter); ok {
+			setter.SetClock(a.clock)
+		}
+		if tracker, ok := provider.(deviceTracker); ok {
+			tracker.SetDeviceLookup(a.recordDevice)
+		}
+		t.provider = provider
+	}
+	return t.provider, nil
+}
+
+// startTargets validates the connection of every target's provider and starts its updates
+func (a *App) startTargets(ctx context.Context) error {
+	for _, t := range a.targets {
+		provider, err := a.getTargetProvider(t)
+		if err != nil {
+			return err
+		}
+		if err := provider.ValidateConnection(ctx); err != nil {
+			return fmt.Errorf("target %s connection validation failed: %w", t.name, err)
+		}
+
+		klog.Infof("Publishing to target %s in zone %s", t.name, t.config.Bind.Zone)
+		a.wg.Add(1)
+		go func() {
+			defer a.wg.Done()
+			provider.StartUpdating(ctx, a.config.Bind.UpdateInterval, t.recordChan, a.config.General.DryRun)
+		}()
+		a.startLeases(ctx, t.name, t.config, provider)
+	}
+	return nil
+}
+
+// targetRecords returns the records of the machines a target gets, all in the target's zone
+func (a *App) targetRecords(t *target, machines []tailscale.Machine, now time.Time) []bind.DNSRecord {
+	var selected []tailscale.Machine
+	for _, machine := range a.publishedMachines(a.withoutUnseen(machines, now)) {
+		if t.selector == nil || t.selector.matches(machine) {
+			selected = append(selected, machine)
+		}
+	}
+
+	records := append(a.machinesToRecords(selected), a.createMetadataRecords(selected)...)
+	for i := range records {
+		records[i].Zone = ""
+	}
+	return records
+}
+
+// publishTargets hands the records of every target to its provider. It returns false when the context was cancelled
+// first.
+func (a *App) publishTargets(ctx context.Context, machines []tailscale.Machine, now time.Time) bool {
+	for _, t := range a.targets {
+		records := a.targetRecords(t, machines, now)
+		select {
+		case t.recordChan <- records:
+			klog.V(2).Infof("Sent %d records to target %s", len(records), t.name)
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+// syncTargets publishes the records of every target immediately, continuing past targets that fail
+func (a *App) syncTargets(ctx context.Context, machines []tailscale.Machine, now time.Time) error {
+	var errs []error
+	for _, t := range a.targets {
+		provider, err := a.getTargetProvider(t)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if _, err := provider.UpdateRecords(ctx, a.targetRecords(t, machines, now), a.config.General.DryRun); err != nil {
+			errs = append(errs, fmt.Errorf("target %s: %w", t.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// closeTargets closes the record channels of the targets once nothing is published anymore
+func (a *App) closeTargets() {
+	for _, t := range a.targets {
+		close(t.recordChan)
+	}
+}
+
+// targetStatuses returns the per-zone outcome of the most recent updates of every target whose provider tracks them
+func (a *App) targetStatuses() map[string]map[string]bind.ZoneStatus {
+	a.clientsMu.Lock()
+	defer a.clientsMu.Unlock()
+
+	statuses := make(map[string]map[string]bind.ZoneStatus)
+	for _, t := range a.targets {
+		if reporter, ok := t.provider.(zoneStatusReporter); ok {
+			if zones := reporter.ZoneStatuses(); len(zones) > 0 {
+				statuses[t.name] = zones
+			}
+		}
+	}
+	return statuses
+}
@@ -0,0 +1,129 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"github.com/aauren/tailscale-bind-ddns/pkg/tailscale"
+	"k8s.io/klog/v2"
+)
+
+// Every update cycle takes the machines of a poll through the same stages: getting them from Tailscale, filtering out
+// those that get no records, converting the rest into records and publishing those zone by zone. How long each stage
+// took is logged at the end of the cycle and kept for the status, so that a slow cycle can be narrowed down to the
+// stage and the zone responsible.
+
+// CycleTimings is how long the stages of an update cycle took
+type CycleTimings struct {
+	Finished time.Time `json:"finished"`
+	// Total is the sum of the stages
+	Total time.Duration `json:"total"`
+	// Fetch is how long getting the machines from Tailscale took, together with the zones read ahead of the update
+	Fetch   time.Duration `json:"fetch"`
+	Filter  time.Duration `json:"filter"`
+	Convert time.Duration `json:"convert"`
+	// Update is how long the provider took to publish the records, the zones included
+	Update time.Duration `json:"update"`
+	Zones  []ZoneTiming  `json:"zones,omitempty"`
+}
+
+// ZoneTiming is how long the update of a zone took and how much of that was spent verifying it
+type ZoneTiming struct {
+	Zone   string        `json:"zone"`
+	Update time.Duration `json:"update"`
+	Verify time.Duration `json:"verify,omitempty"`
+}
+
+// String returns the stages of the cycle on one line
+func (t CycleTimings) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v: fetch %v, filter %v, convert %v, update %v", t.Total, t.Fetch, t.Filter, t.Convert, t.Update)
+	for _, zone := range t.Zones {
+		fmt.Fprintf(&b, ", zone %s %v", zone.Zone, zone.Update)
+		if zone.Verify > 0 {
+			fmt.Fprintf(&b, " (verify %v)", zone.Verify)
+		}
+	}
+	return b.String()
+}
+
+// timedDesiredRecords returns the desired records for machines like desiredRecords, along with how long filtering the
+// machines and converting them took
+func (a *App) timedDesiredRecords(machines []tailscale.Machine) ([]bind.DNSRecord, CycleTimings) {
+	clk := clock.Or(a.clock)
+	started := clk.Now()
+	published := a.publishedMachines(a.withoutUnseen(machines, started))
+	filtered := clk.Now()
+	records := a.withExternalRecords(a.convertMachines(published))
+	return records, CycleTimings{Filter: filtered.Sub(started), Convert: clk.Since(filtered)}
+}
+
+// startCycle remembers the timings of the stages before the update, which the provider's result completes
+func (a *App) startCycle(timings CycleTimings) {
+	a.timingsMu.Lock()
+	defer a.timingsMu.Unlock()
+	a.pendingCycle = timings
+}
+
+// finishCycle completes the timings of the current cycle with the outcome of the provider's update. Updates retried
+// without a new cycle only report the update.
+func (a *App) finishCycle(result *bind.SyncResult) {
+	a.timingsMu.Lock()
+	timings := a.pendingCycle
+	a.pendingCycle = CycleTimings{}
+	a.timingsMu.Unlock()
+
+	a.completeCycle(timings, result)
+	if !result.DryRun {
+		a.verifyJoins(context.Background())
+	}
+}
+
+// completeCycle completes the timings of the stages before an update with its outcome, logs them and keeps them for
+// the status
+func (a *App) completeCycle(timings CycleTimings, result *bind.SyncResult) {
+	timings.Finished = result.Started.Add(result.Duration)
+	timings.Update = result.Duration
+	timings.Zones = make([]ZoneTiming, 0, len(result.Zones))
+	for _, zone := range result.Zones {
+		timings.Zones = append(timings.Zones, ZoneTiming{Zone: zone.Zone, Update: zone.Duration, Verify: zone.Verify})
+	}
+	timings.Total = timings.Fetch + timings.Filter + timings.Convert + timings.Update
+	a.countFailedUpdates(result)
+	a.slo.observe(result, clock.Or(a.clock).Now())
+
+	klog.V(1).Infof("Update cycle took %v", timings)
+
+	a.timingsMu.Lock()
+	defer a.timingsMu.Unlock()
+	a.lastCycle = &timings
+	a.lastResult = result
+	a.recordUpdateErrors(result)
+}
+
+// pollDuration returns how long the most recent poll of the Tailscale client took, 0 before there is a client
+func (a *App) pollDuration() time.Duration {
+	a.clientsMu.Lock()
+	defer a.clientsMu.Unlock()
+
+	if a.tailscaleClient == nil {
+		return 0
+	}
+	return a.tailscaleClient.PollDuration()
+}
+
+// LastCycle returns the timings of the most recent update cycle, nil before the first one finished
+func (a *App) LastCycle() *CycleTimings {
+	a.timingsMu.Lock()
+	defer a.timingsMu.Unlock()
+
+	if a.lastCycle == nil {
+		return nil
+	}
+	timings := *a.lastCycle
+	return &timings
+}
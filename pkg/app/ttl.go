@@ -0,0 +1,91 @@
+package app
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/tailscale"
+	"k8s.io/klog/v2"
+)
+
+// ttlTagPrefix marks tags setting the TTL of a device's records, e.g. tag:ttl-60 or tag:ttl-5m
+const ttlTagPrefix = "tag:ttl-"
+
+// recordTTL returns the TTL for a machine's records. In order of precedence it comes from bind.ttl_overrides by
+// hostname, bind.ttl_overrides by tag, a tag:ttl-<ttl> tag, the TTL requested by the device owner and bind.ttl. TTLs
+// from tags and device owners are clamped to bind.min_ttl and bind.max_ttl.
+func (a *App) recordTTL(machine tailscale.Machine) uint32 {
+	cfg := &a.config.Bind
+
+	// Keys are matched case-insensitively, the configuration file loader lowercases them
+	hostname := strings.Split(machine.Name, ".")[0]
+	var hostnameTTL, lowestTagTTL time.Duration
+	for key, ttl := range cfg.TTLOverrides {
+		if strings.EqualFold(key, hostname) {
+			hostnameTTL = ttl
+		}
+		// The lowest TTL wins when several tags of the device have an override
+		if slices.ContainsFunc(machine.Tags, func(tag string) bool { return strings.EqualFold(key, tag) }) &&
+			(lowestTagTTL == 0 || ttl < lowestTagTTL) {
+			lowestTagTTL = ttl
+		}
+	}
+	if hostnameTTL > 0 {
+		return uint32(hostnameTTL.Seconds())
+	}
+	if lowestTagTTL > 0 {
+		return uint32(lowestTagTTL.Seconds())
+	}
+
+	if ttl, ok := tagTTL(machine); ok {
+		return a.clampTTL(machine, ttl)
+	}
+	if machine.DNSTTL > 0 {
+		return a.clampTTL(machine, machine.DNSTTL)
+	}
+	return uint32(cfg.TTL.Seconds())
+}
+
+// tagTTL returns the TTL set by a tag:ttl-<ttl> tag of a machine, in seconds or as a duration such as 5m. The lowest
+// wins when there are several.
+func tagTTL(machine tailscale.Machine) (time.Duration, bool) {
+	var lowest time.Duration
+	for _, tag := range machine.Tags {
+		value, ok := strings.CutPrefix(tag, ttlTagPrefix)
+		if !ok {
+			continue
+		}
+
+		ttl, err := time.ParseDuration(value)
+		if seconds, atoiErr := strconv.Atoi(value); atoiErr == nil {
+			ttl, err = time.Duration(seconds)*time.Second, nil
+		}
+		if err != nil || ttl < time.Second {
+			klog.Warningf("Ignoring tag %s of %s (%s): not a valid TTL", tag, machine.Name, machine.ID)
+			continue
+		}
+		if lowest == 0 || ttl < lowest {
+			lowest = ttl
+		}
+	}
+	return lowest, lowest > 0
+}
+
+// clampTTL bounds a TTL requested by a tag or the device owner to bind.min_ttl and bind.max_ttl
+func (a *App) clampTTL(machine tailscale.Machine, ttl time.Duration) uint32 {
+	cfg := &a.config.Bind
+	clamped := ttl
+	if cfg.MinTTL > 0 && clamped < cfg.MinTTL {
+		clamped = cfg.MinTTL
+	}
+	if cfg.MaxTTL > 0 && clamped > cfg.MaxTTL {
+		clamped = cfg.MaxTTL
+	}
+	if clamped != ttl {
+		klog.V(1).Infof("TTL %v of %s (%s) is outside the allowed range, using %v", ttl, machine.Name, machine.ID,
+			clamped)
+	}
+	return uint32(clamped.Seconds())
+}
@@ -0,0 +1,52 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/webhook"
+	"k8s.io/klog/v2"
+)
+
+// Tailscale webhooks notify the daemon as soon as devices are created, deleted or approved, so that their records are
+// published with an immediate poll rather than with the next one. Regular polls still run, they catch every change
+// webhooks don't report, such as devices going offline.
+
+// WebhookPath is where the webhook receiver accepts Tailscale webhook events
+const WebhookPath = "/webhook"
+
+// RequestPoll asks the poller to poll straight away. Requests made while one is pending are coalesced into it.
+func (a *App) RequestPoll() {
+	select {
+	case a.pollNow <- struct{}{}:
+	default:
+	}
+}
+
+// serveWebhook receives Tailscale webhook events on the listener until the context is cancelled
+func (a *App) serveWebhook(ctx context.Context, listener net.Listener) {
+	mux := http.NewServeMux()
+	handler := webhook.NewHandler(a.config.Webhook.Secret, a.RequestPoll)
+	handler.SetJoinHook(a.observeJoin)
+	mux.Handle(WebhookPath, handler)
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: statusReadHeaderTimeout,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), statusShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			klog.Errorf("Failed to shut down webhook receiver: %v", err)
+		}
+	}()
+
+	klog.Infof("Receiving Tailscale webhooks on %s%s", listener.Addr(), WebhookPath)
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		klog.Errorf("Webhook receiver failed: %v", err)
+	}
+}
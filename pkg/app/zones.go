@@ -0,0 +1,347 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/aauren/tailscale-bind-ddns/pkg/tailscale"
+	"k8s.io/klog/v2"
+)
+
+// Machines can be split across several forward zones: every zone in bind.zones has a selector and its own TSIG key. The
+// records of a machine carry the zone its selector picked (see zoneSelector) and zoneRouter hands them to that zone's
+// client. Records without a zone, and all PTR records, go to the client of the main zone, which also owns the reverse
+// zones.
+
+// zoneSelector decides whether a machine is published in an additional forward zone
+type zoneSelector struct {
+	zone      string
+	tags      []string
+	hostnames []*regexp.Regexp
+	users     []string
+}
+
+// newZoneSelectors compiles the selectors of the additional forward zones
+func newZoneSelectors(zones []config.ZoneConfig) ([]*zoneSelector, error) {
+	selectors := make([]*zoneSelector, 0, len(zones))
+	for _, zone := range zones {
+		selector := &zoneSelector{zone: zone.Name, tags: zone.Tags, users: zone.Users}
+		for _, pattern := range zone.Hostnames {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("compiling zone %s hostnames pattern %q: %w", zone.Name, pattern, err)
+			}
+			selector.hostnames = append(selector.hostnames, re)
+		}
+		selectors = append(selectors, selector)
+	}
+	return selectors, nil
+}
+
+// matches reports whether a machine carries one of the selector's tags, has a matching hostname or belongs to one of
+// its users
+func (s *zoneSelector) matches(machine tailscale.Machine) bool {
+	return s.match(machine) != ""
+}
+
+// match describes why a machine matches the selector, e.g. "tag tag:server", or returns an empty string when it doesn't
+func (s *zoneSelector) match(machine tailscale.Machine) string {
+	for _, tag := range machine.Tags {
+		if slices.Contains(s.tags, tag) {
+			return "tag " + tag
+		}
+	}
+	hostname, _, _ := strings.Cut(machine.Name, ".")
+	for _, re := range s.hostnames {
+		if re.MatchString(hostname) {
+			return "hostname pattern " + re.String()
+		}
+	}
+	if machine.User != "" && slices.ContainsFunc(s.users, func(user string) bool {
+		return strings.EqualFold(user, machine.User)
+	}) {
+		return "user " + machine.User
+	}
+	return ""
+}
+
+// machineZone returns the additional zone a machine is published in, or an empty string for the main zone
+func (a *App) machineZone(machine tailscale.Machine) string {
+	zone, _ := a.explainZone(machine)
+	return zone
+}
+
+// explainZone returns the additional zone a machine is published in and why, or empty strings for the main zone
+func (a *App) explainZone(machine tailscale.Machine) (string, string) {
+	for _, selector := range a.zones {
+		if reason := selector.match(machine); reason != "" {
+			return selector.zone, reason
+		}
+	}
+	return "", ""
+}
+
+// zoneFQDN returns the fully qualified name of a record name in a machine's zone
+func (a *App) zoneFQDN(recordName, zone string) string {
+	if zone == "" {
+		zone = a.config.Bind.Zone
+	}
+	return recordName + "." + zone
+}
+
+// zoneRouter is the bind provider for configurations with additional forward zones. The embedded client of the main
+// zone provides everything that isn't zone specific, such as PTR bootstrapping and consistency checks.
+type zoneRouter struct {
+	*bind.Client
+	zones map[string]*bind.Client
+}
+
+// newBindProvider returns the bind client of the main zone, wrapped in a zoneRouter when additional zones are
+// configured
+func newBindProvider(cfg *config.Config) (Provider, error) {
+	main, err := bind.NewClientFromConfig(&cfg.Bind)
+	if err != nil {
+		return nil, err
+	}
+	main.SetLease(cfg.Lease())
+	if len(cfg.Bind.Zones) == 0 {
+		return main, nil
+	}
+
+	router := &zoneRouter{Client: main, zones: make(map[string]*bind.Client, len(cfg.Bind.Zones))}
+	for _, zone := range cfg.Bind.Zones {
+		zoneCfg := cfg.Bind
+		zoneCfg.Zone = zone.Name
+		zoneCfg.KeyName = zone.KeyName
+		zoneCfg.KeySecret = zone.KeySecret
+		if zone.KeySecret != "" {
+			zoneCfg.KeyCommand = ""
+		}
+		if zone.Algorithm != "" {
+			zoneCfg.Algorithm = zone.Algorithm
+		}
+		// Reverse zones are only ever updated by the main zone's client
+		zoneCfg.PTR = config.PTRConfig{}
+		// Every zone's client records its records in the main zone's state file
+		zoneCfg.StateFile = ""
+
+		client, err := bind.NewClientFromConfig(&zoneCfg)
+		if err != nil {
+			return nil, fmt.Errorf("creating client for zone %s: %w", zone.Name, err)
+		}
+		client.ShareState(main)
+		client.SetLease(cfg.Lease())
+		router.zones[zone.Name] = client
+	}
+	return router, nil
+}
+
+// ValidateConnection checks the connection of every zone's client
+func (r *zoneRouter) ValidateConnection(ctx context.Context) error {
+	if err := r.Client.ValidateConnection(ctx); err != nil {
+		return err
+	}
+	for _, zone := range slices.Sorted(maps.Keys(r.zones)) {
+		if err := r.zones[zone].ValidateConnection(ctx); err != nil {
+			return fmt.Errorf("zone %s: %w", zone, err)
+		}
+	}
+	return nil
+}
+
+// UpdateRecords hands every record to the client of its zone. The additional zones are updated first, so that PTR
+// records in the reverse zones are only published once the names they point at exist.
+func (r *zoneRouter) UpdateRecords(
+	ctx context.Context,
+	records []bind.DNSRecord,
+	dryRun bool,
+) (*bind.SyncResult, error) {
+	clk := r.Clock()
+	result := &bind.SyncResult{Started: clk.Now(), DryRun: dryRun}
+	defer func() { result.Duration = clk.Since(result.Started) }()
+
+	byZone, main := r.splitRecords(records)
+
+	var errs []error
+	merge := func(zoneResult *bind.SyncResult, err error) {
+		if zoneResult != nil {
+			result.Zones = append(result.Zones, zoneResult.Zones...)
+			result.Skipped = append(result.Skipped, zoneResult.Skipped...)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Every zone is updated even without records, so that records of machines that moved away are removed
+	for _, zone := range slices.Sorted(maps.Keys(r.zones)) {
+		merge(r.zones[zone].UpdateRecords(ctx, byZone[zone], dryRun))
+	}
+	merge(r.Client.UpdateRecords(ctx, main, dryRun))
+
+	return result, errors.Join(errs...)
+}
+
+// ServerRecords reads the records the server holds in every zone, handing the desired records to the client of their
+// zone like UpdateRecords
+func (r *zoneRouter) ServerRecords(ctx context.Context, records []bind.DNSRecord) ([]bind.ZoneContents, error) {
+	byZone, main := r.splitRecords(records)
+
+	contents, err := r.Client.ServerRecords(ctx, main)
+	if err != nil {
+		return contents, err
+	}
+	for _, zone := range slices.Sorted(maps.Keys(r.zones)) {
+		zoneContents, err := r.zones[zone].ServerRecords(ctx, byZone[zone])
+		contents = append(contents, zoneContents...)
+		if err != nil {
+			return contents, fmt.Errorf("zone %s: %w", zone, err)
+		}
+	}
+	return contents, nil
+}
+
+// BackupZones backs up the records of every zone, handing the desired records to the client of their zone like
+// UpdateRecords
+func (r *zoneRouter) BackupZones(ctx context.Context, records []bind.DNSRecord) ([]bind.ZoneBackup, error) {
+	byZone, main := r.splitRecords(records)
+
+	backups, err := r.Client.BackupZones(ctx, main)
+	if err != nil {
+		return backups, err
+	}
+	for _, zone := range slices.Sorted(maps.Keys(r.zones)) {
+		zoneBackups, err := r.zones[zone].BackupZones(ctx, byZone[zone])
+		backups = append(backups, zoneBackups...)
+		if err != nil {
+			return backups, fmt.Errorf("zone %s: %w", zone, err)
+		}
+	}
+	return backups, nil
+}
+
+// RestoreZones hands every backed up zone to the client managing it. The additional zones are restored first, like
+// they are updated first.
+func (r *zoneRouter) RestoreZones(ctx context.Context, zones []bind.ZoneBackup, dryRun bool) (int, error) {
+	byZone := make(map[string][]bind.ZoneBackup, len(r.zones))
+	var main []bind.ZoneBackup
+	for _, zone := range zones {
+		if _, ok := r.zones[zone.Zone]; ok {
+			byZone[zone.Zone] = append(byZone[zone.Zone], zone)
+		} else {
+			main = append(main, zone)
+		}
+	}
+
+	sent := 0
+	for _, zone := range slices.Sorted(maps.Keys(byZone)) {
+		zoneSent, err := r.zones[zone].RestoreZones(ctx, byZone[zone], dryRun)
+		sent += zoneSent
+		if err != nil {
+			return sent, fmt.Errorf("zone %s: %w", zone, err)
+		}
+	}
+	mainSent, err := r.Client.RestoreZones(ctx, main, dryRun)
+	return sent + mainSent, err
+}
+
+// RemoveAll removes every record published to the main zone and the additional zones, continuing past zones that fail
+func (r *zoneRouter) RemoveAll(ctx context.Context, dryRun bool) (int, error) {
+	removed, err := r.Client.RemoveAll(ctx, dryRun)
+	errs := []error{err}
+	for _, zone := range slices.Sorted(maps.Keys(r.zones)) {
+		zoneRemoved, err := r.zones[zone].RemoveAll(ctx, dryRun)
+		removed += zoneRemoved
+		if err != nil {
+			errs = append(errs, fmt.Errorf("zone %s: %w", zone, err))
+		}
+	}
+	return removed, errors.Join(errs...)
+}
+
+// ExpireLeases removes the records with an expired lease from the main zone and the additional zones, continuing past
+// zones that fail
+func (r *zoneRouter) ExpireLeases(ctx context.Context, dryRun bool) (int, error) {
+	removed, err := r.Client.ExpireLeases(ctx, dryRun)
+	errs := []error{err}
+	for _, zone := range slices.Sorted(maps.Keys(r.zones)) {
+		zoneRemoved, err := r.zones[zone].ExpireLeases(ctx, dryRun)
+		removed += zoneRemoved
+		if err != nil {
+			errs = append(errs, fmt.Errorf("zone %s: %w", zone, err))
+		}
+	}
+	return removed, errors.Join(errs...)
+}
+
+// splitRecords groups the records of the additional zones by zone, returning the records of the main zone's client
+// separately
+func (r *zoneRouter) splitRecords(records []bind.DNSRecord) (map[string][]bind.DNSRecord, []bind.DNSRecord) {
+	byZone := make(map[string][]bind.DNSRecord, len(r.zones))
+	var main []bind.DNSRecord
+	for _, record := range records {
+		if _, ok := r.zones[record.Zone]; ok && record.Type != bind.TypePTR {
+			byZone[record.Zone] = append(byZone[record.Zone], record)
+		} else {
+			main = append(main, record)
+		}
+	}
+	return byZone, main
+}
+
+// SetClock replaces the clock of every zone's client
+func (r *zoneRouter) SetClock(clk clock.Clock) {
+	r.Client.SetClock(clk)
+	for _, client := range r.zones {
+		client.SetClock(clk)
+	}
+}
+
+// SetDeviceLookup sets the device lookup of every zone's client
+func (r *zoneRouter) SetDeviceLookup(lookup bind.DeviceLookup) {
+	r.Client.SetDeviceLookup(lookup)
+	for _, client := range r.zones {
+		client.SetDeviceLookup(lookup)
+	}
+}
+
+// PrefetchZones reads the zones of every zone's client ahead of the next update
+func (r *zoneRouter) PrefetchZones(ctx context.Context) {
+	r.Client.PrefetchZones(ctx)
+	for _, client := range r.zones {
+		client.PrefetchZones(ctx)
+	}
+}
+
+// StartUpdating publishes record sets received on recordChan until the context is cancelled
+func (r *zoneRouter) StartUpdating(
+	ctx context.Context,
+	updateInterval time.Duration,
+	recordChan <-chan []bind.DNSRecord,
+	dryRun bool,
+) {
+	klog.Infof("Starting DDNS updates of %d zones with interval %v", len(r.zones)+1, updateInterval)
+	// The main zone's client keeps the queue of record sets that failed, which cover every zone
+	r.Client.ProcessUpdates(ctx, recordChan, dryRun, r.UpdateRecords)
+	klog.Info("DDNS updating stopped")
+}
+
+// ZoneStatuses returns the per-zone outcome of the most recent updates across every zone's client
+func (r *zoneRouter) ZoneStatuses() map[string]bind.ZoneStatus {
+	statuses := r.Client.ZoneStatuses()
+	for _, client := range r.zones {
+		for zone, status := range client.ZoneStatuses() {
+			statuses[zone] = status
+		}
+	}
+	return statuses
+}
@@ -0,0 +1,154 @@
+package app
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/aauren/tailscale-bind-ddns/pkg/tailscale"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTSIGSecret is a base64 encoded secret usable by both the test server and client
+const testTSIGSecret = "dGVzdC1zZWNyZXQtZm9yLXVuaXQtdGVzdHM="
+
+// startZoneTestServer starts a UDP DNS server on localhost that accepts every update signed with one of keys and
+// reports it as zone -> key name on the returned channel
+func startZoneTestServer(t *testing.T, keys ...string) (string, int, <-chan [2]string) {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	secrets := make(map[string]string, len(keys))
+	for _, key := range keys {
+		secrets[key] = testTSIGSecret
+	}
+
+	updates := make(chan [2]string, 10)
+	started := make(chan struct{})
+	server := &dns.Server{
+		PacketConn: pc,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			m := new(dns.Msg)
+			m.SetReply(r)
+			if r.Opcode == dns.OpcodeUpdate {
+				updates <- [2]string{r.Question[0].Name, r.IsTsig().Hdr.Name}
+			}
+			if tsig := r.IsTsig(); tsig != nil {
+				m.SetTsig(tsig.Hdr.Name, tsig.Algorithm, 300, time.Now().Unix())
+			}
+			_ = w.WriteMsg(m)
+		}),
+		TsigSecret:        secrets,
+		UDPSize:           dns.DefaultMsgSize,
+		MsgAcceptFunc:     func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go func() {
+		_ = server.ActivateAndServe()
+	}()
+	<-started
+	t.Cleanup(func() {
+		_ = server.Shutdown()
+	})
+
+	addr := pc.LocalAddr().(*net.UDPAddr)
+	return addr.IP.String(), addr.Port, updates
+}
+
+func TestZoneSelectors(t *testing.T) {
+	app, err := NewApp(&config.Config{
+		Bind: config.BindConfig{
+			Zone: "ts.example.com",
+			TTL:  300 * time.Second,
+			Zones: []config.ZoneConfig{
+				{Name: "servers.example.com", Tags: []string{"tag:server"}, Hostnames: []string{"^db-"}},
+				{Name: "alice.example.com", Users: []string{"alice@example.com"}},
+			},
+			PTR: config.PTRConfig{Enabled: true, IPv4Subnet: "100.64.0.0/10", IPv4SubnetSize: 16},
+		},
+	})
+	require.NoError(t, err)
+
+	machines := []tailscale.Machine{
+		{ID: "n1", Name: "web-1", IPv4Address: "100.64.0.1", Online: true, Tags: []string{"tag:server"}},
+		{ID: "n2", Name: "db-1", IPv4Address: "100.64.0.2", Online: true, User: "alice@example.com"},
+		{ID: "n3", Name: "laptop", IPv4Address: "100.64.0.3", Online: true, User: "Alice@example.com"},
+		{ID: "n4", Name: "phone", IPv4Address: "100.64.0.4", Online: true, User: "bob@example.com"},
+	}
+
+	// The first matching zone wins, machines no zone selects stay in the main zone
+	assert.Equal(t, []bind.DNSRecord{
+		{Name: "web-1", Value: "100.64.0.1", TTL: 300, Type: "A", Zone: "servers.example.com"},
+		{Name: "db-1", Value: "100.64.0.2", TTL: 300, Type: "A", Zone: "servers.example.com"},
+		{Name: "laptop", Value: "100.64.0.3", TTL: 300, Type: "A", Zone: "alice.example.com"},
+		{Name: "phone", Value: "100.64.0.4", TTL: 300, Type: "A"},
+		{Name: "1.0.64.100.in-addr.arpa.", Value: "web-1.servers.example.com", TTL: 300, Type: "PTR"},
+		{Name: "2.0.64.100.in-addr.arpa.", Value: "db-1.servers.example.com", TTL: 300, Type: "PTR"},
+		{Name: "3.0.64.100.in-addr.arpa.", Value: "laptop.alice.example.com", TTL: 300, Type: "PTR"},
+		{Name: "4.0.64.100.in-addr.arpa.", Value: "phone.ts.example.com", TTL: 300, Type: "PTR"},
+	}, app.buildRecords(machines))
+}
+
+func TestZoneRouter(t *testing.T) {
+	host, port, updates := startZoneTestServer(t, "main-key.", "servers-key.")
+
+	cfg := &config.Config{
+		Bind: config.BindConfig{
+			Server:      host,
+			Port:        port,
+			Zone:        "ts.example.com",
+			KeyName:     "main-key.",
+			KeySecret:   testTSIGSecret,
+			Algorithm:   "hmac-sha256",
+			TTL:         300 * time.Second,
+			RemoveStale: true,
+			Zones: []config.ZoneConfig{
+				{Name: "servers.example.com", KeyName: "servers-key.", KeySecret: testTSIGSecret, Tags: []string{"tag:server"}},
+			},
+			PTR: config.PTRConfig{Enabled: true, IPv4Subnet: "100.64.0.0/10", IPv4SubnetSize: 16},
+		},
+	}
+	provider, err := newBindProvider(cfg)
+	require.NoError(t, err)
+	require.IsType(t, &zoneRouter{}, provider)
+
+	records := []bind.DNSRecord{
+		{Name: "web-1", Value: "100.64.0.1", TTL: 300, Type: "A", Zone: "servers.example.com"},
+		{Name: "phone", Value: "100.64.0.4", TTL: 300, Type: "A"},
+		{Name: "1.0.64.100.in-addr.arpa.", Value: "web-1.servers.example.com", TTL: 300, Type: "PTR"},
+	}
+	result, err := provider.UpdateRecords(context.Background(), records, false)
+	require.NoError(t, err)
+
+	// Each zone is updated with its own key, the additional zones first
+	require.Len(t, updates, 3)
+	assert.Equal(t, [2]string{"servers.example.com.", "servers-key."}, <-updates)
+	assert.ElementsMatch(t, [][2]string{
+		{"ts.example.com.", "main-key."},
+		{"64.100.in-addr.arpa.", "main-key."},
+	}, [][2]string{<-updates, <-updates})
+
+	var zones []string
+	for _, zone := range result.Zones {
+		zones = append(zones, zone.Zone)
+	}
+	assert.ElementsMatch(t, []string{"servers.example.com", "ts.example.com", "64.100.in-addr.arpa"}, zones)
+	assert.Len(t, provider.(*zoneRouter).ZoneStatuses(), 3)
+
+	// A machine that lost its tag moves to the main zone and is removed from its old zone
+	records[0].Zone = ""
+	_, err = provider.UpdateRecords(context.Background(), records[:2], false)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, [][2]string{
+		{"servers.example.com.", "servers-key."},
+		{"ts.example.com.", "main-key."},
+		{"64.100.in-addr.arpa.", "main-key."},
+	}, [][2]string{<-updates, <-updates, <-updates})
+}
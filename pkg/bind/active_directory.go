@@ -0,0 +1,223 @@
+package bind
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// With bind.active_directory updates go to Active Directory integrated DNS, which accepts secure dynamic updates
+// signed with GSS-TSIG only. Every name in an Active Directory zone carries an ACL: a name created by a secure update
+// belongs to the principal that sent it, and other principals may not change it, e.g. a name a workstation registered
+// itself. The server refuses an update as a whole when any of its names is denied, so a refused update that spans
+// several names is sent again name by name: the names the principal may update are updated and the others are skipped
+// and tried again with every update, until an administrator grants access to them. An update refused without any
+// name getting through fails as usual, it points at the setup rather than at ACLs. Without bind.server the domain
+// controller updates are sent to is the primary name server of the SOA record of the domain, which every domain
+// controller of an integrated zone names itself in.
+
+// discoveryTimeout bounds the lookup of the domain controller when the client is created
+const discoveryTimeout = 30 * time.Second
+
+// resolvConf is the file the system resolvers are read from when discovering the domain controller
+var resolvConf = "/etc/resolv.conf"
+
+// discoverServer returns the domain controller named by the SOA record of an Active Directory domain, looked up
+// through the resolvers, host or host:port, or the system resolvers when there are none
+func discoverServer(ctx context.Context, domain string, resolvers []string) (string, error) {
+	if domain == "" {
+		return "", fmt.Errorf("no Active Directory domain to discover the server of, set bind.active_directory.domain")
+	}
+	if len(resolvers) == 0 {
+		conf, err := dns.ClientConfigFromFile(resolvConf)
+		if err != nil {
+			return "", fmt.Errorf("reading system resolvers, set bind.server or bind.verify_resolvers: %w", err)
+		}
+		port, err := strconv.Atoi(conf.Port)
+		if err != nil {
+			return "", fmt.Errorf("invalid resolver port %q in %s", conf.Port, resolvConf)
+		}
+		for _, address := range conf.Servers {
+			resolvers = append(resolvers, net.JoinHostPort(address, strconv.Itoa(port)))
+		}
+	}
+	resolver, err := NewRecursiveResolver(resolvers)
+	if err != nil {
+		return "", err
+	}
+
+	answers, err := resolver.Lookup(ctx, domain, dns.TypeSOA)
+	if err != nil {
+		return "", fmt.Errorf("discovering the domain controller of %s: %w", domain, err)
+	}
+	for _, rr := range answers {
+		if soa, ok := rr.(*dns.SOA); ok {
+			server := strings.TrimSuffix(soa.Ns, ".")
+			klog.Infof("Discovered domain controller %s from the SOA record of %s", server, domain)
+			return server, nil
+		}
+	}
+	return "", fmt.Errorf("discovering the domain controller of %s: the domain has no SOA record", domain)
+}
+
+// changeNames returns the owner names a zone change touches, sorted
+func changeNames(change zoneChange) []string {
+	names := make(map[string]bool)
+	for _, record := range slices.Concat(change.upserts, change.removals) {
+		names[recordFQDN(change.zone, record)] = true
+	}
+	return slices.Sorted(maps.Keys(names))
+}
+
+// forName returns the part of a zone change that touches a single owner name
+func (z zoneChange) forName(name string) zoneChange {
+	onName := func(records []DNSRecord) []DNSRecord {
+		return slices.DeleteFunc(slices.Clone(records), func(record DNSRecord) bool {
+			return recordFQDN(z.zone, record) != name
+		})
+	}
+	change := zoneChange{zone: z.zone, desired: onName(z.desired), upserts: onName(z.upserts),
+		removals: onName(z.removals)}
+	if z.previous != nil {
+		change.previous = make(map[RecordKey][]DNSRecord)
+		for key, records := range z.previous {
+			if len(records) > 0 && recordFQDN(z.zone, records[0]) == name {
+				change.previous[key] = records
+			}
+		}
+	}
+	return change
+}
+
+// sendByName sends a zone change that the server refused as a whole one owner name at a time, skipping the names it
+// refuses. Refused names are remembered with the records they held before, so that their upserts and removals are
+// tried again with the next update, and as denied, so that updates of nothing but denied names are skipped as well
+// rather than failing.
+func (c *Client) sendByName(
+	ctx context.Context,
+	change *zoneChange,
+	key *dns.TSIG,
+	secret string,
+	result *ZoneResult,
+) error {
+	names := changeNames(*change)
+	klog.Warningf("Server refused the update of zone %s, sending its %d names one by one", change.zone, len(names))
+
+	check, err := c.beginUpdateCheck(ctx, change.zone, change.desired)
+	if err != nil {
+		return err
+	}
+
+	var (
+		refused    = make(map[string]bool)
+		refusedErr error
+	)
+	for _, name := range names {
+		part := change.forName(name)
+		msg := c.zoneUpdate(part)
+		if c.ownerID != "" {
+			c.addOwnership(msg, part)
+		}
+		err := c.exchangeUpdate(ctx, change.zone, msg, key, secret)
+		switch {
+		case errors.Is(err, ErrRefused):
+			klog.Warningf("Not updating %s: the server refused it, its ACL doesn't let %s update it", name,
+				c.principal)
+			refused[name], refusedErr = true, err
+		case err != nil:
+			return err
+		}
+	}
+	// Names refused on their own since the server accepted others are denied by their ACL, not a broken setup
+	if len(refused) == len(names) && !c.deniedByACL(names) {
+		return refusedErr
+	}
+	c.markDenied(names, refused)
+
+	var upserts, removals []DNSRecord
+	desired := slices.DeleteFunc(slices.Clone(change.desired), func(record DNSRecord) bool {
+		return refused[recordFQDN(change.zone, record)]
+	})
+	for _, records := range change.previous {
+		if len(records) > 0 && refused[recordFQDN(change.zone, records[0])] {
+			desired = append(desired, records...)
+		}
+	}
+	for _, record := range change.upserts {
+		if !refused[recordFQDN(change.zone, record)] {
+			upserts = append(upserts, record)
+			continue
+		}
+		change.skipped = append(change.skipped, SkippedRecord{Record: record, Reason: SkipDeniedByACL})
+	}
+	for _, record := range change.removals {
+		if !refused[recordFQDN(change.zone, record)] {
+			removals = append(removals, record)
+			continue
+		}
+		change.skipped = append(change.skipped, SkippedRecord{Record: record, Reason: SkipDeniedByACL})
+		// Without previous records the removals were recovered from the state file and are still ours
+		if change.previous == nil {
+			desired = append(desired, record)
+		}
+	}
+	sortRecords(desired)
+	change.desired, change.upserts, change.removals = desired, upserts, removals
+	result.Rcode = dns.RcodeSuccess
+	result.Sent, result.Removed = len(upserts), len(removals)
+
+	if len(refused) < len(names) {
+		serial, err := c.finishUpdateCheck(ctx, check)
+		result.Serial = serial
+		if err != nil {
+			return err
+		}
+	}
+
+	c.markPublished(change.zone, change.desired)
+	if c.ownerID != "" {
+		c.markOwned(*change)
+	}
+	klog.V(1).Infof("Updated %d names of zone %s, %d were refused", len(names)-len(refused), change.zone,
+		len(refused))
+	return nil
+}
+
+// deniedByACL reports whether the server refused every one of the names before while accepting others
+func (c *Client) deniedByACL(names []string) bool {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	for _, name := range names {
+		if !c.aclDenied[name] {
+			return false
+		}
+	}
+	return len(names) > 0
+}
+
+// markDenied remembers which of the names of an update sent name by name the server refused
+func (c *Client) markDenied(names []string, refused map[string]bool) {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	if c.aclDenied == nil {
+		c.aclDenied = make(map[string]bool)
+	}
+	for _, name := range names {
+		if refused[name] {
+			c.aclDenied[name] = true
+		} else {
+			delete(c.aclDenied, name)
+		}
+	}
+}
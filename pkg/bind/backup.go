@@ -0,0 +1,169 @@
+package bind
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// A backup holds the managed records of every zone as the server held them, so that they can be re-applied with
+// dynamic updates after a risky change went wrong or the DNS server was rebuilt. Zones are read like list-records does:
+// transferred with AXFR when the server permits it, otherwise the names of the desired records are looked up. With an
+// owner ID only the names carrying our owner record are backed up, without one every A, AAAA, CNAME, TXT and PTR
+// record a transferred zone holds is.
+
+// BackupVersion is the version of the backup format written by this build
+const BackupVersion = 1
+
+// Backup is the content of a backup file
+type Backup struct {
+	Version int          `json:"version"`
+	Created time.Time    `json:"created"`
+	Zones   []ZoneBackup `json:"zones"`
+}
+
+// ZoneBackup holds the backed up records of a single zone
+type ZoneBackup struct {
+	Zone string `json:"zone"`
+	// Transferred is false when the server didn't permit transferring the zone, so that only the record sets of the
+	// desired records were backed up
+	Transferred bool        `json:"transferred"`
+	Records     []DNSRecord `json:"records"`
+}
+
+// ParseBackup parses a backup file, rejecting backups written in a newer format
+func ParseBackup(data []byte) (*Backup, error) {
+	var backup Backup
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return nil, fmt.Errorf("parsing backup: %w", err)
+	}
+	if backup.Version < 1 || backup.Version > BackupVersion {
+		return nil, fmt.Errorf("unsupported backup version %d (supported: 1 to %d)", backup.Version, BackupVersion)
+	}
+	return &backup, nil
+}
+
+// BackupZones reads the managed records of the client's zones from the server. desired are the records the machines
+// would currently be published as, whose names are looked up in zones that can't be transferred.
+func (c *Client) BackupZones(ctx context.Context, desired []DNSRecord) ([]ZoneBackup, error) {
+	reader := &zoneReader{c: c, transfer: true, zones: make(map[string]*zoneSnapshot)}
+	contents, err := c.serverRecords(ctx, reader, desired)
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]ZoneBackup, 0, len(contents))
+	for _, zone := range contents {
+		records := zone.Records
+		if c.ownerID != "" {
+			if records, err = c.ownedRecords(ctx, reader, zone.Zone, records); err != nil {
+				return backups, err
+			}
+		}
+		klog.V(1).Infof("Backed up %d records of zone %s", len(records), zone.Zone)
+		backups = append(backups, ZoneBackup{Zone: zone.Zone, Transferred: zone.Transferred, Records: records})
+	}
+	return backups, nil
+}
+
+// ownedRecords returns the records whose names carry our owner record
+func (c *Client) ownedRecords(
+	ctx context.Context,
+	reader *zoneReader,
+	zone string,
+	records []DNSRecord,
+) ([]DNSRecord, error) {
+	owned := make(map[string]bool)
+	var kept []DNSRecord
+	for _, record := range records {
+		name := recordFQDN(zone, record)
+		ours, ok := owned[name]
+		if !ok {
+			state, err := c.nameOwnership(ctx, reader, name, nil)
+			if err != nil {
+				return nil, fmt.Errorf("looking up owner of %s: %w", name, err)
+			}
+			ours = state == ownershipOurs
+			owned[name] = ours
+		}
+		if ours {
+			kept = append(kept, record)
+		}
+	}
+	return kept, nil
+}
+
+// RestoreZones re-applies the records of backed up zones with dynamic updates, replacing the record sets they belong
+// to. Record sets the server already holds as backed up are left alone, as are records the update policy doesn't allow
+// and names the ownership registry doesn't let us write. Zones that aren't managed by this client are skipped. The
+// restored records are not tracked as published, the next update takes over from the records it finds. It returns the
+// number of records sent.
+func (c *Client) RestoreZones(ctx context.Context, zones []ZoneBackup, dryRun bool) (int, error) {
+	recordsByZone := make(map[string][]DNSRecord)
+	for _, zone := range zones {
+		for _, record := range zone.Records {
+			if dns.CanonicalName(c.recordZone(record)) != dns.CanonicalName(zone.Zone) {
+				klog.Warningf("Not restoring %s record %s: zone %s isn't managed by this client", record.Key().Type,
+					recordFQDN(zone.Zone, record), zone.Zone)
+				continue
+			}
+			recordsByZone[c.recordZone(record)] = append(recordsByZone[c.recordZone(record)], record)
+		}
+	}
+
+	key, secret, err := c.signingKey()
+	if err != nil {
+		return 0, fmt.Errorf("creating TSIG key: %w", err)
+	}
+
+	c.updateMu.Lock()
+	defer c.updateMu.Unlock()
+
+	sent := 0
+	reader := c.newZoneReader()
+	for _, zone := range slices.Sorted(maps.Keys(recordsByZone)) {
+		records := recordsByZone[zone]
+		change := zoneChange{zone: zone, desired: records, upserts: records}
+		c.filterPolicy(&change)
+		if c.ownerID != "" {
+			if err := c.filterOwned(ctx, reader, &change); err != nil {
+				return sent, fmt.Errorf("restoring zone %s: %w", zone, err)
+			}
+		}
+		if err := c.skipApplied(ctx, reader, &change); err != nil {
+			return sent, fmt.Errorf("restoring zone %s: %w", zone, err)
+		}
+		if change.empty() {
+			klog.Infof("Zone %s already holds all %d backed up records", zone, len(records))
+			continue
+		}
+
+		if dryRun {
+			for _, record := range change.upserts {
+				klog.Infof("DRY RUN: Would restore %s record %s -> %s (TTL: %d)", record.Key().Type,
+					recordFQDN(zone, record), record.Value, record.TTL)
+			}
+			continue
+		}
+
+		msg := c.zoneUpdate(change)
+		if c.ownerID != "" {
+			c.addOwnership(msg, change)
+		}
+		if err := c.exchangeUpdate(ctx, zone, msg, key, secret); err != nil {
+			return sent, fmt.Errorf("restoring %d records in zone %s: %w", len(change.upserts), zone, err)
+		}
+		if c.ownerID != "" {
+			c.markOwned(change)
+		}
+		klog.Infof("Restored %d records in zone %s", len(change.upserts), zone)
+		sent += len(change.upserts)
+	}
+	return sent, nil
+}
@@ -0,0 +1,99 @@
+package bind
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// The PTR bootstrap back-populates reverse zones for users that adopt the tool with an already populated forward zone.
+// It publishes PTR records for every device of the tailnet, not only the online ones, but only at reverse names that
+// don't hold a PTR record yet, so that it is a no-op on every start after the first.
+
+// BootstrapPTR publishes the PTR records among records whose names hold no PTR record on the server yet. The records
+// are not tracked as published, so they are never removed as stale. It returns the number of records created.
+func (c *Client) BootstrapPTR(ctx context.Context, records []DNSRecord, dryRun bool) (int, error) {
+	var ptrs []DNSRecord
+	for _, record := range records {
+		if record.Type == TypePTR {
+			ptrs = append(ptrs, record)
+		}
+	}
+
+	missing, err := c.missingPTRs(ctx, c.newZoneReader(), ptrs)
+	if err != nil {
+		return 0, err
+	}
+	if len(missing) == 0 {
+		klog.V(1).Infof("Reverse zones already hold PTR records for all %d addresses, nothing to bootstrap", len(ptrs))
+		return 0, nil
+	}
+
+	if dryRun {
+		for _, record := range missing {
+			klog.Infof("DRY RUN: Would bootstrap PTR record %s -> %s", record.Name, record.Value)
+		}
+		return 0, nil
+	}
+
+	key, secret, err := c.signingKey()
+	if err != nil {
+		return 0, fmt.Errorf("creating TSIG key: %w", err)
+	}
+
+	c.updateMu.Lock()
+	defer c.updateMu.Unlock()
+
+	created := 0
+	recordsByZone := c.groupRecordsByZone(missing)
+	for _, zone := range slices.Sorted(maps.Keys(recordsByZone)) {
+		zoneRecords := c.allowedByPolicy(zone, recordsByZone[zone])
+		if len(zoneRecords) == 0 {
+			continue
+		}
+		msg := c.zoneUpdate(zoneChange{zone: zone, upserts: zoneRecords})
+		// The update only applies while the names are still empty, so that PTR records published in the meantime by
+		// hand or by another instance are never replaced
+		for _, record := range zoneRecords {
+			msg.RRsetNotUsed([]dns.RR{&dns.PTR{Hdr: dns.RR_Header{Name: dns.Fqdn(record.Name), Rrtype: dns.TypePTR}}})
+		}
+		change := zoneChange{zone: zone, desired: zoneRecords, upserts: zoneRecords}
+		if c.ownerID != "" {
+			c.addOwnership(msg, change)
+		}
+
+		if err := c.exchangeUpdate(ctx, zone, msg, key, secret); err != nil {
+			return created, fmt.Errorf("bootstrapping %d PTR records in zone %s: %w", len(zoneRecords), zone, err)
+		}
+		if c.ownerID != "" {
+			c.markOwned(change)
+		}
+		klog.Infof("Bootstrapped %d PTR records in zone %s", len(zoneRecords), zone)
+		created += len(zoneRecords)
+	}
+	return created, nil
+}
+
+// missingPTRs returns the PTR records whose names hold no PTR record on the server
+func (c *Client) missingPTRs(ctx context.Context, reader *zoneReader, records []DNSRecord) ([]DNSRecord, error) {
+	var missing []DNSRecord
+	for _, record := range records {
+		if c.recordZone(record) == "" {
+			klog.V(1).Infof("Not bootstrapping PTR record %s: not in any configured zone", record.Name)
+			continue
+		}
+
+		answers, err := reader.lookup(ctx, record.Name, dns.TypePTR)
+		if err != nil {
+			return nil, fmt.Errorf("looking up PTR record %s: %w", record.Name, err)
+		}
+		if !slices.ContainsFunc(answers, func(rr dns.RR) bool { return rr.Header().Rrtype == dns.TypePTR }) {
+			missing = append(missing, record)
+		}
+	}
+	return missing, nil
+}
@@ -2,11 +2,21 @@ package bind
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"maps"
 	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
 	"github.com/aauren/tailscale-bind-ddns/pkg/config"
 	"github.com/miekg/dns"
 	"k8s.io/klog/v2"
@@ -33,26 +43,132 @@ const (
 	IPv6NibbleShift    = 4    // Nibble shift amount
 )
 
-// Client represents a Bind DDNS client
+// probeRecordName is the owner name, relative to the zone, used by update probes. Probes only ever delete this name.
+const probeRecordName = "_tailscale-bind-ddns-probe"
+
+// Client represents a Bind DDNS client. A Client is safe for concurrent use by multiple goroutines: the TSIG key and
+// the per-zone state are guarded by their own mutexes, and updates are serialized so that every update is computed
+// against the outcome of the one before it. The remaining fields are set on construction, or by SetClock and
+// SetResultHook before StartUpdating, and never modified afterwards.
 type Client struct {
-	server    string
-	port      int
-	zone      string
+	server string
+	port   int
+	zone   string
+	ttl    uint32
+
+	// TSIG key, which may be swapped at runtime by SetTSIGKey
+	keyMu     sync.RWMutex
 	keyName   string
 	keySecret string
 	algorithm string
-	ttl       uint32
+	// Computes the MACs instead of keySecret with bind.key_command, see signer.go, or negotiates the keys with
+	// GSS-TSIG, see gss.go, logged in as principal
+	signer    dns.TsigProvider
+	principal string
 
 	// PTR configuration
 	ptrConfig *config.PTRConfig
+	// Reverse zones whose records moved to finer zones, see sharding.go
+	shards atomic.Pointer[map[string]bool]
+
+	// Update verification, see verify.go, and the resolver consistency checks look names up through, see resolver.go
+	verifySerial     bool
+	statisticsURL    string
+	statisticsClient *http.Client
+	resolver         Resolver
+
+	// Whether records this client published that are no longer desired get removed, and how long they are kept
+	// otherwise, see reconcile.go, and how long published records are kept without being refreshed, see lease.go
+	removeStale  bool
+	maxRecordAge time.Duration
+	lease        time.Duration
+
+	// Adjustments of the update message shape for servers other than BIND, see quirks.go
+	strictRRsetRemoval bool
+	usePrerequisites   bool
+	// Whether refused updates are retried name by name for Active Directory, see active_directory.go
+	activeDirectory bool
+
+	// Protocol used to reach the server and the TLS settings used with tcp-tls, see transport.go
+	transport string
+	tlsConfig *tls.Config
+
+	// Servers tried when the primary server is unreachable and whether updates go to every server, see servers.go
+	fallbacks        []server
+	updateAllServers bool
+
+	// Whether records are looked up before they are sent, skipping those the server already holds, and how far their
+	// TTL on the server may differ from the desired one, see reconcile.go
+	queryBeforeUpdate bool
+	ttlTolerance      time.Duration
+
+	// Whether record sets are read from zone transfers rather than looked up name by name, see transfer.go
+	zoneTransfer bool
+
+	// Grant and deny rules of the server's update-policy that records are checked against before sending, see
+	// policy.go
+	updatePolicy []policyRule
+
+	// Owner ID published next to every managed name and the names known to carry it, see registry.go
+	ownerID string
+	owned   map[string]bool
+
+	// File the record set of a failed update is kept in until it was published, see queue.go
+	queueFile string
+	// Policy failed updates are retried with, see queue.go
+	retry config.RetryConfig
+	// State file the published records are recorded in, nil without one, see state.go
+	state *stateStore
+
+	// Order and parallelism of the updates sent to the individual zones, see ordering.go
+	zoneOrder       string
+	zoneConcurrency int
+
+	// Clock of update timings, retry backoff and server health, the real clock when nil
+	clock clock.Clock
+
+	// Called with the outcome of every update of ProcessUpdates, see SetResultHook
+	resultHook func(*SyncResult)
+
+	// updateMu is held while records are sent to the server, by UpdateRecords and by consistency repairs
+	updateMu sync.Mutex
+
+	// Per-zone outcome of the most recent update attempts, the records of the last confirmed update and when each of
+	// their record sets was last desired, the records recovered from the state file and the lookup of their devices,
+	// the health of every server, the zone contents of the most recent transfers, which later ones are incremental to,
+	// the transfers made ahead of the next update, see prefetch.go, and the names Active Directory refused
+	statusMu   sync.Mutex
+	zoneStatus map[string]ZoneStatus
+	published  map[string][]DNSRecord
+	refreshed  map[string]map[RecordKey]time.Time
+	recovered  map[string][]DNSRecord
+	devices    DeviceLookup
+	health     map[string]ServerHealth
+	transfers  map[string]*zoneSnapshot
+	prefetched map[string]prefetchedZone
+	aclDenied  map[string]bool
 }
 
-// DNSRecord represents a DNS record (A or PTR)
+// ZoneStatus describes the outcome of the most recent update sent to a zone
+type ZoneStatus struct {
+	Records     int       `json:"records"`
+	LastAttempt time.Time `json:"last_attempt"`
+	LastSuccess time.Time `json:"last_success,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+	Failures    int       `json:"consecutive_failures"`
+	Serial      uint32    `json:"serial,omitempty"`
+}
+
+// DNSRecord represents a DNS record (A, AAAA, CNAME, TXT or PTR)
 type DNSRecord struct {
 	Name  string
 	Value string
 	TTL   uint32
-	Type  string // "A" or "PTR"
+	Type  RecordType
+
+	// Zone is the forward zone an A/AAAA/CNAME/TXT record is published in when it isn't the client's zone, used to route
+	// records to the client of their zone
+	Zone string
 }
 
 // NewClient creates a new Bind DDNS client
@@ -76,170 +192,530 @@ func NewClient(
 		return nil, fmt.Errorf("key secret is required")
 	}
 
+	client, err := newClient(server, port, zone, keyName, algorithm, ttl, ptrConfig)
+	if err != nil {
+		return nil, err
+	}
+	client.keySecret = keySecret
+	return client, nil
+}
+
+// newClient creates a client without a TSIG secret, which either gets one or a signer
+func newClient(
+	server string,
+	port int,
+	zone, keyName, algorithm string,
+	ttl time.Duration,
+	ptrConfig *config.PTRConfig,
+) (*Client, error) {
+	server, port, err := parseServerAddress(server, port)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Client{
 		server:    server,
 		port:      port,
 		zone:      zone,
 		keyName:   keyName,
-		keySecret: keySecret,
 		algorithm: algorithm,
 		ttl:       uint32(ttl.Seconds()),
 		ptrConfig: ptrConfig,
 	}, nil
 }
 
-// UpdateRecords updates DNS records for the given machines
-func (c *Client) UpdateRecords(ctx context.Context, records []DNSRecord, dryRun bool) error {
+// SetClock replaces the clock of the client, e.g. with a fake one in tests. It must be called before StartUpdating,
+// the clock isn't guarded.
+func (c *Client) SetClock(clk clock.Clock) {
+	c.clock = clk
+}
+
+// SetResultHook sets a function called with the outcome of every update of StartUpdating, e.g. to report how long
+// the zones took. It must be called before StartUpdating, the hook isn't guarded.
+func (c *Client) SetResultHook(hook func(*SyncResult)) {
+	c.resultHook = hook
+}
+
+// Clock returns the clock of the client
+func (c *Client) Clock() clock.Clock {
+	return clock.Or(c.clock)
+}
+
+// NewClientFromConfig creates a new Bind DDNS client from the bind section of the configuration
+func NewClientFromConfig(cfg *config.BindConfig) (*Client, error) {
+	if cfg.ActiveDirectory.Enabled && cfg.Server == "" {
+		ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+		defer cancel()
+		server, err := discoverServer(ctx, cfg.ActiveDirectoryDomain(), cfg.VerifyResolvers)
+		if err != nil {
+			return nil, err
+		}
+		discovered := *cfg
+		discovered.Server = server
+		cfg = &discovered
+	}
+	if cfg.GSSTSIG() {
+		return newGSSSignedClient(cfg)
+	}
+	if cfg.KeyCommand != "" && cfg.KeySecret == "" {
+		return newCommandSignedClient(cfg)
+	}
+
+	client, err := NewClient(
+		cfg.Server,
+		cfg.Port,
+		cfg.Zone,
+		cfg.KeyName,
+		cfg.KeySecret,
+		cfg.Algorithm,
+		cfg.TTL,
+		&cfg.PTR,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return configureClient(client, cfg)
+}
+
+// newCommandSignedClient creates a client whose TSIG MACs are computed by the key command
+func newCommandSignedClient(cfg *config.BindConfig) (*Client, error) {
+	switch {
+	case cfg.Server == "":
+		return nil, fmt.Errorf("server is required")
+	case cfg.Zone == "":
+		return nil, fmt.Errorf("zone is required")
+	case cfg.KeyName == "":
+		return nil, fmt.Errorf("key name is required")
+	}
+
+	client, err := newClient(cfg.Server, cfg.Port, cfg.Zone, cfg.KeyName, cfg.Algorithm, cfg.TTL, &cfg.PTR)
+	if err != nil {
+		return nil, err
+	}
+	client.signer = &commandSigner{command: cfg.KeyCommand}
+	return configureClient(client, cfg)
+}
+
+// newGSSSignedClient creates a client whose TSIG keys are negotiated with GSS-TSIG. The principal stands in for the
+// key name in the update policy, as the server grants updates to it.
+func newGSSSignedClient(cfg *config.BindConfig) (*Client, error) {
+	switch {
+	case cfg.Server == "":
+		return nil, fmt.Errorf("server is required")
+	case cfg.Zone == "":
+		return nil, fmt.Errorf("zone is required")
+	}
+
+	client, err := newClient(cfg.Server, cfg.Port, cfg.Zone, cfg.GSS.Principal, "", cfg.TTL, &cfg.PTR)
+	if err != nil {
+		return nil, err
+	}
+	if client.signer, err = newGSSSigner(server{host: client.server, port: client.port}, &cfg.GSS); err != nil {
+		return nil, err
+	}
+	client.principal = cfg.GSS.Principal
+	return configureClient(client, cfg)
+}
+
+// configureClient applies the rest of the bind section to a new client
+func configureClient(client *Client, cfg *config.BindConfig) (*Client, error) {
+	var err error
+
+	client.verifySerial = cfg.VerifySerial
+	client.statisticsURL = cfg.StatisticsURL
+	client.statisticsClient = &http.Client{Timeout: verifyTimeout}
+	client.removeStale = cfg.RemoveStale
+	client.maxRecordAge = cfg.MaxRecordAge
+	client.strictRRsetRemoval = cfg.StrictRRsetRemoval
+	client.usePrerequisites = cfg.UsePrerequisites
+	client.activeDirectory = cfg.ActiveDirectory.Enabled
+	client.transport = cfg.Transport
+	client.zoneOrder = cfg.ZoneOrder
+	client.ownerID = cfg.OwnerID
+	client.queryBeforeUpdate = cfg.QueryBeforeUpdate
+	client.ttlTolerance = cfg.TTLTolerance
+	client.zoneTransfer = cfg.ZoneTransfer
+	client.updatePolicy, err = parseUpdatePolicy(cfg.UpdatePolicy)
+	if err != nil {
+		return nil, err
+	}
+	client.zoneConcurrency = cfg.ZoneConcurrency
+	client.updateAllServers = cfg.UpdateAllServers
+	client.queueFile = cfg.QueueFile
+	client.retry = cfg.Retry
+	if cfg.StateFile != "" {
+		client.state, err = openStateStore(cfg.StateFile)
+		if err != nil {
+			return nil, err
+		}
+		client.recoverState()
+	}
+	if len(cfg.VerifyResolvers) > 0 {
+		client.resolver, err = NewRecursiveResolver(cfg.VerifyResolvers)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Transport == config.TransportTCPTLS {
+		client.tlsConfig, err = newTLSConfig(client.server, &cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, address := range cfg.Servers {
+		host, port, err := parseServerAddress(address, cfg.Port)
+		if err != nil {
+			return nil, err
+		}
+		fallback := server{host: host, port: port}
+		if cfg.Transport == config.TransportTCPTLS {
+			fallback.tlsConfig, err = newTLSConfig(host, &cfg.TLS)
+			if err != nil {
+				return nil, err
+			}
+		}
+		client.fallbacks = append(client.fallbacks, fallback)
+	}
+	return client, nil
+}
+
+// parseServerAddress splits a server given as a host name, an IP address (IPv6 with or without brackets), host:port or
+// [ipv6]:port into its host and port. A port in the address takes precedence over the configured port.
+func parseServerAddress(server string, port int) (string, int, error) {
+	server = strings.TrimSpace(server)
+
+	// Bare IP addresses, including IPv6 literals with a zone such as fe80::53%eth0
+	address, _, _ := strings.Cut(server, "%")
+	if net.ParseIP(address) != nil {
+		return server, port, nil
+	}
+
+	if strings.HasPrefix(server, "[") && strings.HasSuffix(server, "]") {
+		host := server[1 : len(server)-1]
+		if ip, _, _ := strings.Cut(host, "%"); net.ParseIP(ip) == nil {
+			return "", 0, fmt.Errorf("invalid server address %q: %q is not an IP address", server, host)
+		}
+		return host, port, nil
+	}
+
+	if !strings.HasPrefix(server, "[") && strings.Count(server, ":") > 1 {
+		return "", 0, fmt.Errorf("invalid server address %q: enclose IPv6 addresses with a port in brackets", server)
+	}
+	if !strings.Contains(server, ":") {
+		return server, port, nil
+	}
+
+	host, portStr, err := net.SplitHostPort(server)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid server address %q: %w", server, err)
+	}
+	if host == "" {
+		return "", 0, fmt.Errorf("invalid server address %q: missing host", server)
+	}
+	port, err = strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid server address %q: invalid port %q", server, portStr)
+	}
+	return host, port, nil
+}
+
+// UpdateRecords updates DNS records for the given machines and returns the outcome of the update of every zone. The
+// returned error joins the errors of the failed zones, the result is returned in either case.
+func (c *Client) UpdateRecords(ctx context.Context, records []DNSRecord, dryRun bool) (*SyncResult, error) {
+	clk := clock.Or(c.clock)
+	result := &SyncResult{Started: clk.Now(), DryRun: dryRun}
+	defer func() { result.Duration = clk.Since(result.Started) }()
+
 	if dryRun {
 		klog.Infof("DRY RUN: Would update %d DNS records", len(records))
-		for _, record := range records {
-			if record.Type == "PTR" {
+		sorted := slices.Clone(records)
+		sortRecords(sorted)
+		for _, record := range sorted {
+			if record.Type == TypePTR {
 				klog.V(1).Infof("DRY RUN: Would create/update PTR record %s -> %s (TTL: %d)",
 					record.Name, record.Value, record.TTL)
 			} else {
-				klog.V(1).Infof("DRY RUN: Would create/update A record %s.%s -> %s (TTL: %d)",
-					record.Name, c.zone, record.Value, record.TTL)
+				klog.V(1).Infof("DRY RUN: Would create/update %s record %s.%s -> %s (TTL: %d)",
+					record.Type, record.Name, c.zone, record.Value, record.TTL)
 			}
 		}
-		return nil
+		return result, nil
 	}
 
-	if len(records) == 0 {
+	// Concurrent callers, e.g. the periodic updater and an on-demand sync, take turns so that each diff is computed
+	// against the records the previous update left behind
+	c.updateMu.Lock()
+	defer c.updateMu.Unlock()
+
+	if len(records) == 0 && !c.hasPublished() {
 		klog.V(1).Info("No records to update")
-		return nil
+		return result, nil
 	}
 
 	klog.Infof("Updating %d DNS records", len(records))
 
-	// Group records by zone
-	recordsByZone := make(map[string][]DNSRecord)
+	// Invalid records never reach a zone, so that e.g. a type of "a" isn't published as something else
+	records, result.Skipped = validRecords(records)
+	c.updateShards(records)
+	recordsByZone := c.groupRecordsByZone(records)
 	for _, record := range records {
-		var zone string
-		if record.Type == "PTR" {
-			// For PTR records, determine the zone dynamically based on the record name
-			if strings.Contains(record.Name, ".in-addr.arpa.") {
-				// IPv4 PTR record - extract zone from the record name
-				zone = c.extractIPv4ZoneFromPTRName(record.Name)
-			} else if strings.Contains(record.Name, ".ip6.arpa.") {
-				// IPv6 PTR record - extract zone from the record name
-				zone = c.extractIPv6ZoneFromPTRName(record.Name)
-			}
-		} else {
-			// A/AAAA records go to the main zone
-			zone = c.zone
+		if c.recordZone(record) == "" {
+			result.Skipped = append(result.Skipped, SkippedRecord{Record: record, Reason: SkipOutsideZones})
 		}
+	}
 
-		if zone != "" {
-			recordsByZone[zone] = append(recordsByZone[zone], record)
+	// Zones that no longer have any desired records may still hold stale records of ours
+	if c.removeStale || c.retention() > 0 {
+		for _, zone := range c.publishedZones() {
+			if _, ok := recordsByZone[zone]; !ok {
+				recordsByZone[zone] = nil
+			}
 		}
 	}
 
 	// Create TSIG key
-	key, err := c.createTSIGKey()
+	key, secret, err := c.signingKey()
 	if err != nil {
-		return fmt.Errorf("creating TSIG key: %w", err)
+		return result, fmt.Errorf("creating TSIG key: %w", err)
 	}
 
-	// Send updates for each zone
-	for zone, zoneRecords := range recordsByZone {
-		klog.V(1).Infof("Sending %d records to zone %s", len(zoneRecords), zone)
-
-		if err := c.sendZoneUpdate(ctx, zone, zoneRecords, key); err != nil {
-			return fmt.Errorf("sending update to zone %s: %w", zone, err)
+	// Send updates for each zone, continuing past failures so that one broken zone doesn't starve the others
+	var (
+		resultsMu   sync.Mutex
+		zoneResults = make(map[string]ZoneResult, len(recordsByZone))
+	)
+	zones := slices.Collect(maps.Keys(recordsByZone))
+	errs := c.updateZones(zones, func(zone string) error {
+		zoneResult := c.updateZone(ctx, c.pendingChange(zone, recordsByZone[zone]), key, secret)
+		resultsMu.Lock()
+		zoneResults[zone] = zoneResult
+		resultsMu.Unlock()
+		return zoneResult.err
+	})
+
+	for _, group := range c.zoneGroups(zones) {
+		for _, zone := range group {
+			zoneResult, ok := zoneResults[zone]
+			if !ok {
+				// The zone was never attempted because an earlier zone's update was rejected
+				zoneResult = ZoneResult{
+					Zone:    zone,
+					Rcode:   NoResponse,
+					Records: len(recordsByZone[zone]),
+					Skipped: skip(recordsByZone[zone], SkipKeyRejected),
+				}
+			}
+			result.Zones = append(result.Zones, zoneResult)
 		}
 	}
 
-	return nil
+	if len(errs) > 0 {
+		klog.Errorf("%d of %d zone updates failed", len(errs), len(recordsByZone))
+	}
+
+	return result, errors.Join(errs...)
 }
 
-// sendZoneUpdate sends DNS updates for a specific zone
-func (c *Client) sendZoneUpdate(ctx context.Context, zone string, records []DNSRecord, key *dns.TSIG) error {
-	// Create dynamic update message for this zone
-	msg := new(dns.Msg)
-	msg.SetUpdate(dns.Fqdn(zone))
+// updateZone brings a single zone to its desired records and returns the outcome
+func (c *Client) updateZone(ctx context.Context, change zoneChange, key *dns.TSIG, secret string) ZoneResult {
+	clk := clock.Or(c.clock)
+	started := clk.Now()
+	result := ZoneResult{Zone: change.zone, Rcode: NoResponse}
+	finish := func(err error) ZoneResult {
+		c.recordZoneResult(change.zone, len(change.desired), result.Serial, err)
+		result.Records = len(change.desired)
+		result.Skipped = change.skipped
+		result.Duration = clk.Since(started)
+		if err != nil {
+			result.Error, result.err = err.Error(), err
+		}
+		return result
+	}
 
-	// Add records to the update message
-	klog.V(2).Infof("Adding %d records to zone %s", len(records), zone)
+	c.filterPolicy(&change)
+	reader := c.newZoneReader()
+	if c.ownerID != "" {
+		if err := c.filterOwned(ctx, reader, &change); err != nil {
+			return finish(err)
+		}
+	}
+	if c.queryBeforeUpdate {
+		if err := c.skipApplied(ctx, reader, &change); err != nil {
+			return finish(err)
+		}
+	}
+	if change.empty() {
+		// Records found on the server count as published so that they are tracked like the ones we sent
+		c.markPublished(change.zone, change.desired)
+		klog.V(1).Infof("Zone %s is up to date", change.zone)
+		result.Rcode = dns.RcodeSuccess
+		return finish(nil)
+	}
+	klog.V(1).Infof("Sending %d added or changed records to zone %s, removing %d stale records",
+		len(change.upserts), change.zone, len(change.removals))
+
+	result.Sent, result.Removed = len(change.upserts), len(change.removals)
+	err := c.sendZoneUpdate(ctx, change, key, secret, &result)
+	if names := changeNames(change); c.activeDirectory && errors.Is(err, ErrRefused) &&
+		(len(names) > 1 || c.deniedByACL(names)) {
+		err = c.sendByName(ctx, &change, key, secret, &result)
+	}
+	return finish(err)
+}
+
+// groupRecordsByZone groups records by the zone they belong to, dropping records that don't belong to any zone
+func (c *Client) groupRecordsByZone(records []DNSRecord) map[string][]DNSRecord {
+	recordsByZone := make(map[string][]DNSRecord)
 	for _, record := range records {
-		if record.Type == "PTR" {
-			// Handle PTR records
-			klog.V(1).Infof("Processing PTR record: %s -> %s", record.Name, record.Value)
+		if zone := c.recordZone(record); zone != "" {
+			recordsByZone[zone] = append(recordsByZone[zone], record)
+		}
+	}
+	return recordsByZone
+}
 
-			// Remove any existing PTR record for this name
-			rrset := &dns.PTR{
-				Hdr: dns.RR_Header{
-					Name:   dns.Fqdn(record.Name),
-					Rrtype: dns.TypePTR,
-					Class:  dns.ClassINET,
-					Ttl:    0, // TTL 0 for removal
-				},
-			}
-			msg.RemoveRRset([]dns.RR{rrset})
-
-			// Add new PTR record
-			ptrRecord := &dns.PTR{
-				Hdr: dns.RR_Header{
-					Name:   dns.Fqdn(record.Name),
-					Rrtype: dns.TypePTR,
-					Class:  dns.ClassINET,
-					Ttl:    record.TTL,
-				},
-				Ptr: dns.Fqdn(record.Value),
-			}
-			msg.Insert([]dns.RR{ptrRecord})
+// recordZone returns the zone a record belongs to, or an empty string when it doesn't belong to any configured zone
+func (c *Client) recordZone(record DNSRecord) string {
+	if record.Type != TypePTR {
+		// A/AAAA records go to the main zone
+		return c.zone
+	}
 
-		} else {
-			// Handle A/AAAA records (default)
-			klog.V(1).Infof("Processing %s record: %s.%s -> %s", record.Type, record.Name, zone, record.Value)
+	// For PTR records, determine the zone dynamically based on the record name
+	if strings.Contains(record.Name, ".in-addr.arpa.") {
+		// IPv4 PTR record - extract zone from the record name
+		zone := c.extractIPv4ZoneFromPTRName(record.Name)
+		if c.isSharded(zone) {
+			return ipv4PTRZone(record.Name, c.ipv4ShardSize())
+		}
+		return zone
+	} else if strings.Contains(record.Name, ".ip6.arpa.") {
+		// IPv6 PTR record - extract zone from the record name
+		zone := c.extractIPv6ZoneFromPTRName(record.Name)
+		if c.isSharded(zone) {
+			return ipv6PTRZone(record.Name, c.ipv6ShardSize())
+		}
+		return zone
+	}
+	return ""
+}
 
-			// Remove any existing A/AAAA record for this name
-			var rrset dns.RR
-			if record.Type == "AAAA" {
-				rrset = &dns.AAAA{
-					Hdr: dns.RR_Header{
-						Name:   dns.Fqdn(record.Name + "." + zone),
-						Rrtype: dns.TypeAAAA,
-						Class:  dns.ClassINET,
-						Ttl:    0, // TTL 0 for removal
-					},
-				}
-			} else {
-				rrset = &dns.A{
-					Hdr: dns.RR_Header{
-						Name:   dns.Fqdn(record.Name + "." + zone),
-						Rrtype: dns.TypeA,
-						Class:  dns.ClassINET,
-						Ttl:    0, // TTL 0 for removal
-					},
-				}
-			}
-			msg.RemoveRRset([]dns.RR{rrset})
-
-			// Add new A/AAAA record
-			var newRecord dns.RR
-			if record.Type == "AAAA" {
-				newRecord = &dns.AAAA{
-					Hdr: dns.RR_Header{
-						Name:   dns.Fqdn(record.Name + "." + zone),
-						Rrtype: dns.TypeAAAA,
-						Class:  dns.ClassINET,
-						Ttl:    record.TTL,
-					},
-					AAAA: net.ParseIP(record.Value),
-				}
-			} else {
-				newRecord = &dns.A{
-					Hdr: dns.RR_Header{
-						Name:   dns.Fqdn(record.Name + "." + zone),
-						Rrtype: dns.TypeA,
-						Class:  dns.ClassINET,
-						Ttl:    record.TTL,
-					},
-					A: net.ParseIP(record.Value),
-				}
-			}
-			msg.Insert([]dns.RR{newRecord})
+// recordZoneResult stores the outcome of an update attempt against a zone
+func (c *Client) recordZoneResult(zone string, records int, serial uint32, err error) {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	if c.zoneStatus == nil {
+		c.zoneStatus = make(map[string]ZoneStatus)
+	}
+
+	status := c.zoneStatus[zone]
+	status.Records = records
+	status.LastAttempt = clock.Or(c.clock).Now()
+	if serial != 0 {
+		status.Serial = serial
+	}
+	if err != nil {
+		status.LastError = err.Error()
+		status.Failures++
+	} else {
+		status.LastSuccess = status.LastAttempt
+		status.LastError = ""
+		status.Failures = 0
+	}
+	c.zoneStatus[zone] = status
+}
+
+// ZoneStatuses returns a snapshot of the per-zone outcome of the most recent updates
+func (c *Client) ZoneStatuses() map[string]ZoneStatus {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	statuses := make(map[string]ZoneStatus, len(c.zoneStatus))
+	for zone, status := range c.zoneStatus {
+		statuses[zone] = status
+	}
+	return statuses
+}
+
+// sortRecords orders records by type, name and value so that update messages are built deterministically
+func sortRecords(records []DNSRecord) {
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Type != records[j].Type {
+			return records[i].Type < records[j].Type
+		}
+		if records[i].Name != records[j].Name {
+			return records[i].Name < records[j].Name
+		}
+		return records[i].Value < records[j].Value
+	})
+}
+
+// sendZoneUpdate sends the changed records of a zone, removing its stale records in the same message and verifying
+// that the update was applied when configured to. The response code of the update and the zone's SOA serial, when the
+// serial was verified, are stored in result.
+func (c *Client) sendZoneUpdate(
+	ctx context.Context,
+	change zoneChange,
+	key *dns.TSIG,
+	secret string,
+	result *ZoneResult,
+) error {
+	msg := c.zoneUpdate(change)
+	if c.ownerID != "" {
+		c.addOwnership(msg, change)
+	}
+
+	// How long verification took is only reported when the update is verified at all
+	clk := clock.Or(c.clock)
+	verifying := c.verifySerial || c.statisticsURL != ""
+	verifyStarted := clk.Now()
+	check, err := c.beginUpdateCheck(ctx, change.zone, change.desired)
+	if verifying {
+		result.Verify = clk.Since(verifyStarted)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := c.exchangeUpdate(ctx, change.zone, msg, key, secret); err != nil {
+		var rcodeErr *RcodeError
+		if errors.As(err, &rcodeErr) {
+			result.Rcode = rcodeErr.Rcode
+		}
+		if c.usePrerequisites && errors.Is(err, ErrPrerequisiteFailed) {
+			klog.Warningf("Records of zone %s were changed by someone else, republishing the zone with the next update",
+				change.zone)
+			c.forgetPublished(change.zone)
 		}
+		return err
+	}
+	result.Rcode = dns.RcodeSuccess
+
+	verifyStarted = clk.Now()
+	serial, err := c.finishUpdateCheck(ctx, check)
+	if verifying {
+		result.Verify += clk.Since(verifyStarted)
+	}
+	result.Serial = serial
+	if err != nil {
+		return err
+	}
+
+	c.markPublished(change.zone, change.desired)
+	if c.ownerID != "" {
+		c.markOwned(change)
+	}
+	klog.V(1).Infof("Successfully updated %d records in zone %s", len(change.upserts), change.zone)
+	return nil
+}
+
+// exchangeUpdate signs an update message with TSIG, sends it to the server and checks the response code
+func (c *Client) exchangeUpdate(ctx context.Context, zone string, msg *dns.Msg, key *dns.TSIG, secret string) error {
+	key, secret, err := c.zoneSigningKey(zone, key, secret)
+	if err != nil {
+		return err
 	}
 
 	// Sign the message with TSIG (300 seconds timeout)
@@ -250,65 +726,278 @@ func (c *Client) sendZoneUpdate(ctx context.Context, zone string, records []DNSR
 	klog.V(2).Infof("Sending DNS update message to zone %s: %s", zone, msg.String())
 	klog.V(2).Infof("Message Question Section: %v", msg.Question)
 
-	client := new(dns.Client)
-	client.TsigSecret = map[string]string{key.Hdr.Name: c.keySecret}
+	secrets := map[string]string{key.Hdr.Name: secret}
+	if c.updateAllServers && len(c.fallbacks) > 0 {
+		responses, err := c.exchangeAll(ctx, msg, 0, secrets)
+		for _, response := range responses {
+			if rcodeErr := responseError(response); rcodeErr != nil {
+				c.rejectedKey(rcodeErr)
+				err = errors.Join(err, rcodeErr)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("DNS update failed on some servers: %w", err)
+		}
+		return nil
+	}
 
-	response, _, err := client.ExchangeContext(ctx, msg, net.JoinHostPort(c.server, fmt.Sprintf("%d", c.port)))
+	response, err := c.exchange(ctx, msg, 0, secrets)
 	if err != nil {
 		return fmt.Errorf("sending DNS update: %w", err)
 	}
 
-	if response.Rcode != dns.RcodeSuccess {
-		return fmt.Errorf("DNS update failed with Rcode %d: %s", response.Rcode, dns.RcodeToString[response.Rcode])
+	if err := responseError(response); err != nil {
+		c.rejectedKey(err)
+		return fmt.Errorf("DNS update failed: %w", err)
+	}
+	return nil
+}
+
+// rejectedKey drops a negotiated key the server rejected, which it forgets e.g. when it restarts, so that the next
+// update negotiates a new one
+func (c *Client) rejectedKey(err error) {
+	if negotiated, ok := c.signer.(negotiatedSigner); ok && errors.Is(err, ErrTSIGBadKey) {
+		klog.Warningf("Server rejected the GSS-TSIG key, negotiating a new one: %v", err)
+		negotiated.reset()
+	}
+}
+
+// ProbeUpdate sends an authenticated update that deletes a reserved probe name from the zone. Because the name is never
+// populated the update changes nothing, but a success proves that the server accepts updates signed with our key.
+func (c *Client) ProbeUpdate(ctx context.Context) error {
+	key, secret, err := c.signingKey()
+	if err != nil {
+		return fmt.Errorf("creating TSIG key: %w", err)
+	}
+
+	msg := new(dns.Msg)
+	msg.SetUpdate(dns.Fqdn(c.zone))
+	msg.RemoveName([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: dns.Fqdn(probeRecordName + "." + c.zone)}}})
+
+	if err := c.exchangeUpdate(ctx, c.zone, msg, key, secret); err != nil {
+		return fmt.Errorf("probe update to zone %s: %w", c.zone, err)
+	}
+
+	klog.V(1).Infof("Probe update to zone %s succeeded with key %s", c.zone, key.Hdr.Name)
+	return nil
+}
+
+// SetTSIGKey replaces the TSIG key used for subsequent updates
+func (c *Client) SetTSIGKey(keyName, keySecret, algorithm string) error {
+	if keyName == "" {
+		return fmt.Errorf("key name is required")
+	}
+	if keySecret == "" {
+		return fmt.Errorf("key secret is required")
+	}
+	if _, err := tsigAlgorithm(algorithm); err != nil {
+		return err
+	}
+	if _, ok := c.signer.(negotiatedSigner); ok {
+		return fmt.Errorf("the TSIG key is negotiated with GSS-TSIG, rotate the keytab instead")
+	}
+	if c.signer != nil {
+		return fmt.Errorf("the TSIG key is held by the key command, rotate it there")
+	}
+
+	c.keyMu.Lock()
+	defer c.keyMu.Unlock()
+
+	c.keyName = keyName
+	c.keySecret = keySecret
+	c.algorithm = algorithm
+	klog.Infof("TSIG key for zone %s switched to %s", c.zone, keyName)
+	return nil
+}
+
+// buildZoneUpdate builds the dynamic update message for a zone, replacing the record sets of records and deleting
+// those of stale. Records are sorted first so that the same record set always produces the same message regardless
+// of the order in which it was generated.
+func buildZoneUpdate(zone string, records, stale []DNSRecord) *dns.Msg {
+	records = slices.Clone(records)
+	sortRecords(records)
+
+	// Create dynamic update message for this zone
+	msg := new(dns.Msg)
+	msg.SetUpdate(dns.Fqdn(zone))
+
+	// Add records to the update message
+	klog.V(2).Infof("Adding %d records to zone %s", len(records), zone)
+	replaced := make(map[RecordKey]bool, len(records))
+	for _, record := range records {
+		switch record.Type {
+		case TypePTR:
+			klog.V(1).Infof("Processing PTR record: %s -> %s", record.Name, record.Value)
+		case TypeTXT:
+			// TXT values are split into strings of at most 255 bytes
+			klog.V(1).Infof("Processing TXT record: %s.%s -> %q", record.Name, zone, record.Value)
+		case TypeA, TypeAAAA, TypeCNAME:
+			klog.V(1).Infof("Processing %s record: %s.%s -> %s", record.Type, record.Name, zone, record.Value)
+		default:
+			klog.Warningf("Skipping record %s: unsupported record type %q", record.Name, record.Type)
+			continue
+		}
+		rr := record.RR(zone)
+		if rr == nil {
+			klog.Warningf("Skipping %s record %s: invalid value %q", record.Type, record.Name, record.Value)
+			continue
+		}
+
+		// Record sets with several values, e.g. the A records of a multi-homed device, are only removed once
+		if !replaced[record.Key()] {
+			replaced[record.Key()] = true
+			msg.RemoveRRset([]dns.RR{removalRRset(zone, record)})
+		}
+		msg.Insert([]dns.RR{rr})
+	}
+
+	// Remove stale record sets
+	stale = slices.Clone(stale)
+	sortRecords(stale)
+	for _, record := range stale {
+		if replaced[record.Key()] {
+			continue
+		}
+		replaced[record.Key()] = true
+		if !record.Type.Valid() {
+			klog.Warningf("Not removing stale record %s: unsupported record type %q", record.Name, record.Type)
+			continue
+		}
+		klog.V(1).Infof("Removing stale %s record: %s", record.Key().Type, record.Name)
+		if record.Key().Type == TypeTXT {
+			// Only our own value is deleted, the name may carry other TXT records such as the owner record
+			msg.Remove([]dns.RR{txtRR(zone, record, 0)})
+			continue
+		}
+		msg.RemoveRRset([]dns.RR{removalRRset(zone, record)})
 	}
 
-	klog.V(1).Infof("Successfully updated %d records in zone %s", len(records), zone)
+	return msg
+}
+
+// txtRR returns the TXT record holding the value of a record, split into strings of at most 255 bytes
+func txtRR(zone string, record DNSRecord, ttl uint32) *dns.TXT {
+	var txt []string
+	for value := record.Value; value != "" || len(txt) == 0; {
+		n := min(len(value), 255)
+		txt = append(txt, value[:n])
+		value = value[n:]
+	}
+	return &dns.TXT{
+		Hdr: dns.RR_Header{
+			Name:   dns.Fqdn(record.Name + "." + zone),
+			Rrtype: dns.TypeTXT,
+			Class:  dns.ClassINET,
+			Ttl:    ttl,
+		},
+		Txt: txt,
+	}
+}
+
+// RR returns the resource record a record is published as in zone, nil when its type or value is invalid
+func (r DNSRecord) RR(zone string) dns.RR {
+	hdr := dns.RR_Header{Name: recordFQDN(zone, r), Class: dns.ClassINET, Ttl: r.TTL}
+	switch r.Type {
+	case TypeA:
+		ip := net.ParseIP(r.Value).To4()
+		if ip == nil {
+			return nil
+		}
+		hdr.Rrtype = dns.TypeA
+		return &dns.A{Hdr: hdr, A: ip}
+	case TypeAAAA:
+		ip := net.ParseIP(r.Value)
+		if ip == nil || ip.To4() != nil {
+			return nil
+		}
+		hdr.Rrtype = dns.TypeAAAA
+		return &dns.AAAA{Hdr: hdr, AAAA: ip}
+	case TypeCNAME:
+		hdr.Rrtype = dns.TypeCNAME
+		return &dns.CNAME{Hdr: hdr, Target: dns.Fqdn(r.Value)}
+	case TypeTXT:
+		txt := txtRR(zone, r, r.TTL)
+		txt.Hdr.Name = hdr.Name
+		return txt
+	case TypePTR:
+		hdr.Rrtype = dns.TypePTR
+		return &dns.PTR{Hdr: hdr, Ptr: dns.Fqdn(r.Value)}
+	}
 	return nil
 }
 
 // createTSIGKey creates a TSIG key for authentication
 func (c *Client) createTSIGKey() (*dns.TSIG, error) {
-	algorithm := c.algorithm
-	if algorithm == "" {
-		algorithm = "hmac-sha256"
+	key, _, err := c.signingKey()
+	return key, err
+}
+
+// signingKey returns the TSIG key together with its secret, taken from a single consistent snapshot
+func (c *Client) signingKey() (*dns.TSIG, string, error) {
+	if negotiated, ok := c.signer.(negotiatedSigner); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), gssNegotiationTimeout)
+		defer cancel()
+		keyName, err := negotiated.keyName(ctx)
+		if err != nil {
+			return nil, "", err
+		}
+		return &dns.TSIG{
+			Hdr:       dns.RR_Header{Name: keyName, Rrtype: dns.TypeTSIG, Class: dns.ClassANY},
+			Algorithm: gssTSIGAlgorithm,
+		}, "", nil
 	}
 
-	// Validate algorithm
-	switch algorithm {
-	case "hmac-md5", "hmac-sha1", "hmac-sha256", "hmac-sha384", "hmac-sha512":
-		// Valid algorithms
-	default:
-		return nil, fmt.Errorf("unsupported TSIG algorithm: %s", algorithm)
+	c.keyMu.RLock()
+	keyName, keySecret, algorithm := c.keyName, c.keySecret, c.algorithm
+	c.keyMu.RUnlock()
+
+	algorithm, err := tsigAlgorithm(algorithm)
+	if err != nil {
+		return nil, "", err
 	}
 
 	return &dns.TSIG{
 		Hdr: dns.RR_Header{
-			Name:   c.keyName,
+			Name:   keyName,
 			Rrtype: dns.TypeTSIG,
 			Class:  dns.ClassANY,
 		},
 		Algorithm: fmt.Sprintf("%s.", algorithm),
-	}, nil
+	}, keySecret, nil
+}
+
+// tsigAlgorithm validates a TSIG algorithm name, applying the default when it is empty
+func tsigAlgorithm(algorithm string) (string, error) {
+	if algorithm == "" {
+		algorithm = "hmac-sha256"
+	}
+
+	// Validate algorithm
+	switch algorithm {
+	case "hmac-md5", "hmac-sha1", "hmac-sha256", "hmac-sha384", "hmac-sha512":
+		// Valid algorithms
+		return algorithm, nil
+	default:
+		return "", fmt.Errorf("unsupported TSIG algorithm: %s", algorithm)
+	}
 }
 
 // ValidateConnection tests the connection to the Bind server
 func (c *Client) ValidateConnection(ctx context.Context) error {
-	klog.V(1).Infof("Validating connection to Bind server %s:%d", c.server, c.port)
+	klog.V(1).Infof("Validating connection to Bind server %s:%d over %s (%d fallback servers)", c.server, c.port,
+		c.network(), len(c.fallbacks))
 
 	// Create a simple query to test connectivity
 	msg := new(dns.Msg)
 	msg.SetQuestion(dns.Fqdn(c.zone), dns.TypeSOA)
 
-	client := new(dns.Client)
-	client.Timeout = 5 * time.Second
-
-	response, _, err := client.ExchangeContext(ctx, msg, net.JoinHostPort(c.server, fmt.Sprintf("%d", c.port)))
+	response, err := c.exchange(ctx, msg, 5*time.Second, nil)
 	if err != nil {
 		return fmt.Errorf("connection test failed: %w", err)
 	}
 
-	if response.Rcode != dns.RcodeSuccess {
-		return fmt.Errorf("connection test failed with Rcode %d: %s", response.Rcode, dns.RcodeToString[response.Rcode])
+	if err := responseError(response); err != nil {
+		return fmt.Errorf("connection test failed: %w", err)
 	}
 
 	klog.V(1).Info("Successfully validated connection to Bind server")
@@ -322,44 +1011,9 @@ func (c *Client) StartUpdating(
 	recordChan <-chan []DNSRecord,
 	dryRun bool,
 ) {
-	ticker := time.NewTicker(updateInterval)
-	defer ticker.Stop()
-
 	klog.Infof("Starting DDNS updates with interval %v", updateInterval)
-
-	// Process initial records if available
-	select {
-	case records := <-recordChan:
-		if err := c.UpdateRecords(ctx, records, dryRun); err != nil {
-			klog.Errorf("Failed to update initial records: %v", err)
-		}
-	case <-ctx.Done():
-		return
-	}
-
-	for {
-		select {
-		case records := <-recordChan:
-			if err := c.UpdateRecords(ctx, records, dryRun); err != nil {
-				klog.Errorf("Failed to update records: %v", err)
-			}
-
-		case <-ticker.C:
-			// Periodic update - check if there are any pending records
-			select {
-			case records := <-recordChan:
-				if err := c.UpdateRecords(ctx, records, dryRun); err != nil {
-					klog.Errorf("Failed to update records: %v", err)
-				}
-			default:
-				// No records to process
-			}
-
-		case <-ctx.Done():
-			klog.Info("DDNS updating stopped")
-			return
-		}
-	}
+	c.ProcessUpdates(ctx, recordChan, dryRun, c.UpdateRecords)
+	klog.Info("DDNS updating stopped")
 }
 
 // isIPInSubnet checks if an IP address is within the specified subnet
@@ -451,7 +1105,11 @@ func (c *Client) extractIPv4ZoneFromPTRName(ptrName string) string {
 	if c.ptrConfig == nil || !c.ptrConfig.Enabled {
 		return ""
 	}
+	return ipv4PTRZone(ptrName, c.ptrConfig.IPv4SubnetSize)
+}
 
+// ipv4PTRZone returns the reverse zone of the given subnet size holding an IPv4 PTR record name
+func ipv4PTRZone(ptrName string, subnetSize int) string {
 	// Remove trailing dot and .in-addr.arpa
 	name := strings.TrimSuffix(ptrName, ".in-addr.arpa.")
 	name = strings.TrimSuffix(name, ".")
@@ -463,7 +1121,7 @@ func (c *Client) extractIPv4ZoneFromPTRName(ptrName string) string {
 	}
 
 	// Generate zone based on subnet size
-	switch c.ptrConfig.IPv4SubnetSize {
+	switch subnetSize {
 	case IPv4Subnet8:
 		// /8: Use first octet (e.g., 4.3.2.1 -> 1.in-addr.arpa)
 		return fmt.Sprintf("%s.in-addr.arpa", octets[3])
@@ -483,7 +1141,11 @@ func (c *Client) extractIPv6ZoneFromPTRName(ptrName string) string {
 	if c.ptrConfig == nil || !c.ptrConfig.Enabled || !c.ptrConfig.IPv6Enabled {
 		return ""
 	}
+	return ipv6PTRZone(ptrName, c.ptrConfig.IPv6SubnetSize)
+}
 
+// ipv6PTRZone returns the reverse zone of the given subnet size holding an IPv6 PTR record name
+func ipv6PTRZone(ptrName string, subnetSize int) string {
 	// Remove trailing dot and .ip6.arpa
 	name := strings.TrimSuffix(ptrName, ".ip6.arpa.")
 	name = strings.TrimSuffix(name, ".")
@@ -496,7 +1158,7 @@ func (c *Client) extractIPv6ZoneFromPTRName(ptrName string) string {
 
 	// Calculate how many nibbles to use based on subnet size
 	var nibblesToUse int
-	switch c.ptrConfig.IPv6SubnetSize {
+	switch subnetSize {
 	case IPv6Subnet32:
 		nibblesToUse = 8 // /32 = 8 nibbles
 	case IPv6Subnet48:
@@ -519,7 +1181,13 @@ func (c *Client) extractIPv6ZoneFromPTRName(ptrName string) string {
 
 // CreatePTRRecord creates a PTR record for the given IP address and hostname
 func (c *Client) CreatePTRRecord(ipStr, hostname string) (*DNSRecord, error) {
-	if c.ptrConfig == nil || !c.ptrConfig.Enabled {
+	return NewPTRRecord(c.ptrConfig, c.ttl, ipStr, hostname)
+}
+
+// NewPTRRecord creates a PTR record for the given IP address and hostname according to the PTR configuration. It
+// returns a nil record when PTR records are disabled or the address falls outside the configured subnets.
+func NewPTRRecord(ptrConfig *config.PTRConfig, ttl uint32, ipStr, hostname string) (*DNSRecord, error) {
+	if ptrConfig == nil || !ptrConfig.Enabled {
 		return nil, nil
 	}
 
@@ -533,8 +1201,8 @@ func (c *Client) CreatePTRRecord(ipStr, hostname string) (*DNSRecord, error) {
 
 	if ip.To4() != nil {
 		// IPv4 address
-		if !isIPInSubnet(ipStr, c.ptrConfig.IPv4Subnet) {
-			klog.Warningf("IPv4 address %s is not in configured subnet %s, skipping PTR record", ipStr, c.ptrConfig.IPv4Subnet)
+		if !isIPInSubnet(ipStr, ptrConfig.IPv4Subnet) {
+			klog.Warningf("IPv4 address %s is not in configured subnet %s, skipping PTR record", ipStr, ptrConfig.IPv4Subnet)
 			return nil, nil
 		}
 
@@ -546,23 +1214,23 @@ func (c *Client) CreatePTRRecord(ipStr, hostname string) (*DNSRecord, error) {
 
 		// Reverse the IP address parts
 		ptrName = fmt.Sprintf("%s.%s.%s.%s.in-addr.arpa.", parts[3], parts[2], parts[1], parts[0])
-		subnet = c.ptrConfig.IPv4Subnet
+		subnet = ptrConfig.IPv4Subnet
 
 	} else {
 		// IPv6 address
-		if !c.ptrConfig.IPv6Enabled {
+		if !ptrConfig.IPv6Enabled {
 			klog.V(2).Infof("IPv6 PTR records disabled, skipping IPv6 address %s", ipStr)
 			return nil, nil
 		}
 
-		if !isIPInSubnet(ipStr, c.ptrConfig.IPv6Subnet) {
-			klog.Warningf("IPv6 address %s is not in configured subnet %s, skipping PTR record", ipStr, c.ptrConfig.IPv6Subnet)
+		if !isIPInSubnet(ipStr, ptrConfig.IPv6Subnet) {
+			klog.Warningf("IPv6 address %s is not in configured subnet %s, skipping PTR record", ipStr, ptrConfig.IPv6Subnet)
 			return nil, nil
 		}
 
 		// Create reverse DNS name for IPv6
 		ptrName = ipv6ToReverseDNS(ipStr)
-		subnet = c.ptrConfig.IPv6Subnet
+		subnet = ptrConfig.IPv6Subnet
 	}
 
 	klog.V(2).Infof("Creating PTR record for %s -> %s (subnet: %s)", ptrName, hostname, subnet)
@@ -570,8 +1238,8 @@ func (c *Client) CreatePTRRecord(ipStr, hostname string) (*DNSRecord, error) {
 	return &DNSRecord{
 		Name:  ptrName,
 		Value: hostname,
-		TTL:   c.ttl,
-		Type:  "PTR",
+		TTL:   ttl,
+		Type:  TypePTR,
 	}, nil
 }
 
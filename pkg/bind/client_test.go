@@ -2,13 +2,40 @@ package bind
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"math/big"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
 	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewClient(t *testing.T) {
@@ -110,6 +137,68 @@ func TestNewClient(t *testing.T) {
 	}
 }
 
+func TestNewClientServerAddress(t *testing.T) {
+	tests := []struct {
+		name       string
+		server     string
+		wantServer string
+		wantPort   int
+		wantError  string
+	}{
+		{name: "host name", server: "dns.example.com", wantServer: "dns.example.com", wantPort: 53},
+		{name: "host and port", server: "dns.example.com:5353", wantServer: "dns.example.com", wantPort: 5353},
+		{name: "IPv4 and port", server: "192.0.2.53:5353", wantServer: "192.0.2.53", wantPort: 5353},
+		{name: "bare IPv6", server: "fd00::53", wantServer: "fd00::53", wantPort: 53},
+		{name: "bracketed IPv6", server: "[fd00::53]", wantServer: "fd00::53", wantPort: 53},
+		{name: "bracketed IPv6 and port", server: "[fd00::53]:5353", wantServer: "fd00::53", wantPort: 5353},
+		{name: "IPv6 with zone", server: "fe80::53%eth0", wantServer: "fe80::53%eth0", wantPort: 53},
+		{name: "invalid port", server: "dns.example.com:dns", wantError: "invalid port"},
+		{name: "port out of range", server: "dns.example.com:70000", wantError: "invalid port"},
+		{name: "missing host", server: ":5353", wantError: "missing host"},
+		{name: "unbracketed IPv6 with port", server: "fd00::53::5353", wantError: "enclose IPv6 addresses"},
+		{name: "bracketed host name", server: "[dns.example.com]", wantError: "not an IP address"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewClient(tt.server, 53, "test.example.com", "test-key", "test-secret", "hmac-sha256",
+				300*time.Second, nil)
+			if tt.wantError != "" {
+				assert.ErrorContains(t, err, tt.wantError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantServer, client.server)
+			assert.Equal(t, tt.wantPort, client.port)
+		})
+	}
+}
+
+func TestNewClientFallbackServers(t *testing.T) {
+	cfg := &config.BindConfig{
+		Server:    "dns1.example.com",
+		Servers:   []string{"dns2.example.com", "[fd00::53]:5353"},
+		Port:      53,
+		Zone:      "test.example.com",
+		KeyName:   "test-key",
+		KeySecret: "test-secret",
+		Algorithm: "hmac-sha256",
+	}
+	client, err := NewClientFromConfig(cfg)
+	require.NoError(t, err)
+
+	var addresses []string
+	for _, health := range client.ServerHealth() {
+		addresses = append(addresses, health.Address)
+		assert.True(t, health.Healthy)
+	}
+	assert.Equal(t, []string{"dns1.example.com:53", "dns2.example.com:53", "[fd00::53]:5353"}, addresses)
+
+	cfg.Servers = []string{"dns2.example.com:dns"}
+	_, err = NewClientFromConfig(cfg)
+	assert.ErrorContains(t, err, "invalid port")
+}
+
 func TestCreateTSIGKey(t *testing.T) {
 	client := &Client{
 		keyName:   "test-key",
@@ -213,7 +302,7 @@ func TestUpdateRecordsDryRun(t *testing.T) {
 	}
 
 	ctx := context.Background()
-	err := client.UpdateRecords(ctx, records, true) // dry run = true
+	_, err := client.UpdateRecords(ctx, records, true) // dry run = true
 
 	// Dry run should not return an error
 	assert.NoError(t, err)
@@ -231,7 +320,7 @@ func TestUpdateRecordsEmpty(t *testing.T) {
 	}
 
 	ctx := context.Background()
-	err := client.UpdateRecords(ctx, []DNSRecord{}, false)
+	_, err := client.UpdateRecords(ctx, []DNSRecord{}, false)
 
 	// Empty records should not return an error
 	assert.NoError(t, err)
@@ -315,29 +404,173 @@ func TestStartUpdating(t *testing.T) {
 		ttl:       300,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
-	defer cancel()
-
 	recordChan := make(chan []DNSRecord, 1)
-
-	// Send some test records
-	testRecords := []DNSRecord{
+	recordChan <- []DNSRecord{
 		{
 			Name:  "test-machine",
 			Value: "100.64.1.1",
 			TTL:   300,
 		},
 	}
+	close(recordChan)
+
+	// Updating returns once the channel is closed, after the dry run of the records sent on it
+	client.StartUpdating(context.Background(), 10*time.Millisecond, recordChan, true)
+	assert.Empty(t, recordChan)
+}
+
+func TestProcessUpdatesRetry(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	client := &Client{zone: "test.example.com", clock: clk}
+	records := []DNSRecord{{Name: "laptop", Value: "100.64.0.1", TTL: 300, Type: "A"}}
+
+	attempts := make(chan time.Time, 10)
+	var calls atomic.Int32
+	update := func(context.Context, []DNSRecord, bool) (*SyncResult, error) {
+		attempts <- clk.Now()
+		if calls.Add(1) < 3 {
+			return nil, errors.New("connection refused")
+		}
+		return &SyncResult{}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	recordChan := make(chan []DNSRecord, 1)
+	recordChan <- records
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.ProcessUpdates(ctx, recordChan, false, update)
+	}()
+
+	// Failed updates are retried after the backoff interval, which doubles with every failure
+	start := <-attempts
+	clk.BlockUntil(1)
+	clk.Advance(retryMinInterval - time.Second)
+	assert.Empty(t, attempts)
+	clk.Advance(time.Second)
+	assert.Equal(t, retryMinInterval, (<-attempts).Sub(start))
+
+	clk.BlockUntil(1)
+	clk.Advance(2 * retryMinInterval)
+	assert.Equal(t, 3*retryMinInterval, (<-attempts).Sub(start))
+
+	// Nothing is retried once the update succeeded
+	clk.Advance(retryMaxInterval)
+	cancel()
+	<-done
+	assert.Empty(t, attempts)
+}
+
+func TestProcessUpdatesMaxAttempts(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	client := &Client{
+		zone:  "test.example.com",
+		clock: clk,
+		retry: config.RetryConfig{MaxAttempts: 2, InitialBackoff: time.Second},
+	}
+	records := []DNSRecord{{Name: "laptop", Value: "100.64.0.1", TTL: 300, Type: "A"}}
+
+	attempts := make(chan []DNSRecord, 10)
+	update := func(_ context.Context, records []DNSRecord, _ bool) (*SyncResult, error) {
+		attempts <- records
+		return nil, errors.New("connection refused")
+	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	recordChan := make(chan []DNSRecord)
+	done := make(chan struct{})
 	go func() {
-		recordChan <- testRecords
+		defer close(done)
+		client.ProcessUpdates(ctx, recordChan, false, update)
 	}()
 
-	// Start updating with a very short interval
-	go client.StartUpdating(ctx, 10*time.Millisecond, recordChan, true) // dry run
+	// The record set is given up on after the second attempt
+	recordChan <- records
+	<-attempts
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	<-attempts
+	clk.Advance(time.Hour)
+	assert.Empty(t, attempts)
+
+	// The next record set starts over
+	recordChan <- records
+	<-attempts
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	<-attempts
+
+	cancel()
+	<-done
+	assert.Empty(t, attempts)
+}
+
+func TestProcessUpdatesQueue(t *testing.T) {
+	queueFile := filepath.Join(t.TempDir(), "queue.json")
+	client := &Client{zone: "test.example.com", queueFile: queueFile}
+	records := []DNSRecord{{Name: "laptop", Value: "100.64.0.1", TTL: 300, Type: "A"}}
+
+	// A failed update is queued on disk
+	ctx, cancel := context.WithCancel(context.Background())
+	recordChan := make(chan []DNSRecord, 1)
+	recordChan <- records
+	failing := func(context.Context, []DNSRecord, bool) (*SyncResult, error) {
+		cancel()
+		return nil, errors.New("connection refused")
+	}
+	client.ProcessUpdates(ctx, recordChan, false, failing)
+
+	queued, err := client.loadQueue()
+	require.NoError(t, err)
+	assert.Equal(t, records, queued)
+
+	// The next run publishes the queued records before anything else and removes the queue once they were published
+	var published [][]DNSRecord
+	succeeding := func(_ context.Context, records []DNSRecord, _ bool) (*SyncResult, error) {
+		published = append(published, records)
+		return &SyncResult{Duration: time.Second}, nil
+	}
+	var results []*SyncResult
+	client.SetResultHook(func(result *SyncResult) { results = append(results, result) })
+	recordChan = make(chan []DNSRecord)
+	close(recordChan)
+	client.ProcessUpdates(context.Background(), recordChan, false, succeeding)
+
+	assert.Equal(t, [][]DNSRecord{records}, published)
+	assert.Equal(t, []*SyncResult{{Duration: time.Second}}, results)
+	assert.NoFileExists(t, queueFile)
+}
+
+func TestNextRetryInterval(t *testing.T) {
+	intervals := func(client *Client) []time.Duration {
+		interval := time.Duration(0)
+		var intervals []time.Duration
+		for range 7 {
+			interval = client.nextRetryInterval(interval)
+			intervals = append(intervals, interval)
+		}
+		return intervals
+	}
+	assert.Equal(t, []time.Duration{
+		10 * time.Second, 20 * time.Second, 40 * time.Second, 80 * time.Second, 160 * time.Second,
+		retryMaxInterval, retryMaxInterval,
+	}, intervals(&Client{}))
+
+	client := &Client{retry: config.RetryConfig{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}}
+	assert.Equal(t, []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second, 5 * time.Second,
+		5 * time.Second,
+	}, intervals(client))
 
-	// Wait for context cancellation
-	<-ctx.Done()
+	// Jitter stays within its fraction of the interval
+	client.retry.Jitter = 0.5
+	for range 100 {
+		interval := client.jitterInterval(time.Minute)
+		assert.GreaterOrEqual(t, interval, 30*time.Second)
+		assert.LessOrEqual(t, interval, 90*time.Second)
+	}
+	assert.Equal(t, time.Minute, (&Client{}).jitterInterval(time.Minute))
 }
 
 func TestClientFields(t *testing.T) {
@@ -460,7 +693,7 @@ func TestCreatePTRRecord(t *testing.T) {
 				assert.NoError(t, err)
 				if tt.wantRecord {
 					assert.NotNil(t, record)
-					assert.Equal(t, "PTR", record.Type)
+					assert.Equal(t, TypePTR, record.Type)
 					assert.Equal(t, tt.hostname, record.Value)
 					assert.Equal(t, uint32(300), record.TTL)
 				} else {
@@ -768,3 +1001,3023 @@ func TestExtractIPv6ZoneFromPTRName(t *testing.T) {
 		})
 	}
 }
+
+// testTSIGSecret is a base64 encoded secret usable by both the test server and client
+const testTSIGSecret = "dGVzdC1zZWNyZXQtZm9yLXVuaXQtdGVzdHM="
+
+// testPTRTSIGSecret is the secret of ptr-key., the key of the reverse zones of some tests
+const testPTRTSIGSecret = "cmV2ZXJzZS16b25lLXNlY3JldC1mb3ItdGVzdHM="
+
+// startTestDNSServer starts a UDP DNS server on localhost that answers with the given handler
+func startTestDNSServer(t *testing.T, handler dns.HandlerFunc) (string, int) {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen for test DNS server: %v", err)
+	}
+
+	started := make(chan struct{})
+	server := &dns.Server{
+		PacketConn:        pc,
+		Handler:           handler,
+		TsigSecret:        map[string]string{"test-key.": testTSIGSecret, "ptr-key.": testPTRTSIGSecret},
+		UDPSize:           dns.DefaultMsgSize,
+		MsgAcceptFunc:     func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go func() {
+		_ = server.ActivateAndServe()
+	}()
+	<-started
+	t.Cleanup(func() {
+		_ = server.Shutdown()
+	})
+
+	addr := pc.LocalAddr().(*net.UDPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+func TestUpdateRecordsPartialFailure(t *testing.T) {
+	host, port := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if r.Question[0].Name == "1.64.100.in-addr.arpa." {
+			m.Rcode = dns.RcodeRefused
+		}
+		_ = w.WriteMsg(m)
+	})
+
+	client := &Client{
+		server:    host,
+		port:      port,
+		zone:      "test.example.com",
+		keyName:   "test-key.",
+		keySecret: testTSIGSecret,
+		algorithm: "hmac-sha256",
+		ttl:       300,
+		ptrConfig: &config.PTRConfig{
+			Enabled:        true,
+			IPv4Subnet:     "100.64.0.0/10",
+			IPv4SubnetSize: 24,
+		},
+	}
+
+	records := []DNSRecord{
+		{Name: "machine1", Value: "100.64.1.1", TTL: 300, Type: "A"},
+		{Name: "1.1.64.100.in-addr.arpa.", Value: "machine1.test.example.com", TTL: 300, Type: "PTR"},
+		{Name: "2.2.64.100.in-addr.arpa.", Value: "machine2.test.example.com", TTL: 300, Type: "PTR"},
+	}
+
+	_, err := client.UpdateRecords(context.Background(), records, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1.64.100.in-addr.arpa")
+	assert.NotContains(t, err.Error(), "2.64.100.in-addr.arpa")
+
+	statuses := client.ZoneStatuses()
+	require.Len(t, statuses, 3)
+	assert.Empty(t, statuses["test.example.com"].LastError)
+	assert.False(t, statuses["test.example.com"].LastSuccess.IsZero())
+	assert.Empty(t, statuses["2.64.100.in-addr.arpa"].LastError)
+	assert.Contains(t, statuses["1.64.100.in-addr.arpa"].LastError, "REFUSED")
+	assert.ErrorIs(t, err, ErrRefused)
+	assert.Equal(t, 1, statuses["1.64.100.in-addr.arpa"].Failures)
+	assert.True(t, statuses["1.64.100.in-addr.arpa"].LastSuccess.IsZero())
+}
+
+func TestRecordTypes(t *testing.T) {
+	recordType, err := ParseRecordType("aaaa")
+	require.NoError(t, err)
+	assert.Equal(t, TypeAAAA, recordType)
+	assert.Equal(t, dns.TypeAAAA, recordType.Qtype())
+	_, err = ParseRecordType("AAA")
+	assert.ErrorContains(t, err, `unsupported record type "AAA"`)
+	_, err = ParseRecordType("MX")
+	assert.Error(t, err)
+
+	tests := []struct {
+		name    string
+		record  DNSRecord
+		wantErr string
+	}{
+		{name: "A", record: DNSRecord{Name: "web", Value: "100.64.0.1", Type: TypeA}},
+		{name: "AAAA", record: DNSRecord{Name: "web", Value: "fd7a:115c:a1e0::1", Type: TypeAAAA}},
+		{name: "CNAME", record: DNSRecord{Name: "www", Value: "web.ts.example.com", Type: TypeCNAME}},
+		{name: "TXT", record: DNSRecord{Name: "web", Value: "owner=alice", Type: TypeTXT}},
+		{name: "PTR", record: DNSRecord{Name: "1.0.64.100.in-addr.arpa.", Value: "web.ts.example.com", Type: TypePTR}},
+		{
+			name:    "no type",
+			record:  DNSRecord{Name: "web", Value: "100.64.0.1"},
+			wantErr: `unsupported record type ""`,
+		},
+		{
+			name:    "lowercase type",
+			record:  DNSRecord{Name: "web", Value: "100.64.0.1", Type: "a"},
+			wantErr: `unsupported record type "a"`,
+		},
+		{
+			name:    "IPv6 address in an A record",
+			record:  DNSRecord{Name: "web", Value: "fd7a:115c:a1e0::1", Type: TypeA},
+			wantErr: "invalid A record value",
+		},
+		{
+			name:    "empty CNAME target",
+			record:  DNSRecord{Name: "www", Type: TypeCNAME},
+			wantErr: "invalid CNAME record value",
+		},
+		{
+			name:    "empty name",
+			record:  DNSRecord{Value: "100.64.0.1", Type: TypeA},
+			wantErr: "invalid record name",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record, err := NewDNSRecord(tt.record.Name, tt.record.Value, tt.record.Type, 300)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				assert.ErrorContains(t, tt.record.Validate(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			tt.record.TTL = 300
+			assert.Equal(t, tt.record, record)
+		})
+	}
+
+	// Records with unsupported types are left out of updates instead of being published as A records
+	msg := buildZoneUpdate("test.example.com", []DNSRecord{
+		{Name: "typo", Value: "100.64.0.1", TTL: 300, Type: "a"},
+		{Name: "web", Value: "100.64.0.2", TTL: 300, Type: TypeA},
+	}, []DNSRecord{{Name: "old", Value: "100.64.0.3", TTL: 300, Type: "AAA"}})
+	require.Len(t, msg.Ns, 2)
+	for _, rr := range msg.Ns {
+		assert.Equal(t, "web.test.example.com.", rr.Header().Name)
+	}
+}
+
+func TestUpdateRecordsResult(t *testing.T) {
+	host, port := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if r.Question[0].Name == "1.64.100.in-addr.arpa." {
+			m.Rcode = dns.RcodeRefused
+		}
+		_ = w.WriteMsg(m)
+	})
+
+	client := &Client{
+		server:      host,
+		port:        port,
+		zone:        "test.example.com",
+		keyName:     "test-key.",
+		keySecret:   testTSIGSecret,
+		algorithm:   "hmac-sha256",
+		ttl:         300,
+		removeStale: true,
+		ptrConfig: &config.PTRConfig{
+			Enabled:        true,
+			IPv4Subnet:     "100.64.0.0/10",
+			IPv4SubnetSize: 24,
+		},
+	}
+
+	outside := DNSRecord{Name: "bogus", Value: "machine3.test.example.com", TTL: 300, Type: "PTR"}
+	typo := DNSRecord{Name: "machine4", Value: "100.64.4.4", TTL: 300, Type: "AAA"}
+	records := []DNSRecord{
+		{Name: "machine1", Value: "100.64.1.1", TTL: 300, Type: "A"},
+		{Name: "machine2", Value: "100.64.2.2", TTL: 300, Type: "A"},
+		{Name: "1.1.64.100.in-addr.arpa.", Value: "machine1.test.example.com", TTL: 300, Type: "PTR"},
+		{Name: "2.2.64.100.in-addr.arpa.", Value: "machine2.test.example.com", TTL: 300, Type: "PTR"},
+		outside,
+		typo,
+	}
+
+	ctx := context.Background()
+	result, err := client.UpdateRecords(ctx, records, false)
+	require.Error(t, err)
+	require.NotNil(t, result)
+	assert.False(t, result.Started.IsZero())
+	assert.Positive(t, result.Duration)
+	assert.Equal(t, []SkippedRecord{
+		{Record: typo, Reason: SkipInvalid},
+		{Record: outside, Reason: SkipOutsideZones},
+	}, result.SkippedRecords())
+	assert.Equal(t, 1, result.Failed())
+
+	require.Len(t, result.Zones, 3)
+	byZone := make(map[string]ZoneResult)
+	for _, zone := range result.Zones {
+		byZone[zone.Zone] = zone
+	}
+	assert.Equal(t, dns.RcodeRefused, byZone["1.64.100.in-addr.arpa"].Rcode)
+	assert.Equal(t, "REFUSED", byZone["1.64.100.in-addr.arpa"].RcodeString())
+	assert.ErrorIs(t, byZone["1.64.100.in-addr.arpa"].Err(), ErrRefused)
+	assert.Contains(t, byZone["1.64.100.in-addr.arpa"].Error, "REFUSED")
+	assert.Equal(t, dns.RcodeSuccess, byZone["test.example.com"].Rcode)
+	assert.Equal(t, 2, byZone["test.example.com"].Sent)
+	assert.Equal(t, 2, byZone["test.example.com"].Records)
+	assert.NoError(t, byZone["test.example.com"].Err())
+
+	// machine2 went away: its records are removed, the unchanged machine1 isn't sent again
+	result, err = client.UpdateRecords(ctx, records[:1], false)
+	require.NoError(t, err)
+	byZone = make(map[string]ZoneResult)
+	for _, zone := range result.Zones {
+		byZone[zone.Zone] = zone
+	}
+	require.Len(t, byZone, 2)
+	assert.Equal(t, ZoneResult{
+		Zone: "test.example.com", Rcode: dns.RcodeSuccess, Records: 1, Removed: 1,
+		Duration: byZone["test.example.com"].Duration,
+	}, byZone["test.example.com"])
+	assert.Equal(t, 1, byZone["2.64.100.in-addr.arpa"].Removed)
+
+	result, err = client.UpdateRecords(ctx, records, true)
+	require.NoError(t, err)
+	assert.True(t, result.DryRun)
+	assert.Empty(t, result.Zones)
+}
+
+func TestBuildZoneUpdateDeterministic(t *testing.T) {
+	records := []DNSRecord{
+		{Name: "zeta", Value: "100.64.1.3", TTL: 300, Type: "A"},
+		{Name: "alpha", Value: "fd7a:115c:a1e0::1", TTL: 300, Type: "AAAA"},
+		{Name: "alpha", Value: "100.64.1.1", TTL: 300, Type: "A"},
+		{Name: "mid", Value: "100.64.1.2", TTL: 300, Type: "A"},
+	}
+	reversed := make([]DNSRecord, len(records))
+	for i, record := range records {
+		reversed[len(records)-1-i] = record
+	}
+
+	first := buildZoneUpdate("test.example.com", records, nil)
+	second := buildZoneUpdate("test.example.com", reversed, nil)
+	first.Id, second.Id = 0, 0
+
+	firstWire, err := first.Pack()
+	require.NoError(t, err)
+	secondWire, err := second.Pack()
+	require.NoError(t, err)
+	assert.Equal(t, firstWire, secondWire)
+
+	// The caller's slice must not be reordered
+	assert.Equal(t, "zeta", records[0].Name)
+
+	// Each record contributes a removal and an insertion, in sorted order
+	require.Len(t, first.Ns, 8)
+	assert.Equal(t, "alpha.test.example.com.", first.Ns[1].Header().Name)
+	assert.Equal(t, dns.TypeA, first.Ns[1].Header().Rrtype)
+	assert.Equal(t, "mid.test.example.com.", first.Ns[3].Header().Name)
+	assert.Equal(t, "zeta.test.example.com.", first.Ns[5].Header().Name)
+	assert.Equal(t, dns.TypeAAAA, first.Ns[7].Header().Rrtype)
+}
+
+func TestBuildZoneUpdateCNAME(t *testing.T) {
+	records := []DNSRecord{{Name: "nas", Value: "storage-box.test.example.com", TTL: 300, Type: "CNAME"}}
+	stale := []DNSRecord{{Name: "files", Value: "storage-box.test.example.com", TTL: 300, Type: "CNAME"}}
+
+	msg := buildZoneUpdate("test.example.com", records, stale)
+	require.Len(t, msg.Ns, 3)
+
+	cname, ok := msg.Ns[1].(*dns.CNAME)
+	require.True(t, ok)
+	assert.Equal(t, "nas.test.example.com.", cname.Hdr.Name)
+	assert.Equal(t, "storage-box.test.example.com.", cname.Target)
+	assert.Equal(t, uint32(300), cname.Hdr.Ttl)
+
+	assert.Equal(t, "files.test.example.com.", msg.Ns[2].Header().Name)
+	assert.Equal(t, dns.TypeCNAME, msg.Ns[2].Header().Rrtype)
+	assert.Equal(t, uint16(dns.ClassANY), msg.Ns[2].Header().Class)
+}
+
+func TestBuildZoneUpdateTXT(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	records := []DNSRecord{{Name: "laptop", Value: long, TTL: 300, Type: "TXT"}}
+	stale := []DNSRecord{{Name: "phone", Value: "id=n2", TTL: 300, Type: "TXT"}}
+
+	msg := buildZoneUpdate("test.example.com", records, stale)
+	require.Len(t, msg.Ns, 3)
+
+	// Values longer than 255 bytes are split into several strings
+	txt, ok := msg.Ns[1].(*dns.TXT)
+	require.True(t, ok)
+	assert.Equal(t, []string{long[:255], long[255:]}, txt.Txt)
+
+	// Stale TXT records are deleted by value, leaving other TXT records at the name alone
+	removal, ok := msg.Ns[2].(*dns.TXT)
+	require.True(t, ok)
+	assert.Equal(t, uint16(dns.ClassNONE), removal.Hdr.Class)
+	assert.Equal(t, []string{"id=n2"}, removal.Txt)
+}
+
+// tsigCheckingHandler answers every request, refusing those whose TSIG signature did not verify the way BIND does:
+// with NOTAUTH and an unsigned TSIG record carrying BADKEY
+func tsigCheckingHandler(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	if tsig := r.IsTsig(); tsig == nil || w.TsigStatus() != nil {
+		m.Rcode = dns.RcodeNotAuth
+		if tsig != nil {
+			m.Extra = append(m.Extra, &dns.TSIG{
+				Hdr:        dns.RR_Header{Name: tsig.Hdr.Name, Rrtype: dns.TypeTSIG, Class: dns.ClassANY},
+				Algorithm:  tsig.Algorithm,
+				TimeSigned: tsig.TimeSigned,
+				Fudge:      tsig.Fudge,
+				OrigId:     r.Id,
+				Error:      dns.RcodeBadKey,
+			})
+			// Written raw, the server would otherwise try to sign it with the unknown key
+			wire, _ := m.Pack()
+			_, _ = w.Write(wire)
+			return
+		}
+	}
+	_ = w.WriteMsg(m)
+}
+
+func TestProbeUpdateAndSetTSIGKey(t *testing.T) {
+	host, port := startTestDNSServer(t, tsigCheckingHandler)
+
+	client, err := NewClient(host, port, "test.example.com", "old-key.", testTSIGSecret, "hmac-sha256", 300*time.Second, nil)
+	require.NoError(t, err)
+
+	// The server doesn't know the old key
+	err = client.ProbeUpdate(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "NOTAUTH")
+	assert.ErrorIs(t, err, ErrTSIGBadKey)
+	assert.NotErrorIs(t, err, ErrZoneNotAuthoritative)
+
+	// Invalid keys are rejected without touching the current key
+	assert.Error(t, client.SetTSIGKey("test-key.", testTSIGSecret, "hmac-bogus"))
+	assert.Error(t, client.SetTSIGKey("", testTSIGSecret, "hmac-sha256"))
+	key, err := client.createTSIGKey()
+	require.NoError(t, err)
+	assert.Equal(t, "old-key.", key.Hdr.Name)
+
+	require.NoError(t, client.SetTSIGKey("test-key.", testTSIGSecret, "hmac-sha256"))
+	assert.NoError(t, client.ProbeUpdate(context.Background()))
+}
+
+func TestDiffRecords(t *testing.T) {
+	previous := []DNSRecord{
+		{Name: "keep", Type: "A", Value: "100.64.0.1", TTL: 300},
+		{Name: "change", Type: "A", Value: "100.64.0.2", TTL: 300},
+		{Name: "ttl", Type: "A", Value: "100.64.0.3", TTL: 300},
+		{Name: "gone", Type: "A", Value: "100.64.0.4", TTL: 300},
+	}
+	desired := []DNSRecord{
+		{Name: "new", Type: "A", Value: "100.64.0.5", TTL: 300},
+		{Name: "ttl", Type: "A", Value: "100.64.0.3", TTL: 60},
+		{Name: "change", Type: "A", Value: "100.64.0.6", TTL: 300},
+		{Name: "keep", Type: "A", Value: "100.64.0.1", TTL: 300},
+	}
+
+	diff := DiffRecords(previous, desired)
+	assert.Equal(t, []DNSRecord{{Name: "new", Type: "A", Value: "100.64.0.5", TTL: 300}}, diff.Added)
+	assert.Equal(t, []DNSRecord{{Name: "gone", Type: "A", Value: "100.64.0.4", TTL: 300}}, diff.Removed)
+	assert.Equal(t, []DNSRecord{
+		{Name: "change", Type: "A", Value: "100.64.0.6", TTL: 300},
+		{Name: "ttl", Type: "A", Value: "100.64.0.3", TTL: 60},
+	}, diff.Changed)
+	assert.False(t, diff.Empty())
+
+	assert.True(t, DiffRecords(desired, desired).Empty())
+
+	// A record set with several values is replaced as a whole when one of them changes
+	multiHomed := []DNSRecord{
+		{Name: "router", Type: "A", Value: "100.64.0.7", TTL: 300},
+		{Name: "router", Type: "A", Value: "192.168.1.1", TTL: 300},
+	}
+	assert.True(t, DiffRecords(multiHomed, []DNSRecord{multiHomed[1], multiHomed[0]}).Empty())
+	diff = DiffRecords(multiHomed, multiHomed[:1])
+	assert.Empty(t, diff.Removed)
+	assert.Equal(t, multiHomed[:1], diff.Changed)
+}
+
+func TestBuildZoneUpdateMultipleValues(t *testing.T) {
+	records := []DNSRecord{
+		{Name: "router", Value: "192.168.1.1", TTL: 300, Type: "A"},
+		{Name: "router", Value: "100.64.0.7", TTL: 300, Type: "A"},
+	}
+
+	// The record set is removed once, before both values are inserted
+	msg := buildZoneUpdate("test.example.com", records, nil)
+	require.Len(t, msg.Ns, 3)
+	assert.Equal(t, uint16(dns.ClassANY), msg.Ns[0].Header().Class)
+	assert.Equal(t, "100.64.0.7", msg.Ns[1].(*dns.A).A.String())
+	assert.Equal(t, "192.168.1.1", msg.Ns[2].(*dns.A).A.String())
+}
+
+func TestSerialAdvanced(t *testing.T) {
+	assert.True(t, serialAdvanced(1, 2))
+	assert.False(t, serialAdvanced(2, 2))
+	assert.False(t, serialAdvanced(2, 1))
+	// Serials wrap around according to RFC 1982
+	assert.True(t, serialAdvanced(0xffffffff, 1))
+}
+
+func TestUpdateRecordsVerification(t *testing.T) {
+	var serial, updateFailures, otherFailures atomic.Uint32
+	var applyUpdates, journalBroken, zoneStatistics atomic.Bool
+	serial.Store(100)
+	applyUpdates.Store(true)
+	zoneStatistics.Store(true)
+
+	host, port := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		switch r.Opcode {
+		case dns.OpcodeUpdate:
+			// Acknowledge every update but only bump the serial when it is "applied"
+			if applyUpdates.Load() {
+				serial.Add(1)
+			}
+			if journalBroken.Load() {
+				updateFailures.Add(1)
+			}
+		case dns.OpcodeQuery:
+			m.Answer = append(m.Answer, &dns.SOA{
+				Hdr:    dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 300},
+				Ns:     "ns.test.example.com.",
+				Mbox:   "admin.test.example.com.",
+				Serial: serial.Load(),
+			})
+		}
+		_ = w.WriteMsg(m)
+	})
+
+	stats := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, statisticsZonesPath, r.URL.Path)
+		zone := fmt.Sprintf(`{"name": "test.example.com", "rcodes": {"UpdateDone": 1, "UpdateFail": %d}}`,
+			updateFailures.Load())
+		if !zoneStatistics.Load() {
+			zone = `{"name": "test.example.com"}`
+		}
+		_, _ = fmt.Fprintf(w, `{"views": {"_default": {"zones": [%s, `+
+			`{"name": "other.example.com", "rcodes": {"UpdateFail": %d}}]}}}`, zone, otherFailures.Load())
+	}))
+	t.Cleanup(stats.Close)
+
+	client, err := NewClientFromConfig(&config.BindConfig{
+		Server:        host,
+		Port:          port,
+		Zone:          "test.example.com",
+		KeyName:       "test-key.",
+		KeySecret:     testTSIGSecret,
+		Algorithm:     "hmac-sha256",
+		TTL:           300 * time.Second,
+		VerifySerial:  true,
+		StatisticsURL: stats.URL,
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	records := []DNSRecord{{Name: "machine1", Value: "100.64.1.1", TTL: 300, Type: "A"}}
+
+	// The first update can't be verified since the zone's prior contents are unknown
+	_, err = client.UpdateRecords(ctx, records, false)
+	require.NoError(t, err)
+	assert.Zero(t, client.ZoneStatuses()["test.example.com"].Serial)
+
+	// Re-sending the same records doesn't expect the serial to move
+	applyUpdates.Store(false)
+	_, err = client.UpdateRecords(ctx, records, false)
+	require.NoError(t, err)
+
+	// A change that is applied advances the serial
+	applyUpdates.Store(true)
+	records[0].Value = "100.64.1.2"
+	_, err = client.UpdateRecords(ctx, records, false)
+	require.NoError(t, err)
+	status := client.ZoneStatuses()["test.example.com"]
+	assert.Equal(t, serial.Load(), status.Serial)
+	assert.Empty(t, status.LastError)
+
+	// A change that is acknowledged but not applied is flagged
+	applyUpdates.Store(false)
+	records[0].Value = "100.64.1.3"
+	_, err = client.UpdateRecords(ctx, records, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "SOA serial did not advance")
+	assert.Equal(t, 1, client.ZoneStatuses()["test.example.com"].Failures)
+
+	// Failed updates of other zones don't fail the update
+	client.verifySerial = false
+	otherFailures.Add(1)
+	_, err = client.UpdateRecords(ctx, records, false)
+	require.NoError(t, err)
+
+	// Without the counters of the zone the journal check is skipped
+	zoneStatistics.Store(false)
+	journalBroken.Store(true)
+	records[0].Value = "100.64.1.4"
+	_, err = client.UpdateRecords(ctx, records, false)
+	require.NoError(t, err)
+
+	// With serial verification off the journal check still catches the failure
+	zoneStatistics.Store(true)
+	records[0].Value = "100.64.1.5"
+	_, err = client.UpdateRecords(ctx, records, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "journal write errors")
+}
+
+func TestUpdateRecordsRemovesStale(t *testing.T) {
+	updates := make(chan *dns.Msg, 10)
+	host, port := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		updates <- r.Copy()
+		m := new(dns.Msg)
+		m.SetReply(r)
+		_ = w.WriteMsg(m)
+	})
+
+	client := &Client{
+		server:      host,
+		port:        port,
+		zone:        "test.example.com",
+		keyName:     "test-key.",
+		keySecret:   testTSIGSecret,
+		algorithm:   "hmac-sha256",
+		ttl:         300,
+		removeStale: true,
+		ptrConfig: &config.PTRConfig{
+			Enabled:        true,
+			IPv4Subnet:     "100.64.0.0/10",
+			IPv4SubnetSize: 16,
+		},
+	}
+
+	// deletions returns the names of the record sets removed by an update
+	deletions := func(msg *dns.Msg) []string {
+		var names []string
+		for _, rr := range msg.Ns {
+			if rr.Header().Class == dns.ClassANY {
+				names = append(names, dns.TypeToString[rr.Header().Rrtype]+" "+rr.Header().Name)
+			}
+		}
+		return names
+	}
+
+	ctx := context.Background()
+	_, err := client.UpdateRecords(ctx, []DNSRecord{
+		{Name: "machine1", Value: "100.64.1.1", TTL: 300, Type: "A"},
+		{Name: "machine2", Value: "100.64.1.2", TTL: 300, Type: "A"},
+		{Name: "2.1.64.100.in-addr.arpa.", Value: "machine2.test.example.com", TTL: 300, Type: "PTR"},
+	}, false)
+	require.NoError(t, err)
+	<-updates
+	<-updates
+
+	// machine2 went away, so its A and PTR records are removed in each zone's update while the unchanged machine1 is
+	// left alone
+	_, err = client.UpdateRecords(ctx, []DNSRecord{
+		{Name: "machine1", Value: "100.64.1.1", TTL: 300, Type: "A"},
+	}, false)
+	require.NoError(t, err)
+	ptrUpdate, forwardUpdate := <-updates, <-updates
+	assert.Equal(t, []string{"PTR 2.1.64.100.in-addr.arpa."}, deletions(ptrUpdate))
+	assert.Equal(t, []string{"A machine2.test.example.com."}, deletions(forwardUpdate))
+	assert.Len(t, forwardUpdate.Ns, 1)
+
+	// Once everything is gone the last records are removed too
+	_, err = client.UpdateRecords(ctx, nil, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"A machine1.test.example.com."}, deletions(<-updates))
+	assert.False(t, client.hasPublished())
+
+	// Nothing is left to do afterwards
+	_, err = client.UpdateRecords(ctx, nil, false)
+	require.NoError(t, err)
+	assert.Empty(t, updates)
+}
+
+func TestUpdateRecordsMaxRecordAge(t *testing.T) {
+	updates := make(chan *dns.Msg, 10)
+	host, port := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		updates <- r.Copy()
+		m := new(dns.Msg)
+		m.SetReply(r)
+		_ = w.WriteMsg(m)
+	})
+
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	client := &Client{
+		server:       host,
+		port:         port,
+		zone:         "test.example.com",
+		keyName:      "test-key.",
+		keySecret:    testTSIGSecret,
+		algorithm:    "hmac-sha256",
+		ttl:          300,
+		maxRecordAge: time.Hour,
+		clock:        clk,
+	}
+
+	deletions := func(msg *dns.Msg) []string {
+		var names []string
+		for _, rr := range msg.Ns {
+			if rr.Header().Class == dns.ClassANY {
+				names = append(names, dns.TypeToString[rr.Header().Rrtype]+" "+rr.Header().Name)
+			}
+		}
+		return names
+	}
+
+	machine1 := DNSRecord{Name: "machine1", Value: "100.64.1.1", TTL: 300, Type: "A"}
+	machine2 := DNSRecord{Name: "machine2", Value: "100.64.1.2", TTL: 300, Type: "A"}
+	ctx := context.Background()
+	_, err := client.UpdateRecords(ctx, []DNSRecord{machine1, machine2}, false)
+	require.NoError(t, err)
+	<-updates
+
+	// Without remove_stale, machine2 is kept while it is younger than the maximum age
+	clk.Advance(30 * time.Minute)
+	result, err := client.UpdateRecords(ctx, []DNSRecord{machine1}, false)
+	require.NoError(t, err)
+	assert.Empty(t, updates)
+	assert.Equal(t, 2, result.Zones[0].Records)
+
+	// machine1 keeps being refreshed, machine2 was last desired over an hour ago and is withdrawn
+	clk.Advance(31 * time.Minute)
+	_, err = client.UpdateRecords(ctx, []DNSRecord{machine1}, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"A machine2.test.example.com."}, deletions(<-updates))
+
+	// Records of zones without any desired records expire too
+	clk.Advance(61 * time.Minute)
+	_, err = client.UpdateRecords(ctx, nil, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"A machine1.test.example.com."}, deletions(<-updates))
+	assert.False(t, client.hasPublished())
+}
+
+func TestExpireLeases(t *testing.T) {
+	updates := make(chan *dns.Msg, 10)
+	host, port := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		updates <- r.Copy()
+		m := new(dns.Msg)
+		m.SetReply(r)
+		_ = w.WriteMsg(m)
+	})
+
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	client := &Client{
+		server:    host,
+		port:      port,
+		zone:      "test.example.com",
+		keyName:   "test-key.",
+		keySecret: testTSIGSecret,
+		algorithm: "hmac-sha256",
+		ttl:       300,
+		clock:     clk,
+	}
+	client.SetLease(time.Hour)
+
+	deletions := func(msg *dns.Msg) []string {
+		var names []string
+		for _, rr := range msg.Ns {
+			if rr.Header().Class == dns.ClassANY {
+				names = append(names, dns.TypeToString[rr.Header().Rrtype]+" "+rr.Header().Name)
+			}
+		}
+		return names
+	}
+
+	machine1 := DNSRecord{Name: "machine1", Value: "100.64.1.1", TTL: 300, Type: TypeA}
+	machine2 := DNSRecord{Name: "machine2", Value: "100.64.1.2", TTL: 300, Type: TypeA}
+	ctx := context.Background()
+	_, err := client.UpdateRecords(ctx, []DNSRecord{machine1, machine2}, false)
+	require.NoError(t, err)
+	<-updates
+
+	// machine2 is no longer desired but kept within its lease, nothing has expired yet
+	clk.Advance(30 * time.Minute)
+	_, err = client.UpdateRecords(ctx, []DNSRecord{machine1}, false)
+	require.NoError(t, err)
+	removed, err := client.ExpireLeases(ctx, false)
+	require.NoError(t, err)
+	assert.Zero(t, removed)
+	assert.Empty(t, updates)
+
+	// Without any further update, machine2's lease runs out while machine1's refreshed one still holds
+	clk.Advance(31 * time.Minute)
+	removed, err = client.ExpireLeases(ctx, true)
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.Empty(t, updates)
+	removed, err = client.ExpireLeases(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.Equal(t, []string{"A machine2.test.example.com."}, deletions(<-updates))
+
+	clk.Advance(30 * time.Minute)
+	removed, err = client.ExpireLeases(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.Equal(t, []string{"A machine1.test.example.com."}, deletions(<-updates))
+	assert.False(t, client.hasPublished())
+}
+
+func TestStateFile(t *testing.T) {
+	updates := make(chan *dns.Msg, 10)
+	host, port := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		updates <- r.Copy()
+		m := new(dns.Msg)
+		m.SetReply(r)
+		_ = w.WriteMsg(m)
+	})
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	newClient := func() *Client {
+		store, err := openStateStore(path)
+		require.NoError(t, err)
+		client := &Client{
+			server:      host,
+			port:        port,
+			zone:        "test.example.com",
+			keyName:     "test-key.",
+			keySecret:   testTSIGSecret,
+			algorithm:   "hmac-sha256",
+			ttl:         300,
+			removeStale: true,
+			clock:       clk,
+			state:       store,
+		}
+		client.recoverState()
+		client.SetDeviceLookup(func(record DNSRecord) string { return "device-" + record.Name })
+		return client
+	}
+
+	machine1 := DNSRecord{Name: "machine1", Value: "100.64.1.1", TTL: 300, Type: "A"}
+	machine2 := DNSRecord{Name: "machine2", Value: "100.64.1.2", TTL: 300, Type: "A"}
+	ctx := context.Background()
+	client := newClient()
+	_, err := client.UpdateRecords(ctx, []DNSRecord{machine1, machine2}, false)
+	require.NoError(t, err)
+	<-updates
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var content stateContent
+	require.NoError(t, json.Unmarshal(data, &content))
+	assert.Equal(t, []stateEntry{
+		{
+			Zone: "test.example.com", Record: machine1, Device: "device-machine1",
+			Created: clk.Now(), Refreshed: clk.Now(),
+		},
+		{
+			Zone: "test.example.com", Record: machine2, Device: "device-machine2",
+			Created: clk.Now(), Refreshed: clk.Now(),
+		},
+	}, content.Records)
+
+	// machine2 disappeared while the process was stopped: the first update after the restart still sends every desired
+	// record and removes the records of machine2
+	clk.Advance(time.Hour)
+	client = newClient()
+	assert.True(t, client.hasPublished())
+	_, err = client.UpdateRecords(ctx, []DNSRecord{machine1}, false)
+	require.NoError(t, err)
+	update := <-updates
+	assert.Equal(t, []string{"machine1.test.example.com."}, insertedNames(update))
+	var removed []string
+	for _, rr := range update.Ns {
+		if rr.Header().Class == dns.ClassANY && rr.Header().Name == "machine2.test.example.com." {
+			removed = append(removed, rr.Header().Name)
+		}
+	}
+	assert.Equal(t, []string{"machine2.test.example.com."}, removed)
+
+	// Records keep when they were first published
+	store, err := openStateStore(path)
+	require.NoError(t, err)
+	assert.Equal(t, []stateEntry{{
+		Zone:      "test.example.com",
+		Record:    machine1,
+		Device:    "device-machine1",
+		Created:   clk.Now().Add(-time.Hour),
+		Refreshed: clk.Now(),
+	}}, store.zone("test.example.com"))
+
+	// Without a state file nothing is recovered, a broken one is refused
+	_, err = openStateStore(filepath.Join(t.TempDir(), "missing.json"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, []byte(`{"version": 2}`), 0o600))
+	_, err = openStateStore(path)
+	assert.ErrorContains(t, err, "unsupported version 2")
+}
+
+func TestRemoveAll(t *testing.T) {
+	updates := make(chan *dns.Msg, 10)
+	host, port := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		updates <- r.Copy()
+		m := new(dns.Msg)
+		m.SetReply(r)
+		_ = w.WriteMsg(m)
+	})
+
+	queueFile := filepath.Join(t.TempDir(), "queue.json")
+	client := &Client{
+		server:    host,
+		port:      port,
+		zone:      "test.example.com",
+		keyName:   "test-key.",
+		keySecret: testTSIGSecret,
+		algorithm: "hmac-sha256",
+		ttl:       300,
+		queueFile: queueFile,
+		ptrConfig: &config.PTRConfig{Enabled: true, IPv4Subnet: "100.64.0.0/10", IPv4SubnetSize: 24},
+	}
+
+	ctx := context.Background()
+	_, err := client.UpdateRecords(ctx, []DNSRecord{
+		{Name: "machine1", Value: "100.64.1.1", TTL: 300, Type: "A"},
+		{Name: "machine2", Value: "100.64.1.2", TTL: 300, Type: "A"},
+		{Name: "1.1.64.100.in-addr.arpa.", Value: "machine1.test.example.com.", TTL: 300, Type: "PTR"},
+	}, false)
+	require.NoError(t, err)
+	<-updates
+	<-updates
+	require.NoError(t, client.saveQueue([]DNSRecord{{Name: "machine3", Value: "100.64.1.3", TTL: 300, Type: "A"}}))
+
+	// A dry run only counts the records
+	removed, err := client.RemoveAll(ctx, true)
+	require.NoError(t, err)
+	assert.Equal(t, 3, removed)
+	assert.Empty(t, updates)
+
+	// Records are removed even without remove_stale, every zone in an update of its own
+	removed, err = client.RemoveAll(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, 3, removed)
+	var deleted []string
+	for range 2 {
+		update := <-updates
+		assert.Empty(t, insertedNames(update))
+		for _, rr := range update.Ns {
+			if rr.Header().Class == dns.ClassANY {
+				deleted = append(deleted, rr.Header().Name)
+			}
+		}
+	}
+	assert.ElementsMatch(t, []string{
+		"machine1.test.example.com.", "machine2.test.example.com.", "1.1.64.100.in-addr.arpa.",
+	}, deleted)
+	assert.False(t, client.hasPublished())
+	assert.NoFileExists(t, queueFile)
+
+	// Nothing is left to remove
+	removed, err = client.RemoveAll(ctx, false)
+	require.NoError(t, err)
+	assert.Zero(t, removed)
+	assert.Empty(t, updates)
+}
+
+func TestUpdateRecordsSendsOnlyChanges(t *testing.T) {
+	updates := make(chan *dns.Msg, 10)
+	host, port := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		updates <- r.Copy()
+		m := new(dns.Msg)
+		m.SetReply(r)
+		_ = w.WriteMsg(m)
+	})
+
+	client := &Client{
+		server:    host,
+		port:      port,
+		zone:      "test.example.com",
+		keyName:   "test-key.",
+		keySecret: testTSIGSecret,
+		algorithm: "hmac-sha256",
+		ttl:       300,
+	}
+
+	// sent returns the records inserted by an update
+	sent := func(msg *dns.Msg) []string {
+		var records []string
+		for _, rr := range msg.Ns {
+			if rr.Header().Class == dns.ClassINET {
+				records = append(records, rr.String())
+			}
+		}
+		return records
+	}
+
+	ctx := context.Background()
+	records := []DNSRecord{
+		{Name: "machine1", Value: "100.64.1.1", TTL: 300, Type: "A"},
+		{Name: "machine2", Value: "100.64.1.2", TTL: 300, Type: "A"},
+	}
+
+	// The first update sends everything, since the zone's contents are unknown
+	_, err := client.UpdateRecords(ctx, records, false)
+	require.NoError(t, err)
+	assert.Len(t, sent(<-updates), 2)
+
+	// Nothing changed, so nothing is sent
+	_, err = client.UpdateRecords(ctx, records, false)
+	require.NoError(t, err)
+	assert.Empty(t, updates)
+
+	// Only the changed and the added records are sent
+	records = []DNSRecord{
+		{Name: "machine1", Value: "100.64.1.1", TTL: 300, Type: "A"},
+		{Name: "machine2", Value: "100.64.1.22", TTL: 300, Type: "A"},
+		{Name: "machine3", Value: "100.64.1.3", TTL: 60, Type: "A"},
+	}
+	_, err = client.UpdateRecords(ctx, records, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"machine2.test.example.com.\t300\tIN\tA\t100.64.1.22",
+		"machine3.test.example.com.\t60\tIN\tA\t100.64.1.3",
+	}, sent(<-updates))
+}
+
+func TestQueryBeforeUpdate(t *testing.T) {
+	served := map[string][]dns.RR{}
+	for _, rr := range []string{
+		"machine1.test.example.com. 300 IN A 100.64.1.1",
+		"machine2.test.example.com. 60 IN A 100.64.1.2",
+		"machine3.test.example.com. 300 IN A 100.64.1.3",
+		"machine3.test.example.com. 300 IN A 100.64.1.33",
+		"1.1.64.100.in-addr.arpa. 300 IN PTR Machine1.test.example.com.",
+	} {
+		parsed, err := dns.NewRR(rr)
+		require.NoError(t, err)
+		key := dns.TypeToString[parsed.Header().Rrtype] + " " + parsed.Header().Name
+		served[key] = append(served[key], parsed)
+	}
+
+	updates := make(chan *dns.Msg, 10)
+	host, port := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if r.Opcode == dns.OpcodeUpdate {
+			updates <- r.Copy()
+		} else {
+			m.Answer = served[dns.TypeToString[r.Question[0].Qtype]+" "+r.Question[0].Name]
+		}
+		_ = w.WriteMsg(m)
+	})
+
+	client := &Client{
+		server:            host,
+		port:              port,
+		zone:              "test.example.com",
+		keyName:           "test-key.",
+		keySecret:         testTSIGSecret,
+		algorithm:         "hmac-sha256",
+		ttl:               300,
+		queryBeforeUpdate: true,
+		ptrConfig: &config.PTRConfig{
+			Enabled:        true,
+			IPv4Subnet:     "100.64.0.0/10",
+			IPv4SubnetSize: 16,
+		},
+	}
+
+	records := []DNSRecord{
+		{Name: "machine1", Value: "100.64.1.1", TTL: 300, Type: "A"},
+		{Name: "machine2", Value: "100.64.1.2", TTL: 300, Type: "A"},
+		{Name: "machine3", Value: "100.64.1.3", TTL: 300, Type: "A"},
+		{Name: "machine4", Value: "fd7a:115c:a1e0::4", TTL: 300, Type: "AAAA"},
+		{Name: "1.1.64.100.in-addr.arpa.", Value: "machine1.test.example.com", TTL: 300, Type: "PTR"},
+	}
+	_, err := client.UpdateRecords(context.Background(), records, false)
+	require.NoError(t, err)
+
+	// The PTR zone already matches, only the forward records that differ are sent
+	require.Len(t, updates, 1)
+	var inserted []string
+	for _, rr := range (<-updates).Ns {
+		if rr.Header().Class == dns.ClassINET {
+			inserted = append(inserted, rr.Header().Name)
+		}
+	}
+	assert.Equal(t, []string{"machine2.test.example.com.", "machine3.test.example.com.", "machine4.test.example.com."},
+		inserted)
+
+	// Skipped records still count as ours, so nothing is sent again
+	_, err = client.UpdateRecords(context.Background(), records, false)
+	require.NoError(t, err)
+	assert.Empty(t, updates)
+	assert.Len(t, client.publishedRecords(), 5)
+}
+
+func TestRRMatches(t *testing.T) {
+	tests := []struct {
+		name      string
+		rr        string
+		record    DNSRecord
+		tolerance time.Duration
+		want      bool
+	}{
+		{
+			name:   "identical",
+			rr:     "laptop.test.example.com. 300 IN A 100.64.0.1",
+			record: DNSRecord{Name: "laptop", Value: "100.64.0.1", TTL: 300, Type: "A"},
+			want:   true,
+		},
+		{
+			name:   "different TTL",
+			rr:     "laptop.test.example.com. 240 IN A 100.64.0.1",
+			record: DNSRecord{Name: "laptop", Value: "100.64.0.1", TTL: 300, Type: "A"},
+		},
+		{
+			name:      "TTL within tolerance",
+			rr:        "laptop.test.example.com. 240 IN A 100.64.0.1",
+			record:    DNSRecord{Name: "laptop", Value: "100.64.0.1", TTL: 300, Type: "A"},
+			tolerance: time.Minute,
+			want:      true,
+		},
+		{
+			name:      "TTL beyond tolerance",
+			rr:        "laptop.test.example.com. 3600 IN A 100.64.0.1",
+			record:    DNSRecord{Name: "laptop", Value: "100.64.0.1", TTL: 300, Type: "A"},
+			tolerance: time.Minute,
+		},
+		{
+			name:   "target differing in case and trailing dot",
+			rr:     "1.0.64.100.in-addr.arpa. 300 IN PTR Laptop.Test.Example.com.",
+			record: DNSRecord{Name: "1.0.64.100.in-addr.arpa.", Value: "laptop.test.example.com", TTL: 300, Type: "PTR"},
+			want:   true,
+		},
+		{
+			name:   "different value",
+			rr:     "nas.test.example.com. 300 IN CNAME storage.test.example.com.",
+			record: DNSRecord{Name: "nas", Value: "backup.test.example.com", TTL: 300, Type: "CNAME"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr, err := dns.NewRR(tt.rr)
+			require.NoError(t, err)
+			client := &Client{ttlTolerance: tt.tolerance}
+			assert.Equal(t, tt.want, client.rrMatches(rr, tt.record))
+		})
+	}
+}
+
+func TestClientConcurrentUse(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	host, port := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		if r.Opcode == dns.OpcodeUpdate {
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			if n > maxInFlight.Load() {
+				maxInFlight.Store(n)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		m := new(dns.Msg)
+		m.SetReply(r)
+		_ = w.WriteMsg(m)
+	})
+
+	client := &Client{
+		server:      host,
+		port:        port,
+		zone:        "test.example.com",
+		keyName:     "test-key.",
+		keySecret:   testTSIGSecret,
+		algorithm:   "hmac-sha256",
+		ttl:         300,
+		removeStale: true,
+	}
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			records := []DNSRecord{{Name: fmt.Sprintf("machine%d", i), Value: "100.64.1.1", TTL: 300, Type: "A"}}
+			_, err := client.UpdateRecords(ctx, records, false)
+			assert.NoError(t, err)
+			assert.NoError(t, client.SetTSIGKey("test-key.", testTSIGSecret, "hmac-sha256"))
+			_ = client.ZoneStatuses()
+			_ = client.publishedRecords()
+		}()
+	}
+	wg.Wait()
+
+	// Updates were sent one at a time and every update replaced the records of the previous one
+	assert.Equal(t, int32(1), maxInFlight.Load())
+	assert.Len(t, client.publishedRecords(), 1)
+	assert.Zero(t, client.ZoneStatuses()["test.example.com"].Failures)
+}
+
+func TestReverseNameToIP(t *testing.T) {
+	assert.Equal(t, "100.64.1.2", reverseNameToIP("2.1.64.100.in-addr.arpa.").String())
+	assert.Equal(t, "fd7a:115c:a1e0::1", reverseNameToIP(ipv6ToReverseDNS("fd7a:115c:a1e0::1")).String())
+	assert.Nil(t, reverseNameToIP("1.64.100.in-addr.arpa."))
+	assert.Nil(t, reverseNameToIP("machine1.test.example.com."))
+}
+
+func TestCheckConsistency(t *testing.T) {
+	served := map[string]dns.RR{}
+	for _, rr := range []string{
+		"machine1.test.example.com. 300 IN A 100.64.1.1",
+		"1.1.64.100.in-addr.arpa. 300 IN PTR machine1.test.example.com.",
+		"3.1.64.100.in-addr.arpa. 300 IN PTR machine3.test.example.com.",
+	} {
+		parsed, err := dns.NewRR(rr)
+		require.NoError(t, err)
+		served[dns.TypeToString[parsed.Header().Rrtype]+" "+parsed.Header().Name] = parsed
+	}
+
+	updates := make(chan *dns.Msg, 10)
+	host, port := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if r.Opcode == dns.OpcodeUpdate {
+			updates <- r.Copy()
+		} else if rr, ok := served[dns.TypeToString[r.Question[0].Qtype]+" "+r.Question[0].Name]; ok {
+			m.Answer = append(m.Answer, rr)
+		} else {
+			m.Rcode = dns.RcodeNameError
+		}
+		_ = w.WriteMsg(m)
+	})
+
+	client := &Client{
+		server:    host,
+		port:      port,
+		zone:      "test.example.com",
+		keyName:   "test-key.",
+		keySecret: testTSIGSecret,
+		algorithm: "hmac-sha256",
+		ttl:       300,
+		ptrConfig: &config.PTRConfig{
+			Enabled:        true,
+			IPv4Subnet:     "100.64.0.0/10",
+			IPv4SubnetSize: 16,
+		},
+	}
+	client.markPublished("test.example.com", []DNSRecord{
+		{Name: "machine1", Value: "100.64.1.1", TTL: 300, Type: "A"},
+		{Name: "machine2", Value: "100.64.1.2", TTL: 300, Type: "A"},
+	})
+	client.markPublished("64.100.in-addr.arpa", []DNSRecord{
+		{Name: "1.1.64.100.in-addr.arpa.", Value: "machine1.test.example.com", TTL: 300, Type: "PTR"},
+		{Name: "3.1.64.100.in-addr.arpa.", Value: "machine3.test.example.com", TTL: 300, Type: "PTR"},
+	})
+
+	ctx := context.Background()
+	report, err := client.CheckConsistency(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, 4, report.Checked)
+	require.Len(t, report.Mismatches, 2)
+	assert.Equal(t, "3.1.64.100.in-addr.arpa.", report.Mismatches[0].Record.Name)
+	assert.Equal(t, &DNSRecord{Name: "machine3", Value: "100.64.1.3", TTL: 300, Type: "A"}, report.Mismatches[0].Repair)
+	assert.Equal(t, "machine2", report.Mismatches[1].Record.Name)
+	assert.Equal(t, "2.1.64.100.in-addr.arpa.", report.Mismatches[1].Repair.Name)
+	assert.Zero(t, report.Repaired)
+	assert.Empty(t, updates)
+
+	report, err = client.CheckConsistency(ctx, true)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Repaired)
+	assert.Equal(t, "64.100.in-addr.arpa.", (<-updates).Question[0].Name)
+	assert.Equal(t, "test.example.com.", (<-updates).Question[0].Name)
+
+	// Repairs don't change which records the client owns
+	assert.Len(t, client.publishedRecords(), 4)
+}
+
+// startTestTCPDNSServer starts a TCP DNS server on the given localhost port that answers with the given handler
+func startTestTCPDNSServer(t *testing.T, port int, handler dns.HandlerFunc) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatalf("failed to listen for test TCP DNS server: %v", err)
+	}
+
+	started := make(chan struct{})
+	server := &dns.Server{
+		Listener:          listener,
+		Handler:           handler,
+		TsigSecret:        map[string]string{"test-key.": testTSIGSecret},
+		MsgAcceptFunc:     func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go func() {
+		_ = server.ActivateAndServe()
+	}()
+	<-started
+	t.Cleanup(func() {
+		_ = server.Shutdown()
+	})
+}
+
+func TestExchangeTransport(t *testing.T) {
+	var udpRequests, tcpRequests atomic.Int32
+	host, port := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		udpRequests.Add(1)
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Truncated = true
+		_ = w.WriteMsg(m)
+	})
+	startTestTCPDNSServer(t, port, func(w dns.ResponseWriter, r *dns.Msg) {
+		tcpRequests.Add(1)
+		tsigCheckingHandler(w, r)
+	})
+
+	manyRecords := make([]DNSRecord, 0, 200)
+	for i := range 200 {
+		manyRecords = append(manyRecords, DNSRecord{
+			Name:  fmt.Sprintf("machine%d", i),
+			Value: fmt.Sprintf("100.64.%d.%d", i/250, i%250+1),
+			TTL:   300,
+			Type:  "A",
+		})
+	}
+
+	tests := []struct {
+		name      string
+		transport string
+		records   []DNSRecord
+		wantUDP   int32
+		wantTCP   int32
+	}{
+		{
+			name:    "udp retries truncated responses over tcp",
+			records: []DNSRecord{{Name: "machine1", Value: "100.64.1.1", TTL: 300, Type: "A"}},
+			wantUDP: 1,
+			wantTCP: 1,
+		},
+		{
+			name:      "tcp never uses udp",
+			transport: config.TransportTCP,
+			records:   []DNSRecord{{Name: "machine1", Value: "100.64.1.1", TTL: 300, Type: "A"}},
+			wantUDP:   0,
+			wantTCP:   1,
+		},
+		{
+			name:      "large udp updates go straight to tcp",
+			transport: config.TransportUDP,
+			records:   manyRecords,
+			wantUDP:   0,
+			wantTCP:   1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			udpRequests.Store(0)
+			tcpRequests.Store(0)
+
+			client, err := NewClient(host, port, "test.example.com", "test-key.", testTSIGSecret, "hmac-sha256",
+				300*time.Second, nil)
+			require.NoError(t, err)
+			client.transport = tt.transport
+
+			_, err = client.UpdateRecords(context.Background(), tt.records, false)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantUDP, udpRequests.Load())
+			assert.Equal(t, tt.wantTCP, tcpRequests.Load())
+		})
+	}
+}
+
+// writeTestCertificate issues a certificate for name signed by the CA (self-signed when ca is nil) and writes it and
+// its key as PEM files to dir
+func writeTestCertificate(
+	t *testing.T,
+	dir, name string,
+	ca *x509.Certificate,
+	caKey *ecdsa.PrivateKey,
+) (*x509.Certificate, *ecdsa.PrivateKey, string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	parent, signer := template, key
+	if ca == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		parent, signer = ca, caKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return cert, key, certFile, keyFile
+}
+
+func TestDNSOverTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey, caFile, _ := writeTestCertificate(t, dir, "test-ca", nil, nil)
+	_, _, serverCert, serverKey := writeTestCertificate(t, dir, "dns.test", ca, caKey)
+	_, _, clientCert, clientKey := writeTestCertificate(t, dir, "ddns-client", ca, caKey)
+
+	serverPair, err := tls.LoadX509KeyPair(serverCert, serverKey)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverPair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	})
+	require.NoError(t, err)
+
+	started := make(chan struct{})
+	server := &dns.Server{
+		Listener:          listener,
+		Net:               "tcp-tls",
+		Handler:           dns.HandlerFunc(tsigCheckingHandler),
+		TsigSecret:        map[string]string{"test-key.": testTSIGSecret},
+		MsgAcceptFunc:     func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go func() {
+		_ = server.ActivateAndServe()
+	}()
+	<-started
+	t.Cleanup(func() {
+		_ = server.Shutdown()
+	})
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	tests := []struct {
+		name    string
+		tls     config.TLSConfig
+		wantErr bool
+	}{
+		{
+			name: "trusted CA, SNI and client certificate",
+			tls:  config.TLSConfig{CAFile: caFile, CertFile: clientCert, KeyFile: clientKey, ServerName: "dns.test"},
+		},
+		{
+			name:    "server name not in certificate",
+			tls:     config.TLSConfig{CAFile: caFile, CertFile: clientCert, KeyFile: clientKey},
+			wantErr: true,
+		},
+		{
+			name:    "untrusted server",
+			tls:     config.TLSConfig{CertFile: clientCert, KeyFile: clientKey, ServerName: "dns.test"},
+			wantErr: true,
+		},
+		{
+			name:    "missing client certificate",
+			tls:     config.TLSConfig{CAFile: caFile, ServerName: "dns.test"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewClientFromConfig(&config.BindConfig{
+				Server:    "127.0.0.1",
+				Port:      port,
+				Zone:      "test.example.com",
+				KeyName:   "test-key.",
+				KeySecret: testTSIGSecret,
+				Algorithm: "hmac-sha256",
+				TTL:       300 * time.Second,
+				Transport: config.TransportTCPTLS,
+				TLS:       tt.tls,
+			})
+			require.NoError(t, err)
+
+			err = client.ProbeUpdate(context.Background())
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	_, err = NewClientFromConfig(&config.BindConfig{
+		Server:    "127.0.0.1",
+		Zone:      "test.example.com",
+		KeyName:   "test-key.",
+		KeySecret: testTSIGSecret,
+		Transport: config.TransportTCPTLS,
+		TLS:       config.TLSConfig{CAFile: filepath.Join(dir, "missing.pem")},
+	})
+	assert.Error(t, err)
+}
+
+func TestRcodeErrorKinds(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       *RcodeError
+		wantKind  error
+		notKinds  []error
+		wantInMsg string
+	}{
+		{
+			name:      "not authoritative",
+			err:       &RcodeError{Rcode: dns.RcodeNotAuth},
+			wantKind:  ErrZoneNotAuthoritative,
+			notKinds:  []error{ErrTSIGBadKey, ErrRefused},
+			wantInMsg: "NOTAUTH",
+		},
+		{
+			name:      "not in zone",
+			err:       &RcodeError{Rcode: dns.RcodeNotZone},
+			wantKind:  ErrZoneNotAuthoritative,
+			notKinds:  []error{ErrTSIGBadKey},
+			wantInMsg: "NOTZONE",
+		},
+		{
+			name:      "bad signature",
+			err:       &RcodeError{Rcode: dns.RcodeNotAuth, TSIGError: dns.RcodeBadSig},
+			wantKind:  ErrTSIGBadKey,
+			notKinds:  []error{ErrZoneNotAuthoritative},
+			wantInMsg: "BADSIG",
+		},
+		{
+			name:      "refused",
+			err:       &RcodeError{Rcode: dns.RcodeRefused},
+			wantKind:  ErrRefused,
+			notKinds:  []error{ErrZoneNotAuthoritative, ErrTSIGBadKey},
+			wantInMsg: "REFUSED",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wrapped := fmt.Errorf("sending update to zone test.example.com: %w", tt.err)
+			assert.ErrorIs(t, wrapped, tt.wantKind)
+			for _, kind := range tt.notKinds {
+				assert.NotErrorIs(t, wrapped, kind)
+			}
+			assert.Contains(t, wrapped.Error(), tt.wantInMsg)
+		})
+	}
+}
+
+func TestZoneGroups(t *testing.T) {
+	zones := []string{"tailscale.example.com", "64.100.in-addr.arpa", "0.e.1.a.1.c.5.1.1.a.7.d.f.ip6.arpa", "alt.example.com"}
+
+	tests := []struct {
+		order string
+		want  [][]string
+	}{
+		{
+			order: config.ZoneOrderName,
+			want: [][]string{{"0.e.1.a.1.c.5.1.1.a.7.d.f.ip6.arpa", "64.100.in-addr.arpa", "alt.example.com",
+				"tailscale.example.com"}},
+		},
+		{
+			order: config.ZoneOrderForwardFirst,
+			want: [][]string{
+				{"alt.example.com", "tailscale.example.com"},
+				{"0.e.1.a.1.c.5.1.1.a.7.d.f.ip6.arpa", "64.100.in-addr.arpa"},
+			},
+		},
+		{
+			order: config.ZoneOrderReverseFirst,
+			want: [][]string{
+				{"0.e.1.a.1.c.5.1.1.a.7.d.f.ip6.arpa", "64.100.in-addr.arpa"},
+				{"alt.example.com", "tailscale.example.com"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			client := &Client{zoneOrder: tt.order}
+			assert.Equal(t, tt.want, client.zoneGroups(zones))
+		})
+	}
+
+	// Groups without zones are dropped
+	client := &Client{zoneOrder: config.ZoneOrderReverseFirst}
+	assert.Equal(t, [][]string{{"tailscale.example.com"}}, client.zoneGroups([]string{"tailscale.example.com"}))
+}
+
+func TestUpdateZones(t *testing.T) {
+	zones := []string{"tailscale.example.com", "alt.example.com", "64.100.in-addr.arpa", "10.in-addr.arpa"}
+
+	t.Run("groups finish before the next one starts", func(t *testing.T) {
+		client := &Client{zoneOrder: config.ZoneOrderForwardFirst, zoneConcurrency: 2}
+
+		var running, maxRunning atomic.Int32
+		var forwardDone atomic.Int32
+		errs := client.updateZones(zones, func(zone string) error {
+			n := running.Add(1)
+			defer running.Add(-1)
+			for {
+				m := maxRunning.Load()
+				if n <= m || maxRunning.CompareAndSwap(m, n) {
+					break
+				}
+			}
+
+			time.Sleep(10 * time.Millisecond)
+			if isReverseZone(zone) {
+				assert.Equal(t, int32(2), forwardDone.Load(), "reverse zone %s updated before forward zones", zone)
+			} else {
+				forwardDone.Add(1)
+			}
+			return nil
+		})
+
+		assert.Empty(t, errs)
+		assert.Equal(t, int32(2), maxRunning.Load())
+	})
+
+	t.Run("failures don't stop other zones", func(t *testing.T) {
+		client := &Client{}
+
+		var updated []string
+		errs := client.updateZones(zones, func(zone string) error {
+			updated = append(updated, zone)
+			if zone == "alt.example.com" {
+				return fmt.Errorf("refused")
+			}
+			return nil
+		})
+
+		require.Len(t, errs, 1)
+		assert.ErrorContains(t, errs[0], "sending update to zone alt.example.com")
+		assert.Equal(t, []string{"10.in-addr.arpa", "64.100.in-addr.arpa", "alt.example.com", "tailscale.example.com"},
+			updated)
+	})
+
+	t.Run("bad key stops remaining zones", func(t *testing.T) {
+		client := &Client{zoneOrder: config.ZoneOrderForwardFirst}
+
+		var updated []string
+		errs := client.updateZones(zones, func(zone string) error {
+			updated = append(updated, zone)
+			return &RcodeError{Rcode: dns.RcodeNotAuth, TSIGError: dns.RcodeBadKey}
+		})
+
+		require.Len(t, errs, 1)
+		assert.ErrorIs(t, errs[0], ErrTSIGBadKey)
+		assert.Equal(t, []string{"alt.example.com"}, updated)
+	})
+}
+
+func TestOwnershipRegistry(t *testing.T) {
+	served := map[string][]dns.RR{}
+	for _, rr := range []string{
+		`ours.test.example.com. 300 IN TXT "heritage=tailscale-bind-ddns,tailscale-bind-ddns/owner=office"`,
+		`ours.test.example.com. 300 IN TXT "v=spf1 -all"`,
+		`other.test.example.com. 300 IN TXT "heritage=tailscale-bind-ddns,tailscale-bind-ddns/owner=lab"`,
+		"other.test.example.com. 300 IN A 100.64.9.3",
+		"manual.test.example.com. 300 IN AAAA fd7a:115c:a1e0::4",
+		`gone.test.example.com. 300 IN TXT "heritage=tailscale-bind-ddns,tailscale-bind-ddns/owner=office"`,
+		`stolen.test.example.com. 300 IN TXT "heritage=tailscale-bind-ddns,tailscale-bind-ddns/owner=lab"`,
+	} {
+		parsed, err := dns.NewRR(rr)
+		require.NoError(t, err)
+		key := dns.TypeToString[parsed.Header().Rrtype] + " " + parsed.Header().Name
+		served[key] = append(served[key], parsed)
+	}
+
+	updates := make(chan *dns.Msg, 10)
+	var queries atomic.Int32
+	host, port := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if r.Opcode == dns.OpcodeUpdate {
+			updates <- r.Copy()
+		} else {
+			queries.Add(1)
+			m.Answer = served[dns.TypeToString[r.Question[0].Qtype]+" "+r.Question[0].Name]
+		}
+		_ = w.WriteMsg(m)
+	})
+
+	client := &Client{
+		server:      host,
+		port:        port,
+		zone:        "test.example.com",
+		keyName:     "test-key.",
+		keySecret:   testTSIGSecret,
+		algorithm:   "hmac-sha256",
+		ttl:         300,
+		removeStale: true,
+		ownerID:     "office",
+	}
+	client.markPublished("test.example.com", []DNSRecord{
+		{Name: "gone", Value: "100.64.1.5", TTL: 300, Type: "A"},
+		{Name: "stolen", Value: "100.64.1.6", TTL: 300, Type: "A"},
+	})
+
+	ctx := context.Background()
+	_, err := client.UpdateRecords(ctx, []DNSRecord{
+		{Name: "ours", Value: "100.64.1.1", TTL: 300, Type: "A"},
+		{Name: "free", Value: "100.64.1.2", TTL: 300, Type: "A"},
+		{Name: "other", Value: "100.64.1.3", TTL: 300, Type: "A"},
+		{Name: "manual", Value: "100.64.1.4", TTL: 300, Type: "A"},
+	}, false)
+	require.NoError(t, err)
+
+	require.Len(t, updates, 1)
+	var update []string
+	for _, rr := range (<-updates).Ns {
+		update = append(update, rr.String())
+	}
+	assert.ElementsMatch(t, []string{
+		"free.test.example.com.\t0\tCLASS255\tA\t",
+		"free.test.example.com.\t300\tIN\tA\t100.64.1.2",
+		"ours.test.example.com.\t0\tCLASS255\tA\t",
+		"ours.test.example.com.\t300\tIN\tA\t100.64.1.1",
+		"gone.test.example.com.\t0\tCLASS255\tA\t",
+		"free.test.example.com.\t300\tIN\tTXT\t\"heritage=tailscale-bind-ddns,tailscale-bind-ddns/owner=office\"",
+		"ours.test.example.com.\t300\tIN\tTXT\t\"heritage=tailscale-bind-ddns,tailscale-bind-ddns/owner=office\"",
+		"gone.test.example.com.\t0\tNONE\tTXT\t\"heritage=tailscale-bind-ddns,tailscale-bind-ddns/owner=office\"",
+	}, update)
+
+	// Names confirmed as ours aren't looked up again, the two names we don't own are and are skipped again
+	queries.Store(0)
+	_, err = client.UpdateRecords(ctx, []DNSRecord{
+		{Name: "ours", Value: "100.64.1.1", TTL: 300, Type: "A"},
+		{Name: "free", Value: "100.64.1.2", TTL: 300, Type: "A"},
+		{Name: "other", Value: "100.64.1.3", TTL: 300, Type: "A"},
+		{Name: "manual", Value: "100.64.1.4", TTL: 300, Type: "A"},
+	}, false)
+	require.NoError(t, err)
+	assert.Empty(t, updates)
+	assert.Equal(t, int32(4), queries.Load())
+}
+
+func TestBootstrapPTR(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		served = map[string]dns.RR{}
+	)
+	existing, err := dns.NewRR("1.1.64.100.in-addr.arpa. 300 IN PTR manual.test.example.com.")
+	require.NoError(t, err)
+	served[existing.Header().Name] = existing
+
+	updates := make(chan *dns.Msg, 10)
+	host, port := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if r.Opcode == dns.OpcodeUpdate {
+			updates <- r.Copy()
+		} else {
+			mu.Lock()
+			rr, ok := served[r.Question[0].Name]
+			mu.Unlock()
+			if ok && r.Question[0].Qtype == dns.TypePTR {
+				m.Answer = append(m.Answer, rr)
+			}
+		}
+		_ = w.WriteMsg(m)
+	})
+
+	client := &Client{
+		server:      host,
+		port:        port,
+		zone:        "test.example.com",
+		keyName:     "test-key.",
+		keySecret:   testTSIGSecret,
+		algorithm:   "hmac-sha256",
+		ttl:         300,
+		removeStale: true,
+		ptrConfig: &config.PTRConfig{
+			Enabled:        true,
+			IPv4Subnet:     "100.64.0.0/10",
+			IPv4SubnetSize: 16,
+		},
+	}
+
+	records := []DNSRecord{
+		{Name: "machine1", Value: "100.64.1.1", TTL: 300, Type: "A"},
+		{Name: "1.1.64.100.in-addr.arpa.", Value: "machine1.test.example.com", TTL: 300, Type: "PTR"},
+		{Name: "2.1.64.100.in-addr.arpa.", Value: "machine2.test.example.com", TTL: 300, Type: "PTR"},
+	}
+
+	ctx := context.Background()
+	created, err := client.BootstrapPTR(ctx, records, true)
+	require.NoError(t, err)
+	assert.Zero(t, created)
+	assert.Empty(t, updates)
+
+	// Only the empty reverse name gets a record, guarded by a prerequisite that it is still empty
+	created, err = client.BootstrapPTR(ctx, records, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, created)
+	require.Len(t, updates, 1)
+	update := <-updates
+	assert.Equal(t, "64.100.in-addr.arpa.", update.Question[0].Name)
+	require.Len(t, update.Answer, 1)
+	assert.Equal(t, "2.1.64.100.in-addr.arpa.", update.Answer[0].Header().Name)
+	assert.Equal(t, uint16(dns.ClassNONE), update.Answer[0].Header().Class)
+	assert.Equal(t, []string{"2.1.64.100.in-addr.arpa."}, insertedNames(update))
+
+	// Bootstrapped records aren't tracked, so they are never removed as stale
+	assert.False(t, client.hasPublished())
+
+	// Once every name holds a PTR record nothing is sent
+	mu.Lock()
+	served["2.1.64.100.in-addr.arpa."] = update.Ns[len(update.Ns)-1]
+	mu.Unlock()
+	created, err = client.BootstrapPTR(ctx, records, false)
+	require.NoError(t, err)
+	assert.Zero(t, created)
+	assert.Empty(t, updates)
+}
+
+// insertedNames returns the names of the records an update message adds
+func insertedNames(msg *dns.Msg) []string {
+	var names []string
+	for _, rr := range msg.Ns {
+		if rr.Header().Class == dns.ClassINET {
+			names = append(names, rr.Header().Name)
+		}
+	}
+	return names
+}
+
+// unreachableServer returns a localhost address nothing listens on
+func unreachableServer(t *testing.T) (string, int) {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := pc.LocalAddr().(*net.UDPAddr)
+	require.NoError(t, pc.Close())
+	return addr.IP.String(), addr.Port
+}
+
+func TestServerFailover(t *testing.T) {
+	var received atomic.Int32
+	host, port := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		if r.Opcode == dns.OpcodeUpdate {
+			received.Add(1)
+		}
+		m := new(dns.Msg)
+		m.SetReply(r)
+		_ = w.WriteMsg(m)
+	})
+	downHost, downPort := unreachableServer(t)
+
+	client := &Client{
+		server:    downHost,
+		port:      downPort,
+		fallbacks: []server{{host: host, port: port}},
+		zone:      "test.example.com",
+		keyName:   "test-key.",
+		keySecret: testTSIGSecret,
+		algorithm: "hmac-sha256",
+		ttl:       300,
+	}
+
+	records := []DNSRecord{{Name: "machine1", Value: "100.64.1.1", TTL: 300, Type: "A"}}
+	_, err := client.UpdateRecords(context.Background(), records, false)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), received.Load())
+
+	health := client.ServerHealth()
+	require.Len(t, health, 2)
+	assert.False(t, health[0].Healthy)
+	assert.Equal(t, 1, health[0].Failures)
+	assert.NotEmpty(t, health[0].LastError)
+	assert.True(t, health[1].Healthy)
+
+	// The failed primary is tried last until the retry interval has passed
+	assert.Equal(t, host, client.serverOrder()[0].host)
+	records[0].Value = "100.64.1.2"
+	_, err = client.UpdateRecords(context.Background(), records, false)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), received.Load())
+	assert.Equal(t, 1, client.ServerHealth()[0].Failures)
+
+	// Once every server is unreachable the update fails
+	client.fallbacks[0].host, client.fallbacks[0].port = unreachableServer(t)
+	records[0].Value = "100.64.1.3"
+	_, err = client.UpdateRecords(context.Background(), records, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), client.fallbacks[0].address())
+}
+
+func TestUpdateAllServers(t *testing.T) {
+	var received [2]atomic.Int32
+	var servers []server
+	for i := range received {
+		host, port := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+			m := new(dns.Msg)
+			m.SetReply(r)
+			if r.Opcode == dns.OpcodeUpdate {
+				received[i].Add(1)
+				if i == 1 {
+					m.Rcode = dns.RcodeRefused
+				}
+			}
+			_ = w.WriteMsg(m)
+		})
+		servers = append(servers, server{host: host, port: port})
+	}
+
+	client := &Client{
+		server:           servers[0].host,
+		port:             servers[0].port,
+		fallbacks:        servers[1:],
+		updateAllServers: true,
+		zone:             "test.example.com",
+		keyName:          "test-key.",
+		keySecret:        testTSIGSecret,
+		algorithm:        "hmac-sha256",
+		ttl:              300,
+	}
+
+	// Every server gets the update, a server refusing it fails the update
+	records := []DNSRecord{{Name: "machine1", Value: "100.64.1.1", TTL: 300, Type: "A"}}
+	_, err := client.UpdateRecords(context.Background(), records, false)
+	require.ErrorIs(t, err, ErrRefused)
+	assert.Equal(t, int32(1), received[0].Load())
+	assert.Equal(t, int32(1), received[1].Load())
+}
+
+// testZone is the contents of a zone served by transferHandler, changes are served as an incremental transfer
+type testZone struct {
+	mu       sync.Mutex
+	serial   uint32
+	records  []dns.RR
+	previous []dns.RR // Records at serial-1
+}
+
+// set replaces the records of the zone, advancing its serial
+func (z *testZone) set(t *testing.T, records ...string) {
+	t.Helper()
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	z.previous = z.records
+	z.records = nil
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		require.NoError(t, err)
+		z.records = append(z.records, rr)
+	}
+	z.serial++
+}
+
+// soa returns the SOA record of the zone at a serial
+func (z *testZone) soa(serial uint32) dns.RR {
+	return &dns.SOA{
+		Hdr:    dns.RR_Header{Name: "test.example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 300},
+		Ns:     "ns1.example.com.",
+		Mbox:   "admin.example.com.",
+		Serial: serial,
+	}
+}
+
+// transferHandler answers transfers and queries for test.example.com from the zone and refuses transfers of any other
+// zone. Queries are counted by name.
+func transferHandler(zone *testZone, queries *sync.Map) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		zone.mu.Lock()
+		defer zone.mu.Unlock()
+
+		m := new(dns.Msg)
+		m.SetReply(r)
+		question := r.Question[0]
+		switch {
+		case question.Qtype != dns.TypeAXFR && question.Qtype != dns.TypeIXFR:
+			count, _ := queries.LoadOrStore(question.Name, new(atomic.Int32))
+			count.(*atomic.Int32).Add(1)
+			for _, rr := range zone.records {
+				if rr.Header().Name == question.Name && rr.Header().Rrtype == question.Qtype {
+					m.Answer = append(m.Answer, rr)
+				}
+			}
+		case r.IsTsig() == nil || w.TsigStatus() != nil:
+			m.Rcode = dns.RcodeNotAuth
+		case question.Name != "test.example.com.":
+			m.Rcode = dns.RcodeRefused
+		case question.Qtype == dns.TypeIXFR && r.Ns[0].(*dns.SOA).Serial == zone.serial:
+			m.Answer = []dns.RR{zone.soa(zone.serial)}
+		case question.Qtype == dns.TypeIXFR && r.Ns[0].(*dns.SOA).Serial == zone.serial-1:
+			m.Answer = append(m.Answer, zone.soa(zone.serial), zone.soa(zone.serial-1))
+			for _, rr := range zone.previous {
+				if !slices.ContainsFunc(zone.records, func(other dns.RR) bool { return other.String() == rr.String() }) {
+					m.Answer = append(m.Answer, rr)
+				}
+			}
+			m.Answer = append(m.Answer, zone.soa(zone.serial))
+			for _, rr := range zone.records {
+				if !slices.ContainsFunc(zone.previous, func(other dns.RR) bool { return other.String() == rr.String() }) {
+					m.Answer = append(m.Answer, rr)
+				}
+			}
+			m.Answer = append(m.Answer, zone.soa(zone.serial))
+		default:
+			m.Answer = append(m.Answer, zone.soa(zone.serial))
+			for _, rr := range zone.records {
+				if dns.IsSubDomain(question.Name, rr.Header().Name) {
+					m.Answer = append(m.Answer, rr)
+				}
+			}
+			m.Answer = append(m.Answer, zone.soa(zone.serial))
+		}
+		if tsig := r.IsTsig(); tsig != nil && w.TsigStatus() == nil {
+			m.SetTsig(tsig.Hdr.Name, tsig.Algorithm, tsig.Fudge, time.Now().Unix())
+		}
+		_ = w.WriteMsg(m)
+	}
+}
+
+func TestZoneTransfer(t *testing.T) {
+	zone := &testZone{}
+	zone.set(t,
+		"machine1.test.example.com. 300 IN A 100.64.1.1",
+		"machine2.test.example.com. 60 IN A 100.64.1.2",
+		"alias.test.example.com. 300 IN CNAME machine1.test.example.com.",
+	)
+	var queries sync.Map
+	host, port := startTestDNSServer(t, transferHandler(zone, &queries))
+	startTestTCPDNSServer(t, port, transferHandler(zone, &queries))
+
+	client := &Client{
+		server:            host,
+		port:              port,
+		zone:              "test.example.com",
+		keyName:           "test-key.",
+		keySecret:         testTSIGSecret,
+		algorithm:         "hmac-sha256",
+		ttl:               300,
+		queryBeforeUpdate: true,
+		zoneTransfer:      true,
+		ptrConfig: &config.PTRConfig{
+			Enabled:        true,
+			IPv4Subnet:     "100.64.0.0/10",
+			IPv4SubnetSize: 16,
+		},
+	}
+
+	// Forward records are read from the transfer, the reverse zone can't be transferred and is queried instead
+	records := []DNSRecord{
+		{Name: "machine1", Value: "100.64.1.1", TTL: 300, Type: "A"},
+		{Name: "machine2", Value: "100.64.1.2", TTL: 300, Type: "A"},
+		{Name: "1.1.64.100.in-addr.arpa.", Value: "machine1.test.example.com", TTL: 300, Type: "PTR"},
+	}
+	result, err := client.UpdateRecords(context.Background(), records, false)
+	require.NoError(t, err)
+	sent := 0
+	for _, zoneResult := range result.Zones {
+		sent += zoneResult.Sent
+	}
+	assert.Equal(t, 2, sent, "machine2 with its TTL and the missing PTR record are sent")
+	_, queried := queries.Load("machine1.test.example.com.")
+	assert.False(t, queried)
+	_, queried = queries.Load("1.1.64.100.in-addr.arpa.")
+	assert.True(t, queried)
+
+	reader := client.newZoneReader()
+	answers, err := reader.lookup(context.Background(), "Alias.test.example.com", dns.TypeA)
+	require.NoError(t, err)
+	require.Len(t, answers, 2, "aliases are followed within the zone")
+	assert.Equal(t, "100.64.1.1", answers[1].(*dns.A).A.String())
+
+	// Later transfers are incremental and only apply the changes
+	zone.set(t,
+		"machine1.test.example.com. 300 IN A 100.64.1.1",
+		"machine2.test.example.com. 300 IN A 100.64.1.2",
+		"machine3.test.example.com. 300 IN A 100.64.1.3",
+	)
+	snapshot, err := client.transferZone(context.Background(), "test.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2), snapshot.serial)
+	assert.Equal(t, []string{"machine1.test.example.com.", "machine2.test.example.com.", "machine3.test.example.com."},
+		slices.Sorted(maps.Keys(snapshot.records)))
+	assert.Equal(t, uint32(300), snapshot.records["machine2.test.example.com."][0].Header().Ttl)
+
+	// An unchanged zone keeps its records
+	snapshot, err = client.transferZone(context.Background(), "test.example.com")
+	require.NoError(t, err)
+	assert.Len(t, snapshot.records, 3)
+
+	_, err = client.transferZone(context.Background(), "64.100.in-addr.arpa")
+	require.ErrorIs(t, err, ErrTransferRefused)
+}
+
+func TestCheckConsistencyVerifyResolvers(t *testing.T) {
+	var serverQueries atomic.Int32
+	host, port := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		serverQueries.Add(1)
+		m := new(dns.Msg)
+		m.SetReply(r)
+		_ = w.WriteMsg(m)
+	})
+
+	// The first resolver fails, the second one still serves the previous address of machine1
+	failing, failingPort := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeServerFailure)
+		_ = w.WriteMsg(m)
+	})
+	cached, err := dns.NewRR("machine1.test.example.com. 300 IN A 100.64.1.9")
+	require.NoError(t, err)
+	resolverHost, resolverPort := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if r.Question[0].Name == "machine1.test.example.com." && r.Question[0].Qtype == dns.TypeA {
+			m.Answer = append(m.Answer, cached)
+		} else {
+			m.Rcode = dns.RcodeNameError
+		}
+		_ = w.WriteMsg(m)
+	})
+	resolver, err := NewRecursiveResolver([]string{
+		net.JoinHostPort(failing, strconv.Itoa(failingPort)),
+		net.JoinHostPort(resolverHost, strconv.Itoa(resolverPort)),
+	})
+	require.NoError(t, err)
+
+	client := &Client{
+		server:    host,
+		port:      port,
+		zone:      "test.example.com",
+		keyName:   "test-key.",
+		keySecret: testTSIGSecret,
+		algorithm: "hmac-sha256",
+		ttl:       300,
+		ptrConfig: &config.PTRConfig{
+			Enabled:        true,
+			IPv4Subnet:     "100.64.0.0/10",
+			IPv4SubnetSize: 16,
+		},
+	}
+	client.SetResolver(resolver)
+	client.markPublished("64.100.in-addr.arpa", []DNSRecord{
+		{Name: "1.1.64.100.in-addr.arpa.", Value: "machine1.test.example.com", TTL: 300, Type: "PTR"},
+	})
+
+	report, err := client.CheckConsistency(context.Background(), false)
+	require.NoError(t, err)
+	require.Len(t, report.Mismatches, 1)
+	assert.Equal(t, "1.1.64.100.in-addr.arpa.", report.Mismatches[0].Record.Name)
+	assert.Zero(t, serverQueries.Load(), "the update server isn't asked")
+
+	_, err = NewRecursiveResolver([]string{"[resolver.example.com]"})
+	require.Error(t, err)
+}
+
+func TestResolves(t *testing.T) {
+	served, err := dns.NewRR("machine1.test.example.com. 300 IN A 100.64.1.1")
+	require.NoError(t, err)
+	host, port := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if r.Question[0].Name == "machine1.test.example.com." && r.Question[0].Qtype == dns.TypeA {
+			m.Answer = append(m.Answer, served)
+		} else {
+			m.Rcode = dns.RcodeNameError
+		}
+		_ = w.WriteMsg(m)
+	})
+	client := &Client{server: host, port: port, zone: "test.example.com", ttl: 300}
+
+	tests := []struct {
+		name   string
+		record DNSRecord
+		want   bool
+	}{
+		{
+			name:   "served record",
+			record: DNSRecord{Name: "machine1", Value: "100.64.1.1", TTL: 60, Type: "A"},
+			want:   true,
+		},
+		{
+			name:   "other address",
+			record: DNSRecord{Name: "machine1", Value: "100.64.1.2", TTL: 300, Type: "A"},
+		},
+		{
+			name:   "missing name",
+			record: DNSRecord{Name: "machine2", Value: "100.64.1.2", TTL: 300, Type: "A"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolves, err := client.Resolves(context.Background(), tt.record)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, resolves)
+		})
+	}
+
+	_, err = client.Resolves(context.Background(), DNSRecord{Name: "machine1", Value: "invalid", Type: "A"})
+	require.Error(t, err)
+}
+
+func TestPrefetchZones(t *testing.T) {
+	zone := &testZone{}
+	zone.set(t, "machine1.test.example.com. 300 IN A 100.64.1.1")
+	var queries sync.Map
+	var transfers atomic.Int32
+	handler := transferHandler(zone, &queries)
+	host, port := startTestDNSServer(t, handler)
+	startTestTCPDNSServer(t, port, func(w dns.ResponseWriter, r *dns.Msg) {
+		if qtype := r.Question[0].Qtype; qtype == dns.TypeAXFR || qtype == dns.TypeIXFR {
+			transfers.Add(1)
+		}
+		handler(w, r)
+	})
+
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	client := &Client{
+		server:    host,
+		port:      port,
+		zone:      "test.example.com",
+		keyName:   "test-key.",
+		keySecret: testTSIGSecret,
+		algorithm: "hmac-sha256",
+		ttl:       300,
+		clock:     clk,
+	}
+	records := []DNSRecord{{Name: "machine1", Value: "100.64.1.1", TTL: 300, Type: "A"}}
+
+	// Nothing is read ahead unless updates read zones from transfers
+	client.PrefetchZones(context.Background())
+	assert.Zero(t, transfers.Load())
+
+	client.queryBeforeUpdate = true
+	client.zoneTransfer = true
+	client.PrefetchZones(context.Background())
+	assert.Equal(t, int32(1), transfers.Load())
+
+	// The next update uses the prefetched transfer, the one after it transfers again
+	result, err := client.UpdateRecords(context.Background(), records, false)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), transfers.Load())
+	assert.Equal(t, []SkippedRecord{{Record: records[0], Reason: SkipAlreadyApplied}}, result.Zones[0].Skipped)
+	changed := []DNSRecord{{Name: "machine1", Value: "100.64.1.2", TTL: 300, Type: "A"}}
+	_, err = client.UpdateRecords(context.Background(), changed, false)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), transfers.Load())
+
+	// Outdated prefetched transfers are dropped
+	client.PrefetchZones(context.Background())
+	clk.Advance(prefetchMaxAge + time.Second)
+	_, err = client.UpdateRecords(context.Background(), records, false)
+	require.NoError(t, err)
+	assert.Equal(t, int32(4), transfers.Load())
+}
+
+func TestServerRecords(t *testing.T) {
+	zone := &testZone{}
+	zone.set(t,
+		"machine1.test.example.com. 300 IN A 100.64.1.1",
+		"machine1.test.example.com. 300 IN TXT \"heritage=tailscale-bind-ddns,tailscale-bind-ddns/owner=office\"",
+		"manual.test.example.com. 300 IN A 192.0.2.1",
+		"1.1.64.100.in-addr.arpa. 300 IN PTR machine1.test.example.com.",
+	)
+	var queries sync.Map
+	host, port := startTestDNSServer(t, transferHandler(zone, &queries))
+	startTestTCPDNSServer(t, port, transferHandler(zone, &queries))
+
+	client := &Client{
+		server:    host,
+		port:      port,
+		zone:      "test.example.com",
+		keyName:   "test-key.",
+		keySecret: testTSIGSecret,
+		algorithm: "hmac-sha256",
+		ttl:       300,
+		ptrConfig: &config.PTRConfig{
+			Enabled:        true,
+			IPv4Subnet:     "100.64.0.0/10",
+			IPv4SubnetSize: 16,
+		},
+	}
+
+	// Zones are transferred even without bind.zone_transfer
+	contents, err := client.ServerRecords(context.Background(), []DNSRecord{
+		{Name: "machine1", Value: "100.64.1.1", TTL: 300, Type: "A"},
+		{Name: "machine2", Value: "100.64.1.2", TTL: 300, Type: "A"},
+		{Name: "1.1.64.100.in-addr.arpa.", Value: "machine1.test.example.com", TTL: 300, Type: "PTR"},
+		{Name: "2.1.64.100.in-addr.arpa.", Value: "machine2.test.example.com", TTL: 300, Type: "PTR"},
+	})
+	require.NoError(t, err)
+	require.Len(t, contents, 2)
+
+	reverse := contents[0]
+	assert.Equal(t, "64.100.in-addr.arpa", reverse.Zone)
+	assert.False(t, reverse.Transferred)
+	assert.Equal(t, []DNSRecord{
+		{Name: "1.1.64.100.in-addr.arpa.", Value: "machine1.test.example.com", TTL: 300, Type: "PTR"},
+	}, reverse.Records)
+	assert.Equal(t, RecordDiff{
+		Added: []DNSRecord{{Name: "2.1.64.100.in-addr.arpa.", Value: "machine2.test.example.com", TTL: 300, Type: "PTR"}},
+	}, reverse.Diff)
+
+	forward := contents[1]
+	assert.Equal(t, "test.example.com", forward.Zone)
+	assert.True(t, forward.Transferred)
+	assert.Equal(t, []DNSRecord{
+		{Name: "machine1", Value: "100.64.1.1", TTL: 300, Type: "A"},
+		{Name: "manual", Value: "192.0.2.1", TTL: 300, Type: "A"},
+	}, forward.Records)
+	assert.Equal(t, RecordDiff{
+		Added:   []DNSRecord{{Name: "machine2", Value: "100.64.1.2", TTL: 300, Type: "A"}},
+		Removed: []DNSRecord{{Name: "manual", Value: "192.0.2.1", TTL: 300, Type: "A"}},
+	}, forward.Diff)
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	zone := &testZone{}
+	zone.set(t,
+		"machine1.test.example.com. 300 IN A 100.64.1.1",
+		"machine1.test.example.com. 300 IN TXT \"heritage=tailscale-bind-ddns,tailscale-bind-ddns/owner=office\"",
+		"manual.test.example.com. 300 IN A 192.0.2.1",
+		"1.1.64.100.in-addr.arpa. 300 IN PTR machine1.test.example.com.",
+	)
+	var queries sync.Map
+	updates := make(chan *dns.Msg, 10)
+	handler := func(w dns.ResponseWriter, r *dns.Msg) {
+		if r.Opcode == dns.OpcodeUpdate {
+			updates <- r.Copy()
+			m := new(dns.Msg)
+			m.SetReply(r)
+			m.SetTsig(r.IsTsig().Hdr.Name, r.IsTsig().Algorithm, 300, time.Now().Unix())
+			_ = w.WriteMsg(m)
+			return
+		}
+		transferHandler(zone, &queries)(w, r)
+	}
+	host, port := startTestDNSServer(t, handler)
+	startTestTCPDNSServer(t, port, handler)
+
+	client := &Client{
+		server:    host,
+		port:      port,
+		zone:      "test.example.com",
+		keyName:   "test-key.",
+		keySecret: testTSIGSecret,
+		algorithm: "hmac-sha256",
+		ttl:       300,
+		ptrConfig: &config.PTRConfig{
+			Enabled:        true,
+			IPv4Subnet:     "100.64.0.0/10",
+			IPv4SubnetSize: 16,
+		},
+	}
+	desired := []DNSRecord{
+		{Name: "machine1", Value: "100.64.1.1", TTL: 300, Type: "A"},
+		{Name: "1.1.64.100.in-addr.arpa.", Value: "machine1.test.example.com", TTL: 300, Type: "PTR"},
+	}
+
+	// Without an owner ID every record of a transferred zone is backed up
+	backups, err := client.BackupZones(context.Background(), desired)
+	require.NoError(t, err)
+	assert.Equal(t, []ZoneBackup{
+		{
+			Zone: "64.100.in-addr.arpa",
+			Records: []DNSRecord{
+				{Name: "1.1.64.100.in-addr.arpa.", Value: "machine1.test.example.com", TTL: 300, Type: "PTR"},
+			},
+		},
+		{
+			Zone:        "test.example.com",
+			Transferred: true,
+			Records: []DNSRecord{
+				{Name: "machine1", Value: "100.64.1.1", TTL: 300, Type: "A"},
+				{Name: "manual", Value: "192.0.2.1", TTL: 300, Type: "A"},
+			},
+		},
+	}, backups)
+
+	// With one only the names carrying our owner record are
+	client.ownerID = "office"
+	backups, err = client.BackupZones(context.Background(), desired)
+	require.NoError(t, err)
+	require.Len(t, backups, 2)
+	assert.Empty(t, backups[0].Records)
+	assert.Equal(t, []DNSRecord{{Name: "machine1", Value: "100.64.1.1", TTL: 300, Type: "A"}}, backups[1].Records)
+	client.ownerID = ""
+
+	// Only record sets the server doesn't hold as backed up are sent, zones of other clients are skipped
+	restore := []ZoneBackup{
+		{
+			Zone: "test.example.com",
+			Records: []DNSRecord{
+				{Name: "machine1", Value: "100.64.1.1", TTL: 300, Type: "A"},
+				{Name: "machine2", Value: "100.64.1.2", TTL: 300, Type: "A"},
+			},
+		},
+		{Zone: "other.example.com", Records: []DNSRecord{{Name: "web", Value: "100.64.1.9", TTL: 300, Type: "A"}}},
+	}
+	sent, err := client.RestoreZones(context.Background(), restore, true)
+	require.NoError(t, err)
+	assert.Zero(t, sent)
+	assert.Empty(t, updates)
+
+	sent, err = client.RestoreZones(context.Background(), restore, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	require.Len(t, updates, 1)
+	assert.Equal(t, []string{"machine2.test.example.com."}, insertedNames(<-updates))
+
+	// Restored records aren't tracked as published
+	assert.False(t, client.hasPublished())
+}
+
+func TestParseBackup(t *testing.T) {
+	backup, err := ParseBackup([]byte(`{"version": 1, "created": "2025-01-01T00:00:00Z", "zones": [
+		{"zone": "test.example.com", "transferred": true, "records": [{"Name": "machine1", "Value": "100.64.1.1",
+		"TTL": 300, "Type": "A"}]}]}`))
+	require.NoError(t, err)
+	require.Len(t, backup.Zones, 1)
+	assert.Equal(t, []DNSRecord{{Name: "machine1", Value: "100.64.1.1", TTL: 300, Type: "A"}}, backup.Zones[0].Records)
+
+	_, err = ParseBackup([]byte(`{"version": 2}`))
+	assert.ErrorContains(t, err, "unsupported backup version 2")
+	_, err = ParseBackup([]byte(`{}`))
+	assert.Error(t, err)
+	_, err = ParseBackup([]byte(`not json`))
+	assert.Error(t, err)
+}
+
+func TestParseUpdatePolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    string
+		wantErr string
+	}{
+		{name: "subdomain rule with types", rule: "grant test-key subdomain test.example.com. A AAAA(2);"},
+		{name: "zonesub rule without types", rule: "grant test-key zonesub"},
+		{name: "self rule repeating the identity", rule: "deny *.keys.example.com self . ANY"},
+		{name: "unknown action", rule: "allow test-key zonesub A", wantErr: "must start with grant or deny"},
+		{name: "name rule without name", rule: "grant test-key name", wantErr: "name rules need a name"},
+		{name: "unsupported rule type", rule: "grant test-key krb5-self . A", wantErr: "unsupported rule type"},
+		{name: "unknown record type", rule: "grant test-key zonesub BOGUS", wantErr: "unknown record type BOGUS"},
+		{name: "too few fields", rule: "grant test-key", wantErr: "expected grant|deny"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseUpdatePolicy([]string{tt.rule})
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestPolicyAllows(t *testing.T) {
+	policy, err := parseUpdatePolicy([]string{
+		"deny test-key name admin.test.example.com.",
+		"grant test-key subdomain test.example.com. A AAAA",
+		"grant test-key wildcard *.meta.test.example.com. TXT",
+		"grant *.other-keys. zonesub ANY",
+		"grant test-key zonesub PTR",
+	})
+	require.NoError(t, err)
+	client := &Client{zone: "test.example.com", keyName: "test-key.", updatePolicy: policy}
+
+	tests := []struct {
+		name    string
+		zone    string
+		record  DNSRecord
+		ownerID string
+		want    bool
+	}{
+		{
+			name:   "granted subdomain",
+			zone:   "test.example.com",
+			record: DNSRecord{Name: "laptop", Value: "100.64.0.1", Type: "A"},
+			want:   true,
+		},
+		{
+			name:   "denied by an earlier rule",
+			zone:   "test.example.com",
+			record: DNSRecord{Name: "admin", Value: "100.64.0.1", Type: "A"},
+		},
+		{
+			name:   "type not granted",
+			zone:   "test.example.com",
+			record: DNSRecord{Name: "laptop", Value: "laptop.example.org", Type: "CNAME"},
+		},
+		{
+			name:   "wildcard rule",
+			zone:   "test.example.com",
+			record: DNSRecord{Name: "laptop.meta", Value: "os=linux", Type: "TXT"},
+			want:   true,
+		},
+		{
+			name:    "owner record not granted",
+			zone:    "test.example.com",
+			record:  DNSRecord{Name: "laptop", Value: "100.64.0.1", Type: "A"},
+			ownerID: "office",
+		},
+		{
+			name:   "zonesub rule in the reverse zone",
+			zone:   "64.100.in-addr.arpa",
+			record: DNSRecord{Name: "1.0.64.100.in-addr.arpa.", Value: "laptop.test.example.com", Type: "PTR"},
+			want:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client.ownerID = tt.ownerID
+			assert.Equal(t, tt.want, client.policyAllows(tt.zone, tt.record))
+		})
+	}
+}
+
+func TestUpdateRecordsUpdatePolicy(t *testing.T) {
+	updates := make(chan *dns.Msg, 10)
+	host, port := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if r.Opcode == dns.OpcodeUpdate {
+			updates <- r.Copy()
+		}
+		_ = w.WriteMsg(m)
+	})
+
+	policy, err := parseUpdatePolicy([]string{"grant test-key. subdomain test.example.com. A AAAA"})
+	require.NoError(t, err)
+	client := &Client{
+		server:       host,
+		port:         port,
+		zone:         "test.example.com",
+		keyName:      "test-key.",
+		keySecret:    testTSIGSecret,
+		algorithm:    "hmac-sha256",
+		ttl:          300,
+		updatePolicy: policy,
+	}
+
+	result, err := client.UpdateRecords(context.Background(), []DNSRecord{
+		{Name: "machine1", Value: "100.64.1.1", TTL: 300, Type: "A"},
+		{Name: "machine1", Value: "os=linux", TTL: 300, Type: "TXT"},
+	}, false)
+	require.NoError(t, err)
+
+	// Only the granted record is sent, the denied one is reported and not remembered as published
+	require.Len(t, updates, 1)
+	assert.Equal(t, []string{"machine1.test.example.com."}, insertedNames(<-updates))
+	assert.Equal(t, []SkippedRecord{{
+		Record: DNSRecord{Name: "machine1", Value: "os=linux", TTL: 300, Type: "TXT"},
+		Reason: SkipDeniedByPolicy,
+	}}, result.SkippedRecords())
+	assert.Len(t, client.publishedRecords(), 1)
+}
+
+// updateSections describes the prerequisite and update sections of an update message as "<class> <type> <name>
+// [rdata]" lines
+func updateSections(msg *dns.Msg) (prerequisites, updates []string) {
+	describe := func(rrs []dns.RR) []string {
+		var lines []string
+		for _, rr := range rrs {
+			hdr := rr.Header()
+			line := fmt.Sprintf("%s %s %s", dns.ClassToString[hdr.Class], dns.TypeToString[hdr.Rrtype], hdr.Name)
+			if rdata := strings.TrimPrefix(rr.String(), hdr.String()); rdata != "" && hdr.Rrtype != dns.TypeANY {
+				line += " " + rdata
+			}
+			lines = append(lines, strings.TrimSpace(line))
+		}
+		return lines
+	}
+	return describe(msg.Answer), describe(msg.Ns)
+}
+
+func TestZoneUpdateQuirks(t *testing.T) {
+	change := zoneChange{
+		zone: "test.example.com",
+		upserts: []DNSRecord{
+			{Name: "laptop", Value: "100.64.0.2", TTL: 300, Type: "A"},
+			{Name: "server", Value: "100.64.0.3", TTL: 300, Type: "A"},
+		},
+		removals: []DNSRecord{{Name: "phone", Value: "100.64.0.4", TTL: 300, Type: "A"}},
+		previous: map[RecordKey][]DNSRecord{
+			{Type: "A", Name: "laptop"}: {{Name: "laptop", Value: "100.64.0.1", TTL: 300, Type: "A"}},
+			{Type: "A", Name: "phone"}:  {{Name: "phone", Value: "100.64.0.4", TTL: 300, Type: "A"}},
+		},
+	}
+
+	tests := []struct {
+		name              string
+		client            *Client
+		wantPrerequisites []string
+		wantUpdates       []string
+	}{
+		{
+			name:   "bind",
+			client: &Client{},
+			wantUpdates: []string{
+				"ANY A laptop.test.example.com.",
+				"IN A laptop.test.example.com. 100.64.0.2",
+				"ANY A server.test.example.com.",
+				"IN A server.test.example.com. 100.64.0.3",
+				"ANY A phone.test.example.com.",
+			},
+		},
+		{
+			name:   "strict rrset removal",
+			client: &Client{strictRRsetRemoval: true},
+			wantUpdates: []string{
+				"NONE A laptop.test.example.com. 100.64.0.1",
+				"IN A laptop.test.example.com. 100.64.0.2",
+				"IN A server.test.example.com. 100.64.0.3",
+				"NONE A phone.test.example.com. 100.64.0.4",
+			},
+		},
+		{
+			name:   "prerequisites",
+			client: &Client{usePrerequisites: true},
+			wantPrerequisites: []string{
+				"IN A laptop.test.example.com. 100.64.0.1",
+				"IN A phone.test.example.com. 100.64.0.4",
+				"NONE A server.test.example.com.",
+			},
+			wantUpdates: []string{
+				"ANY A laptop.test.example.com.",
+				"IN A laptop.test.example.com. 100.64.0.2",
+				"ANY A server.test.example.com.",
+				"IN A server.test.example.com. 100.64.0.3",
+				"ANY A phone.test.example.com.",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prerequisites, updates := updateSections(tt.client.zoneUpdate(change))
+			assert.Equal(t, tt.wantPrerequisites, prerequisites)
+			assert.Equal(t, tt.wantUpdates, updates)
+		})
+	}
+
+	// Zones without a confirmed update get no prerequisites
+	change.previous = nil
+	prerequisites, _ := updateSections((&Client{usePrerequisites: true}).zoneUpdate(change))
+	assert.Empty(t, prerequisites)
+}
+
+func TestUpdateRecordsServerCompatibility(t *testing.T) {
+	// Servers are mimicked by the parts of the update message shape they refuse
+	tests := []struct {
+		name                string
+		refuseRRsetDeletion bool
+		strictRRsetRemoval  bool
+		wantErr             error
+	}{
+		{name: "bind"},
+		{name: "server refusing record set deletions", refuseRRsetDeletion: true, wantErr: ErrRefused},
+		{name: "server refusing record set deletions, strict removal", refuseRRsetDeletion: true,
+			strictRRsetRemoval: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, port := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+				m := new(dns.Msg)
+				m.SetReply(r)
+				for _, rr := range r.Ns {
+					if tt.refuseRRsetDeletion && rr.Header().Class == dns.ClassANY {
+						m.Rcode = dns.RcodeRefused
+					}
+				}
+				_ = w.WriteMsg(m)
+			})
+
+			client := &Client{
+				server:             host,
+				port:               port,
+				zone:               "test.example.com",
+				keyName:            "test-key.",
+				keySecret:          testTSIGSecret,
+				algorithm:          "hmac-sha256",
+				ttl:                300,
+				removeStale:        true,
+				strictRRsetRemoval: tt.strictRRsetRemoval,
+			}
+
+			ctx := context.Background()
+			for _, records := range [][]DNSRecord{
+				{{Name: "laptop", Value: "100.64.0.1", TTL: 300, Type: "A"}},
+				{{Name: "laptop", Value: "100.64.0.2", TTL: 300, Type: "A"}},
+				nil,
+			} {
+				_, err := client.UpdateRecords(ctx, records, false)
+				if tt.wantErr != nil {
+					assert.ErrorIs(t, err, tt.wantErr)
+					return
+				}
+				require.NoError(t, err)
+			}
+			assert.False(t, client.hasPublished())
+		})
+	}
+}
+
+func TestUpdateRecordsPrerequisiteConflict(t *testing.T) {
+	updates := make(chan *dns.Msg, 10)
+	conflict := atomic.Bool{}
+	host, port := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		updates <- r.Copy()
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if conflict.Load() && len(r.Answer) > 0 {
+			m.Rcode = dns.RcodeNXRrset
+		}
+		_ = w.WriteMsg(m)
+	})
+
+	client := &Client{
+		server:           host,
+		port:             port,
+		zone:             "test.example.com",
+		keyName:          "test-key.",
+		keySecret:        testTSIGSecret,
+		algorithm:        "hmac-sha256",
+		ttl:              300,
+		usePrerequisites: true,
+	}
+
+	ctx := context.Background()
+	_, err := client.UpdateRecords(ctx, []DNSRecord{{Name: "laptop", Value: "100.64.0.1", TTL: 300, Type: "A"}}, false)
+	require.NoError(t, err)
+	assert.Empty(t, (<-updates).Answer)
+
+	// Someone else changed the record, so the guarded update is refused and the zone's state forgotten
+	conflict.Store(true)
+	records := []DNSRecord{{Name: "laptop", Value: "100.64.0.2", TTL: 300, Type: "A"}}
+	result, err := client.UpdateRecords(ctx, records, false)
+	require.ErrorIs(t, err, ErrPrerequisiteFailed)
+	assert.Equal(t, dns.RcodeNXRrset, result.Zones[0].Rcode)
+	assert.NotEmpty(t, (<-updates).Answer)
+
+	// The next update republishes the zone without prerequisites
+	_, err = client.UpdateRecords(ctx, records, false)
+	require.NoError(t, err)
+	assert.Empty(t, (<-updates).Answer)
+}
+
+// TestKeyCommandHelper isn't a real test, it's the key command of TestKeyCommand computing the MAC with the test secret
+func TestKeyCommandHelper(t *testing.T) {
+	if os.Getenv("TSBD_TEST_KEY_COMMAND") != "1" {
+		t.Skip("only run as the key command")
+	}
+	secret, _ := base64.StdEncoding.DecodeString(testTSIGSecret)
+	msg, _ := io.ReadAll(os.Stdin)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(msg)
+	_, _ = os.Stdout.Write(mac.Sum(nil))
+	os.Exit(0)
+}
+
+func TestKeyCommand(t *testing.T) {
+	t.Setenv("TSBD_TEST_KEY_COMMAND", "1")
+	keyCommand := fmt.Sprintf("'%s' -test.run='^TestKeyCommandHelper$'", os.Args[0])
+	host, port := startTestDNSServer(t, tsigCheckingHandler)
+
+	newSignedClient := func(command string) *Client {
+		t.Helper()
+		client, err := NewClientFromConfig(&config.BindConfig{
+			Server:     host,
+			Port:       port,
+			Zone:       "test.example.com",
+			KeyName:    "test-key.",
+			KeyCommand: command,
+			Algorithm:  "hmac-sha256",
+			TTL:        300 * time.Second,
+		})
+		require.NoError(t, err)
+		return client
+	}
+
+	// Requests are signed and responses verified by the command without the client knowing the secret
+	client := newSignedClient(keyCommand)
+	assert.NoError(t, client.ProbeUpdate(context.Background()))
+	assert.Error(t, client.SetTSIGKey("test-key.", testTSIGSecret, "hmac-sha256"))
+
+	// A MAC of the wrong key is refused by the server
+	assert.Error(t, newSignedClient("printf '%032d' 0").ProbeUpdate(context.Background()))
+
+	// Failures and MACs of the wrong size never make it on the wire
+	tsig := &dns.TSIG{Hdr: dns.RR_Header{Name: "test-key."}, Algorithm: dns.HmacSHA256}
+	_, err := (&commandSigner{command: "echo nope >&2; exit 1"}).Generate([]byte("msg"), tsig)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "nope")
+	_, err = (&commandSigner{command: "printf short"}).Generate([]byte("msg"), tsig)
+	assert.ErrorContains(t, err, "5 bytes")
+
+	// The command sees the key it signs for
+	mac, err := (&commandSigner{command: `printf '%-32s' "$TSBD_TSIG_KEY_NAME"`}).Generate([]byte("msg"), tsig)
+	require.NoError(t, err)
+	assert.Equal(t, "test-key", strings.TrimSpace(string(mac)))
+}
+
+// fakeNegotiatedSigner hands out a new key name on every negotiation and signs with a fixed MAC
+type fakeNegotiatedSigner struct {
+	mu           sync.Mutex
+	name         string
+	negotiations int
+}
+
+func (s *fakeNegotiatedSigner) keyName(context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.name == "" {
+		s.negotiations++
+		s.name = fmt.Sprintf("gss-%d.", s.negotiations)
+	}
+	return s.name, nil
+}
+
+func (s *fakeNegotiatedSigner) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = ""
+}
+
+func (s *fakeNegotiatedSigner) Generate([]byte, *dns.TSIG) ([]byte, error) { return []byte("mic"), nil }
+func (s *fakeNegotiatedSigner) Verify([]byte, *dns.TSIG) error             { return nil }
+
+func TestNegotiatedKeys(t *testing.T) {
+	keys := make(chan *dns.TSIG, 2)
+	host, port := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		tsig := r.IsTsig()
+		keys <- tsig
+		if tsig.Hdr.Name != "gss-1." {
+			m := new(dns.Msg)
+			m.SetReply(r)
+			_ = w.WriteMsg(m)
+			return
+		}
+		// The server forgot the first key
+		tsigCheckingHandler(w, r)
+	})
+
+	signer := &fakeNegotiatedSigner{}
+	client := &Client{server: host, port: port, zone: "test.example.com", signer: signer}
+
+	// Messages are signed with the negotiated key, which is dropped once the server rejected it
+	err := client.ProbeUpdate(context.Background())
+	assert.ErrorIs(t, err, ErrTSIGBadKey)
+	tsig := <-keys
+	assert.Equal(t, "gss-1.", tsig.Hdr.Name)
+	assert.Equal(t, gssTSIGAlgorithm, tsig.Algorithm)
+
+	require.NoError(t, client.ProbeUpdate(context.Background()))
+	assert.Equal(t, "gss-2.", (<-keys).Hdr.Name)
+	assert.Equal(t, 2, signer.negotiations)
+
+	assert.ErrorContains(t, client.SetTSIGKey("test-key.", testTSIGSecret, "hmac-sha256"), "GSS-TSIG")
+}
+
+func TestPTRZoneSharding(t *testing.T) {
+	client := &Client{
+		zone: "test.example.com",
+		ptrConfig: &config.PTRConfig{
+			Enabled: true, IPv4SubnetSize: 16, IPv6Enabled: true, IPv6SubnetSize: 48, ShardThreshold: 4,
+		},
+	}
+	ptr := func(name string) DNSRecord {
+		return DNSRecord{Name: name, Type: "PTR", Value: "host.test.example.com."}
+	}
+	records := []DNSRecord{
+		{Name: "host", Type: "A", Value: "100.64.0.1"},
+		ptr("1.0.64.100.in-addr.arpa."),
+		ptr("2.0.64.100.in-addr.arpa."),
+		ptr("1.1.64.100.in-addr.arpa."),
+		ptr("1.1.65.100.in-addr.arpa."),
+		ptr(ipv6ToReverseDNS("fd7a:115c:a1e0::1")),
+	}
+	zones := func(records []DNSRecord) []string {
+		client.updateShards(records)
+		return slices.Sorted(maps.Keys(client.groupRecordsByZone(records)))
+	}
+
+	// Below the threshold the zones are derived with the subnet sizes
+	assert.Equal(t, []string{"0.e.1.a.c.5.1.1.a.7.d.f.ip6.arpa", "64.100.in-addr.arpa", "65.100.in-addr.arpa",
+		"test.example.com"}, zones(records))
+	assert.Empty(t, client.ShardedZones())
+
+	// Above it only the crowded zone moves to /24 zones
+	records = append(records, ptr("2.1.64.100.in-addr.arpa."), ptr("3.1.64.100.in-addr.arpa."))
+	assert.Equal(t, []string{"0.64.100.in-addr.arpa", "0.e.1.a.c.5.1.1.a.7.d.f.ip6.arpa", "1.64.100.in-addr.arpa",
+		"65.100.in-addr.arpa", "test.example.com"}, zones(records))
+	assert.Equal(t, []string{"64.100.in-addr.arpa"}, client.ShardedZones())
+
+	// It stays sharded until its records dropped below half the threshold
+	assert.Contains(t, zones(records[:4]), "0.64.100.in-addr.arpa")
+	assert.Contains(t, zones(records[:2]), "64.100.in-addr.arpa")
+	assert.Empty(t, client.ShardedZones())
+
+	// /24 zones can't be split any further, /48 zones are split into /64 zones
+	client.ptrConfig.IPv4SubnetSize = 24
+	client.ptrConfig.ShardThreshold = 1
+	records = append(records, ptr(ipv6ToReverseDNS("fd7a:115c:a1e0::2")))
+	client.updateShards(records)
+	assert.Equal(t, []string{"0.e.1.a.c.5.1.1.a.7.d.f.ip6.arpa"}, client.ShardedZones())
+	assert.Equal(t, "0.0.0.0.0.e.1.a.c.5.1.1.a.7.d.f.ip6.arpa", client.recordZone(records[5]))
+	assert.Equal(t, "1.64.100.in-addr.arpa", client.recordZone(records[3]))
+}
+
+func TestActiveDirectoryRefusedNames(t *testing.T) {
+	// The server mimics an Active Directory ACL denying laptop, which a workstation registered itself
+	var (
+		mu      sync.Mutex
+		updates [][]string
+	)
+	host, port := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		var names []string
+		for _, rr := range r.Ns {
+			names = append(names, rr.Header().Name)
+			if rr.Header().Name == "laptop.test.example.com." {
+				m.Rcode = dns.RcodeRefused
+			}
+		}
+		mu.Lock()
+		updates = append(updates, slices.Compact(names))
+		mu.Unlock()
+		_ = w.WriteMsg(m)
+	})
+	sent := func() [][]string {
+		mu.Lock()
+		defer mu.Unlock()
+		sentUpdates := updates
+		updates = nil
+		return sentUpdates
+	}
+
+	newClient := func(activeDirectory bool) *Client {
+		return &Client{
+			server:          host,
+			port:            port,
+			zone:            "test.example.com",
+			keyName:         "test-key.",
+			keySecret:       testTSIGSecret,
+			algorithm:       "hmac-sha256",
+			ttl:             300,
+			principal:       "ddns@EXAMPLE.COM",
+			removeStale:     true,
+			activeDirectory: activeDirectory,
+		}
+	}
+	records := []DNSRecord{
+		{Name: "laptop", Value: "100.64.0.1", TTL: 300, Type: TypeA},
+		{Name: "server", Value: "100.64.0.2", TTL: 300, Type: TypeA},
+		{Name: "web", Value: "100.64.0.3", TTL: 300, Type: TypeA},
+	}
+	ctx := context.Background()
+
+	// Without Active Directory support the whole update is refused
+	_, err := newClient(false).UpdateRecords(ctx, records, false)
+	require.ErrorIs(t, err, ErrRefused)
+	assert.Len(t, sent(), 1)
+
+	client := newClient(true)
+	result, err := client.UpdateRecords(ctx, records, false)
+	require.NoError(t, err)
+	require.Len(t, result.Zones, 1)
+	zone := result.Zones[0]
+	assert.Equal(t, dns.RcodeSuccess, zone.Rcode)
+	assert.Equal(t, 2, zone.Sent)
+	assert.Equal(t, []SkippedRecord{{Record: records[0], Reason: SkipDeniedByACL}}, zone.Skipped)
+	assert.Equal(t, [][]string{
+		{"laptop.test.example.com.", "server.test.example.com.", "web.test.example.com."},
+		{"laptop.test.example.com."},
+		{"server.test.example.com."},
+		{"web.test.example.com."},
+	}, sent())
+
+	// The refused name isn't remembered as published, so it is tried again with the next update, and skipped again
+	result, err = client.UpdateRecords(ctx, records, false)
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"laptop.test.example.com."}, {"laptop.test.example.com."}}, sent())
+	assert.Equal(t, []SkippedRecord{{Record: records[0], Reason: SkipDeniedByACL}}, result.Zones[0].Skipped)
+
+	// An update of the refused name alone fails like any refused update
+	result, err = newClient(true).UpdateRecords(ctx, records[:1], false)
+	require.ErrorIs(t, err, ErrRefused)
+	assert.Len(t, sent(), 1)
+	assert.Equal(t, dns.RcodeRefused, result.Zones[0].Rcode)
+}
+
+func TestDiscoverServer(t *testing.T) {
+	host, port := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if r.Question[0].Name == "corp.example.com." && r.Question[0].Qtype == dns.TypeSOA {
+			m.Answer = append(m.Answer, &dns.SOA{
+				Hdr:  dns.RR_Header{Name: "corp.example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 3600},
+				Ns:   "dc1.corp.example.com.",
+				Mbox: "hostmaster.corp.example.com.",
+			})
+		}
+		_ = w.WriteMsg(m)
+	})
+	resolvers := []string{net.JoinHostPort(host, strconv.Itoa(port))}
+	ctx := context.Background()
+
+	server, err := discoverServer(ctx, "corp.example.com", resolvers)
+	require.NoError(t, err)
+	assert.Equal(t, "dc1.corp.example.com", server)
+
+	_, err = discoverServer(ctx, "other.example.com", resolvers)
+	assert.ErrorContains(t, err, "the domain has no SOA record")
+	_, err = discoverServer(ctx, "", resolvers)
+	assert.ErrorContains(t, err, "bind.active_directory.domain")
+
+	// Without resolvers the system resolvers are asked
+	resolvConf = filepath.Join(t.TempDir(), "resolv.conf")
+	t.Cleanup(func() { resolvConf = "/etc/resolv.conf" })
+	_, err = discoverServer(ctx, "corp.example.com", nil)
+	assert.ErrorContains(t, err, "reading system resolvers")
+}
+
+func TestReverseZoneKeys(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		keys = make(map[string]string)
+	)
+	host, port := startTestDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		if tsig := r.IsTsig(); tsig != nil && w.TsigStatus() == nil {
+			mu.Lock()
+			keys[r.Question[0].Name] = tsig.Hdr.Name
+			mu.Unlock()
+		}
+		tsigCheckingHandler(w, r)
+	})
+	// signedWith returns the key every zone was updated with since the last call
+	signedWith := func() map[string]string {
+		mu.Lock()
+		defer mu.Unlock()
+		got := maps.Clone(keys)
+		clear(keys)
+		return got
+	}
+
+	newClient := func(key config.TSIGKeyConfig) *Client {
+		return &Client{
+			server:    host,
+			port:      port,
+			zone:      "test.example.com",
+			keyName:   "test-key.",
+			keySecret: testTSIGSecret,
+			algorithm: "hmac-sha256",
+			ttl:       300,
+			ptrConfig: &config.PTRConfig{
+				Enabled:        true,
+				IPv4Subnet:     "100.64.0.0/10",
+				IPv4SubnetSize: 24,
+				IPv4Key:        key,
+			},
+		}
+	}
+	records := []DNSRecord{
+		{Name: "machine1", Value: "100.64.1.1", TTL: 300, Type: TypeA},
+		{Name: "1.1.64.100.in-addr.arpa.", Value: "machine1.test.example.com", TTL: 300, Type: TypePTR},
+	}
+	ctx := context.Background()
+
+	_, err := newClient(config.TSIGKeyConfig{KeyName: "ptr-key", KeySecret: testPTRTSIGSecret}).UpdateRecords(ctx,
+		records, false)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"test.example.com.":      "test-key.",
+		"1.64.100.in-addr.arpa.": "ptr-key.",
+	}, signedWith())
+
+	// A rejected reverse zone key doesn't keep the forward zone from being updated
+	result, err := newClient(config.TSIGKeyConfig{KeyName: "bad-key.", KeySecret: testPTRTSIGSecret}).UpdateRecords(ctx,
+		records, false)
+	require.ErrorIs(t, err, ErrTSIGBadKey)
+	assert.Equal(t, map[string]string{"test.example.com.": "test-key."}, signedWith())
+	require.Len(t, result.Zones, 2)
+	for _, zone := range result.Zones {
+		if zone.Zone == "test.example.com" {
+			assert.Equal(t, dns.RcodeSuccess, zone.Rcode)
+		} else {
+			assert.Equal(t, dns.RcodeNotAuth, zone.Rcode)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package bind
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// Mismatch describes a published record whose forward or reverse counterpart doesn't match what the server serves
+type Mismatch struct {
+	Record  DNSRecord `json:"record"`
+	Problem string    `json:"problem"`
+	// Repair is the record that restores consistency, nil when the mismatch can't be repaired automatically
+	Repair *DNSRecord `json:"repair,omitempty"`
+}
+
+// ConsistencyReport is the outcome of a forward/reverse consistency check
+type ConsistencyReport struct {
+	CheckedAt  time.Time  `json:"checked_at"`
+	Checked    int        `json:"checked"`
+	Mismatches []Mismatch `json:"mismatches,omitempty"`
+	Repaired   int        `json:"repaired"`
+}
+
+// CheckConsistency verifies that every published A/AAAA record has a PTR record on the server pointing back at it and
+// that every published PTR record's target resolves to its address. When repair is set, the missing counterparts are
+// republished. Nothing is checked unless PTR records are enabled.
+func (c *Client) CheckConsistency(ctx context.Context, repair bool) (ConsistencyReport, error) {
+	report := ConsistencyReport{CheckedAt: clock.Or(c.clock).Now()}
+	if c.ptrConfig == nil || !c.ptrConfig.Enabled {
+		return report, nil
+	}
+
+	reader := c.newVerifyReader()
+	for _, record := range c.publishedRecords() {
+		if recordType := record.Key().Type; recordType == TypeCNAME || recordType == TypeTXT {
+			// Aliases and metadata have no reverse counterpart, the records they belong to are checked on their own
+			continue
+		}
+
+		var mismatch *Mismatch
+		var err error
+		if record.Key().Type == TypePTR {
+			mismatch, err = c.checkReverse(ctx, reader, record)
+		} else {
+			mismatch, err = c.checkForward(ctx, reader, record)
+		}
+		if err != nil {
+			return report, err
+		}
+
+		report.Checked++
+		if mismatch != nil {
+			klog.Warningf("Forward/reverse mismatch: %s", mismatch.Problem)
+			report.Mismatches = append(report.Mismatches, *mismatch)
+		}
+	}
+
+	if repair && len(report.Mismatches) > 0 {
+		repaired, err := c.repairMismatches(ctx, report.Mismatches)
+		report.Repaired = repaired
+		if err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// checkForward verifies that the PTR record for an A/AAAA record's address points back at its name
+func (c *Client) checkForward(ctx context.Context, reader *zoneReader, record DNSRecord) (*Mismatch, error) {
+	hostname := record.Name + "." + c.zone
+	expected, err := NewPTRRecord(c.ptrConfig, record.TTL, record.Value, hostname)
+	if err != nil {
+		return nil, fmt.Errorf("building PTR record for %s: %w", hostname, err)
+	}
+	if expected == nil {
+		// Addresses outside the PTR subnets never get a PTR record
+		return nil, nil
+	}
+
+	answers, err := reader.lookup(ctx, expected.Name, dns.TypePTR)
+	if err != nil {
+		return nil, err
+	}
+	for _, rr := range answers {
+		if ptr, ok := rr.(*dns.PTR); ok && dns.CanonicalName(ptr.Ptr) == dns.CanonicalName(expected.Value) {
+			return nil, nil
+		}
+	}
+
+	return &Mismatch{
+		Record: record,
+		Problem: fmt.Sprintf("%s -> %s has no PTR record at %s pointing back", dns.Fqdn(hostname), record.Value,
+			expected.Name),
+		Repair: expected,
+	}, nil
+}
+
+// checkReverse verifies that the target of a PTR record resolves to the address the PTR record is for
+func (c *Client) checkReverse(ctx context.Context, reader *zoneReader, record DNSRecord) (*Mismatch, error) {
+	ip := reverseNameToIP(record.Name)
+	if ip == nil {
+		return nil, nil
+	}
+
+	target := dns.Fqdn(record.Value)
+	recordType := TypeA
+	if ip.To4() == nil {
+		recordType = TypeAAAA
+	}
+
+	answers, err := reader.lookup(ctx, target, recordType.Qtype())
+	if err != nil {
+		return nil, err
+	}
+	for _, rr := range answers {
+		switch rr := rr.(type) {
+		case *dns.A:
+			if rr.A.Equal(ip) {
+				return nil, nil
+			}
+		case *dns.AAAA:
+			if rr.AAAA.Equal(ip) {
+				return nil, nil
+			}
+		}
+	}
+
+	mismatch := &Mismatch{
+		Record:  record,
+		Problem: fmt.Sprintf("%s -> %s but %s doesn't resolve to %s", record.Name, target, target, ip),
+	}
+	// Only forward records in our own zone can be repaired
+	if name, ok := strings.CutSuffix(strings.ToLower(target), "."+strings.ToLower(dns.Fqdn(c.zone))); ok {
+		mismatch.Repair = &DNSRecord{Name: name, Value: ip.String(), TTL: record.TTL, Type: recordType}
+	}
+	return mismatch, nil
+}
+
+// repairMismatches republishes the records that restore consistency, returning how many were sent successfully. The
+// records are sent as targeted updates without touching the published record sets.
+func (c *Client) repairMismatches(ctx context.Context, mismatches []Mismatch) (int, error) {
+	repairs := make(map[RecordKey]DNSRecord)
+	for _, mismatch := range mismatches {
+		if mismatch.Repair != nil {
+			repairs[mismatch.Repair.Key()] = *mismatch.Repair
+		}
+	}
+	if len(repairs) == 0 {
+		return 0, nil
+	}
+
+	key, secret, err := c.signingKey()
+	if err != nil {
+		return 0, fmt.Errorf("creating TSIG key: %w", err)
+	}
+
+	c.updateMu.Lock()
+	defer c.updateMu.Unlock()
+
+	repaired := 0
+	recordsByZone := c.groupRecordsByZone(slices.Collect(maps.Values(repairs)))
+	for _, zone := range slices.Sorted(maps.Keys(recordsByZone)) {
+		records := c.allowedByPolicy(zone, recordsByZone[zone])
+		if len(records) == 0 {
+			continue
+		}
+		msg := c.zoneUpdate(zoneChange{zone: zone, upserts: records})
+		if err := c.exchangeUpdate(ctx, zone, msg, key, secret); err != nil {
+			return repaired, fmt.Errorf("repairing %d records in zone %s: %w", len(records), zone, err)
+		}
+		klog.Infof("Repaired %d forward/reverse mismatches in zone %s", len(records), zone)
+		repaired += len(records)
+	}
+	return repaired, nil
+}
+
+// publishedRecords returns every record this client currently owns, across all zones
+func (c *Client) publishedRecords() []DNSRecord {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	var records []DNSRecord
+	for _, zone := range slices.Sorted(maps.Keys(c.published)) {
+		records = append(records, c.published[zone]...)
+	}
+	return records
+}
+
+// lookup queries the server for records of a type at a name. A name that doesn't exist yields no records.
+func (c *Client) lookup(ctx context.Context, name string, qtype uint16) ([]dns.RR, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), qtype)
+
+	response, err := c.exchange(ctx, msg, verifyTimeout, nil)
+	if err != nil {
+		return nil, fmt.Errorf("querying %s %s: %w", dns.TypeToString[qtype], name, err)
+	}
+
+	switch response.Rcode {
+	case dns.RcodeSuccess:
+		return response.Answer, nil
+	case dns.RcodeNameError:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("%s query for %s failed: %w", dns.TypeToString[qtype], name, responseError(response))
+	}
+}
+
+// reverseNameToIP converts an in-addr.arpa or ip6.arpa name back into the address it is for
+func reverseNameToIP(name string) net.IP {
+	name = strings.ToLower(dns.Fqdn(name))
+
+	if labels, ok := strings.CutSuffix(name, ".in-addr.arpa."); ok {
+		octets := strings.Split(labels, ".")
+		if len(octets) != net.IPv4len {
+			return nil
+		}
+		slices.Reverse(octets)
+		return net.ParseIP(strings.Join(octets, ".")).To4()
+	}
+
+	if labels, ok := strings.CutSuffix(name, ".ip6.arpa."); ok {
+		nibbles := strings.Split(labels, ".")
+		if len(nibbles) != 2*net.IPv6len {
+			return nil
+		}
+		slices.Reverse(nibbles)
+		var groups []string
+		for i := 0; i < len(nibbles); i += 4 {
+			groups = append(groups, strings.Join(nibbles[i:i+4], ""))
+		}
+		return net.ParseIP(strings.Join(groups, ":"))
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package bind
+
+import "slices"
+
+// RecordKey identifies a DNS record set by type and owner name
+type RecordKey struct {
+	Type RecordType
+	Name string
+}
+
+// Key returns the key identifying the record set this record belongs to
+func (r DNSRecord) Key() RecordKey {
+	return RecordKey{Type: r.Type, Name: r.Name}
+}
+
+// RecordDiff describes the changes needed to go from one record set to another
+type RecordDiff struct {
+	Added   []DNSRecord
+	Removed []DNSRecord
+	Changed []DNSRecord // Holds the new version of each changed record
+}
+
+// Empty reports whether the diff contains no changes
+func (d RecordDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// DiffRecords compares a previous and desired record set. Records are matched by type and name, and every record of a
+// record set whose values or TTLs differ is reported as changed, so that the whole set can be replaced. The resulting
+// slices are sorted deterministically.
+func DiffRecords(previous, desired []DNSRecord) RecordDiff {
+	previousByKey := groupByKey(previous)
+	desiredByKey := groupByKey(desired)
+
+	var diff RecordDiff
+	for _, record := range desired {
+		old, ok := previousByKey[record.Key()]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, record)
+		case !sameRecordSet(old, desiredByKey[record.Key()]):
+			diff.Changed = append(diff.Changed, record)
+		}
+	}
+
+	for _, record := range previous {
+		if _, ok := desiredByKey[record.Key()]; !ok {
+			diff.Removed = append(diff.Removed, record)
+		}
+	}
+
+	for _, records := range [][]DNSRecord{diff.Added, diff.Removed, diff.Changed} {
+		sortRecords(records)
+	}
+	return diff
+}
+
+// groupByKey groups records by the record set they belong to
+func groupByKey(records []DNSRecord) map[RecordKey][]DNSRecord {
+	sets := make(map[RecordKey][]DNSRecord, len(records))
+	for _, record := range records {
+		sets[record.Key()] = append(sets[record.Key()], record)
+	}
+	return sets
+}
+
+// sameRecordSet reports whether two record sets hold the same values with the same TTLs, in any order
+func sameRecordSet(a, b []DNSRecord) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, record := range a {
+		if !slices.ContainsFunc(b, func(other DNSRecord) bool {
+			return other.Value == record.Value && other.TTL == record.TTL
+		}) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,66 @@
+package bind
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// Kinds of failures reported by the server, to be checked with errors.Is
+var (
+	// ErrZoneNotAuthoritative means the server isn't a primary for the zone, or the records aren't inside it
+	ErrZoneNotAuthoritative = errors.New("server is not authoritative for the zone")
+	// ErrTSIGBadKey means the server doesn't know the TSIG key or rejected the signature or its time
+	ErrTSIGBadKey = errors.New("server rejected the TSIG key")
+	// ErrRefused means the server's policy doesn't allow the request, e.g. the key may not update the names
+	ErrRefused = errors.New("server refused the request")
+	// ErrPrerequisiteFailed means the zone didn't hold what the prerequisites of an update required
+	ErrPrerequisiteFailed = errors.New("update prerequisites are not satisfied")
+)
+
+// RcodeError is returned when the server answers with an unsuccessful response code
+type RcodeError struct {
+	Rcode int
+	// TSIGError is the error from the TSIG record of the response, if there was one
+	TSIGError uint16
+}
+
+func (e *RcodeError) Error() string {
+	msg := fmt.Sprintf("server responded with Rcode %d: %s", e.Rcode, dns.RcodeToString[e.Rcode])
+	if e.TSIGError != dns.RcodeSuccess {
+		msg += fmt.Sprintf(" (TSIG error %s)", dns.RcodeToString[int(e.TSIGError)])
+	}
+	return msg
+}
+
+// Is maps the response code onto the error kinds above
+func (e *RcodeError) Is(target error) bool {
+	switch target {
+	case ErrTSIGBadKey:
+		return e.TSIGError != dns.RcodeSuccess
+	case ErrZoneNotAuthoritative:
+		return e.TSIGError == dns.RcodeSuccess && (e.Rcode == dns.RcodeNotAuth || e.Rcode == dns.RcodeNotZone)
+	case ErrRefused:
+		return e.Rcode == dns.RcodeRefused
+	case ErrPrerequisiteFailed:
+		switch e.Rcode {
+		case dns.RcodeYXDomain, dns.RcodeYXRrset, dns.RcodeNXRrset, dns.RcodeNameError:
+			return true
+		}
+	}
+	return false
+}
+
+// responseError returns the error for an unsuccessful response, or nil for a successful one
+func responseError(response *dns.Msg) error {
+	if response.Rcode == dns.RcodeSuccess {
+		return nil
+	}
+
+	err := &RcodeError{Rcode: response.Rcode}
+	if tsig := response.IsTsig(); tsig != nil {
+		err.TSIGError = tsig.Error
+	}
+	return err
+}
@@ -0,0 +1,255 @@
+//go:build !no_gssapi
+
+package bind
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	krbclient "github.com/jcmturner/gokrb5/v8/client"
+	krbconfig "github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// With bind.auth gss-tsig updates are signed with GSS-TSIG (RFC 3645) instead of a shared secret, for servers whose
+// update-policy grants updates to Kerberos principals, such as BIND with krb5-self or Active Directory integrated DNS.
+// The client logs in to the realm with the keys of a keytab, gets a ticket for the DNS server and establishes a
+// security context with it in a TKEY exchange. The context is used like a TSIG key named by the TKEY, its MACs being
+// Kerberos MIC tokens (RFC 4121). Contexts are negotiated again shortly before the lifetime the server granted runs
+// out and when the server rejects them, e.g. after it restarted.
+
+const (
+	// tkeyModeGSSAPI is the TKEY mode of GSS-API negotiations
+	tkeyModeGSSAPI = 3
+	// gssKeyLifetime is the lifetime requested for negotiated keys, servers may grant less
+	gssKeyLifetime = time.Hour
+	// gssRenewBefore is how long before it expires a key is replaced, so that updates in flight don't fail
+	gssRenewBefore = time.Minute
+)
+
+// gssSigner negotiates GSS-TSIG keys with a server and computes their MACs
+type gssSigner struct {
+	server  server
+	service string
+	krb     *krbclient.Client
+
+	mu sync.Mutex
+	// Name of the negotiated key, empty when there is none, and when the server lets it expire
+	name    string
+	expires time.Time
+	// Key the MIC tokens are computed with, the subkey of the server when it asserted one
+	key            types.EncryptionKey
+	acceptorSubkey bool
+	// Sequence number of the next MIC token
+	seq uint64
+}
+
+// newGSSSigner creates a signer logging in with the keytab of the configuration. Nothing is sent until the first key
+// is needed.
+func newGSSSigner(s server, cfg *config.GSSConfig) (negotiatedSigner, error) {
+	krb5conf, err := krbconfig.Load(cfg.KRB5Conf)
+	if err != nil {
+		return nil, fmt.Errorf("loading Kerberos configuration %s: %w", cfg.KRB5Conf, err)
+	}
+	kt, err := keytab.Load(cfg.Keytab)
+	if err != nil {
+		return nil, fmt.Errorf("loading keytab %s: %w", cfg.Keytab, err)
+	}
+	at := strings.LastIndex(cfg.Principal, "@")
+	if at < 0 {
+		return nil, fmt.Errorf("principal %s has no realm", cfg.Principal)
+	}
+
+	service := cfg.ServicePrincipal
+	if service == "" {
+		service = "DNS/" + strings.TrimSuffix(s.host, ".")
+	}
+	return &gssSigner{
+		server:  s,
+		service: service,
+		krb: krbclient.NewWithKeytab(cfg.Principal[:at], cfg.Principal[at+1:], kt, krb5conf,
+			krbclient.DisablePAFXFAST(true)),
+	}, nil
+}
+
+// keyName returns the name of the negotiated key, negotiating a new one when there is none or it is about to expire
+func (s *gssSigner) keyName(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.name != "" && time.Until(s.expires) > gssRenewBefore {
+		return s.name, nil
+	}
+	if err := s.negotiate(ctx); err != nil {
+		return "", fmt.Errorf("negotiating GSS-TSIG key with %s: %w", s.server.address(), err)
+	}
+	return s.name, nil
+}
+
+// reset drops the negotiated key
+func (s *gssSigner) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = ""
+}
+
+// negotiate establishes a security context with the server in a TKEY exchange, sending a Kerberos AP-REQ for the DNS
+// service and checking the AP-REP of the server, which proves that it holds the service key
+func (s *gssSigner) negotiate(ctx context.Context) error {
+	if err := s.krb.AffirmLogin(); err != nil {
+		return fmt.Errorf("logging in to Kerberos: %w", err)
+	}
+	ticket, sessionKey, err := s.krb.GetServiceTicket(s.service)
+	if err != nil {
+		return fmt.Errorf("getting a ticket for %s: %w", s.service, err)
+	}
+	apReq, err := spnego.NewKRB5TokenAPREQ(s.krb, ticket, sessionKey,
+		[]int{gssapi.ContextFlagInteg, gssapi.ContextFlagMutual}, []int{flags.APOptionMutualRequired})
+	if err != nil {
+		return fmt.Errorf("creating AP-REQ: %w", err)
+	}
+	token, err := apReq.Marshal()
+	if err != nil {
+		return fmt.Errorf("encoding AP-REQ: %w", err)
+	}
+
+	name := dns.Fqdn(strings.ToLower(rand.Text()) + ".tailscale-bind-ddns")
+	now := time.Now()
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, dns.TypeTKEY)
+	msg.Question[0].Qclass = dns.ClassANY
+	msg.Extra = append(msg.Extra, &dns.TKEY{
+		Hdr:        dns.RR_Header{Name: name, Rrtype: dns.TypeTKEY, Class: dns.ClassANY},
+		Algorithm:  gssTSIGAlgorithm,
+		Inception:  uint32(now.Unix()),
+		Expiration: uint32(now.Add(gssKeyLifetime).Unix()),
+		Mode:       tkeyModeGSSAPI,
+		KeySize:    uint16(len(token)),
+		Key:        hex.EncodeToString(token),
+	})
+
+	// Kerberos tokens don't fit into UDP datagrams reliably
+	client := &dns.Client{Net: config.TransportTCP, Timeout: gssNegotiationTimeout}
+	response, _, err := client.ExchangeContext(ctx, msg, s.server.address())
+	if err != nil {
+		return fmt.Errorf("sending TKEY query: %w", err)
+	}
+	if err := responseError(response); err != nil {
+		return err
+	}
+	var tkey *dns.TKEY
+	for _, rr := range response.Answer {
+		if answer, ok := rr.(*dns.TKEY); ok {
+			tkey = answer
+		}
+	}
+	if tkey == nil {
+		return fmt.Errorf("server answered without a TKEY record")
+	}
+	if tkey.Error != dns.RcodeSuccess {
+		return fmt.Errorf("server rejected the key: %s", dns.RcodeToString[int(tkey.Error)])
+	}
+
+	subkey, err := acceptorSubkey(tkey.Key, sessionKey)
+	if err != nil {
+		return err
+	}
+	s.key, s.acceptorSubkey = sessionKey, false
+	if subkey.KeyType != 0 {
+		s.key, s.acceptorSubkey = subkey, true
+	}
+	s.name = name
+	s.expires = time.Unix(int64(tkey.Expiration), 0)
+	s.seq = 0
+	klog.V(1).Infof("Negotiated GSS-TSIG key %s with %s as %s, valid until %s", name, s.server.address(),
+		s.krb.Credentials.CName().PrincipalNameString(), s.expires.Format(time.RFC3339))
+	return nil
+}
+
+// acceptorSubkey checks the AP-REP token of a TKEY response and returns the subkey the server asserted in it, a zero
+// key when it didn't
+func acceptorSubkey(key string, sessionKey types.EncryptionKey) (types.EncryptionKey, error) {
+	data, err := hex.DecodeString(key)
+	if err != nil {
+		return types.EncryptionKey{}, fmt.Errorf("decoding TKEY key: %w", err)
+	}
+	var reply spnego.KRB5Token
+	if err := reply.Unmarshal(data); err != nil {
+		return types.EncryptionKey{}, fmt.Errorf("decoding the reply of the server: %w", err)
+	}
+	if reply.IsKRBError() {
+		return types.EncryptionKey{}, fmt.Errorf("server rejected the Kerberos ticket: %s", reply.KRBError.Error())
+	}
+	if !reply.IsAPRep() {
+		return types.EncryptionKey{}, fmt.Errorf("server replied without an AP-REP")
+	}
+
+	plain, err := crypto.DecryptEncPart(reply.APRep.EncPart, sessionKey, keyusage.AP_REP_ENCPART)
+	if err != nil {
+		return types.EncryptionKey{}, fmt.Errorf("decrypting the AP-REP of the server: %w", err)
+	}
+	var part messages.EncAPRepPart
+	if err := part.Unmarshal(plain); err != nil {
+		return types.EncryptionKey{}, fmt.Errorf("decoding the AP-REP of the server: %w", err)
+	}
+	return part.Subkey, nil
+}
+
+// Generate computes the MIC token of a message signed with the negotiated key
+func (s *gssSigner) Generate(msg []byte, t *dns.TSIG) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !strings.EqualFold(t.Hdr.Name, s.name) {
+		return nil, fmt.Errorf("GSS-TSIG key %s is no longer established", t.Hdr.Name)
+	}
+	token := gssapi.MICToken{SndSeqNum: s.seq, Payload: msg}
+	if s.acceptorSubkey {
+		token.Flags = gssapi.MICTokenFlagAcceptorSubkey
+	}
+	s.seq++
+	if err := token.SetChecksum(s.key, keyusage.GSSAPI_INITIATOR_SIGN); err != nil {
+		return nil, fmt.Errorf("computing MIC: %w", err)
+	}
+	return token.Marshal()
+}
+
+// Verify checks the MIC token the server signed a response with
+func (s *gssSigner) Verify(msg []byte, t *dns.TSIG) error {
+	s.mu.Lock()
+	name, key := s.name, s.key
+	s.mu.Unlock()
+
+	if !strings.EqualFold(t.Hdr.Name, name) {
+		return dns.ErrSecret
+	}
+	mac, err := hex.DecodeString(t.MAC)
+	if err != nil {
+		return err
+	}
+	var token gssapi.MICToken
+	if err := token.Unmarshal(mac, true); err != nil {
+		return fmt.Errorf("decoding MIC: %w", err)
+	}
+	token.Payload = msg
+	if ok, err := token.Verify(key, keyusage.GSSAPI_ACCEPTOR_SIGN); !ok {
+		klog.V(2).Infof("MIC of the response doesn't verify: %v", err)
+		return dns.ErrSig
+	}
+	return nil
+}
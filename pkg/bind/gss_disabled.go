@@ -0,0 +1,15 @@
+//go:build no_gssapi
+
+package bind
+
+import (
+	"fmt"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+)
+
+// newGSSSigner reports that GSS-TSIG was left out of this build
+func newGSSSigner(server, *config.GSSConfig) (negotiatedSigner, error) {
+	return nil, fmt.Errorf("bind auth %s is not available in this build (built with the no_gssapi tag)",
+		config.AuthGSSTSIG)
+}
@@ -0,0 +1,95 @@
+//go:build !no_gssapi
+
+package bind
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGSSSigner(t *testing.T) {
+	key := types.EncryptionKey{KeyType: etypeID.AES256_CTS_HMAC_SHA1_96, KeyValue: make([]byte, 32)}
+	_, _ = rand.Read(key.KeyValue)
+	signer := &gssSigner{name: "abc.tailscale-bind-ddns.", key: key, acceptorSubkey: true}
+	tsig := &dns.TSIG{Hdr: dns.RR_Header{Name: "abc.tailscale-bind-ddns."}, Algorithm: gssTSIGAlgorithm}
+
+	// Requests carry initiator MIC tokens with increasing sequence numbers
+	for seq := range uint64(2) {
+		mac, err := signer.Generate([]byte("request"), tsig)
+		require.NoError(t, err)
+		var token gssapi.MICToken
+		require.NoError(t, token.Unmarshal(mac, false))
+		assert.Equal(t, seq, token.SndSeqNum)
+		assert.Equal(t, byte(gssapi.MICTokenFlagAcceptorSubkey), token.Flags)
+		token.Payload = []byte("request")
+		ok, err := token.Verify(key, keyusage.GSSAPI_INITIATOR_SIGN)
+		assert.True(t, ok, err)
+	}
+
+	// Responses are verified with the acceptor's MIC
+	response := gssapi.MICToken{
+		Flags:   gssapi.MICTokenFlagSentByAcceptor | gssapi.MICTokenFlagAcceptorSubkey,
+		Payload: []byte("response"),
+	}
+	require.NoError(t, response.SetChecksum(key, keyusage.GSSAPI_ACCEPTOR_SIGN))
+	mac, err := response.Marshal()
+	require.NoError(t, err)
+	tsig.MAC = hex.EncodeToString(mac)
+	assert.NoError(t, signer.Verify([]byte("response"), tsig))
+	assert.ErrorIs(t, signer.Verify([]byte("tampered"), tsig), dns.ErrSig)
+
+	// Keys that are no longer established aren't used
+	signer.reset()
+	_, err = signer.Generate([]byte("request"), tsig)
+	assert.ErrorContains(t, err, "no longer established")
+	assert.ErrorIs(t, signer.Verify([]byte("response"), tsig), dns.ErrSecret)
+}
+
+func TestNewGSSSigner(t *testing.T) {
+	dir := t.TempDir()
+	krb5Conf := filepath.Join(dir, "krb5.conf")
+	require.NoError(t, os.WriteFile(krb5Conf, []byte("[libdefaults]\n  default_realm = EXAMPLE.COM\n"), 0o600))
+
+	kt := keytab.New()
+	require.NoError(t, kt.AddEntry("ddns", "EXAMPLE.COM", "password", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96))
+	data, err := kt.Marshal()
+	require.NoError(t, err)
+	keytabFile := filepath.Join(dir, "ddns.keytab")
+	require.NoError(t, os.WriteFile(keytabFile, data, 0o600))
+
+	// The service principal defaults to the one of the server, nothing is sent yet
+	signer, err := newGSSSigner(server{host: "ns1.example.com.", port: 53}, &config.GSSConfig{
+		Principal: "ddns@EXAMPLE.COM",
+		Keytab:    keytabFile,
+		KRB5Conf:  krb5Conf,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "DNS/ns1.example.com", signer.(*gssSigner).service)
+
+	_, err = newGSSSigner(server{host: "ns1.example.com", port: 53}, &config.GSSConfig{
+		Principal: "ddns@EXAMPLE.COM",
+		Keytab:    filepath.Join(dir, "missing.keytab"),
+		KRB5Conf:  krb5Conf,
+	})
+	assert.ErrorContains(t, err, "loading keytab")
+
+	_, err = newGSSSigner(server{host: "ns1.example.com", port: 53}, &config.GSSConfig{
+		Principal: "ddns@EXAMPLE.COM",
+		KRB5Conf:  filepath.Join(dir, "missing.conf"),
+	})
+	assert.ErrorContains(t, err, "loading Kerberos configuration")
+}
@@ -0,0 +1,104 @@
+package bind
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"k8s.io/klog/v2"
+)
+
+// With a lease every record set this client publishes has to be refreshed, i.e. be part of a desired record set, within
+// the lease or it is withdrawn, like a dyndns client that stops refreshing its name. Updates only remove the records
+// that dropped out of the desired record set, so a removal that is never seen, e.g. because polling stopped, would
+// otherwise leave a record published forever. ExpireLeases withdraws the record sets whose lease ran out on its own
+// schedule, whether or not an update comes along. Until then record sets that are no longer desired are kept and
+// tracked as ours, as with bind.max_record_age.
+
+// SetLease sets how long a published record set is kept without being refreshed, 0 disables leases. It must be called
+// before the first update.
+func (c *Client) SetLease(lease time.Duration) {
+	c.lease = lease
+}
+
+// retention returns how long record sets that are no longer desired are kept with bind.remove_stale disabled: the
+// shorter of the lease and bind.max_record_age, 0 when neither is set
+func (c *Client) retention() time.Duration {
+	switch {
+	case c.lease <= 0:
+		return c.maxRecordAge
+	case c.maxRecordAge <= 0:
+		return c.lease
+	}
+	return min(c.lease, c.maxRecordAge)
+}
+
+// ExpireLeases removes the record sets whose lease ran out and returns how many records were removed. Zones are
+// handled independently, so that one failing zone doesn't keep the others from expiring.
+func (c *Client) ExpireLeases(ctx context.Context, dryRun bool) (int, error) {
+	if c.lease <= 0 {
+		return 0, nil
+	}
+
+	c.updateMu.Lock()
+	defer c.updateMu.Unlock()
+
+	var (
+		removed int
+		errs    []error
+	)
+	for _, zone := range c.publishedZones() {
+		change := c.expiredChange(zone)
+		if len(change.removals) == 0 {
+			continue
+		}
+		if dryRun {
+			klog.Infof("DRY RUN: Would remove %d records with an expired lease from zone %s", len(change.removals),
+				zone)
+			removed += len(change.removals)
+			continue
+		}
+
+		key, secret, err := c.signingKey()
+		if err != nil {
+			return removed, fmt.Errorf("creating TSIG key: %w", err)
+		}
+		result := c.updateZone(ctx, change, key, secret)
+		if result.err != nil {
+			errs = append(errs, fmt.Errorf("zone %s: %w", zone, result.err))
+			continue
+		}
+		klog.Infof("Removed %d records with an expired lease from zone %s", result.Removed, zone)
+		removed += result.Removed
+	}
+	return removed, errors.Join(errs...)
+}
+
+// expiredChange returns the change removing the record sets of a zone that weren't refreshed within the lease, keeping
+// the others
+func (c *Client) expiredChange(zone string) zoneChange {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	now := clock.Or(c.clock).Now()
+	change := zoneChange{zone: zone}
+	records, ok := c.published[zone]
+	if ok {
+		change.previous = groupByKey(records)
+	} else {
+		records = c.recovered[zone]
+	}
+	for _, record := range records {
+		age := now.Sub(c.refreshed[zone][record.Key()])
+		if age <= c.lease {
+			change.desired = append(change.desired, record)
+			continue
+		}
+		klog.V(1).Infof("Removing %s record %s: lease expired, not refreshed for %v", record.Key().Type, record.Name,
+			age.Truncate(time.Second))
+		change.removals = append(change.removals, record)
+	}
+	return change
+}
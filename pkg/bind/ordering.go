@@ -0,0 +1,106 @@
+package bind
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// Zones are updated in groups: with the forward_first and reverse_first orders the forward and the reverse zones
+// each form a group and the second group only starts once every update of the first one has finished, so that e.g.
+// PTR records are only published after the names they point at. Within a group, zones are updated in name order with
+// up to zoneConcurrency updates in flight.
+
+// isReverseZone reports whether a zone holds reverse (PTR) records
+func isReverseZone(zone string) bool {
+	zone = strings.ToLower(dns.Fqdn(zone))
+	return dns.IsSubDomain("in-addr.arpa.", zone) || dns.IsSubDomain("ip6.arpa.", zone)
+}
+
+// zoneGroups splits zones into the groups they are updated in, according to the configured zone order
+func (c *Client) zoneGroups(zones []string) [][]string {
+	sorted := slices.Sorted(slices.Values(zones))
+
+	var forward, reverse []string
+	for _, zone := range sorted {
+		if isReverseZone(zone) {
+			reverse = append(reverse, zone)
+		} else {
+			forward = append(forward, zone)
+		}
+	}
+
+	var groups [][]string
+	switch c.zoneOrder {
+	case config.ZoneOrderForwardFirst:
+		groups = [][]string{forward, reverse}
+	case config.ZoneOrderReverseFirst:
+		groups = [][]string{reverse, forward}
+	default:
+		groups = [][]string{sorted}
+	}
+
+	return slices.DeleteFunc(groups, func(group []string) bool { return len(group) == 0 })
+}
+
+// updateZones calls update for every zone, group by group, and returns the errors of the failed updates. Once an update
+// fails because of the TSIG key no further updates signed with that key are started, as they would be rejected as well.
+func (c *Client) updateZones(zones []string, update func(zone string) error) []error {
+	concurrency := max(c.zoneConcurrency, 1)
+
+	var (
+		mu      sync.Mutex
+		errs    []error
+		badKeys sync.Map // Names of the rejected keys
+		limiter = make(chan struct{}, concurrency)
+	)
+
+	for _, group := range c.zoneGroups(zones) {
+		var wg sync.WaitGroup
+		for _, zone := range group {
+			keyName := dns.CanonicalName(c.zoneKeyName(zone))
+			if _, bad := badKeys.Load(keyName); bad {
+				continue
+			}
+
+			limiter <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer func() {
+					<-limiter
+					wg.Done()
+				}()
+
+				// The key may have been rejected while this update was waiting for its turn
+				if _, bad := badKeys.Load(keyName); bad {
+					return
+				}
+				err := update(zone)
+				if err == nil {
+					return
+				}
+
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("sending update to zone %s: %w", zone, err))
+				mu.Unlock()
+				if errors.Is(err, ErrTSIGBadKey) {
+					// The remaining updates signed with the key would be rejected as well
+					badKeys.Store(keyName, true)
+					klog.Errorf("Update to zone %s was rejected because of the TSIG key, skipping remaining zones "+
+						"signed with it: %v", zone, err)
+					return
+				}
+				klog.Warningf("Update to zone %s failed, continuing with remaining zones: %v", zone, err)
+			}()
+		}
+		wg.Wait()
+	}
+
+	return errs
+}
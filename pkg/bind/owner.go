@@ -0,0 +1,11 @@
+package bind
+
+// Providers that can attach metadata to the records they publish, such as the comments of PowerDNS record sets, mark
+// them as owned by this tool along with the device they were published for, the version that published them and when.
+// Marked records are recognized as ours after a restart, so that they are cleaned up like the records published since.
+
+// Owner is the name the records published by this tool are marked with
+const Owner = "tailscale-bind-ddns"
+
+// Version is the version of the tool recorded with the records it publishes, set from the build information
+var Version = "dev"
@@ -0,0 +1,221 @@
+package bind
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// A single record the key may not update makes the server refuse the whole update. With bind.update_policy, the
+// grant and deny rules of the zone's BIND update-policy are mirrored here, e.g.
+//
+//	grant tailscale-bind-ddns-key subdomain ts.example.com. A AAAA TXT;
+//	grant tailscale-bind-ddns-key zonesub PTR;
+//
+// so that records the key may not update are skipped and reported instead of failing the update of their zone. Like
+// BIND, the first rule matching the key, name and type decides, and records no rule matches are denied. Rules apply to
+// every zone and only the rule types that don't need external helpers are understood.
+
+// Policy rule types, as in the update-policy statement of named.conf
+const (
+	policyName      = "name"
+	policySubdomain = "subdomain"
+	policyZonesub   = "zonesub"
+	policyWildcard  = "wildcard"
+	policySelf      = "self"
+	policySelfsub   = "selfsub"
+	policySelfwild  = "selfwild"
+)
+
+// policyRule is a single grant or deny rule of an update policy
+type policyRule struct {
+	grant    bool
+	identity string // Canonical key name, may start with a wildcard label
+	ruleType string
+	name     string   // Canonical name the rule type matches against, empty for zonesub and the self rules
+	types    []string // Record types the rule covers, every type this tool publishes when empty
+}
+
+// parseUpdatePolicy parses update-policy rules written as in named.conf, with or without the trailing semicolon
+func parseUpdatePolicy(rules []string) ([]policyRule, error) {
+	parsed := make([]policyRule, 0, len(rules))
+	for i, rule := range rules {
+		fields := strings.Fields(strings.TrimSuffix(strings.TrimSpace(rule), ";"))
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("update policy rule %d %q: expected grant|deny <key> <rule type> [name] [types]",
+				i+1, rule)
+		}
+
+		r := policyRule{identity: dns.CanonicalName(fields[1]), ruleType: strings.ToLower(fields[2])}
+		switch strings.ToLower(fields[0]) {
+		case "grant":
+			r.grant = true
+		case "deny":
+		default:
+			return nil, fmt.Errorf("update policy rule %d %q: must start with grant or deny", i+1, rule)
+		}
+
+		rest := fields[3:]
+		switch r.ruleType {
+		case policyName, policySubdomain, policyWildcard:
+			if len(rest) == 0 {
+				return nil, fmt.Errorf("update policy rule %d %q: %s rules need a name", i+1, rule, r.ruleType)
+			}
+			r.name, rest = dns.CanonicalName(rest[0]), rest[1:]
+		case policyZonesub:
+		case policySelf, policySelfsub, policySelfwild:
+			// The name of self rules is ignored by BIND as well, it has to repeat the identity
+			if len(rest) > 0 {
+				rest = rest[1:]
+			}
+		default:
+			return nil, fmt.Errorf("update policy rule %d %q: unsupported rule type %s", i+1, rule, r.ruleType)
+		}
+
+		for _, recordType := range rest {
+			// Types may carry a limit on the number of records, e.g. A(2), which doesn't matter here
+			recordType, _, _ = strings.Cut(strings.ToUpper(recordType), "(")
+			if _, ok := dns.StringToType[recordType]; !ok {
+				return nil, fmt.Errorf("update policy rule %d %q: unknown record type %s", i+1, rule, recordType)
+			}
+			if recordType != "ANY" {
+				r.types = append(r.types, recordType)
+			}
+		}
+		parsed = append(parsed, r)
+	}
+	return parsed, nil
+}
+
+// matches reports whether the rule covers an update of a record type at a name in zone by the key
+func (r policyRule) matches(key, zone, name, recordType string) bool {
+	if !matchesWildcard(r.identity, key) {
+		return false
+	}
+	if len(r.types) > 0 && !containsFold(r.types, recordType) {
+		return false
+	}
+
+	switch r.ruleType {
+	case policyName:
+		return name == r.name
+	case policySubdomain:
+		return dns.IsSubDomain(r.name, name)
+	case policyZonesub:
+		return dns.IsSubDomain(zone, name)
+	case policyWildcard:
+		return matchesWildcard(r.name, name)
+	case policySelf:
+		return name == key
+	case policySelfsub:
+		return dns.IsSubDomain(key, name)
+	case policySelfwild:
+		return name != key && dns.IsSubDomain(key, name) && dns.CountLabel(name) == dns.CountLabel(key)+1
+	}
+	return false
+}
+
+// matchesWildcard reports whether a canonical name matches a pattern that may start with a wildcard label, which
+// matches any name strictly below the rest of the pattern
+func matchesWildcard(pattern, name string) bool {
+	if rest, ok := strings.CutPrefix(pattern, "*."); ok {
+		return name != rest && dns.IsSubDomain(rest, name)
+	}
+	return pattern == name
+}
+
+// containsFold reports whether values holds value, ignoring case
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// policyAllows reports whether the update policy lets our key update a record in zone. Every record is allowed when
+// no policy is configured. With the ownership registry the owner TXT record at the record's name has to be allowed
+// too, since it's part of every update touching the name.
+func (c *Client) policyAllows(zone string, record DNSRecord) bool {
+	if len(c.updatePolicy) == 0 {
+		return true
+	}
+
+	key := dns.CanonicalName(c.zoneKeyName(zone))
+
+	name := recordFQDN(zone, record)
+	types := []string{string(record.Key().Type)}
+	if c.ownerID != "" && record.Key().Type != TypeTXT {
+		types = append(types, string(TypeTXT))
+	}
+	for _, recordType := range types {
+		if !c.policyGrants(key, dns.CanonicalName(zone), name, recordType) {
+			return false
+		}
+	}
+	return true
+}
+
+// policyGrants evaluates the update policy for a single record type, the first matching rule decides
+func (c *Client) policyGrants(key, zone, name, recordType string) bool {
+	for _, rule := range c.updatePolicy {
+		if rule.matches(key, zone, name, recordType) {
+			return rule.grant
+		}
+	}
+	return false
+}
+
+// filterPolicy drops the upserts and removals of a zone change that the update policy doesn't let our key make, so
+// that they don't get the whole update refused
+func (c *Client) filterPolicy(change *zoneChange) {
+	if len(c.updatePolicy) == 0 {
+		return
+	}
+
+	allowed := func(record DNSRecord, action string) bool {
+		if c.policyAllows(change.zone, record) {
+			return true
+		}
+		klog.Warningf("Not %s %s record %s: the update policy doesn't allow it", action, record.Key().Type,
+			recordFQDN(change.zone, record))
+		change.skipped = append(change.skipped, SkippedRecord{Record: record, Reason: SkipDeniedByPolicy})
+		return false
+	}
+
+	var upserts, removals []DNSRecord
+	for _, record := range change.upserts {
+		if allowed(record, "publishing") {
+			upserts = append(upserts, record)
+		}
+	}
+	for _, record := range change.removals {
+		if allowed(record, "removing") {
+			removals = append(removals, record)
+		}
+	}
+
+	// Denied records never make it to the server, so they must not be remembered as published either
+	change.desired = slices.DeleteFunc(slices.Clone(change.desired), func(record DNSRecord) bool {
+		return !c.policyAllows(change.zone, record)
+	})
+	change.upserts, change.removals = upserts, removals
+}
+
+// allowedByPolicy returns the records of a zone the update policy lets our key update, logging the others
+func (c *Client) allowedByPolicy(zone string, records []DNSRecord) []DNSRecord {
+	var allowed []DNSRecord
+	for _, record := range records {
+		if c.policyAllows(zone, record) {
+			allowed = append(allowed, record)
+			continue
+		}
+		klog.Warningf("Not publishing %s record %s: the update policy doesn't allow it", record.Key().Type,
+			recordFQDN(zone, record))
+	}
+	return allowed
+}
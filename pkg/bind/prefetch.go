@@ -0,0 +1,71 @@
+package bind
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// When updates read the zones they change, i.e. with bind.zone_transfer together with query_before_update or the
+// ownership registry, the zones can be transferred while the devices are still being fetched from Tailscale instead of
+// afterwards, which roughly halves the latency of a sync cycle on large tailnets. A prefetched transfer is used by the
+// first read of its zone within prefetchMaxAge and dropped otherwise, so that updates never work from outdated records.
+
+const prefetchMaxAge = 30 * time.Second
+
+// prefetchedZone is the transfer of a zone made ahead of an update
+type prefetchedZone struct {
+	snapshot *zoneSnapshot // nil when the zone can't be transferred
+	fetched  time.Time
+}
+
+// PrefetchZones transfers the zones the next update is going to read. It does nothing unless updates read zones from
+// transfers.
+func (c *Client) PrefetchZones(ctx context.Context) {
+	if !c.zoneTransfer || (!c.queryBeforeUpdate && c.ownerID == "") {
+		return
+	}
+
+	zones := c.publishedZones()
+	if !slices.Contains(zones, c.zone) {
+		zones = append(zones, c.zone)
+	}
+	for _, zone := range zones {
+		snapshot, err := c.transferZone(ctx, zone)
+		if errors.Is(err, ErrTransferRefused) {
+			snapshot, err = nil, nil
+		}
+		if err != nil {
+			klog.V(1).Infof("Failed to prefetch zone %s, it is read with the update instead: %v", zone, err)
+			continue
+		}
+
+		c.statusMu.Lock()
+		if c.prefetched == nil {
+			c.prefetched = make(map[string]prefetchedZone)
+		}
+		c.prefetched[dns.CanonicalName(zone)] = prefetchedZone{snapshot: snapshot, fetched: clock.Or(c.clock).Now()}
+		c.statusMu.Unlock()
+	}
+}
+
+// takePrefetched returns the prefetched transfer of a zone if it's recent enough, at most once
+func (c *Client) takePrefetched(zone string) (*zoneSnapshot, bool) {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	prefetched, ok := c.prefetched[zone]
+	if !ok {
+		return nil, false
+	}
+	delete(c.prefetched, zone)
+	if clock.Or(c.clock).Since(prefetched.fetched) > prefetchMaxAge {
+		return nil, false
+	}
+	return prefetched.snapshot, true
+}
@@ -0,0 +1,224 @@
+package bind
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"k8s.io/klog/v2"
+)
+
+// A record set that couldn't be published, e.g. because the server was unreachable, is retried with backoff until it
+// succeeds, a newer record set replaces it or bind.retry.max_attempts were made. With a queue file the pending record
+// set is also written to disk, so that it is still applied when the process restarts before the server comes back.
+
+const (
+	retryMinInterval = config.DefaultRetryInitialBackoff
+	retryMaxInterval = config.DefaultRetryMaxBackoff
+)
+
+// UpdateFunc publishes a record set, e.g. Client.UpdateRecords
+type UpdateFunc func(ctx context.Context, records []DNSRecord, dryRun bool) (*SyncResult, error)
+
+// pendingUpdate is the content of the queue file
+type pendingUpdate struct {
+	Queued  time.Time   `json:"queued"`
+	Records []DNSRecord `json:"records"`
+}
+
+// loadQueue returns the record set left in the queue file, nil when there is none
+func (c *Client) loadQueue() ([]DNSRecord, error) {
+	if c.queueFile == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(c.queueFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading queue file: %w", err)
+	}
+
+	var pending pendingUpdate
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return nil, fmt.Errorf("parsing queue file %s: %w", c.queueFile, err)
+	}
+	klog.Infof("Found %d records queued at %s that were not published yet", len(pending.Records),
+		pending.Queued.Format(time.RFC3339))
+	// An empty record set is still a pending update, it removes every record published earlier
+	if pending.Records == nil {
+		pending.Records = []DNSRecord{}
+	}
+	return pending.Records, nil
+}
+
+// saveQueue writes a pending record set to the queue file. The file is replaced atomically so that a crash never
+// leaves a partial record set behind.
+func (c *Client) saveQueue(records []DNSRecord) error {
+	if c.queueFile == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(pendingUpdate{Queued: clock.Or(c.clock).Now(), Records: records})
+	if err != nil {
+		return fmt.Errorf("encoding queue: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.queueFile), filepath.Base(c.queueFile)+".*")
+	if err != nil {
+		return fmt.Errorf("creating queue file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing queue file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing queue file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.queueFile); err != nil {
+		return fmt.Errorf("replacing queue file: %w", err)
+	}
+	return nil
+}
+
+// clearQueue removes the queue file once its record set was published
+func (c *Client) clearQueue() error {
+	if c.queueFile == "" {
+		return nil
+	}
+	if err := os.Remove(c.queueFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing queue file: %w", err)
+	}
+	return nil
+}
+
+// nextRetryInterval doubles the retry interval from the initial backoff up to the maximum backoff, retryMinInterval
+// and retryMaxInterval unless the retry policy sets them
+func (c *Client) nextRetryInterval(interval time.Duration) time.Duration {
+	initial := cmp.Or(c.retry.InitialBackoff, retryMinInterval)
+	if interval == 0 {
+		return initial
+	}
+	return min(2*interval, max(cmp.Or(c.retry.MaxBackoff, retryMaxInterval), initial))
+}
+
+// jitterInterval randomly shortens or lengthens a retry interval by up to the jitter fraction of the retry policy
+func (c *Client) jitterInterval(interval time.Duration) time.Duration {
+	if c.retry.Jitter <= 0 {
+		return interval
+	}
+	return interval + time.Duration(c.retry.Jitter*(2*rand.Float64()-1)*float64(interval))
+}
+
+// ProcessUpdates publishes record sets received on recordChan with update until the context is cancelled or the
+// channel is closed. A record set that fails is retried with backoff until it succeeds, a newer one arrives or the
+// retry policy's attempts are used up, and is kept in the client's queue file meanwhile. A record set left in the queue
+// file by an earlier run is published first.
+func (c *Client) ProcessUpdates(
+	ctx context.Context,
+	recordChan <-chan []DNSRecord,
+	dryRun bool,
+	update UpdateFunc,
+) {
+	pending, err := c.loadQueue()
+	if err != nil {
+		klog.Errorf("Failed to load queued records: %v", err)
+	}
+
+	var (
+		retry    clock.Timer
+		retryC   <-chan time.Time
+		interval time.Duration
+		attempts int
+		queued   bool
+	)
+	defer func() {
+		if retry != nil {
+			retry.Stop()
+		}
+	}()
+
+	apply := func(records []DNSRecord, retrying bool) {
+		if retry != nil {
+			retry.Stop()
+			retryC = nil
+		}
+
+		result, err := update(ctx, records, dryRun)
+		if result != nil && c.resultHook != nil {
+			c.resultHook(result)
+		}
+		if err == nil {
+			pending, interval, attempts = nil, 0, 0
+			if queued {
+				if err := c.clearQueue(); err != nil {
+					klog.Errorf("Failed to clear queued records: %v", err)
+				}
+				queued = false
+			}
+			return
+		}
+
+		// A new record set replaces the pending one and starts over with the shortest retry interval
+		if !retrying {
+			interval, attempts = 0, 0
+			if !dryRun {
+				if err := c.saveQueue(records); err != nil {
+					klog.Errorf("Failed to queue records: %v", err)
+				} else {
+					queued = c.queueFile != ""
+				}
+			}
+		}
+		pending = records
+		if ctx.Err() != nil {
+			return
+		}
+
+		// The queue file is kept, so that a restart still publishes the record set
+		attempts++
+		if c.retry.MaxAttempts > 0 && attempts >= c.retry.MaxAttempts {
+			klog.Errorf("Failed to update records, giving up after %d attempts until the next record set: %v",
+				attempts, err)
+			pending, interval, attempts = nil, 0, 0
+			return
+		}
+
+		interval = c.nextRetryInterval(interval)
+		wait := c.jitterInterval(interval)
+		klog.Errorf("Failed to update records, retrying in %v: %v", wait, err)
+		retry = clock.Or(c.clock).NewTimer(wait)
+		retryC = retry.C()
+	}
+
+	if pending != nil {
+		queued = true
+		apply(pending, true)
+	}
+
+	for {
+		select {
+		case records, ok := <-recordChan:
+			if !ok {
+				return
+			}
+			apply(records, false)
+		case <-retryC:
+			klog.Infof("Retrying update of %d records", len(pending))
+			apply(pending, true)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
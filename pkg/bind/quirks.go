@@ -0,0 +1,101 @@
+package bind
+
+import (
+	"slices"
+
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// Updates are shaped the way BIND handles them best: every changed record set is deleted as a whole and then inserted
+// again, without any prerequisites. Other authoritative servers, e.g. Knot DNS with a restrictive update ACL, may
+// refuse parts of that, so the shape can be adjusted:
+//
+//   - bind.strict_rrset_removal never deletes a record set as a whole (class ANY), only the individual records this
+//     client published (class NONE). Record sets whose previous values aren't known, e.g. after a restart, only get
+//     the new values added.
+//   - bind.use_prerequisites makes every update conditional on the zone still holding what this client last
+//     published: replaced and deleted record sets must hold exactly their previous values and added record sets must
+//     not exist yet. When something else changed them, the server refuses the whole update instead of it overwriting
+//     the change, and the next update republishes the zone in full, like after a restart. TXT record sets are left
+//     unguarded since they share their names with the owner records of the registry.
+
+// zoneUpdate builds the update message of a zone change in the shape the quirk flags call for
+func (c *Client) zoneUpdate(change zoneChange) *dns.Msg {
+	var msg *dns.Msg
+	if c.strictRRsetRemoval {
+		msg = buildStrictZoneUpdate(change)
+	} else {
+		msg = buildZoneUpdate(change.zone, change.upserts, change.removals)
+	}
+	if c.usePrerequisites {
+		addPrerequisites(msg, change)
+	}
+	return msg
+}
+
+// buildStrictZoneUpdate builds the update message of a zone change that only ever deletes individual records: the
+// previous values of replaced record sets and the values of stale ones
+func buildStrictZoneUpdate(change zoneChange) *dns.Msg {
+	upserts := slices.Clone(change.upserts)
+	sortRecords(upserts)
+	removals := slices.Clone(change.removals)
+	sortRecords(removals)
+
+	msg := new(dns.Msg)
+	msg.SetUpdate(dns.Fqdn(change.zone))
+
+	cleared := make(map[RecordKey]bool, len(upserts))
+	for _, record := range upserts {
+		if !cleared[record.Key()] {
+			cleared[record.Key()] = true
+			msg.Remove(recordRRs(change.zone, change.previous[record.Key()]))
+		}
+		msg.Insert(recordRRs(change.zone, []DNSRecord{record}))
+	}
+
+	for _, record := range removals {
+		if cleared[record.Key()] {
+			continue
+		}
+		klog.V(1).Infof("Removing stale %s record: %s", record.Key().Type, record.Name)
+		msg.Remove(recordRRs(change.zone, []DNSRecord{record}))
+	}
+	return msg
+}
+
+// addPrerequisites makes an update conditional on the record sets it touches still holding what this client last
+// published. Zones without a confirmed update get no prerequisites, their contents are unknown.
+func addPrerequisites(msg *dns.Msg, change zoneChange) {
+	if change.previous == nil {
+		return
+	}
+
+	records := slices.Concat(change.upserts, change.removals)
+	sortRecords(records)
+	guarded := make(map[RecordKey]bool)
+	for _, record := range records {
+		key := record.Key()
+		if guarded[key] || key.Type == TypeTXT {
+			continue
+		}
+		guarded[key] = true
+
+		if previous, ok := change.previous[key]; ok {
+			msg.Used(recordRRs(change.zone, previous))
+		} else if rrset := removalRRset(change.zone, record); rrset != nil {
+			msg.RRsetNotUsed([]dns.RR{rrset})
+		}
+	}
+}
+
+// recordRRs returns the resource records of records in zone, leaving out those with an invalid value
+func recordRRs(zone string, records []DNSRecord) []dns.RR {
+	rrs := make([]dns.RR, 0, len(records))
+	for _, record := range records {
+		if rr := record.RR(zone); rr != nil {
+			rrs = append(rrs, rr)
+		}
+	}
+	return rrs
+}
@@ -0,0 +1,325 @@
+package bind
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// Reconciliation removes records that this client published earlier but that are no longer part of the desired record
+// set, e.g. because their machine went offline or left the tailnet. Ownership is tracked from confirmed updates, so
+// only record sets this client itself created are ever removed and records managed by hand are left alone.
+//
+// With bind.remove_stale disabled, records that are no longer desired are left in place. bind.max_record_age is a
+// backstop for removal events that are missed that way: record sets that haven't been desired for longer than the
+// maximum age are withdrawn anyway, while younger ones are kept and still tracked as ours. A lease (see lease.go)
+// withdraws them the same way.
+
+// zoneChange is the update that brings a zone from its last confirmed state to the desired records
+type zoneChange struct {
+	zone     string
+	desired  []DNSRecord // Every record of ours the zone holds once the update was applied
+	upserts  []DNSRecord // Records added or changed since the last confirmed update
+	removals []DNSRecord // Records published earlier that are no longer desired
+
+	// Records of the last confirmed update by record set, nil when the zone's contents are unknown
+	previous map[RecordKey][]DNSRecord
+
+	skipped []SkippedRecord // Records left out of the update, with the reason why
+}
+
+// empty reports whether the zone is already up to date
+func (z zoneChange) empty() bool {
+	return len(z.upserts) == 0 && len(z.removals) == 0
+}
+
+// pendingChange returns the change needed to bring a zone to the desired records. Only records that were added or
+// changed since the last confirmed update are sent, so that unchanged records don't churn the server's journal. A zone
+// without a confirmed update gets every record, since its contents are unknown.
+func (c *Client) pendingChange(zone string, desired []DNSRecord) zoneChange {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	now := clock.Or(c.clock).Now()
+	if c.refreshed == nil {
+		c.refreshed = make(map[string]map[RecordKey]time.Time)
+	}
+	if c.refreshed[zone] == nil {
+		c.refreshed[zone] = make(map[RecordKey]time.Time)
+	}
+	for _, record := range desired {
+		c.refreshed[zone][record.Key()] = now
+	}
+
+	change := zoneChange{zone: zone, desired: desired}
+	var removed []DNSRecord
+	if previous, ok := c.published[zone]; ok {
+		change.previous = groupByKey(previous)
+		diff := DiffRecords(previous, desired)
+		change.upserts = append(diff.Added, diff.Changed...)
+		removed = diff.Removed
+	} else {
+		// The zone's contents are unknown, but the records recovered from the state file that are no longer desired
+		// are still ours to remove
+		change.upserts = desired
+		removed = DiffRecords(c.recovered[zone], desired).Removed
+	}
+
+	switch {
+	case c.removeStale:
+		change.removals = removed
+	case c.retention() > 0:
+		for _, record := range removed {
+			age := now.Sub(c.refreshed[zone][record.Key()])
+			if age <= c.retention() {
+				change.desired = append(slices.Clip(change.desired), record)
+				continue
+			}
+			klog.V(1).Infof("Removing %s record %s: not refreshed for %v", record.Key().Type, record.Name,
+				age.Truncate(time.Second))
+			change.removals = append(change.removals, record)
+		}
+	}
+	return change
+}
+
+// markPublished records the record set of a confirmed update as owned by this client
+func (c *Client) markPublished(zone string, records []DNSRecord) {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	// Record sets that are no longer ours don't need their refresh times anymore
+	sets := groupByKey(records)
+	maps.DeleteFunc(c.refreshed[zone], func(key RecordKey, _ time.Time) bool {
+		_, ok := sets[key]
+		return !ok
+	})
+
+	delete(c.recovered, zone)
+	c.saveState(zone, records)
+	if len(records) == 0 {
+		delete(c.published, zone)
+		return
+	}
+	if c.published == nil {
+		c.published = make(map[string][]DNSRecord)
+	}
+	c.published[zone] = slices.Clone(records)
+}
+
+// forgetPublished drops the confirmed state of a zone, so that the next update republishes it in full
+func (c *Client) forgetPublished(zone string) {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	delete(c.published, zone)
+}
+
+// hasPublished reports whether this client owns any records, including those recovered from the state file
+func (c *Client) hasPublished() bool {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	return len(c.published) > 0 || len(c.recovered) > 0
+}
+
+// publishedZones returns the zones this client owns records in, including those recovered from the state file
+func (c *Client) publishedZones() []string {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	zones := slices.Collect(maps.Keys(c.published))
+	for zone := range c.recovered {
+		if _, ok := c.published[zone]; !ok {
+			zones = append(zones, zone)
+		}
+	}
+	slices.Sort(zones)
+	return zones
+}
+
+// removalChange returns the change removing every record this client owns in a zone, including those recovered from
+// the state file
+func (c *Client) removalChange(zone string) zoneChange {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	change := zoneChange{zone: zone}
+	if previous, ok := c.published[zone]; ok {
+		change.previous = groupByKey(previous)
+		change.removals = slices.Clone(previous)
+	}
+	change.removals = append(change.removals, DiffRecords(c.recovered[zone], change.removals).Removed...)
+	return change
+}
+
+// RemoveAll removes every record this client published, whatever bind.remove_stale says, and returns how many were
+// removed. Zones are removed from independently, so that one failing zone doesn't leave the others populated. Once
+// every zone is empty the queue is cleared as well, so that a restart doesn't publish its records again.
+func (c *Client) RemoveAll(ctx context.Context, dryRun bool) (int, error) {
+	c.updateMu.Lock()
+	defer c.updateMu.Unlock()
+
+	key, secret, err := c.signingKey()
+	if err != nil {
+		return 0, fmt.Errorf("creating TSIG key: %w", err)
+	}
+
+	var (
+		removed int
+		errs    []error
+	)
+	for _, zone := range c.publishedZones() {
+		change := c.removalChange(zone)
+		if dryRun {
+			klog.Infof("DRY RUN: Would remove %d records from zone %s", len(change.removals), zone)
+			removed += len(change.removals)
+			continue
+		}
+
+		result := c.updateZone(ctx, change, key, secret)
+		if result.err != nil {
+			errs = append(errs, fmt.Errorf("zone %s: %w", zone, result.err))
+			continue
+		}
+		klog.Infof("Removed %d records from zone %s", result.Removed, zone)
+		removed += result.Removed
+	}
+
+	if len(errs) == 0 && !dryRun {
+		if err := c.clearQueue(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return removed, errors.Join(errs...)
+}
+
+// skipApplied drops the upserts of a zone change whose record sets the server already holds with the desired values
+// and TTL. Record sets holding anything else, such as additional values, are still replaced.
+func (c *Client) skipApplied(ctx context.Context, reader *zoneReader, change *zoneChange) error {
+	sets := make(map[RecordKey][]DNSRecord)
+	var keys []RecordKey
+	for _, record := range change.upserts {
+		if _, ok := sets[record.Key()]; !ok {
+			keys = append(keys, record.Key())
+		}
+		sets[record.Key()] = append(sets[record.Key()], record)
+	}
+
+	var upserts []DNSRecord
+	for _, key := range keys {
+		applied, err := c.recordSetApplied(ctx, reader, change.zone, sets[key])
+		if err != nil {
+			return fmt.Errorf("looking up %s record %s: %w", key.Type, key.Name, err)
+		}
+		if applied {
+			klog.V(1).Infof("Skipping %s record %s: the server already holds it", key.Type, key.Name)
+			for _, record := range sets[key] {
+				change.skipped = append(change.skipped, SkippedRecord{Record: record, Reason: SkipAlreadyApplied})
+			}
+			continue
+		}
+		upserts = append(upserts, sets[key]...)
+	}
+	change.upserts = upserts
+	return nil
+}
+
+// recordSetApplied reports whether a record set on the server consists of exactly the given records, all of which
+// share their type and name
+func (c *Client) recordSetApplied(
+	ctx context.Context,
+	reader *zoneReader,
+	zone string,
+	records []DNSRecord,
+) (bool, error) {
+	qtype := records[0].Key().Type.Qtype()
+	name := recordFQDN(zone, records[0])
+	answers, err := reader.lookup(ctx, name, qtype)
+	if err != nil {
+		return false, err
+	}
+
+	var rrset []dns.RR
+	for _, rr := range answers {
+		// Answers may also hold the records of a CNAME chain, which belong to other names
+		if rr.Header().Rrtype != qtype || dns.CanonicalName(rr.Header().Name) != name {
+			continue
+		}
+		// Owner records share their name with the metadata TXT records but aren't part of the desired records
+		if txt, ok := rr.(*dns.TXT); ok {
+			if _, isOwner := parseOwnership(txt); isOwner {
+				continue
+			}
+		}
+		rrset = append(rrset, rr)
+	}
+	if len(rrset) != len(records) {
+		return false, nil
+	}
+
+	for _, record := range records {
+		if !slices.ContainsFunc(rrset, func(rr dns.RR) bool { return c.rrMatches(rr, record) }) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// rrMatches reports whether a record on the server holds the value and TTL of a record. Names are compared in their
+// canonical form and TTLs within the configured tolerance, so that cosmetic differences such as the case of a name or
+// a TTL the server capped don't cause the record to be sent again and again.
+func (c *Client) rrMatches(rr dns.RR, record DNSRecord) bool {
+	if !ttlWithin(rr.Header().Ttl, record.TTL, c.ttlTolerance) {
+		return false
+	}
+
+	switch rr := rr.(type) {
+	case *dns.A:
+		return rr.A.Equal(net.ParseIP(record.Value))
+	case *dns.AAAA:
+		return rr.AAAA.Equal(net.ParseIP(record.Value))
+	case *dns.PTR:
+		return dns.CanonicalName(rr.Ptr) == dns.CanonicalName(record.Value)
+	case *dns.CNAME:
+		return dns.CanonicalName(rr.Target) == dns.CanonicalName(record.Value)
+	case *dns.TXT:
+		return strings.Join(rr.Txt, "") == record.Value
+	default:
+		return false
+	}
+}
+
+// ttlWithin reports whether two TTLs differ by no more than tolerance
+func ttlWithin(a, b uint32, tolerance time.Duration) bool {
+	diff := max(a, b) - min(a, b)
+	return time.Duration(diff)*time.Second <= tolerance
+}
+
+// removalRRset returns the RR identifying the record set of a record, as used to delete it, nil for unsupported types
+func removalRRset(zone string, record DNSRecord) dns.RR {
+	hdr := dns.RR_Header{Name: dns.Fqdn(record.Name + "." + zone), Class: dns.ClassINET, Rrtype: record.Type.Qtype()}
+	switch record.Type {
+	case TypePTR:
+		hdr.Name = dns.Fqdn(record.Name)
+		return &dns.PTR{Hdr: hdr}
+	case TypeA:
+		return &dns.A{Hdr: hdr}
+	case TypeAAAA:
+		return &dns.AAAA{Hdr: hdr}
+	case TypeCNAME:
+		return &dns.CNAME{Hdr: hdr}
+	case TypeTXT:
+		return &dns.TXT{Hdr: hdr}
+	}
+	return nil
+}
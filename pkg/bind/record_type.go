@@ -0,0 +1,109 @@
+package bind
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// RecordType is the type of a DNS record. Switches over it are checked for exhaustiveness, so that a new type can't
+// fall through to the handling of another one.
+type RecordType string
+
+// The record types that are published
+const (
+	TypeA     RecordType = "A"
+	TypeAAAA  RecordType = "AAAA"
+	TypeCNAME RecordType = "CNAME"
+	TypeTXT   RecordType = "TXT"
+	TypePTR   RecordType = "PTR"
+)
+
+// RecordTypes holds every record type that is published
+var RecordTypes = []RecordType{TypeA, TypeAAAA, TypeCNAME, TypeTXT, TypePTR}
+
+// ParseRecordType returns the record type with the given name, ignoring case
+func ParseRecordType(name string) (RecordType, error) {
+	recordType := RecordType(strings.ToUpper(strings.TrimSpace(name)))
+	if !recordType.Valid() {
+		return "", fmt.Errorf("unsupported record type %q", name)
+	}
+	return recordType, nil
+}
+
+// Valid reports whether t is one of the record types that are published
+func (t RecordType) Valid() bool {
+	switch t {
+	case TypeA, TypeAAAA, TypeCNAME, TypeTXT, TypePTR:
+		return true
+	}
+	return false
+}
+
+// Qtype returns the DNS type code of t, dns.TypeNone for invalid types
+func (t RecordType) Qtype() uint16 {
+	switch t {
+	case TypeA:
+		return dns.TypeA
+	case TypeAAAA:
+		return dns.TypeAAAA
+	case TypeCNAME:
+		return dns.TypeCNAME
+	case TypeTXT:
+		return dns.TypeTXT
+	case TypePTR:
+		return dns.TypePTR
+	}
+	return dns.TypeNone
+}
+
+// NewDNSRecord returns a record after checking that its type is supported and its value fits the type
+func NewDNSRecord(name, value string, recordType RecordType, ttl uint32) (DNSRecord, error) {
+	record := DNSRecord{Name: name, Value: value, TTL: ttl, Type: recordType}
+	if err := record.Validate(); err != nil {
+		return DNSRecord{}, err
+	}
+	return record, nil
+}
+
+// validRecords splits records into the valid ones and the skipped invalid ones, logging why they are invalid
+func validRecords(records []DNSRecord) ([]DNSRecord, []SkippedRecord) {
+	var skipped []SkippedRecord
+	valid := make([]DNSRecord, 0, len(records))
+	for _, record := range records {
+		if err := record.Validate(); err != nil {
+			klog.Warningf("Skipping record: %v", err)
+			skipped = append(skipped, SkippedRecord{Record: record, Reason: SkipInvalid})
+			continue
+		}
+		valid = append(valid, record)
+	}
+	return valid, skipped
+}
+
+// Validate checks that the type of a record is supported and that its name and value fit the type: addresses of the
+// right family for A and AAAA records, and domain names for CNAME and PTR records
+func (r DNSRecord) Validate() error {
+	if _, ok := dns.IsDomainName(r.Name); !ok || r.Name == "" {
+		return fmt.Errorf("invalid record name %q", r.Name)
+	}
+
+	switch r.Type {
+	case TypeA, TypeAAAA:
+		ip := net.ParseIP(r.Value)
+		if ip == nil || (ip.To4() != nil) != (r.Type == TypeA) {
+			return fmt.Errorf("invalid %s record value %q", r.Type, r.Value)
+		}
+	case TypeCNAME, TypePTR:
+		if _, ok := dns.IsDomainName(r.Value); !ok || r.Value == "" {
+			return fmt.Errorf("invalid %s record value %q", r.Type, r.Value)
+		}
+	case TypeTXT:
+	default:
+		return fmt.Errorf("unsupported record type %q of record %s", r.Type, r.Name)
+	}
+	return nil
+}
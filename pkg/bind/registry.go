@@ -0,0 +1,230 @@
+package bind
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// The ownership registry lets several instances, and people editing the zone by hand, share a zone without clobbering
+// each other's records. Next to the records of every name it manages, an instance with an owner ID publishes a TXT
+// record naming itself as the owner, in the style of external-dns:
+//
+//	laptop.ts.example.com. 300 IN TXT "heritage=tailscale-bind-ddns,tailscale-bind-ddns/owner=office"
+//
+// Names owned by someone else, and names that already hold records without any owner, are never written or deleted.
+// Deletions are only sent after the owner TXT record on the server was confirmed to be ours.
+
+const (
+	ownershipHeritage = "heritage=tailscale-bind-ddns"
+	ownershipOwnerKey = "tailscale-bind-ddns/owner="
+)
+
+// ownership is the state of a name in the registry
+type ownership int
+
+const (
+	ownershipFree    ownership = iota // Nothing is published at the name, it can be claimed
+	ownershipOurs                     // The name carries our owner record
+	ownershipForeign                  // The name carries another instance's owner record
+	ownershipUnowned                  // The name holds records but no owner record, e.g. records added by hand
+)
+
+// ownershipValue returns the text of the owner TXT record for an owner ID
+func ownershipValue(ownerID string) string {
+	return ownershipHeritage + "," + ownershipOwnerKey + ownerID
+}
+
+// parseOwnership returns the owner named by an owner TXT record, or false when the TXT record isn't one
+func parseOwnership(txt *dns.TXT) (string, bool) {
+	value := strings.Join(txt.Txt, "")
+	fields := strings.Split(value, ",")
+	if len(fields) != 2 || fields[0] != ownershipHeritage {
+		return "", false
+	}
+	return strings.CutPrefix(fields[1], ownershipOwnerKey)
+}
+
+// ownershipRR returns our owner TXT record for a fully qualified name
+func (c *Client) ownershipRR(name string) *dns.TXT {
+	return &dns.TXT{
+		Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: c.ttl},
+		Txt: []string{ownershipValue(c.ownerID)},
+	}
+}
+
+// recordFQDN returns the fully qualified name of a record in a zone
+func recordFQDN(zone string, record DNSRecord) string {
+	if record.Key().Type == TypePTR {
+		return dns.CanonicalName(record.Name)
+	}
+	return dns.CanonicalName(record.Name + "." + zone)
+}
+
+// nameOwnership looks up the registry state of a name on the server. recordTypes are the types whose presence marks a
+// name without owner record as in use.
+func (c *Client) nameOwnership(
+	ctx context.Context,
+	reader *zoneReader,
+	name string,
+	recordTypes []uint16,
+) (ownership, error) {
+	answers, err := reader.lookup(ctx, name, dns.TypeTXT)
+	if err != nil {
+		return 0, err
+	}
+	for _, rr := range answers {
+		txt, ok := rr.(*dns.TXT)
+		if !ok {
+			continue
+		}
+		if owner, ok := parseOwnership(txt); ok {
+			if owner == c.ownerID {
+				return ownershipOurs, nil
+			}
+			klog.V(1).Infof("Name %s is owned by %q", name, owner)
+			return ownershipForeign, nil
+		}
+	}
+
+	for _, qtype := range recordTypes {
+		answers, err := reader.lookup(ctx, name, qtype)
+		if err != nil {
+			return 0, err
+		}
+		for _, rr := range answers {
+			if rr.Header().Rrtype == qtype {
+				return ownershipUnowned, nil
+			}
+		}
+	}
+	return ownershipFree, nil
+}
+
+// filterOwned drops the upserts and removals of a zone change whose names this instance doesn't own. Names that are
+// free are claimed by the update that publishes their records. Names that are cached as ours are only looked up again
+// before their records are deleted.
+func (c *Client) filterOwned(ctx context.Context, reader *zoneReader, change *zoneChange) error {
+	states := make(map[string]ownership)
+	state := func(name string, record DNSRecord, deleting bool) (ownership, error) {
+		if s, ok := states[name]; ok {
+			return s, nil
+		}
+		if !deleting && c.ownsName(name) {
+			states[name] = ownershipOurs
+			return ownershipOurs, nil
+		}
+
+		recordTypes := []uint16{dns.TypeA, dns.TypeAAAA}
+		if record.Key().Type == TypePTR {
+			recordTypes = []uint16{dns.TypePTR}
+		}
+		s, err := c.nameOwnership(ctx, reader, name, recordTypes)
+		if err != nil {
+			return 0, fmt.Errorf("checking owner of %s: %w", name, err)
+		}
+		states[name] = s
+		return s, nil
+	}
+
+	// Removals are checked first so that their names are always looked up on the server
+	var removals []DNSRecord
+	for _, record := range change.removals {
+		name := recordFQDN(change.zone, record)
+		s, err := state(name, record, true)
+		if err != nil {
+			return err
+		}
+		if s != ownershipOurs {
+			klog.Warningf("Not removing %s record %s: the name is not owned by %q", record.Key().Type, name, c.ownerID)
+			change.skipped = append(change.skipped, SkippedRecord{Record: record, Reason: SkipNotOwned})
+			continue
+		}
+		removals = append(removals, record)
+	}
+
+	var upserts []DNSRecord
+	skipped := make(map[RecordKey]bool)
+	for _, record := range change.upserts {
+		name := recordFQDN(change.zone, record)
+		s, err := state(name, record, false)
+		if err != nil {
+			return err
+		}
+		switch s {
+		case ownershipOurs, ownershipFree:
+			upserts = append(upserts, record)
+			continue
+		case ownershipForeign:
+			klog.Warningf("Not publishing %s record %s: the name is owned by another instance", record.Key().Type, name)
+			change.skipped = append(change.skipped, SkippedRecord{Record: record, Reason: SkipForeignOwner})
+		case ownershipUnowned:
+			klog.Warningf("Not publishing %s record %s: the name already holds records without an owner",
+				record.Key().Type, name)
+			change.skipped = append(change.skipped, SkippedRecord{Record: record, Reason: SkipUnowned})
+		}
+		skipped[record.Key()] = true
+	}
+
+	// Skipped records never make it to the server, so they must not be remembered as published either
+	change.desired = slices.DeleteFunc(slices.Clone(change.desired), func(record DNSRecord) bool {
+		return skipped[record.Key()]
+	})
+	change.upserts, change.removals = upserts, removals
+	return nil
+}
+
+// addOwnership adds our owner TXT record to every name that gets records in an update and removes it from names whose
+// last record is deleted. Only our own TXT record is touched, other TXT records at the same names are left alone.
+func (c *Client) addOwnership(msg *dns.Msg, change zoneChange) {
+	desired := make(map[string]bool, len(change.desired))
+	for _, record := range change.desired {
+		desired[recordFQDN(change.zone, record)] = true
+	}
+	claimed := make(map[string]bool, len(change.upserts))
+	for _, record := range change.upserts {
+		claimed[recordFQDN(change.zone, record)] = true
+	}
+	released := make(map[string]bool)
+	for _, record := range change.removals {
+		if name := recordFQDN(change.zone, record); !desired[name] {
+			released[name] = true
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(claimed)) {
+		msg.Insert([]dns.RR{c.ownershipRR(name)})
+	}
+	for _, name := range slices.Sorted(maps.Keys(released)) {
+		msg.Remove([]dns.RR{c.ownershipRR(name)})
+	}
+}
+
+// markOwned updates the cache of names known to be ours after an update was applied
+func (c *Client) markOwned(change zoneChange) {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	if c.owned == nil {
+		c.owned = make(map[string]bool)
+	}
+	for _, record := range change.removals {
+		delete(c.owned, recordFQDN(change.zone, record))
+	}
+	for _, record := range change.desired {
+		c.owned[recordFQDN(change.zone, record)] = true
+	}
+}
+
+// ownsName reports whether a name is cached as ours
+func (c *Client) ownsName(name string) bool {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	return c.owned[name]
+}
@@ -0,0 +1,129 @@
+package bind
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// Forward/reverse consistency checks ask the update server by default, which only tells whether the records were
+// published. With bind.verify_resolvers they go through recursive resolvers instead, so that the checks see what
+// clients see, including caches and views the update server doesn't apply. Answers may then lag behind an update by
+// up to the TTL of the records. SOA serial verification always asks the update server, resolvers cache the serial.
+
+const defaultResolverPort = 53
+
+// Resolver looks up the records clients are served at a name. Lookups of a name that doesn't exist return no records.
+type Resolver interface {
+	Lookup(ctx context.Context, name string, qtype uint16) ([]dns.RR, error)
+}
+
+// recursiveResolvers asks recursive resolvers in order, moving on to the next one when one fails
+type recursiveResolvers struct {
+	addresses []string // host:port
+}
+
+// NewRecursiveResolver returns a Resolver asking the given resolvers, host or host:port with port 53 by default, in
+// order
+func NewRecursiveResolver(resolvers []string) (Resolver, error) {
+	addresses := make([]string, 0, len(resolvers))
+	for _, resolver := range resolvers {
+		host, port, err := parseServerAddress(resolver, defaultResolverPort)
+		if err != nil {
+			return nil, fmt.Errorf("resolver: %w", err)
+		}
+		addresses = append(addresses, net.JoinHostPort(host, strconv.Itoa(port)))
+	}
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("at least one resolver is required")
+	}
+	return &recursiveResolvers{addresses: addresses}, nil
+}
+
+// Lookup resolves a name through the first resolver that answers, retrying over TCP when an answer was truncated
+func (r *recursiveResolvers) Lookup(ctx context.Context, name string, qtype uint16) ([]dns.RR, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), qtype)
+	msg.SetEdns0(dns.DefaultMsgSize, false)
+
+	var errs []error
+	for i, address := range r.addresses {
+		answers, err := resolve(ctx, address, msg)
+		if err == nil {
+			return answers, nil
+		}
+		errs = append(errs, fmt.Errorf("resolver %s: %w", address, err))
+		if ctx.Err() != nil {
+			break
+		}
+		if i < len(r.addresses)-1 {
+			klog.V(1).Infof("Resolver %s failed, trying %s: %v", address, r.addresses[i+1], err)
+		}
+	}
+	return nil, fmt.Errorf("resolving %s %s: %w", dns.TypeToString[qtype], name, errors.Join(errs...))
+}
+
+// resolve sends a query to a single resolver
+func resolve(ctx context.Context, address string, msg *dns.Msg) ([]dns.RR, error) {
+	client := &dns.Client{Timeout: verifyTimeout}
+	response, _, err := client.ExchangeContext(ctx, msg, address)
+	if err == nil && response.Truncated {
+		client.Net = "tcp"
+		response, _, err = client.ExchangeContext(ctx, msg, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch response.Rcode {
+	case dns.RcodeSuccess:
+		return response.Answer, nil
+	case dns.RcodeNameError:
+		return nil, nil
+	default:
+		return nil, responseError(response)
+	}
+}
+
+// SetResolver makes consistency checks look records up through a resolver rather than asking the update server, nil
+// restores the default
+func (c *Client) SetResolver(resolver Resolver) {
+	c.resolver = resolver
+}
+
+// newVerifyReader returns the reader of consistency checks, which looks names up through the resolver when one is set
+func (c *Client) newVerifyReader() *zoneReader {
+	reader := c.newZoneReader()
+	reader.resolver = c.resolver
+	return reader
+}
+
+// Resolves reports whether a record is served: by the resolvers set with SetResolver, otherwise by the update server
+func (c *Client) Resolves(ctx context.Context, record DNSRecord) (bool, error) {
+	rr := record.RR(c.recordZone(record))
+	if rr == nil {
+		return false, fmt.Errorf("invalid %s record %s", record.Type, record.Name)
+	}
+
+	var answers []dns.RR
+	var err error
+	if c.resolver != nil {
+		answers, err = c.resolver.Lookup(ctx, rr.Header().Name, rr.Header().Rrtype)
+	} else {
+		answers, err = c.lookup(ctx, rr.Header().Name, rr.Header().Rrtype)
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, answer := range answers {
+		if dns.IsDuplicate(rr, answer) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
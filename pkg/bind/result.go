@@ -0,0 +1,113 @@
+package bind
+
+import (
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// NoResponse is the ZoneResult.Rcode of a zone whose update got no response from the server, e.g. because it wasn't
+// sent or the server was unreachable
+const NoResponse = -1
+
+// Reasons records are skipped during an update
+const (
+	SkipOutsideZones   = "not in any configured zone"
+	SkipForeignOwner   = "name is owned by another instance"
+	SkipUnowned        = "name holds records without an owner"
+	SkipNotOwned       = "name is not owned by us, not removing"
+	SkipAlreadyApplied = "server already holds the record"
+	SkipKeyRejected    = "TSIG key was rejected by an earlier zone"
+	SkipDeniedByPolicy = "update policy doesn't allow the key to update the record"
+	SkipDeniedByACL    = "server refused the update of the name, its ACL doesn't allow the principal to update it"
+	SkipInvalid        = "record type or value is invalid"
+)
+
+// SyncResult describes the outcome of an UpdateRecords call
+type SyncResult struct {
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+	DryRun   bool          `json:"dry_run,omitempty"`
+
+	// Zones holds the outcome of every zone the update covered, in the order they were updated
+	Zones []ZoneResult `json:"zones,omitempty"`
+	// Skipped holds invalid records and records that don't belong to any zone, records skipped within a zone are in
+	// its ZoneResult
+	Skipped []SkippedRecord `json:"skipped,omitempty"`
+}
+
+// ZoneResult is the outcome of the update of a single zone
+type ZoneResult struct {
+	Zone string `json:"zone"`
+	// Rcode is the response code of the update, NoResponse when none was received and RcodeSuccess when the zone was
+	// already up to date
+	Rcode    int           `json:"rcode"`
+	Records  int           `json:"records"`
+	Sent     int           `json:"sent"`
+	Removed  int           `json:"removed"`
+	Serial   uint32        `json:"serial,omitempty"`
+	Duration time.Duration `json:"duration"`
+	// Verify is the part of Duration spent checking that the server applied the update, see verify.go
+	Verify time.Duration `json:"verify,omitempty"`
+	Error  string        `json:"error,omitempty"`
+
+	Skipped []SkippedRecord `json:"skipped,omitempty"`
+
+	err error
+}
+
+// SkippedRecord is a record that was left out of an update
+type SkippedRecord struct {
+	Record DNSRecord `json:"record"`
+	Reason string    `json:"reason"`
+}
+
+// Err returns the error the zone update failed with
+func (z ZoneResult) Err() error {
+	return z.err
+}
+
+// SetErr marks the zone update as failed with err, for providers that report their outcome like this client
+func (z *ZoneResult) SetErr(err error) {
+	z.Error, z.err = err.Error(), err
+}
+
+// RcodeString returns the name of the response code, e.g. NOERROR or REFUSED
+func (z ZoneResult) RcodeString() string {
+	if z.Rcode == NoResponse {
+		return "NONE"
+	}
+	if name, ok := dns.RcodeToString[z.Rcode]; ok {
+		return name
+	}
+	return "UNKNOWN"
+}
+
+// Failed returns the number of zones whose update failed
+func (r *SyncResult) Failed() int {
+	failed := 0
+	for _, zone := range r.Zones {
+		if zone.err != nil {
+			failed++
+		}
+	}
+	return failed
+}
+
+// SkippedRecords returns every skipped record, both those outside any zone and those skipped within a zone
+func (r *SyncResult) SkippedRecords() []SkippedRecord {
+	skipped := append([]SkippedRecord(nil), r.Skipped...)
+	for _, zone := range r.Zones {
+		skipped = append(skipped, zone.Skipped...)
+	}
+	return skipped
+}
+
+// skip marks records as skipped for a reason
+func skip(records []DNSRecord, reason string) []SkippedRecord {
+	skipped := make([]SkippedRecord, 0, len(records))
+	for _, record := range records {
+		skipped = append(skipped, SkippedRecord{Record: record, Reason: reason})
+	}
+	return skipped
+}
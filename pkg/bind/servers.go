@@ -0,0 +1,180 @@
+package bind
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// Besides the primary server, bind.servers lists further servers that are tried in order when the servers before them
+// are unreachable. A server that just failed is tried after the healthy ones until serverRetryInterval has passed, so
+// that an outage of the primary doesn't delay every update by a timeout. With update_all_servers, updates are sent to
+// every server instead, e.g. for a hidden primary replicated between several masters.
+
+// serverRetryInterval is how long a failed server is tried after the healthy ones
+const serverRetryInterval = time.Minute
+
+// server is a DNS server messages are sent to
+type server struct {
+	host      string
+	port      int
+	tlsConfig *tls.Config // Used with tcp-tls, defaults to verifying the host name
+}
+
+// address returns the host:port of the server
+func (s server) address() string {
+	return net.JoinHostPort(s.host, strconv.Itoa(s.port))
+}
+
+// ServerHealth describes the outcome of the most recent exchanges with a server
+type ServerHealth struct {
+	Address     string    `json:"address"`
+	Healthy     bool      `json:"healthy"`
+	LastSuccess time.Time `json:"last_success,omitzero"`
+	LastFailure time.Time `json:"last_failure,omitzero"`
+	LastError   string    `json:"last_error,omitempty"`
+	Failures    int       `json:"consecutive_failures"`
+}
+
+// servers returns the primary and the fallback servers in preference order
+func (c *Client) servers() []server {
+	return append([]server{{host: c.server, port: c.port, tlsConfig: c.tlsConfig}}, c.fallbacks...)
+}
+
+// serverOrder returns the servers in the order they are tried: servers that failed within the retry interval go after
+// the others, each group keeping the preference order
+func (c *Client) serverOrder() []server {
+	servers := c.servers()
+	if len(servers) == 1 {
+		return servers
+	}
+
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	now := clock.Or(c.clock).Now()
+	failing := func(s server) bool {
+		health, ok := c.health[s.address()]
+		return ok && health.Failures > 0 && now.Sub(health.LastFailure) < serverRetryInterval
+	}
+	slices.SortStableFunc(servers, func(a, b server) int {
+		switch fa, fb := failing(a), failing(b); {
+		case fa == fb:
+			return 0
+		case fb:
+			return -1
+		default:
+			return 1
+		}
+	})
+	return servers
+}
+
+// recordServerResult stores the outcome of an exchange with a server
+func (c *Client) recordServerResult(s server, err error) {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	if c.health == nil {
+		c.health = make(map[string]ServerHealth)
+	}
+
+	health := c.health[s.address()]
+	health.Address = s.address()
+	if err != nil {
+		health.LastFailure = clock.Or(c.clock).Now()
+		health.LastError = err.Error()
+		health.Failures++
+	} else {
+		health.LastSuccess = clock.Or(c.clock).Now()
+		health.LastError = ""
+		health.Failures = 0
+	}
+	health.Healthy = health.Failures == 0
+	c.health[s.address()] = health
+}
+
+// ServerHealth returns the health of every server in preference order. Servers no message was sent to yet are
+// reported healthy.
+func (c *Client) ServerHealth() []ServerHealth {
+	servers := c.servers()
+
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	healths := make([]ServerHealth, 0, len(servers))
+	for _, s := range servers {
+		health, ok := c.health[s.address()]
+		if !ok {
+			health = ServerHealth{Address: s.address(), Healthy: true}
+		}
+		healths = append(healths, health)
+	}
+	return healths
+}
+
+// exchange sends a message to the first server that can be reached and returns its response. Servers that answer
+// count as reachable whatever their response code.
+func (c *Client) exchange(
+	ctx context.Context,
+	msg *dns.Msg,
+	timeout time.Duration,
+	tsigSecret map[string]string,
+) (*dns.Msg, error) {
+	servers := c.serverOrder()
+
+	var errs []error
+	for i, s := range servers {
+		// Signing a message with TSIG modifies it, so every server gets its own copy
+		response, err := c.exchangeWith(ctx, s, msg.Copy(), timeout, tsigSecret)
+		c.recordServerResult(s, err)
+		if err == nil {
+			return response, nil
+		}
+		if len(servers) > 1 {
+			err = fmt.Errorf("server %s: %w", s.address(), err)
+		}
+		errs = append(errs, err)
+
+		if ctx.Err() != nil {
+			break
+		}
+		if i < len(servers)-1 {
+			klog.Warningf("DNS server %s is unreachable, trying %s: %v", s.address(), servers[i+1].address(), err)
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// exchangeAll sends a message to every server and returns their responses in preference order. A server that can't be
+// reached fails the exchange, after the message was still sent to all others.
+func (c *Client) exchangeAll(
+	ctx context.Context,
+	msg *dns.Msg,
+	timeout time.Duration,
+	tsigSecret map[string]string,
+) ([]*dns.Msg, error) {
+	var (
+		responses []*dns.Msg
+		errs      []error
+	)
+	for _, s := range c.servers() {
+		response, err := c.exchangeWith(ctx, s, msg.Copy(), timeout, tsigSecret)
+		c.recordServerResult(s, err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("server %s: %w", s.address(), err))
+			continue
+		}
+		responses = append(responses, response)
+	}
+	return responses, errors.Join(errs...)
+}
@@ -0,0 +1,104 @@
+package bind
+
+import (
+	"slices"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// A reverse zone derived with the configured subnet size can grow large on big tailnets, making every update and
+// transfer of it slow. With bind.ptr.shard_threshold, a reverse zone holding more PTR records than the threshold is
+// sharded: its records move to the finer reverse zones of the shard size, e.g. from 64.100.in-addr.arpa to
+// 0.64.100.in-addr.arpa, 1.64.100.in-addr.arpa and so on, and the records left in the coarse zone are removed as
+// stale. A sharded zone only returns to holding its records once they dropped below half the threshold, so that a
+// tailnet hovering around the threshold doesn't move its records back and forth.
+
+// updateShards decides which reverse zones are sharded for the given desired records
+func (c *Client) updateShards(records []DNSRecord) {
+	if c.ptrConfig == nil || c.ptrConfig.ShardThreshold <= 0 {
+		return
+	}
+
+	counts := make(map[string]int)
+	for _, record := range records {
+		if record.Type != TypePTR {
+			continue
+		}
+		switch {
+		case strings.Contains(record.Name, ".in-addr.arpa.") && c.ipv4ShardSize() != 0:
+			counts[c.extractIPv4ZoneFromPTRName(record.Name)]++
+		case strings.Contains(record.Name, ".ip6.arpa.") && c.ipv6ShardSize() != 0:
+			counts[c.extractIPv6ZoneFromPTRName(record.Name)]++
+		}
+	}
+
+	threshold := c.ptrConfig.ShardThreshold
+	shards := make(map[string]bool)
+	for zone, count := range counts {
+		sharded := c.isSharded(zone)
+		switch {
+		case zone == "":
+		case count > threshold:
+			if !sharded {
+				klog.Infof("Reverse zone %s holds %d PTR records, more than %d: moving them to finer zones", zone,
+					count, threshold)
+			}
+			shards[zone] = true
+		case sharded && count >= threshold/2:
+			shards[zone] = true
+		case sharded:
+			klog.Infof("Reverse zone %s holds %d PTR records, fewer than %d: moving them back from the finer zones",
+				zone, count, threshold/2)
+		}
+	}
+	c.shards.Store(&shards)
+}
+
+// isSharded reports whether the records of a reverse zone moved to finer zones
+func (c *Client) isSharded(zone string) bool {
+	shards := c.shards.Load()
+	return shards != nil && (*shards)[zone]
+}
+
+// ShardedZones returns the reverse zones whose records moved to finer zones
+func (c *Client) ShardedZones() []string {
+	shards := c.shards.Load()
+	if shards == nil {
+		return nil
+	}
+	zones := make([]string, 0, len(*shards))
+	for zone := range *shards {
+		zones = append(zones, zone)
+	}
+	slices.Sort(zones)
+	return zones
+}
+
+// ipv4ShardSize returns the subnet size sharded IPv4 reverse zones are split into, 0 when they can't be split
+func (c *Client) ipv4ShardSize() int {
+	if c.ptrConfig.IPv4ShardSize != 0 {
+		return c.ptrConfig.IPv4ShardSize
+	}
+	switch c.ptrConfig.IPv4SubnetSize {
+	case IPv4Subnet8:
+		return IPv4Subnet16
+	case IPv4Subnet16:
+		return IPv4Subnet24
+	}
+	return 0
+}
+
+// ipv6ShardSize returns the subnet size sharded IPv6 reverse zones are split into, 0 when they can't be split
+func (c *Client) ipv6ShardSize() int {
+	if c.ptrConfig.IPv6ShardSize != 0 {
+		return c.ptrConfig.IPv6ShardSize
+	}
+	switch c.ptrConfig.IPv6SubnetSize {
+	case IPv6Subnet32:
+		return IPv6Subnet48
+	case IPv6Subnet48:
+		return IPv6Subnet64
+	}
+	return 0
+}
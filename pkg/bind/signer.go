@@ -0,0 +1,107 @@
+package bind
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// With bind.key_command the TSIG MACs are computed by an external command instead of from key_secret, so that the
+// shared secret never has to be in the configuration or the memory of the process, e.g. a wrapper around a PKCS#11
+// token holding the key or a signing service. The command is run through the shell for every message signed or
+// verified, with the bytes the MAC covers on stdin and TSBD_TSIG_KEY_NAME and TSBD_TSIG_ALGORITHM in its environment,
+// and writes the raw MAC to stdout, e.g. for a key in a file only the command can read:
+//
+//	openssl dgst -sha256 -mac HMAC -macopt hexkey:$(cat /etc/tsig/$TSBD_TSIG_KEY_NAME.hex) -binary
+
+const (
+	// keyCommandTimeout bounds how long the key command may take to compute a MAC
+	keyCommandTimeout = 10 * time.Second
+	// gssNegotiationTimeout bounds how long negotiating a GSS-TSIG key may take, Kerberos exchanges included
+	gssNegotiationTimeout = 30 * time.Second
+)
+
+// gssTSIGAlgorithm is the TSIG algorithm of GSS-TSIG keys
+const gssTSIGAlgorithm = "gss-tsig."
+
+// tsigMACSizes are the sizes of the MACs of the TSIG algorithms
+var tsigMACSizes = map[string]int{
+	"hmac-md5":    16,
+	"hmac-sha1":   20,
+	"hmac-sha256": 32,
+	"hmac-sha384": 48,
+	"hmac-sha512": 64,
+}
+
+// negotiatedSigner is a dns.TsigProvider whose keys are negotiated with the server rather than configured, see gss.go
+type negotiatedSigner interface {
+	dns.TsigProvider
+	// keyName returns the name of the key negotiated with the server, negotiating one first when there is none or it
+	// is about to expire
+	keyName(ctx context.Context) (string, error)
+	// reset drops the negotiated key, e.g. after the server rejected it, so that the next message negotiates a new one
+	reset()
+}
+
+// commandSigner is a dns.TsigProvider computing MACs with the key command
+type commandSigner struct {
+	command string
+}
+
+// Generate runs the key command to compute the MAC of msg
+func (s *commandSigner) Generate(msg []byte, t *dns.TSIG) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), keyCommandTimeout)
+	defer cancel()
+
+	algorithm := strings.TrimSuffix(strings.ToLower(t.Algorithm), ".")
+	cmd := shellCommand(ctx, s.command)
+	cmd.Env = append(os.Environ(),
+		"TSBD_TSIG_KEY_NAME="+strings.TrimSuffix(t.Hdr.Name, "."),
+		"TSBD_TSIG_ALGORITHM="+algorithm,
+	)
+	cmd.Stdin = bytes.NewReader(msg)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	mac, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("running key command: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if size, ok := tsigMACSizes[algorithm]; ok && len(mac) != size {
+		return nil, fmt.Errorf("key command returned a MAC of %d bytes, %s MACs have %d", len(mac), algorithm, size)
+	}
+	return mac, nil
+}
+
+// Verify runs the key command to check the MAC of a response
+func (s *commandSigner) Verify(msg []byte, t *dns.TSIG) error {
+	mac, err := s.Generate(msg, t)
+	if err != nil {
+		return err
+	}
+	expected, err := hex.DecodeString(t.MAC)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(mac, expected) {
+		return dns.ErrSig
+	}
+	return nil
+}
+
+// shellCommand builds a command run through the shell of the platform
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "/bin/sh", "-c", command)
+}
@@ -0,0 +1,226 @@
+package bind
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// The state file records every record this client published, when it was first published and the Tailscale device it
+// was published for. Without it the records published before a restart are unknown, so records of devices that went
+// offline or left the tailnet while the process was stopped would never be removed.
+//
+// The records recovered from the state file don't count as published: the first update of every zone after a restart
+// still sends every desired record, since the zone may have changed meanwhile, but it also removes the recovered
+// records that are no longer desired, as bind.remove_stale and bind.max_record_age say. The state is written after
+// every confirmed update.
+
+// stateVersion is the version of the state file format written by this build
+const stateVersion = 1
+
+// DeviceLookup returns the ID of the Tailscale device a record was published for, empty when it's unknown
+type DeviceLookup func(record DNSRecord) string
+
+// stateEntry is a record in the state file
+type stateEntry struct {
+	Zone   string    `json:"zone"`
+	Record DNSRecord `json:"record"`
+	Device string    `json:"device,omitempty"`
+	// Created is when the record was first published and Refreshed when it was last desired
+	Created   time.Time `json:"created"`
+	Refreshed time.Time `json:"refreshed"`
+}
+
+// stateContent is the content of the state file
+type stateContent struct {
+	Version int          `json:"version"`
+	Saved   time.Time    `json:"saved"`
+	Records []stateEntry `json:"records"`
+}
+
+// stateStore keeps the state file. The clients of every zone share a single store, each writing its own zones.
+type stateStore struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string][]stateEntry // By zone
+}
+
+// openStateStore reads the state file at path, which doesn't need to exist yet
+func openStateStore(path string) (*stateStore, error) {
+	store := &stateStore{path: path, entries: make(map[string][]stateEntry)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading state file: %w", err)
+	}
+
+	var content stateContent
+	if err := json.Unmarshal(data, &content); err != nil {
+		return nil, fmt.Errorf("parsing state file %s: %w", path, err)
+	}
+	if content.Version != stateVersion {
+		return nil, fmt.Errorf("state file %s has unsupported version %d", path, content.Version)
+	}
+	for _, entry := range content.Records {
+		store.entries[entry.Zone] = append(store.entries[entry.Zone], entry)
+	}
+	return store, nil
+}
+
+// zone returns the entries of a zone
+func (s *stateStore) zone(zone string) []stateEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.entries[zone])
+}
+
+// zones returns the zones the store holds entries of
+func (s *stateStore) zones() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Sorted(maps.Keys(s.entries))
+}
+
+// set replaces the entries of a zone and writes the state file. The file is replaced atomically so that a crash never
+// leaves a partial state behind.
+func (s *stateStore) set(zone string, entries []stateEntry, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(entries) == 0 {
+		delete(s.entries, zone)
+	} else {
+		s.entries[zone] = entries
+	}
+
+	content := stateContent{Version: stateVersion, Saved: now, Records: []stateEntry{}}
+	for _, zone := range slices.Sorted(maps.Keys(s.entries)) {
+		content.Records = append(content.Records, s.entries[zone]...)
+	}
+	data, err := json.MarshalIndent(content, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding state: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("creating state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("replacing state file: %w", err)
+	}
+	return nil
+}
+
+// SetDeviceLookup sets how the device a record was published for is found, recorded in the state file
+func (c *Client) SetDeviceLookup(lookup DeviceLookup) {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	c.devices = lookup
+}
+
+// ShareState makes the client record its zones in the state file of another client, e.g. the client of the main zone,
+// and recovers the records it published before a restart from it
+func (c *Client) ShareState(other *Client) {
+	c.state = other.state
+	c.recoverState()
+}
+
+// recoverState takes the records of the client's zones recorded in the state file as the records published before
+// the restart
+func (c *Client) recoverState() {
+	if c.state == nil {
+		return
+	}
+
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	for _, zone := range c.state.zones() {
+		entries := c.state.zone(zone)
+		if len(entries) == 0 || dns.CanonicalName(c.recordZone(entries[0].Record)) != dns.CanonicalName(zone) {
+			continue
+		}
+
+		if c.recovered == nil {
+			c.recovered = make(map[string][]DNSRecord)
+		}
+		if c.refreshed == nil {
+			c.refreshed = make(map[string]map[RecordKey]time.Time)
+		}
+		if c.refreshed[zone] == nil {
+			c.refreshed[zone] = make(map[RecordKey]time.Time)
+		}
+		for _, entry := range entries {
+			c.recovered[zone] = append(c.recovered[zone], entry.Record)
+			key := entry.Record.Key()
+			if entry.Refreshed.After(c.refreshed[zone][key]) {
+				c.refreshed[zone][key] = entry.Refreshed
+			}
+		}
+		klog.Infof("Recovered %d records of zone %s published before the restart", len(entries), zone)
+	}
+}
+
+// saveState records the records of a confirmed update of a zone in the state file. Records that were published before
+// keep when they were first published and, when it can't be found anymore, the device they were published for. It
+// must be called with statusMu held.
+func (c *Client) saveState(zone string, records []DNSRecord) {
+	if c.state == nil {
+		return
+	}
+
+	now := clock.Or(c.clock).Now()
+	previous := make(map[DNSRecord]stateEntry)
+	for _, entry := range c.state.zone(zone) {
+		previous[entry.Record] = entry
+	}
+
+	entries := make([]stateEntry, 0, len(records))
+	for _, record := range records {
+		entry, ok := previous[record]
+		if !ok {
+			entry = stateEntry{Zone: zone, Record: record, Created: now}
+		}
+		if c.devices != nil {
+			if device := c.devices(record); device != "" {
+				entry.Device = device
+			}
+		}
+		entry.Refreshed = now
+		if refreshed, ok := c.refreshed[zone][record.Key()]; ok {
+			entry.Refreshed = refreshed
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := c.state.set(zone, entries, now); err != nil {
+		klog.Errorf("Failed to save state of zone %s: %v", zone, err)
+	}
+}
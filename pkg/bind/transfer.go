@@ -0,0 +1,465 @@
+package bind
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// With bind.zone_transfer, the reads made before updates, by ownership checks, by the PTR bootstrap and by consistency
+// checks transfer each zone once instead of looking names up one by one. Transfers are TSIG-signed and always go over
+// TCP (or TLS with tcp-tls). The first transfer of a zone is a full AXFR, later ones ask for an IXFR from the serial
+// seen last so that an unchanged zone costs a single SOA record. Zones the server doesn't permit transferring are read
+// with targeted queries instead.
+
+const (
+	// transferTimeout bounds every read and write of a zone transfer
+	transferTimeout = 30 * time.Second
+	// maxTransferCNAMEChain bounds how many aliases are followed within a transferred zone
+	maxTransferCNAMEChain = 8
+)
+
+// ErrTransferRefused means the server doesn't permit transferring the zone, e.g. because allow-transfer doesn't list
+// the key
+var ErrTransferRefused = errors.New("server refused the zone transfer")
+
+// zoneSnapshot holds the records of a transferred zone. Snapshots are never modified once built, an incremental
+// transfer produces a new one.
+type zoneSnapshot struct {
+	serial  uint32
+	records map[string][]dns.RR // By canonical owner name, without the SOA record
+}
+
+// lookup returns the records of a type at a name, following aliases within the zone like a query would
+func (s *zoneSnapshot) lookup(name string, qtype uint16) []dns.RR {
+	var answers []dns.RR
+	name = dns.CanonicalName(name)
+	for range maxTransferCNAMEChain {
+		var cname *dns.CNAME
+		matched := false
+		for _, rr := range s.records[name] {
+			if rr.Header().Rrtype == qtype {
+				answers = append(answers, rr)
+				matched = true
+			} else if alias, ok := rr.(*dns.CNAME); ok {
+				cname = alias
+			}
+		}
+		if matched || cname == nil {
+			break
+		}
+		answers = append(answers, cname)
+		name = dns.CanonicalName(cname.Target)
+	}
+	return answers
+}
+
+// all returns every record of the zone, sorted by name
+func (s *zoneSnapshot) all() []dns.RR {
+	var rrs []dns.RR
+	for _, name := range slices.Sorted(maps.Keys(s.records)) {
+		rrs = append(rrs, s.records[name]...)
+	}
+	return rrs
+}
+
+// apply builds the snapshot of a zone from the records of a transfer, which either hold the whole zone or, for an
+// incremental transfer, the records removed and added since the serial of the previous snapshot
+func (s *zoneSnapshot) apply(rrs []dns.RR) (*zoneSnapshot, error) {
+	if len(rrs) == 0 {
+		return nil, fmt.Errorf("transfer holds no records")
+	}
+	soa, ok := rrs[0].(*dns.SOA)
+	if !ok {
+		return nil, fmt.Errorf("transfer doesn't start with an SOA record")
+	}
+	next := &zoneSnapshot{serial: soa.Serial, records: make(map[string][]dns.RR)}
+
+	// A single SOA record means that the zone didn't change since the previous transfer
+	if len(rrs) == 1 {
+		if s == nil {
+			return nil, fmt.Errorf("transfer holds no records")
+		}
+		maps.Copy(next.records, s.records)
+		return next, nil
+	}
+
+	// Incremental transfers repeat the old SOA record right away, full transfers continue with the zone's records
+	if _, incremental := rrs[1].(*dns.SOA); !incremental || s == nil {
+		for _, rr := range rrs[1 : len(rrs)-1] {
+			if rr.Header().Rrtype != dns.TypeSOA {
+				next.add(rr)
+			}
+		}
+		return next, nil
+	}
+
+	for name, records := range s.records {
+		next.records[name] = slices.Clone(records)
+	}
+	// Every difference sequence starts with the old SOA record followed by the removed records, then the new SOA
+	// record followed by the added ones
+	removing := false
+	for _, rr := range rrs[1 : len(rrs)-1] {
+		if rr.Header().Rrtype == dns.TypeSOA {
+			removing = !removing
+			continue
+		}
+		if removing {
+			next.remove(rr)
+		} else {
+			next.add(rr)
+		}
+	}
+	return next, nil
+}
+
+// add stores a record in the snapshot
+func (s *zoneSnapshot) add(rr dns.RR) {
+	name := dns.CanonicalName(rr.Header().Name)
+	s.records[name] = append(s.records[name], rr)
+}
+
+// remove drops a record from the snapshot, whatever its TTL
+func (s *zoneSnapshot) remove(rr dns.RR) {
+	name := dns.CanonicalName(rr.Header().Name)
+	s.records[name] = slices.DeleteFunc(s.records[name], func(other dns.RR) bool {
+		return dns.IsDuplicate(rr, other)
+	})
+	if len(s.records[name]) == 0 {
+		delete(s.records, name)
+	}
+}
+
+// transferZone transfers a zone from the first server that can be reached. Zones transferred before are transferred
+// incrementally from the serial seen last. A server that doesn't permit the transfer fails it with ErrTransferRefused.
+func (c *Client) transferZone(ctx context.Context, zone string) (*zoneSnapshot, error) {
+	zone = dns.CanonicalName(zone)
+	key, secret, err := c.signingKey()
+	if err == nil {
+		key, secret, err = c.zoneSigningKey(zone, key, secret)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating TSIG key: %w", err)
+	}
+
+	c.statusMu.Lock()
+	previous := c.transfers[zone]
+	c.statusMu.Unlock()
+
+	msg := new(dns.Msg)
+	if previous != nil {
+		// Only the serial of the SOA record in the request matters
+		msg.SetIxfr(zone, previous.serial, ".", ".")
+	} else {
+		msg.SetAxfr(zone)
+	}
+	// Sign the message with TSIG (300 seconds timeout)
+	const tsigTimeout = 300
+	msg.SetTsig(key.Hdr.Name, key.Algorithm, tsigTimeout, time.Now().Unix())
+	secrets := map[string]string{key.Hdr.Name: secret}
+
+	var errs []error
+	for _, s := range c.serverOrder() {
+		// Signing a message with TSIG modifies it, so every server gets its own copy
+		rrs, err := c.transferFrom(ctx, s, msg.Copy(), secrets)
+		var refused *transferRcodeError
+		if errors.As(err, &refused) {
+			// The server could be reached, it just doesn't want to transfer the zone
+			c.recordServerResult(s, nil)
+			return nil, fmt.Errorf("transferring zone %s: %w", zone, err)
+		}
+		c.recordServerResult(s, err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("server %s: %w", s.address(), err))
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+
+		snapshot, err := previous.apply(rrs)
+		if err != nil {
+			return nil, fmt.Errorf("transferring zone %s: %w", zone, err)
+		}
+		klog.V(1).Infof("Transferred zone %s at serial %d from %s (%d records)", zone, snapshot.serial, s.address(),
+			len(rrs))
+
+		c.statusMu.Lock()
+		if c.transfers == nil {
+			c.transfers = make(map[string]*zoneSnapshot)
+		}
+		c.transfers[zone] = snapshot
+		c.statusMu.Unlock()
+		return snapshot, nil
+	}
+	return nil, fmt.Errorf("transferring zone %s: %w", zone, errors.Join(errs...))
+}
+
+// transferRcodeError is returned when the server answers a transfer with an unsuccessful response code
+type transferRcodeError struct {
+	rcode int
+}
+
+func (e *transferRcodeError) Error() string {
+	return fmt.Sprintf("server responded with Rcode %d: %s", e.rcode, dns.RcodeToString[e.rcode])
+}
+
+// Is makes every unsuccessful response code count as a refusal, servers answer REFUSED or NOTAUTH depending on why
+func (e *transferRcodeError) Is(target error) bool {
+	return target == ErrTransferRefused
+}
+
+// transferFrom transfers a zone from a single server and returns every record of the transfer in order
+func (c *Client) transferFrom(
+	ctx context.Context,
+	s server,
+	msg *dns.Msg,
+	secrets map[string]string,
+) ([]dns.RR, error) {
+	network := config.TransportTCP
+	if c.network() == config.TransportTCPTLS {
+		network = config.TransportTCPTLS
+	}
+	client := &dns.Client{Net: network, Timeout: transferTimeout, TLSConfig: s.clientTLSConfig()}
+	conn, err := client.DialContext(ctx, s.address())
+	if err != nil {
+		return nil, fmt.Errorf("connecting over %s: %w", network, err)
+	}
+	// The transfer closes the connection once it's done, closing it early aborts the transfer
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	transfer := &dns.Transfer{
+		Conn:         conn,
+		ReadTimeout:  transferTimeout,
+		WriteTimeout: transferTimeout,
+		TsigSecret:   secrets,
+		TsigProvider: c.tsigProvider(msg, secrets),
+	}
+	envelopes, err := transfer.In(msg, s.address())
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("requesting transfer: %w", err)
+	}
+
+	var rrs []dns.RR
+	for envelope := range envelopes {
+		if envelope.Error != nil {
+			if rcode, ok := transferRcode(envelope.Error); ok {
+				return nil, &transferRcodeError{rcode: rcode}
+			}
+			return nil, fmt.Errorf("receiving transfer: %w", envelope.Error)
+		}
+		rrs = append(rrs, envelope.RR...)
+	}
+	return rrs, nil
+}
+
+// transferRcode extracts the response code from the error of a transfer the server answered unsuccessfully. The
+// library only reports it as part of the error message.
+func transferRcode(err error) (int, bool) {
+	var rcode int
+	if _, scanErr := fmt.Sscanf(err.Error(), "dns: bad xfr rcode: %d", &rcode); scanErr != nil {
+		return 0, false
+	}
+	return rcode, true
+}
+
+// zoneReader reads record sets from the server, transferring every zone at most once and looking names up one by one
+// in zones that can't be transferred. A reader lives for a single operation so that it never serves outdated records.
+type zoneReader struct {
+	c        *Client
+	transfer bool                     // Whether zones are transferred at all
+	zones    map[string]*zoneSnapshot // By canonical zone name, nil for zones that can't be transferred
+	resolver Resolver                 // Looks up every name instead when set, see resolver.go
+}
+
+// newZoneReader returns a reader that transfers zones when bind.zone_transfer is enabled
+func (c *Client) newZoneReader() *zoneReader {
+	return &zoneReader{c: c, transfer: c.zoneTransfer, zones: make(map[string]*zoneSnapshot)}
+}
+
+// snapshot returns the records of a zone, transferring it on first use. It returns nil when the zone can't be
+// transferred.
+func (r *zoneReader) snapshot(ctx context.Context, zone string) (*zoneSnapshot, error) {
+	zone = dns.CanonicalName(zone)
+	if snapshot, ok := r.zones[zone]; ok {
+		return snapshot, nil
+	}
+	if snapshot, ok := r.c.takePrefetched(zone); ok {
+		r.zones[zone] = snapshot
+		return snapshot, nil
+	}
+
+	snapshot, err := r.c.transferZone(ctx, zone)
+	if errors.Is(err, ErrTransferRefused) {
+		klog.V(1).Infof("Looking up names in zone %s one by one: %v", zone, err)
+		snapshot, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.zones[zone] = snapshot
+	return snapshot, nil
+}
+
+// lookup returns the records of a type at a name, from the transfer of its zone when possible
+func (r *zoneReader) lookup(ctx context.Context, name string, qtype uint16) ([]dns.RR, error) {
+	if r.resolver != nil {
+		return r.resolver.Lookup(ctx, name, qtype)
+	}
+
+	zone := r.c.nameZone(name)
+	if !r.transfer || zone == "" {
+		return r.c.lookup(ctx, name, qtype)
+	}
+
+	snapshot, err := r.snapshot(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot == nil {
+		return r.c.lookup(ctx, name, qtype)
+	}
+	return snapshot.lookup(name, qtype), nil
+}
+
+// nameZone returns the configured zone a name belongs to, the empty string when it doesn't belong to any
+func (c *Client) nameZone(name string) string {
+	name = dns.CanonicalName(name)
+	if strings.HasSuffix(name, ".arpa.") {
+		return c.recordZone(DNSRecord{Name: name, Type: TypePTR})
+	}
+	if dns.IsSubDomain(dns.CanonicalName(c.zone), name) {
+		return c.zone
+	}
+	return ""
+}
+
+// ZoneContents holds the records a zone on the server holds, compared to the desired records of the zone
+type ZoneContents struct {
+	Zone string `json:"zone"`
+	// Transferred is false when the server didn't permit transferring the zone, so that only the record sets of the
+	// desired records were looked up
+	Transferred bool        `json:"transferred"`
+	Records     []DNSRecord `json:"records"`
+	// Diff holds the desired records missing from the zone as added and those whose record sets differ as changed. The
+	// records the zone holds without them being desired are only known, and listed as removed, for transferred zones.
+	Diff RecordDiff `json:"diff"`
+}
+
+// ServerRecords reads the records the server holds in the zones of the desired records and the client's own zone. Zones
+// are transferred when the server permits it, whatever bind.zone_transfer says, and otherwise only the names of the
+// desired records are looked up. Records of types this tool doesn't publish and ownership records are left out.
+func (c *Client) ServerRecords(ctx context.Context, desired []DNSRecord) ([]ZoneContents, error) {
+	return c.serverRecords(ctx, &zoneReader{c: c, transfer: true, zones: make(map[string]*zoneSnapshot)}, desired)
+}
+
+// serverRecords reads the records the server holds in the zones of the desired records and the client's own zone with
+// reader
+func (c *Client) serverRecords(ctx context.Context, reader *zoneReader, desired []DNSRecord) ([]ZoneContents, error) {
+	recordsByZone := c.groupRecordsByZone(desired)
+	if _, ok := recordsByZone[c.zone]; !ok {
+		recordsByZone[c.zone] = nil
+	}
+
+	var contents []ZoneContents
+	for _, zone := range slices.Sorted(maps.Keys(recordsByZone)) {
+		zoneContents, err := c.zoneContents(ctx, reader, zone, recordsByZone[zone])
+		if err != nil {
+			return contents, err
+		}
+		contents = append(contents, zoneContents)
+	}
+	return contents, nil
+}
+
+// zoneContents reads the records a single zone holds and compares them to its desired records
+func (c *Client) zoneContents(
+	ctx context.Context,
+	reader *zoneReader,
+	zone string,
+	desired []DNSRecord,
+) (ZoneContents, error) {
+	contents := ZoneContents{Zone: zone}
+	snapshot, err := reader.snapshot(ctx, zone)
+	if err != nil {
+		return contents, err
+	}
+
+	var rrs []dns.RR
+	if snapshot != nil {
+		contents.Transferred = true
+		rrs = snapshot.all()
+	} else {
+		for key, records := range groupByKey(desired) {
+			qtype := key.Type.Qtype()
+			name := recordFQDN(zone, records[0])
+			answers, err := c.lookup(ctx, name, qtype)
+			if err != nil {
+				return contents, fmt.Errorf("looking up %s record %s: %w", key.Type, key.Name, err)
+			}
+			for _, rr := range answers {
+				// Answers may also hold the records of a CNAME chain, which belong to other names
+				if rr.Header().Rrtype == qtype && dns.CanonicalName(rr.Header().Name) == name {
+					rrs = append(rrs, rr)
+				}
+			}
+		}
+	}
+
+	for _, rr := range rrs {
+		if record, ok := recordFromRR(zone, rr); ok {
+			contents.Records = append(contents.Records, record)
+		}
+	}
+	sortRecords(contents.Records)
+
+	contents.Diff = DiffRecords(contents.Records, desired)
+	if !contents.Transferred {
+		contents.Diff.Removed = nil
+	}
+	return contents, nil
+}
+
+// recordFromRR converts a record on the server into the record it is published as in zone. It returns false for
+// types this tool doesn't publish, ownership records and records at the zone apex.
+func recordFromRR(zone string, rr dns.RR) (DNSRecord, bool) {
+	hdr := rr.Header()
+	record := DNSRecord{TTL: hdr.Ttl, Type: RecordType(dns.TypeToString[hdr.Rrtype])}
+	switch rr := rr.(type) {
+	case *dns.A:
+		record.Value = rr.A.String()
+	case *dns.AAAA:
+		record.Value = rr.AAAA.String()
+	case *dns.CNAME:
+		record.Value = strings.TrimSuffix(rr.Target, ".")
+	case *dns.PTR:
+		// PTR records are named by their full reverse name
+		record.Name, record.Value = dns.CanonicalName(hdr.Name), strings.TrimSuffix(rr.Ptr, ".")
+		return record, true
+	case *dns.TXT:
+		if _, isOwner := parseOwnership(rr); isOwner {
+			return DNSRecord{}, false
+		}
+		record.Value = strings.Join(rr.Txt, "")
+	default:
+		return DNSRecord{}, false
+	}
+
+	name, ok := strings.CutSuffix(dns.CanonicalName(hdr.Name), "."+dns.CanonicalName(zone))
+	if !ok {
+		return DNSRecord{}, false
+	}
+	record.Name = name
+	return record, true
+}
@@ -0,0 +1,133 @@
+package bind
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// tsigMaxMACSize is the size of the largest TSIG MAC (hmac-sha512)
+const tsigMaxMACSize = 64
+
+// network returns the transport messages are sent over, defaulting to UDP
+func (c *Client) network() string {
+	if c.transport == "" {
+		return config.TransportUDP
+	}
+	return c.transport
+}
+
+// exchangeWith sends a message to a server over the configured transport and returns its response. Over UDP, messages
+// too large for a datagram are sent over TCP right away and truncated responses are retried over TCP. A zero timeout
+// keeps the library defaults.
+func (c *Client) exchangeWith(
+	ctx context.Context,
+	s server,
+	msg *dns.Msg,
+	timeout time.Duration,
+	tsigSecret map[string]string,
+) (*dns.Msg, error) {
+	network := c.network()
+	size := msg.Len()
+	if msg.IsTsig() != nil {
+		// The TSIG record is still unsigned, its MAC is only added while the message is sent
+		size += tsigMaxMACSize
+	}
+	if network == config.TransportUDP && size > dns.DefaultMsgSize {
+		klog.V(2).Infof("Message of %d bytes exceeds the UDP message size, sending it over TCP", size)
+		network = config.TransportTCP
+	}
+
+	// Signing a message with TSIG modifies it, so keep an unsigned copy in case it has to be resent
+	retry := msg.Copy()
+	response, err := c.exchangeOver(ctx, s, network, msg, timeout, tsigSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.Truncated && network == config.TransportUDP {
+		klog.V(1).Infof("Response from %s was truncated, retrying over TCP", s.host)
+		return c.exchangeOver(ctx, s, config.TransportTCP, retry, timeout, tsigSecret)
+	}
+	return response, nil
+}
+
+// exchangeOver sends a message to a server over a specific transport
+func (c *Client) exchangeOver(
+	ctx context.Context,
+	s server,
+	network string,
+	msg *dns.Msg,
+	timeout time.Duration,
+	tsigSecret map[string]string,
+) (*dns.Msg, error) {
+	client := &dns.Client{
+		Net:          network,
+		Timeout:      timeout,
+		TsigSecret:   tsigSecret,
+		TsigProvider: c.tsigProvider(msg, tsigSecret),
+	}
+	if network == config.TransportTCPTLS {
+		client.TLSConfig = s.clientTLSConfig()
+	}
+
+	response, _, err := client.ExchangeContext(ctx, msg, s.address())
+	if err != nil {
+		// Servers don't sign errors caused by a bad key, so the response fails verification but its response code
+		// still tells what went wrong
+		if response != nil && response.Rcode != dns.RcodeSuccess {
+			return response, nil
+		}
+		return nil, fmt.Errorf("exchanging message over %s: %w", network, err)
+	}
+	return response, nil
+}
+
+// clientTLSConfig returns the TLS configuration of DNS over TLS connections to the server, verifying its host name
+// unless configured otherwise
+func (s server) clientTLSConfig() *tls.Config {
+	if s.tlsConfig != nil {
+		return s.tlsConfig
+	}
+	return &tls.Config{ServerName: s.host, MinVersion: tls.VersionTLS12}
+}
+
+// newTLSConfig builds the TLS configuration for DNS over TLS connections to server
+func newTLSConfig(server string, cfg *config.TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: server,
+		MinVersion: tls.VersionTLS12,
+	}
+	if cfg.ServerName != "" {
+		tlsConfig.ServerName = cfg.ServerName
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading TLS CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in TLS CA file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading TLS client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,181 @@
+package bind
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// statisticsZonesPath is the BIND statistics channel endpoint holding the counters of every zone, kept with
+	// zone-statistics enabled
+	statisticsZonesPath = "/json/v1/zones"
+	// statisticsUpdateFailCounter counts updates BIND rejected or failed to apply, which includes journal write errors
+	statisticsUpdateFailCounter = "UpdateFail"
+
+	verifyTimeout = 5 * time.Second
+)
+
+// updateCheck holds the server state captured before a zone update so that it can be compared afterwards
+type updateCheck struct {
+	zone string
+
+	checkSerial bool
+	serial      uint32
+
+	checkJournal   bool
+	updateFailures uint64
+}
+
+// beginUpdateCheck captures the state needed to verify that an update to a zone was durably applied. The SOA serial is
+// only checked when the records differ from the last confirmed update, because BIND leaves the serial alone for
+// updates that change nothing.
+func (c *Client) beginUpdateCheck(ctx context.Context, zone string, records []DNSRecord) (*updateCheck, error) {
+	check := &updateCheck{zone: zone}
+
+	if c.verifySerial && c.expectsChange(zone, records) {
+		serial, err := c.querySOASerial(ctx, zone)
+		if err != nil {
+			return nil, fmt.Errorf("reading SOA serial before update: %w", err)
+		}
+		check.checkSerial = true
+		check.serial = serial
+	}
+
+	if c.statisticsURL != "" {
+		failures, err := c.queryUpdateFailures(ctx, zone)
+		if err != nil {
+			// The statistics channel is advisory, so don't hold back the update when it is unavailable
+			klog.Warningf("Skipping journal health check for zone %s: %v", zone, err)
+		} else {
+			check.checkJournal = true
+			check.updateFailures = failures
+		}
+	}
+
+	return check, nil
+}
+
+// finishUpdateCheck compares the server state after an acknowledged update with the state captured before it and
+// returns the zone's current SOA serial, or 0 when the serial wasn't checked
+func (c *Client) finishUpdateCheck(ctx context.Context, check *updateCheck) (uint32, error) {
+	var serial uint32
+	if check.checkSerial {
+		after, err := c.querySOASerial(ctx, check.zone)
+		if err != nil {
+			return 0, fmt.Errorf("reading SOA serial after update: %w", err)
+		}
+		if !serialAdvanced(check.serial, after) {
+			return after, fmt.Errorf("update was acknowledged but SOA serial did not advance from %d", check.serial)
+		}
+		klog.V(1).Infof("Zone %s serial advanced from %d to %d", check.zone, check.serial, after)
+		serial = after
+	}
+
+	if check.checkJournal {
+		failures, err := c.queryUpdateFailures(ctx, check.zone)
+		if err != nil {
+			klog.Warningf("Skipping journal health check for zone %s: %v", check.zone, err)
+		} else if failures > check.updateFailures {
+			return serial, fmt.Errorf("update was acknowledged but the server reported %d failed updates of the zone, "+
+				"check the BIND log for journal write errors", failures-check.updateFailures)
+		}
+	}
+
+	return serial, nil
+}
+
+// expectsChange reports whether records differ from the last update to the zone that was confirmed. Nothing is
+// expected before the first confirmed update since the zone's current contents are unknown.
+func (c *Client) expectsChange(zone string, records []DNSRecord) bool {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	previous, ok := c.published[zone]
+	if !ok {
+		return false
+	}
+	return !DiffRecords(previous, records).Empty()
+}
+
+// querySOASerial asks the server for the current SOA serial of a zone
+func (c *Client) querySOASerial(ctx context.Context, zone string) (uint32, error) {
+	answers, err := c.lookup(ctx, zone, dns.TypeSOA)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, rr := range answers {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa.Serial, nil
+		}
+	}
+	return 0, fmt.Errorf("no SOA record returned for zone %s", zone)
+}
+
+// queryUpdateFailures reads the failed update counter of a zone from the BIND statistics channel. The counters of the
+// zone are used rather than the server wide ones, so that failed updates of other zones and clients don't count.
+func (c *Client) queryUpdateFailures(ctx context.Context, zone string) (uint64, error) {
+	ctx, cancel := context.WithTimeout(ctx, verifyTimeout)
+	defer cancel()
+
+	url := strings.TrimSuffix(c.statisticsURL, "/") + statisticsZonesPath
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, fmt.Errorf("building statistics request: %w", err)
+	}
+
+	resp, err := c.statisticsClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("querying statistics channel: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("statistics channel request failed: %s", resp.Status)
+	}
+
+	var stats struct {
+		Views map[string]struct {
+			Zones []struct {
+				Name   string            `json:"name"`
+				Rcodes map[string]uint64 `json:"rcodes"`
+			} `json:"zones"`
+		} `json:"views"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return 0, fmt.Errorf("decoding statistics: %w", err)
+	}
+
+	var (
+		failures uint64
+		found    bool
+	)
+	for _, view := range stats.Views {
+		for _, z := range view.Zones {
+			// Zones without counters are listed too when zone-statistics is off
+			if dns.CanonicalName(z.Name) != dns.CanonicalName(zone) || z.Rcodes == nil {
+				continue
+			}
+			// BIND omits counters that are still zero
+			failures += z.Rcodes[statisticsUpdateFailCounter]
+			found = true
+		}
+	}
+	if !found {
+		return 0, fmt.Errorf("no statistics for zone %s, enable zone-statistics for it", zone)
+	}
+	return failures, nil
+}
+
+// serialAdvanced reports whether a SOA serial moved forward using RFC 1982 serial number arithmetic
+func serialAdvanced(before, after uint32) bool {
+	//nolint:gosec // Wrapping into int32 is exactly what RFC 1982 comparison relies on
+	return after != before && int32(after-before) > 0
+}
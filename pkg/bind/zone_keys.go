@@ -0,0 +1,83 @@
+package bind
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/miekg/dns"
+)
+
+// Servers often scope every TSIG key to a single zone in named.conf, so the reverse zones can have keys of their own
+// with bind.ptr.ipv4_key and bind.ptr.ipv6_key. Updates and transfers of a reverse zone, and of its shards, are signed
+// with the key of its address family when one is configured and with the key of the forward zone otherwise. A key
+// with a secret is signed with directly, even when the forward zone's MACs are computed by the key command or its key
+// is negotiated with GSS-TSIG; without a secret the key command computes its MACs. Rotating the key only replaces the
+// key of the forward zone.
+
+// reverseKey returns the key a reverse zone is configured with, nil for forward zones and reverse zones without a key
+// of their own
+func (c *Client) reverseKey(zone string) *config.TSIGKeyConfig {
+	if c.ptrConfig == nil {
+		return nil
+	}
+	var key *config.TSIGKeyConfig
+	switch zone = dns.CanonicalName(zone); {
+	case strings.HasSuffix(zone, ".in-addr.arpa."):
+		key = &c.ptrConfig.IPv4Key
+	case strings.HasSuffix(zone, ".ip6.arpa."):
+		key = &c.ptrConfig.IPv6Key
+	}
+	if key == nil || key.KeyName == "" {
+		return nil
+	}
+	return key
+}
+
+// zoneSigningKey returns the key messages to a zone are signed with: the key of the reverse zone when it has one,
+// otherwise key, the key of the forward zone
+func (c *Client) zoneSigningKey(zone string, key *dns.TSIG, secret string) (*dns.TSIG, string, error) {
+	reverse := c.reverseKey(zone)
+	if reverse == nil {
+		return key, secret, nil
+	}
+
+	c.keyMu.RLock()
+	algorithm := c.algorithm
+	c.keyMu.RUnlock()
+	if reverse.Algorithm != "" {
+		algorithm = reverse.Algorithm
+	}
+	algorithm, err := tsigAlgorithm(algorithm)
+	if err != nil {
+		return nil, "", fmt.Errorf("key of zone %s: %w", zone, err)
+	}
+
+	return &dns.TSIG{
+		Hdr: dns.RR_Header{
+			Name:   dns.Fqdn(reverse.KeyName),
+			Rrtype: dns.TypeTSIG,
+			Class:  dns.ClassANY,
+		},
+		Algorithm: algorithm + ".",
+	}, reverse.KeySecret, nil
+}
+
+// zoneKeyName returns the name of the key updates of a zone are signed with, as matched by the update policy
+func (c *Client) zoneKeyName(zone string) string {
+	if reverse := c.reverseKey(zone); reverse != nil {
+		return reverse.KeyName
+	}
+	c.keyMu.RLock()
+	defer c.keyMu.RUnlock()
+	return c.keyName
+}
+
+// tsigProvider returns the provider computing the MACs of a message: the signer of the client, unless the message is
+// signed with a key whose secret is known, such as the key of a reverse zone
+func (c *Client) tsigProvider(msg *dns.Msg, secrets map[string]string) dns.TsigProvider {
+	if t := msg.IsTsig(); t != nil && secrets[t.Hdr.Name] != "" {
+		return nil
+	}
+	return c.signer
+}
@@ -0,0 +1,148 @@
+package clock
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Fake is a clock that only moves when advanced. Tickers and timers created from it fire during Advance, and
+// BlockUntil lets tests wait for the code under test to start waiting on them.
+type Fake struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+// fakeWaiter is the state of a ticker, or of a timer when period is zero
+type fakeWaiter struct {
+	clock    *Fake
+	c        chan time.Time
+	deadline time.Time
+	period   time.Duration
+}
+
+// NewFake returns a fake clock starting at now
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+// Now returns the current fake time
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Since returns the fake time elapsed since t
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+// NewTicker returns a ticker firing every d of fake time
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	return fakeTicker{f.add(d, d)}
+}
+
+// NewTimer returns a timer firing once d of fake time has passed
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	return fakeTimer{f.add(d, 0)}
+}
+
+// add registers a waiter due after d
+func (f *Fake) add(d, period time.Duration) *fakeWaiter {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w := &fakeWaiter{clock: f, c: make(chan time.Time, 1), deadline: f.now.Add(d), period: period}
+	f.waiters = append(f.waiters, w)
+	f.cond.Broadcast()
+	return w
+}
+
+// Advance moves the clock forward by d, firing every ticker and timer that became due. Like real tickers, a ticker
+// whose previous tick wasn't received yet drops the tick.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+	f.waiters = slices.DeleteFunc(f.waiters, func(w *fakeWaiter) bool {
+		if w.deadline.After(f.now) {
+			return false
+		}
+		select {
+		case w.c <- f.now:
+		default:
+		}
+		if w.period == 0 {
+			return true
+		}
+		for !w.deadline.After(f.now) {
+			w.deadline = w.deadline.Add(w.period)
+		}
+		return false
+	})
+	f.cond.Broadcast()
+}
+
+// BlockUntil waits until n tickers and timers are pending
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for len(f.waiters) < n {
+		f.cond.Wait()
+	}
+}
+
+type fakeTicker struct{ *fakeWaiter }
+
+// Reset restarts the ticker with a new period
+func (t fakeTicker) Reset(d time.Duration) {
+	f := t.clock
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t.period = d
+	t.deadline = f.now.Add(d)
+	if !slices.Contains(f.waiters, t.fakeWaiter) {
+		f.waiters = append(f.waiters, t.fakeWaiter)
+	}
+	f.cond.Broadcast()
+}
+
+// Stop stops the ticker
+func (t fakeTicker) Stop() {
+	t.stop()
+}
+
+type fakeTimer struct{ *fakeWaiter }
+
+// Stop stops the timer, reporting whether it was still pending
+func (t fakeTimer) Stop() bool {
+	return t.stop()
+}
+
+// C returns the channel ticks are delivered on
+func (w *fakeWaiter) C() <-chan time.Time {
+	return w.c
+}
+
+// stop removes the waiter from the clock, reporting whether it was still pending
+func (w *fakeWaiter) stop() bool {
+	f := w.clock
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	i := slices.Index(f.waiters, w)
+	if i < 0 {
+		return false
+	}
+	f.waiters = slices.Delete(f.waiters, i, i+1)
+	f.cond.Broadcast()
+	return true
+}
@@ -0,0 +1,79 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fired reports whether a tick is waiting on c
+func fired(c <-chan time.Time) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestFakeTicker(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := NewFake(start)
+	ticker := clk.NewTicker(time.Minute)
+
+	clk.Advance(59 * time.Second)
+	assert.False(t, fired(ticker.C()))
+	assert.Equal(t, 59*time.Second, clk.Since(start))
+
+	clk.Advance(time.Second)
+	assert.True(t, fired(ticker.C()))
+
+	// Ticks that aren't received are dropped rather than queued
+	clk.Advance(3 * time.Minute)
+	assert.True(t, fired(ticker.C()))
+	assert.False(t, fired(ticker.C()))
+
+	// Reset starts the period over from the current time
+	clk.Advance(30 * time.Second)
+	ticker.Reset(time.Minute)
+	clk.Advance(45 * time.Second)
+	assert.False(t, fired(ticker.C()))
+	clk.Advance(15 * time.Second)
+	assert.True(t, fired(ticker.C()))
+
+	ticker.Stop()
+	clk.Advance(time.Hour)
+	assert.False(t, fired(ticker.C()))
+}
+
+func TestFakeTimer(t *testing.T) {
+	clk := NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	timer := clk.NewTimer(time.Second)
+	clk.Advance(time.Second)
+	assert.True(t, fired(timer.C()))
+	clk.Advance(time.Hour)
+	assert.False(t, fired(timer.C()), "a timer fires once")
+	assert.False(t, timer.Stop())
+
+	timer = clk.NewTimer(time.Second)
+	assert.True(t, timer.Stop())
+	clk.Advance(time.Second)
+	assert.False(t, fired(timer.C()))
+}
+
+func TestFakeBlockUntil(t *testing.T) {
+	clk := NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		timer := clk.NewTimer(time.Minute)
+		<-timer.C()
+	}()
+
+	clk.BlockUntil(1)
+	clk.Advance(time.Minute)
+	<-done
+}
@@ -0,0 +1,171 @@
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Validation stops at the first invalid setting and only rejects configurations that can't work at all. Checking a
+// configuration reports every problem it can find instead, including settings that are valid on their own but don't
+// fit together, e.g. a reverse zone that doesn't match the subnet its PTR records are generated for.
+
+// Severities of the problems found by Check
+const (
+	// SeverityError marks a configuration that is rejected or doesn't publish what it's meant to
+	SeverityError = "error"
+	// SeverityWarning marks settings that are probably not what was meant
+	SeverityWarning = "warning"
+)
+
+// Problem is a problem with the configuration
+type Problem struct {
+	Severity string `json:"severity" yaml:"severity"`
+	// Key is the dotted path of the setting the problem is about, empty when it isn't about a single setting
+	Key     string `json:"key,omitempty" yaml:"key,omitempty"`
+	Message string `json:"message" yaml:"message"`
+}
+
+// HasErrors reports whether any of the problems is an error
+func HasErrors(problems []Problem) bool {
+	return slices.ContainsFunc(problems, func(p Problem) bool { return p.Severity == SeverityError })
+}
+
+// Check validates the configuration and looks for semantic problems validation lets through. path is the
+// configuration file the configuration was loaded from, whose migrated and unknown keys are reported as well, empty
+// when there is none.
+func (c *Config) Check(path string) []Problem {
+	var problems []Problem
+	if err := c.Validate(); err != nil {
+		problems = append(problems, Problem{Severity: SeverityError, Message: err.Error()})
+	}
+	switch c.General.LogLevel {
+	case "debug", "verbose", "info":
+	default:
+		problems = append(problems, Problem{Severity: SeverityError, Key: "general.log_level",
+			Message: fmt.Sprintf("invalid log level %q, must be debug, verbose or info", c.General.LogLevel)})
+	}
+
+	if path != "" {
+		_, warnings, err := MigrateFile(path)
+		if err != nil {
+			problems = append(problems, Problem{Severity: SeverityError, Message: err.Error()})
+		}
+		for _, warning := range warnings {
+			problems = append(problems, Problem{Severity: SeverityWarning, Message: warning})
+		}
+	}
+
+	if c.Bind.PTR.Enabled {
+		problems = append(problems, checkPTR("bind.ptr.ipv4", c.Bind.PTR.IPv4Zone, c.Bind.PTR.IPv4Subnet,
+			c.Bind.PTR.IPv4SubnetSize)...)
+		if c.Bind.PTR.IPv6Enabled && c.IPv6Enabled() {
+			problems = append(problems, checkPTR("bind.ptr.ipv6", c.Bind.PTR.IPv6Zone, c.Bind.PTR.IPv6Subnet,
+				c.Bind.PTR.IPv6SubnetSize)...)
+		}
+	}
+	return problems
+}
+
+// checkPTR checks that the reverse zone of an address family matches the subnet PTR records are generated for. PTR
+// records are published in the reverse zones of subnetSize their addresses fall in, so the zone has to be one of
+// those for the records to be published in a managed zone.
+func checkPTR(prefix, zone, subnet string, subnetSize int) []Problem {
+	if subnet == "" {
+		return []Problem{{Severity: SeverityWarning, Key: prefix + "_subnet",
+			Message: "no PTR records are published for these addresses without a subnet"}}
+	}
+	network, err := netip.ParsePrefix(subnet)
+	if err != nil || zone == "" {
+		// Already reported by the validation
+		return nil
+	}
+	network = network.Masked()
+
+	zonePrefix, ok := reverseZonePrefix(zone)
+	switch {
+	case !ok || zonePrefix.Addr().Is4() != network.Addr().Is4():
+		return []Problem{{Severity: SeverityError, Key: prefix + "_zone",
+			Message: fmt.Sprintf("%s is not a reverse zone of %s", zone, subnet)}}
+	case zonePrefix.Bits() != subnetSize:
+		return []Problem{{Severity: SeverityError, Key: prefix + "_zone",
+			Message: fmt.Sprintf("%s is a /%d reverse zone, but PTR records are published in the /%d zones of "+
+				"%s_subnet_size, e.g. %s", zone, zonePrefix.Bits(), subnetSize, prefix,
+				reverseZone(network, subnetSize))}}
+	case !zonePrefix.Overlaps(network):
+		return []Problem{{Severity: SeverityError, Key: prefix + "_zone",
+			Message: fmt.Sprintf("%s holds no address of %s, PTR records are published in %s", zone, subnet,
+				reverseZone(network, subnetSize))}}
+	case network.Bits() < subnetSize:
+		return []Problem{{Severity: SeverityWarning, Key: prefix + "_subnet",
+			Message: fmt.Sprintf("%s spans %s reverse zones of /%d, the PTR records of addresses outside %s go to "+
+				"zones that aren't managed", subnet, zoneCount(subnetSize-network.Bits()), subnetSize, zone)}}
+	}
+	return nil
+}
+
+// reverseZonePrefix returns the network a reverse zone is for, e.g. 100.64.0.0/16 for 64.100.in-addr.arpa
+func reverseZonePrefix(zone string) (netip.Prefix, bool) {
+	name := strings.TrimSuffix(dns.CanonicalName(zone), ".")
+	if labels, ok := strings.CutSuffix(name, ".in-addr.arpa"); ok {
+		octets := strings.Split(labels, ".")
+		if len(octets) > 4 {
+			return netip.Prefix{}, false
+		}
+		var addr [4]byte
+		for i, octet := range octets {
+			value, err := strconv.ParseUint(octet, 10, 8)
+			if err != nil {
+				return netip.Prefix{}, false
+			}
+			addr[len(octets)-1-i] = byte(value)
+		}
+		return netip.PrefixFrom(netip.AddrFrom4(addr), 8*len(octets)), true
+	}
+	if labels, ok := strings.CutSuffix(name, ".ip6.arpa"); ok {
+		nibbles := strings.Split(labels, ".")
+		if len(nibbles) > 32 {
+			return netip.Prefix{}, false
+		}
+		var addr [16]byte
+		for i, nibble := range nibbles {
+			value, err := strconv.ParseUint(nibble, 16, 4)
+			if err != nil || len(nibble) != 1 {
+				return netip.Prefix{}, false
+			}
+			position := len(nibbles) - 1 - i
+			addr[position/2] |= byte(value) << (4 * (1 - position%2))
+		}
+		return netip.PrefixFrom(netip.AddrFrom16(addr), 4*len(nibbles)), true
+	}
+	return netip.Prefix{}, false
+}
+
+// reverseZone returns the reverse zone of the given size holding the first address of network
+func reverseZone(network netip.Prefix, size int) string {
+	zone, err := dns.ReverseAddr(network.Addr().String())
+	if err != nil {
+		return ""
+	}
+	labels := strings.Split(strings.TrimSuffix(zone, "."), ".")
+	// Every label of an IPv4 reverse name stands for 8 bits, every one of an IPv6 reverse name for 4
+	bitsPerLabel := 4
+	if network.Addr().Is4() {
+		bitsPerLabel = 8
+	}
+	suffix := 2 // in-addr.arpa or ip6.arpa
+	return strings.Join(labels[len(labels)-suffix-size/bitsPerLabel:], ".")
+}
+
+// zoneCount returns the number of zones 2^bits renders as, capped for readability
+func zoneCount(bits int) string {
+	const maxBits = 20
+	if bits > maxBits {
+		return fmt.Sprintf("more than %d", 1<<maxBits)
+	}
+	return strconv.Itoa(1 << bits)
+}
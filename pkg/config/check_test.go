@@ -0,0 +1,138 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	base := func() *Config {
+		return &Config{
+			General:   GeneralConfig{LogLevel: "info"},
+			Tailscale: TailscaleConfig{APIKey: "test-api-key", Tailnet: "test.example.com"},
+			Bind: BindConfig{
+				Server:    "dns.example.com",
+				Zone:      "test.example.com",
+				KeyName:   "test-key",
+				KeySecret: "test-secret",
+				PTR: PTRConfig{
+					Enabled:        true,
+					IPv4Zone:       "64.100.in-addr.arpa",
+					IPv4Subnet:     "100.64.0.0/16",
+					IPv4SubnetSize: 16,
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   []Problem
+	}{
+		{
+			name:   "valid",
+			modify: func(c *Config) {},
+		},
+		{
+			name:   "invalid configuration and log level",
+			modify: func(c *Config) { c.Tailscale.APIKey = ""; c.General.LogLevel = "trace" },
+			want: []Problem{
+				{Severity: SeverityError, Message: "either tailscale client_id or api_key must be provided"},
+				{Severity: SeverityError, Key: "general.log_level",
+					Message: `invalid log level "trace", must be debug, verbose or info`},
+			},
+		},
+		{
+			name:   "no subnet",
+			modify: func(c *Config) { c.Bind.PTR.IPv4Subnet = "" },
+			want: []Problem{{Severity: SeverityWarning, Key: "bind.ptr.ipv4_subnet",
+				Message: "no PTR records are published for these addresses without a subnet"}},
+		},
+		{
+			name:   "zone of another subnet",
+			modify: func(c *Config) { c.Bind.PTR.IPv4Zone = "65.100.in-addr.arpa" },
+			want: []Problem{{Severity: SeverityError, Key: "bind.ptr.ipv4_zone",
+				Message: "65.100.in-addr.arpa holds no address of 100.64.0.0/16, PTR records are published in " +
+					"64.100.in-addr.arpa"}},
+		},
+		{
+			name:   "zone of another size",
+			modify: func(c *Config) { c.Bind.PTR.IPv4SubnetSize = 24 },
+			want: []Problem{{Severity: SeverityError, Key: "bind.ptr.ipv4_zone",
+				Message: "64.100.in-addr.arpa is a /16 reverse zone, but PTR records are published in the /24 zones " +
+					"of bind.ptr.ipv4_subnet_size, e.g. 0.64.100.in-addr.arpa"}},
+		},
+		{
+			name:   "not a reverse zone",
+			modify: func(c *Config) { c.Bind.PTR.IPv4Zone = "ptr.example.com" },
+			want: []Problem{{Severity: SeverityError, Key: "bind.ptr.ipv4_zone",
+				Message: "ptr.example.com is not a reverse zone of 100.64.0.0/16"}},
+		},
+		{
+			name:   "subnet spanning several zones",
+			modify: func(c *Config) { c.Bind.PTR.IPv4Subnet = "100.64.0.0/10" },
+			want: []Problem{{Severity: SeverityWarning, Key: "bind.ptr.ipv4_subnet",
+				Message: "100.64.0.0/10 spans 64 reverse zones of /16, the PTR records of addresses outside " +
+					"64.100.in-addr.arpa go to zones that aren't managed"}},
+		},
+		{
+			name: "IPv6 zone",
+			modify: func(c *Config) {
+				c.Bind.PTR.IPv6Enabled = true
+				c.Bind.PTR.IPv6Zone = "0.e.1.a.c.5.1.1.a.7.d.f.ip6.arpa"
+				c.Bind.PTR.IPv6Subnet = "fd7a:115c:a1e0::/48"
+				c.Bind.PTR.IPv6SubnetSize = 48
+			},
+		},
+		{
+			name: "IPv4 zone for IPv6",
+			modify: func(c *Config) {
+				c.Bind.PTR.IPv6Enabled = true
+				c.Bind.PTR.IPv6Zone = "64.100.in-addr.arpa"
+				c.Bind.PTR.IPv6Subnet = "fd7a:115c:a1e0::/48"
+				c.Bind.PTR.IPv6SubnetSize = 48
+			},
+			want: []Problem{{Severity: SeverityError, Key: "bind.ptr.ipv6_zone",
+				Message: "64.100.in-addr.arpa is not a reverse zone of fd7a:115c:a1e0::/48"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := base()
+			tt.modify(c)
+			problems := c.Check("")
+			assert.Equal(t, tt.want, problems)
+			assert.Equal(t, tt.want != nil && tt.want[0].Severity == SeverityError, HasErrors(problems))
+		})
+	}
+
+	// The unknown keys of the configuration file are reported as well
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("tailscale:\n  pol_interval: 30s\n"), 0o600))
+	assert.Equal(t, []Problem{{Severity: SeverityWarning,
+		Message: "unknown key tailscale.pol_interval (line 2) is ignored"}}, base().Check(path))
+}
+
+func TestReverseZonePrefix(t *testing.T) {
+	for zone, want := range map[string]string{
+		"64.100.in-addr.arpa":              "100.64.0.0/16",
+		"0.64.100.in-addr.arpa.":           "100.64.0.0/24",
+		"0.e.1.a.c.5.1.1.a.7.d.f.ip6.arpa": "fd7a:115c:a1e0::/48",
+		"example.com":                      "",
+		"300.100.in-addr.arpa":             "",
+		"ab.ip6.arpa":                      "",
+	} {
+		prefix, ok := reverseZonePrefix(zone)
+		if want == "" {
+			assert.False(t, ok, zone)
+			continue
+		}
+		assert.True(t, ok, zone)
+		assert.Equal(t, want, prefix.String(), zone)
+	}
+}
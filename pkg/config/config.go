@@ -2,7 +2,14 @@ package config
 
 import (
 	"fmt"
+	"maps"
 	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/spf13/viper"
@@ -15,27 +22,268 @@ const (
 	// Default subnet sizes
 	defaultIPv4SubnetSize = 16 // Default to /16 for IPv4
 	defaultIPv6SubnetSize = 64 // Default to /64 for IPv6
+
+	// ProviderBind is the name of the RFC 2136 dynamic update provider
+	ProviderBind = "bind"
+	// ProviderNone publishes records nowhere, for setups where the built-in forwarder serves them
+	ProviderNone = "none"
+	// ProviderPowerDNS publishes records through the HTTP API of a PowerDNS Authoritative server
+	ProviderPowerDNS = "powerdns"
+	// ProviderZoneFile writes the records into zone files for servers that serve zones from files
+	ProviderZoneFile = "zonefile"
+	// ProviderRoute53 publishes records into AWS Route53 hosted zones
+	ProviderRoute53 = "route53"
+
+	// DefaultPowerDNSServerID is the server ID of the PowerDNS API, which is always localhost for a single server
+	DefaultPowerDNSServerID = "localhost"
+
+	// Route53 API defaults. Requests to the global endpoint are always signed for us-east-1, and a single
+	// ChangeResourceRecordSets request holds at most 1000 changes.
+	DefaultRoute53Endpoint  = "https://route53.amazonaws.com"
+	DefaultRoute53Region    = "us-east-1"
+	DefaultRoute53BatchSize = 100
+	MaxRoute53BatchSize     = 1000
+
+	// ZoneFilePlaceholder is replaced by the zone name in the paths of the zonefile provider
+	ZoneFilePlaceholder = "{zone}"
+
+	// Backends devices are discovered with
+	TailscaleModeAPI   = "api"   // Polls the Tailscale API with an API key or OAuth client
+	TailscaleModeTSNet = "tsnet" // Joins the tailnet as a node of its own and reads the peers from its netmap
+	TailscaleModeLocal = "local" // Reads the peers from the LocalAPI of the tailscaled running on the same machine
+
+	// DefaultTSNetHostname is the name the node joining the tailnet in tsnet mode registers with
+	DefaultTSNetHostname = "tailscale-bind-ddns"
+
+	// Heuristics deciding whether a Tailscale device is online
+	OnlineHeuristicLastSeen     = "last_seen"    // Seen by the coordination server within the online threshold
+	OnlineHeuristicConnectivity = "connectivity" // Reports endpoints or a DERP home region
+	OnlineHeuristicAuthorized   = "authorized"   // Authorized to join the tailnet, regardless of whether it is online
+
+	// Selections of the IPv4 addresses published for devices that report several
+	AddressSelectionFirst     = "first"     // The first address the device reports
+	AddressSelectionAll       = "all"       // Every address
+	AddressSelectionTailscale = "tailscale" // Every address in the Tailscale range (100.64.0.0/10)
+	AddressSelectionSubnet    = "subnet"    // The addresses in the first of the preferred subnets that holds any
+
+	// Selections of the subnet routes records are published for
+	RouteSelectionApproved   = "approved"   // Routes approved in the admin console
+	RouteSelectionAdvertised = "advertised" // Every advertised route, also those awaiting approval
+
+	// DefaultRouteNameTemplate names route records after the route, e.g. route-192-168-1-0-24
+	DefaultRouteNameTemplate = "route-{{ .Network }}-{{ .Bits }}"
+
+	// AddressRangesTailscale in address_ranges stands for the ranges Tailscale assigns addresses from, 100.64.0.0/10
+	// and fd7a:115c:a1e0::/48
+	AddressRangesTailscale = "tailscale"
+	// AddressRangesAny in address_ranges accepts every address, including those outside the Tailscale ranges
+	AddressRangesAny = "any"
+
+	// Strategies for devices whose record names collide
+	NameCollisionSuffix = "suffix" // Suffix the names of the other devices with -2, -3, ...
+	NameCollisionID     = "id"     // Suffix the names of the other devices with their short device ID
+	NameCollisionSkip   = "skip"   // Publish no records for the other devices
+	NameCollisionFail   = "fail"   // Publish nothing until the collision is resolved
+
+	// Policies for the records of devices that go offline
+	OfflinePolicyDelete = "delete" // Withdraw the records right away
+	OfflinePolicyKeep   = "keep"   // Keep the records published while the device is in the tailnet
+	OfflinePolicyGrace  = "grace"  // Withdraw the records once the device has been offline for the grace period
+
+	// DefaultOnlineThreshold is how recently a device must have been seen to count as online
+	DefaultOnlineThreshold = 5 * time.Minute
+
+	// DefaultQuarantineTTL is the TTL withdrawn records are republished with while they are quarantined
+	DefaultQuarantineTTL = 30 * time.Second
+
+	// DefaultPluginTimeout is the longest a run of a plugin may take when its timeout isn't set
+	DefaultPluginTimeout = 5 * time.Second
+
+	// Defaults of the backoff between retries of failed updates
+	DefaultRetryInitialBackoff = 10 * time.Second
+	DefaultRetryMaxBackoff     = 5 * time.Minute
+	DefaultRetryJitter         = 0.2
+
+	// DefaultSLOWindow is how far back the objectives of the zone updates are judged
+	DefaultSLOWindow = time.Hour
+
+	// Defaults of the retries of failed Tailscale API requests, which hold up the poll they're part of
+	DefaultAPIRetryAttempts       = 3
+	DefaultAPIRetryInitialBackoff = time.Second
+	DefaultAPIRetryMaxBackoff     = 30 * time.Second
+
+	// Transports dynamic updates and queries can be sent to the DNS server over
+	TransportUDP    = "udp"     // UDP, retried over TCP when a response is truncated
+	TransportTCP    = "tcp"     // Plain TCP
+	TransportTCPTLS = "tcp-tls" // DNS over TLS (RFC 7858)
+
+	// Ways dynamic updates are authenticated
+	AuthTSIG    = "tsig"     // TSIG with a shared secret or key_command
+	AuthGSSTSIG = "gss-tsig" // GSS-TSIG (RFC 3645) with Kerberos credentials
+
+	// DefaultKRB5Conf is the Kerberos configuration GSS-TSIG reads the realms from
+	DefaultKRB5Conf = "/etc/krb5.conf"
+
+	// Orders in which the zones of an update are sent to the DNS server
+	ZoneOrderName         = "name"          // Sorted by zone name
+	ZoneOrderForwardFirst = "forward_first" // Forward zones before reverse (PTR) zones
+	ZoneOrderReverseFirst = "reverse_first" // Reverse (PTR) zones before forward zones
+
+	// Ways of publishing devices exposed through Tailscale Funnel
+	FunnelCNAME = "cname" // A CNAME record pointing at the public ts.net hostname instead of A/AAAA records
+	FunnelSkip  = "skip"  // No records at all
+
+	// DefaultMetadataTemplate renders the metadata TXT record of a machine. The last seen time is truncated to the hour
+	// so that the record isn't rewritten on every poll.
+	DefaultMetadataTemplate = `id={{.ID}} os={{.OS}} tags={{join .Tags ","}} ` +
+		`last_seen={{rfc3339 (truncate .LastSeen "1h")}}`
+
+	// DefaultHistorySize is how many transitions are kept per device by default
+	DefaultHistorySize = 50
+
+	// UnixAddressPrefix marks a listen address as a unix socket path rather than a TCP host:port
+	UnixAddressPrefix = "unix:"
 )
 
+// TailscaleRanges are the ranges Tailscale assigns addresses from, the CGNAT range for IPv4 and its ULA range for IPv6
+var TailscaleRanges = []netip.Prefix{
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("fd7a:115c:a1e0::/48"),
+}
+
 type Config struct {
 	Tailscale TailscaleConfig `mapstructure:"tailscale"`
 	Bind      BindConfig      `mapstructure:"bind"`
+	Providers ProvidersConfig `mapstructure:"providers"`
+	Forwarder ForwarderConfig `mapstructure:"forwarder"`
+	Webhook   WebhookConfig   `mapstructure:"webhook"`
 	General   GeneralConfig   `mapstructure:"general"`
+	IPv6      IPv6Config      `mapstructure:"ipv6"`
+
+	// Targets are additional providers machines are published to next to the main one
+	Targets []TargetConfig `mapstructure:"targets"`
+
+	// Views are named subsets of the machines, each published to zones of its own
+	Views []ViewConfig `mapstructure:"views"`
+
+	// Plugins are WASM modules the desired records of the main provider pass through, in order
+	Plugins []PluginConfig `mapstructure:"plugins"`
 }
 
 // TailscaleConfig holds Tailscale-specific configuration
 type TailscaleConfig struct {
+	// Mode selects how devices are discovered, api, tsnet or local. The API and OAuth credentials are only used in api
+	// mode.
+	Mode  string      `mapstructure:"mode"`
+	TSNet TSNetConfig `mapstructure:"tsnet"`
+	Local LocalConfig `mapstructure:"local"`
+
 	ClientID     string        `mapstructure:"client_id"`
 	ClientSecret string        `mapstructure:"client_secret"`
 	APIKey       string        `mapstructure:"api_key"`
 	Tailnet      string        `mapstructure:"tailnet"`
 	PollInterval time.Duration `mapstructure:"poll_interval"`
+
+	// APIKeyFile and ClientSecretFile hold the API key and OAuth client secret instead of APIKey and ClientSecret, e.g.
+	// a mounted Kubernetes secret. They are re-read every poll interval, so that credentials are rotated without a
+	// restart.
+	APIKeyFile       string `mapstructure:"api_key_file"`
+	ClientSecretFile string `mapstructure:"client_secret_file"`
+
+	// CacheFile is where the machines of the most recent successful poll are kept, so that when Tailscale is
+	// unavailable at startup the records are published from them rather than from nothing
+	CacheFile string `mapstructure:"cache_file"`
+
+	// OnlineHeuristic selects how devices are determined to be online (last_seen, connectivity or authorized) and
+	// OnlineThreshold is how recently a device must have been seen for the last_seen heuristic
+	OnlineHeuristic string        `mapstructure:"online_heuristic"`
+	OnlineThreshold time.Duration `mapstructure:"online_threshold"`
+
+	// OnlinePolls and OfflinePolls are how many consecutive polls must find a device online before it's published and
+	// offline before its records are withdrawn, so that devices on flaky links don't flap
+	OnlinePolls  int `mapstructure:"online_polls"`
+	OfflinePolls int `mapstructure:"offline_polls"`
+
+	// DeviceAttributes enables per-device DNS preferences read from custom posture attributes (custom:dns-name,
+	// custom:dns-ttl). OAuth clients additionally need the devices:posture_attributes:read scope.
+	DeviceAttributes bool `mapstructure:"device_attributes"`
+
+	// Retry is how failed API requests are retried within a poll, waiting out the Retry-After of rate limited ones, and
+	// MaxRequestsPerMinute spaces requests out to stay below the API rate limit, 0 for no limit
+	Retry                RetryConfig `mapstructure:"retry"`
+	MaxRequestsPerMinute int         `mapstructure:"max_requests_per_minute"`
+
+	// IncludeHostnames and ExcludeHostnames are regular expressions matched against device hostnames. When includes
+	// are set only matching devices are published, and devices matching an exclude are never published.
+	IncludeHostnames []string `mapstructure:"include_hostnames"`
+	ExcludeHostnames []string `mapstructure:"exclude_hostnames"`
+
+	// IPv4Addresses selects which IPv4 addresses are published for devices reporting several (first, all, tailscale or
+	// subnet) and IPv4Subnets are the preferred subnets of the subnet selection, in order. AddressPolicies override
+	// the selection for devices carrying one of their tags, the first matching policy wins.
+	IPv4Addresses   string          `mapstructure:"ipv4_addresses"`
+	IPv4Subnets     []string        `mapstructure:"ipv4_subnets"`
+	AddressPolicies []AddressPolicy `mapstructure:"address_policies"`
+
+	// IPv6Addresses and IPv6Subnets select the published IPv6 addresses the same way, where tailscale selects the
+	// addresses in the Tailscale ULA range (fd7a:115c:a1e0::/48). Address policies only apply to IPv4 addresses.
+	IPv6Addresses string   `mapstructure:"ipv6_addresses"`
+	IPv6Subnets   []string `mapstructure:"ipv6_subnets"`
+
+	// AddressRanges are the CIDRs device addresses are accepted from, e.g. the custom IP pool of the tailnet, tailscale
+	// for the Tailscale ranges (the default) or any. Other addresses, such as misreported endpoint addresses, are
+	// never published; empty accepts every address.
+	AddressRanges []string `mapstructure:"address_ranges"`
+
+	// Routes publishes records for the subnet routes of devices
+	Routes RoutesConfig `mapstructure:"routes"`
+}
+
+// RoutesConfig holds the records published for subnet routes: one per route, pointing at the addresses of the devices
+// routing it, so that devices sharing a route for high availability share its record
+type RoutesConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Selection is approved or advertised
+	Selection string `mapstructure:"selection"`
+	// NameTemplate renders the record name of a route, see DefaultRouteNameTemplate
+	NameTemplate string `mapstructure:"name_template"`
+}
+
+// TSNetConfig holds the node that joins the tailnet in tsnet mode
+type TSNetConfig struct {
+	Hostname string `mapstructure:"hostname"`  // Name the node registers with
+	StateDir string `mapstructure:"state_dir"` // Directory the node keeps its keys in, reused across restarts
+	// AuthKey logs the node in the first time, afterwards the state in StateDir is used. Without it the login URL is
+	// logged for interactive login.
+	AuthKey string `mapstructure:"auth_key"`
+}
+
+// LocalConfig holds the tailscaled read in local mode
+type LocalConfig struct {
+	// Socket is the unix socket of the tailscaled LocalAPI, the platform's default socket when empty
+	Socket string `mapstructure:"socket"`
+	// SelfOnly publishes only the machine tailscaled runs on, not its peers, e.g. from a sidecar on every node. It
+	// implies local mode.
+	SelfOnly bool `mapstructure:"self_only"`
+}
+
+// AddressPolicy selects the IPv4 addresses published for the devices carrying one of its tags
+type AddressPolicy struct {
+	Tags      []string `mapstructure:"tags"`
+	Selection string   `mapstructure:"selection"` // first, all, tailscale or subnet
+	Subnets   []string `mapstructure:"subnets"`   // Preferred subnets of the subnet selection, in order
 }
 
 // BindConfig holds Bind DNS server configuration
 type BindConfig struct {
-	Server         string        `mapstructure:"server"`
-	Port           int           `mapstructure:"port"`
+	Server string `mapstructure:"server"`
+	Port   int    `mapstructure:"port"`
+
+	// Servers are tried in order when the servers before them, starting with Server, are unreachable. With
+	// UpdateAllServers updates are sent to every server instead, e.g. for a hidden primary with several masters.
+	Servers          []string `mapstructure:"servers"`
+	UpdateAllServers bool     `mapstructure:"update_all_servers"`
+
 	Zone           string        `mapstructure:"zone"`
 	KeyName        string        `mapstructure:"key_name"`
 	KeySecret      string        `mapstructure:"key_secret"`
@@ -43,10 +291,250 @@ type BindConfig struct {
 	TTL            time.Duration `mapstructure:"ttl"`
 	UpdateInterval time.Duration `mapstructure:"update_interval"`
 
+	// KeySecretFile holds the TSIG secret instead of KeySecret. It is re-read every poll interval and a changed secret
+	// is swapped in once the server accepts it, like with the rotate-key command.
+	KeySecretFile string `mapstructure:"key_secret_file"`
+
+	// Auth selects how updates are authenticated, tsig with the key above or gss-tsig with the Kerberos credentials of
+	// GSS
+	Auth string    `mapstructure:"auth"`
+	GSS  GSSConfig `mapstructure:"gss"`
+
+	// ActiveDirectory adjusts updates for Active Directory integrated DNS, whose secure dynamic updates require
+	// gss-tsig
+	ActiveDirectory ActiveDirectoryConfig `mapstructure:"active_directory"`
+
+	// KeyCommand computes the TSIG MACs instead of KeySecret, run through the shell with the signed bytes on stdin, so
+	// that the secret stays with e.g. a PKCS#11 token
+	KeyCommand string `mapstructure:"key_command"`
+
+	// TTLOverrides sets the TTL of the machines with a hostname or tag (keys starting with tag:), taking precedence
+	// over TTLs requested by tag:ttl-<ttl> tags and device owners, which are clamped to MinTTL and MaxTTL (0 for no
+	// bound)
+	TTLOverrides map[string]time.Duration `mapstructure:"ttl_overrides"`
+	MinTTL       time.Duration            `mapstructure:"min_ttl"`
+	MaxTTL       time.Duration            `mapstructure:"max_ttl"`
+
+	// Update verification. VerifySerial checks that the SOA serial advanced after updates that change records and
+	// StatisticsURL points at the BIND statistics channel (e.g. http://127.0.0.1:8053) used to detect journal errors.
+	VerifySerial  bool   `mapstructure:"verify_serial"`
+	StatisticsURL string `mapstructure:"statistics_url"`
+	// VerifyResolvers are recursive resolvers, host or host:port, that forward/reverse consistency checks go through
+	// instead of the update server, so that they see what clients see
+	VerifyResolvers []string `mapstructure:"verify_resolvers"`
+
+	// RemoveStale deletes records this tool published for machines that went offline or left the tailnet
+	RemoveStale bool `mapstructure:"remove_stale"`
+
+	// RemoveOnShutdown deletes every record this tool published when it shuts down, so that the zones are empty while
+	// it isn't running
+	RemoveOnShutdown bool `mapstructure:"remove_on_shutdown"`
+
+	// MaxRecordAge withdraws records that haven't been refreshed for this long, even with RemoveStale disabled, and
+	// records of machines not seen for this long even when they're still reported online. 0 disables it.
+	MaxRecordAge time.Duration `mapstructure:"max_record_age"`
+
+	// LeaseIntervals leases every published record for this many poll intervals: records that aren't refreshed by a
+	// poll within their lease are withdrawn, whether or not their removal was noticed. 0 disables leases.
+	LeaseIntervals int `mapstructure:"lease_intervals"`
+
+	// Quarantine keeps withdrawn names published for this long before deleting them, with their TTL lowered to
+	// QuarantineTTL and a TXT tombstone, so that accidental removals can be noticed and reverted. 0 disables it.
+	Quarantine    time.Duration `mapstructure:"quarantine"`
+	QuarantineTTL time.Duration `mapstructure:"quarantine_ttl"`
+
+	// OfflinePolicy decides what happens to the records of a device that goes offline (delete, keep or grace), with
+	// grace they are withdrawn once it has been offline for OfflineGracePeriod
+	OfflinePolicy      string        `mapstructure:"offline_policy"`
+	OfflineGracePeriod time.Duration `mapstructure:"offline_grace_period"`
+
+	// Transport is the protocol used to talk to the server (udp, tcp or tcp-tls)
+	Transport string `mapstructure:"transport"`
+
+	// TLS configures the connection when the transport is tcp-tls
+	TLS TLSConfig `mapstructure:"tls"`
+
+	// QueryBeforeUpdate looks up records on the server before sending them and skips those that already hold the
+	// desired value and TTL, so that a restarted process doesn't rewrite records it published before
+	QueryBeforeUpdate bool `mapstructure:"query_before_update"`
+
+	// TTLTolerance is how far the TTL of a record on the server may differ from the desired TTL for the record to still
+	// count as up to date, e.g. when the server caps TTLs
+	TTLTolerance time.Duration `mapstructure:"ttl_tolerance"`
+
+	// StrictRRsetRemoval and UsePrerequisites adjust the shape of update messages for servers other than BIND. The
+	// former only ever deletes the individual records we published instead of whole record sets, the latter makes
+	// updates conditional on the record sets still holding what we last published.
+	StrictRRsetRemoval bool `mapstructure:"strict_rrset_removal"`
+	UsePrerequisites   bool `mapstructure:"use_prerequisites"`
+
+	// ZoneTransfer reads the records of a zone from a single TSIG-signed AXFR/IXFR instead of looking names up one by
+	// one, falling back to lookups for zones the server doesn't permit transferring
+	ZoneTransfer bool `mapstructure:"zone_transfer"`
+
+	// UpdatePolicy mirrors the grant and deny rules of the zones' update-policy in named.conf, e.g. "grant key
+	// subdomain ts.example.com. A AAAA", so that records the key may not update are skipped instead of getting the
+	// whole update refused
+	UpdatePolicy []string `mapstructure:"update_policy"`
+
+	// OwnerID enables the TXT ownership registry: every managed name gets a TXT record naming this owner, and names
+	// owned by someone else or holding records without an owner are never written or deleted. The PowerDNS provider
+	// names it in the ownership comments of its record sets instead.
+	OwnerID string `mapstructure:"owner_id"`
+
+	// ZoneOrder sets which zones are updated first (name, forward_first or reverse_first) and ZoneConcurrency how many
+	// zones of the same group are updated at once. A group only starts once the previous one has finished.
+	ZoneOrder       string `mapstructure:"zone_order"`
+	ZoneConcurrency int    `mapstructure:"zone_concurrency"`
+
+	// Zones are additional forward zones, each with its own TSIG key. A machine is published in the first zone whose
+	// selector matches it and in the main zone when none does.
+	Zones []ZoneConfig `mapstructure:"zones"`
+
+	// QueueFile is where the record set of a failed update is kept until it was published, so that it survives a
+	// restart during a DNS server outage. Failed updates are retried with backoff either way.
+	QueueFile string `mapstructure:"queue_file"`
+
+	// StateFile is where every published record is recorded, so that records of devices that disappeared while the
+	// process was stopped are still removed after a restart
+	StateFile string `mapstructure:"state_file"`
+
+	// Retry is how failed updates are retried
+	Retry RetryConfig `mapstructure:"retry"`
+
+	// SLO holds the objectives the zone updates are tracked against
+	SLO SLOConfig `mapstructure:"slo"`
+
+	// NameTemplate is a Go template rendering the record name of a machine, e.g. "{{ .Name }}-ts" or
+	// "{{ .Name }}.{{ .User }}". Machines are published under their hostname when it's empty.
+	NameTemplate string `mapstructure:"name_template"`
+
+	// NameCollisions decides what happens when several devices get the same record name (suffix, id, skip or fail).
+	// The device with the lowest ID keeps the name.
+	NameCollisions string `mapstructure:"name_collisions"`
+
+	// UserSubdomains publishes machines under a subdomain per owner, e.g. laptop.alice
+	UserSubdomains UserSubdomainsConfig `mapstructure:"user_subdomains"`
+
+	// PublishMetadata publishes a TXT record per machine holding its metadata, rendered with the Go template
+	// MetadataTemplate, so that inventory tooling can query it from DNS
+	PublishMetadata  bool   `mapstructure:"publish_metadata"`
+	MetadataTemplate string `mapstructure:"metadata_template"`
+
+	// Aliases maps additional names to the machine they point at, e.g. nas: storage-box. Every alias is published as a
+	// CNAME record next to the machine's records, in the same zone.
+	Aliases map[string]string `mapstructure:"aliases"`
+
+	// Funnel maps tags of devices exposed through Tailscale Funnel to how they're published (cname or skip), so that
+	// internal names lead to the same place as the public ts.net hostname
+	Funnel map[string]string `mapstructure:"funnel"`
+
 	// PTR record configuration
 	PTR PTRConfig `mapstructure:"ptr"`
 }
 
+// ZoneConfig holds an additional forward zone and the selector deciding which machines are published in it. A machine
+// matches when it carries one of the tags, its hostname matches one of the patterns or it belongs to one of the users.
+type ZoneConfig struct {
+	Name      string `mapstructure:"name"`
+	KeyName   string `mapstructure:"key_name"`
+	KeySecret string `mapstructure:"key_secret"`
+	Algorithm string `mapstructure:"algorithm"` // Defaults to bind.algorithm
+
+	Tags      []string `mapstructure:"tags"`      // ACL tags, e.g. tag:server
+	Hostnames []string `mapstructure:"hostnames"` // Regular expressions matched against the hostname
+	Users     []string `mapstructure:"users"`     // Login names of device owners, e.g. alice@example.com
+}
+
+// UserSubdomainsConfig publishes every machine under a subdomain named after its owner, e.g. laptop.alice for a device
+// of alice@example.com. Devices without an owner, such as tagged devices, are published directly in their zone.
+type UserSubdomainsConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Users maps owners to the label of their subdomain, which is the local part of their login name otherwise
+	Users []UserSubdomainConfig `mapstructure:"users"`
+}
+
+// UserSubdomainConfig maps an owner to the label of their subdomain
+type UserSubdomainConfig struct {
+	User  string `mapstructure:"user"`  // Login name, e.g. alice@example.com
+	Label string `mapstructure:"label"` // e.g. al, or several labels such as alice.eng
+}
+
+// TargetConfig holds an additional provider and zone the machines are published to next to the main zone, e.g. a
+// public zone holding only some machines while the internal zone holds all of them (split horizon). A machine is
+// published to the target when it carries one of the tags, its hostname matches one of the patterns or it belongs to
+// one of the users, every machine is when none are given. Targets get the address, Funnel CNAME and metadata records of
+// their machines, no aliases or PTR records.
+type TargetConfig struct {
+	Name     string `mapstructure:"name"`
+	Provider string `mapstructure:"provider"` // Defaults to general.provider
+	Zone     string `mapstructure:"zone"`
+
+	// Server and TSIG key of bind targets, default to those of the main zone
+	Server    string `mapstructure:"server"`
+	KeyName   string `mapstructure:"key_name"`
+	KeySecret string `mapstructure:"key_secret"`
+	Algorithm string `mapstructure:"algorithm"`
+
+	Tags      []string `mapstructure:"tags"`      // ACL tags, e.g. tag:public
+	Hostnames []string `mapstructure:"hostnames"` // Regular expressions matched against the hostname
+	Users     []string `mapstructure:"users"`     // Login names of device owners, e.g. alice@example.com
+}
+
+// ViewConfig holds a named subset of the tailnet for an audience, e.g. every machine for admins and only the tagged
+// services for users. The machines are picked like those of a target and published to every zone of the view, all
+// from the same poll.
+type ViewConfig struct {
+	Name string `mapstructure:"name"`
+
+	Tags      []string `mapstructure:"tags"`      // ACL tags, e.g. tag:service
+	Hostnames []string `mapstructure:"hostnames"` // Regular expressions matched against the hostname
+	Users     []string `mapstructure:"users"`     // Login names of device owners, e.g. alice@example.com
+
+	Zones []ViewZoneConfig `mapstructure:"zones"`
+}
+
+// ViewZoneConfig holds a zone and provider a view is published to
+type ViewZoneConfig struct {
+	Provider string `mapstructure:"provider"` // Defaults to general.provider
+	Zone     string `mapstructure:"zone"`
+
+	// Server and TSIG key of bind zones, default to those of the main zone
+	Server    string `mapstructure:"server"`
+	KeyName   string `mapstructure:"key_name"`
+	KeySecret string `mapstructure:"key_secret"`
+	Algorithm string `mapstructure:"algorithm"`
+}
+
+// ViewTargets returns a target for every zone of every view, named <view>@<zone>, with the selector of its view
+func (c *Config) ViewTargets() []TargetConfig {
+	var targets []TargetConfig
+	for _, view := range c.Views {
+		for _, zone := range view.Zones {
+			targets = append(targets, TargetConfig{
+				Name:      view.Name + "@" + zone.Zone,
+				Provider:  zone.Provider,
+				Zone:      zone.Zone,
+				Server:    zone.Server,
+				KeyName:   zone.KeyName,
+				KeySecret: zone.KeySecret,
+				Algorithm: zone.Algorithm,
+				Tags:      view.Tags,
+				Hostnames: view.Hostnames,
+				Users:     view.Users,
+			})
+		}
+	}
+	return targets
+}
+
+// PluginConfig holds a WASM module that receives the machines and their desired records and returns the records to
+// publish instead, e.g. to rename records, add records or drop them
+type PluginConfig struct {
+	Path    string        `mapstructure:"path"`
+	Timeout time.Duration `mapstructure:"timeout"` // Longest a run of the module may take, DefaultPluginTimeout when 0
+}
+
 // PTRConfig holds PTR record configuration
 type PTRConfig struct {
 	Enabled        bool   `mapstructure:"enabled"`
@@ -57,21 +545,221 @@ type PTRConfig struct {
 	IPv6Zone       string `mapstructure:"ipv6_zone"`
 	IPv6Subnet     string `mapstructure:"ipv6_subnet"`
 	IPv6SubnetSize int    `mapstructure:"ipv6_subnet_size"` // /32, /48, or /64
+
+	// ConsistencyCheckInterval is how often published forward and reverse records are checked against each other on
+	// the server, 0 disables the check. ConsistencyRepair republishes the missing counterparts of mismatches.
+	ConsistencyCheckInterval time.Duration `mapstructure:"consistency_check_interval"`
+	ConsistencyRepair        bool          `mapstructure:"consistency_repair"`
+
+	// Bootstrap publishes PTR records for every device of the tailnet at startup, including offline ones, at reverse
+	// names that don't hold a PTR record yet
+	Bootstrap bool `mapstructure:"bootstrap"`
+
+	// ShardThreshold is how many PTR records a reverse zone may hold before its records move to the finer reverse
+	// zones of IPv4ShardSize and IPv6ShardSize, 0 disables sharding. The shard sizes default to the next finer subnet
+	// size, the finer zones must exist on the server.
+	ShardThreshold int `mapstructure:"shard_threshold"`
+	IPv4ShardSize  int `mapstructure:"ipv4_shard_size"` // /16 or /24
+	IPv6ShardSize  int `mapstructure:"ipv6_shard_size"` // /48 or /64
+
+	// IPv4Key and IPv6Key sign the updates of the IPv4 and IPv6 reverse zones, including their shards, instead of the
+	// key of the forward zone, for servers that scope every key to a single zone
+	IPv4Key TSIGKeyConfig `mapstructure:"ipv4_key"`
+	IPv6Key TSIGKeyConfig `mapstructure:"ipv6_key"`
+}
+
+// TSIGKeyConfig is a TSIG key of its own for a zone. Without a secret its MACs are computed by bind.key_command.
+type TSIGKeyConfig struct {
+	KeyName   string `mapstructure:"key_name"`
+	KeySecret string `mapstructure:"key_secret"`
+	Algorithm string `mapstructure:"algorithm"` // Defaults to bind.algorithm
+}
+
+// TLSConfig holds the DNS over TLS settings used to reach the server
+type TLSConfig struct {
+	CAFile     string `mapstructure:"ca_file"`     // PEM bundle of CAs trusted instead of the system roots
+	CertFile   string `mapstructure:"cert_file"`   // PEM client certificate for servers that require mutual TLS
+	KeyFile    string `mapstructure:"key_file"`    // PEM private key of the client certificate
+	ServerName string `mapstructure:"server_name"` // Name sent as SNI and verified, defaults to the server address
+}
+
+// GSSConfig holds the Kerberos credentials updates are authenticated with when bind.auth is gss-tsig, e.g. for a
+// krb5-self update-policy or Active Directory integrated DNS
+type GSSConfig struct {
+	// Principal is the client principal, user@REALM, logged in with the keys of Keytab
+	Principal string `mapstructure:"principal"`
+	Keytab    string `mapstructure:"keytab"`
+	// KRB5Conf is the Kerberos configuration the KDCs of the realm are read from
+	KRB5Conf string `mapstructure:"krb5_conf"`
+	// ServicePrincipal is the principal of the DNS server, DNS/<bind.server> by default
+	ServicePrincipal string `mapstructure:"service_principal"`
+}
+
+// ActiveDirectoryConfig holds the settings of Active Directory integrated DNS servers. Their per-record ACLs may refuse
+// some names of an update, which is then retried name by name, and the domain controller updates are sent to is
+// discovered from the SOA record of the domain when bind.server is empty.
+type ActiveDirectoryConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Domain is the Active Directory domain, the realm of the GSS principal by default
+	Domain string `mapstructure:"domain"`
+}
+
+// RetryConfig holds the policy failed updates or requests are retried with. The backoff starts at InitialBackoff and
+// doubles with every failure up to MaxBackoff, each wait randomly shortened or lengthened by up to the Jitter fraction
+// of it so that several instances don't retry in lockstep.
+type RetryConfig struct {
+	// MaxAttempts is how often an update or request is tried before giving up on it, 0 for no limit
+	MaxAttempts    int           `mapstructure:"max_attempts"`
+	InitialBackoff time.Duration `mapstructure:"initial_backoff"`
+	MaxBackoff     time.Duration `mapstructure:"max_backoff"`
+	Jitter         float64       `mapstructure:"jitter"` // Between 0 and 1
+}
+
+// SLOConfig holds the service level objectives of the zone updates, judged over the updates of the rolling Window.
+// Objectives left at 0 aren't tracked.
+type SLOConfig struct {
+	Window time.Duration `mapstructure:"window"`
+	// SuccessRate is the fraction of the zone updates that have to succeed, e.g. 0.99
+	SuccessRate float64 `mapstructure:"success_rate"`
+	// P95Latency is how long 95% of the zone updates may take at most
+	P95Latency time.Duration `mapstructure:"p95_latency"`
+}
+
+// Enabled reports whether any objective is set
+func (s SLOConfig) Enabled() bool {
+	return s.SuccessRate > 0 || s.P95Latency > 0
+}
+
+// ProvidersConfig holds the settings of the providers other than bind. Zones, TTLs, PTR records and the removal of
+// stale records are configured in the bind section for every provider.
+type ProvidersConfig struct {
+	PowerDNS PowerDNSConfig `mapstructure:"powerdns"`
+	ZoneFile ZoneFileConfig `mapstructure:"zonefile"`
+	Route53  Route53Config  `mapstructure:"route53"`
+}
+
+// PowerDNSConfig holds the HTTP API of a PowerDNS Authoritative server, whose zones are updated through it
+type PowerDNSConfig struct {
+	// APIURL is the base URL of the API, e.g. http://127.0.0.1:8081, and APIKey the api-key set in pdns.conf
+	APIURL string `mapstructure:"api_url"`
+	APIKey string `mapstructure:"api_key"`
+	// ServerID is the server the zones belong to, localhost unless the API is served by a proxy for several servers
+	ServerID string `mapstructure:"server_id"`
+	// AdoptUnmarked takes over record sets that lack our ownership comment, e.g. records published by the bind
+	// provider before switching, which are otherwise left alone
+	AdoptUnmarked bool `mapstructure:"adopt_unmarked"`
+}
+
+// ZoneFileConfig holds the zone files written for DNS servers that load zones from files instead of accepting dynamic
+// updates, e.g. CoreDNS with the file plugin or NSD
+type ZoneFileConfig struct {
+	// Path is the file a zone is written to, {zone} is replaced by the zone name and required with several zones
+	Path string `mapstructure:"path"`
+	// FullZone writes complete zones with an SOA record, whose serial is increased with every change, and NS records
+	// for the Nameservers, the first of which is the primary. Otherwise only the records are written, as a fragment for
+	// a zone file maintained by hand to $INCLUDE.
+	FullZone    bool     `mapstructure:"full_zone"`
+	Nameservers []string `mapstructure:"nameservers"`
+	// Hostmaster is the mailbox of the SOA record, hostmaster.<zone> when empty
+	Hostmaster string `mapstructure:"hostmaster"`
+	// SerialFile is the zone file including a fragment, whose SOA serial is increased whenever the fragment changed.
+	// {zone} is replaced like in Path.
+	SerialFile string `mapstructure:"serial_file"`
+	// ReloadCommand is run through the shell after files changed and the process whose ID ReloadPIDFile holds is
+	// sent SIGHUP, both are optional
+	ReloadCommand string `mapstructure:"reload_command"`
+	ReloadPIDFile string `mapstructure:"reload_pid_file"`
+}
+
+// Route53Config holds the AWS Route53 hosted zones records are published in, typically private hosted zones associated
+// with the VPCs whose clients should resolve the tailnet
+type Route53Config struct {
+	// Credentials come from the default AWS chain (environment, shared files, web identity, ECS and EC2 roles) unless
+	// AccessKeyID and SecretAccessKey are given. Profile selects a profile of the shared files and RoleARN a role that
+	// is assumed with the credentials found.
+	AccessKeyID     string `mapstructure:"access_key_id"`
+	SecretAccessKey string `mapstructure:"secret_access_key"`
+	Profile         string `mapstructure:"profile"`
+	RoleARN         string `mapstructure:"role_arn"`
+	// Region requests are signed for, us-east-1 unless the endpoint is in another partition, and Endpoint the URL of
+	// the Route53 API
+	Region   string `mapstructure:"region"`
+	Endpoint string `mapstructure:"endpoint"`
+	// HostedZoneIDs maps zone names to the IDs of their hosted zones, for names with several hosted zones. Other
+	// zones are looked up by name, private hosted zones first.
+	HostedZoneIDs map[string]string `mapstructure:"hosted_zone_ids"`
+	// BatchSize is how many changes are sent with a single ChangeResourceRecordSets request
+	BatchSize int `mapstructure:"batch_size"`
+}
+
+// ForwarderConfig holds the built-in DNS forwarder, which answers queries for the managed zones from the desired
+// records and forwards every other query to the upstream servers
+type ForwarderConfig struct {
+	// Address is the host:port the forwarder listens on over UDP and TCP, the forwarder is disabled when it's empty
+	Address string `mapstructure:"address"`
+	// Upstreams are the servers other queries are forwarded to, tried in order. Without upstreams they are refused.
+	Upstreams []string `mapstructure:"upstreams"`
+}
+
+// WebhookConfig holds the receiver of Tailscale webhook events, which polls straight away when devices are created,
+// deleted or approved
+type WebhookConfig struct {
+	// Address is the host:port webhook requests are received on, the receiver is disabled when it's empty
+	Address string `mapstructure:"address"`
+	// Secret is the webhook secret Tailscale signs requests with, requests with another signature are rejected
+	Secret string `mapstructure:"secret"`
+}
+
+// IPv6Config holds the single switch for everything IPv6: AAAA records, IPv6 PTR records and IPv6 servers
+type IPv6Config struct {
+	// Enabled is nil when unset, which enables IPv6, see Config.IPv6Enabled
+	Enabled *bool `mapstructure:"enabled"`
 }
 
 // GeneralConfig holds general application configuration
 type GeneralConfig struct {
 	LogLevel string `mapstructure:"log_level"`
 	DryRun   bool   `mapstructure:"dry_run"`
+	Provider string `mapstructure:"provider"`
+
+	// StatusAddress is where the running daemon serves its status, either host:port or unix:/path/to/socket
+	StatusAddress string `mapstructure:"status_address"`
+
+	// GRPCAddress is where the running daemon serves its gRPC admin API, either host:port or unix:/path/to/socket.
+	// When GRPCToken is set, callers must present it as a bearer token.
+	GRPCAddress string `mapstructure:"grpc_address"`
+	GRPCToken   string `mapstructure:"grpc_token"`
+
+	// HistorySize is how many online, offline and address transitions are kept per device, 0 disables the history
+	HistorySize int `mapstructure:"history_size"`
 }
 
-// LoadConfig loads configuration from multiple sources
+// LoadConfig loads configuration from multiple sources and validates it
 func LoadConfig() (*Config, error) {
-	viper.SetConfigName("config")
+	config, err := LoadPartialConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate configuration
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("config validation failed: %w", err)
+	}
+
+	return config, nil
+}
+
+// LoadPartialConfig loads configuration from multiple sources without validating it, for commands that only need
+// to inspect whatever configuration is present
+func LoadPartialConfig() (*Config, error) {
+	// Setting the name drops a file set with --config, which is searched for only when none was set
+	if viper.ConfigFileUsed() == "" {
+		viper.SetConfigName("config")
+		viper.AddConfigPath(".")
+		viper.AddConfigPath("./config")
+		viper.AddConfigPath("$HOME/.tailscale-bind-ddns")
+	}
 	viper.SetConfigType("yaml")
-	viper.AddConfigPath(".")
-	viper.AddConfigPath("./config")
-	viper.AddConfigPath("$HOME/.tailscale-bind-ddns")
 
 	// Set default values
 	setDefaults()
@@ -95,10 +783,10 @@ func LoadConfig() (*Config, error) {
 	if err := viper.Unmarshal(&config); err != nil {
 		return nil, fmt.Errorf("unmarshaling config: %w", err)
 	}
-
-	// Validate configuration
-	if err := config.Validate(); err != nil {
-		return nil, fmt.Errorf("config validation failed: %w", err)
+	config.applyIPv6()
+	config.applySelfOnly()
+	if err := config.readSecretFiles(); err != nil {
+		return nil, err
 	}
 
 	return &config, nil
@@ -106,13 +794,56 @@ func LoadConfig() (*Config, error) {
 
 // setDefaults sets default configuration values
 func setDefaults() {
+	viper.SetDefault("tailscale.mode", TailscaleModeAPI)
+	viper.SetDefault("tailscale.tsnet.hostname", DefaultTSNetHostname)
 	viper.SetDefault("tailscale.poll_interval", "30s")
+	viper.SetDefault("tailscale.online_heuristic", OnlineHeuristicLastSeen)
+	viper.SetDefault("tailscale.ipv4_addresses", AddressSelectionFirst)
+	viper.SetDefault("tailscale.ipv6_addresses", AddressSelectionFirst)
+	viper.SetDefault("tailscale.address_ranges", []string{AddressRangesTailscale})
+	viper.SetDefault("tailscale.routes.selection", RouteSelectionApproved)
+	viper.SetDefault("tailscale.routes.name_template", DefaultRouteNameTemplate)
+	viper.SetDefault("tailscale.online_threshold", DefaultOnlineThreshold.String())
+	viper.SetDefault("tailscale.online_polls", 1)
+	viper.SetDefault("tailscale.offline_polls", 1)
+	viper.SetDefault("tailscale.device_attributes", false)
+	viper.SetDefault("tailscale.retry.max_attempts", DefaultAPIRetryAttempts)
+	viper.SetDefault("tailscale.retry.initial_backoff", DefaultAPIRetryInitialBackoff.String())
+	viper.SetDefault("tailscale.retry.max_backoff", DefaultAPIRetryMaxBackoff.String())
+	viper.SetDefault("tailscale.retry.jitter", DefaultRetryJitter)
 	viper.SetDefault("bind.port", dnsStandardPort)
 	viper.SetDefault("bind.algorithm", "hmac-sha256")
 	viper.SetDefault("bind.ttl", "300s")
 	viper.SetDefault("bind.update_interval", "60s")
+	viper.SetDefault("bind.verify_serial", false)
+	viper.SetDefault("bind.remove_stale", true)
+	viper.SetDefault("bind.quarantine_ttl", DefaultQuarantineTTL.String())
+	viper.SetDefault("bind.retry.initial_backoff", DefaultRetryInitialBackoff.String())
+	viper.SetDefault("bind.retry.max_backoff", DefaultRetryMaxBackoff.String())
+	viper.SetDefault("bind.retry.jitter", DefaultRetryJitter)
+	viper.SetDefault("bind.slo.window", DefaultSLOWindow.String())
+	viper.SetDefault("bind.offline_policy", OfflinePolicyDelete)
+	viper.SetDefault("bind.name_collisions", NameCollisionSuffix)
+	viper.SetDefault("bind.query_before_update", false)
+	viper.SetDefault("bind.zone_transfer", false)
+	viper.SetDefault("bind.strict_rrset_removal", false)
+	viper.SetDefault("bind.use_prerequisites", false)
+	viper.SetDefault("bind.update_all_servers", false)
+	viper.SetDefault("bind.publish_metadata", false)
+	viper.SetDefault("bind.metadata_template", DefaultMetadataTemplate)
+	viper.SetDefault("bind.transport", TransportUDP)
+	viper.SetDefault("bind.auth", AuthTSIG)
+	viper.SetDefault("bind.gss.krb5_conf", DefaultKRB5Conf)
+	viper.SetDefault("bind.zone_order", ZoneOrderName)
+	viper.SetDefault("bind.zone_concurrency", 1)
 	viper.SetDefault("general.log_level", "info")
+	viper.SetDefault("general.history_size", DefaultHistorySize)
 	viper.SetDefault("general.dry_run", false)
+	viper.SetDefault("general.provider", ProviderBind)
+	viper.SetDefault("providers.powerdns.server_id", DefaultPowerDNSServerID)
+	viper.SetDefault("providers.route53.endpoint", DefaultRoute53Endpoint)
+	viper.SetDefault("providers.route53.region", DefaultRoute53Region)
+	viper.SetDefault("providers.route53.batch_size", DefaultRoute53BatchSize)
 
 	// PTR record defaults
 	viper.SetDefault("bind.ptr.enabled", false)
@@ -120,6 +851,9 @@ func setDefaults() {
 	viper.SetDefault("bind.ptr.ipv4_subnet_size", defaultIPv4SubnetSize) // Default to /16 for IPv4
 	viper.SetDefault("bind.ptr.ipv6_enabled", false)
 	viper.SetDefault("bind.ptr.ipv6_subnet_size", defaultIPv6SubnetSize) // Default to /64 for IPv6
+	viper.SetDefault("bind.ptr.consistency_check_interval", "0s")
+	viper.SetDefault("bind.ptr.consistency_repair", false)
+	viper.SetDefault("bind.ptr.bootstrap", false)
 }
 
 // bindEnvVars binds environment variables to configuration keys
@@ -134,12 +868,99 @@ func bindEnvVars() {
 	if err := viper.BindEnv("tailscale.api_key", "TSBD_TAILSCALE_API_KEY"); err != nil {
 		klog.Errorf("Failed to bind TSBD_TAILSCALE_API_KEY: %v", err)
 	}
+	if err := viper.BindEnv("tailscale.api_key_file", "TSBD_TAILSCALE_API_KEY_FILE"); err != nil {
+		klog.Errorf("Failed to bind TSBD_TAILSCALE_API_KEY_FILE: %v", err)
+	}
+	if err := viper.BindEnv("tailscale.client_secret_file", "TSBD_TAILSCALE_CLIENT_SECRET_FILE"); err != nil {
+		klog.Errorf("Failed to bind TSBD_TAILSCALE_CLIENT_SECRET_FILE: %v", err)
+	}
 	if err := viper.BindEnv("tailscale.tailnet", "TSBD_TAILSCALE_TAILNET"); err != nil {
 		klog.Errorf("Failed to bind TSBD_TAILSCALE_TAILNET: %v", err)
 	}
 	if err := viper.BindEnv("tailscale.poll_interval", "TSBD_TAILSCALE_POLL_INTERVAL"); err != nil {
 		klog.Errorf("Failed to bind TSBD_TAILSCALE_POLL_INTERVAL: %v", err)
 	}
+	if err := viper.BindEnv("tailscale.online_heuristic", "TSBD_TAILSCALE_ONLINE_HEURISTIC"); err != nil {
+		klog.Errorf("Failed to bind TSBD_TAILSCALE_ONLINE_HEURISTIC: %v", err)
+	}
+	if err := viper.BindEnv("tailscale.online_threshold", "TSBD_TAILSCALE_ONLINE_THRESHOLD"); err != nil {
+		klog.Errorf("Failed to bind TSBD_TAILSCALE_ONLINE_THRESHOLD: %v", err)
+	}
+	if err := viper.BindEnv("tailscale.online_polls", "TSBD_TAILSCALE_ONLINE_POLLS"); err != nil {
+		klog.Errorf("Failed to bind TSBD_TAILSCALE_ONLINE_POLLS: %v", err)
+	}
+	if err := viper.BindEnv("tailscale.offline_polls", "TSBD_TAILSCALE_OFFLINE_POLLS"); err != nil {
+		klog.Errorf("Failed to bind TSBD_TAILSCALE_OFFLINE_POLLS: %v", err)
+	}
+	if err := viper.BindEnv("tailscale.device_attributes", "TSBD_TAILSCALE_DEVICE_ATTRIBUTES"); err != nil {
+		klog.Errorf("Failed to bind TSBD_TAILSCALE_DEVICE_ATTRIBUTES: %v", err)
+	}
+	if err := viper.BindEnv("tailscale.retry.max_attempts", "TSBD_TAILSCALE_RETRY_MAX_ATTEMPTS"); err != nil {
+		klog.Errorf("Failed to bind TSBD_TAILSCALE_RETRY_MAX_ATTEMPTS: %v", err)
+	}
+	if err := viper.BindEnv("tailscale.retry.initial_backoff", "TSBD_TAILSCALE_RETRY_INITIAL_BACKOFF"); err != nil {
+		klog.Errorf("Failed to bind TSBD_TAILSCALE_RETRY_INITIAL_BACKOFF: %v", err)
+	}
+	if err := viper.BindEnv("tailscale.retry.max_backoff", "TSBD_TAILSCALE_RETRY_MAX_BACKOFF"); err != nil {
+		klog.Errorf("Failed to bind TSBD_TAILSCALE_RETRY_MAX_BACKOFF: %v", err)
+	}
+	if err := viper.BindEnv("tailscale.retry.jitter", "TSBD_TAILSCALE_RETRY_JITTER"); err != nil {
+		klog.Errorf("Failed to bind TSBD_TAILSCALE_RETRY_JITTER: %v", err)
+	}
+	if err := viper.BindEnv("tailscale.max_requests_per_minute", "TSBD_TAILSCALE_MAX_REQUESTS_PER_MINUTE"); err != nil {
+		klog.Errorf("Failed to bind TSBD_TAILSCALE_MAX_REQUESTS_PER_MINUTE: %v", err)
+	}
+	if err := viper.BindEnv("tailscale.include_hostnames", "TSBD_TAILSCALE_INCLUDE_HOSTNAMES"); err != nil {
+		klog.Errorf("Failed to bind TSBD_TAILSCALE_INCLUDE_HOSTNAMES: %v", err)
+	}
+	if err := viper.BindEnv("tailscale.exclude_hostnames", "TSBD_TAILSCALE_EXCLUDE_HOSTNAMES"); err != nil {
+		klog.Errorf("Failed to bind TSBD_TAILSCALE_EXCLUDE_HOSTNAMES: %v", err)
+	}
+	if err := viper.BindEnv("tailscale.ipv4_addresses", "TSBD_TAILSCALE_IPV4_ADDRESSES"); err != nil {
+		klog.Errorf("Failed to bind TSBD_TAILSCALE_IPV4_ADDRESSES: %v", err)
+	}
+	if err := viper.BindEnv("tailscale.ipv4_subnets", "TSBD_TAILSCALE_IPV4_SUBNETS"); err != nil {
+		klog.Errorf("Failed to bind TSBD_TAILSCALE_IPV4_SUBNETS: %v", err)
+	}
+	if err := viper.BindEnv("tailscale.ipv6_addresses", "TSBD_TAILSCALE_IPV6_ADDRESSES"); err != nil {
+		klog.Errorf("Failed to bind TSBD_TAILSCALE_IPV6_ADDRESSES: %v", err)
+	}
+	if err := viper.BindEnv("tailscale.ipv6_subnets", "TSBD_TAILSCALE_IPV6_SUBNETS"); err != nil {
+		klog.Errorf("Failed to bind TSBD_TAILSCALE_IPV6_SUBNETS: %v", err)
+	}
+	if err := viper.BindEnv("tailscale.address_ranges", "TSBD_TAILSCALE_ADDRESS_RANGES"); err != nil {
+		klog.Errorf("Failed to bind TSBD_TAILSCALE_ADDRESS_RANGES: %v", err)
+	}
+	if err := viper.BindEnv("tailscale.cache_file", "TSBD_TAILSCALE_CACHE_FILE"); err != nil {
+		klog.Errorf("Failed to bind TSBD_TAILSCALE_CACHE_FILE: %v", err)
+	}
+	if err := viper.BindEnv("tailscale.routes.enabled", "TSBD_TAILSCALE_ROUTES_ENABLED"); err != nil {
+		klog.Errorf("Failed to bind TSBD_TAILSCALE_ROUTES_ENABLED: %v", err)
+	}
+	if err := viper.BindEnv("tailscale.routes.selection", "TSBD_TAILSCALE_ROUTES_SELECTION"); err != nil {
+		klog.Errorf("Failed to bind TSBD_TAILSCALE_ROUTES_SELECTION: %v", err)
+	}
+	if err := viper.BindEnv("tailscale.routes.name_template", "TSBD_TAILSCALE_ROUTES_NAME_TEMPLATE"); err != nil {
+		klog.Errorf("Failed to bind TSBD_TAILSCALE_ROUTES_NAME_TEMPLATE: %v", err)
+	}
+	if err := viper.BindEnv("tailscale.mode", "TSBD_TAILSCALE_MODE"); err != nil {
+		klog.Errorf("Failed to bind TSBD_TAILSCALE_MODE: %v", err)
+	}
+	if err := viper.BindEnv("tailscale.tsnet.hostname", "TSBD_TAILSCALE_TSNET_HOSTNAME"); err != nil {
+		klog.Errorf("Failed to bind TSBD_TAILSCALE_TSNET_HOSTNAME: %v", err)
+	}
+	if err := viper.BindEnv("tailscale.tsnet.state_dir", "TSBD_TAILSCALE_TSNET_STATE_DIR"); err != nil {
+		klog.Errorf("Failed to bind TSBD_TAILSCALE_TSNET_STATE_DIR: %v", err)
+	}
+	if err := viper.BindEnv("tailscale.tsnet.auth_key", "TSBD_TAILSCALE_TSNET_AUTH_KEY"); err != nil {
+		klog.Errorf("Failed to bind TSBD_TAILSCALE_TSNET_AUTH_KEY: %v", err)
+	}
+	if err := viper.BindEnv("tailscale.local.socket", "TSBD_TAILSCALE_LOCAL_SOCKET"); err != nil {
+		klog.Errorf("Failed to bind TSBD_TAILSCALE_LOCAL_SOCKET: %v", err)
+	}
+	if err := viper.BindEnv("tailscale.local.self_only", "TSBD_TAILSCALE_LOCAL_SELF_ONLY"); err != nil {
+		klog.Errorf("Failed to bind TSBD_TAILSCALE_LOCAL_SELF_ONLY: %v", err)
+	}
 
 	// Bind configuration
 	if err := viper.BindEnv("bind.server", "TSBD_BIND_SERVER"); err != nil {
@@ -157,15 +978,171 @@ func bindEnvVars() {
 	if err := viper.BindEnv("bind.key_secret", "TSBD_BIND_KEY_SECRET"); err != nil {
 		klog.Errorf("Failed to bind TSBD_BIND_KEY_SECRET: %v", err)
 	}
+	if err := viper.BindEnv("bind.key_secret_file", "TSBD_BIND_KEY_SECRET_FILE"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_KEY_SECRET_FILE: %v", err)
+	}
 	if err := viper.BindEnv("bind.algorithm", "TSBD_BIND_ALGORITHM"); err != nil {
 		klog.Errorf("Failed to bind TSBD_BIND_ALGORITHM: %v", err)
 	}
+	if err := viper.BindEnv("bind.key_command", "TSBD_BIND_KEY_COMMAND"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_KEY_COMMAND: %v", err)
+	}
+	if err := viper.BindEnv("bind.auth", "TSBD_BIND_AUTH"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_AUTH: %v", err)
+	}
+	if err := viper.BindEnv("bind.gss.principal", "TSBD_BIND_GSS_PRINCIPAL"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_GSS_PRINCIPAL: %v", err)
+	}
+	if err := viper.BindEnv("bind.gss.keytab", "TSBD_BIND_GSS_KEYTAB"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_GSS_KEYTAB: %v", err)
+	}
+	if err := viper.BindEnv("bind.gss.krb5_conf", "TSBD_BIND_GSS_KRB5_CONF"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_GSS_KRB5_CONF: %v", err)
+	}
+	if err := viper.BindEnv("bind.gss.service_principal", "TSBD_BIND_GSS_SERVICE_PRINCIPAL"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_GSS_SERVICE_PRINCIPAL: %v", err)
+	}
+	if err := viper.BindEnv("bind.active_directory.enabled", "TSBD_BIND_ACTIVE_DIRECTORY_ENABLED"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_ACTIVE_DIRECTORY_ENABLED: %v", err)
+	}
+	if err := viper.BindEnv("bind.active_directory.domain", "TSBD_BIND_ACTIVE_DIRECTORY_DOMAIN"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_ACTIVE_DIRECTORY_DOMAIN: %v", err)
+	}
 	if err := viper.BindEnv("bind.ttl", "TSBD_BIND_TTL"); err != nil {
 		klog.Errorf("Failed to bind TSBD_BIND_TTL: %v", err)
 	}
 	if err := viper.BindEnv("bind.update_interval", "TSBD_BIND_UPDATE_INTERVAL"); err != nil {
 		klog.Errorf("Failed to bind TSBD_BIND_UPDATE_INTERVAL: %v", err)
 	}
+	if err := viper.BindEnv("bind.min_ttl", "TSBD_BIND_MIN_TTL"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_MIN_TTL: %v", err)
+	}
+	if err := viper.BindEnv("bind.max_ttl", "TSBD_BIND_MAX_TTL"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_MAX_TTL: %v", err)
+	}
+	if err := viper.BindEnv("bind.verify_serial", "TSBD_BIND_VERIFY_SERIAL"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_VERIFY_SERIAL: %v", err)
+	}
+	if err := viper.BindEnv("bind.statistics_url", "TSBD_BIND_STATISTICS_URL"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_STATISTICS_URL: %v", err)
+	}
+	if err := viper.BindEnv("bind.verify_resolvers", "TSBD_BIND_VERIFY_RESOLVERS"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_VERIFY_RESOLVERS: %v", err)
+	}
+	if err := viper.BindEnv("bind.remove_stale", "TSBD_BIND_REMOVE_STALE"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_REMOVE_STALE: %v", err)
+	}
+	if err := viper.BindEnv("bind.remove_on_shutdown", "TSBD_BIND_REMOVE_ON_SHUTDOWN"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_REMOVE_ON_SHUTDOWN: %v", err)
+	}
+	if err := viper.BindEnv("bind.max_record_age", "TSBD_BIND_MAX_RECORD_AGE"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_MAX_RECORD_AGE: %v", err)
+	}
+	if err := viper.BindEnv("bind.lease_intervals", "TSBD_BIND_LEASE_INTERVALS"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_LEASE_INTERVALS: %v", err)
+	}
+	if err := viper.BindEnv("bind.quarantine", "TSBD_BIND_QUARANTINE"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_QUARANTINE: %v", err)
+	}
+	if err := viper.BindEnv("bind.quarantine_ttl", "TSBD_BIND_QUARANTINE_TTL"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_QUARANTINE_TTL: %v", err)
+	}
+	if err := viper.BindEnv("bind.offline_policy", "TSBD_BIND_OFFLINE_POLICY"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_OFFLINE_POLICY: %v", err)
+	}
+	if err := viper.BindEnv("bind.offline_grace_period", "TSBD_BIND_OFFLINE_GRACE_PERIOD"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_OFFLINE_GRACE_PERIOD: %v", err)
+	}
+	if err := viper.BindEnv("bind.retry.max_attempts", "TSBD_BIND_RETRY_MAX_ATTEMPTS"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_RETRY_MAX_ATTEMPTS: %v", err)
+	}
+	if err := viper.BindEnv("bind.retry.initial_backoff", "TSBD_BIND_RETRY_INITIAL_BACKOFF"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_RETRY_INITIAL_BACKOFF: %v", err)
+	}
+	if err := viper.BindEnv("bind.retry.max_backoff", "TSBD_BIND_RETRY_MAX_BACKOFF"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_RETRY_MAX_BACKOFF: %v", err)
+	}
+	if err := viper.BindEnv("bind.retry.jitter", "TSBD_BIND_RETRY_JITTER"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_RETRY_JITTER: %v", err)
+	}
+	if err := viper.BindEnv("bind.slo.window", "TSBD_BIND_SLO_WINDOW"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_SLO_WINDOW: %v", err)
+	}
+	if err := viper.BindEnv("bind.slo.success_rate", "TSBD_BIND_SLO_SUCCESS_RATE"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_SLO_SUCCESS_RATE: %v", err)
+	}
+	if err := viper.BindEnv("bind.slo.p95_latency", "TSBD_BIND_SLO_P95_LATENCY"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_SLO_P95_LATENCY: %v", err)
+	}
+	if err := viper.BindEnv("bind.transport", "TSBD_BIND_TRANSPORT"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_TRANSPORT: %v", err)
+	}
+	if err := viper.BindEnv("bind.tls.ca_file", "TSBD_BIND_TLS_CA_FILE"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_TLS_CA_FILE: %v", err)
+	}
+	if err := viper.BindEnv("bind.tls.cert_file", "TSBD_BIND_TLS_CERT_FILE"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_TLS_CERT_FILE: %v", err)
+	}
+	if err := viper.BindEnv("bind.tls.key_file", "TSBD_BIND_TLS_KEY_FILE"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_TLS_KEY_FILE: %v", err)
+	}
+	if err := viper.BindEnv("bind.tls.server_name", "TSBD_BIND_TLS_SERVER_NAME"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_TLS_SERVER_NAME: %v", err)
+	}
+	if err := viper.BindEnv("bind.servers", "TSBD_BIND_SERVERS"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_SERVERS: %v", err)
+	}
+	if err := viper.BindEnv("bind.update_all_servers", "TSBD_BIND_UPDATE_ALL_SERVERS"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_UPDATE_ALL_SERVERS: %v", err)
+	}
+	if err := viper.BindEnv("bind.name_template", "TSBD_BIND_NAME_TEMPLATE"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_NAME_TEMPLATE: %v", err)
+	}
+	if err := viper.BindEnv("bind.name_collisions", "TSBD_BIND_NAME_COLLISIONS"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_NAME_COLLISIONS: %v", err)
+	}
+	if err := viper.BindEnv("bind.user_subdomains.enabled", "TSBD_BIND_USER_SUBDOMAINS_ENABLED"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_USER_SUBDOMAINS_ENABLED: %v", err)
+	}
+	if err := viper.BindEnv("bind.publish_metadata", "TSBD_BIND_PUBLISH_METADATA"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_PUBLISH_METADATA: %v", err)
+	}
+	if err := viper.BindEnv("bind.metadata_template", "TSBD_BIND_METADATA_TEMPLATE"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_METADATA_TEMPLATE: %v", err)
+	}
+	if err := viper.BindEnv("bind.queue_file", "TSBD_BIND_QUEUE_FILE"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_QUEUE_FILE: %v", err)
+	}
+	if err := viper.BindEnv("bind.state_file", "TSBD_BIND_STATE_FILE"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_STATE_FILE: %v", err)
+	}
+	if err := viper.BindEnv("bind.query_before_update", "TSBD_BIND_QUERY_BEFORE_UPDATE"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_QUERY_BEFORE_UPDATE: %v", err)
+	}
+	if err := viper.BindEnv("bind.ttl_tolerance", "TSBD_BIND_TTL_TOLERANCE"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_TTL_TOLERANCE: %v", err)
+	}
+	if err := viper.BindEnv("bind.strict_rrset_removal", "TSBD_BIND_STRICT_RRSET_REMOVAL"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_STRICT_RRSET_REMOVAL: %v", err)
+	}
+	if err := viper.BindEnv("bind.use_prerequisites", "TSBD_BIND_USE_PREREQUISITES"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_USE_PREREQUISITES: %v", err)
+	}
+	if err := viper.BindEnv("bind.zone_transfer", "TSBD_BIND_ZONE_TRANSFER"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_ZONE_TRANSFER: %v", err)
+	}
+	if err := viper.BindEnv("bind.update_policy", "TSBD_BIND_UPDATE_POLICY"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_UPDATE_POLICY: %v", err)
+	}
+	if err := viper.BindEnv("bind.owner_id", "TSBD_BIND_OWNER_ID"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_OWNER_ID: %v", err)
+	}
+	if err := viper.BindEnv("bind.zone_order", "TSBD_BIND_ZONE_ORDER"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_ZONE_ORDER: %v", err)
+	}
+	if err := viper.BindEnv("bind.zone_concurrency", "TSBD_BIND_ZONE_CONCURRENCY"); err != nil {
+		klog.Errorf("Failed to bind TSBD_BIND_ZONE_CONCURRENCY: %v", err)
+	}
 
 	// PTR configuration
 	if err := viper.BindEnv("bind.ptr.enabled", "TSBD_PTR_ENABLED"); err != nil {
@@ -192,44 +1169,411 @@ func bindEnvVars() {
 	if err := viper.BindEnv("bind.ptr.ipv6_subnet_size", "TSBD_PTR_IPV6_SUBNET_SIZE"); err != nil {
 		klog.Errorf("Failed to bind TSBD_PTR_IPV6_SUBNET_SIZE: %v", err)
 	}
-
-	// General configuration
-	if err := viper.BindEnv("general.log_level", "TSBD_LOG_LEVEL"); err != nil {
-		klog.Errorf("Failed to bind TSBD_LOG_LEVEL: %v", err)
+	if err := viper.BindEnv("bind.ptr.consistency_check_interval", "TSBD_PTR_CONSISTENCY_CHECK_INTERVAL"); err != nil {
+		klog.Errorf("Failed to bind TSBD_PTR_CONSISTENCY_CHECK_INTERVAL: %v", err)
 	}
-	if err := viper.BindEnv("general.dry_run", "TSBD_DRY_RUN"); err != nil {
-		klog.Errorf("Failed to bind TSBD_DRY_RUN: %v", err)
+	if err := viper.BindEnv("bind.ptr.consistency_repair", "TSBD_PTR_CONSISTENCY_REPAIR"); err != nil {
+		klog.Errorf("Failed to bind TSBD_PTR_CONSISTENCY_REPAIR: %v", err)
 	}
-}
-
-// Validate validates the configuration
-func (c *Config) Validate() error {
-	if c.Tailscale.ClientID == "" && c.Tailscale.APIKey == "" {
-		return fmt.Errorf("either tailscale client_id or api_key must be provided")
+	if err := viper.BindEnv("bind.ptr.bootstrap", "TSBD_PTR_BOOTSTRAP"); err != nil {
+		klog.Errorf("Failed to bind TSBD_PTR_BOOTSTRAP: %v", err)
 	}
-
-	if c.Tailscale.ClientSecret == "" && c.Tailscale.APIKey == "" {
-		return fmt.Errorf("either tailscale client_secret or api_key must be provided")
+	if err := viper.BindEnv("bind.ptr.shard_threshold", "TSBD_PTR_SHARD_THRESHOLD"); err != nil {
+		klog.Errorf("Failed to bind TSBD_PTR_SHARD_THRESHOLD: %v", err)
 	}
-
-	if c.Tailscale.Tailnet == "" {
-		return fmt.Errorf("tailscale tailnet must be provided")
+	if err := viper.BindEnv("bind.ptr.ipv4_shard_size", "TSBD_PTR_IPV4_SHARD_SIZE"); err != nil {
+		klog.Errorf("Failed to bind TSBD_PTR_IPV4_SHARD_SIZE: %v", err)
 	}
-
-	if c.Bind.Server == "" {
-		return fmt.Errorf("bind server must be provided")
+	if err := viper.BindEnv("bind.ptr.ipv6_shard_size", "TSBD_PTR_IPV6_SHARD_SIZE"); err != nil {
+		klog.Errorf("Failed to bind TSBD_PTR_IPV6_SHARD_SIZE: %v", err)
 	}
-
-	if c.Bind.Zone == "" {
-		return fmt.Errorf("bind zone must be provided")
+	if err := viper.BindEnv("bind.ptr.ipv4_key.key_name", "TSBD_PTR_IPV4_KEY_NAME"); err != nil {
+		klog.Errorf("Failed to bind TSBD_PTR_IPV4_KEY_NAME: %v", err)
+	}
+	if err := viper.BindEnv("bind.ptr.ipv4_key.key_secret", "TSBD_PTR_IPV4_KEY_SECRET"); err != nil {
+		klog.Errorf("Failed to bind TSBD_PTR_IPV4_KEY_SECRET: %v", err)
+	}
+	if err := viper.BindEnv("bind.ptr.ipv4_key.algorithm", "TSBD_PTR_IPV4_KEY_ALGORITHM"); err != nil {
+		klog.Errorf("Failed to bind TSBD_PTR_IPV4_KEY_ALGORITHM: %v", err)
+	}
+	if err := viper.BindEnv("bind.ptr.ipv6_key.key_name", "TSBD_PTR_IPV6_KEY_NAME"); err != nil {
+		klog.Errorf("Failed to bind TSBD_PTR_IPV6_KEY_NAME: %v", err)
+	}
+	if err := viper.BindEnv("bind.ptr.ipv6_key.key_secret", "TSBD_PTR_IPV6_KEY_SECRET"); err != nil {
+		klog.Errorf("Failed to bind TSBD_PTR_IPV6_KEY_SECRET: %v", err)
+	}
+	if err := viper.BindEnv("bind.ptr.ipv6_key.algorithm", "TSBD_PTR_IPV6_KEY_ALGORITHM"); err != nil {
+		klog.Errorf("Failed to bind TSBD_PTR_IPV6_KEY_ALGORITHM: %v", err)
+	}
+
+	// Provider configuration
+	if err := viper.BindEnv("providers.powerdns.api_url", "TSBD_POWERDNS_API_URL"); err != nil {
+		klog.Errorf("Failed to bind TSBD_POWERDNS_API_URL: %v", err)
+	}
+	if err := viper.BindEnv("providers.powerdns.api_key", "TSBD_POWERDNS_API_KEY"); err != nil {
+		klog.Errorf("Failed to bind TSBD_POWERDNS_API_KEY: %v", err)
+	}
+	if err := viper.BindEnv("providers.powerdns.server_id", "TSBD_POWERDNS_SERVER_ID"); err != nil {
+		klog.Errorf("Failed to bind TSBD_POWERDNS_SERVER_ID: %v", err)
+	}
+	if err := viper.BindEnv("providers.powerdns.adopt_unmarked", "TSBD_POWERDNS_ADOPT_UNMARKED"); err != nil {
+		klog.Errorf("Failed to bind TSBD_POWERDNS_ADOPT_UNMARKED: %v", err)
+	}
+	if err := viper.BindEnv("providers.zonefile.path", "TSBD_ZONEFILE_PATH"); err != nil {
+		klog.Errorf("Failed to bind TSBD_ZONEFILE_PATH: %v", err)
+	}
+	if err := viper.BindEnv("providers.zonefile.full_zone", "TSBD_ZONEFILE_FULL_ZONE"); err != nil {
+		klog.Errorf("Failed to bind TSBD_ZONEFILE_FULL_ZONE: %v", err)
+	}
+	if err := viper.BindEnv("providers.zonefile.nameservers", "TSBD_ZONEFILE_NAMESERVERS"); err != nil {
+		klog.Errorf("Failed to bind TSBD_ZONEFILE_NAMESERVERS: %v", err)
+	}
+	if err := viper.BindEnv("providers.zonefile.hostmaster", "TSBD_ZONEFILE_HOSTMASTER"); err != nil {
+		klog.Errorf("Failed to bind TSBD_ZONEFILE_HOSTMASTER: %v", err)
+	}
+	if err := viper.BindEnv("providers.zonefile.serial_file", "TSBD_ZONEFILE_SERIAL_FILE"); err != nil {
+		klog.Errorf("Failed to bind TSBD_ZONEFILE_SERIAL_FILE: %v", err)
+	}
+	if err := viper.BindEnv("providers.zonefile.reload_command", "TSBD_ZONEFILE_RELOAD_COMMAND"); err != nil {
+		klog.Errorf("Failed to bind TSBD_ZONEFILE_RELOAD_COMMAND: %v", err)
+	}
+	if err := viper.BindEnv("providers.zonefile.reload_pid_file", "TSBD_ZONEFILE_RELOAD_PID_FILE"); err != nil {
+		klog.Errorf("Failed to bind TSBD_ZONEFILE_RELOAD_PID_FILE: %v", err)
+	}
+	if err := viper.BindEnv("providers.route53.access_key_id", "TSBD_ROUTE53_ACCESS_KEY_ID"); err != nil {
+		klog.Errorf("Failed to bind TSBD_ROUTE53_ACCESS_KEY_ID: %v", err)
+	}
+	if err := viper.BindEnv("providers.route53.secret_access_key", "TSBD_ROUTE53_SECRET_ACCESS_KEY"); err != nil {
+		klog.Errorf("Failed to bind TSBD_ROUTE53_SECRET_ACCESS_KEY: %v", err)
+	}
+	if err := viper.BindEnv("providers.route53.profile", "TSBD_ROUTE53_PROFILE"); err != nil {
+		klog.Errorf("Failed to bind TSBD_ROUTE53_PROFILE: %v", err)
+	}
+	if err := viper.BindEnv("providers.route53.role_arn", "TSBD_ROUTE53_ROLE_ARN"); err != nil {
+		klog.Errorf("Failed to bind TSBD_ROUTE53_ROLE_ARN: %v", err)
+	}
+	if err := viper.BindEnv("providers.route53.region", "TSBD_ROUTE53_REGION"); err != nil {
+		klog.Errorf("Failed to bind TSBD_ROUTE53_REGION: %v", err)
+	}
+	if err := viper.BindEnv("providers.route53.endpoint", "TSBD_ROUTE53_ENDPOINT"); err != nil {
+		klog.Errorf("Failed to bind TSBD_ROUTE53_ENDPOINT: %v", err)
+	}
+	if err := viper.BindEnv("providers.route53.batch_size", "TSBD_ROUTE53_BATCH_SIZE"); err != nil {
+		klog.Errorf("Failed to bind TSBD_ROUTE53_BATCH_SIZE: %v", err)
+	}
+
+	// Forwarder configuration
+	if err := viper.BindEnv("forwarder.address", "TSBD_FORWARDER_ADDRESS"); err != nil {
+		klog.Errorf("Failed to bind TSBD_FORWARDER_ADDRESS: %v", err)
+	}
+	if err := viper.BindEnv("forwarder.upstreams", "TSBD_FORWARDER_UPSTREAMS"); err != nil {
+		klog.Errorf("Failed to bind TSBD_FORWARDER_UPSTREAMS: %v", err)
+	}
+
+	// Webhook configuration
+	if err := viper.BindEnv("webhook.address", "TSBD_WEBHOOK_ADDRESS"); err != nil {
+		klog.Errorf("Failed to bind TSBD_WEBHOOK_ADDRESS: %v", err)
+	}
+	if err := viper.BindEnv("webhook.secret", "TSBD_WEBHOOK_SECRET"); err != nil {
+		klog.Errorf("Failed to bind TSBD_WEBHOOK_SECRET: %v", err)
+	}
+
+	// General configuration
+	if err := viper.BindEnv("general.log_level", "TSBD_LOG_LEVEL"); err != nil {
+		klog.Errorf("Failed to bind TSBD_LOG_LEVEL: %v", err)
+	}
+	if err := viper.BindEnv("ipv6.enabled", "TSBD_IPV6_ENABLED"); err != nil {
+		klog.Errorf("Failed to bind TSBD_IPV6_ENABLED: %v", err)
+	}
+	if err := viper.BindEnv("general.dry_run", "TSBD_DRY_RUN"); err != nil {
+		klog.Errorf("Failed to bind TSBD_DRY_RUN: %v", err)
+	}
+	if err := viper.BindEnv("general.provider", "TSBD_PROVIDER"); err != nil {
+		klog.Errorf("Failed to bind TSBD_PROVIDER: %v", err)
+	}
+	if err := viper.BindEnv("general.status_address", "TSBD_STATUS_ADDRESS"); err != nil {
+		klog.Errorf("Failed to bind TSBD_STATUS_ADDRESS: %v", err)
+	}
+	if err := viper.BindEnv("general.history_size", "TSBD_HISTORY_SIZE"); err != nil {
+		klog.Errorf("Failed to bind TSBD_HISTORY_SIZE: %v", err)
+	}
+	if err := viper.BindEnv("general.grpc_address", "TSBD_GRPC_ADDRESS"); err != nil {
+		klog.Errorf("Failed to bind TSBD_GRPC_ADDRESS: %v", err)
+	}
+	if err := viper.BindEnv("general.grpc_token", "TSBD_GRPC_TOKEN"); err != nil {
+		klog.Errorf("Failed to bind TSBD_GRPC_TOKEN: %v", err)
+	}
+}
+
+// Validate validates the configuration
+func (c *Config) Validate() error {
+	if err := c.Tailscale.validateMode(); err != nil {
+		return err
+	}
+
+	switch c.Tailscale.OnlineHeuristic {
+	case "", OnlineHeuristicLastSeen, OnlineHeuristicConnectivity, OnlineHeuristicAuthorized:
+	default:
+		return fmt.Errorf("tailscale online_heuristic must be one of %s, %s or %s", OnlineHeuristicLastSeen,
+			OnlineHeuristicConnectivity, OnlineHeuristicAuthorized)
+	}
+
+	if c.Tailscale.OnlineThreshold < 0 {
+		return fmt.Errorf("tailscale online_threshold must not be negative")
+	}
+
+	if c.Tailscale.OnlinePolls < 0 || c.Tailscale.OfflinePolls < 0 {
+		return fmt.Errorf("tailscale online_polls and offline_polls must not be negative")
+	}
+
+	if err := c.Tailscale.Retry.validate("tailscale"); err != nil {
+		return err
+	}
+	if c.Tailscale.MaxRequestsPerMinute < 0 {
+		return fmt.Errorf("tailscale max_requests_per_minute must not be negative")
+	}
+
+	for _, pattern := range c.Tailscale.IncludeHostnames {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid tailscale include_hostnames pattern %q: %w", pattern, err)
+		}
+	}
+	for _, pattern := range c.Tailscale.ExcludeHostnames {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid tailscale exclude_hostnames pattern %q: %w", pattern, err)
+		}
+	}
+
+	for _, addressRange := range c.Tailscale.AddressRanges {
+		if addressRange == AddressRangesTailscale || addressRange == AddressRangesAny {
+			continue
+		}
+		if _, err := netip.ParsePrefix(addressRange); err != nil {
+			return fmt.Errorf("invalid tailscale address_ranges entry %q: %w", addressRange, err)
+		}
+	}
+
+	if err := validateAddressSelection("tailscale ipv4_addresses", c.Tailscale.IPv4Addresses,
+		c.Tailscale.IPv4Subnets, false); err != nil {
+		return err
+	}
+	if err := validateSubnetRanges("tailscale ipv4_subnets", c.Tailscale.IPv4Subnets,
+		c.Tailscale.AddressRanges); err != nil {
+		return err
+	}
+	if err := validateAddressSelection("tailscale ipv6_addresses", c.Tailscale.IPv6Addresses,
+		c.Tailscale.IPv6Subnets, true); err != nil {
+		return err
+	}
+	if err := validateSubnetRanges("tailscale ipv6_subnets", c.Tailscale.IPv6Subnets,
+		c.Tailscale.AddressRanges); err != nil {
+		return err
+	}
+	for i, policy := range c.Tailscale.AddressPolicies {
+		if len(policy.Tags) == 0 {
+			return fmt.Errorf("tailscale address_policies[%d] needs at least one tag", i)
+		}
+		if policy.Selection == "" {
+			return fmt.Errorf("tailscale address_policies[%d] selection must be provided", i)
+		}
+		name := fmt.Sprintf("tailscale address_policies[%d] selection", i)
+		if err := validateAddressSelection(name, policy.Selection, policy.Subnets, false); err != nil {
+			return err
+		}
+		name = fmt.Sprintf("tailscale address_policies[%d] subnets", i)
+		if err := validateSubnetRanges(name, policy.Subnets, c.Tailscale.AddressRanges); err != nil {
+			return err
+		}
+	}
+	switch c.Tailscale.Routes.Selection {
+	case "", RouteSelectionApproved, RouteSelectionAdvertised:
+	default:
+		return fmt.Errorf("tailscale routes selection must be %s or %s", RouteSelectionApproved,
+			RouteSelectionAdvertised)
+	}
+
+	if c.UsesBind() {
+		// Active Directory domain controllers are discovered from the SOA record of the domain
+		if c.Bind.Server == "" && !c.Bind.ActiveDirectory.Enabled {
+			return fmt.Errorf("bind server must be provided")
+		}
+
+		if slices.Contains(c.Bind.Servers, "") {
+			return fmt.Errorf("bind servers must not contain empty entries")
+		}
+		if !c.IPv6Enabled() {
+			for _, server := range append([]string{c.Bind.Server}, c.Bind.Servers...) {
+				if IsIPv6Server(server) {
+					return fmt.Errorf("bind server %s is an IPv6 address but ipv6 enabled is false", server)
+				}
+			}
+		}
+
+		if err := c.Bind.validateAuth(); err != nil {
+			return err
+		}
+	}
+
+	if c.Bind.Zone == "" {
+		return fmt.Errorf("bind zone must be provided")
+	}
+
+	switch c.Bind.Transport {
+	case "", TransportUDP, TransportTCP, TransportTCPTLS:
+	default:
+		return fmt.Errorf("bind transport must be one of %s, %s or %s", TransportUDP, TransportTCP, TransportTCPTLS)
+	}
+
+	if strings.ContainsAny(c.Bind.OwnerID, ",\" \t") {
+		return fmt.Errorf("bind owner_id must not contain commas, quotes or whitespace")
+	}
+
+	switch c.Bind.ZoneOrder {
+	case "", ZoneOrderName, ZoneOrderForwardFirst, ZoneOrderReverseFirst:
+	default:
+		return fmt.Errorf("bind zone_order must be one of %s, %s or %s", ZoneOrderName, ZoneOrderForwardFirst,
+			ZoneOrderReverseFirst)
+	}
+
+	if c.Bind.ZoneConcurrency < 0 {
+		return fmt.Errorf("bind zone_concurrency must not be negative")
 	}
 
-	if c.Bind.KeyName == "" {
-		return fmt.Errorf("bind key_name must be provided")
+	if c.Bind.TTLTolerance < 0 {
+		return fmt.Errorf("bind ttl_tolerance must not be negative")
 	}
 
-	if c.Bind.KeySecret == "" {
-		return fmt.Errorf("bind key_secret must be provided")
+	if c.Bind.MaxRecordAge < 0 {
+		return fmt.Errorf("bind max_record_age must not be negative")
+	}
+
+	// A lease of a single interval would expire whenever a poll is late
+	if c.Bind.LeaseIntervals < 0 || c.Bind.LeaseIntervals == 1 {
+		return fmt.Errorf("bind lease_intervals must be 0 or at least 2")
+	}
+
+	if c.Bind.Quarantine < 0 {
+		return fmt.Errorf("bind quarantine must not be negative")
+	}
+	if c.Bind.Quarantine > 0 && (c.Bind.QuarantineTTL < time.Second || c.Bind.QuarantineTTL > c.Bind.Quarantine) {
+		return fmt.Errorf("bind quarantine_ttl must be at least 1s and no longer than the quarantine")
+	}
+
+	switch c.Bind.OfflinePolicy {
+	case "", OfflinePolicyDelete, OfflinePolicyKeep:
+		if c.Bind.OfflineGracePeriod != 0 {
+			return fmt.Errorf("bind offline_grace_period requires offline_policy %s", OfflinePolicyGrace)
+		}
+	case OfflinePolicyGrace:
+		if c.Bind.OfflineGracePeriod <= 0 {
+			return fmt.Errorf("bind offline_policy %s requires a positive offline_grace_period", OfflinePolicyGrace)
+		}
+	default:
+		return fmt.Errorf("bind offline_policy must be one of %s, %s or %s", OfflinePolicyDelete, OfflinePolicyKeep,
+			OfflinePolicyGrace)
+	}
+
+	if err := c.Bind.Retry.validate("bind"); err != nil {
+		return err
+	}
+	if err := c.Bind.SLO.validate(); err != nil {
+		return err
+	}
+
+	if c.General.Provider == ProviderPowerDNS {
+		if err := c.Providers.PowerDNS.validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.General.Provider == ProviderRoute53 {
+		if err := c.Providers.Route53.validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.General.Provider == ProviderZoneFile {
+		if err := c.Providers.ZoneFile.validate(len(c.ManagedZones()) > 1); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Bind.validateZones(c.UsesBind() && !c.Bind.GSSTSIG()); err != nil {
+		return err
+	}
+
+	switch c.Bind.NameCollisions {
+	case "", NameCollisionSuffix, NameCollisionID, NameCollisionSkip, NameCollisionFail:
+	default:
+		return fmt.Errorf("bind name_collisions must be one of %s, %s, %s or %s", NameCollisionSuffix, NameCollisionID,
+			NameCollisionSkip, NameCollisionFail)
+	}
+
+	if err := c.Bind.UserSubdomains.validate(); err != nil {
+		return err
+	}
+
+	if err := c.Bind.validateAliases(); err != nil {
+		return err
+	}
+
+	if err := c.Bind.validateFunnel(); err != nil {
+		return err
+	}
+
+	if err := c.Bind.validateTTLs(); err != nil {
+		return err
+	}
+
+	if (c.Bind.TLS.CertFile == "") != (c.Bind.TLS.KeyFile == "") {
+		return fmt.Errorf("bind tls cert_file and key_file must be provided together")
+	}
+
+	if c.Bind.StatisticsURL != "" {
+		if u, err := url.Parse(c.Bind.StatisticsURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("bind statistics_url must be an http(s) URL, got %q", c.Bind.StatisticsURL)
+		}
+	}
+
+	if slices.Contains(c.Bind.VerifyResolvers, "") {
+		return fmt.Errorf("bind verify_resolvers must not contain empty entries")
+	}
+
+	if err := c.validateTargets(); err != nil {
+		return err
+	}
+
+	for i, plugin := range c.Plugins {
+		if plugin.Path == "" {
+			return fmt.Errorf("plugins[%d] path must be provided", i)
+		}
+		if plugin.Timeout < 0 {
+			return fmt.Errorf("plugin %s timeout must not be negative", plugin.Path)
+		}
+	}
+
+	if err := c.Forwarder.validate(c.General.Provider); err != nil {
+		return err
+	}
+
+	if err := c.Webhook.validate(); err != nil {
+		return err
+	}
+
+	if c.General.HistorySize < 0 {
+		return fmt.Errorf("general history_size must not be negative")
+	}
+
+	if c.General.StatusAddress != "" {
+		if err := ValidateStatusAddress(c.General.StatusAddress); err != nil {
+			return err
+		}
+	}
+
+	if c.General.GRPCAddress != "" {
+		if err := ValidateGRPCAddress(c.General.GRPCAddress); err != nil {
+			return err
+		}
 	}
 
 	// Validate PTR configuration if enabled
@@ -248,8 +1592,18 @@ func (c *Config) Validate() error {
 			return fmt.Errorf("IPv4 subnet size must be 8, 16, or 24")
 		}
 
+		if c.Bind.PTR.ConsistencyCheckInterval < 0 {
+			return fmt.Errorf("PTR consistency check interval must not be negative")
+		}
+		if err := c.Bind.PTR.validateSharding(); err != nil {
+			return err
+		}
+		if err := c.Bind.validatePTRKeys(); err != nil {
+			return err
+		}
+
 		// Validate IPv6 configuration if IPv6 is enabled
-		if c.Bind.PTR.IPv6Enabled {
+		if c.Bind.PTR.IPv6Enabled && c.IPv6Enabled() {
 			if c.Bind.PTR.IPv6Zone == "" {
 				return fmt.Errorf("IPv6 PTR zone must be provided when IPv6 PTR records are enabled")
 			}
@@ -268,3 +1622,656 @@ func (c *Config) Validate() error {
 
 	return nil
 }
+
+// ValidateStatusAddress checks that a status address is either a host:port pair or a unix:/path socket address
+func ValidateStatusAddress(address string) error {
+	return validateListenAddress("status", address)
+}
+
+// ValidateGRPCAddress checks that a gRPC admin address is either a host:port pair or a unix:/path socket address
+func ValidateGRPCAddress(address string) error {
+	return validateListenAddress("gRPC", address)
+}
+
+// validateListenAddress checks that an address is either a host:port pair or a unix:/path socket address
+func validateListenAddress(kind, address string) error {
+	if path, ok := strings.CutPrefix(address, UnixAddressPrefix); ok {
+		if path == "" {
+			return fmt.Errorf("%s address %q is missing a socket path", kind, address)
+		}
+		return nil
+	}
+
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return fmt.Errorf("invalid %s address %q: %w", kind, address, err)
+	}
+	return nil
+}
+
+// Lease returns how long a published record is kept without being refreshed by a poll, 0 when leases are disabled
+func (c *Config) Lease() time.Duration {
+	return time.Duration(c.Bind.LeaseIntervals) * c.Tailscale.PollInterval
+}
+
+// IPv6Enabled reports whether IPv6 records are published and IPv6 servers used, which they are unless ipv6.enabled is
+// false
+func (c *Config) IPv6Enabled() bool {
+	return c.IPv6.Enabled == nil || *c.IPv6.Enabled
+}
+
+// applyIPv6 turns the IPv6 settings off that ipv6.enabled false overrides, so that no part of the application has to
+// check both
+func (c *Config) applyIPv6() {
+	if c.IPv6Enabled() {
+		return
+	}
+	if c.Bind.PTR.IPv6Enabled {
+		klog.Warning("Ignoring bind.ptr.ipv6_enabled, ipv6.enabled is false")
+		c.Bind.PTR.IPv6Enabled = false
+	}
+	if c.Tailscale.IPv6Addresses != "" && c.Tailscale.IPv6Addresses != AddressSelectionFirst {
+		klog.Warning("Ignoring tailscale.ipv6_addresses, ipv6.enabled is false")
+	}
+}
+
+// applySelfOnly switches to local mode when only the local machine is published, which is read from its tailscaled
+func (c *Config) applySelfOnly() {
+	if c.Tailscale.Local.SelfOnly && (c.Tailscale.Mode == "" || c.Tailscale.Mode == TailscaleModeAPI) {
+		c.Tailscale.Mode = TailscaleModeLocal
+	}
+}
+
+// readSecretFiles sets the secrets configured as files, bind.key_secret_file, tailscale.api_key_file and
+// tailscale.client_secret_file, from their contents
+func (c *Config) readSecretFiles() error {
+	for _, secret := range []struct {
+		key, fileKey string
+		value        *string
+		file         string
+	}{
+		{"bind.key_secret", "bind.key_secret_file", &c.Bind.KeySecret, c.Bind.KeySecretFile},
+		{"tailscale.api_key", "tailscale.api_key_file", &c.Tailscale.APIKey, c.Tailscale.APIKeyFile},
+		{"tailscale.client_secret", "tailscale.client_secret_file", &c.Tailscale.ClientSecret,
+			c.Tailscale.ClientSecretFile},
+	} {
+		if secret.file == "" {
+			continue
+		}
+		if *secret.value != "" {
+			return fmt.Errorf("%s and %s can't be used together", secret.key, secret.fileKey)
+		}
+		value, err := ReadSecretFile(secret.file)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", secret.fileKey, err)
+		}
+		*secret.value = value
+	}
+	return nil
+}
+
+// ReadSecretFile returns the secret held by a file, without surrounding whitespace such as a trailing newline
+func ReadSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimSpace(string(data))
+	if secret == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return secret, nil
+}
+
+// IsIPv6Server reports whether a server address, with or without a port, is an IPv6 address
+func IsIPv6Server(server string) bool {
+	host := server
+	if h, _, err := net.SplitHostPort(server); err == nil {
+		host = h
+	}
+	addr, err := netip.ParseAddr(strings.Trim(host, "[]"))
+	return err == nil && addr.Is6() && !addr.Is4In6()
+}
+
+// UsesBind reports whether the configuration publishes records via RFC 2136 dynamic updates to a Bind server
+func (c *Config) UsesBind() bool {
+	return c.General.Provider == "" || c.General.Provider == ProviderBind
+}
+
+// ForTarget returns the configuration the provider of a target is constructed from: this configuration publishing to
+// the target's zone with the target's provider, without additional zones or reverse zones
+func (c *Config) ForTarget(target TargetConfig) *Config {
+	cfg := *c
+	cfg.Targets, cfg.Views = nil, nil
+	if target.Provider != "" {
+		cfg.General.Provider = target.Provider
+	}
+
+	cfg.Bind.Zone = target.Zone
+	cfg.Bind.Zones = nil
+	cfg.Bind.PTR = PTRConfig{}
+	if target.Server != "" {
+		cfg.Bind.Server = target.Server
+		cfg.Bind.Servers = nil
+	}
+	if target.KeyName != "" {
+		cfg.Bind.KeyName = target.KeyName
+		cfg.Bind.KeySecret = target.KeySecret
+	}
+	if target.KeySecret != "" {
+		cfg.Bind.KeyCommand = ""
+	}
+	if target.Algorithm != "" {
+		cfg.Bind.Algorithm = target.Algorithm
+	}
+	// Every target keeps the record set of its failed updates and its published records apart
+	if cfg.Bind.QueueFile != "" {
+		cfg.Bind.QueueFile += "." + target.Name
+	}
+	if cfg.Bind.StateFile != "" {
+		cfg.Bind.StateFile += "." + target.Name
+	}
+	return &cfg
+}
+
+// validateTargets checks that every target and every zone of a view has a unique name, a zone and a provider that
+// can be constructed
+func (c *Config) validateTargets() error {
+	seen := make(map[string]bool, len(c.Targets))
+	for i, target := range c.Targets {
+		if target.Name == "" {
+			return fmt.Errorf("targets[%d] name must be provided", i)
+		}
+		if seen[target.Name] {
+			return fmt.Errorf("target %s is configured more than once", target.Name)
+		}
+		seen[target.Name] = true
+
+		if err := c.validateTarget(target); err != nil {
+			return fmt.Errorf("target %s: %w", target.Name, err)
+		}
+	}
+
+	views := make(map[string]bool, len(c.Views))
+	for i, view := range c.Views {
+		if view.Name == "" {
+			return fmt.Errorf("views[%d] name must be provided", i)
+		}
+		if views[view.Name] {
+			return fmt.Errorf("view %s is configured more than once", view.Name)
+		}
+		views[view.Name] = true
+
+		if len(view.Zones) == 0 {
+			return fmt.Errorf("view %s zones must be provided", view.Name)
+		}
+	}
+	for _, target := range c.ViewTargets() {
+		if seen[target.Name] {
+			return fmt.Errorf("view zone %s is configured more than once", target.Name)
+		}
+		seen[target.Name] = true
+
+		if err := c.validateTarget(target); err != nil {
+			return fmt.Errorf("view zone %s: %w", target.Name, err)
+		}
+	}
+	return nil
+}
+
+// validateTarget checks the zone, selector and provider of a target
+func (c *Config) validateTarget(target TargetConfig) error {
+	if target.Zone == "" {
+		return fmt.Errorf("zone must be provided")
+	}
+	for _, pattern := range target.Hostnames {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid hostnames pattern %q: %w", pattern, err)
+		}
+	}
+
+	cfg := c.ForTarget(target)
+	switch cfg.General.Provider {
+	case "", ProviderBind:
+		keyless := cfg.Bind.KeyName == "" || (cfg.Bind.KeySecret == "" && cfg.Bind.KeyCommand == "")
+		serverless := cfg.Bind.Server == "" && !cfg.Bind.ActiveDirectory.Enabled
+		if serverless || (keyless && !cfg.Bind.GSSTSIG()) {
+			return fmt.Errorf("server, key_name and key_secret must be provided")
+		}
+	case ProviderPowerDNS:
+		return cfg.Providers.PowerDNS.validate()
+	case ProviderRoute53:
+		return cfg.Providers.Route53.validate()
+	case ProviderZoneFile:
+		// The files of the target must not be those of the main zone
+		return cfg.Providers.ZoneFile.validate(true)
+	default:
+		return fmt.Errorf("provider must be one of %s, %s, %s or %s", ProviderBind, ProviderPowerDNS,
+			ProviderRoute53, ProviderZoneFile)
+	}
+	return nil
+}
+
+// ManagedZones returns every zone records are published in: the main zone, the additional zones and the reverse zones
+// when PTR records are enabled
+func (c *Config) ManagedZones() []string {
+	zones := []string{c.Bind.Zone}
+	for _, zone := range c.Bind.Zones {
+		zones = append(zones, zone.Name)
+	}
+	if c.Bind.PTR.Enabled {
+		zones = append(zones, c.Bind.PTR.IPv4Zone)
+		if c.Bind.PTR.IPv6Enabled && c.IPv6Enabled() {
+			zones = append(zones, c.Bind.PTR.IPv6Zone)
+		}
+	}
+	return zones
+}
+
+// validate checks that the retry policy of a section never shrinks the backoff. Backoffs left at 0 take their
+// defaults.
+func (r *RetryConfig) validate(section string) error {
+	if r.MaxAttempts < 0 {
+		return fmt.Errorf("%s retry max_attempts must not be negative", section)
+	}
+	if r.InitialBackoff < 0 || r.MaxBackoff < 0 {
+		return fmt.Errorf("%s retry initial_backoff and max_backoff must not be negative", section)
+	}
+	if r.MaxBackoff > 0 && r.MaxBackoff < r.InitialBackoff {
+		return fmt.Errorf("%s retry max_backoff must not be shorter than initial_backoff", section)
+	}
+	if r.Jitter < 0 || r.Jitter > 1 {
+		return fmt.Errorf("%s retry jitter must be between 0 and 1, got %v", section, r.Jitter)
+	}
+	return nil
+}
+
+// validate checks that the objectives are attainable and judged over a window
+func (s *SLOConfig) validate() error {
+	if s.SuccessRate < 0 || s.SuccessRate > 1 {
+		return fmt.Errorf("bind slo success_rate must be between 0 and 1, got %v", s.SuccessRate)
+	}
+	if s.P95Latency < 0 {
+		return fmt.Errorf("bind slo p95_latency must not be negative")
+	}
+	if s.Enabled() && s.Window <= 0 {
+		return fmt.Errorf("bind slo window must be positive")
+	}
+	return nil
+}
+
+// validate checks that the PowerDNS API can be reached and authenticated against
+func (p *PowerDNSConfig) validate() error {
+	if p.APIURL == "" {
+		return fmt.Errorf("providers powerdns api_url must be provided")
+	}
+	if u, err := url.Parse(p.APIURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("providers powerdns api_url must be an http(s) URL, got %q", p.APIURL)
+	}
+	if p.APIKey == "" {
+		return fmt.Errorf("providers powerdns api_key must be provided")
+	}
+	return nil
+}
+
+// validate checks the credentials and the API endpoint of Route53
+func (r *Route53Config) validate() error {
+	if (r.AccessKeyID == "") != (r.SecretAccessKey == "") {
+		return fmt.Errorf("providers route53 access_key_id and secret_access_key must be provided together")
+	}
+	if r.Endpoint != "" {
+		if u, err := url.Parse(r.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("providers route53 endpoint must be an http(s) URL, got %q", r.Endpoint)
+		}
+	}
+	if r.BatchSize < 0 || r.BatchSize > MaxRoute53BatchSize {
+		return fmt.Errorf("providers route53 batch_size must be between 1 and %d, got %d", MaxRoute53BatchSize,
+			r.BatchSize)
+	}
+	for _, zone := range slices.Sorted(maps.Keys(r.HostedZoneIDs)) {
+		if r.HostedZoneIDs[zone] == "" {
+			return fmt.Errorf("providers route53 hosted_zone_ids %s must not be empty", zone)
+		}
+	}
+	return nil
+}
+
+// validate checks that every zone gets a file of its own and that full zones can be written
+func (z *ZoneFileConfig) validate(severalZones bool) error {
+	if z.Path == "" {
+		return fmt.Errorf("providers zonefile path must be provided")
+	}
+	if severalZones && !strings.Contains(z.Path, ZoneFilePlaceholder) {
+		return fmt.Errorf("providers zonefile path must contain %s when several zones are managed", ZoneFilePlaceholder)
+	}
+	if z.FullZone {
+		if len(z.Nameservers) == 0 {
+			return fmt.Errorf("providers zonefile nameservers must be provided with full_zone")
+		}
+		if z.SerialFile != "" {
+			return fmt.Errorf("providers zonefile serial_file can't be used with full_zone, full zones have a serial")
+		}
+	}
+	return nil
+}
+
+// validate checks the forwarder addresses. The none provider publishes records nowhere else, so it needs the forwarder.
+func (f *ForwarderConfig) validate(provider string) error {
+	if f.Address == "" {
+		if provider == ProviderNone {
+			return fmt.Errorf("provider %s requires a forwarder address", ProviderNone)
+		}
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(f.Address); err != nil {
+		return fmt.Errorf("invalid forwarder address %q: %w", f.Address, err)
+	}
+	for _, upstream := range f.Upstreams {
+		if upstream == "" {
+			return fmt.Errorf("forwarder upstreams must not contain empty entries")
+		}
+	}
+	return nil
+}
+
+// validate checks the webhook receiver address and that requests can be verified
+func (w *WebhookConfig) validate() error {
+	if w.Address == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(w.Address); err != nil {
+		return fmt.Errorf("invalid webhook address %q: %w", w.Address, err)
+	}
+	if w.Secret == "" {
+		return fmt.Errorf("webhook secret must be provided when a webhook address is set")
+	}
+	return nil
+}
+
+// validateMode checks that the discovery backend is known and has what it needs. The API needs credentials and the
+// tailnet, the netmap of a node in the tailnet needs neither and carries no posture attributes.
+func (t *TailscaleConfig) validateMode() error {
+	if t.Local.SelfOnly && t.Mode != TailscaleModeLocal {
+		return fmt.Errorf("tailscale local.self_only requires mode %s", TailscaleModeLocal)
+	}
+	switch t.Mode {
+	case "", TailscaleModeAPI:
+		if t.ClientID == "" && t.APIKey == "" {
+			return fmt.Errorf("either tailscale client_id or api_key must be provided")
+		}
+		if t.ClientSecret == "" && t.APIKey == "" {
+			return fmt.Errorf("either tailscale client_secret or api_key must be provided")
+		}
+		if t.Tailnet == "" {
+			return fmt.Errorf("tailscale tailnet must be provided")
+		}
+	case TailscaleModeTSNet, TailscaleModeLocal:
+		if t.DeviceAttributes {
+			return fmt.Errorf("tailscale device_attributes requires mode %s", TailscaleModeAPI)
+		}
+	default:
+		return fmt.Errorf("tailscale mode must be %s, %s or %s", TailscaleModeAPI, TailscaleModeTSNet,
+			TailscaleModeLocal)
+	}
+	return nil
+}
+
+// GSSTSIG reports whether updates are authenticated with GSS-TSIG rather than a TSIG key
+func (b *BindConfig) GSSTSIG() bool {
+	return b.Auth == AuthGSSTSIG
+}
+
+// validateAuth checks that the TSIG key or the Kerberos credentials of GSS-TSIG are configured. GSS-TSIG security
+// contexts are negotiated with a single server, so the key isn't accepted by other servers.
+func (b *BindConfig) validateAuth() error {
+	switch b.Auth {
+	case "", AuthTSIG:
+		if b.KeyName == "" {
+			return fmt.Errorf("bind key_name must be provided")
+		}
+		if b.KeySecret == "" && b.KeyCommand == "" {
+			return fmt.Errorf("bind key_secret or key_command must be provided")
+		}
+		// The key command holds the key, so there is no secret to rotate when the file changes
+		if b.KeySecretFile != "" && b.KeyCommand != "" {
+			return fmt.Errorf("bind key_secret_file and key_command can't be used together")
+		}
+		if b.KeySecret != "" && b.KeyCommand != "" {
+			return fmt.Errorf("bind key_secret and key_command can't be used together")
+		}
+	case AuthGSSTSIG:
+		if b.GSS.Principal == "" || b.GSS.Keytab == "" {
+			return fmt.Errorf("bind gss principal and keytab must be provided with auth %s", AuthGSSTSIG)
+		}
+		if !strings.Contains(b.GSS.Principal, "@") {
+			return fmt.Errorf("bind gss principal %q must include the realm, e.g. ddns@EXAMPLE.COM", b.GSS.Principal)
+		}
+		if b.KeySecretFile != "" {
+			return fmt.Errorf("bind key_secret_file can't be used with auth %s, its keys are negotiated", AuthGSSTSIG)
+		}
+		if b.KeySecret != "" || b.KeyCommand != "" {
+			return fmt.Errorf("bind key_secret and key_command can't be used with auth %s", AuthGSSTSIG)
+		}
+		if len(b.Servers) > 0 {
+			return fmt.Errorf("bind servers can't be used with auth %s, keys are negotiated with bind.server only",
+				AuthGSSTSIG)
+		}
+	default:
+		return fmt.Errorf("bind auth must be %s or %s", AuthTSIG, AuthGSSTSIG)
+	}
+	if b.ActiveDirectory.Enabled && !b.GSSTSIG() {
+		return fmt.Errorf("bind active_directory requires auth %s, Active Directory only accepts secure updates "+
+			"signed with GSS-TSIG", AuthGSSTSIG)
+	}
+	return nil
+}
+
+// ActiveDirectoryDomain returns the Active Directory domain, the realm of the GSS principal unless set
+func (b *BindConfig) ActiveDirectoryDomain() string {
+	if b.ActiveDirectory.Domain != "" {
+		return b.ActiveDirectory.Domain
+	}
+	at := strings.LastIndex(b.GSS.Principal, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(b.GSS.Principal[at+1:])
+}
+
+// validateZones checks the additional forward zones
+func (b *BindConfig) validateZones(requireKeys bool) error {
+	seen := map[string]bool{strings.ToLower(strings.TrimSuffix(b.Zone, ".")): true}
+	for i, zone := range b.Zones {
+		if zone.Name == "" {
+			return fmt.Errorf("bind zones[%d] name must be provided", i)
+		}
+		name := strings.ToLower(strings.TrimSuffix(zone.Name, "."))
+		if seen[name] {
+			return fmt.Errorf("bind zone %s is configured more than once", zone.Name)
+		}
+		seen[name] = true
+
+		// Zones without a secret of their own have their MACs computed by the key command
+		if requireKeys && (zone.KeyName == "" || (zone.KeySecret == "" && b.KeyCommand == "")) {
+			return fmt.Errorf("bind zone %s key_name and key_secret must be provided", zone.Name)
+		}
+		if len(zone.Tags) == 0 && len(zone.Hostnames) == 0 && len(zone.Users) == 0 {
+			return fmt.Errorf("bind zone %s needs at least one of tags, hostnames or users", zone.Name)
+		}
+		for _, pattern := range zone.Hostnames {
+			if _, err := regexp.Compile(pattern); err != nil {
+				return fmt.Errorf("invalid bind zone %s hostnames pattern %q: %w", zone.Name, pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validate checks the labels mapped to owners
+func (u *UserSubdomainsConfig) validate() error {
+	seen := make(map[string]bool, len(u.Users))
+	for i, user := range u.Users {
+		if user.User == "" {
+			return fmt.Errorf("bind user_subdomains users[%d] user must be provided", i)
+		}
+		if seen[strings.ToLower(user.User)] {
+			return fmt.Errorf("bind user_subdomains user %s is mapped more than once", user.User)
+		}
+		seen[strings.ToLower(user.User)] = true
+		if strings.Trim(user.Label, ". ") == "" {
+			return fmt.Errorf("bind user_subdomains user %s label must be provided", user.User)
+		}
+	}
+	return nil
+}
+
+// validateAliases checks the CNAME aliases
+func (b *BindConfig) validateAliases() error {
+	if len(b.Aliases) > 0 && b.OwnerID != "" {
+		// A CNAME record can't share its name with any other record, including the owner TXT record
+		return fmt.Errorf("bind aliases can't be used together with owner_id")
+	}
+	for _, alias := range slices.Sorted(maps.Keys(b.Aliases)) {
+		if alias == "" || strings.HasSuffix(alias, ".") || strings.ContainsAny(alias, " \t") {
+			return fmt.Errorf("bind alias %q must be a name relative to the zone", alias)
+		}
+		if b.Aliases[alias] == "" {
+			return fmt.Errorf("bind alias %s must name a machine", alias)
+		}
+	}
+	return nil
+}
+
+// validateFunnel checks the publishing modes of Funnel devices
+func (b *BindConfig) validateFunnel() error {
+	for _, tag := range slices.Sorted(maps.Keys(b.Funnel)) {
+		if !strings.HasPrefix(tag, "tag:") || tag == "tag:" {
+			return fmt.Errorf("bind funnel keys must be tags, got %q", tag)
+		}
+		switch mode := b.Funnel[tag]; mode {
+		case FunnelSkip:
+		case FunnelCNAME:
+			if b.OwnerID != "" {
+				// Same as for aliases, the CNAME record can't share its name with the owner TXT record
+				return fmt.Errorf("bind funnel mode %s can't be used together with owner_id", FunnelCNAME)
+			}
+		default:
+			return fmt.Errorf("invalid bind funnel mode %q for %s, must be %s or %s", mode, tag, FunnelCNAME, FunnelSkip)
+		}
+	}
+	return nil
+}
+
+// validateTTLs checks that the TTL range is consistent and that the TTL and the TTL overrides fall within it
+func (b *BindConfig) validateTTLs() error {
+	if b.MinTTL < 0 || b.MaxTTL < 0 {
+		return fmt.Errorf("bind min_ttl and max_ttl must not be negative")
+	}
+	if b.MinTTL > 0 && b.MaxTTL > 0 && b.MinTTL > b.MaxTTL {
+		return fmt.Errorf("bind min_ttl must not be larger than max_ttl")
+	}
+
+	inRange := func(ttl time.Duration) bool {
+		return ttl >= b.MinTTL && (b.MaxTTL == 0 || ttl <= b.MaxTTL)
+	}
+	if b.TTL > 0 && !inRange(b.TTL) {
+		return fmt.Errorf("bind ttl %v is outside the range of min_ttl and max_ttl", b.TTL)
+	}
+	for _, key := range slices.Sorted(maps.Keys(b.TTLOverrides)) {
+		ttl := b.TTLOverrides[key]
+		if key == "" || key == "tag:" {
+			return fmt.Errorf("bind ttl_overrides keys must name a hostname or a tag")
+		}
+		if ttl < time.Second {
+			return fmt.Errorf("bind ttl_overrides %s must be at least 1s, got %v", key, ttl)
+		}
+		if !inRange(ttl) {
+			return fmt.Errorf("bind ttl_overrides %s %v is outside the range of min_ttl and max_ttl", key, ttl)
+		}
+	}
+	return nil
+}
+
+// validatePTRKeys checks the keys of the reverse zones. Keys without a secret are only signed with by the key
+// command.
+func (b *BindConfig) validatePTRKeys() error {
+	for _, key := range []struct {
+		name string
+		TSIGKeyConfig
+	}{{"ipv4_key", b.PTR.IPv4Key}, {"ipv6_key", b.PTR.IPv6Key}} {
+		switch {
+		case key.KeyName == "" && (key.KeySecret != "" || key.Algorithm != ""):
+			return fmt.Errorf("bind ptr %s key_name must be provided", key.name)
+		case key.KeyName != "" && key.KeySecret == "" && b.KeyCommand == "":
+			return fmt.Errorf("bind ptr %s key_secret must be provided", key.name)
+		}
+	}
+	return nil
+}
+
+// validateSharding checks that the shard sizes are finer than the subnet sizes the reverse zones are derived with
+func (p *PTRConfig) validateSharding() error {
+	if p.ShardThreshold < 0 {
+		return fmt.Errorf("PTR shard threshold must not be negative")
+	}
+	if p.IPv4ShardSize != 0 && (p.IPv4ShardSize != 16 && p.IPv4ShardSize != 24 || p.IPv4ShardSize <= p.IPv4SubnetSize) {
+		return fmt.Errorf("IPv4 shard size must be 16 or 24 and finer than the IPv4 subnet size")
+	}
+	if p.IPv6ShardSize != 0 && (p.IPv6ShardSize != 48 && p.IPv6ShardSize != 64 || p.IPv6ShardSize <= p.IPv6SubnetSize) {
+		return fmt.Errorf("IPv6 shard size must be 48 or 64 and finer than the IPv6 subnet size")
+	}
+	return nil
+}
+
+// validateAddressSelection checks an IPv4 or IPv6 address selection and its preferred subnets
+func validateAddressSelection(name, selection string, subnets []string, ipv6 bool) error {
+	switch selection {
+	case "", AddressSelectionFirst, AddressSelectionAll, AddressSelectionTailscale:
+	case AddressSelectionSubnet:
+		if len(subnets) == 0 {
+			return fmt.Errorf("%s %s needs at least one subnet", name, AddressSelectionSubnet)
+		}
+	default:
+		return fmt.Errorf("%s must be one of %s, %s, %s or %s", name, AddressSelectionFirst, AddressSelectionAll,
+			AddressSelectionTailscale, AddressSelectionSubnet)
+	}
+	for _, subnet := range subnets {
+		_, network, err := net.ParseCIDR(subnet)
+		if ipv6 && (err != nil || network.IP.To4() != nil) {
+			return fmt.Errorf("invalid %s subnet %q: must be an IPv6 CIDR", name, subnet)
+		}
+		if !ipv6 && (err != nil || network.IP.To4() == nil) {
+			return fmt.Errorf("invalid %s subnet %q: must be an IPv4 CIDR", name, subnet)
+		}
+	}
+	return nil
+}
+
+// validateSubnetRanges checks that every preferred subnet overlaps tailscale.address_ranges. Addresses outside the
+// ranges are ignored before any selection, so a subnet outside them would never select an address.
+func validateSubnetRanges(name string, subnets, addressRanges []string) error {
+	if len(addressRanges) == 0 || slices.Contains(addressRanges, AddressRangesAny) {
+		return nil
+	}
+	var ranges []netip.Prefix
+	for _, addressRange := range addressRanges {
+		if addressRange == AddressRangesTailscale {
+			ranges = append(ranges, TailscaleRanges...)
+			continue
+		}
+		// Validated along with the other address_ranges entries
+		if prefix, err := netip.ParsePrefix(addressRange); err == nil {
+			ranges = append(ranges, prefix)
+		}
+	}
+	for _, subnet := range subnets {
+		prefix, err := netip.ParsePrefix(subnet)
+		if err != nil {
+			continue
+		}
+		if !slices.ContainsFunc(ranges, prefix.Overlaps) {
+			return fmt.Errorf("%s entry %q lies outside tailscale address_ranges, none of its addresses would be "+
+				"selected", name, subnet)
+		}
+	}
+	return nil
+}
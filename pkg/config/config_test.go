@@ -1,6 +1,8 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -131,6 +133,37 @@ func TestLoadConfig(t *testing.T) {
 	}
 }
 
+func TestLoadConfigIPv6Disabled(t *testing.T) {
+	viper.Reset()
+	setDefaults()
+	viper.Set("tailscale.api_key", "test-api-key")
+	viper.Set("tailscale.tailnet", "test.example.com")
+	viper.Set("bind.server", "dns.example.com")
+	viper.Set("bind.zone", "test.example.com")
+	viper.Set("bind.key_name", "test-key")
+	viper.Set("bind.key_secret", "test-secret")
+	viper.Set("bind.ptr.ipv6_enabled", true)
+	viper.Set("ipv6.enabled", "false")
+
+	// The IPv6 PTR settings aren't validated, ipv6.enabled turns them off
+	config, err := LoadConfig()
+	require.NoError(t, err)
+	assert.False(t, config.IPv6Enabled())
+	assert.False(t, config.Bind.PTR.IPv6Enabled)
+
+	config.IPv6.Enabled = nil
+	assert.True(t, config.IPv6Enabled())
+}
+
+func TestIsIPv6Server(t *testing.T) {
+	assert.True(t, IsIPv6Server("fd00::53"))
+	assert.True(t, IsIPv6Server("[fd00::53]:5353"))
+	assert.False(t, IsIPv6Server("192.0.2.53"))
+	assert.False(t, IsIPv6Server("192.0.2.53:53"))
+	assert.False(t, IsIPv6Server("::ffff:192.0.2.53"))
+	assert.False(t, IsIPv6Server("dns.example.com:53"))
+}
+
 func TestConfigValidate(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -138,7 +171,1697 @@ func TestConfigValidate(t *testing.T) {
 		wantErr bool
 	}{
 		{
-			name: "valid config with API key",
+			name: "valid config with API key",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid config with OAuth",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					ClientID:     "test-client-id",
+					ClientSecret: "test-client-secret",
+					Tailnet:      "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid config with key command",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:     "dns.example.com",
+					Zone:       "test.example.com",
+					KeyName:    "test-key",
+					KeyCommand: "tsig-sign",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "key command together with key secret",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:     "dns.example.com",
+					Zone:       "test.example.com",
+					KeyName:    "test-key",
+					KeySecret:  "test-secret",
+					KeyCommand: "tsig-sign",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid config with GSS-TSIG",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server: "dns.example.com",
+					Zone:   "test.example.com",
+					Auth:   AuthGSSTSIG,
+					GSS:    GSSConfig{Principal: "ddns@EXAMPLE.COM", Keytab: "/etc/ddns.keytab"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "GSS-TSIG without keytab",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server: "dns.example.com",
+					Zone:   "test.example.com",
+					Auth:   AuthGSSTSIG,
+					GSS:    GSSConfig{Principal: "ddns@EXAMPLE.COM"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "GSS-TSIG principal without realm",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server: "dns.example.com",
+					Zone:   "test.example.com",
+					Auth:   AuthGSSTSIG,
+					GSS:    GSSConfig{Principal: "ddns", Keytab: "/etc/ddns.keytab"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "GSS-TSIG together with key secret",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					Auth:      AuthGSSTSIG,
+					KeySecret: "test-secret",
+					GSS:       GSSConfig{Principal: "ddns@EXAMPLE.COM", Keytab: "/etc/ddns.keytab"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "GSS-TSIG with fallback servers",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:  "dns.example.com",
+					Zone:    "test.example.com",
+					Auth:    AuthGSSTSIG,
+					Servers: []string{"dns2.example.com"},
+					GSS:     GSSConfig{Principal: "ddns@EXAMPLE.COM", Keytab: "/etc/ddns.keytab"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Active Directory without server",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Zone:            "test.example.com",
+					Auth:            AuthGSSTSIG,
+					GSS:             GSSConfig{Principal: "ddns@EXAMPLE.COM", Keytab: "/etc/ddns.keytab"},
+					ActiveDirectory: ActiveDirectoryConfig{Enabled: true},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Active Directory with TSIG",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:          "dns.example.com",
+					Zone:            "test.example.com",
+					KeyName:         "test-key",
+					KeySecret:       "test-secret",
+					ActiveDirectory: ActiveDirectoryConfig{Enabled: true},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "PTR key without secret",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+					PTR: PTRConfig{
+						Enabled:        true,
+						IPv4Zone:       "64.100.in-addr.arpa",
+						IPv4SubnetSize: 16,
+						IPv4Key:        TSIGKeyConfig{KeyName: "ptr-key"},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "PTR key signed by the key command",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:     "dns.example.com",
+					Zone:       "test.example.com",
+					KeyName:    "test-key",
+					KeyCommand: "tsig-sign",
+					PTR: PTRConfig{
+						Enabled:        true,
+						IPv4Zone:       "64.100.in-addr.arpa",
+						IPv4SubnetSize: 16,
+						IPv4Key:        TSIGKeyConfig{KeyName: "ptr-key"},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "key secret file together with key command",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:        "dns.example.com",
+					Zone:          "test.example.com",
+					KeyName:       "test-key",
+					KeyCommand:    "tsig-sign",
+					KeySecretFile: "/run/secrets/tsig-secret",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "key secret file with GSS-TSIG",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:        "dns.example.com",
+					Zone:          "test.example.com",
+					Auth:          AuthGSSTSIG,
+					KeySecretFile: "/run/secrets/tsig-secret",
+					GSS:           GSSConfig{Principal: "ddns@EXAMPLE.COM", Keytab: "/etc/ddns.keytab"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown auth",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+					Auth:      "kerberos",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing tailscale credentials",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing tailnet",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey: "test-api-key",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing bind server",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing bind zone",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing bind key name",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeySecret: "test-secret",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing bind key secret",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:  "dns.example.com",
+					Zone:    "test.example.com",
+					KeyName: "test-key",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "non-bind provider does not require bind credentials",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Zone: "test.example.com",
+				},
+				General: GeneralConfig{
+					Provider: "other",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "none provider with forwarder",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Zone: "test.example.com",
+				},
+				Forwarder: ForwarderConfig{
+					Address:   "127.0.0.1:5353",
+					Upstreams: []string{"1.1.1.1"},
+				},
+				General: GeneralConfig{
+					Provider: ProviderNone,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "none provider without forwarder",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Zone: "test.example.com",
+				},
+				General: GeneralConfig{
+					Provider: ProviderNone,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "powerdns provider with API URL and key",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Zone:  "test.example.com",
+					Zones: []ZoneConfig{{Name: "servers.example.com", Tags: []string{"tag:server"}}},
+				},
+				Providers: ProvidersConfig{
+					PowerDNS: PowerDNSConfig{APIURL: "http://127.0.0.1:8081", APIKey: "secret"},
+				},
+				General: GeneralConfig{
+					Provider: ProviderPowerDNS,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "powerdns provider without API key",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Zone: "test.example.com",
+				},
+				Providers: ProvidersConfig{
+					PowerDNS: PowerDNSConfig{APIURL: "http://127.0.0.1:8081"},
+				},
+				General: GeneralConfig{
+					Provider: ProviderPowerDNS,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "powerdns provider with invalid API URL",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Zone: "test.example.com",
+				},
+				Providers: ProvidersConfig{
+					PowerDNS: PowerDNSConfig{APIURL: "127.0.0.1:8081", APIKey: "secret"},
+				},
+				General: GeneralConfig{
+					Provider: ProviderPowerDNS,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "route53 provider with the default credentials chain",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Zone: "test.example.com",
+				},
+				Providers: ProvidersConfig{
+					Route53: Route53Config{BatchSize: DefaultRoute53BatchSize},
+				},
+				General: GeneralConfig{
+					Provider: ProviderRoute53,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "route53 provider with an access key but no secret",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Zone: "test.example.com",
+				},
+				Providers: ProvidersConfig{
+					Route53: Route53Config{AccessKeyID: "AKIAEXAMPLE"},
+				},
+				General: GeneralConfig{
+					Provider: ProviderRoute53,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "route53 provider with too large batches",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Zone: "test.example.com",
+				},
+				Providers: ProvidersConfig{
+					Route53: Route53Config{BatchSize: MaxRoute53BatchSize + 1},
+				},
+				General: GeneralConfig{
+					Provider: ProviderRoute53,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "zonefile provider writing a zone per file",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Zone:  "test.example.com",
+					Zones: []ZoneConfig{{Name: "servers.example.com", Tags: []string{"tag:server"}}},
+				},
+				Providers: ProvidersConfig{
+					ZoneFile: ZoneFileConfig{Path: "/etc/coredns/{zone}.zone", FullZone: true, Nameservers: []string{"ns1.example.com"}},
+				},
+				General: GeneralConfig{
+					Provider: ProviderZoneFile,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "zonefile provider writing several zones to one file",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Zone:  "test.example.com",
+					Zones: []ZoneConfig{{Name: "servers.example.com", Tags: []string{"tag:server"}}},
+				},
+				Providers: ProvidersConfig{
+					ZoneFile: ZoneFileConfig{Path: "/etc/coredns/tailnet.zone"},
+				},
+				General: GeneralConfig{
+					Provider: ProviderZoneFile,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "zonefile provider with full zones without nameservers",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Zone: "test.example.com",
+				},
+				Providers: ProvidersConfig{
+					ZoneFile: ZoneFileConfig{Path: "/etc/coredns/tailnet.zone", FullZone: true},
+				},
+				General: GeneralConfig{
+					Provider: ProviderZoneFile,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "zonefile provider with a serial file for full zones",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Zone: "test.example.com",
+				},
+				Providers: ProvidersConfig{
+					ZoneFile: ZoneFileConfig{
+						Path:        "/etc/nsd/tailnet.zone",
+						FullZone:    true,
+						Nameservers: []string{"ns1.example.com"},
+						SerialFile:  "/etc/nsd/example.com.zone",
+					},
+				},
+				General: GeneralConfig{
+					Provider: ProviderZoneFile,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid forwarder address",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Zone: "test.example.com",
+				},
+				Forwarder: ForwarderConfig{
+					Address: "127.0.0.1",
+				},
+				General: GeneralConfig{
+					Provider: ProviderNone,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "tsnet mode without credentials",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					Mode:  TailscaleModeTSNet,
+					TSNet: TSNetConfig{Hostname: DefaultTSNetHostname},
+				},
+				Bind: BindConfig{
+					Server:    "127.0.0.1",
+					Port:      53,
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+					Algorithm: "hmac-sha256",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "local mode without credentials",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					Mode: TailscaleModeLocal,
+				},
+				Bind: BindConfig{
+					Server:    "127.0.0.1",
+					Port:      53,
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+					Algorithm: "hmac-sha256",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "self only in tsnet mode",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					Mode:  TailscaleModeTSNet,
+					Local: LocalConfig{SelfOnly: true},
+				},
+				Bind: BindConfig{
+					Server:    "127.0.0.1",
+					Port:      53,
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+					Algorithm: "hmac-sha256",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "tsnet mode with device attributes",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					Mode:             TailscaleModeTSNet,
+					DeviceAttributes: true,
+				},
+				Bind: BindConfig{
+					Server: "127.0.0.1",
+					Port:   53,
+					Zone:   "test.example.com",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown tailscale mode",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					Mode:    "netmap",
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server: "127.0.0.1",
+					Port:   53,
+					Zone:   "test.example.com",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "webhook without secret",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server: "127.0.0.1",
+					Port:   53,
+					Zone:   "test.example.com",
+				},
+				Webhook: WebhookConfig{
+					Address: ":8443",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid statistics URL",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:        "dns.example.com",
+					Zone:          "test.example.com",
+					KeyName:       "test-key",
+					KeySecret:     "test-secret",
+					StatisticsURL: "127.0.0.1:8053",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "empty verify resolver",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:          "dns.example.com",
+					Zone:            "test.example.com",
+					KeyName:         "test-key",
+					KeySecret:       "test-secret",
+					VerifyResolvers: []string{"100.100.100.100", ""},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid online heuristic",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:          "test-api-key",
+					Tailnet:         "test.example.com",
+					OnlineHeuristic: "pinged",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid hostname filter pattern",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:           "test-api-key",
+					Tailnet:          "test.example.com",
+					ExcludeHostnames: []string{"build-(["},
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "owner ID with comma",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+					OwnerID:   "office,lab",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown IPv4 address selection",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:        "test-api-key",
+					Tailnet:       "test.example.com",
+					IPv4Addresses: "some",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "subnet IPv4 address selection without subnets",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:        "test-api-key",
+					Tailnet:       "test.example.com",
+					IPv4Addresses: AddressSelectionSubnet,
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "address policy without tags",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:          "test-api-key",
+					Tailnet:         "test.example.com",
+					AddressPolicies: []AddressPolicy{{Selection: AddressSelectionAll}},
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "address policy with IPv6 subnet",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+					AddressPolicies: []AddressPolicy{
+						{Tags: []string{"tag:router"}, Selection: AddressSelectionSubnet, Subnets: []string{"fd00::/8"}},
+					},
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "IPv6 subnet selection",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:        "test-api-key",
+					Tailnet:       "test.example.com",
+					IPv6Addresses: AddressSelectionSubnet,
+					IPv6Subnets:   []string{"2001:db8::/32"},
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "IPv6 subnet selection with IPv4 subnet",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:        "test-api-key",
+					Tailnet:       "test.example.com",
+					IPv6Addresses: AddressSelectionSubnet,
+					IPv6Subnets:   []string{"10.0.0.0/8"},
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "address ranges",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:        "test-api-key",
+					Tailnet:       "test.example.com",
+					AddressRanges: []string{AddressRangesTailscale, AddressRangesAny, "100.100.0.0/16"},
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "IPv4 subnet inside address ranges",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:        "test-api-key",
+					Tailnet:       "test.example.com",
+					IPv4Addresses: AddressSelectionSubnet,
+					IPv4Subnets:   []string{"100.100.0.0/16", "10.0.0.0/8"},
+					AddressRanges: []string{AddressRangesTailscale, "10.1.0.0/16"},
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "IPv4 subnet outside address ranges",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:        "test-api-key",
+					Tailnet:       "test.example.com",
+					IPv4Addresses: AddressSelectionSubnet,
+					IPv4Subnets:   []string{"192.168.0.0/16"},
+					AddressRanges: []string{AddressRangesTailscale},
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "IPv6 subnet outside address ranges",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:        "test-api-key",
+					Tailnet:       "test.example.com",
+					IPv6Addresses: AddressSelectionSubnet,
+					IPv6Subnets:   []string{"2001:db8::/32"},
+					AddressRanges: []string{AddressRangesTailscale},
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "address policy subnet outside address ranges",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+					AddressPolicies: []AddressPolicy{
+						{Tags: []string{"tag:lan"}, Selection: AddressSelectionSubnet, Subnets: []string{"192.168.0.0/16"}},
+					},
+					AddressRanges: []string{AddressRangesTailscale},
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "subnet outside address ranges accepting any address",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:        "test-api-key",
+					Tailnet:       "test.example.com",
+					IPv4Addresses: AddressSelectionSubnet,
+					IPv4Subnets:   []string{"192.168.0.0/16"},
+					AddressRanges: []string{AddressRangesTailscale, AddressRangesAny},
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid address range",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:        "test-api-key",
+					Tailnet:       "test.example.com",
+					AddressRanges: []string{"100.100.0.0"},
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown route selection",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+					Routes:  RoutesConfig{Enabled: true, Selection: "primary"},
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "PTR shard size not finer than the subnet size",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+					PTR: PTRConfig{
+						Enabled:        true,
+						IPv4Zone:       "64.100.in-addr.arpa",
+						IPv4Subnet:     "100.64.0.0/10",
+						IPv4SubnetSize: 24,
+						ShardThreshold: 1000,
+						IPv4ShardSize:  16,
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "IPv6 server with IPv6 disabled",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Servers:   []string{"[fd00::53]:53"},
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+				},
+				IPv6: IPv6Config{Enabled: new(bool)},
+			},
+			wantErr: true,
+		},
+		{
+			name: "aliases with owner ID",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+					OwnerID:   "office",
+					Aliases:   map[string]string{"nas": "storage-box"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "fully qualified alias",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+					Aliases:   map[string]string{"nas.test.example.com.": "storage-box"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "funnel modes",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+					Funnel:    map[string]string{"tag:web": FunnelCNAME, "tag:public": FunnelSkip},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "funnel key not a tag",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+					Funnel:    map[string]string{"web": FunnelCNAME},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid funnel mode",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+					Funnel:    map[string]string{"tag:web": "redirect"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "funnel CNAME with owner ID",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+					OwnerID:   "office",
+					Funnel:    map[string]string{"tag:web": FunnelCNAME},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "quarantine",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:        "dns.example.com",
+					Zone:          "test.example.com",
+					KeyName:       "test-key",
+					KeySecret:     "test-secret",
+					Quarantine:    time.Hour,
+					QuarantineTTL: 30 * time.Second,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "quarantine TTL longer than the quarantine",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:        "dns.example.com",
+					Zone:          "test.example.com",
+					KeyName:       "test-key",
+					KeySecret:     "test-secret",
+					Quarantine:    time.Minute,
+					QuarantineTTL: time.Hour,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "quarantine without TTL",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:     "dns.example.com",
+					Zone:       "test.example.com",
+					KeyName:    "test-key",
+					KeySecret:  "test-secret",
+					Quarantine: time.Hour,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "lease",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:         "dns.example.com",
+					Zone:           "test.example.com",
+					KeyName:        "test-key",
+					KeySecret:      "test-secret",
+					LeaseIntervals: 3,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "lease of a single interval",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:         "dns.example.com",
+					Zone:           "test.example.com",
+					KeyName:        "test-key",
+					KeySecret:      "test-secret",
+					LeaseIntervals: 1,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "retry policy",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+					Retry: RetryConfig{
+						MaxAttempts:    5,
+						InitialBackoff: time.Second,
+						MaxBackoff:     time.Minute,
+						Jitter:         0.5,
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "retry max backoff shorter than initial backoff",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+					Retry:     RetryConfig{InitialBackoff: time.Minute, MaxBackoff: time.Second},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "retry jitter above 1",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+					Retry:     RetryConfig{Jitter: 1.5},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative retry max attempts",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+					Retry:     RetryConfig{MaxAttempts: -1},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "slo",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+					SLO:       SLOConfig{Window: time.Hour, SuccessRate: 0.99, P95Latency: 2 * time.Second},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "slo success rate above 1",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+					SLO:       SLOConfig{Window: time.Hour, SuccessRate: 1.5},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "slo without window",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+					SLO:       SLOConfig{SuccessRate: 0.99},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown name collision strategy",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:         "dns.example.com",
+					Zone:           "test.example.com",
+					KeyName:        "test-key",
+					KeySecret:      "test-secret",
+					NameCollisions: "merge",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "user subdomains",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+					UserSubdomains: UserSubdomainsConfig{
+						Enabled: true,
+						Users: []UserSubdomainConfig{
+							{User: "alice@example.com", Label: "al"},
+							{User: "bob@example.com", Label: "eng.bob"},
+						},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "user subdomain without label",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+					UserSubdomains: UserSubdomainsConfig{
+						Enabled: true,
+						Users:   []UserSubdomainConfig{{User: "alice@example.com", Label: "."}},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "user subdomain mapped twice",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+					UserSubdomains: UserSubdomainsConfig{
+						Enabled: true,
+						Users: []UserSubdomainConfig{
+							{User: "alice@example.com", Label: "al"},
+							{User: "Alice@example.com", Label: "a"},
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "offline grace policy",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:             "dns.example.com",
+					Zone:               "test.example.com",
+					KeyName:            "test-key",
+					KeySecret:          "test-secret",
+					OfflinePolicy:      OfflinePolicyGrace,
+					OfflineGracePeriod: 10 * time.Minute,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "offline grace policy without grace period",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:        "dns.example.com",
+					Zone:          "test.example.com",
+					KeyName:       "test-key",
+					KeySecret:     "test-secret",
+					OfflinePolicy: OfflinePolicyGrace,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "offline grace period without grace policy",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:             "dns.example.com",
+					Zone:               "test.example.com",
+					KeyName:            "test-key",
+					KeySecret:          "test-secret",
+					OfflinePolicy:      OfflinePolicyKeep,
+					OfflineGracePeriod: 10 * time.Minute,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown offline policy",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:        "dns.example.com",
+					Zone:          "test.example.com",
+					KeyName:       "test-key",
+					KeySecret:     "test-secret",
+					OfflinePolicy: "forever",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "tailscale retry max backoff shorter than initial backoff",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+					Retry:   RetryConfig{InitialBackoff: time.Minute, MaxBackoff: time.Second},
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative tailscale max requests per minute",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:               "test-api-key",
+					Tailnet:              "test.example.com",
+					MaxRequestsPerMinute: -1,
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "plugins",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+				},
+				Plugins: []PluginConfig{
+					{Path: "/etc/plugins/a.wasm"},
+					{Path: "/etc/plugins/b.wasm", Timeout: time.Second},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "plugin without path",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+				},
+				Plugins: []PluginConfig{{Timeout: time.Second}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative plugin timeout",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+				},
+				Plugins: []PluginConfig{{Path: "/etc/plugins/a.wasm", Timeout: -time.Second}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative offline polls",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:       "test-api-key",
+					Tailnet:      "test.example.com",
+					OfflinePolls: -1,
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "TTL overrides within range",
 			config: &Config{
 				Tailscale: TailscaleConfig{
 					APIKey:  "test-api-key",
@@ -149,31 +1872,111 @@ func TestConfigValidate(t *testing.T) {
 					Zone:      "test.example.com",
 					KeyName:   "test-key",
 					KeySecret: "test-secret",
+					TTL:       300 * time.Second,
+					MinTTL:    time.Minute,
+					MaxTTL:    time.Hour,
+					TTLOverrides: map[string]time.Duration{
+						"laptop":  time.Minute,
+						"tag:web": 10 * time.Minute,
+					},
 				},
 			},
 			wantErr: false,
 		},
 		{
-			name: "valid config with OAuth",
+			name: "TTL override outside range",
 			config: &Config{
 				Tailscale: TailscaleConfig{
-					ClientID:     "test-client-id",
-					ClientSecret: "test-client-secret",
-					Tailnet:      "test.example.com",
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:       "dns.example.com",
+					Zone:         "test.example.com",
+					KeyName:      "test-key",
+					KeySecret:    "test-secret",
+					MaxTTL:       time.Hour,
+					TTLOverrides: map[string]time.Duration{"tag:web": 2 * time.Hour},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "minimum TTL above maximum TTL",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+					MinTTL:    time.Hour,
+					MaxTTL:    time.Minute,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative TTL tolerance",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:       "dns.example.com",
+					Zone:         "test.example.com",
+					KeyName:      "test-key",
+					KeySecret:    "test-secret",
+					TTLTolerance: -time.Second,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid zone order",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+					ZoneOrder: "random",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid additional zone",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
 				},
 				Bind: BindConfig{
 					Server:    "dns.example.com",
 					Zone:      "test.example.com",
 					KeyName:   "test-key",
 					KeySecret: "test-secret",
+					Zones: []ZoneConfig{
+						{Name: "servers.example.com", KeyName: "servers-key", KeySecret: "secret", Tags: []string{"tag:server"}},
+					},
 				},
 			},
 			wantErr: false,
 		},
 		{
-			name: "missing tailscale credentials",
+			name: "additional zone without selector",
 			config: &Config{
 				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
 					Tailnet: "test.example.com",
 				},
 				Bind: BindConfig{
@@ -181,42 +1984,53 @@ func TestConfigValidate(t *testing.T) {
 					Zone:      "test.example.com",
 					KeyName:   "test-key",
 					KeySecret: "test-secret",
+					Zones: []ZoneConfig{
+						{Name: "servers.example.com", KeyName: "servers-key", KeySecret: "secret"},
+					},
 				},
 			},
 			wantErr: true,
 		},
 		{
-			name: "missing tailnet",
+			name: "additional zone without key",
 			config: &Config{
 				Tailscale: TailscaleConfig{
-					APIKey: "test-api-key",
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
 				},
 				Bind: BindConfig{
 					Server:    "dns.example.com",
 					Zone:      "test.example.com",
 					KeyName:   "test-key",
 					KeySecret: "test-secret",
+					Zones: []ZoneConfig{
+						{Name: "servers.example.com", Users: []string{"alice@example.com"}},
+					},
 				},
 			},
 			wantErr: true,
 		},
 		{
-			name: "missing bind server",
+			name: "additional zone duplicating the main zone",
 			config: &Config{
 				Tailscale: TailscaleConfig{
 					APIKey:  "test-api-key",
 					Tailnet: "test.example.com",
 				},
 				Bind: BindConfig{
+					Server:    "dns.example.com",
 					Zone:      "test.example.com",
 					KeyName:   "test-key",
 					KeySecret: "test-secret",
+					Zones: []ZoneConfig{
+						{Name: "test.example.com.", KeyName: "k", KeySecret: "s", Tags: []string{"tag:server"}},
+					},
 				},
 			},
 			wantErr: true,
 		},
 		{
-			name: "missing bind zone",
+			name: "additional zone with invalid hostname pattern",
 			config: &Config{
 				Tailscale: TailscaleConfig{
 					APIKey:  "test-api-key",
@@ -224,14 +2038,18 @@ func TestConfigValidate(t *testing.T) {
 				},
 				Bind: BindConfig{
 					Server:    "dns.example.com",
+					Zone:      "test.example.com",
 					KeyName:   "test-key",
 					KeySecret: "test-secret",
+					Zones: []ZoneConfig{
+						{Name: "servers.example.com", KeyName: "k", KeySecret: "s", Hostnames: []string{"("}},
+					},
 				},
 			},
 			wantErr: true,
 		},
 		{
-			name: "missing bind key name",
+			name: "empty fallback server",
 			config: &Config{
 				Tailscale: TailscaleConfig{
 					APIKey:  "test-api-key",
@@ -239,23 +2057,64 @@ func TestConfigValidate(t *testing.T) {
 				},
 				Bind: BindConfig{
 					Server:    "dns.example.com",
+					Servers:   []string{"dns2.example.com", ""},
 					Zone:      "test.example.com",
+					KeyName:   "test-key",
 					KeySecret: "test-secret",
 				},
 			},
 			wantErr: true,
 		},
 		{
-			name: "missing bind key secret",
+			name: "invalid transport",
 			config: &Config{
 				Tailscale: TailscaleConfig{
 					APIKey:  "test-api-key",
 					Tailnet: "test.example.com",
 				},
 				Bind: BindConfig{
-					Server:  "dns.example.com",
-					Zone:    "test.example.com",
-					KeyName: "test-key",
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+					Transport: "quic",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "TLS client certificate without key",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+					Transport: TransportTCPTLS,
+					TLS:       TLSConfig{CertFile: "/etc/tailscale-bind-ddns/client.crt"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid gRPC address",
+			config: &Config{
+				Tailscale: TailscaleConfig{
+					APIKey:  "test-api-key",
+					Tailnet: "test.example.com",
+				},
+				Bind: BindConfig{
+					Server:    "dns.example.com",
+					Zone:      "test.example.com",
+					KeyName:   "test-key",
+					KeySecret: "test-secret",
+				},
+				General: GeneralConfig{
+					GRPCAddress: "unix:",
 				},
 			},
 			wantErr: true,
@@ -312,6 +2171,10 @@ func TestConfigDefaults(t *testing.T) {
 
 	// Check that defaults are applied
 	assert.Equal(t, 30*time.Second, config.Tailscale.PollInterval)
+	assert.Equal(t, OnlineHeuristicLastSeen, config.Tailscale.OnlineHeuristic)
+	assert.Equal(t, DefaultOnlineThreshold, config.Tailscale.OnlineThreshold)
+	assert.Equal(t, []string{AddressRangesTailscale}, config.Tailscale.AddressRanges)
+	assert.Equal(t, DefaultHistorySize, config.General.HistorySize)
 	assert.Equal(t, 53, config.Bind.Port)
 	assert.Equal(t, "hmac-sha256", config.Bind.Algorithm)
 	assert.Equal(t, 300*time.Second, config.Bind.TTL)
@@ -319,3 +2182,312 @@ func TestConfigDefaults(t *testing.T) {
 	assert.Equal(t, "info", config.General.LogLevel)
 	assert.Equal(t, false, config.General.DryRun)
 }
+
+func TestLoadPartialConfig(t *testing.T) {
+	viper.Reset()
+	setDefaults()
+
+	// Only the bind server is present, which would fail full validation
+	viper.Set("bind.server", "dns.example.com")
+
+	config, err := LoadPartialConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "dns.example.com", config.Bind.Server)
+	assert.Empty(t, config.Tailscale.APIKey)
+
+	_, err = LoadConfig()
+	assert.Error(t, err)
+}
+
+func TestValidateStatusAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		wantErr bool
+	}{
+		{
+			name:    "host and port",
+			address: "127.0.0.1:9236",
+			wantErr: false,
+		},
+		{
+			name:    "port only",
+			address: ":9236",
+			wantErr: false,
+		},
+		{
+			name:    "unix socket",
+			address: "unix:/run/tailscale-bind-ddns.sock",
+			wantErr: false,
+		},
+		{
+			name:    "unix socket without path",
+			address: "unix:",
+			wantErr: true,
+		},
+		{
+			name:    "missing port",
+			address: "127.0.0.1",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateStatusAddress(tt.address)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateTargets(t *testing.T) {
+	base := Config{
+		Tailscale: TailscaleConfig{APIKey: "test-api-key", Tailnet: "test.example.com"},
+		Bind: BindConfig{
+			Server:    "dns.example.com",
+			Zone:      "ts.example.com",
+			KeyName:   "test-key",
+			KeySecret: "test-secret",
+			QueueFile: "/var/lib/tailscale-bind-ddns/queue.json",
+			StateFile: "/var/lib/tailscale-bind-ddns/state.json",
+			Zones: []ZoneConfig{{
+				Name: "servers.example.com", KeyName: "servers-key", KeySecret: "servers-secret",
+				Tags: []string{"tag:server"},
+			}},
+			PTR: PTRConfig{
+				Enabled: true, IPv4Zone: "64.100.in-addr.arpa", IPv4Subnet: "100.64.0.0/10", IPv4SubnetSize: 16,
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		targets []TargetConfig
+		wantErr bool
+	}{
+		{
+			name:    "bind target inheriting the server and key",
+			targets: []TargetConfig{{Name: "public", Zone: "example.com", Tags: []string{"tag:public"}}},
+		},
+		{
+			name:    "missing name",
+			targets: []TargetConfig{{Zone: "example.com"}},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate name",
+			targets: []TargetConfig{{Name: "public", Zone: "example.com"}, {Name: "public", Zone: "example.org"}},
+			wantErr: true,
+		},
+		{
+			name:    "missing zone",
+			targets: []TargetConfig{{Name: "public"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid hostnames pattern",
+			targets: []TargetConfig{{Name: "public", Zone: "example.com", Hostnames: []string{"web-("}}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown provider",
+			targets: []TargetConfig{{Name: "public", Provider: "cloudflare", Zone: "example.com"}},
+			wantErr: true,
+		},
+		{
+			name:    "unconfigured provider",
+			targets: []TargetConfig{{Name: "public", Provider: ProviderPowerDNS, Zone: "example.com"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base
+			cfg.Targets = tt.targets
+			err := cfg.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	// The provider of a target publishes only to its zone, keeping the settings it doesn't override
+	target := base.ForTarget(TargetConfig{
+		Name:     "public",
+		Provider: ProviderPowerDNS,
+		Zone:     "example.com",
+		KeyName:  "public-key",
+	})
+	assert.Equal(t, ProviderPowerDNS, target.General.Provider)
+	assert.Equal(t, "example.com", target.Bind.Zone)
+	assert.Equal(t, "dns.example.com", target.Bind.Server)
+	assert.Equal(t, "public-key", target.Bind.KeyName)
+	assert.Empty(t, target.Bind.KeySecret)
+	assert.Empty(t, target.Bind.Zones)
+	assert.False(t, target.Bind.PTR.Enabled)
+	assert.Equal(t, "/var/lib/tailscale-bind-ddns/queue.json.public", target.Bind.QueueFile)
+	assert.Equal(t, "/var/lib/tailscale-bind-ddns/state.json.public", target.Bind.StateFile)
+	assert.Equal(t, "ts.example.com", base.Bind.Zone)
+}
+
+func TestValidateViews(t *testing.T) {
+	base := Config{
+		Tailscale: TailscaleConfig{APIKey: "test-api-key", Tailnet: "test.example.com"},
+		Bind: BindConfig{
+			Server:    "dns.example.com",
+			Zone:      "ts.example.com",
+			KeyName:   "test-key",
+			KeySecret: "test-secret",
+		},
+	}
+
+	tests := []struct {
+		name    string
+		targets []TargetConfig
+		views   []ViewConfig
+		wantErr bool
+	}{
+		{
+			name: "views with a selector and without",
+			views: []ViewConfig{
+				{Name: "admin", Zones: []ViewZoneConfig{{Zone: "admin.example.com"}}},
+				{Name: "users", Tags: []string{"tag:service"}, Zones: []ViewZoneConfig{{Zone: "users.example.com"}}},
+			},
+		},
+		{
+			name:    "missing name",
+			views:   []ViewConfig{{Zones: []ViewZoneConfig{{Zone: "admin.example.com"}}}},
+			wantErr: true,
+		},
+		{
+			name: "duplicate name",
+			views: []ViewConfig{
+				{Name: "admin", Zones: []ViewZoneConfig{{Zone: "admin.example.com"}}},
+				{Name: "admin", Zones: []ViewZoneConfig{{Zone: "admin.example.net"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "missing zones",
+			views:   []ViewConfig{{Name: "admin"}},
+			wantErr: true,
+		},
+		{
+			name:    "missing zone name",
+			views:   []ViewConfig{{Name: "admin", Zones: []ViewZoneConfig{{Server: "ns1.example.com"}}}},
+			wantErr: true,
+		},
+		{
+			name: "duplicate zone",
+			views: []ViewConfig{{
+				Name:  "admin",
+				Zones: []ViewZoneConfig{{Zone: "admin.example.com"}, {Zone: "admin.example.com"}},
+			}},
+			wantErr: true,
+		},
+		{
+			name:    "zone named like a target",
+			targets: []TargetConfig{{Name: "admin@admin.example.com", Zone: "example.com"}},
+			views:   []ViewConfig{{Name: "admin", Zones: []ViewZoneConfig{{Zone: "admin.example.com"}}}},
+			wantErr: true,
+		},
+		{
+			name: "invalid hostnames pattern",
+			views: []ViewConfig{{
+				Name: "admin", Hostnames: []string{"web-("}, Zones: []ViewZoneConfig{{Zone: "admin.example.com"}},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "unconfigured provider",
+			views: []ViewConfig{{
+				Name: "admin", Zones: []ViewZoneConfig{{Zone: "admin.example.com", Provider: ProviderPowerDNS}},
+			}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base
+			cfg.Targets = tt.targets
+			cfg.Views = tt.views
+			err := cfg.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	// Every zone of a view becomes a target with the selector of the view
+	base.Views = []ViewConfig{{
+		Name:  "users",
+		Users: []string{"alice@example.com"},
+		Zones: []ViewZoneConfig{{Zone: "users.example.com", KeyName: "users-key", KeySecret: "users-secret"}},
+	}}
+	assert.Equal(t, []TargetConfig{{
+		Name: "users@users.example.com", Zone: "users.example.com", KeyName: "users-key", KeySecret: "users-secret",
+		Users: []string{"alice@example.com"},
+	}}, base.ViewTargets())
+}
+
+func TestApplySelfOnly(t *testing.T) {
+	// Publishing only the local machine reads it from tailscaled instead of the API
+	c := &Config{Tailscale: TailscaleConfig{Mode: TailscaleModeAPI, Local: LocalConfig{SelfOnly: true}}}
+	c.applySelfOnly()
+	assert.Equal(t, TailscaleModeLocal, c.Tailscale.Mode)
+
+	c = &Config{Tailscale: TailscaleConfig{Mode: TailscaleModeTSNet, Local: LocalConfig{SelfOnly: true}}}
+	c.applySelfOnly()
+	assert.Equal(t, TailscaleModeTSNet, c.Tailscale.Mode)
+
+	c = &Config{Tailscale: TailscaleConfig{Mode: TailscaleModeAPI}}
+	c.applySelfOnly()
+	assert.Equal(t, TailscaleModeAPI, c.Tailscale.Mode)
+}
+
+func TestReadSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "tsig-secret")
+	require.NoError(t, os.WriteFile(keyFile, []byte("dGVzdC1zZWNyZXQ=\n"), 0o600))
+	apiKeyFile := filepath.Join(dir, "api-key")
+	require.NoError(t, os.WriteFile(apiKeyFile, []byte("test-api-key"), 0o600))
+
+	c := &Config{
+		Tailscale: TailscaleConfig{APIKeyFile: apiKeyFile},
+		Bind:      BindConfig{KeySecretFile: keyFile},
+	}
+	require.NoError(t, c.readSecretFiles())
+	assert.Equal(t, "dGVzdC1zZWNyZXQ=", c.Bind.KeySecret)
+	assert.Equal(t, "test-api-key", c.Tailscale.APIKey)
+	assert.Empty(t, c.Tailscale.ClientSecret)
+
+	// The secret is either set or read from a file
+	c = &Config{Bind: BindConfig{KeySecret: "dGVzdC1zZWNyZXQ=", KeySecretFile: keyFile}}
+	assert.EqualError(t, c.readSecretFiles(), "bind.key_secret and bind.key_secret_file can't be used together")
+
+	c = &Config{Tailscale: TailscaleConfig{ClientSecretFile: filepath.Join(dir, "missing")}}
+	assert.ErrorContains(t, c.readSecretFiles(), "reading tailscale.client_secret_file")
+
+	empty := filepath.Join(dir, "empty")
+	require.NoError(t, os.WriteFile(empty, []byte("\n"), 0o600))
+	_, err := ReadSecretFile(empty)
+	assert.EqualError(t, err, empty+" is empty")
+}
+
+func TestActiveDirectoryDomain(t *testing.T) {
+	b := BindConfig{GSS: GSSConfig{Principal: "ddns@CORP.EXAMPLE.COM"}}
+	assert.Equal(t, "corp.example.com", b.ActiveDirectoryDomain())
+	b.ActiveDirectory.Domain = "example.com"
+	assert.Equal(t, "example.com", b.ActiveDirectoryDomain())
+	assert.Empty(t, (&BindConfig{}).ActiveDirectoryDomain())
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+const yamlIndent = 2
+
+// SetFileValues rewrites the given dotted keys (e.g. "bind.key_name") in a YAML config file, creating intermediate
+// mappings as needed. Comments and the ordering of untouched keys are preserved.
+func SetFileValues(path string, values map[string]string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return fmt.Errorf("config file %s does not contain a YAML mapping", path)
+	}
+
+	for key, value := range values {
+		if err := setNodeValue(doc.Content[0], strings.Split(key, "."), value); err != nil {
+			return fmt.Errorf("setting %s: %w", key, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(yamlIndent)
+	if err := encoder.Encode(&doc); err != nil {
+		return fmt.Errorf("encoding config file: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return fmt.Errorf("encoding config file: %w", err)
+	}
+
+	if err := os.WriteFile(path, buf.Bytes(), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("writing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// setNodeValue sets the scalar at path within a mapping node
+func setNodeValue(mapping *yaml.Node, path []string, value string) error {
+	if mapping.Kind != yaml.MappingNode {
+		return fmt.Errorf("expected a mapping at %q", path[0])
+	}
+
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value != path[0] {
+			continue
+		}
+		child := mapping.Content[i+1]
+		if len(path) == 1 {
+			child.Kind = yaml.ScalarNode
+			child.Tag = "!!str"
+			child.Value = value
+			child.Content = nil
+			return nil
+		}
+		return setNodeValue(child, path[1:], value)
+	}
+
+	// Key not present yet, append it
+	keyNode := &yaml.Node{Kind: yaml.ScalarNode, Value: path[0]}
+	if len(path) == 1 {
+		mapping.Content = append(mapping.Content, keyNode, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value})
+		return nil
+	}
+	child := &yaml.Node{Kind: yaml.MappingNode}
+	mapping.Content = append(mapping.Content, keyNode, child)
+	return setNodeValue(child, path[1:], value)
+}
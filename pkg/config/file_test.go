@@ -0,0 +1,54 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetFileValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	original := `# Tailscale configuration
+tailscale:
+  tailnet: "example.com"
+
+bind:
+  # TSIG key configuration
+  key_name: "old-key"
+  key_secret: "old-secret"
+`
+	require.NoError(t, os.WriteFile(path, []byte(original), 0o600))
+
+	err := SetFileValues(path, map[string]string{
+		"bind.key_name":   "new-key",
+		"bind.key_secret": "new-secret",
+		"bind.algorithm":  "hmac-sha512",
+	})
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	content := string(data)
+
+	assert.Contains(t, content, "# Tailscale configuration")
+	assert.Contains(t, content, "# TSIG key configuration")
+	assert.Contains(t, content, `key_name: "new-key"`)
+	assert.Contains(t, content, `key_secret: "new-secret"`)
+	assert.Contains(t, content, "algorithm: hmac-sha512")
+	assert.NotContains(t, content, "old-secret")
+	assert.Contains(t, content, `tailnet: "example.com"`)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestSetFileValuesNotMapping(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("- just\n- a list\n"), 0o600))
+
+	assert.Error(t, SetFileValues(path, map[string]string{"bind.key_name": "new-key"}))
+}
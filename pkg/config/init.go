@@ -0,0 +1,112 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Starter configurations are the example configuration with the settings a new user has to decide on filled in. The
+// example documents every option with its default, so the starter configuration stays complete as options are added
+// and only the keys written here have to remain in the example. Keys are edited line by line, so that every comment is
+// kept: keys set in the example get their value replaced and keys commented out in the example are uncommented.
+
+// Setting is a value written into a starter configuration
+type Setting struct {
+	Key string // Dotted path of the key, e.g. "bind.zone"
+	// Value is written as a YAML scalar, strings quoted. A nil value comments the key out.
+	Value any
+}
+
+// exampleKeyRegexp matches a key line of the example without its indentation, e.g. `zone: "example.com."`, and a key
+// commented out right after the indentation, e.g. `#api_key: "..."`, but not comments about keys
+var exampleKeyRegexp = regexp.MustCompile(`^(#?)([a-z0-9_]+):(\s.*)?$`)
+
+// StarterConfig returns the example configuration with the settings written into it. Every setting must be a key of
+// the example, set or commented out; the result is checked to still be valid YAML.
+func StarterConfig(example []byte, settings []Setting) ([]byte, error) {
+	lines := strings.Split(string(example), "\n")
+	paths := examplePaths(lines)
+
+	for _, setting := range settings {
+		i, ok := paths[setting.Key]
+		if !ok {
+			return nil, fmt.Errorf("key %s is not part of the example configuration", setting.Key)
+		}
+		indent := lines[i][:len(lines[i])-len(strings.TrimLeft(lines[i], " "))]
+		match := exampleKeyRegexp.FindStringSubmatch(strings.TrimLeft(lines[i], " "))
+		if setting.Value == nil {
+			lines[i] = indent + "#" + match[2] + ":" + match[3]
+			continue
+		}
+		value, err := yamlScalar(setting.Value)
+		if err != nil {
+			return nil, fmt.Errorf("writing %s: %w", setting.Key, err)
+		}
+		lines[i] = indent + match[2] + ": " + value
+	}
+
+	data := []byte(strings.Join(lines, "\n"))
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing starter configuration: %w", err)
+	}
+	return data, nil
+}
+
+// examplePaths returns the line of every key of the example by dotted path, the first one where a key appears more
+// than once. Commented out keys count as keys of the mapping they are indented in.
+func examplePaths(lines []string) map[string]int {
+	type parent struct {
+		indent int
+		key    string
+	}
+	var stack []parent
+	paths := make(map[string]int)
+	for i, line := range lines {
+		trimmed := strings.TrimLeft(line, " ")
+		match := exampleKeyRegexp.FindStringSubmatch(trimmed)
+		if match == nil {
+			continue
+		}
+		indent := len(line) - len(trimmed)
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+
+		keys := make([]string, 0, len(stack)+1)
+		for _, p := range stack {
+			keys = append(keys, p.key)
+		}
+		path := strings.Join(append(keys, match[2]), ".")
+		if _, ok := paths[path]; !ok {
+			paths[path] = i
+		}
+		// Only keys that are set can hold the keys indented below them
+		if match[1] == "" {
+			stack = append(stack, parent{indent: indent, key: match[2]})
+		}
+	}
+	return paths
+}
+
+// yamlScalar renders a value as a YAML scalar, strings double quoted like the rest of the example
+func yamlScalar(value any) (string, error) {
+	node := &yaml.Node{}
+	if err := node.Encode(value); err != nil {
+		return "", err
+	}
+	if node.Kind != yaml.ScalarNode {
+		return "", fmt.Errorf("%v is not a scalar", value)
+	}
+	if node.Tag == "!!str" {
+		node.Style = yaml.DoubleQuotedStyle
+	}
+	data, err := yaml.Marshal(node)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
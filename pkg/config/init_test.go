@@ -0,0 +1,69 @@
+package config
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStarterConfig(t *testing.T) {
+	example := `# Tailscale configuration
+tailscale:
+  # API key
+  #api_key: "your-api-key"
+  client_id: "your-client-id"
+  # Poll interval
+  poll_interval: "30s"
+#providers:
+#  powerdns:
+#    api_key: "your-api-key"
+bind:
+  zone: "example.com."
+  ptr:
+    enabled: false
+`
+	data, err := StarterConfig([]byte(example), []Setting{
+		{Key: "tailscale.api_key", Value: `tskey-"quoted"`},
+		{Key: "tailscale.client_id"},
+		{Key: "bind.zone", Value: "ts.example.com."},
+		{Key: "bind.ptr.enabled", Value: true},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, `# Tailscale configuration
+tailscale:
+  # API key
+  api_key: "tskey-\"quoted\""
+  #client_id: "your-client-id"
+  # Poll interval
+  poll_interval: "30s"
+#providers:
+#  powerdns:
+#    api_key: "your-api-key"
+bind:
+  zone: "ts.example.com."
+  ptr:
+    enabled: true
+`, string(data))
+
+	// Keys of commented out sections aren't keys of the example
+	_, err = StarterConfig([]byte(example), []Setting{{Key: "providers.powerdns.api_key", Value: "key"}})
+	assert.Error(t, err)
+
+	// The example shipped with the binary holds every key config init writes
+	shipped, err := os.ReadFile("../../config.yaml.example")
+	require.NoError(t, err)
+	_, err = StarterConfig(shipped, []Setting{
+		{Key: "tailscale.tailnet", Value: "example.com"},
+		{Key: "tailscale.api_key", Value: "key"},
+		{Key: "tailscale.client_id"},
+		{Key: "tailscale.client_secret"},
+		{Key: "bind.server", Value: "dns.example.com"},
+		{Key: "bind.zone", Value: "ts.example.com."},
+		{Key: "bind.key_name", Value: "ddns"},
+		{Key: "bind.key_secret", Value: "c2VjcmV0"},
+		{Key: "bind.ptr.enabled", Value: true},
+	})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,195 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Configuration files are migrated between versions by moving the keys a version renamed or moved to their new place,
+// keeping comments and the values as written. Keys the configuration doesn't know are reported, as they are silently
+// ignored when the configuration is loaded, which hides both typos and keys a migration doesn't cover.
+
+// KeyMigration moves a key of the configuration file that was renamed or moved, or drops one that no longer has any
+// effect
+type KeyMigration struct {
+	From string // Dotted path of the old key, e.g. "bind.key_file"
+	To   string // Dotted path of the new key, empty when the key was dropped
+	Note string // Why the key moved or what replaces it, shown with the warning
+}
+
+// keyMigrations lists the keys moved or dropped since the flat single-zone layout, oldest first. Every change that
+// breaks existing configuration files adds its entries here, so that `config migrate` carries users across it.
+var keyMigrations []KeyMigration
+
+// MigrateFile reads a configuration file and returns it with every migrated key in its new place, along with warnings
+// about migrated, conflicting and unknown keys. The file itself isn't modified.
+func MigrateFile(path string) ([]byte, []string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading config file: %w", err)
+	}
+	migrated, warnings, err := migrate(data, keyMigrations)
+	if err != nil {
+		return nil, nil, fmt.Errorf("migrating config file %s: %w", path, err)
+	}
+	return migrated, warnings, nil
+}
+
+// migrate applies migrations to a YAML configuration document
+func migrate(data []byte, migrations []KeyMigration) ([]byte, []string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("parsing YAML: %w", err)
+	}
+	if doc.Kind == 0 {
+		return data, nil, nil
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, nil, fmt.Errorf("the file does not contain a YAML mapping")
+	}
+	root := doc.Content[0]
+
+	var warnings []string
+	for _, migration := range migrations {
+		key, value := takeNode(root, strings.Split(migration.From, "."))
+		if value == nil {
+			continue
+		}
+
+		note := ""
+		if migration.Note != "" {
+			note = ": " + migration.Note
+		}
+		switch {
+		case migration.To == "":
+			warnings = append(warnings, fmt.Sprintf("removed %s, it has no effect anymore%s", migration.From, note))
+		case lookupNode(root, strings.Split(migration.To, ".")) != nil:
+			warnings = append(warnings, fmt.Sprintf("removed %s, %s is set as well and takes precedence%s",
+				migration.From, migration.To, note))
+		default:
+			if err := putNode(root, strings.Split(migration.To, "."), key, value); err != nil {
+				return nil, nil, fmt.Errorf("moving %s to %s: %w", migration.From, migration.To, err)
+			}
+			warnings = append(warnings, fmt.Sprintf("moved %s to %s%s", migration.From, migration.To, note))
+		}
+	}
+	warnings = append(warnings, unknownKeys(root, reflect.TypeFor[Config](), "")...)
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(yamlIndent)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, nil, fmt.Errorf("encoding YAML: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, nil, fmt.Errorf("encoding YAML: %w", err)
+	}
+	return buf.Bytes(), warnings, nil
+}
+
+// lookupNode returns the value at path within a mapping node, nil when there is none
+func lookupNode(mapping *yaml.Node, path []string) *yaml.Node {
+	for i := 0; mapping.Kind == yaml.MappingNode && i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			return mapping.Content[i+1]
+		}
+		return lookupNode(mapping.Content[i+1], path[1:])
+	}
+	return nil
+}
+
+// takeNode removes the key at path from a mapping node and returns its key and value nodes, nils when there is none.
+// Mappings left empty are removed as well.
+func takeNode(mapping *yaml.Node, path []string) (*yaml.Node, *yaml.Node) {
+	for i := 0; mapping.Kind == yaml.MappingNode && i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value != path[0] {
+			continue
+		}
+		key, value := mapping.Content[i], mapping.Content[i+1]
+		if len(path) > 1 {
+			key, value = takeNode(value, path[1:])
+			if key == nil || len(mapping.Content[i+1].Content) > 0 {
+				return key, value
+			}
+		}
+		mapping.Content = slices.Delete(mapping.Content, i, i+2)
+		return key, value
+	}
+	return nil, nil
+}
+
+// putNode adds a key and its value at path within a mapping node, creating intermediate mappings as needed. The key
+// keeps its comments but takes the last element of path as its name.
+func putNode(mapping *yaml.Node, path []string, key, value *yaml.Node) error {
+	if mapping.Kind != yaml.MappingNode {
+		return fmt.Errorf("expected a mapping at %q", path[0])
+	}
+	if len(path) == 1 {
+		key.Value = path[0]
+		mapping.Content = append(mapping.Content, key, value)
+		return nil
+	}
+
+	child := lookupNode(mapping, path[:1])
+	if child == nil {
+		child = &yaml.Node{Kind: yaml.MappingNode}
+		mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: path[0]}, child)
+	}
+	return putNode(child, path[1:], key, value)
+}
+
+// unknownKeys returns warnings about the keys of a mapping node that the struct type it's decoded into has no field
+// for, descending into nested structs and lists of structs
+func unknownKeys(node *yaml.Node, typ reflect.Type, prefix string) []string {
+	switch {
+	case typ.Kind() == reflect.Pointer:
+		return unknownKeys(node, typ.Elem(), prefix)
+	case typ.Kind() == reflect.Slice && node.Kind == yaml.SequenceNode:
+		var warnings []string
+		for i, item := range node.Content {
+			warnings = append(warnings, unknownKeys(item, typ.Elem(), fmt.Sprintf("%s[%d]", prefix, i))...)
+		}
+		return warnings
+	case typ.Kind() != reflect.Struct || node.Kind != yaml.MappingNode:
+		// Scalars and maps, e.g. bind.aliases, take any keys
+		return nil
+	}
+
+	fields := make(map[string]reflect.Type, typ.NumField())
+	for i := range typ.NumField() {
+		field := typ.Field(i)
+		if name := field.Tag.Get("mapstructure"); name != "" {
+			fields[name] = field.Type
+		}
+	}
+
+	var warnings []string
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name := node.Content[i].Value
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+		// The configuration file loader matches keys case-insensitively
+		fieldType, ok := fields[strings.ToLower(name)]
+		if !ok {
+			// Keys added by a migration have no line
+			if line := node.Content[i].Line; line > 0 {
+				key = fmt.Sprintf("%s (line %d)", key, line)
+			}
+			warnings = append(warnings, "unknown key "+key+" is ignored")
+			continue
+		}
+		warnings = append(warnings, unknownKeys(node.Content[i+1], fieldType, key)...)
+	}
+	return warnings
+}
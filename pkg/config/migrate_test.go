@@ -0,0 +1,81 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrate(t *testing.T) {
+	original := `# Tailscale configuration
+tailscale:
+  tailnet: "example.com"
+  pol_interval: "30s"
+
+bind:
+  zone: "ts.example.com"
+  # TSIG key file
+  key_file: "/etc/bind/ddns.key"
+  legacy:
+    ptr_zone: "64.100.in-addr.arpa"
+  retries: 3
+  Remove_Stale: true
+  aliases:
+    nas: "storage-box"
+  zones:
+    - name: "servers.example.com"
+      tag: "tag:server"
+
+general:
+  dry_run: false
+  old_ttl: "300s"
+`
+	migrations := []KeyMigration{
+		{From: "bind.key_file", To: "providers.bind.key_file", Note: "TSIG keys are configured per provider"},
+		{From: "bind.legacy.ptr_zone", To: "bind.ptr.ipv4_zone"},
+		{From: "bind.retries", To: ""},
+		{From: "general.old_ttl", To: "bind.zone"},
+		{From: "bind.missing", To: "bind.other"},
+	}
+
+	data, warnings, err := migrate([]byte(original), migrations)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"moved bind.key_file to providers.bind.key_file: TSIG keys are configured per provider",
+		"moved bind.legacy.ptr_zone to bind.ptr.ipv4_zone",
+		"removed bind.retries, it has no effect anymore",
+		"removed general.old_ttl, bind.zone is set as well and takes precedence",
+		"unknown key tailscale.pol_interval (line 4) is ignored",
+		"unknown key bind.zones[0].tag (line 18) is ignored",
+		"unknown key providers.bind is ignored",
+	}, warnings)
+
+	// Moved keys keep their comments and values, mappings left empty are removed
+	content := string(data)
+	assert.Contains(t, content, "# Tailscale configuration")
+	assert.Contains(t, content, "providers:\n  bind:\n    # TSIG key file\n    key_file: \"/etc/bind/ddns.key\"")
+	assert.Contains(t, content, "ptr:\n    ipv4_zone: \"64.100.in-addr.arpa\"")
+	assert.NotContains(t, content, "legacy")
+	assert.NotContains(t, content, "retries")
+	assert.NotContains(t, content, "old_ttl")
+	assert.Contains(t, content, `zone: "ts.example.com"`)
+}
+
+func TestMigrateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	original := "bind:\n  zone: ts.example.com\n  remove_stale: true\n"
+	require.NoError(t, os.WriteFile(path, []byte(original), 0o600))
+
+	// A current configuration comes out unchanged and the file is left alone
+	data, warnings, err := MigrateFile(path)
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+	assert.Equal(t, original, string(data))
+
+	require.NoError(t, os.WriteFile(path, []byte("- just\n- a list\n"), 0o600))
+	_, _, err = MigrateFile(path)
+	assert.Error(t, err)
+}
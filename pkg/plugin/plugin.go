@@ -0,0 +1,266 @@
+// Package plugin runs WASM modules that transform the desired records, sandboxed with wazero.
+//
+// A plugin is a WASM module exporting its memory along with
+//
+//	alloc(size i32) i32              returns the address of size bytes the input can be written to
+//	transform(ptr i32, len i32) i64  returns the address (upper 32 bits) and length (lower 32 bits) of the output
+//
+// The input is a JSON object holding the machines and their desired records, {"machines": [...], "records": [...]},
+// the output a JSON object holding the records to publish instead, {"records": [...]}, or the reason the plugin
+// failed, {"error": "..."}. Records are objects with name, type, value, ttl and zone, machines objects with id, name,
+// ipv4_address, ipv6_address, last_seen, online, tags, user and os among others. Passing the input back unchanged keeps
+// the records as they are.
+//
+// Modules built for WASI (wasip1), e.g. by TinyGo or Rust, can be used as reactors: their _initialize function is run
+// before transform. They get no files, network or environment, what they write to stdout and stderr is logged. Every
+// run starts from a fresh instance, so nothing is kept between runs.
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/tailscale"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// memoryLimitPages caps the memory of a plugin at 256 MiB
+	memoryLimitPages = 4096
+
+	allocFunction     = "alloc"
+	transformFunction = "transform"
+)
+
+// record is a DNS record as plugins see it, bind.DNSRecord with JSON names
+type record struct {
+	Name  string          `json:"name"`
+	Value string          `json:"value"`
+	TTL   uint32          `json:"ttl,omitempty"`
+	Type  bind.RecordType `json:"type"`
+	Zone  string          `json:"zone,omitempty"`
+}
+
+// request is the input of a plugin
+type request struct {
+	Machines []tailscale.Machine `json:"machines"`
+	Records  []record            `json:"records"`
+}
+
+// response is the output of a plugin
+type response struct {
+	Records *[]record `json:"records"`
+	Error   string    `json:"error"`
+}
+
+// Plugin is a compiled WASM module transforming desired records
+type Plugin struct {
+	name    string
+	timeout time.Duration
+	runtime wazero.Runtime
+	module  wazero.CompiledModule
+}
+
+// Load compiles the WASM module at path. A run of the plugin is aborted after timeout.
+func Load(ctx context.Context, path string, timeout time.Duration) (*Plugin, error) {
+	wasm, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading plugin: %w", err)
+	}
+	plugin, err := New(ctx, filepath.Base(path), wasm, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("loading plugin %s: %w", path, err)
+	}
+	return plugin, nil
+}
+
+// New compiles a WASM module as a plugin, checking that it exports what plugins must
+func New(ctx context.Context, name string, wasm []byte, timeout time.Duration) (*Plugin, error) {
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithCloseOnContextDone(true).
+		WithMemoryLimitPages(memoryLimitPages))
+
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		_ = runtime.Close(ctx)
+		return nil, fmt.Errorf("providing WASI: %w", err)
+	}
+	module, err := runtime.CompileModule(ctx, wasm)
+	if err != nil {
+		_ = runtime.Close(ctx)
+		return nil, fmt.Errorf("compiling module: %w", err)
+	}
+	if err := checkExports(module); err != nil {
+		_ = runtime.Close(ctx)
+		return nil, err
+	}
+
+	return &Plugin{name: name, timeout: timeout, runtime: runtime, module: module}, nil
+}
+
+// checkExports checks that a module exports its memory and the plugin functions with their signatures
+func checkExports(module wazero.CompiledModule) error {
+	if _, ok := module.ExportedMemories()["memory"]; !ok {
+		return fmt.Errorf("module does not export its memory")
+	}
+
+	functions := module.ExportedFunctions()
+	for _, want := range []struct {
+		name    string
+		params  []api.ValueType
+		results []api.ValueType
+	}{
+		{allocFunction, []api.ValueType{api.ValueTypeI32}, []api.ValueType{api.ValueTypeI32}},
+		{transformFunction, []api.ValueType{api.ValueTypeI32, api.ValueTypeI32}, []api.ValueType{api.ValueTypeI64}},
+	} {
+		function, ok := functions[want.name]
+		if !ok {
+			return fmt.Errorf("module does not export %s", want.name)
+		}
+		if !bytes.Equal(function.ParamTypes(), want.params) || !bytes.Equal(function.ResultTypes(), want.results) {
+			return fmt.Errorf("exported function %s has the wrong signature", want.name)
+		}
+	}
+	return nil
+}
+
+// Name returns the name of the plugin, the file name of its module
+func (p *Plugin) Name() string {
+	return p.name
+}
+
+// Transform runs the plugin on the machines and their desired records and returns the records it wants published
+func (p *Plugin) Transform(
+	ctx context.Context,
+	machines []tailscale.Machine,
+	records []bind.DNSRecord,
+) ([]bind.DNSRecord, error) {
+	input := request{Machines: machines, Records: make([]record, 0, len(records))}
+	if input.Machines == nil {
+		input.Machines = []tailscale.Machine{}
+	}
+	for _, r := range records {
+		input.Records = append(input.Records, record(r))
+	}
+	data, err := json.Marshal(input)
+	if err != nil {
+		return nil, fmt.Errorf("encoding input: %w", err)
+	}
+
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+	output, err := p.run(ctx, data)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("plugin %s did not finish within %v: %w", p.name, p.timeout, ctx.Err())
+		}
+		return nil, fmt.Errorf("running plugin %s: %w", p.name, err)
+	}
+
+	var result response
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, fmt.Errorf("parsing output of plugin %s: %w", p.name, err)
+	}
+	if result.Error != "" {
+		return nil, fmt.Errorf("plugin %s failed: %s", p.name, result.Error)
+	}
+	if result.Records == nil {
+		return nil, fmt.Errorf("output of plugin %s holds no records", p.name)
+	}
+
+	transformed := make([]bind.DNSRecord, 0, len(*result.Records))
+	for i, r := range *result.Records {
+		if err := validateRecord(r); err != nil {
+			return nil, fmt.Errorf("record %d of plugin %s: %w", i, p.name, err)
+		}
+		transformed = append(transformed, bind.DNSRecord(r))
+	}
+	return transformed, nil
+}
+
+// run instantiates the module, writes the input into its memory and returns the output of transform
+func (p *Plugin) run(ctx context.Context, input []byte) ([]byte, error) {
+	var logs bytes.Buffer
+	module, err := p.runtime.InstantiateModule(ctx, p.module, wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize").
+		WithStdout(&logs).
+		WithStderr(&logs))
+	defer p.logOutput(&logs)
+	if err != nil {
+		return nil, fmt.Errorf("instantiating module: %w", err)
+	}
+	defer module.Close(ctx)
+
+	results, err := module.ExportedFunction(allocFunction).Call(ctx, uint64(len(input)))
+	if err != nil {
+		return nil, fmt.Errorf("allocating input: %w", err)
+	}
+	ptr := uint32(results[0])
+	if !module.Memory().Write(ptr, input) {
+		return nil, fmt.Errorf("input of %d bytes at %d is out of the module's memory", len(input), ptr)
+	}
+
+	results, err = module.ExportedFunction(transformFunction).Call(ctx, uint64(ptr), uint64(len(input)))
+	if err != nil {
+		return nil, err
+	}
+	ptr, length := uint32(results[0]>>32), uint32(results[0])
+	output, ok := module.Memory().Read(ptr, length)
+	if !ok {
+		return nil, fmt.Errorf("output of %d bytes at %d is out of the module's memory", length, ptr)
+	}
+	// The memory is gone once the module is closed
+	return bytes.Clone(output), nil
+}
+
+// logOutput logs what the plugin wrote to stdout and stderr, line by line
+func (p *Plugin) logOutput(logs *bytes.Buffer) {
+	for line := range strings.Lines(logs.String()) {
+		if line = strings.TrimRight(line, "\n"); line != "" {
+			klog.Infof("Plugin %s: %s", p.name, line)
+		}
+	}
+}
+
+// validateRecord checks that a record returned by a plugin can be published
+func validateRecord(r record) error {
+	if r.Name == "" || r.Value == "" {
+		return fmt.Errorf("name and value are required")
+	}
+	switch r.Type {
+	case bind.TypeA:
+		if addr, err := netip.ParseAddr(r.Value); err != nil || !addr.Is4() {
+			return fmt.Errorf("A record %s holds %q, not an IPv4 address", r.Name, r.Value)
+		}
+	case bind.TypeAAAA:
+		if addr, err := netip.ParseAddr(r.Value); err != nil || !addr.Is6() || addr.Is4In6() {
+			return fmt.Errorf("AAAA record %s holds %q, not an IPv6 address", r.Name, r.Value)
+		}
+	case bind.TypeCNAME, bind.TypeTXT, bind.TypePTR:
+	default:
+		return fmt.Errorf("record %s has unsupported type %q", r.Name, r.Type)
+	}
+	return nil
+}
+
+// Close releases the compiled module
+func (p *Plugin) Close(ctx context.Context) error {
+	if err := p.runtime.Close(ctx); err != nil {
+		return fmt.Errorf("closing plugin %s: %w", p.name, err)
+	}
+	return nil
+}
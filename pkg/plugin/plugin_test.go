@@ -0,0 +1,205 @@
+package plugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/tailscale"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Bodies of the transform function of test modules
+var (
+	// echoTransform returns the input as the output
+	echoTransform = []byte{
+		0x20, 0x00, 0xad, 0x42, 0x20, 0x86, // i64.shl(i64.extend_i32_u(local 0), 32)
+		0x20, 0x01, 0xad, 0x84, // i64.or with i64.extend_i32_u(local 1)
+	}
+	// trapTransform traps
+	trapTransform = []byte{0x00}
+	// loopTransform never returns
+	loopTransform = []byte{0x03, 0x40, 0x0c, 0x00, 0x0b, 0x00}
+)
+
+// dataTransform returns the body of a transform function returning the data of the module, which starts at address 0
+func dataTransform(data string) []byte {
+	return append([]byte{0x42}, sleb128(int64(len(data)))...)
+}
+
+// testModule assembles a module exporting its memory, an alloc function returning address 1024 and a transform
+// function with the given body, its memory starting with data
+func testModule(transform []byte, data string) []byte {
+	vec := func(items ...[]byte) []byte {
+		out := uleb128(uint64(len(items)))
+		for _, item := range items {
+			out = append(out, item...)
+		}
+		return out
+	}
+	name := func(s string) []byte {
+		return append(uleb128(uint64(len(s))), s...)
+	}
+	section := func(id byte, content []byte) []byte {
+		return append(append([]byte{id}, uleb128(uint64(len(content)))...), content...)
+	}
+	body := func(code []byte) []byte {
+		code = append(append([]byte{0x00}, code...), 0x0b)
+		return append(uleb128(uint64(len(code))), code...)
+	}
+
+	module := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	module = append(module, section(1, vec(
+		[]byte{0x60, 0x01, 0x7f, 0x01, 0x7f},       // (i32) -> i32
+		[]byte{0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7e}, // (i32, i32) -> i64
+	))...)
+	module = append(module, section(3, vec([]byte{0x00}, []byte{0x01}))...)
+	module = append(module, section(5, vec([]byte{0x00, 0x01}))...)
+	module = append(module, section(7, vec(
+		append(name("memory"), 0x02, 0x00),
+		append(name("alloc"), 0x00, 0x00),
+		append(name("transform"), 0x00, 0x01),
+	))...)
+	module = append(module, section(10, vec(
+		body([]byte{0x41, 0x80, 0x08}), // i32.const 1024
+		body(transform),
+	))...)
+	if data != "" {
+		module = append(module, section(11, vec(append([]byte{0x00, 0x41, 0x00, 0x0b}, name(data)...)))...)
+	}
+	return module
+}
+
+func uleb128(v uint64) []byte {
+	var out []byte
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+func sleb128(v int64) []byte {
+	var out []byte
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && b&0x40 == 0) || (v == -1 && b&0x40 != 0) {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+func TestTransform(t *testing.T) {
+	ctx := context.Background()
+	machines := []tailscale.Machine{{ID: "n1", Name: "laptop", IPv4Address: "100.64.0.1", Online: true}}
+	records := []bind.DNSRecord{
+		{Name: "laptop", Value: "100.64.0.1", TTL: 300, Type: "A"},
+		{Name: "laptop", Value: "owner=alice", TTL: 300, Type: "TXT", Zone: "servers.example.com"},
+	}
+
+	tests := []struct {
+		name      string
+		transform []byte // Returns data when nil
+		data      string
+		want      []bind.DNSRecord
+		wantErr   string
+	}{
+		{
+			name:      "records passed back unchanged",
+			transform: echoTransform,
+			want:      records,
+		},
+		{
+			name: "records replaced",
+			data: `{"records": [{"name": "vpn", "type": "CNAME", "value": "laptop"}]}`,
+			want: []bind.DNSRecord{{Name: "vpn", Value: "laptop", Type: "CNAME"}},
+		},
+		{
+			name: "every record dropped",
+			data: `{"records": []}`,
+			want: []bind.DNSRecord{},
+		},
+		{
+			name:    "error reported",
+			data:    `{"error": "no owner"}`,
+			wantErr: "failed: no owner",
+		},
+		{
+			name:    "no records",
+			data:    `{}`,
+			wantErr: "holds no records",
+		},
+		{
+			name:    "invalid record",
+			data:    `{"records": [{"name": "vpn", "type": "A", "value": "fd7a::1"}]}`,
+			wantErr: "not an IPv4 address",
+		},
+		{
+			name:    "unsupported record type",
+			data:    `{"records": [{"name": "vpn", "type": "MX", "value": "mail"}]}`,
+			wantErr: "unsupported type",
+		},
+		{
+			name:      "trap",
+			transform: trapTransform,
+			wantErr:   "running plugin test.wasm",
+		},
+		{
+			name:      "timeout",
+			transform: loopTransform,
+			wantErr:   "did not finish within 100ms",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transform := tt.transform
+			if transform == nil {
+				transform = dataTransform(tt.data)
+			}
+			plugin, err := New(ctx, "test.wasm", testModule(transform, tt.data), 100*time.Millisecond)
+			require.NoError(t, err)
+			defer plugin.Close(ctx)
+
+			got, err := plugin.Transform(ctx, machines, records)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "echo.wasm")
+	require.NoError(t, os.WriteFile(path, testModule(echoTransform, ""), 0o600))
+
+	plugin, err := Load(ctx, path, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "echo.wasm", plugin.Name())
+	require.NoError(t, plugin.Close(ctx))
+
+	_, err = Load(ctx, filepath.Join(t.TempDir(), "missing.wasm"), time.Second)
+	assert.Error(t, err)
+
+	module := testModule(echoTransform, "")
+	require.NoError(t, os.WriteFile(path, module[:len(module)-1], 0o600))
+	_, err = Load(ctx, path, time.Second)
+	assert.ErrorContains(t, err, "compiling module")
+
+	// Modules must export the plugin functions
+	_, err = New(ctx, "empty.wasm", []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}, time.Second)
+	assert.ErrorContains(t, err, "does not export its memory")
+}
@@ -0,0 +1,468 @@
+package powerdns
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/aauren/tailscale-bind-ddns/pkg/provider"
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// The PowerDNS Authoritative server is updated through its HTTP API rather than RFC 2136, so that it doesn't need
+// dynamic updates enabled. Every zone is read with a single request and only the record sets that differ from what
+// the zone holds are replaced with a PATCH of the zone. Like the bind provider, only record sets this client published
+// itself are ever deleted, and PTR records go to the most specific reverse zone the server hosts.
+//
+// Every record set published carries a comment of account tailscale-bind-ddns naming the bind.owner_id of the instance
+// that published it, when set, the devices it was published for and its version, with the time as its modification
+// time. Record sets with a comment naming our owner count as published by this client, so that those left behind
+// before a restart are deleted once they are no longer desired. Record sets with a comment naming another owner are
+// never touched, and neither are record sets without the comment, e.g. records created by hand, unless
+// adopt_unmarked is set, which replaces them with the comment added.
+
+const requestTimeout = 30 * time.Second
+
+// Client publishes records through the HTTP API of a PowerDNS Authoritative server
+type Client struct {
+	apiURL      string // Base URL of the API without a trailing slash
+	apiKey      string
+	serverID    string
+	zone        string // Main forward zone, without a trailing dot
+	removeStale bool
+
+	// Owner named in the comments of our record sets, and whether record sets without the comment are taken over
+	ownerID       string
+	adoptUnmarked bool
+
+	httpClient *http.Client
+
+	// Clock, result hook, update loop and the records of every zone as of its last successful update
+	provider.Updater
+
+	// Finds the device records were published for, recorded in their comments
+	devicesMu sync.Mutex
+	devices   bind.DeviceLookup
+
+	// Serializes updates so that each is computed against the records the previous one left behind
+	updateMu sync.Mutex
+}
+
+// Types of the PowerDNS API
+type (
+	apiZone struct {
+		Name   string  `json:"name"`
+		RRsets []rrset `json:"rrsets,omitempty"`
+	}
+
+	rrset struct {
+		Name       string       `json:"name"`
+		Type       string       `json:"type"`
+		TTL        uint32       `json:"ttl,omitempty"`
+		ChangeType string       `json:"changetype,omitempty"`
+		Records    []apiRecord  `json:"records,omitempty"`
+		Comments   []apiComment `json:"comments,omitempty"`
+	}
+
+	apiRecord struct {
+		Content  string `json:"content"`
+		Disabled bool   `json:"disabled"`
+	}
+
+	apiComment struct {
+		Content    string `json:"content"`
+		Account    string `json:"account"`
+		ModifiedAt int64  `json:"modified_at,omitempty"`
+	}
+
+	apiError struct {
+		Error string `json:"error"`
+	}
+)
+
+// NewClientFromConfig creates a client for the PowerDNS API, publishing to the zones of the bind configuration
+func NewClientFromConfig(cfg *config.PowerDNSConfig, bindCfg *config.BindConfig) (*Client, error) {
+	if cfg.APIURL == "" {
+		return nil, fmt.Errorf("API URL is required")
+	}
+	if cfg.APIKey == "" {
+		return nil, fmt.Errorf("API key is required")
+	}
+	if bindCfg.Zone == "" {
+		return nil, fmt.Errorf("zone is required")
+	}
+
+	serverID := cfg.ServerID
+	if serverID == "" {
+		serverID = config.DefaultPowerDNSServerID
+	}
+	return &Client{
+		apiURL:        strings.TrimSuffix(cfg.APIURL, "/"),
+		apiKey:        cfg.APIKey,
+		serverID:      serverID,
+		zone:          strings.TrimSuffix(bindCfg.Zone, "."),
+		removeStale:   bindCfg.RemoveStale,
+		ownerID:       bindCfg.OwnerID,
+		adoptUnmarked: cfg.AdoptUnmarked,
+		httpClient:    &http.Client{Timeout: requestTimeout},
+	}, nil
+}
+
+// SetDeviceLookup sets how the device a record was published for is found, recorded in the comments of its record set
+func (c *Client) SetDeviceLookup(lookup bind.DeviceLookup) {
+	c.devicesMu.Lock()
+	defer c.devicesMu.Unlock()
+
+	c.devices = lookup
+}
+
+// ValidateConnection checks that the API accepts the key and hosts the main zone
+func (c *Client) ValidateConnection(ctx context.Context) error {
+	klog.V(1).Infof("Validating connection to the PowerDNS API at %s", c.apiURL)
+
+	var zone apiZone
+	if err := c.do(ctx, http.MethodGet, zonePath(c.zone)+"?rrsets=false", nil, &zone); err != nil {
+		return fmt.Errorf("connection test failed: %w", err)
+	}
+
+	klog.V(1).Info("Successfully validated connection to the PowerDNS API")
+	return nil
+}
+
+// UpdateRecords brings the zones of the records to the desired records and returns the outcome of the update of every
+// zone. The returned error joins the errors of the failed zones, the result is returned in either case.
+func (c *Client) UpdateRecords(ctx context.Context, records []bind.DNSRecord, dryRun bool) (*bind.SyncResult, error) {
+	clk := c.Clock()
+	result := &bind.SyncResult{Started: clk.Now(), DryRun: dryRun}
+	defer func() { result.Duration = clk.Since(result.Started) }()
+
+	if dryRun {
+		klog.Infof("DRY RUN: Would update %d DNS records through the PowerDNS API", len(records))
+		for _, record := range records {
+			klog.V(1).Infof("DRY RUN: Would create/update %s record %s -> %s (TTL: %d)", record.Key().Type,
+				record.Name, record.Value, record.TTL)
+		}
+		return result, nil
+	}
+
+	c.updateMu.Lock()
+	defer c.updateMu.Unlock()
+
+	var hosted []apiZone
+	if err := c.do(ctx, http.MethodGet, "/zones", nil, &hosted); err != nil {
+		return result, fmt.Errorf("listing zones: %w", err)
+	}
+
+	recordsByZone := make(map[string][]bind.DNSRecord)
+	for _, record := range records {
+		zone := c.recordZone(record, hosted)
+		if zone == "" {
+			result.Skipped = append(result.Skipped, bind.SkippedRecord{Record: record, Reason: bind.SkipOutsideZones})
+			continue
+		}
+		recordsByZone[zone] = append(recordsByZone[zone], record)
+	}
+
+	// Zones that no longer have any desired records may still hold stale records of ours
+	if c.removeStale {
+		for _, zone := range c.PublishedZones() {
+			if _, ok := recordsByZone[zone]; !ok {
+				recordsByZone[zone] = nil
+			}
+		}
+	}
+
+	var errs []error
+	for _, zone := range slices.Sorted(maps.Keys(recordsByZone)) {
+		zoneResult := c.updateZone(ctx, zone, recordsByZone[zone])
+		if err := zoneResult.Err(); err != nil {
+			errs = append(errs, fmt.Errorf("zone %s: %w", zone, err))
+		}
+		result.Zones = append(result.Zones, zoneResult)
+	}
+
+	if len(errs) > 0 {
+		klog.Errorf("%d of %d zone updates failed", len(errs), len(recordsByZone))
+	}
+	return result, errors.Join(errs...)
+}
+
+// recordZone returns the zone a record is published in, without a trailing dot. PTR records go to the most specific
+// zone the server hosts, empty when it hosts none holding their name.
+func (c *Client) recordZone(record bind.DNSRecord, hosted []apiZone) string {
+	if record.Key().Type != bind.TypePTR {
+		if record.Zone != "" {
+			return strings.TrimSuffix(record.Zone, ".")
+		}
+		return c.zone
+	}
+
+	name, best := dns.CanonicalName(record.Name), ""
+	for _, zone := range hosted {
+		zoneName := dns.CanonicalName(zone.Name)
+		if dns.IsSubDomain(zoneName, name) && dns.CountLabel(zoneName) > dns.CountLabel(best) {
+			best = zoneName
+		}
+	}
+	return strings.TrimSuffix(best, ".")
+}
+
+// updateZone replaces the record sets of a zone that differ from the desired records and deletes the ones published
+// earlier that are no longer desired
+func (c *Client) updateZone(ctx context.Context, zone string, desired []bind.DNSRecord) bind.ZoneResult {
+	clk := c.Clock()
+	started := clk.Now()
+	result := bind.ZoneResult{Zone: zone, Rcode: bind.NoResponse, Records: len(desired)}
+	finish := func(err error) bind.ZoneResult {
+		result.Duration = clk.Since(started)
+		if err != nil {
+			result.SetErr(err)
+			return result
+		}
+		result.Rcode = dns.RcodeSuccess
+		return result
+	}
+
+	var current apiZone
+	if err := c.do(ctx, http.MethodGet, zonePath(zone), nil, &current); err != nil {
+		return finish(fmt.Errorf("reading zone: %w", err))
+	}
+	existing := make(map[bind.RecordKey]rrset, len(current.RRsets))
+	for _, set := range current.RRsets {
+		existing[bind.RecordKey{Type: bind.RecordType(set.Type), Name: dns.CanonicalName(set.Name)}] = set
+	}
+
+	var patch []rrset
+	sets, keys := provider.GroupRecords(zone, desired)
+	published := slices.Clone(desired)
+	now := clk.Now()
+	for _, key := range keys {
+		set := sets[key]
+		server, exists := existing[key]
+		owner, marked := commentOwner(server)
+		reason := ""
+		switch {
+		case marked && owner != c.ownerID:
+			klog.Warningf("Not publishing %s record %s: the record set is owned by another instance", key.Type,
+				key.Name)
+			reason = bind.SkipForeignOwner
+		case marked && rrsetMatches(server, set):
+			klog.V(1).Infof("Skipping %s record %s: the server already holds it", key.Type, key.Name)
+			reason = bind.SkipAlreadyApplied
+		case exists && !marked && !c.adoptUnmarked:
+			klog.Warningf("Not publishing %s record %s: the server holds the record set without our comment", key.Type,
+				key.Name)
+			reason = bind.SkipUnowned
+		case exists && !marked:
+			klog.V(1).Infof("Adopting %s record %s: the server holds it without our comment", key.Type, key.Name)
+		}
+		if reason != "" {
+			for _, record := range set.Records {
+				result.Skipped = append(result.Skipped, bind.SkippedRecord{Record: record, Reason: reason})
+			}
+			// Record sets of others never become ours, so they must not be remembered as published either
+			if reason != bind.SkipAlreadyApplied {
+				published = slices.DeleteFunc(published, func(record bind.DNSRecord) bool {
+					return slices.Contains(set.Records, record)
+				})
+			}
+			continue
+		}
+		patch = append(patch, replacement(key, set, server, c.comment(set.Records, now)))
+		result.Sent += len(set.Records)
+	}
+
+	if c.removeStale {
+		// Records of ours are those published since the start and those whose comment marks them as ours
+		previous, _ := provider.GroupRecords(zone, c.PublishedRecords(zone))
+		stale := make(map[bind.RecordKey]int, len(previous))
+		for key, set := range previous {
+			stale[key] = len(set.Records)
+		}
+		for key, set := range existing {
+			if _, ok := stale[key]; !ok && c.owned(set) {
+				stale[key] = len(set.Records)
+			}
+		}
+		for _, key := range slices.SortedFunc(maps.Keys(stale), provider.CompareKeys) {
+			if _, ok := sets[key]; ok {
+				continue
+			}
+			// Only record sets still carrying our comment are deleted, others may have been taken over since
+			if set, ok := existing[key]; !ok || !c.owned(set) {
+				continue
+			}
+			patch = append(patch, rrset{Name: key.Name, Type: string(key.Type), ChangeType: "DELETE"})
+			result.Removed += stale[key]
+		}
+	}
+
+	if len(patch) > 0 {
+		klog.V(1).Infof("Sending %d added or changed records to zone %s, removing %d stale records", result.Sent, zone,
+			result.Removed)
+		if err := c.do(ctx, http.MethodPatch, zonePath(zone), apiZone{RRsets: patch}, nil); err != nil {
+			result.Sent, result.Removed = 0, 0
+			return finish(fmt.Errorf("updating zone: %w", err))
+		}
+	} else {
+		klog.V(1).Infof("Zone %s is up to date", zone)
+	}
+
+	c.MarkPublished(zone, published)
+	return finish(nil)
+}
+
+// replacement returns the change replacing the record set on the server with s, marked with comment. The comments of
+// others on the existing record set are kept.
+func replacement(key bind.RecordKey, s provider.RecordSet, existing rrset, comment apiComment) rrset {
+	set := rrset{Name: key.Name, Type: string(key.Type), TTL: s.TTL, ChangeType: "REPLACE"}
+	for _, content := range s.Contents {
+		set.Records = append(set.Records, apiRecord{Content: content})
+	}
+	for _, other := range existing.Comments {
+		if other.Account != bind.Owner {
+			set.Comments = append(set.Comments, other)
+		}
+	}
+	set.Comments = append(set.Comments, comment)
+	return set
+}
+
+// comment returns the comment marking a record set of records as ours, naming the devices they were published for
+func (c *Client) comment(records []bind.DNSRecord, now time.Time) apiComment {
+	c.devicesMu.Lock()
+	lookup := c.devices
+	c.devicesMu.Unlock()
+
+	var devices []string
+	if lookup != nil {
+		for _, record := range records {
+			if device := lookup(record); device != "" && !slices.Contains(devices, device) {
+				devices = append(devices, device)
+			}
+		}
+	}
+
+	var fields []string
+	if c.ownerID != "" {
+		fields = append(fields, ownerField+c.ownerID)
+	}
+	if len(devices) > 0 {
+		fields = append(fields, "devices="+strings.Join(devices, ","))
+	}
+	fields = append(fields, "version="+bind.Version)
+	return apiComment{Content: strings.Join(fields, " "), Account: bind.Owner, ModifiedAt: now.Unix()}
+}
+
+// ownerField precedes the owner ID in the content of our comments
+const ownerField = "owner="
+
+// commentOwner returns the owner named by the comment of account tailscale-bind-ddns of a record set, empty when the
+// comment names none, and whether the record set carries such a comment
+func commentOwner(set rrset) (string, bool) {
+	for _, comment := range set.Comments {
+		if comment.Account != bind.Owner {
+			continue
+		}
+		for _, field := range strings.Fields(comment.Content) {
+			if owner, ok := strings.CutPrefix(field, ownerField); ok {
+				return owner, true
+			}
+		}
+		return "", true
+	}
+	return "", false
+}
+
+// owned reports whether a record set on the server carries the comment marking it as published by this client
+func (c *Client) owned(set rrset) bool {
+	owner, marked := commentOwner(set)
+	return marked && owner == c.ownerID
+}
+
+// rrsetMatches reports whether a record set on the server holds exactly the desired contents with the desired TTL
+func rrsetMatches(existing rrset, desired provider.RecordSet) bool {
+	contents := make([]string, 0, len(existing.Records))
+	for _, record := range existing.Records {
+		if record.Disabled {
+			return false
+		}
+		contents = append(contents, record.Content)
+	}
+	return desired.Matches(existing.Name, existing.Type, existing.TTL, contents)
+}
+
+// zonePath returns the API path of a zone, whose ID is its canonical name
+func zonePath(zone string) string {
+	return "/zones/" + url.PathEscape(dns.CanonicalName(zone))
+}
+
+// do sends a request to the API of the configured server and decodes the JSON response into out, if it's not nil
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("encoding request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	endpoint := c.apiURL + "/api/v1/servers/" + url.PathEscape(c.serverID) + path
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("X-API-Key", c.apiKey)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr apiError
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(data, &apiErr) != nil || apiErr.Error == "" {
+			apiErr.Error = strings.TrimSpace(string(data))
+		}
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, apiErr.Error)
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding response of %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// StartUpdating publishes record sets received on recordChan until the context is cancelled or the channel is closed.
+// A record set that fails is retried every update interval until it succeeds or a newer one arrives.
+func (c *Client) StartUpdating(
+	ctx context.Context,
+	updateInterval time.Duration,
+	recordChan <-chan []bind.DNSRecord,
+	dryRun bool,
+) {
+	c.Run(ctx, "PowerDNS", updateInterval, recordChan, dryRun, c.UpdateRecords)
+}
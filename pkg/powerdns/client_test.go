@@ -0,0 +1,301 @@
+package powerdns
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testAPIKey = "test-api-key"
+
+// fakeAPI is a PowerDNS API serving zones from memory and recording every PATCH it receives
+type fakeAPI struct {
+	mu      sync.Mutex
+	zones   map[string][]rrset
+	patches []apiZone
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-API-Key") != testAPIKey {
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(apiError{Error: "Unauthorized"})
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path, ok := strings.CutPrefix(r.URL.Path, "/api/v1/servers/localhost/zones")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if path == "" {
+		var zones []apiZone
+		for name := range f.zones {
+			zones = append(zones, apiZone{Name: name})
+		}
+		_ = json.NewEncoder(w).Encode(zones)
+		return
+	}
+
+	name := strings.TrimPrefix(path, "/")
+	sets, ok := f.zones[name]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(apiError{Error: "Could not find domain '" + name + "'"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		_ = json.NewEncoder(w).Encode(apiZone{Name: name, RRsets: sets})
+	case http.MethodPatch:
+		var patch apiZone
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.patches = append(f.patches, patch)
+		for _, change := range patch.RRsets {
+			sets = slices.DeleteFunc(sets, func(set rrset) bool {
+				return set.Name == change.Name && set.Type == change.Type
+			})
+			if change.ChangeType == "REPLACE" {
+				change.ChangeType = ""
+				sets = append(sets, change)
+			}
+		}
+		f.zones[name] = sets
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// changes returns the changes of the PATCH requests received so far, as "<changetype> <type> <name>"
+func (f *fakeAPI) changes() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var changes []string
+	for _, patch := range f.patches {
+		for _, set := range patch.RRsets {
+			changes = append(changes, set.ChangeType+" "+set.Type+" "+set.Name)
+		}
+	}
+	f.patches = nil
+	return changes
+}
+
+func newTestClient(t *testing.T, api *fakeAPI, apiKey string) *Client {
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	client, err := NewClientFromConfig(
+		&config.PowerDNSConfig{APIURL: server.URL + "/", APIKey: apiKey},
+		&config.BindConfig{Zone: "ts.example.com", RemoveStale: true},
+	)
+	require.NoError(t, err)
+	return client
+}
+
+func TestNewClientFromConfig(t *testing.T) {
+	_, err := NewClientFromConfig(&config.PowerDNSConfig{APIKey: "key"}, &config.BindConfig{Zone: "ts.example.com"})
+	assert.Error(t, err)
+
+	_, err = NewClientFromConfig(&config.PowerDNSConfig{APIURL: "http://127.0.0.1:8081"},
+		&config.BindConfig{Zone: "ts.example.com"})
+	assert.Error(t, err)
+
+	client, err := NewClientFromConfig(&config.PowerDNSConfig{APIURL: "http://127.0.0.1:8081/", APIKey: "key"},
+		&config.BindConfig{Zone: "ts.example.com."})
+	require.NoError(t, err)
+	assert.Equal(t, "http://127.0.0.1:8081", client.apiURL)
+	assert.Equal(t, config.DefaultPowerDNSServerID, client.serverID)
+	assert.Equal(t, "ts.example.com", client.zone)
+}
+
+func TestValidateConnection(t *testing.T) {
+	api := &fakeAPI{zones: map[string][]rrset{"ts.example.com.": nil}}
+	ctx := context.Background()
+
+	require.NoError(t, newTestClient(t, api, testAPIKey).ValidateConnection(ctx))
+
+	err := newTestClient(t, api, "wrong-key").ValidateConnection(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "401 Unauthorized: Unauthorized")
+
+	delete(api.zones, "ts.example.com.")
+	err = newTestClient(t, api, testAPIKey).ValidateConnection(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Could not find domain")
+}
+
+func TestUpdateRecords(t *testing.T) {
+	api := &fakeAPI{zones: map[string][]rrset{
+		"ts.example.com.": {
+			// Records managed by hand are never touched
+			{Name: "manual.ts.example.com.", Type: "A", TTL: 300, Records: []apiRecord{{Content: "192.0.2.1"}}},
+			// Already up to date, written the way the server normalizes it
+			{
+				Name: "laptop.ts.example.com.", Type: "AAAA", TTL: 300, Records: []apiRecord{{Content: "fd7a:115c::1"}},
+				Comments: []apiComment{{Content: "version=dev", Account: bind.Owner}},
+			},
+		},
+		"in-addr.arpa.":        nil,
+		"64.100.in-addr.arpa.": nil,
+	}}
+	client := newTestClient(t, api, testAPIKey)
+	ctx := context.Background()
+
+	records := []bind.DNSRecord{
+		{Name: "laptop", Value: "100.64.0.1", TTL: 300, Type: "A"},
+		{Name: "laptop", Value: "fd7a:115c:0:0::1", TTL: 300, Type: "AAAA"},
+		{Name: "laptop", Value: "os=linux", TTL: 300, Type: "TXT"},
+		{Name: "server", Value: "100.64.0.2", TTL: 60, Type: "A"},
+		{Name: "1.0.64.100.in-addr.arpa.", Value: "laptop.ts.example.com", TTL: 300, Type: "PTR"},
+		{Name: "1.0.0.10.in-addr.arpa.", Value: "laptop.ts.example.com", TTL: 300, Type: "PTR"},
+		{Name: "1.2.0.192.ip6.arpa.", Value: "laptop.ts.example.com", TTL: 300, Type: "PTR"},
+	}
+	result, err := client.UpdateRecords(ctx, records, false)
+	require.NoError(t, err)
+
+	// PTR records go to the most specific reverse zone, records outside of every zone are skipped
+	assert.ElementsMatch(t, []string{
+		"REPLACE PTR 1.0.64.100.in-addr.arpa.",
+		"REPLACE PTR 1.0.0.10.in-addr.arpa.",
+		"REPLACE A laptop.ts.example.com.",
+		"REPLACE TXT laptop.ts.example.com.",
+		"REPLACE A server.ts.example.com.",
+	}, api.changes())
+	assert.Equal(t, []bind.SkippedRecord{{Record: records[6], Reason: bind.SkipOutsideZones}}, result.Skipped)
+	require.Len(t, result.Zones, 3)
+	assert.Equal(t, "64.100.in-addr.arpa", result.Zones[0].Zone)
+	assert.Equal(t, "ts.example.com", result.Zones[2].Zone)
+	assert.Equal(t, 3, result.Zones[2].Sent)
+	assert.Equal(t, []bind.SkippedRecord{{Record: records[1], Reason: bind.SkipAlreadyApplied}}, result.Zones[2].Skipped)
+	assert.Zero(t, result.Failed())
+
+	txt := slices.IndexFunc(api.zones["ts.example.com."], func(set rrset) bool { return set.Type == "TXT" })
+	assert.Equal(t, `"os=linux"`, api.zones["ts.example.com."][txt].Records[0].Content)
+
+	// Unchanged records aren't sent again and records of ours that are gone are deleted, in zones left without any
+	// desired records too
+	_, err = client.UpdateRecords(ctx, records[:3], false)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"DELETE PTR 1.0.64.100.in-addr.arpa.",
+		"DELETE PTR 1.0.0.10.in-addr.arpa.",
+		"DELETE A server.ts.example.com.",
+	}, api.changes())
+
+	_, err = client.UpdateRecords(ctx, records[:4], false)
+	require.NoError(t, err)
+	_, err = client.UpdateRecords(ctx, nil, false)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"REPLACE A server.ts.example.com.",
+		"DELETE A laptop.ts.example.com.",
+		"DELETE AAAA laptop.ts.example.com.",
+		"DELETE TXT laptop.ts.example.com.",
+		"DELETE A server.ts.example.com.",
+	}, api.changes())
+	assert.Len(t, api.zones["ts.example.com."], 1)
+}
+
+func TestUpdateRecordsFailure(t *testing.T) {
+	api := &fakeAPI{zones: map[string][]rrset{"ts.example.com.": nil}}
+	client := newTestClient(t, api, testAPIKey)
+
+	// The zone of the records isn't hosted by the server
+	result, err := client.UpdateRecords(context.Background(), []bind.DNSRecord{
+		{Name: "laptop", Value: "100.64.0.1", TTL: 300, Type: "A", Zone: "servers.example.com"},
+	}, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "zone servers.example.com: reading zone")
+	require.Len(t, result.Zones, 1)
+	assert.Equal(t, 1, result.Failed())
+	assert.Equal(t, bind.NoResponse, result.Zones[0].Rcode)
+}
+
+func TestRecordComments(t *testing.T) {
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	comment := apiComment{Content: "owner=office version=dev", Account: bind.Owner, ModifiedAt: now.Add(-time.Hour).Unix()}
+	api := &fakeAPI{zones: map[string][]rrset{"ts.example.com.": {
+		// Published before a restart and no longer desired
+		{Name: "old.ts.example.com.", Type: "A", TTL: 300, Records: []apiRecord{{Content: "100.64.0.9"}},
+			Comments: []apiComment{comment}},
+		// Published by another instance sharing the zone, desired and no longer desired
+		{Name: "desk.ts.example.com.", Type: "A", TTL: 300, Records: []apiRecord{{Content: "100.64.0.3"}},
+			Comments: []apiComment{{Content: "owner=lab version=dev", Account: bind.Owner}}},
+		{Name: "other.ts.example.com.", Type: "A", TTL: 300, Records: []apiRecord{{Content: "100.64.0.8"}},
+			Comments: []apiComment{{Content: "version=dev", Account: bind.Owner}}},
+		// Holds the desired record without our comment, with a comment of someone else
+		{Name: "laptop.ts.example.com.", Type: "A", TTL: 300, Records: []apiRecord{{Content: "100.64.0.1"}},
+			Comments: []apiComment{{Content: "moved from the old server", Account: "admin"}}},
+		{Name: "manual.ts.example.com.", Type: "A", TTL: 300, Records: []apiRecord{{Content: "192.0.2.1"}}},
+	}}}
+	client := newTestClient(t, api, testAPIKey)
+	client.ownerID = "office"
+	client.SetClock(clock.NewFake(now))
+	client.SetDeviceLookup(func(record bind.DNSRecord) string { return "device-" + record.Name })
+
+	records := []bind.DNSRecord{
+		{Name: "laptop", Value: "100.64.0.1", TTL: 300, Type: "A"},
+		{Name: "server", Value: "100.64.0.2", TTL: 300, Type: "A"},
+		{Name: "desk", Value: "100.64.0.4", TTL: 300, Type: "A"},
+	}
+	result, err := client.UpdateRecords(context.Background(), records, false)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"REPLACE A server.ts.example.com.",
+		"DELETE A old.ts.example.com.",
+	}, api.changes())
+	require.Len(t, result.Zones, 1)
+	assert.ElementsMatch(t, []bind.SkippedRecord{
+		{Record: records[0], Reason: bind.SkipUnowned},
+		{Record: records[2], Reason: bind.SkipForeignOwner},
+	}, result.Zones[0].Skipped)
+
+	sets := make(map[string]rrset)
+	for _, set := range api.zones["ts.example.com."] {
+		sets[set.Name] = set
+	}
+	assert.Equal(t, []apiComment{
+		{Content: "owner=office devices=device-server version=dev", Account: bind.Owner, ModifiedAt: now.Unix()},
+	}, sets["server.ts.example.com."].Comments)
+	assert.Equal(t, "100.64.0.3", sets["desk.ts.example.com."].Records[0].Content)
+	assert.Empty(t, sets["manual.ts.example.com."].Comments)
+
+	// Records carrying our comment are up to date, and the skipped ones are never removed as stale
+	_, err = client.UpdateRecords(context.Background(), records, false)
+	require.NoError(t, err)
+	assert.Empty(t, api.changes())
+	_, err = client.UpdateRecords(context.Background(), records[1:2], false)
+	require.NoError(t, err)
+	assert.Empty(t, api.changes())
+
+	// With adopt_unmarked record sets without our comment are taken over, keeping the comments of others
+	client.adoptUnmarked = true
+	_, err = client.UpdateRecords(context.Background(), records, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"REPLACE A laptop.ts.example.com."}, api.changes())
+	for _, set := range api.zones["ts.example.com."] {
+		sets[set.Name] = set
+	}
+	assert.Equal(t, []apiComment{
+		{Content: "moved from the old server", Account: "admin"},
+		{Content: "owner=office devices=device-laptop version=dev", Account: bind.Owner, ModifiedAt: now.Unix()},
+	}, sets["laptop.ts.example.com."].Comments)
+}
@@ -0,0 +1,135 @@
+// Package provider holds what the clients of the providers publishing through an API or into files, rather than with
+// RFC 2136 dynamic updates, share: their update loop, the records they published in every zone and how desired records
+// are grouped into record sets and compared with the record sets a server holds.
+package provider
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"k8s.io/klog/v2"
+)
+
+// UpdateFunc brings the zones of the records to the desired records, like bind.Client.UpdateRecords
+type UpdateFunc func(ctx context.Context, records []bind.DNSRecord, dryRun bool) (*bind.SyncResult, error)
+
+// Updater is embedded by provider clients. It holds their clock, the hook called with the outcome of every update and
+// the records they published, and runs their update loop.
+type Updater struct {
+	// Clock of update timings and retries, the real clock when nil
+	clock clock.Clock
+
+	// Called with the outcome of every update of Run, see SetResultHook
+	resultHook func(*bind.SyncResult)
+
+	// Records of every zone as of its last update, canonical zone names as keys
+	publishedMu sync.Mutex
+	published   map[string][]bind.DNSRecord
+}
+
+// SetClock replaces the clock of the client, e.g. with a fake one in tests
+func (u *Updater) SetClock(clk clock.Clock) {
+	u.clock = clk
+}
+
+// Clock returns the clock of the client, the real clock unless it was replaced
+func (u *Updater) Clock() clock.Clock {
+	return clock.Or(u.clock)
+}
+
+// SetResultHook sets a function called with the outcome of every update of StartUpdating, e.g. to report how long
+// the zones took
+func (u *Updater) SetResultHook(hook func(*bind.SyncResult)) {
+	u.resultHook = hook
+}
+
+// Run publishes record sets received on recordChan with update until the context is cancelled or the channel is
+// closed. A record set that fails is retried every update interval until it succeeds or a newer one arrives. name
+// names the provider in the log.
+func (u *Updater) Run(
+	ctx context.Context,
+	name string,
+	updateInterval time.Duration,
+	recordChan <-chan []bind.DNSRecord,
+	dryRun bool,
+	update UpdateFunc,
+) {
+	klog.Infof("Starting %s updates with interval %v", name, updateInterval)
+	defer klog.Infof("Stopped %s updates", name)
+
+	var (
+		pending []bind.DNSRecord
+		failed  bool
+		retryC  <-chan time.Time
+	)
+	if updateInterval > 0 {
+		ticker := u.Clock().NewTicker(updateInterval)
+		defer ticker.Stop()
+		retryC = ticker.C()
+	}
+
+	apply := func(records []bind.DNSRecord) {
+		pending = records
+		result, err := update(ctx, records, dryRun)
+		if result != nil && u.resultHook != nil {
+			u.resultHook(result)
+		}
+		failed = err != nil
+		if failed && ctx.Err() == nil {
+			klog.Errorf("Failed to update records, retrying in %v: %v", updateInterval, err)
+		}
+	}
+
+	for {
+		select {
+		case records, ok := <-recordChan:
+			if !ok {
+				return
+			}
+			apply(records)
+		case <-retryC:
+			if failed {
+				klog.Infof("Retrying update of %d records", len(pending))
+				apply(pending)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// MarkPublished records the records of a zone the client owns after an update
+func (u *Updater) MarkPublished(zone string, records []bind.DNSRecord) {
+	u.publishedMu.Lock()
+	defer u.publishedMu.Unlock()
+
+	if len(records) == 0 {
+		delete(u.published, zone)
+		return
+	}
+	if u.published == nil {
+		u.published = make(map[string][]bind.DNSRecord)
+	}
+	u.published[zone] = slices.Clone(records)
+}
+
+// PublishedRecords returns the records the client published in a zone
+func (u *Updater) PublishedRecords(zone string) []bind.DNSRecord {
+	u.publishedMu.Lock()
+	defer u.publishedMu.Unlock()
+
+	return u.published[zone]
+}
+
+// PublishedZones returns the zones the client owns records in
+func (u *Updater) PublishedZones() []string {
+	u.publishedMu.Lock()
+	defer u.publishedMu.Unlock()
+
+	return slices.Sorted(maps.Keys(u.published))
+}
@@ -0,0 +1,102 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunRetries(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	var updater Updater
+	updater.SetClock(clk)
+	var results atomic.Int32
+	updater.SetResultHook(func(*bind.SyncResult) { results.Add(1) })
+
+	attempts := make(chan []bind.DNSRecord, 10)
+	var calls atomic.Int32
+	update := func(_ context.Context, records []bind.DNSRecord, _ bool) (*bind.SyncResult, error) {
+		attempts <- records
+		if calls.Add(1) < 2 {
+			return &bind.SyncResult{}, errors.New("connection refused")
+		}
+		return &bind.SyncResult{}, nil
+	}
+
+	recordChan := make(chan []bind.DNSRecord, 1)
+	records := []bind.DNSRecord{{Name: "laptop", Value: "100.64.0.1", TTL: 300, Type: "A"}}
+	recordChan <- records
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		updater.Run(context.Background(), "test", time.Minute, recordChan, false, update)
+	}()
+
+	// A failed record set is retried with the next tick, and not again once it succeeded
+	assert.Equal(t, records, <-attempts)
+	clk.BlockUntil(1)
+	clk.Advance(time.Minute)
+	assert.Equal(t, records, <-attempts)
+	clk.Advance(time.Minute)
+	close(recordChan)
+	<-done
+	assert.Empty(t, attempts)
+	assert.Equal(t, int32(2), results.Load())
+}
+
+func TestPublished(t *testing.T) {
+	var updater Updater
+	records := []bind.DNSRecord{{Name: "laptop", Value: "100.64.0.1", TTL: 300, Type: "A"}}
+
+	updater.MarkPublished("ts.example.com", records)
+	updater.MarkPublished("64.100.in-addr.arpa", records)
+	assert.Equal(t, records, updater.PublishedRecords("ts.example.com"))
+	assert.Equal(t, []string{"64.100.in-addr.arpa", "ts.example.com"}, updater.PublishedZones())
+
+	updater.MarkPublished("64.100.in-addr.arpa", nil)
+	assert.Equal(t, []string{"ts.example.com"}, updater.PublishedZones())
+}
+
+func TestGroupRecords(t *testing.T) {
+	records := []bind.DNSRecord{
+		{Name: "Laptop", Value: "100.64.0.1", TTL: 300, Type: "A"},
+		{Name: "server", Value: "100.64.0.2", TTL: 60, Type: "A"},
+		{Name: "laptop", Value: "100.64.0.3", TTL: 300, Type: "A"},
+		{Name: "laptop", Value: "fd7a:115c:a1e0:0:0::1", TTL: 300, Type: "AAAA"},
+		{Name: "broken", Value: "not an address", TTL: 300, Type: "A"},
+	}
+	sets, keys := GroupRecords("ts.example.com", records)
+
+	// Names are canonical and records with an invalid value are left out
+	assert.Equal(t, []bind.RecordKey{
+		{Type: bind.TypeA, Name: "laptop.ts.example.com."},
+		{Type: bind.TypeA, Name: "server.ts.example.com."},
+		{Type: bind.TypeAAAA, Name: "laptop.ts.example.com."},
+	}, keys)
+	laptop := sets[keys[0]]
+	assert.Equal(t, []string{"100.64.0.1", "100.64.0.3"}, laptop.Contents)
+	assert.Equal(t, []bind.DNSRecord{records[0], records[2]}, laptop.Records)
+
+	// Record data compares equal however the server writes it, in any order
+	assert.True(t, laptop.Matches("laptop.ts.example.com.", "A", 300, []string{"100.64.0.3", "100.64.0.1"}))
+	assert.False(t, laptop.Matches("laptop.ts.example.com.", "A", 60, []string{"100.64.0.3", "100.64.0.1"}))
+	assert.False(t, laptop.Matches("laptop.ts.example.com.", "A", 300, []string{"100.64.0.1"}))
+	require.Contains(t, sets, keys[2])
+	assert.True(t, sets[keys[2]].Matches("laptop.ts.example.com.", "AAAA", 300, []string{"fd7a:115c:a1e0::1"}))
+}
+
+func TestCompareKeys(t *testing.T) {
+	a := bind.RecordKey{Type: bind.TypeA, Name: "a.ts.example.com."}
+	aaaa := bind.RecordKey{Type: bind.TypeAAAA, Name: "a.ts.example.com."}
+	b := bind.RecordKey{Type: bind.TypeA, Name: "b.ts.example.com."}
+	assert.Negative(t, CompareKeys(a, aaaa))
+	assert.Negative(t, CompareKeys(aaaa, b))
+	assert.Zero(t, CompareKeys(a, a))
+}
@@ -0,0 +1,80 @@
+package provider
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// RecordSet is the desired content of a record set
+type RecordSet struct {
+	TTL      uint32
+	Contents []string // Record data in presentation format
+	Records  []bind.DNSRecord
+}
+
+// GroupRecords groups records into record sets keyed by their canonical name, returning the keys in the order they
+// first appear. Records with an invalid value are logged and left out.
+func GroupRecords(zone string, records []bind.DNSRecord) (map[bind.RecordKey]RecordSet, []bind.RecordKey) {
+	sets := make(map[bind.RecordKey]RecordSet)
+	var keys []bind.RecordKey
+	for _, record := range records {
+		rr := record.RR(zone)
+		if rr == nil {
+			klog.Warningf("Skipping %s record %s: invalid value %q", record.Key().Type, record.Name, record.Value)
+			continue
+		}
+
+		key := bind.RecordKey{Type: record.Key().Type, Name: dns.CanonicalName(rr.Header().Name)}
+		set, ok := sets[key]
+		if !ok {
+			keys = append(keys, key)
+			set.TTL = record.TTL
+		}
+		set.Contents = append(set.Contents, RRContent(rr))
+		set.Records = append(set.Records, record)
+		sets[key] = set
+	}
+	return sets, keys
+}
+
+// Matches reports whether a record set on a server, with its name, type, TTL and the data of its records, holds
+// exactly the desired contents with the desired TTL
+func (s RecordSet) Matches(name, rrtype string, ttl uint32, contents []string) bool {
+	if ttl != s.TTL || len(contents) != len(s.Contents) {
+		return false
+	}
+	for _, content := range contents {
+		if !slices.Contains(s.Contents, NormalizeContent(name, rrtype, ttl, content)) {
+			return false
+		}
+	}
+	return true
+}
+
+// NormalizeContent parses the data of a record on a server and prints it the way desired contents are printed, so
+// that e.g. differently written IPv6 addresses compare equal
+func NormalizeContent(name, rrtype string, ttl uint32, content string) string {
+	rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", name, ttl, rrtype, content))
+	if err != nil || rr == nil {
+		return content
+	}
+	return RRContent(rr)
+}
+
+// RRContent returns the record data of an RR in presentation format, which is how the APIs take record data
+func RRContent(rr dns.RR) string {
+	return strings.TrimPrefix(rr.String(), rr.Header().String())
+}
+
+// CompareKeys orders record keys by name and type
+func CompareKeys(a, b bind.RecordKey) int {
+	if a.Name != b.Name {
+		return strings.Compare(a.Name, b.Name)
+	}
+	return strings.Compare(string(a.Type), string(b.Type))
+}
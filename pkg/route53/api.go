@@ -0,0 +1,245 @@
+package route53
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"k8s.io/klog/v2"
+)
+
+// The Route53 API is a REST API with XML bodies, requests are signed with AWS Signature Version 4. Only the handful of
+// calls the provider needs are implemented here rather than pulling in the whole service SDK.
+
+const (
+	apiVersion  = "2013-04-01"
+	signingName = "route53"
+
+	// Throttled requests are retried up to maxAttempts times in total, waiting retryDelay times the attempt in between
+	maxAttempts = 3
+	retryDelay  = time.Second
+)
+
+// Types of the Route53 API
+type (
+	hostedZone struct {
+		ID      string `xml:"Id"`
+		Name    string `xml:"Name"`
+		Private bool   `xml:"Config>PrivateZone"`
+	}
+
+	listHostedZonesResponse struct {
+		HostedZones []hostedZone `xml:"HostedZones>HostedZone"`
+		IsTruncated bool         `xml:"IsTruncated"`
+		NextMarker  string       `xml:"NextMarker"`
+	}
+
+	resourceRecordSet struct {
+		Name          string           `xml:"Name"`
+		Type          string           `xml:"Type"`
+		SetIdentifier string           `xml:"SetIdentifier,omitempty"`
+		TTL           uint32           `xml:"TTL,omitempty"`
+		Records       []resourceRecord `xml:"ResourceRecords>ResourceRecord,omitempty"`
+		AliasTarget   *struct{}        `xml:"AliasTarget,omitempty"`
+	}
+
+	resourceRecord struct {
+		Value string `xml:"Value"`
+	}
+
+	listResourceRecordSetsResponse struct {
+		RecordSets           []resourceRecordSet `xml:"ResourceRecordSets>ResourceRecordSet"`
+		IsTruncated          bool                `xml:"IsTruncated"`
+		NextRecordName       string              `xml:"NextRecordName"`
+		NextRecordType       string              `xml:"NextRecordType"`
+		NextRecordIdentifier string              `xml:"NextRecordIdentifier"`
+	}
+
+	change struct {
+		Action    string            `xml:"Action"`
+		RecordSet resourceRecordSet `xml:"ResourceRecordSet"`
+	}
+
+	changeResourceRecordSetsRequest struct {
+		XMLName xml.Name `xml:"https://route53.amazonaws.com/doc/2013-04-01/ ChangeResourceRecordSetsRequest"`
+		Comment string   `xml:"ChangeBatch>Comment,omitempty"`
+		Changes []change `xml:"ChangeBatch>Changes>Change"`
+	}
+
+	changeResourceRecordSetsResponse struct {
+		ID     string `xml:"ChangeInfo>Id"`
+		Status string `xml:"ChangeInfo>Status"`
+	}
+
+	// apiError is the body of a failed request, an ErrorResponse or, for rejected change batches, InvalidChangeBatch
+	apiError struct {
+		Code     string   `xml:"Error>Code"`
+		Message  string   `xml:"Error>Message"`
+		Messages []string `xml:"Messages>Message"`
+	}
+)
+
+// retryable reports whether a failed request may succeed when sent again a little later
+func (e apiError) retryable(status int) bool {
+	return status >= 500 || e.Code == "Throttling" || e.Code == "PriorRequestNotComplete"
+}
+
+// String returns the code and the messages of the error
+func (e apiError) String() string {
+	messages := e.Messages
+	if e.Message != "" {
+		messages = append([]string{e.Message}, messages...)
+	}
+	if e.Code == "" {
+		return strings.Join(messages, "; ")
+	}
+	return e.Code + ": " + strings.Join(messages, "; ")
+}
+
+// hostedZoneID strips the /hostedzone/ prefix the API returns zone IDs with
+func hostedZoneID(id string) string {
+	return strings.TrimPrefix(id, "/hostedzone/")
+}
+
+// listHostedZones returns every hosted zone of the account
+func (c *Client) listHostedZones(ctx context.Context) ([]hostedZone, error) {
+	var zones []hostedZone
+	query := url.Values{"maxitems": {"100"}}
+	for {
+		var page listHostedZonesResponse
+		if err := c.do(ctx, http.MethodGet, "/hostedzone?"+query.Encode(), nil, &page); err != nil {
+			return nil, err
+		}
+		zones = append(zones, page.HostedZones...)
+		if !page.IsTruncated || page.NextMarker == "" {
+			return zones, nil
+		}
+		query.Set("marker", page.NextMarker)
+	}
+}
+
+// listRecordSets returns every record set of a hosted zone
+func (c *Client) listRecordSets(ctx context.Context, zoneID string) ([]resourceRecordSet, error) {
+	var sets []resourceRecordSet
+	query := url.Values{"maxitems": {"300"}}
+	for {
+		var page listResourceRecordSetsResponse
+		path := "/hostedzone/" + url.PathEscape(zoneID) + "/rrset?" + query.Encode()
+		if err := c.do(ctx, http.MethodGet, path, nil, &page); err != nil {
+			return nil, err
+		}
+		sets = append(sets, page.RecordSets...)
+		if !page.IsTruncated {
+			return sets, nil
+		}
+		query.Set("name", page.NextRecordName)
+		query.Set("type", page.NextRecordType)
+		query.Del("identifier")
+		if page.NextRecordIdentifier != "" {
+			query.Set("identifier", page.NextRecordIdentifier)
+		}
+	}
+}
+
+// changeRecordSets sends a batch of changes to a hosted zone, which Route53 applies all together or not at all. Record
+// sets can't carry metadata, so the version sending them is only recorded in the comment of the batch, which shows in
+// CloudTrail.
+func (c *Client) changeRecordSets(ctx context.Context, zoneID string, changes []change) error {
+	comment := fmt.Sprintf("%s version=%s", bind.Owner, bind.Version)
+	request := changeResourceRecordSetsRequest{Comment: comment, Changes: changes}
+	var response changeResourceRecordSetsResponse
+	path := "/hostedzone/" + url.PathEscape(zoneID) + "/rrset"
+	if err := c.do(ctx, http.MethodPost, path, request, &response); err != nil {
+		return err
+	}
+	klog.V(2).Infof("Route53 accepted %d changes to hosted zone %s as change %s (%s)", len(changes), zoneID,
+		hostedZoneID(response.ID), response.Status)
+	return nil
+}
+
+// do sends a signed request to the Route53 API and decodes the XML response into out, if it's not nil. Throttled
+// requests and server errors are retried.
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var data []byte
+	if body != nil {
+		encoded, err := xml.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("encoding request: %w", err)
+		}
+		data = append([]byte(xml.Header), encoded...)
+	}
+
+	clk := c.Clock()
+	for attempt := 1; ; attempt++ {
+		status, apiErr, err := c.send(ctx, method, path, data, out)
+		if err == nil || apiErr == nil || !apiErr.retryable(status) || attempt == maxAttempts {
+			return err
+		}
+
+		delay := time.Duration(attempt) * retryDelay
+		klog.V(1).Infof("Route53 request %s %s failed, retrying in %v: %v", method, path, delay, err)
+		timer := clk.NewTimer(delay)
+		select {
+		case <-timer.C():
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		}
+	}
+}
+
+// send sends a request once, returning the status and the error the API responded with when it failed
+func (c *Client) send(ctx context.Context, method, path string, body []byte, out any) (int, *apiError, error) {
+	endpoint := c.endpoint + "/" + apiVersion + path
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, fmt.Errorf("creating request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/xml")
+	}
+
+	credentials, err := c.credentials.Retrieve(ctx)
+	if err != nil {
+		return 0, nil, fmt.Errorf("retrieving AWS credentials: %w", err)
+	}
+	payloadHash := sha256.Sum256(body)
+	err = c.signer.SignHTTP(ctx, credentials, req, hex.EncodeToString(payloadHash[:]), signingName, c.region,
+		c.Clock().Now())
+	if err != nil {
+		return 0, nil, fmt.Errorf("signing request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr apiError
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if xml.Unmarshal(data, &apiErr) != nil || apiErr.String() == "" {
+			apiErr = apiError{Message: strings.TrimSpace(string(data))}
+		}
+		return resp.StatusCode, &apiErr, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, apiErr)
+	}
+
+	if out == nil {
+		return resp.StatusCode, nil, nil
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(out); err != nil {
+		return resp.StatusCode, nil, fmt.Errorf("decoding response of %s %s: %w", method, path, err)
+	}
+	return resp.StatusCode, nil, nil
+}
@@ -0,0 +1,369 @@
+package route53
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/aauren/tailscale-bind-ddns/pkg/provider"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// Records are published into Route53 hosted zones, usually private ones so that only the associated VPCs resolve the
+// tailnet. Every zone is read once per update and only the record sets that differ are sent, as UPSERT changes in
+// batches of ChangeResourceRecordSets requests. Like the other providers, only record sets this client published
+// itself are ever deleted, and PTR records go to the most specific reverse zone that is hosted.
+
+const requestTimeout = 30 * time.Second
+
+// Client publishes records into the hosted zones of Route53
+type Client struct {
+	endpoint    string // API URL without a trailing slash
+	region      string // Region requests are signed for
+	zone        string // Main forward zone, without a trailing dot
+	removeStale bool
+	batchSize   int
+
+	// IDs of hosted zones configured by hand, canonical zone names as keys
+	zoneIDs map[string]string
+
+	credentials aws.CredentialsProvider
+	signer      *v4.Signer
+	httpClient  *http.Client
+
+	// Clock of update timings, request signatures and retries, result hook, update loop and the records of every zone
+	// as of its last update
+	provider.Updater
+
+	// Serializes updates so that each is computed against the records the previous one left behind
+	updateMu sync.Mutex
+}
+
+// NewClientFromConfig creates a client for Route53, publishing to the zones of the bind configuration
+func NewClientFromConfig(cfg *config.Route53Config, bindCfg *config.BindConfig) (*Client, error) {
+	if bindCfg.Zone == "" {
+		return nil, fmt.Errorf("zone is required")
+	}
+
+	creds, err := newCredentials(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = config.DefaultRoute53Endpoint
+	}
+	batchSize := cfg.BatchSize
+	if batchSize <= 0 {
+		batchSize = config.DefaultRoute53BatchSize
+	}
+	zoneIDs := make(map[string]string, len(cfg.HostedZoneIDs))
+	for zone, id := range cfg.HostedZoneIDs {
+		zoneIDs[dns.CanonicalName(zone)] = hostedZoneID(id)
+	}
+
+	return &Client{
+		endpoint:    strings.TrimSuffix(endpoint, "/"),
+		region:      signingRegion(cfg),
+		zone:        strings.TrimSuffix(bindCfg.Zone, "."),
+		removeStale: bindCfg.RemoveStale,
+		batchSize:   batchSize,
+		zoneIDs:     zoneIDs,
+		credentials: creds,
+		signer:      v4.NewSigner(),
+		httpClient:  &http.Client{Timeout: requestTimeout},
+	}, nil
+}
+
+// ValidateConnection checks that the credentials are accepted and that a hosted zone of the main zone exists
+func (c *Client) ValidateConnection(ctx context.Context) error {
+	klog.V(1).Infof("Validating connection to Route53 at %s", c.endpoint)
+
+	hosted, err := c.hostedZones(ctx)
+	if err != nil {
+		return fmt.Errorf("connection test failed: %w", err)
+	}
+	if _, err := hostedZoneFor(hosted, c.zone); err != nil {
+		return fmt.Errorf("connection test failed: %w", err)
+	}
+
+	klog.V(1).Info("Successfully validated connection to Route53")
+	return nil
+}
+
+// UpdateRecords brings the zones of the records to the desired records and returns the outcome of the update of every
+// zone. The returned error joins the errors of the failed zones, the result is returned in either case.
+func (c *Client) UpdateRecords(ctx context.Context, records []bind.DNSRecord, dryRun bool) (*bind.SyncResult, error) {
+	clk := c.Clock()
+	result := &bind.SyncResult{Started: clk.Now(), DryRun: dryRun}
+	defer func() { result.Duration = clk.Since(result.Started) }()
+
+	if dryRun {
+		klog.Infof("DRY RUN: Would update %d DNS records in Route53", len(records))
+		for _, record := range records {
+			klog.V(1).Infof("DRY RUN: Would create/update %s record %s -> %s (TTL: %d)", record.Key().Type,
+				record.Name, record.Value, record.TTL)
+		}
+		return result, nil
+	}
+
+	c.updateMu.Lock()
+	defer c.updateMu.Unlock()
+
+	hosted, err := c.hostedZones(ctx)
+	if err != nil {
+		return result, fmt.Errorf("listing hosted zones: %w", err)
+	}
+
+	recordsByZone := make(map[string][]bind.DNSRecord)
+	for _, record := range records {
+		zone := c.recordZone(record, hosted)
+		if zone == "" {
+			result.Skipped = append(result.Skipped, bind.SkippedRecord{Record: record, Reason: bind.SkipOutsideZones})
+			continue
+		}
+		recordsByZone[zone] = append(recordsByZone[zone], record)
+	}
+
+	// Zones that no longer have any desired records may still hold stale records of ours
+	if c.removeStale {
+		for _, zone := range c.PublishedZones() {
+			if _, ok := recordsByZone[zone]; !ok {
+				recordsByZone[zone] = nil
+			}
+		}
+	}
+
+	var errs []error
+	for _, zone := range slices.Sorted(maps.Keys(recordsByZone)) {
+		zoneResult := c.updateZone(ctx, zone, hosted, recordsByZone[zone])
+		if err := zoneResult.Err(); err != nil {
+			errs = append(errs, fmt.Errorf("zone %s: %w", zone, err))
+		}
+		result.Zones = append(result.Zones, zoneResult)
+	}
+
+	if len(errs) > 0 {
+		klog.Errorf("%d of %d zone updates failed", len(errs), len(recordsByZone))
+	}
+	return result, errors.Join(errs...)
+}
+
+// hostedZones returns the hosted zones of the account by canonical name, several for names hosted more than once.
+// Zones with a configured ID are only listed with that ID.
+func (c *Client) hostedZones(ctx context.Context) (map[string][]hostedZone, error) {
+	zones, err := c.listHostedZones(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	hosted := make(map[string][]hostedZone, len(zones))
+	for _, zone := range zones {
+		name, id := dns.CanonicalName(zone.Name), hostedZoneID(zone.ID)
+		if configured, ok := c.zoneIDs[name]; ok && configured != id {
+			continue
+		}
+		zone.ID = id
+		hosted[name] = append(hosted[name], zone)
+	}
+	return hosted, nil
+}
+
+// hostedZoneFor returns the hosted zone records of a zone are published in. Private hosted zones are preferred over
+// public ones, and a name with several hosted zones of the same kind needs its ID configured.
+func hostedZoneFor(hosted map[string][]hostedZone, zone string) (hostedZone, error) {
+	candidates := hosted[dns.CanonicalName(zone)]
+	private := slices.DeleteFunc(slices.Clone(candidates), func(z hostedZone) bool { return !z.Private })
+	if len(private) > 0 {
+		candidates = private
+	}
+	switch len(candidates) {
+	case 0:
+		return hostedZone{}, fmt.Errorf("no hosted zone named %s", zone)
+	case 1:
+		return candidates[0], nil
+	default:
+		return hostedZone{}, fmt.Errorf("several hosted zones named %s, set the one to use in hosted_zone_ids", zone)
+	}
+}
+
+// recordZone returns the zone a record is published in, without a trailing dot. PTR records go to the most specific
+// hosted zone, empty when none holds their name.
+func (c *Client) recordZone(record bind.DNSRecord, hosted map[string][]hostedZone) string {
+	if record.Key().Type != bind.TypePTR {
+		if record.Zone != "" {
+			return strings.TrimSuffix(record.Zone, ".")
+		}
+		return c.zone
+	}
+
+	name, best := dns.CanonicalName(record.Name), ""
+	for zoneName := range hosted {
+		if dns.IsSubDomain(zoneName, name) && dns.CountLabel(zoneName) > dns.CountLabel(best) {
+			best = zoneName
+		}
+	}
+	return strings.TrimSuffix(best, ".")
+}
+
+// updateZone upserts the record sets of a zone that differ from the desired records and deletes the ones published
+// earlier that are no longer desired
+func (c *Client) updateZone(
+	ctx context.Context,
+	zone string,
+	hosted map[string][]hostedZone,
+	desired []bind.DNSRecord,
+) bind.ZoneResult {
+	clk := c.Clock()
+	started := clk.Now()
+	result := bind.ZoneResult{Zone: zone, Rcode: bind.NoResponse, Records: len(desired)}
+	finish := func(err error) bind.ZoneResult {
+		result.Duration = clk.Since(started)
+		if err != nil {
+			result.SetErr(err)
+			return result
+		}
+		result.Rcode = dns.RcodeSuccess
+		return result
+	}
+
+	target, err := hostedZoneFor(hosted, zone)
+	if err != nil {
+		return finish(err)
+	}
+	current, err := c.listRecordSets(ctx, target.ID)
+	if err != nil {
+		return finish(fmt.Errorf("reading hosted zone %s: %w", target.ID, err))
+	}
+	existing := make(map[bind.RecordKey]resourceRecordSet, len(current))
+	for _, set := range current {
+		// Record sets with a routing policy aren't ours, they can't be matched by name and type alone
+		if set.SetIdentifier == "" {
+			existing[bind.RecordKey{Type: bind.RecordType(set.Type), Name: dns.CanonicalName(set.Name)}] = set
+		}
+	}
+
+	// Deletions go first, so that a name can change its type within the same batch
+	var deletions, upserts []pendingChange
+	sets, keys := provider.GroupRecords(zone, desired)
+	if c.removeStale {
+		previous, _ := provider.GroupRecords(zone, c.PublishedRecords(zone))
+		for _, key := range slices.SortedFunc(maps.Keys(previous), provider.CompareKeys) {
+			set, ok := existing[key]
+			if _, desired := sets[key]; desired || !ok || set.AliasTarget != nil {
+				continue
+			}
+			deletions = append(deletions, pendingChange{
+				change:  change{Action: "DELETE", RecordSet: set},
+				records: len(previous[key].Records),
+			})
+		}
+	}
+	for _, key := range keys {
+		set := sets[key]
+		if recordSetMatches(existing[key], set) {
+			klog.V(1).Infof("Skipping %s record %s: the hosted zone already holds it", key.Type, key.Name)
+			for _, record := range set.Records {
+				result.Skipped = append(result.Skipped, bind.SkippedRecord{
+					Record: record, Reason: bind.SkipAlreadyApplied,
+				})
+			}
+			continue
+		}
+		upserts = append(upserts, pendingChange{change: upsert(key, set), records: len(set.Records)})
+	}
+
+	changes := append(deletions, upserts...)
+	if len(changes) == 0 {
+		klog.V(1).Infof("Zone %s is up to date", zone)
+		c.MarkPublished(zone, desired)
+		return finish(nil)
+	}
+
+	klog.V(1).Infof("Sending %d changes to hosted zone %s (%s) in batches of up to %d", len(changes), target.ID,
+		zone, c.batchSize)
+	for batch := range slices.Chunk(changes, c.batchSize) {
+		batchChanges := make([]change, 0, len(batch))
+		for _, pending := range batch {
+			batchChanges = append(batchChanges, pending.change)
+		}
+		if err := c.changeRecordSets(ctx, target.ID, batchChanges); err != nil {
+			// Earlier batches were applied. Records of ours that may still be there are kept as published, so that
+			// they're deleted later on.
+			c.MarkPublished(zone, mergeRecords(desired, c.PublishedRecords(zone)))
+			return finish(fmt.Errorf("updating hosted zone %s: %w", target.ID, err))
+		}
+		for _, pending := range batch {
+			if pending.change.Action == "DELETE" {
+				result.Removed += pending.records
+			} else {
+				result.Sent += pending.records
+			}
+		}
+	}
+
+	c.MarkPublished(zone, desired)
+	return finish(nil)
+}
+
+// pendingChange is a change of an update along with the number of records it covers
+type pendingChange struct {
+	change  change
+	records int
+}
+
+// upsert returns the change creating or replacing the record set with s
+func upsert(key bind.RecordKey, s provider.RecordSet) change {
+	set := resourceRecordSet{Name: key.Name, Type: string(key.Type), TTL: s.TTL}
+	for _, content := range s.Contents {
+		set.Records = append(set.Records, resourceRecord{Value: content})
+	}
+	return change{Action: "UPSERT", RecordSet: set}
+}
+
+// mergeRecords returns the records of a followed by those of b whose name and type a doesn't have
+func mergeRecords(a, b []bind.DNSRecord) []bind.DNSRecord {
+	merged := slices.Clone(a)
+	for _, record := range b {
+		if !slices.ContainsFunc(a, func(r bind.DNSRecord) bool { return r.Key() == record.Key() }) {
+			merged = append(merged, record)
+		}
+	}
+	return merged
+}
+
+// recordSetMatches reports whether a record set of the hosted zone holds exactly the desired contents with the
+// desired TTL
+func recordSetMatches(existing resourceRecordSet, desired provider.RecordSet) bool {
+	if existing.AliasTarget != nil {
+		return false
+	}
+	values := make([]string, 0, len(existing.Records))
+	for _, record := range existing.Records {
+		values = append(values, record.Value)
+	}
+	return desired.Matches(existing.Name, existing.Type, existing.TTL, values)
+}
+
+// StartUpdating publishes record sets received on recordChan until the context is cancelled or the channel is closed.
+// A record set that fails is retried every update interval until it succeeds or a newer one arrives.
+func (c *Client) StartUpdating(
+	ctx context.Context,
+	updateInterval time.Duration,
+	recordChan <-chan []bind.DNSRecord,
+	dryRun bool,
+) {
+	c.Run(ctx, "Route53", updateInterval, recordChan, dryRun, c.UpdateRecords)
+}
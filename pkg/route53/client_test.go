@@ -0,0 +1,284 @@
+package route53
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testAccessKeyID = "AKIDEXAMPLE"
+
+// fakeAPI is a Route53 API serving hosted zones from memory and recording every change batch it receives
+type fakeAPI struct {
+	mu       sync.Mutex
+	zones    []hostedZone
+	sets     map[string][]resourceRecordSet // By hosted zone ID
+	batches  [][]change
+	comments []string // Comments of the change batches
+	throttle int      // Number of requests still answered with a throttling error
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential="+testAccessKeyID+"/") ||
+		!strings.Contains(r.Header.Get("Authorization"), "/us-east-1/route53/aws4_request") {
+		writeError(w, http.StatusForbidden, "InvalidClientTokenId",
+			"The security token included in the request is invalid")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.throttle > 0 {
+		f.throttle--
+		writeError(w, http.StatusBadRequest, "Throttling", "Rate exceeded")
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/2013-04-01/hostedzone")
+	if path == "" {
+		writeXML(w, listHostedZonesResponse{HostedZones: f.zones})
+		return
+	}
+
+	id, ok := strings.CutSuffix(strings.TrimPrefix(path, "/"), "/rrset")
+	if _, hosted := f.sets[id]; !ok || !hosted {
+		writeError(w, http.StatusNotFound, "NoSuchHostedZone", "No hosted zone found with ID: "+id)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeXML(w, listResourceRecordSetsResponse{RecordSets: f.sets[id]})
+	case http.MethodPost:
+		var request changeResourceRecordSetsRequest
+		if err := xml.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeError(w, http.StatusBadRequest, "InvalidInput", err.Error())
+			return
+		}
+		f.batches = append(f.batches, request.Changes)
+		f.comments = append(f.comments, request.Comment)
+		for _, change := range request.Changes {
+			f.sets[id] = slices.DeleteFunc(f.sets[id], func(set resourceRecordSet) bool {
+				return set.Name == change.RecordSet.Name && set.Type == change.RecordSet.Type
+			})
+			if change.Action == "UPSERT" {
+				f.sets[id] = append(f.sets[id], change.RecordSet)
+			}
+		}
+		writeXML(w, changeResourceRecordSetsResponse{ID: "/change/C1", Status: "PENDING"})
+	}
+}
+
+func writeXML(w http.ResponseWriter, body any) {
+	w.Header().Set("Content-Type", "text/xml")
+	_ = xml.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, `<ErrorResponse xmlns="https://route53.amazonaws.com/doc/2013-04-01/">`+
+		`<Error><Type>Sender</Type><Code>%s</Code><Message>%s</Message></Error></ErrorResponse>`, code, message)
+}
+
+// changes returns the changes of the batches received so far, as "<action> <type> <name>" per batch
+func (f *fakeAPI) changes() [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var batches [][]string
+	for _, batch := range f.batches {
+		var changes []string
+		for _, change := range batch {
+			changes = append(changes, change.Action+" "+change.RecordSet.Type+" "+change.RecordSet.Name)
+		}
+		batches = append(batches, changes)
+	}
+	f.batches = nil
+	return batches
+}
+
+func newTestClient(t *testing.T, api *fakeAPI, cfg config.Route53Config) *Client {
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	cfg.Endpoint = server.URL + "/"
+	if cfg.AccessKeyID == "" {
+		cfg.AccessKeyID, cfg.SecretAccessKey = testAccessKeyID, "secret"
+	}
+	client, err := NewClientFromConfig(&cfg, &config.BindConfig{Zone: "ts.example.com", RemoveStale: true})
+	require.NoError(t, err)
+	return client
+}
+
+func TestNewClientFromConfig(t *testing.T) {
+	_, err := NewClientFromConfig(&config.Route53Config{}, &config.BindConfig{})
+	assert.Error(t, err)
+
+	client, err := NewClientFromConfig(&config.Route53Config{
+		AccessKeyID:     testAccessKeyID,
+		SecretAccessKey: "secret",
+		HostedZoneIDs:   map[string]string{"TS.example.com": "/hostedzone/Z1"},
+	}, &config.BindConfig{Zone: "ts.example.com."})
+	require.NoError(t, err)
+	assert.Equal(t, config.DefaultRoute53Endpoint, client.endpoint)
+	assert.Equal(t, config.DefaultRoute53Region, client.region)
+	assert.Equal(t, config.DefaultRoute53BatchSize, client.batchSize)
+	assert.Equal(t, "ts.example.com", client.zone)
+	assert.Equal(t, map[string]string{"ts.example.com.": "Z1"}, client.zoneIDs)
+
+	credentials, err := client.credentials.Retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, testAccessKeyID, credentials.AccessKeyID)
+}
+
+func TestValidateConnection(t *testing.T) {
+	ctx := context.Background()
+	api := &fakeAPI{zones: []hostedZone{
+		{ID: "/hostedzone/ZPUBLIC", Name: "ts.example.com."},
+		{ID: "/hostedzone/ZPRIVATE1", Name: "ts.example.com.", Private: true},
+	}}
+
+	// Private hosted zones win over public ones of the same name
+	client := newTestClient(t, api, config.Route53Config{})
+	require.NoError(t, client.ValidateConnection(ctx))
+	hosted, err := client.hostedZones(ctx)
+	require.NoError(t, err)
+	zone, err := hostedZoneFor(hosted, "ts.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "ZPRIVATE1", zone.ID)
+
+	// Several private hosted zones of the same name need the ID configured
+	api.zones = append(api.zones, hostedZone{ID: "/hostedzone/ZPRIVATE2", Name: "ts.example.com.", Private: true})
+	err = client.ValidateConnection(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "several hosted zones named ts.example.com")
+	zoneIDs := map[string]string{"ts.example.com": "ZPRIVATE2"}
+	client = newTestClient(t, api, config.Route53Config{HostedZoneIDs: zoneIDs})
+	require.NoError(t, client.ValidateConnection(ctx))
+
+	err = newTestClient(t, api, config.Route53Config{AccessKeyID: "AKIDWRONG", SecretAccessKey: "secret"}).
+		ValidateConnection(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "403 Forbidden: InvalidClientTokenId: The security token included")
+
+	api.zones = nil
+	err = client.ValidateConnection(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no hosted zone named ts.example.com")
+}
+
+func TestUpdateRecords(t *testing.T) {
+	api := &fakeAPI{
+		zones: []hostedZone{
+			{ID: "/hostedzone/ZFORWARD", Name: "ts.example.com.", Private: true},
+			{ID: "/hostedzone/ZREVERSE", Name: "64.100.in-addr.arpa.", Private: true},
+		},
+		sets: map[string][]resourceRecordSet{
+			"ZFORWARD": {
+				// Records managed by hand are never touched
+				{Name: "manual.ts.example.com.", Type: "A", TTL: 300, Records: []resourceRecord{{Value: "192.0.2.1"}}},
+				// Already up to date, written differently
+				{
+					Name: "laptop.ts.example.com.", Type: "AAAA", TTL: 300,
+					Records: []resourceRecord{{Value: "fd7a:115c:0:0::1"}},
+				},
+			},
+			"ZREVERSE": nil,
+		},
+	}
+	client := newTestClient(t, api, config.Route53Config{BatchSize: 2})
+	ctx := context.Background()
+
+	records := []bind.DNSRecord{
+		{Name: "laptop", Value: "100.64.0.1", TTL: 300, Type: "A"},
+		{Name: "laptop", Value: "fd7a:115c::1", TTL: 300, Type: "AAAA"},
+		{Name: "laptop", Value: "os=linux", TTL: 300, Type: "TXT"},
+		{Name: "server", Value: "100.64.0.2", TTL: 60, Type: "A"},
+		{Name: "1.0.64.100.in-addr.arpa.", Value: "laptop.ts.example.com", TTL: 300, Type: "PTR"},
+		{Name: "1.2.0.192.ip6.arpa.", Value: "laptop.ts.example.com", TTL: 300, Type: "PTR"},
+	}
+	result, err := client.UpdateRecords(ctx, records, false)
+	require.NoError(t, err)
+
+	// Changes are sent in batches, PTR records go to the reverse zone and records outside of every zone are skipped
+	assert.Equal(t, [][]string{
+		{"UPSERT PTR 1.0.64.100.in-addr.arpa."},
+		{"UPSERT A laptop.ts.example.com.", "UPSERT TXT laptop.ts.example.com."},
+		{"UPSERT A server.ts.example.com."},
+	}, api.changes())
+	assert.Equal(t, "tailscale-bind-ddns version=dev", api.comments[0])
+	assert.Equal(t, []bind.SkippedRecord{{Record: records[5], Reason: bind.SkipOutsideZones}}, result.Skipped)
+	require.Len(t, result.Zones, 2)
+	assert.Equal(t, "ts.example.com", result.Zones[1].Zone)
+	assert.Equal(t, 3, result.Zones[1].Sent)
+	assert.Equal(t, []bind.SkippedRecord{{Record: records[1], Reason: bind.SkipAlreadyApplied}}, result.Zones[1].Skipped)
+	assert.Zero(t, result.Failed())
+
+	txt := slices.IndexFunc(api.sets["ZFORWARD"], func(set resourceRecordSet) bool { return set.Type == "TXT" })
+	assert.Equal(t, `"os=linux"`, api.sets["ZFORWARD"][txt].Records[0].Value)
+
+	// Unchanged records aren't sent again and records of ours that are gone are deleted, before anything is upserted
+	records[3].TTL = 120
+	_, err = client.UpdateRecords(ctx, records[1:4], false)
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"DELETE PTR 1.0.64.100.in-addr.arpa."},
+		{"DELETE A laptop.ts.example.com.", "UPSERT A server.ts.example.com."},
+	}, api.changes())
+
+	_, err = client.UpdateRecords(ctx, nil, false)
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{
+		"DELETE AAAA laptop.ts.example.com.", "DELETE TXT laptop.ts.example.com.",
+	}, {
+		"DELETE A server.ts.example.com.",
+	}}, api.changes())
+	assert.Len(t, api.sets["ZFORWARD"], 1)
+}
+
+func TestUpdateRecordsThrottled(t *testing.T) {
+	api := &fakeAPI{
+		zones: []hostedZone{{ID: "/hostedzone/ZFORWARD", Name: "ts.example.com."}},
+		sets:  map[string][]resourceRecordSet{"ZFORWARD": nil},
+	}
+	client := newTestClient(t, api, config.Route53Config{})
+	clk := clock.NewFake(time.Now())
+	client.SetClock(clk)
+
+	// Throttled requests are retried after a while
+	api.throttle = 1
+	done := make(chan error)
+	records := []bind.DNSRecord{{Name: "laptop", Value: "100.64.0.1", TTL: 300, Type: "A"}}
+	go func() {
+		_, err := client.UpdateRecords(context.Background(), records, false)
+		done <- err
+	}()
+	clk.BlockUntil(1)
+	clk.Advance(retryDelay)
+	require.NoError(t, <-done)
+	assert.Len(t, api.changes(), 1)
+
+	// Other errors fail the zone right away
+	result, err := client.UpdateRecords(context.Background(), []bind.DNSRecord{
+		{Name: "laptop", Value: "100.64.0.1", TTL: 300, Type: "A", Zone: "servers.example.com"},
+	}, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "zone servers.example.com: no hosted zone named servers.example.com")
+	require.Len(t, result.Zones, 2)
+	assert.Equal(t, 1, result.Failed())
+	assert.Equal(t, bind.NoResponse, result.Zones[0].Rcode)
+}
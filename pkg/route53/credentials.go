@@ -0,0 +1,53 @@
+package route53
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// newCredentials returns the credentials requests are signed with: static keys when configured and the default chain
+// of the AWS SDK otherwise, optionally exchanged for the credentials of an assumed role. Nothing is fetched until the
+// first request, credentials that expire are refreshed in time.
+func newCredentials(cfg *config.Route53Config) (aws.CredentialsProvider, error) {
+	var options []func(*awsconfig.LoadOptions) error
+	if cfg.Profile != "" {
+		options = append(options, awsconfig.WithSharedConfigProfile(cfg.Profile))
+	}
+	if cfg.AccessKeyID != "" {
+		options = append(options, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(cfg.AccessKeyID, cfg.SecretAccessKey, "")))
+	}
+
+	awsCfg, err := awsconfig.LoadDefaultConfig(context.Background(), options...)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS configuration: %w", err)
+	}
+	if cfg.RoleARN == "" {
+		return awsCfg.Credentials, nil
+	}
+
+	// STS is regional, roles are assumed in the region of the environment and where requests are signed otherwise
+	if awsCfg.Region == "" {
+		awsCfg.Region = signingRegion(cfg)
+	}
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsCfg), cfg.RoleARN,
+		func(options *stscreds.AssumeRoleOptions) {
+			options.RoleSessionName = "tailscale-bind-ddns"
+		})
+	return aws.NewCredentialsCache(provider), nil
+}
+
+// signingRegion returns the region requests are signed for
+func signingRegion(cfg *config.Route53Config) string {
+	if cfg.Region == "" {
+		return config.DefaultRoute53Region
+	}
+	return cfg.Region
+}
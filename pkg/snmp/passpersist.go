@@ -0,0 +1,159 @@
+// Package snmp answers the requests of net-snmp's snmpd for a subtree of OIDs over the pass_persist protocol, so that
+// values can be monitored over SNMP without running an agent of our own.
+//
+// snmpd starts the command configured with
+//
+//	pass_persist .1.3.6.1.4.1.8072.9999.9999.1 /usr/bin/tailscale-bind-ddns snmp-pass-persist
+//
+// once and writes requests to its stdin, one line per field: PING is answered with PONG, get and getnext followed by an
+// OID with the OID, type and value of the variable, or NONE when there is none, and set with not-writable as every
+// variable is read-only. An empty line or the end of the input ends the session.
+package snmp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Types of the variables as pass_persist names them
+const (
+	TypeInteger = "integer"
+	TypeGauge   = "gauge"
+	TypeCounter = "counter"
+	TypeString  = "string"
+)
+
+// Variable is a value published under an OID
+type Variable struct {
+	OID   string
+	Type  string
+	Value string
+}
+
+// Source returns the variables to answer a request from. An error answers the request with NONE, as though the
+// subtree was empty, so that snmpd reports the variables as missing instead of hanging.
+type Source func() ([]Variable, error)
+
+// PassPersist answers the requests snmpd writes to in on out until the session ends. Only failures to read or write
+// end it early.
+func PassPersist(in io.Reader, out io.Writer, source Source) error {
+	scanner := bufio.NewScanner(in)
+	next := func() (string, bool) {
+		if !scanner.Scan() {
+			return "", false
+		}
+		return strings.TrimSpace(scanner.Text()), true
+	}
+
+	for {
+		command, ok := next()
+		if !ok || command == "" {
+			return scanner.Err()
+		}
+
+		var reply []string
+		switch strings.ToLower(command) {
+		case "ping":
+			reply = []string{"PONG"}
+		case "get", "getnext":
+			oid, ok := next()
+			if !ok {
+				return scanner.Err()
+			}
+			reply = answer(source, oid, strings.EqualFold(command, "getnext"))
+		case "set":
+			// The OID and the typed value follow
+			for range 2 {
+				if _, ok := next(); !ok {
+					return scanner.Err()
+				}
+			}
+			reply = []string{"not-writable"}
+		default:
+			reply = []string{"NONE"}
+		}
+
+		if _, err := io.WriteString(out, strings.Join(reply, "\n")+"\n"); err != nil {
+			return fmt.Errorf("answering snmpd: %w", err)
+		}
+	}
+}
+
+// answer looks the variable at oid, or the first one following it, up in the variables of source
+func answer(source Source, oid string, getNext bool) []string {
+	requested, err := parseOID(oid)
+	if err != nil {
+		return []string{"NONE"}
+	}
+	variables, err := source()
+	if err != nil {
+		return []string{"NONE"}
+	}
+
+	type parsedVariable struct {
+		oid []int
+		Variable
+	}
+	parsed := make([]parsedVariable, 0, len(variables))
+	for _, variable := range variables {
+		if variableOID, err := parseOID(variable.OID); err == nil {
+			parsed = append(parsed, parsedVariable{oid: variableOID, Variable: variable})
+		}
+	}
+	slices.SortFunc(parsed, func(a, b parsedVariable) int { return slices.Compare(a.oid, b.oid) })
+
+	for _, variable := range parsed {
+		order := slices.Compare(variable.oid, requested)
+		if (getNext && order > 0) || (!getNext && order == 0) {
+			return []string{formatOID(variable.oid), variable.Type, variable.Value}
+		}
+	}
+	return []string{"NONE"}
+}
+
+// parseOID parses a numeric OID, with or without the leading dot
+func parseOID(oid string) ([]int, error) {
+	oid = strings.TrimPrefix(oid, ".")
+	if oid == "" {
+		return nil, fmt.Errorf("empty OID")
+	}
+	parts := strings.Split(oid, ".")
+	parsed := make([]int, 0, len(parts))
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid OID %q", oid)
+		}
+		parsed = append(parsed, n)
+	}
+	return parsed, nil
+}
+
+// formatOID formats a parsed OID with a leading dot, as snmpd expects it
+func formatOID(oid []int) string {
+	var b strings.Builder
+	for _, n := range oid {
+		b.WriteByte('.')
+		b.WriteString(strconv.Itoa(n))
+	}
+	return b.String()
+}
+
+// JoinOID appends sub-identifiers to an OID
+func JoinOID(oid string, ids ...int) string {
+	oid = "." + strings.Trim(oid, ".")
+	for _, id := range ids {
+		oid += "." + strconv.Itoa(id)
+	}
+	return oid
+}
+
+// ValidateOID checks that an OID is numeric, e.g. .1.3.6.1.4.1.8072.9999.9999.1
+func ValidateOID(oid string) error {
+	_, err := parseOID(oid)
+	return err
+}
@@ -0,0 +1,96 @@
+package snmp
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPassPersist(t *testing.T) {
+	variables := []Variable{
+		{OID: ".1.3.6.1.4.1.8072.9999.9999.1.10", Type: TypeGauge, Value: "10"},
+		{OID: ".1.3.6.1.4.1.8072.9999.9999.1.2", Type: TypeCounter, Value: "2"},
+		{OID: "1.3.6.1.4.1.8072.9999.9999.1.1", Type: TypeString, Value: "one"},
+	}
+	source := func() ([]Variable, error) { return variables, nil }
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "ping",
+			input: "PING\n",
+			want:  "PONG\n",
+		},
+		{
+			name:  "get",
+			input: "get\n.1.3.6.1.4.1.8072.9999.9999.1.2\n",
+			want:  ".1.3.6.1.4.1.8072.9999.9999.1.2\ncounter\n2\n",
+		},
+		{
+			name:  "get of a missing variable",
+			input: "get\n.1.3.6.1.4.1.8072.9999.9999.1.3\n",
+			want:  "NONE\n",
+		},
+		{
+			name:  "getnext of the subtree",
+			input: "getnext\n.1.3.6.1.4.1.8072.9999.9999.1\n",
+			want:  ".1.3.6.1.4.1.8072.9999.9999.1.1\nstring\none\n",
+		},
+		{
+			name:  "getnext compares OIDs numerically",
+			input: "getnext\n.1.3.6.1.4.1.8072.9999.9999.1.2\n",
+			want:  ".1.3.6.1.4.1.8072.9999.9999.1.10\ngauge\n10\n",
+		},
+		{
+			name:  "getnext past the last variable",
+			input: "getnext\n.1.3.6.1.4.1.8072.9999.9999.1.10\n",
+			want:  "NONE\n",
+		},
+		{
+			name:  "set",
+			input: "set\n.1.3.6.1.4.1.8072.9999.9999.1.2\ncounter 5\n",
+			want:  "not-writable\n",
+		},
+		{
+			name:  "invalid OID",
+			input: "get\n.1.3.six\n",
+			want:  "NONE\n",
+		},
+		{
+			name:  "several requests until an empty line",
+			input: "PING\nget\n.1.3.6.1.4.1.8072.9999.9999.1.1\n\nPING\n",
+			want:  "PONG\n.1.3.6.1.4.1.8072.9999.9999.1.1\nstring\none\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			require.NoError(t, PassPersist(strings.NewReader(tt.input), &out, source))
+			assert.Equal(t, tt.want, out.String())
+		})
+	}
+
+	// Variables that can't be read are missing
+	var out bytes.Buffer
+	failing := func() ([]Variable, error) { return nil, errors.New("daemon unreachable") }
+	require.NoError(t, PassPersist(strings.NewReader("getnext\n.1\n"), &out, failing))
+	assert.Equal(t, "NONE\n", out.String())
+}
+
+func TestJoinOID(t *testing.T) {
+	assert.Equal(t, ".1.3.6.1.4.1.8072.9999.9999.1.5", JoinOID("1.3.6.1.4.1.8072.9999.9999.1.", 5))
+	assert.Equal(t, ".1.3.6", JoinOID(".1.3.6"))
+
+	assert.NoError(t, ValidateOID(".1.3.6.1.4.1.8072.9999.9999.1"))
+	assert.Error(t, ValidateOID(""))
+	assert.Error(t, ValidateOID(".1.3.-6"))
+	assert.Error(t, ValidateOID("iso.3.6"))
+}
@@ -0,0 +1,77 @@
+package tailscale
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+)
+
+// parseAddressRanges parses tailscale.address_ranges, expanding tailscale to the Tailscale ranges. It returns no ranges
+// when any address is accepted.
+func parseAddressRanges(ranges []string) ([]netip.Prefix, error) {
+	if slices.Contains(ranges, config.AddressRangesAny) {
+		return nil, nil
+	}
+
+	var prefixes []netip.Prefix
+	for _, r := range ranges {
+		if r == config.AddressRangesTailscale {
+			prefixes = append(prefixes, config.TailscaleRanges...)
+			continue
+		}
+		prefix, err := netip.ParsePrefix(r)
+		if err != nil {
+			return nil, fmt.Errorf("parsing address range %q: %w", r, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// setAddresses sets the IPv4 and IPv6 addresses of a machine from the addresses a device reports. IPv4-mapped IPv6
+// addresses count as IPv4 addresses, invalid and zoned addresses, which can't be published, are ignored.
+func (m *Machine) setAddresses(addresses []string) {
+	for _, address := range addresses {
+		addr, err := netip.ParseAddr(address)
+		if err != nil || addr.Zone() != "" {
+			continue
+		}
+		addr = addr.Unmap()
+		if addr.Is4() {
+			m.IPv4Addresses = append(m.IPv4Addresses, addr.String())
+		} else {
+			m.IPv6Addresses = append(m.IPv6Addresses, addr.String())
+		}
+	}
+	m.setFirstAddresses()
+}
+
+// keepAddresses drops the addresses of a machine outside the given ranges and returns them
+func (m *Machine) keepAddresses(ranges []netip.Prefix) []string {
+	var dropped []string
+	outside := func(address string) bool {
+		addr, err := netip.ParseAddr(address)
+		if err == nil && slices.ContainsFunc(ranges, func(prefix netip.Prefix) bool { return prefix.Contains(addr) }) {
+			return false
+		}
+		dropped = append(dropped, address)
+		return true
+	}
+	m.IPv4Addresses = slices.DeleteFunc(m.IPv4Addresses, outside)
+	m.IPv6Addresses = slices.DeleteFunc(m.IPv6Addresses, outside)
+	m.setFirstAddresses()
+	return dropped
+}
+
+// setFirstAddresses sets IPv4Address and IPv6Address to the first address of each family
+func (m *Machine) setFirstAddresses() {
+	m.IPv4Address, m.IPv6Address = "", ""
+	if len(m.IPv4Addresses) > 0 {
+		m.IPv4Address = m.IPv4Addresses[0]
+	}
+	if len(m.IPv6Addresses) > 0 {
+		m.IPv6Address = m.IPv6Addresses[0]
+	}
+}
@@ -0,0 +1,97 @@
+package tailscale
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+	tailscaleclient "tailscale.com/client/tailscale/v2"
+)
+
+const (
+	// AttributeDNSName overrides the name a device's records are published under
+	AttributeDNSName = "custom:dns-name"
+	// AttributeDNSTTL overrides the TTL of a device's records, either in seconds or as a Go duration (e.g. "5m")
+	AttributeDNSTTL = "custom:dns-ttl"
+
+	devicesReadScope           = "devices:core:read"
+	postureAttributesReadScope = "devices:posture_attributes:read"
+)
+
+// loadDNSAttributes fetches the posture attributes of a device and applies its DNS preferences to the machine. Failing
+// to read them isn't fatal, the machine just keeps the defaults from the configuration.
+func (c *Client) loadDNSAttributes(ctx context.Context, device *tailscaleclient.Device, machine *Machine) {
+	deviceID := device.NodeID
+	if deviceID == "" {
+		deviceID = device.ID
+	}
+
+	attributes, err := c.api().Devices().GetPostureAttributes(ctx, deviceID)
+	if err != nil {
+		klog.Warningf("Failed to read posture attributes of %s (%s): %v", machine.Name, machine.ID, err)
+		return
+	}
+	applyDNSAttributes(machine, attributes.Attributes)
+}
+
+// applyDNSAttributes sets the DNS preferences of a machine from its custom posture attributes, ignoring invalid values
+func applyDNSAttributes(machine *Machine, attributes map[string]any) {
+	if value, ok := attributes[AttributeDNSName]; ok {
+		name, isString := value.(string)
+		if isString && strings.TrimSpace(name) != "" {
+			machine.DNSName = strings.TrimSpace(name)
+		} else {
+			klog.Warningf("Ignoring %s attribute of %s (%s): expected a non-empty string, got %v",
+				AttributeDNSName, machine.Name, machine.ID, value)
+		}
+	}
+
+	if value, ok := attributes[AttributeDNSTTL]; ok {
+		ttl, err := parseTTLAttribute(value)
+		if err != nil {
+			klog.Warningf("Ignoring %s attribute of %s (%s): %v", AttributeDNSTTL, machine.Name, machine.ID, err)
+		} else {
+			machine.DNSTTL = ttl
+		}
+	}
+}
+
+// parseTTLAttribute parses a TTL given as a number of seconds or as a duration string
+func parseTTLAttribute(value any) (time.Duration, error) {
+	var ttl time.Duration
+	switch v := value.(type) {
+	case float64:
+		// Numeric attributes are decoded from JSON as float64
+		if v != math.Trunc(v) {
+			return 0, fmt.Errorf("TTL %v is not a whole number of seconds", v)
+		}
+		if v > math.MaxInt32 {
+			return 0, fmt.Errorf("TTL %v is too large", v)
+		}
+		ttl = time.Duration(v) * time.Second
+	case string:
+		if seconds, err := strconv.ParseInt(v, 10, 32); err == nil {
+			ttl = time.Duration(seconds) * time.Second
+		} else {
+			parsed, err := time.ParseDuration(v)
+			if err != nil {
+				return 0, fmt.Errorf("parsing TTL %q: %w", v, err)
+			}
+			ttl = parsed.Truncate(time.Second)
+		}
+	default:
+		return 0, fmt.Errorf("expected a number or a string, got %v", value)
+	}
+
+	if ttl <= 0 {
+		return 0, fmt.Errorf("TTL must be positive")
+	}
+	if ttl > math.MaxInt32*time.Second {
+		return 0, fmt.Errorf("TTL %v is too large", ttl)
+	}
+	return ttl, nil
+}
@@ -0,0 +1,146 @@
+package tailscale
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"k8s.io/klog/v2"
+)
+
+// With a cache file the machines of every successful poll are written to disk. When the first poll of StartPolling
+// fails, e.g. because the API is down or rate limited while the process starts, the cached machines are sent instead,
+// so that the records stay as they were rather than nothing being published until Tailscale is back. They are reported
+// as stale until a poll succeeds.
+
+// cachedMachines is the content of the cache file
+type cachedMachines struct {
+	Polled   time.Time `json:"polled"`
+	Machines []Machine `json:"machines"`
+}
+
+// CacheStatus reports the machine cache
+type CacheStatus struct {
+	// Stale is set while the most recently sent machines were read from the cache rather than polled
+	Stale bool `json:"stale"`
+	// Polled is when the cached machines were polled and Machines how many there are
+	Polled   time.Time `json:"polled,omitzero"`
+	Machines int       `json:"machines"`
+}
+
+// machineCache keeps the machines of the most recent successful poll in a file
+type machineCache struct {
+	file string
+
+	mu     sync.Mutex
+	status CacheStatus
+}
+
+// SetCacheFile sets the file the machines of every successful poll are kept in, none when empty
+func (c *Client) SetCacheFile(file string) {
+	if file == "" {
+		c.cache = nil
+		return
+	}
+	c.cache = &machineCache{file: file}
+}
+
+// CacheStatus returns the state of the machine cache, nil without a cache file
+func (c *Client) CacheStatus() *CacheStatus {
+	if c.cache == nil {
+		return nil
+	}
+
+	c.cache.mu.Lock()
+	defer c.cache.mu.Unlock()
+
+	status := c.cache.status
+	return &status
+}
+
+// loadCache returns the cached machines and marks them stale, nil when there are none
+func (c *Client) loadCache() ([]Machine, error) {
+	if c.cache == nil {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(c.cache.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading machine cache: %w", err)
+	}
+
+	var cached cachedMachines
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return nil, fmt.Errorf("parsing machine cache %s: %w", c.cache.file, err)
+	}
+	// No online machines is still a machine list, it withdraws the records of every machine
+	if cached.Machines == nil {
+		cached.Machines = []Machine{}
+	}
+
+	c.cache.mu.Lock()
+	c.cache.status = CacheStatus{Stale: true, Polled: cached.Polled, Machines: len(cached.Machines)}
+	c.cache.mu.Unlock()
+	return cached.Machines, nil
+}
+
+// saveCache writes the machines of a successful poll to the cache file, which is replaced atomically so that a crash
+// never leaves a partial machine list behind
+func (c *Client) saveCache(machines []Machine) error {
+	if c.cache == nil {
+		return nil
+	}
+
+	polled := clock.Or(c.clock).Now()
+	c.cache.mu.Lock()
+	c.cache.status = CacheStatus{Polled: polled, Machines: len(machines)}
+	c.cache.mu.Unlock()
+
+	data, err := json.Marshal(cachedMachines{Polled: polled, Machines: machines})
+	if err != nil {
+		return fmt.Errorf("encoding machine cache: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.cache.file), filepath.Base(c.cache.file)+".*")
+	if err != nil {
+		return fmt.Errorf("creating machine cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing machine cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing machine cache: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.cache.file); err != nil {
+		return fmt.Errorf("replacing machine cache: %w", err)
+	}
+	return nil
+}
+
+// cachedFallback returns the cached machines to send when the first poll failed, nil when there are none
+func (c *Client) cachedFallback() []Machine {
+	machines, err := c.loadCache()
+	if err != nil {
+		klog.Errorf("Failed to read the machines of the last successful poll: %v", err)
+		return nil
+	}
+	if machines == nil {
+		return nil
+	}
+
+	status := c.CacheStatus()
+	klog.Warningf("Publishing the %d machines of the last successful poll at %s until Tailscale is reachable",
+		status.Machines, status.Polled.Format(time.RFC3339))
+	return machines
+}
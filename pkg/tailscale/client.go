@@ -2,18 +2,74 @@ package tailscale
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
 	"k8s.io/klog/v2"
 	tailscaleclient "tailscale.com/client/tailscale/v2"
 )
 
 // Client wraps the Tailscale client with additional functionality
 type Client struct {
-	client  *tailscaleclient.Client
-	tailnet string
+	// API client, replaced by SetCredentials, see api
+	clientMu sync.RWMutex
+	client   *tailscaleclient.Client
+	tailnet  string
+
+	// How devices are determined to be online, see deviceOnline
+	onlineHeuristic string
+	onlineThreshold time.Duration
+
+	// Smoothing of the online state across polls, nil when devices go online and offline with the first poll
+	hysteresis *onlineHysteresis
+	// Devices kept online after they went offline, nil when they go offline right away
+	offline *offlinePolicy
+
+	// Whether DNS preferences are read from the custom posture attributes of online devices
+	deviceAttributes bool
+
+	// API usage of the current day, see usage.go
+	usage *usageTracker
+
+	// Retries and spacing of API requests, nil in tsnet and local mode, see retry.go
+	transport *retryTransport
+
+	// Clock of the poll ticker and online heuristics, the real clock when nil
+	clock clock.Clock
+
+	// Run alongside every poll, the machines are sent once both finished, see SetPollHook
+	pollHook func(ctx context.Context)
+
+	// How long the most recent poll of StartPolling took, see PollDuration
+	pollDuration atomic.Int64
+
+	// Machines of the most recent successful poll kept on disk, nil without a cache file, see cache.go
+	cache *machineCache
+
+	// Node that joined the tailnet itself and whose netmap devices are read from in tsnet mode, nil in api mode, see
+	// tsnet.go
+	peers peerSource
+
+	// Ranges device addresses are accepted from, every address when empty, see addresses.go
+	addressRanges []netip.Prefix
+
+	// Whether the subnet routes of devices are read, which the API only lists with all fields
+	routes bool
+}
+
+// peerSource lists the machines of the tailnet without the API
+type peerSource interface {
+	// machines lists the machines, now is when online ones count as last seen
+	machines(ctx context.Context, now time.Time) ([]Machine, error)
+	Close() error
 }
 
 // Machine represents a Tailscale machine
@@ -24,6 +80,23 @@ type Machine struct {
 	IPv6Address string    `json:"ipv6_address"`
 	LastSeen    time.Time `json:"last_seen"`
 	Online      bool      `json:"online"`
+	Tags        []string  `json:"tags,omitempty"`
+	User        string    `json:"user,omitempty"`
+	OS          string    `json:"os,omitempty"`
+
+	// IPv4Addresses and IPv6Addresses hold every address the device reports, IPv4Address and IPv6Address being the
+	// first of them
+	IPv4Addresses []string `json:"ipv4_addresses,omitempty"`
+	IPv6Addresses []string `json:"ipv6_addresses,omitempty"`
+
+	// Subnet routes the device advertises and those approved for it, only read with tailscale.routes. In local and
+	// tsnet mode only the approved routes the device is the primary router of are known, as both.
+	AdvertisedRoutes []string `json:"advertised_routes,omitempty"`
+	ApprovedRoutes   []string `json:"approved_routes,omitempty"`
+
+	// DNS preferences set by the device owner through custom posture attributes, see applyDNSAttributes
+	DNSName string        `json:"dns_name,omitempty"`
+	DNSTTL  time.Duration `json:"dns_ttl,omitempty"`
 }
 
 // NewClient creates a new Tailscale client
@@ -35,19 +108,100 @@ func NewClient(apiKey, tailnet string) (*Client, error) {
 		return nil, fmt.Errorf("tailnet is required")
 	}
 
+	usage := newUsageTracker()
+	transport := &retryTransport{base: &errorTransport{usage: usage}}
 	client := &tailscaleclient.Client{
 		Tailnet: tailnet,
 		APIKey:  apiKey,
+		HTTP: &http.Client{
+			Timeout:   time.Minute,
+			Transport: transport,
+		},
 	}
 
 	return &Client{
-		client:  client,
-		tailnet: tailnet,
+		client:    client,
+		tailnet:   tailnet,
+		usage:     usage,
+		transport: transport,
 	}, nil
 }
 
+// NewClientFromConfig creates a Tailscale client. In tsnet mode it joins the tailnet as a node of its own and in local
+// mode it reads the local tailscaled, otherwise it uses the API key if one is configured and OAuth otherwise.
+func NewClientFromConfig(cfg *config.TailscaleConfig) (*Client, error) {
+	// Parsed before a tsnet node joins the tailnet
+	addressRanges, err := parseAddressRanges(cfg.AddressRanges)
+	if err != nil {
+		return nil, err
+	}
+
+	var client *Client
+	switch {
+	case cfg.Mode == config.TailscaleModeTSNet:
+		client, err = newTSNetClient(&cfg.TSNet)
+	case cfg.Mode == config.TailscaleModeLocal:
+		client, err = newLocalClient(&cfg.Local)
+	case cfg.APIKey != "":
+		client, err = NewClient(cfg.APIKey, cfg.Tailnet)
+	default:
+		client, err = newOAuthClient(cfg.ClientID, cfg.ClientSecret, cfg.Tailnet, oauthScopes(cfg.DeviceAttributes))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	client.onlineHeuristic = cfg.OnlineHeuristic
+	client.onlineThreshold = cfg.OnlineThreshold
+	client.deviceAttributes = cfg.DeviceAttributes
+	client.hysteresis = newOnlineHysteresis(cfg.OnlinePolls, cfg.OfflinePolls)
+	client.addressRanges = addressRanges
+	client.routes = cfg.Routes.Enabled
+	if client.transport != nil {
+		client.transport.configure(cfg.Retry, cfg.MaxRequestsPerMinute)
+	}
+	return client, nil
+}
+
+// SetOfflinePolicy sets what happens to the records of devices that go offline, see config.BindConfig.OfflinePolicy
+func (c *Client) SetOfflinePolicy(policy string, grace time.Duration) {
+	c.offline = newOfflinePolicy(policy, grace)
+}
+
+// SetClock replaces the clock of the client, e.g. with a fake one in tests
+func (c *Client) SetClock(clk clock.Clock) {
+	c.clock = clk
+	if c.transport != nil {
+		c.transport.clock = clk
+	}
+}
+
+// SetPollHook sets a function run concurrently with every poll, e.g. to read the DNS zones the polled machines are
+// going to be compared with. The machines of a poll are only sent once the hook returned.
+func (c *Client) SetPollHook(hook func(ctx context.Context)) {
+	c.pollHook = hook
+}
+
+// PollDuration returns how long the most recent poll of StartPolling took, the poll hook included
+func (c *Client) PollDuration() time.Duration {
+	return time.Duration(c.pollDuration.Load())
+}
+
+// Close leaves the tailnet in tsnet mode, in api mode there is nothing to release
+func (c *Client) Close() error {
+	if c.peers == nil {
+		return nil
+	}
+	return c.peers.Close()
+}
+
 // NewOAuthClient creates a new Tailscale client using OAuth
 func NewOAuthClient(clientID, clientSecret, tailnet string) (*Client, error) {
+	return newOAuthClient(clientID, clientSecret, tailnet, []string{devicesReadScope})
+}
+
+// newOAuthClient creates a Tailscale client using OAuth that requests the given scopes
+func newOAuthClient(clientID, clientSecret, tailnet string, scopes []string) (*Client, error) {
 	if clientID == "" {
 		return nil, fmt.Errorf("client ID is required")
 	}
@@ -59,69 +213,136 @@ func NewOAuthClient(clientID, clientSecret, tailnet string) (*Client, error) {
 	}
 
 	// Create client with OAuth credentials
+	httpClient := tailscaleclient.OAuthConfig{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scopes:       scopes,
+	}.HTTPClient()
+	usage := newUsageTracker()
+	transport := &retryTransport{base: &errorTransport{base: httpClient.Transport, usage: usage}}
+	httpClient.Transport = transport
+
 	client := &tailscaleclient.Client{
 		Tailnet: tailnet,
-		HTTP: tailscaleclient.OAuthConfig{
-			ClientID:     clientID,
-			ClientSecret: clientSecret,
-			Scopes:       []string{"devices:core:read"},
-		}.HTTPClient(),
+		HTTP:    httpClient,
 	}
 
 	return &Client{
-		client:  client,
-		tailnet: tailnet,
+		client:    client,
+		tailnet:   tailnet,
+		usage:     usage,
+		transport: transport,
 	}, nil
 }
 
 // GetMachines retrieves all machines from the tailnet
 func (c *Client) GetMachines(ctx context.Context) ([]Machine, error) {
+	return c.getMachines(ctx, false)
+}
+
+// getMachines retrieves all machines from the tailnet. With poll the online state of the machines is smoothed across
+// polls, see onlineHysteresis; one-off lookups such as the PTR bootstrap leave the smoothing alone.
+func (c *Client) getMachines(ctx context.Context, poll bool) ([]Machine, error) {
+	var machines []Machine
+	var devices []tailscaleclient.Device
+	var err error
+	if c.peers != nil {
+		machines, err = c.peers.machines(ctx, clock.Or(c.clock).Now())
+	} else {
+		machines, devices, err = c.apiMachines(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(c.addressRanges) > 0 {
+		for i := range machines {
+			if dropped := machines[i].keepAddresses(c.addressRanges); len(dropped) > 0 {
+				klog.V(1).Infof("Ignoring addresses %s of %s (%s) outside tailscale.address_ranges",
+					strings.Join(dropped, ", "), machines[i].Name, machines[i].ID)
+			}
+		}
+	}
+
+	if poll {
+		c.hysteresis.apply(machines)
+		c.offline.apply(machines, clock.Or(c.clock).Now())
+	}
+	if c.deviceAttributes {
+		for i := range machines {
+			if machines[i].Online {
+				c.loadDNSAttributes(ctx, &devices[i], &machines[i])
+			}
+		}
+	}
+
+	klog.V(1).Infof("Found %d machines", len(machines))
+	return machines, nil
+}
+
+// apiMachines lists the devices of the tailnet through the API and converts them to machines, returned in the same
+// order as the devices
+func (c *Client) apiMachines(ctx context.Context) ([]Machine, []tailscaleclient.Device, error) {
 	klog.V(2).Info("Fetching machines from Tailscale")
 
-	devices, err := c.client.Devices().List(ctx)
+	var devices []tailscaleclient.Device
+	var err error
+	if c.onlineHeuristic == config.OnlineHeuristicConnectivity || c.routes {
+		// Client connectivity and subnet routes are only included when all fields are requested
+		devices, err = c.api().Devices().ListWithAllFields(ctx)
+	} else {
+		devices, err = c.api().Devices().List(ctx)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("fetching devices: %w", err)
+		return nil, nil, fmt.Errorf("fetching devices: %w", err)
 	}
 
+	c.usage.setDevices(len(devices))
+
+	now := clock.Or(c.clock).Now()
 	var machines []Machine
 	for _, device := range devices {
 		machine := Machine{
 			ID:       device.ID,
 			Name:     device.Name,
 			LastSeen: device.LastSeen.Time,
-			Online:   device.Authorized, // Use Authorized as a proxy for online status
+			Online:   deviceOnline(&device, c.onlineHeuristic, c.onlineThreshold, now),
+			Tags:     device.Tags,
+			User:     device.User,
+			OS:       device.OS,
 		}
-
-		// Extract IPv4 address from the device's IP addresses
-		for _, addr := range device.Addresses {
-			ip := net.ParseIP(addr)
-			if ip != nil && ip.To4() != nil {
-				// This is an IPv4 address
-				machine.IPv4Address = addr
-				break
-			}
+		if c.routes {
+			machine.AdvertisedRoutes, machine.ApprovedRoutes = device.AdvertisedRoutes, device.EnabledRoutes
 		}
-
-		// Extract IPv6 address if available
-		for _, addr := range device.Addresses {
-			ip := net.ParseIP(addr)
-			if ip != nil && ip.To4() == nil {
-				// This is an IPv6 address
-				machine.IPv6Address = addr
-				break
-			}
-		}
-
+		machine.setAddresses(device.Addresses)
 		machines = append(machines, machine)
 	}
+	return machines, devices, nil
+}
 
-	klog.V(1).Infof("Found %d machines", len(machines))
-	return machines, nil
+// deviceOnline decides whether a device is online using the given heuristic. Unauthorized devices are never online.
+// An empty heuristic selects last_seen and a zero threshold selects the default threshold.
+func deviceOnline(device *tailscaleclient.Device, heuristic string, threshold time.Duration, now time.Time) bool {
+	if !device.Authorized {
+		return false
+	}
+
+	switch heuristic {
+	case config.OnlineHeuristicAuthorized:
+		return true
+	case config.OnlineHeuristicConnectivity:
+		connectivity := device.ClientConnectivity
+		return connectivity != nil && (len(connectivity.Endpoints) > 0 || connectivity.DERP != "")
+	default:
+		if threshold == 0 {
+			threshold = config.DefaultOnlineThreshold
+		}
+		return !device.LastSeen.IsZero() && now.Sub(device.LastSeen.Time) <= threshold
+	}
 }
 
 // GetOnlineMachines retrieves only online machines from the tailnet
 func (c *Client) GetOnlineMachines(ctx context.Context) ([]Machine, error) {
-	machines, err := c.GetMachines(ctx)
+	machines, err := c.getMachines(ctx, true)
 	if err != nil {
 		return nil, err
 	}
@@ -137,18 +358,25 @@ func (c *Client) GetOnlineMachines(ctx context.Context) ([]Machine, error) {
 	return onlineMachines, nil
 }
 
-// StartPolling starts polling for machine updates and sends them to the provided channel
-func (c *Client) StartPolling(ctx context.Context, pollInterval time.Duration, machineChan chan<- []Machine) {
-	ticker := time.NewTicker(pollInterval)
+// StartPolling starts polling for machine updates and sends them to the provided channel. Every signal on pollNow
+// polls straight away and restarts the interval, a nil channel only polls on the interval.
+func (c *Client) StartPolling(ctx context.Context, pollInterval time.Duration, machineChan chan<- []Machine,
+	pollNow <-chan struct{}) {
+	ticker := clock.Or(c.clock).NewTicker(pollInterval)
 	defer ticker.Stop()
 
 	klog.Infof("Starting Tailscale polling with interval %v", pollInterval)
 
 	// Send initial data
-	machines, err := c.GetOnlineMachines(ctx)
+	machines, err := c.poll(ctx)
 	if err != nil {
-		klog.Errorf("Failed to get initial machine list: %v", err)
+		logPollError("Failed to get initial machine list", err)
+		machines = c.cachedFallback()
 	} else {
+		c.checkPollBudget(pollInterval, len(machines))
+	}
+	// Without an initial poll the cached machines are sent, nothing when there are none
+	if err == nil || machines != nil {
 		select {
 		case machineChan <- machines:
 		case <-ctx.Done():
@@ -158,22 +386,61 @@ func (c *Client) StartPolling(ctx context.Context, pollInterval time.Duration, m
 
 	for {
 		select {
-		case <-ticker.C:
-			machines, err := c.GetOnlineMachines(ctx)
-			if err != nil {
-				klog.Errorf("Failed to get machines: %v", err)
-				continue
-			}
+		case <-ticker.C():
+		case <-pollNow:
+			ticker.Reset(pollInterval)
+		case <-ctx.Done():
+			klog.Info("Tailscale polling stopped")
+			return
+		}
 
-			select {
-			case machineChan <- machines:
-			case <-ctx.Done():
-				return
-			}
+		machines, err := c.poll(ctx)
+		if err != nil {
+			logPollError("Failed to get machines", err)
+			continue
+		}
 
+		select {
+		case machineChan <- machines:
 		case <-ctx.Done():
-			klog.Info("Tailscale polling stopped")
 			return
 		}
 	}
 }
+
+// poll gets the online machines while running the poll hook and keeps them in the cache file
+func (c *Client) poll(ctx context.Context) ([]Machine, error) {
+	clk := clock.Or(c.clock)
+	started := clk.Now()
+	defer func() { c.pollDuration.Store(int64(clk.Since(started))) }()
+
+	if c.pollHook != nil {
+		var hook sync.WaitGroup
+		hook.Go(func() { c.pollHook(ctx) })
+		defer hook.Wait()
+	}
+
+	machines, err := c.GetOnlineMachines(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.saveCache(machines); err != nil {
+		klog.Errorf("Failed to keep the polled machines: %v", err)
+	}
+	return machines, nil
+}
+
+// logPollError logs a failed poll, pointing out what to do about the failures that need the operator
+func logPollError(msg string, err error) {
+	var apiErr *APIError
+	switch {
+	case errors.Is(err, ErrUnauthorized):
+		klog.Errorf("%s: %v (check that the API key or OAuth client is valid and has the required scopes)", msg, err)
+	case errors.Is(err, ErrRateLimited) && errors.As(err, &apiErr) && apiErr.RetryAfter > 0:
+		klog.Warningf("%s: %v (retry allowed after %v, consider a longer poll interval)", msg, err, apiErr.RetryAfter)
+	case errors.Is(err, ErrRateLimited):
+		klog.Warningf("%s: %v (consider a longer poll interval)", msg, err)
+	default:
+		klog.Errorf("%s: %v", msg, err)
+	}
+}
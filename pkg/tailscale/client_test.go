@@ -1,10 +1,24 @@
 package tailscale
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tailscaleclient "tailscale.com/client/tailscale/v2"
 )
 
 func TestNewClient(t *testing.T) {
@@ -156,10 +170,174 @@ func TestGetOnlineMachines(t *testing.T) {
 }
 
 func TestStartPolling(t *testing.T) {
-	// This test verifies the polling mechanism
-	// We'll skip the actual polling test since it requires real credentials
-	// and would cause a panic with nil client
-	t.Skip("Skipping polling test - requires real Tailscale credentials")
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		// Devices are last seen at the fake time, so they are online by the default heuristic
+		_, _ = fmt.Fprintf(w, `{"devices":[{"id":"n1","name":"a","addresses":["100.64.0.1"],"authorized":true,`+
+			`"lastSeen":%q}]}`, clk.Now().Format(time.RFC3339))
+	}))
+	defer server.Close()
+
+	client, err := NewClient("test-api-key", "test.example.com")
+	require.NoError(t, err)
+	client.client.BaseURL, err = url.Parse(server.URL)
+	require.NoError(t, err)
+	client.SetClock(clk)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	machineChan := make(chan []Machine)
+	pollNow := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.StartPolling(ctx, time.Minute, machineChan, pollNow)
+	}()
+
+	// The first poll happens straight away, the next one once the interval passed
+	machines := <-machineChan
+	require.Len(t, machines, 1)
+	assert.Equal(t, "100.64.0.1", machines[0].IPv4Address)
+	clk.BlockUntil(1)
+	clk.Advance(time.Minute)
+	<-machineChan
+	assert.Equal(t, int32(2), requests.Load())
+
+	// Requested polls happen straight away and restart the interval
+	clk.Advance(30 * time.Second)
+	pollNow <- struct{}{}
+	<-machineChan
+	assert.Equal(t, int32(3), requests.Load())
+	clk.Advance(59 * time.Second)
+	select {
+	case <-machineChan:
+		t.Fatal("polled before the restarted interval passed")
+	default:
+	}
+	clk.Advance(time.Second)
+	<-machineChan
+	assert.Equal(t, int32(4), requests.Load())
+
+	cancel()
+	<-done
+}
+
+func TestStartPollingHook(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	polling := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		<-polling
+		_, _ = fmt.Fprintf(w, `{"devices":[{"id":"n1","name":"a","addresses":["100.64.0.1"],"authorized":true,`+
+			`"lastSeen":%q}]}`, clk.Now().Format(time.RFC3339))
+	}))
+	defer server.Close()
+
+	client, err := NewClient("test-api-key", "test.example.com")
+	require.NoError(t, err)
+	client.client.BaseURL, err = url.Parse(server.URL)
+	require.NoError(t, err)
+	client.SetClock(clk)
+
+	// The hook runs while the devices are fetched and the machines wait for it
+	hookStarted := make(chan struct{})
+	hookDone := make(chan struct{})
+	var hookFinished atomic.Bool
+	client.SetPollHook(func(context.Context) {
+		close(hookStarted)
+		<-hookDone
+		hookFinished.Store(true)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	machineChan := make(chan []Machine)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.StartPolling(ctx, time.Minute, machineChan, nil)
+	}()
+
+	<-hookStarted
+	close(polling)
+	select {
+	case <-machineChan:
+		t.Fatal("machines sent before the poll hook returned")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(hookDone)
+	machines := <-machineChan
+	assert.Len(t, machines, 1)
+	assert.True(t, hookFinished.Load())
+
+	cancel()
+	<-done
+}
+
+func TestMachineCache(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	polled := clk.Now()
+	var unavailable atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if unavailable.Load() {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"devices":[{"id":"n1","name":"a","addresses":["100.64.0.1"],"authorized":true,`+
+			`"lastSeen":%q}]}`, clk.Now().Format(time.RFC3339))
+	}))
+	defer server.Close()
+
+	cacheFile := filepath.Join(t.TempDir(), "machines.json")
+	newClient := func() *Client {
+		client, err := NewClient("test-api-key", "test.example.com")
+		require.NoError(t, err)
+		client.client.BaseURL, err = url.Parse(server.URL)
+		require.NoError(t, err)
+		client.SetClock(clk)
+		client.SetCacheFile(cacheFile)
+		return client
+	}
+	start := func(client *Client) (<-chan []Machine, chan<- struct{}, func()) {
+		ctx, cancel := context.WithCancel(context.Background())
+		machineChan := make(chan []Machine)
+		pollNow := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			client.StartPolling(ctx, time.Minute, machineChan, pollNow)
+		}()
+		return machineChan, pollNow, func() { cancel(); <-done }
+	}
+
+	// Successful polls are kept in the cache file
+	client := newClient()
+	assert.Equal(t, &CacheStatus{}, client.CacheStatus())
+	machineChan, _, stop := start(client)
+	assert.Len(t, <-machineChan, 1)
+	stop()
+	assert.Equal(t, &CacheStatus{Polled: polled, Machines: 1}, client.CacheStatus())
+	assert.FileExists(t, cacheFile)
+
+	// When Tailscale is unavailable at startup the cached machines are sent and reported stale until a poll succeeds
+	clk.Advance(time.Hour)
+	unavailable.Store(true)
+	client = newClient()
+	machineChan, pollNow, stop := start(client)
+	machines := <-machineChan
+	require.Len(t, machines, 1)
+	assert.Equal(t, "100.64.0.1", machines[0].IPv4Address)
+	assert.Equal(t, &CacheStatus{Stale: true, Polled: polled, Machines: 1}, client.CacheStatus())
+
+	unavailable.Store(false)
+	pollNow <- struct{}{}
+	assert.Len(t, <-machineChan, 1)
+	stop()
+	assert.Equal(t, &CacheStatus{Polled: clk.Now(), Machines: 1}, client.CacheStatus())
+
+	// Without a cache file nothing is kept
+	client, err := NewClient("test-api-key", "test.example.com")
+	require.NoError(t, err)
+	assert.Nil(t, client.CacheStatus())
 }
 
 // Helper method to test machine filtering logic
@@ -235,3 +413,595 @@ func TestMachineConversion(t *testing.T) {
 		})
 	}
 }
+
+func TestSetAddresses(t *testing.T) {
+	var machine Machine
+	machine.setAddresses([]string{"100.64.0.1", "fd7a:115c:a1e0::1", "192.168.1.10", "invalid", "2001:db8::1"})
+	assert.Equal(t, Machine{
+		IPv4Address:   "100.64.0.1",
+		IPv4Addresses: []string{"100.64.0.1", "192.168.1.10"},
+		IPv6Address:   "fd7a:115c:a1e0::1",
+		IPv6Addresses: []string{"fd7a:115c:a1e0::1", "2001:db8::1"},
+	}, machine)
+
+	// Mapped addresses are IPv4 addresses, zoned ones can't be published and IPv6 addresses are canonicalized
+	machine = Machine{}
+	machine.setAddresses([]string{"::ffff:100.64.0.2", "fe80::1%eth0", "FD7A:115C:A1E0:0:0:0:0:2", "1.2.3"})
+	assert.Equal(t, Machine{
+		IPv4Address:   "100.64.0.2",
+		IPv4Addresses: []string{"100.64.0.2"},
+		IPv6Address:   "fd7a:115c:a1e0::2",
+		IPv6Addresses: []string{"fd7a:115c:a1e0::2"},
+	}, machine)
+}
+
+func TestAddressRanges(t *testing.T) {
+	ranges, err := parseAddressRanges([]string{config.AddressRangesTailscale, "192.168.1.1/24"})
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("100.64.0.0/10"),
+		netip.MustParsePrefix("fd7a:115c:a1e0::/48"),
+		netip.MustParsePrefix("192.168.1.0/24"),
+	}, ranges)
+	_, err = parseAddressRanges([]string{"192.168.1.0"})
+	assert.Error(t, err)
+	ranges, err = parseAddressRanges([]string{config.AddressRangesAny, "192.168.1.0/24"})
+	require.NoError(t, err)
+	assert.Empty(t, ranges, "any accepts every address")
+	ranges, err = parseAddressRanges([]string{config.AddressRangesTailscale, "192.168.1.1/24"})
+	require.NoError(t, err)
+
+	var machine Machine
+	machine.setAddresses([]string{"10.0.0.1", "100.64.0.1", "192.168.1.10", "2001:db8::1", "fd7a:115c:a1e0::1"})
+	assert.Equal(t, []string{"10.0.0.1", "2001:db8::1"}, machine.keepAddresses(ranges))
+	assert.Equal(t, []string{"100.64.0.1", "192.168.1.10"}, machine.IPv4Addresses)
+	assert.Equal(t, "100.64.0.1", machine.IPv4Address)
+	assert.Equal(t, []string{"fd7a:115c:a1e0::1"}, machine.IPv6Addresses)
+	assert.Equal(t, "fd7a:115c:a1e0::1", machine.IPv6Address)
+
+	// Custom pools of other ranges drop the Tailscale addresses
+	machine.keepAddresses([]netip.Prefix{netip.MustParsePrefix("100.100.0.0/16")})
+	assert.Empty(t, machine.IPv4Addresses)
+	assert.Empty(t, machine.IPv4Address)
+	assert.Empty(t, machine.IPv6Address)
+}
+
+func TestDeviceOnline(t *testing.T) {
+	now := time.Date(2025, 9, 14, 12, 0, 0, 0, time.UTC)
+	seen := func(ago time.Duration) tailscaleclient.Time {
+		return tailscaleclient.Time{Time: now.Add(-ago)}
+	}
+
+	tests := []struct {
+		name      string
+		device    tailscaleclient.Device
+		heuristic string
+		threshold time.Duration
+		want      bool
+	}{
+		{
+			name:      "recently seen",
+			device:    tailscaleclient.Device{Authorized: true, LastSeen: seen(time.Minute)},
+			heuristic: config.OnlineHeuristicLastSeen,
+			threshold: 5 * time.Minute,
+			want:      true,
+		},
+		{
+			name:      "seen before threshold",
+			device:    tailscaleclient.Device{Authorized: true, LastSeen: seen(time.Hour)},
+			heuristic: config.OnlineHeuristicLastSeen,
+			threshold: 5 * time.Minute,
+			want:      false,
+		},
+		{
+			name:      "never seen",
+			device:    tailscaleclient.Device{Authorized: true},
+			heuristic: config.OnlineHeuristicLastSeen,
+			threshold: 5 * time.Minute,
+			want:      false,
+		},
+		{
+			name:   "empty heuristic uses last seen with default threshold",
+			device: tailscaleclient.Device{Authorized: true, LastSeen: seen(10 * time.Minute)},
+			want:   false,
+		},
+		{
+			name:      "unauthorized device is offline",
+			device:    tailscaleclient.Device{Authorized: false, LastSeen: seen(0)},
+			heuristic: config.OnlineHeuristicLastSeen,
+			threshold: 5 * time.Minute,
+			want:      false,
+		},
+		{
+			name: "connectivity with endpoints",
+			device: tailscaleclient.Device{
+				Authorized:         true,
+				ClientConnectivity: &tailscaleclient.ClientConnectivity{Endpoints: []string{"192.0.2.1:41641"}},
+			},
+			heuristic: config.OnlineHeuristicConnectivity,
+			want:      true,
+		},
+		{
+			name: "connectivity with DERP only",
+			device: tailscaleclient.Device{
+				Authorized:         true,
+				ClientConnectivity: &tailscaleclient.ClientConnectivity{DERP: "nyc"},
+			},
+			heuristic: config.OnlineHeuristicConnectivity,
+			want:      true,
+		},
+		{
+			name: "empty connectivity",
+			device: tailscaleclient.Device{
+				Authorized:         true,
+				LastSeen:           seen(0),
+				ClientConnectivity: &tailscaleclient.ClientConnectivity{},
+			},
+			heuristic: config.OnlineHeuristicConnectivity,
+			want:      false,
+		},
+		{
+			name:      "missing connectivity",
+			device:    tailscaleclient.Device{Authorized: true, LastSeen: seen(0)},
+			heuristic: config.OnlineHeuristicConnectivity,
+			want:      false,
+		},
+		{
+			name:      "authorized heuristic ignores last seen",
+			device:    tailscaleclient.Device{Authorized: true, LastSeen: seen(24 * time.Hour)},
+			heuristic: config.OnlineHeuristicAuthorized,
+			want:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, deviceOnline(&tt.device, tt.heuristic, tt.threshold, now))
+		})
+	}
+}
+
+func TestOnlineHysteresis(t *testing.T) {
+	assert.Nil(t, newOnlineHysteresis(1, 1))
+
+	// Online on the second poll, offline on the third
+	h := newOnlineHysteresis(2, 3)
+	polls := []struct {
+		online bool
+		want   bool
+	}{
+		{online: true, want: false},
+		{online: true, want: true},
+		{online: false, want: true},
+		{online: true, want: true},
+		{online: false, want: true},
+		{online: false, want: true},
+		{online: false, want: false},
+		{online: true, want: false},
+		{online: false, want: false},
+	}
+	for i, poll := range polls {
+		machines := []Machine{{ID: "n1", Name: "laptop", Online: poll.online}}
+		h.apply(machines)
+		assert.Equal(t, poll.want, machines[0].Online, "poll %d", i)
+	}
+
+	// Devices that left the tailnet are forgotten
+	h.apply(nil)
+	assert.Empty(t, h.devices)
+}
+
+func TestApplyDNSAttributes(t *testing.T) {
+	tests := []struct {
+		name       string
+		attributes map[string]any
+		wantName   string
+		wantTTL    time.Duration
+	}{
+		{
+			name:       "no attributes",
+			attributes: map[string]any{"node:os": "linux"},
+		},
+		{
+			name:       "name and numeric TTL",
+			attributes: map[string]any{AttributeDNSName: " builder ", AttributeDNSTTL: float64(60)},
+			wantName:   "builder",
+			wantTTL:    time.Minute,
+		},
+		{
+			name:       "TTL as seconds string",
+			attributes: map[string]any{AttributeDNSTTL: "120"},
+			wantTTL:    2 * time.Minute,
+		},
+		{
+			name:       "TTL as duration string",
+			attributes: map[string]any{AttributeDNSTTL: "1h"},
+			wantTTL:    time.Hour,
+		},
+		{
+			name:       "invalid values are ignored",
+			attributes: map[string]any{AttributeDNSName: float64(1), AttributeDNSTTL: "soon"},
+		},
+		{
+			name:       "non-positive TTL is ignored",
+			attributes: map[string]any{AttributeDNSTTL: float64(0)},
+		},
+		{
+			name:       "fractional TTL is ignored",
+			attributes: map[string]any{AttributeDNSTTL: 1.5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			machine := Machine{ID: "n1", Name: "laptop"}
+			applyDNSAttributes(&machine, tt.attributes)
+			assert.Equal(t, tt.wantName, machine.DNSName)
+			assert.Equal(t, tt.wantTTL, machine.DNSTTL)
+		})
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		wantKind   error
+		wantRetry  time.Duration
+	}{
+		{
+			name:     "invalid API key",
+			status:   http.StatusUnauthorized,
+			wantKind: ErrUnauthorized,
+		},
+		{
+			name:     "missing scope",
+			status:   http.StatusForbidden,
+			wantKind: ErrUnauthorized,
+		},
+		{
+			name:       "rate limited",
+			status:     http.StatusTooManyRequests,
+			retryAfter: "30",
+			wantKind:   ErrRateLimited,
+			wantRetry:  30 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(`{"message":"nope"}`))
+			}))
+			defer server.Close()
+
+			client, err := NewClient("test-api-key", "test.example.com")
+			require.NoError(t, err)
+			client.client.BaseURL, err = url.Parse(server.URL)
+			require.NoError(t, err)
+
+			_, err = client.GetMachines(context.Background())
+			require.Error(t, err)
+			assert.ErrorIs(t, err, tt.wantKind)
+
+			var apiErr *APIError
+			require.True(t, errors.As(err, &apiErr))
+			assert.Equal(t, tt.status, apiErr.StatusCode)
+			assert.Equal(t, tt.wantRetry, apiErr.RetryAfter)
+			assert.Contains(t, apiErr.Error(), "nope")
+		})
+	}
+}
+
+func TestUsage(t *testing.T) {
+	var rateLimited atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-RateLimit-Limit", "60")
+		w.Header().Set("X-RateLimit-Remaining", "41")
+		if rateLimited.Load() {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(`{"devices":[{"id":"n1","name":"a"},{"id":"n2","name":"b"}]}`))
+	}))
+	defer server.Close()
+
+	client, err := NewClient("test-api-key", "test.example.com")
+	require.NoError(t, err)
+	client.client.BaseURL, err = url.Parse(server.URL)
+	require.NoError(t, err)
+
+	usage := client.Usage()
+	assert.Zero(t, usage.Requests)
+	assert.Equal(t, -1, usage.Limit)
+
+	_, err = client.GetMachines(context.Background())
+	require.NoError(t, err)
+	rateLimited.Store(true)
+	_, err = client.GetMachines(context.Background())
+	require.ErrorIs(t, err, ErrRateLimited)
+
+	usage = client.Usage()
+	assert.Equal(t, time.Now().UTC().Format(time.DateOnly), usage.Day)
+	assert.Equal(t, 2, usage.Requests)
+	assert.Equal(t, 1, usage.RateLimited)
+	assert.Equal(t, 60, usage.Limit)
+	assert.Equal(t, 41, usage.Remaining)
+	assert.Equal(t, 2, usage.Devices)
+
+	// Counters start over on a new day, the announced limit is kept
+	client.usage.now = func() time.Time { return time.Now().Add(24 * time.Hour) }
+	usage = client.Usage()
+	assert.Zero(t, usage.Requests)
+	assert.Equal(t, 60, usage.Limit)
+}
+
+func TestSetCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key, _, _ := r.BasicAuth(); key != "rotated-api-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"devices":[{"id":"n1","name":"a"}]}`))
+	}))
+	defer server.Close()
+
+	client, err := NewClient("test-api-key", "test.example.com")
+	require.NoError(t, err)
+	client.client.BaseURL, err = url.Parse(server.URL)
+	require.NoError(t, err)
+
+	_, err = client.GetMachines(context.Background())
+	require.Error(t, err)
+
+	require.NoError(t, client.SetCredentials(&config.TailscaleConfig{APIKey: "rotated-api-key"}))
+	machines, err := client.GetMachines(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, machines, 1)
+	// The usage of the previous credentials is kept
+	assert.Equal(t, 2, client.Usage().Requests)
+
+	require.Error(t, client.SetCredentials(&config.TailscaleConfig{ClientID: "test-client-id"}))
+}
+
+func TestDeviceRoutes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Routes are only listed with all fields
+		assert.Equal(t, "all", r.URL.Query().Get("fields"))
+		_, _ = w.Write([]byte(`{"devices":[{"id":"n1","name":"router","authorized":true,` +
+			`"advertisedRoutes":["192.168.1.0/24","10.0.0.0/8"],"enabledRoutes":["192.168.1.0/24"]}]}`))
+	}))
+	defer server.Close()
+
+	client, err := NewClientFromConfig(&config.TailscaleConfig{
+		APIKey:  "test-api-key",
+		Tailnet: "test.example.com",
+		Routes:  config.RoutesConfig{Enabled: true},
+	})
+	require.NoError(t, err)
+	client.client.BaseURL, err = url.Parse(server.URL)
+	require.NoError(t, err)
+
+	machines, err := client.GetMachines(context.Background())
+	require.NoError(t, err)
+	require.Len(t, machines, 1)
+	assert.Equal(t, []string{"192.168.1.0/24", "10.0.0.0/8"}, machines[0].AdvertisedRoutes)
+	assert.Equal(t, []string{"192.168.1.0/24"}, machines[0].ApprovedRoutes)
+}
+
+func TestRetry(t *testing.T) {
+	var requests atomic.Int32
+	var failures atomic.Int32
+	var status atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		if failures.Add(-1) >= 0 {
+			if status.Load() == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "60")
+			}
+			w.WriteHeader(int(status.Load()))
+			return
+		}
+		_, _ = w.Write([]byte(`{"devices":[{"id":"n1","name":"a"}]}`))
+	}))
+	defer server.Close()
+
+	newClient := func(retry config.RetryConfig) *Client {
+		client, err := NewClient("test-api-key", "test.example.com")
+		require.NoError(t, err)
+		client.client.BaseURL, err = url.Parse(server.URL)
+		require.NoError(t, err)
+		client.transport.configure(retry, 0)
+		return client
+	}
+	retry := config.RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Minute}
+
+	t.Run("transient errors retried", func(t *testing.T) {
+		requests.Store(0)
+		failures.Store(2)
+		status.Store(http.StatusServiceUnavailable)
+		client := newClient(config.RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+		machines, err := client.GetMachines(context.Background())
+		require.NoError(t, err)
+		assert.Len(t, machines, 1)
+		assert.Equal(t, int32(3), requests.Load())
+	})
+
+	t.Run("retry after waited out", func(t *testing.T) {
+		clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+		requests.Store(0)
+		failures.Store(1)
+		status.Store(http.StatusTooManyRequests)
+		client := newClient(retry)
+		client.SetClock(clk)
+
+		done := make(chan error)
+		go func() {
+			_, err := client.GetMachines(context.Background())
+			done <- err
+		}()
+		clk.BlockUntil(1)
+		clk.Advance(time.Minute)
+		require.NoError(t, <-done)
+		assert.Equal(t, int32(2), requests.Load())
+	})
+
+	t.Run("attempts exhausted", func(t *testing.T) {
+		requests.Store(0)
+		failures.Store(5)
+		status.Store(http.StatusBadGateway)
+		client := newClient(config.RetryConfig{MaxAttempts: 2, InitialBackoff: time.Millisecond})
+		_, err := client.GetMachines(context.Background())
+		require.Error(t, err)
+		assert.Equal(t, int32(2), requests.Load())
+	})
+
+	t.Run("long retry after holds off further requests", func(t *testing.T) {
+		clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+		requests.Store(0)
+		failures.Store(1)
+		status.Store(http.StatusTooManyRequests)
+		client := newClient(config.RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+		client.SetClock(clk)
+
+		_, err := client.GetMachines(context.Background())
+		require.ErrorIs(t, err, ErrRateLimited)
+		_, err = client.GetMachines(context.Background())
+		require.ErrorIs(t, err, ErrRateLimited)
+		assert.Equal(t, int32(1), requests.Load())
+
+		clk.Advance(time.Minute)
+		_, err = client.GetMachines(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), requests.Load())
+	})
+}
+
+func TestMaxRequestsPerMinute(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		_, _ = w.Write([]byte(`{"devices":[]}`))
+	}))
+	defer server.Close()
+
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	client, err := NewClient("test-api-key", "test.example.com")
+	require.NoError(t, err)
+	client.client.BaseURL, err = url.Parse(server.URL)
+	require.NoError(t, err)
+	client.transport.configure(config.RetryConfig{}, 30)
+	client.SetClock(clk)
+
+	_, err = client.GetMachines(context.Background())
+	require.NoError(t, err)
+
+	// The second request waits two seconds for its turn
+	done := make(chan error)
+	go func() {
+		_, err := client.GetMachines(context.Background())
+		done <- err
+	}()
+	clk.BlockUntil(1)
+	assert.Equal(t, int32(1), requests.Load())
+	clk.Advance(2 * time.Second)
+	require.NoError(t, <-done)
+	assert.Equal(t, int32(2), requests.Load())
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert.Equal(t, 30*time.Second, parseRetryAfter("30"))
+	assert.Zero(t, parseRetryAfter("-5"))
+	assert.Zero(t, parseRetryAfter(""))
+	assert.Zero(t, parseRetryAfter("soon"))
+
+	got := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	assert.InDelta(t, float64(time.Minute), float64(got), float64(2*time.Second))
+	assert.Zero(t, parseRetryAfter(time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)))
+}
+
+func TestCheckPollBudget(t *testing.T) {
+	tests := []struct {
+		name             string
+		pollInterval     time.Duration
+		deviceAttributes bool
+		online           int
+		want             bool
+	}{
+		{name: "one request per poll", pollInterval: time.Second, online: 500, want: true},
+		{name: "attributes of a small tailnet", pollInterval: 30 * time.Second, deviceAttributes: true, online: 20, want: true},
+		{name: "attributes of a large tailnet", pollInterval: 30 * time.Second, deviceAttributes: true, online: 200},
+		{name: "polling too often", pollInterval: 100 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &Client{deviceAttributes: tt.deviceAttributes}
+			assert.Equal(t, tt.want, client.checkPollBudget(tt.pollInterval, tt.online))
+		})
+	}
+}
+
+func TestOfflinePolicy(t *testing.T) {
+	assert.Nil(t, newOfflinePolicy(config.OfflinePolicyDelete, 0))
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	poll := func(p *offlinePolicy, online bool, at time.Duration) bool {
+		machines := []Machine{{ID: "n1", Name: "laptop", Online: online}}
+		p.apply(machines, now.Add(at))
+		return machines[0].Online
+	}
+
+	grace := newOfflinePolicy(config.OfflinePolicyGrace, 10*time.Minute)
+	// Devices never seen online aren't kept
+	assert.False(t, poll(grace, false, 0))
+	assert.True(t, poll(grace, true, time.Minute))
+	assert.True(t, poll(grace, false, 2*time.Minute))
+	assert.True(t, poll(grace, false, 11*time.Minute))
+	assert.False(t, poll(grace, false, 12*time.Minute))
+	// Coming back online restarts the grace period
+	assert.True(t, poll(grace, true, 13*time.Minute))
+	assert.True(t, poll(grace, false, 14*time.Minute))
+	assert.True(t, poll(grace, false, 23*time.Minute))
+	assert.False(t, poll(grace, false, 24*time.Minute))
+
+	keep := newOfflinePolicy(config.OfflinePolicyKeep, 0)
+	assert.True(t, poll(keep, true, 0))
+	assert.True(t, poll(keep, false, 365*24*time.Hour))
+
+	// Devices that left the tailnet are forgotten
+	keep.apply(nil, now)
+	assert.Empty(t, keep.offlineSince)
+	assert.False(t, poll(keep, false, 0))
+}
+
+func TestReplay(t *testing.T) {
+	_, err := NewReplay(&config.TailscaleConfig{AddressRanges: []string{"not a range"}}, "", 0)
+	require.Error(t, err)
+
+	replay, err := NewReplay(&config.TailscaleConfig{AddressRanges: []string{config.AddressRangesTailscale},
+		OfflinePolls: 2}, config.OfflinePolicyDelete, 0)
+	require.NoError(t, err)
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	recorded := []Machine{
+		{ID: "n1", Name: "laptop", IPv4Address: "192.168.1.1", IPv6Address: "fd7a:115c:a1e0::1", Online: true},
+		{ID: "n2", Name: "phone", IPv4Address: "100.64.0.2", Online: true},
+	}
+	// Recorded machines naming only their first addresses lose those outside the ranges, the recording isn't changed
+	online := replay.Poll(recorded, now)
+	require.Len(t, online, 2)
+	assert.Empty(t, online[0].IPv4Address)
+	assert.Equal(t, "fd7a:115c:a1e0::1", online[0].IPv6Address)
+	assert.Equal(t, "192.168.1.1", recorded[0].IPv4Address)
+
+	// Polls are smoothed like those of the client
+	recorded[1].Online = false
+	assert.Len(t, replay.Poll(recorded, now.Add(time.Minute)), 2)
+	assert.Len(t, replay.Poll(recorded, now.Add(2*time.Minute)), 1)
+}
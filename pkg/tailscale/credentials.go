@@ -0,0 +1,60 @@
+package tailscale
+
+import (
+	"fmt"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	tailscaleclient "tailscale.com/client/tailscale/v2"
+)
+
+// api returns the API client requests are made with
+func (c *Client) api() *tailscaleclient.Client {
+	c.clientMu.RLock()
+	defer c.clientMu.RUnlock()
+	return c.client
+}
+
+// oauthScopes returns the scopes requested by OAuth clients, posture attributes are only read with device attributes
+func oauthScopes(deviceAttributes bool) []string {
+	scopes := []string{devicesReadScope}
+	if deviceAttributes {
+		scopes = append(scopes, postureAttributesReadScope)
+	}
+	return scopes
+}
+
+// SetCredentials replaces the API key or OAuth client the API is accessed with, e.g. after a rotation, the API key
+// taking precedence like in NewClientFromConfig. Requests in flight finish with the previous credentials, the retry
+// state, request rate and API usage carry over. Requests already started may still use the previous credentials.
+func (c *Client) SetCredentials(cfg *config.TailscaleConfig) error {
+	if c.transport == nil {
+		return fmt.Errorf("credentials are only used in %s mode", config.TailscaleModeAPI)
+	}
+
+	c.clientMu.Lock()
+	defer c.clientMu.Unlock()
+
+	client := &tailscaleclient.Client{
+		BaseURL:   c.client.BaseURL,
+		UserAgent: c.client.UserAgent,
+		Tailnet:   c.tailnet,
+		HTTP:      c.client.HTTP,
+	}
+	base := &errorTransport{usage: c.usage}
+	switch {
+	case cfg.APIKey != "":
+		client.APIKey = cfg.APIKey
+	case cfg.ClientID != "" && cfg.ClientSecret != "":
+		base.base = tailscaleclient.OAuthConfig{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			Scopes:       oauthScopes(c.deviceAttributes),
+		}.HTTPClient().Transport
+	default:
+		return fmt.Errorf("either an api key or a client id and secret are required")
+	}
+
+	c.transport.setBase(base)
+	c.client = client
+	return nil
+}
@@ -0,0 +1,109 @@
+package tailscale
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Kinds of Tailscale API failures, to be checked with errors.Is
+var (
+	// ErrUnauthorized means the API key or OAuth client was rejected or lacks the required scopes
+	ErrUnauthorized = errors.New("tailscale API rejected the credentials")
+	// ErrRateLimited means too many requests were made, APIError.RetryAfter says when to try again if known
+	ErrRateLimited = errors.New("tailscale API rate limit exceeded")
+)
+
+// maxErrorBody limits how much of an error response is kept as the error message
+const maxErrorBody = 1024
+
+// APIError is returned for Tailscale API responses that callers can act on: rejected credentials and rate limiting
+type APIError struct {
+	StatusCode int
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("tailscale API returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// Is maps the status code onto the error kinds above
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// errorTransport turns responses the caller can act on into an APIError. The Tailscale client doesn't expose the
+// status of its own errors, so without this callers could only match on the message.
+type errorTransport struct {
+	base http.RoundTripper
+
+	// usage counts every response, see usage.go
+	usage *usageTracker
+}
+
+func (t *errorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		// Fetching an OAuth token fails before any API request is made
+		var retrieveErr *oauth2.RetrieveError
+		if errors.As(err, &retrieveErr) && retrieveErr.Response != nil {
+			return nil, newAPIError(retrieveErr.Response, string(retrieveErr.Body))
+		}
+		return nil, err
+	}
+	if t.usage != nil {
+		t.usage.observe(resp)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return nil, newAPIError(resp, string(body))
+	}
+	return resp, nil
+}
+
+// newAPIError builds the error for an API response
+func newAPIError(resp *http.Response, body string) *APIError {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Message:    strings.TrimSpace(body),
+	}
+	apiErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	return apiErr
+}
+
+// parseRetryAfter returns the wait a Retry-After header asks for, given in seconds or as an HTTP date, 0 when there is
+// none
+func parseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return 0
+}
@@ -0,0 +1,90 @@
+package tailscale
+
+import (
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+// onlineHysteresis smooths the online state of devices across polls so that devices on flaky links don't flap: a
+// device only goes offline after offlinePolls consecutive polls found it offline, and only comes online after
+// onlinePolls consecutive polls found it online.
+type onlineHysteresis struct {
+	mu           sync.Mutex
+	onlinePolls  int
+	offlinePolls int
+	devices      map[string]*hysteresisState
+}
+
+// hysteresisState is the smoothed online state of a device and how many consecutive polls disagreed with it
+type hysteresisState struct {
+	online bool
+	streak int
+}
+
+// newOnlineHysteresis returns the hysteresis for the thresholds, nil when both are at most one poll
+func newOnlineHysteresis(onlinePolls, offlinePolls int) *onlineHysteresis {
+	if onlinePolls <= 1 && offlinePolls <= 1 {
+		return nil
+	}
+	return &onlineHysteresis{
+		onlinePolls:  max(onlinePolls, 1),
+		offlinePolls: max(offlinePolls, 1),
+		devices:      make(map[string]*hysteresisState),
+	}
+}
+
+// apply replaces the online state of the machines of a poll with their smoothed state. Devices that left the tailnet
+// are forgotten.
+func (h *onlineHysteresis) apply(machines []Machine) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	present := make(map[string]bool, len(machines))
+	for i := range machines {
+		machine := &machines[i]
+		present[machine.ID] = true
+
+		state, ok := h.devices[machine.ID]
+		if !ok {
+			state = &hysteresisState{}
+			h.devices[machine.ID] = state
+		}
+
+		if machine.Online == state.online {
+			state.streak = 0
+			continue
+		}
+
+		state.streak++
+		needed := h.offlinePolls
+		if machine.Online {
+			needed = h.onlinePolls
+		}
+		if state.streak >= needed {
+			state.online, state.streak = machine.Online, 0
+		} else {
+			klog.V(1).Infof("Device %s (%s) seen %s for %d of %d polls, keeping it %s", machine.Name, machine.ID,
+				onlineWord(machine.Online), state.streak, needed, onlineWord(state.online))
+			machine.Online = state.online
+		}
+	}
+
+	for id := range h.devices {
+		if !present[id] {
+			delete(h.devices, id)
+		}
+	}
+}
+
+// onlineWord describes an online state for logging
+func onlineWord(online bool) string {
+	if online {
+		return "online"
+	}
+	return "offline"
+}
@@ -0,0 +1,201 @@
+package tailscale
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"k8s.io/klog/v2"
+)
+
+// In local mode the tool runs on a machine that is already in the tailnet and reads the devices from the local
+// tailscaled through its LocalAPI, the same status `tailscale status --json` prints. It needs no API credentials and
+// sees devices go online and offline as soon as the coordination server does, but only the devices the machine's ACLs
+// let it see, and no posture attributes. The tsnet mode reads the same status from its embedded node.
+//
+// With self_only only the machine itself is published, without its peers, e.g. by a sidecar on every node that
+// publishes its own records instead of one publisher for the whole tailnet. A running tailscaled means the machine is
+// online, whatever the status says about it.
+
+const (
+	// localAPIStatusURL is the LocalAPI status endpoint. The host is a placeholder, requests go to the socket.
+	localAPIStatusURL = "http://local-tailscaled.sock/localapi/v0/status"
+	// localAPICapability is the capability version sent with LocalAPI requests
+	localAPICapability = "1"
+	// backendRunning is the backend state of a node that is logged in and connected
+	backendRunning = "Running"
+)
+
+// localStatus is the part of the LocalAPI status the machines are read from
+type localStatus struct {
+	BackendState string
+	AuthURL      string
+	Self         *localPeer
+	Peer         map[string]*localPeer
+	User         map[int64]localUser
+}
+
+// localPeer is a node in the LocalAPI status
+type localPeer struct {
+	ID            string
+	HostName      string
+	DNSName       string
+	OS            string
+	UserID        int64
+	TailscaleIPs  []string
+	PrimaryRoutes []string
+	Tags          []string
+	Online        bool
+	LastSeen      time.Time
+	ShareeNode    bool
+}
+
+// localUser is a user profile in the LocalAPI status
+type localUser struct {
+	LoginName string
+}
+
+// localRequester sends a request to a LocalAPI
+type localRequester func(req *http.Request) (*http.Response, error)
+
+// localAPIPeers reads machines from the local tailscaled
+type localAPIPeers struct {
+	client   *http.Client
+	selfOnly bool
+}
+
+// newLocalClient returns a client reading the machines from the local tailscaled, listening on the platform's default
+// socket unless another one is configured
+func newLocalClient(cfg *config.LocalConfig) (*Client, error) {
+	socket := cfg.Socket
+	if socket == "" {
+		socket = defaultLocalSocket()
+	}
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}
+	client := &Client{usage: newUsageTracker()}
+	client.peers = &localAPIPeers{
+		client:   &http.Client{Transport: transport, Timeout: time.Minute},
+		selfOnly: cfg.SelfOnly,
+	}
+	if cfg.SelfOnly {
+		klog.Infof("Publishing only this machine, read through the tailscaled LocalAPI at %s", socket)
+	} else {
+		klog.Infof("Discovering devices through the tailscaled LocalAPI at %s", socket)
+	}
+	return client, nil
+}
+
+// defaultLocalSocket returns where tailscaled listens by default on this platform
+func defaultLocalSocket() string {
+	if runtime.GOOS == "darwin" {
+		return "/var/run/tailscaled.socket"
+	}
+	return "/var/run/tailscale/tailscaled.sock"
+}
+
+// machines returns this machine and, unless only this machine is published, its peers
+func (p *localAPIPeers) machines(ctx context.Context, now time.Time) ([]Machine, error) {
+	status, err := readLocalStatus(ctx, p.client.Do)
+	if err != nil {
+		return nil, err
+	}
+	if status.BackendState != backendRunning {
+		return nil, fmt.Errorf("tailscaled is %s, not running", status.BackendState)
+	}
+	if p.selfOnly {
+		if status.Self == nil {
+			return nil, fmt.Errorf("tailscaled status holds no node of this machine")
+		}
+		status.Self.Online = true
+		status.Peer = nil
+	}
+	return machinesFromStatus(status, now), nil
+}
+
+// Close releases the idle connections to tailscaled
+func (p *localAPIPeers) Close() error {
+	p.client.CloseIdleConnections()
+	return nil
+}
+
+// readLocalStatus requests the status from a LocalAPI
+func readLocalStatus(ctx context.Context, do localRequester) (*localStatus, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, localAPIStatusURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Tailscale-Cap", localAPICapability)
+
+	resp, err := do(req)
+	if err != nil {
+		return nil, fmt.Errorf("requesting tailscaled status: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("requesting tailscaled status: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var status localStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("decoding tailscaled status: %w", err)
+	}
+	return &status, nil
+}
+
+// machinesFromStatus converts the node and its peers to machines, sorted by name. Devices shared into the tailnet
+// from others are left out like they are by the API. Online devices count as seen now, the status only tells when
+// offline ones were last seen.
+func machinesFromStatus(status *localStatus, now time.Time) []Machine {
+	peers := make([]*localPeer, 0, len(status.Peer)+1)
+	if status.Self != nil {
+		peers = append(peers, status.Self)
+	}
+	for _, peer := range status.Peer {
+		if !peer.ShareeNode {
+			peers = append(peers, peer)
+		}
+	}
+
+	machines := make([]Machine, 0, len(peers))
+	for _, peer := range peers {
+		machine := Machine{
+			ID:       peer.ID,
+			Name:     strings.TrimSuffix(peer.DNSName, "."),
+			LastSeen: peer.LastSeen,
+			Online:   peer.Online,
+			Tags:     peer.Tags,
+			User:     status.User[peer.UserID].LoginName,
+			OS:       peer.OS,
+		}
+		if machine.Name == "" {
+			machine.Name = peer.HostName
+		}
+		if machine.Online {
+			machine.LastSeen = now
+		}
+		machine.setAddresses(peer.TailscaleIPs)
+		machine.AdvertisedRoutes, machine.ApprovedRoutes = peer.PrimaryRoutes, peer.PrimaryRoutes
+		machines = append(machines, machine)
+	}
+
+	slices.SortFunc(machines, func(a, b Machine) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return machines
+}
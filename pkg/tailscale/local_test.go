@@ -0,0 +1,166 @@
+package tailscale
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testLocalStatus is trimmed down `tailscale status --json` output
+const testLocalStatus = `{
+	"BackendState": "Running",
+	"Self": {
+		"ID": "self",
+		"HostName": "dns",
+		"DNSName": "dns.example.ts.net.",
+		"OS": "linux",
+		"UserID": 1,
+		"TailscaleIPs": ["100.64.0.10", "fd7a:115c:a1e0::a"],
+		"Online": true
+	},
+	"Peer": {
+		"nodekey:01": {
+			"ID": "n1",
+			"HostName": "Laptop",
+			"DNSName": "laptop.example.ts.net.",
+			"OS": "macOS",
+			"UserID": 1,
+			"TailscaleIPs": ["100.64.0.1", "fd7a:115c:a1e0::1"],
+			"Online": true,
+			"LastSeen": "0001-01-01T00:00:00Z"
+		},
+		"nodekey:02": {
+			"ID": "n2",
+			"HostName": "db",
+			"DNSName": "db.example.ts.net.",
+			"OS": "linux",
+			"UserID": 2,
+			"TailscaleIPs": ["100.64.0.2"],
+			"PrimaryRoutes": ["192.168.1.0/24"],
+			"Tags": ["tag:server"],
+			"Online": false,
+			"LastSeen": "2024-01-01T11:00:00Z"
+		},
+		"nodekey:03": {
+			"ID": "n3",
+			"HostName": "shared",
+			"DNSName": "shared.other.ts.net.",
+			"UserID": 3,
+			"TailscaleIPs": ["100.64.0.3"],
+			"Online": true,
+			"ShareeNode": true
+		}
+	},
+	"User": {
+		"1": {"ID": 1, "LoginName": "alice@example.com"},
+		"2": {"ID": 2, "LoginName": "tagged-devices"}
+	}
+}`
+
+func TestMachinesFromStatus(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	var status localStatus
+	require.NoError(t, json.Unmarshal([]byte(testLocalStatus), &status))
+
+	assert.Equal(t, []Machine{
+		{
+			ID:            "n2",
+			Name:          "db.example.ts.net",
+			IPv4Address:   "100.64.0.2",
+			IPv4Addresses: []string{"100.64.0.2"},
+			LastSeen:      time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC),
+			Tags:          []string{"tag:server"},
+			User:          "tagged-devices",
+			OS:            "linux",
+
+			AdvertisedRoutes: []string{"192.168.1.0/24"},
+			ApprovedRoutes:   []string{"192.168.1.0/24"},
+		},
+		{
+			ID:            "self",
+			Name:          "dns.example.ts.net",
+			IPv4Address:   "100.64.0.10",
+			IPv4Addresses: []string{"100.64.0.10"},
+			IPv6Address:   "fd7a:115c:a1e0::a",
+			IPv6Addresses: []string{"fd7a:115c:a1e0::a"},
+			LastSeen:      now,
+			Online:        true,
+			User:          "alice@example.com",
+			OS:            "linux",
+		},
+		{
+			ID:            "n1",
+			Name:          "laptop.example.ts.net",
+			IPv4Address:   "100.64.0.1",
+			IPv4Addresses: []string{"100.64.0.1"},
+			IPv6Address:   "fd7a:115c:a1e0::1",
+			IPv6Addresses: []string{"fd7a:115c:a1e0::1"},
+			LastSeen:      now,
+			Online:        true,
+			User:          "alice@example.com",
+			OS:            "macOS",
+		},
+	}, machinesFromStatus(&status, now))
+}
+
+func TestLocalClient(t *testing.T) {
+	// Unix socket paths are short, so the socket doesn't go into the test's temporary directory
+	dir, err := os.MkdirTemp("", "tsbd")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "tailscaled.sock")
+
+	status := testLocalStatus
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/localapi/v0/status" || r.Header.Get("Tailscale-Cap") == "" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(status))
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	client, err := newLocalClient(&config.LocalConfig{Socket: socket})
+	require.NoError(t, err)
+	defer client.Close()
+	client.SetClock(clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)))
+
+	machines, err := client.GetOnlineMachines(context.Background())
+	require.NoError(t, err)
+	require.Len(t, machines, 2)
+	assert.Equal(t, "dns.example.ts.net", machines[0].Name)
+	assert.Equal(t, "laptop.example.ts.net", machines[1].Name)
+
+	// Only the machine itself is published by a sidecar, online while tailscaled runs
+	selfClient, err := newLocalClient(&config.LocalConfig{Socket: socket, SelfOnly: true})
+	require.NoError(t, err)
+	defer selfClient.Close()
+	status = strings.Replace(testLocalStatus, `"Online": true`, `"Online": false`, 1)
+	require.NotEqual(t, testLocalStatus, status)
+	machines, err = selfClient.GetOnlineMachines(context.Background())
+	require.NoError(t, err)
+	require.Len(t, machines, 1)
+	assert.Equal(t, "dns.example.ts.net", machines[0].Name)
+
+	// Nothing is published while tailscaled isn't connected to the tailnet
+	status = `{"BackendState": "NeedsLogin"}`
+	_, err = client.GetMachines(context.Background())
+	assert.ErrorContains(t, err, "NeedsLogin")
+}
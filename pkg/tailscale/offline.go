@@ -0,0 +1,75 @@
+package tailscale
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"k8s.io/klog/v2"
+)
+
+// offlinePolicy keeps devices that went offline reported online, so that their records stay published: for as long as
+// they are in the tailnet with the keep policy, for the grace period with the grace policy. Only devices seen online
+// since the start are kept, devices that left the tailnet are forgotten.
+type offlinePolicy struct {
+	mu    sync.Mutex
+	keep  bool
+	grace time.Duration
+	// When the devices seen online went offline, zero while they are online
+	offlineSince map[string]time.Time
+}
+
+// newOfflinePolicy returns the policy for bind.offline_policy, nil when the records of devices going offline are
+// withdrawn right away
+func newOfflinePolicy(policy string, grace time.Duration) *offlinePolicy {
+	switch policy {
+	case config.OfflinePolicyKeep:
+		return &offlinePolicy{keep: true, offlineSince: make(map[string]time.Time)}
+	case config.OfflinePolicyGrace:
+		return &offlinePolicy{grace: grace, offlineSince: make(map[string]time.Time)}
+	default:
+		return nil
+	}
+}
+
+// apply reports the devices of a poll the policy keeps as online
+func (p *offlinePolicy) apply(machines []Machine, now time.Time) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	present := make(map[string]bool, len(machines))
+	for i := range machines {
+		machine := &machines[i]
+		present[machine.ID] = true
+
+		since, seen := p.offlineSince[machine.ID]
+		switch {
+		case machine.Online:
+			p.offlineSince[machine.ID] = time.Time{}
+		case !seen:
+			// Never seen online, so nothing of it was published
+		case p.keep:
+			machine.Online = true
+		default:
+			if since.IsZero() {
+				since = now
+				p.offlineSince[machine.ID] = since
+			}
+			if offline := now.Sub(since); offline < p.grace {
+				klog.V(1).Infof("Device %s (%s) offline for %v, keeping it published for the grace period of %v",
+					machine.Name, machine.ID, offline.Truncate(time.Second), p.grace)
+				machine.Online = true
+			}
+		}
+	}
+
+	for id := range p.offlineSince {
+		if !present[id] {
+			delete(p.offlineSince, id)
+		}
+	}
+}
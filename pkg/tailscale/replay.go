@@ -0,0 +1,64 @@
+package tailscale
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"k8s.io/klog/v2"
+)
+
+// Replay takes recorded polls, e.g. the snapshots of a simulation, through what the client does to the machines of a
+// poll before handing the online ones on: dropping addresses outside tailscale.address_ranges, smoothing the online
+// state with online_polls/offline_polls and keeping devices that went offline with bind.offline_policy. The online
+// state of recorded machines is taken as the online heuristic reported it.
+type Replay struct {
+	addressRanges []netip.Prefix
+	hysteresis    *onlineHysteresis
+	offline       *offlinePolicy
+}
+
+// NewReplay creates a replay of polls of a client configured with cfg and the offline policy
+func NewReplay(cfg *config.TailscaleConfig, offlinePolicy string, grace time.Duration) (*Replay, error) {
+	addressRanges, err := parseAddressRanges(cfg.AddressRanges)
+	if err != nil {
+		return nil, err
+	}
+	return &Replay{
+		addressRanges: addressRanges,
+		hysteresis:    newOnlineHysteresis(cfg.OnlinePolls, cfg.OfflinePolls),
+		offline:       newOfflinePolicy(offlinePolicy, grace),
+	}, nil
+}
+
+// Poll returns the machines of a poll recorded at now that the client would report online, like GetOnlineMachines.
+// Polls have to be replayed in the order they were recorded.
+func (r *Replay) Poll(machines []Machine, now time.Time) []Machine {
+	machines = slices.Clone(machines)
+	if len(r.addressRanges) > 0 {
+		for i := range machines {
+			machine := &machines[i]
+			// Recorded machines may only name their first addresses
+			machine.IPv4Addresses = slices.Clone(machine.IPv4Addresses)
+			if len(machine.IPv4Addresses) == 0 && machine.IPv4Address != "" {
+				machine.IPv4Addresses = []string{machine.IPv4Address}
+			}
+			machine.IPv6Addresses = slices.Clone(machine.IPv6Addresses)
+			if len(machine.IPv6Addresses) == 0 && machine.IPv6Address != "" {
+				machine.IPv6Addresses = []string{machine.IPv6Address}
+			}
+			if dropped := machine.keepAddresses(r.addressRanges); len(dropped) > 0 {
+				klog.V(1).Infof("Ignoring addresses %s of %s (%s) outside tailscale.address_ranges",
+					strings.Join(dropped, ", "), machine.Name, machine.ID)
+			}
+		}
+	}
+	r.hysteresis.apply(machines)
+	r.offline.apply(machines, now)
+
+	return slices.DeleteFunc(machines, func(machine Machine) bool {
+		return !machine.Online
+	})
+}
@@ -0,0 +1,197 @@
+package tailscale
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"k8s.io/klog/v2"
+)
+
+// API requests are spaced out to tailscale.max_requests_per_minute and retried with backoff when they fail transiently:
+// connection errors, server errors and rate limiting, so that a single failure doesn't cost a whole poll. The
+// Retry-After of a response is waited out when it's within the retry policy's max_backoff. A longer one, or one sent
+// with the last attempt, fails the request and further requests fail right away until it passed instead of being sent
+// to an API that refuses them.
+
+// retryTransport retries failed API requests and spaces requests out
+type retryTransport struct {
+	base http.RoundTripper
+
+	// Policy of the retries, requests aren't retried when nil
+	retry *config.RetryConfig
+	// Shortest time between the start of two requests, 0 for no limit
+	interval time.Duration
+	// Clock of the waits, the real clock when nil
+	clock clock.Clock
+
+	mu sync.Mutex
+	// Earliest start of the next request
+	next time.Time
+	// Requests fail right away until then, as the API asked for with a Retry-After
+	blockedUntil time.Time
+}
+
+// configure sets the retry policy and the request rate of the transport
+func (t *retryTransport) configure(retry config.RetryConfig, maxRequestsPerMinute int) {
+	t.retry = &retry
+	t.interval = 0
+	if maxRequestsPerMinute > 0 {
+		t.interval = time.Minute / time.Duration(maxRequestsPerMinute)
+	}
+}
+
+// setBase replaces the transport requests are sent with, e.g. with one holding new credentials, keeping the retry
+// state
+func (t *retryTransport) setBase(base http.RoundTripper) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.base = base
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var backoff time.Duration
+	for attempt := 1; ; attempt++ {
+		if err := t.wait(req.Context()); err != nil {
+			return nil, err
+		}
+
+		t.mu.Lock()
+		base := t.base
+		t.mu.Unlock()
+		resp, err := base.RoundTrip(req)
+		retryAfter, retryable := retryableResponse(resp, err)
+		if !retryable || t.retry == nil {
+			return resp, err
+		}
+		if (t.retry.MaxAttempts > 0 && attempt >= t.retry.MaxAttempts) ||
+			retryAfter > cmp.Or(t.retry.MaxBackoff, config.DefaultAPIRetryMaxBackoff) ||
+			(req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+			t.block(retryAfter)
+			return resp, err
+		}
+		backoff = t.nextBackoff(backoff)
+		wait := max(retryAfter, t.jitter(backoff))
+
+		var failure string
+		if resp != nil {
+			failure = "returned " + resp.Status
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxErrorBody))
+			resp.Body.Close()
+		} else {
+			failure = err.Error()
+		}
+		klog.V(1).Infof("Tailscale API request %s %s failed (attempt %d), retrying in %v: %s", req.Method,
+			req.URL.Path, attempt, wait, failure)
+
+		if err := t.sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// retryableResponse reports whether a request failed transiently and how long the API asked to wait before retrying
+func retryableResponse(resp *http.Response, err error) (time.Duration, bool) {
+	if err != nil {
+		var apiErr *APIError
+		switch {
+		case errors.As(err, &apiErr):
+			return apiErr.RetryAfter, errors.Is(apiErr, ErrRateLimited)
+		case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+			return 0, false
+		}
+		return 0, true
+	}
+
+	switch resp.StatusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return parseRetryAfter(resp.Header.Get("Retry-After")), true
+	}
+	return 0, false
+}
+
+// nextBackoff doubles the backoff from the initial backoff of the retry policy up to its maximum backoff
+func (t *retryTransport) nextBackoff(backoff time.Duration) time.Duration {
+	initial := cmp.Or(t.retry.InitialBackoff, config.DefaultAPIRetryInitialBackoff)
+	if backoff == 0 {
+		return initial
+	}
+	return min(2*backoff, max(cmp.Or(t.retry.MaxBackoff, config.DefaultAPIRetryMaxBackoff), initial))
+}
+
+// jitter randomly shortens or lengthens a backoff by up to the jitter fraction of the retry policy
+func (t *retryTransport) jitter(backoff time.Duration) time.Duration {
+	if t.retry.Jitter <= 0 {
+		return backoff
+	}
+	return backoff + time.Duration(t.retry.Jitter*(2*rand.Float64()-1)*float64(backoff))
+}
+
+// wait waits for the request's turn under the request rate, failing right away while the API asked for requests to
+// hold off
+func (t *retryTransport) wait(ctx context.Context) error {
+	clk := clock.Or(t.clock)
+
+	t.mu.Lock()
+	now := clk.Now()
+	if now.Before(t.blockedUntil) {
+		retryAfter := t.blockedUntil.Sub(now)
+		t.mu.Unlock()
+		return &APIError{
+			StatusCode: http.StatusTooManyRequests,
+			Message:    "not sent, holding off as asked by an earlier response",
+			RetryAfter: retryAfter,
+		}
+	}
+	start := now
+	if t.interval > 0 {
+		if t.next.After(now) {
+			start = t.next
+		}
+		t.next = start.Add(t.interval)
+	}
+	t.mu.Unlock()
+
+	return t.sleep(ctx, start.Sub(now))
+}
+
+// block makes requests fail right away for the given time
+func (t *retryTransport) block(retryAfter time.Duration) {
+	if retryAfter <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.blockedUntil = clock.Or(t.clock).Now().Add(retryAfter)
+}
+
+// sleep waits for the given time or until the context is cancelled
+func (t *retryTransport) sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := clock.Or(t.clock).NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,75 @@
+//go:build tsnet
+
+package tailscale
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"k8s.io/klog/v2"
+	"tailscale.com/client/local"
+	"tailscale.com/tsnet"
+)
+
+// In tsnet mode the tool joins the tailnet as a node of its own and reads the devices from the netmap the
+// coordination server sends it, so it needs neither an API key nor an OAuth client. The status of the embedded node is
+// read through its LocalAPI like in local mode, see local.go.
+
+// tsnetPeers reads machines from the netmap of an embedded tailnet node
+type tsnetPeers struct {
+	server *tsnet.Server
+	local  *local.Client
+}
+
+// newTSNetClient starts a node joining the tailnet and returns a client reading the machines from its netmap. The node
+// logs in with the auth key on its first start, or logs a login URL without one, and reuses its state afterwards.
+func newTSNetClient(cfg *config.TSNetConfig) (*Client, error) {
+	server := &tsnet.Server{
+		Hostname: cfg.Hostname,
+		Dir:      cfg.StateDir,
+		AuthKey:  cfg.AuthKey,
+		UserLogf: klog.Infof,
+		Logf: func(format string, args ...any) {
+			klog.V(4).Infof(format, args...)
+		},
+	}
+	if err := server.Start(); err != nil {
+		return nil, fmt.Errorf("starting tsnet node: %w", err)
+	}
+	lc, err := server.LocalClient()
+	if err != nil {
+		server.Close()
+		return nil, fmt.Errorf("connecting to tsnet node: %w", err)
+	}
+
+	client := &Client{usage: newUsageTracker()}
+	client.peers = &tsnetPeers{server: server, local: lc}
+	klog.Infof("Joining the tailnet as %s to discover devices", cfg.Hostname)
+	return client, nil
+}
+
+// machines returns the node itself and its peers, waiting for the node to be logged in and connected first
+func (p *tsnetPeers) machines(ctx context.Context, now time.Time) ([]Machine, error) {
+	status, err := readLocalStatus(ctx, p.local.DoLocalRequest)
+	if err != nil {
+		return nil, err
+	}
+	if status.BackendState != backendRunning {
+		// Waits for the login, the login URL of an interactive login is logged meanwhile
+		klog.Infof("Waiting for the tsnet node to connect to the tailnet (%s)", status.BackendState)
+		if _, err := p.server.Up(ctx); err != nil {
+			return nil, fmt.Errorf("waiting for tsnet node to connect: %w", err)
+		}
+		if status, err = readLocalStatus(ctx, p.local.DoLocalRequest); err != nil {
+			return nil, err
+		}
+	}
+	return machinesFromStatus(status, now), nil
+}
+
+// Close leaves the tailnet
+func (p *tsnetPeers) Close() error {
+	return p.server.Close()
+}
@@ -0,0 +1,16 @@
+//go:build !tsnet
+
+package tailscale
+
+import (
+	"fmt"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+)
+
+// newTSNetClient rejects tsnet mode in builds without the embedded tailnet node, which is only compiled in with
+// -tags tsnet
+func newTSNetClient(*config.TSNetConfig) (*Client, error) {
+	return nil, fmt.Errorf("tailscale mode %s is not available in this build (build with -tags tsnet)",
+		config.TailscaleModeTSNet)
+}
@@ -0,0 +1,132 @@
+package tailscale
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// DefaultRequestsPerMinute is the API request budget polling is checked against when the API doesn't announce a rate
+// limit. Tailscale doesn't publish its limits, so this is a conservative estimate rather than a documented value.
+const DefaultRequestsPerMinute = 100
+
+// Usage is the Tailscale API usage of the current day (UTC)
+type Usage struct {
+	Day         string `json:"day"`
+	Requests    int    `json:"requests"`
+	RateLimited int    `json:"rate_limited"`
+
+	// Rate limit announced by the most recent response, -1 when the API sent none
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	Reset     time.Time `json:"reset,omitzero"`
+
+	// Devices is the number of devices in the tailnet as of the most recent poll
+	Devices int `json:"devices"`
+}
+
+// usageTracker counts API requests per day and remembers the most recent rate limit headers
+type usageTracker struct {
+	mu    sync.Mutex
+	usage Usage
+	now   func() time.Time
+}
+
+// newUsageTracker returns a tracker for the current day
+func newUsageTracker() *usageTracker {
+	return &usageTracker{usage: Usage{Limit: -1, Remaining: -1}, now: time.Now}
+}
+
+// rollover starts a new day's counters once the day changed. Must be called with mu held.
+func (u *usageTracker) rollover() {
+	day := u.now().UTC().Format(time.DateOnly)
+	if u.usage.Day != day {
+		u.usage.Day = day
+		u.usage.Requests = 0
+		u.usage.RateLimited = 0
+	}
+}
+
+// observe counts a response and reads the rate limit headers from it
+func (u *usageTracker) observe(resp *http.Response) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.rollover()
+	u.usage.Requests++
+	if resp.StatusCode == http.StatusTooManyRequests {
+		u.usage.RateLimited++
+	}
+
+	if limit, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit")); err == nil {
+		u.usage.Limit = limit
+	}
+	if remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining")); err == nil {
+		u.usage.Remaining = remaining
+	}
+	if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		u.usage.Reset = time.Unix(reset, 0)
+	}
+}
+
+// setDevices records the number of devices in the tailnet
+func (u *usageTracker) setDevices(devices int) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.usage.Devices = devices
+}
+
+// snapshot returns the usage of the current day. A nil tracker, as used by clients built in tests, reports nothing.
+func (u *usageTracker) snapshot() Usage {
+	if u == nil {
+		return Usage{Limit: -1, Remaining: -1}
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.rollover()
+	return u.usage
+}
+
+// Usage returns the API usage of the current day
+func (c *Client) Usage() Usage {
+	return c.usage.snapshot()
+}
+
+// requestsPerPoll estimates how many API requests a poll makes: one to list the devices plus one per online device when
+// device attributes are read
+func (c *Client) requestsPerPoll(onlineDevices int) int {
+	if c.deviceAttributes {
+		return 1 + onlineDevices
+	}
+	return 1
+}
+
+// checkPollBudget warns when polling at pollInterval would exceed the API rate limit, the announced one if the API sent
+// one and DefaultRequestsPerMinute otherwise
+func (c *Client) checkPollBudget(pollInterval time.Duration, onlineDevices int) bool {
+	if pollInterval <= 0 {
+		return true
+	}
+
+	limit := DefaultRequestsPerMinute
+	if usage := c.Usage(); usage.Limit > 0 {
+		limit = usage.Limit
+	}
+
+	perMinute := float64(c.requestsPerPoll(onlineDevices)) * float64(time.Minute) / float64(pollInterval)
+	if perMinute <= float64(limit) {
+		return true
+	}
+	klog.Warningf("Polling every %v makes about %.0f Tailscale API requests per minute for %d online devices, more "+
+		"than the limit of %d: expect rate limiting, consider a longer poll interval", pollInterval, perMinute,
+		onlineDevices, limit)
+	return false
+}
@@ -0,0 +1,59 @@
+package update
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The checksums file of every release is signed with cosign (cosign sign-blob, see .goreleaser.yml), and release
+// builds carry the public key it's signed with. The checksums only prove that a download is intact, since they come
+// from the same release; the signature proves that they were published by whoever holds the release key. Updates are
+// refused when the signature is missing or doesn't verify, and by builds without the key, e.g. development builds.
+
+// releaseKey is the public key the checksums of releases are signed with, the base64 encoded PKIX key (the body of
+// cosign.pub on a single line). Release builds set it with -ldflags -X.
+var releaseKey string
+
+var (
+	// ErrNoReleaseKey is returned when the binary was built without the key releases are signed with
+	ErrNoReleaseKey = errors.New("this build has no release key to verify updates with")
+	// ErrSignatureMissing is returned when a release has no signature of its checksums
+	ErrSignatureMissing = errors.New("checksums are not signed")
+	// ErrSignatureMismatch is returned when the signature of the checksums doesn't verify with the release key
+	ErrSignatureMismatch = errors.New("checksums signature doesn't verify")
+)
+
+// parseReleaseKey parses a public key as set in releaseKey, cosign keys are ECDSA P-256 keys
+func parseReleaseKey(key string) (*ecdsa.PublicKey, error) {
+	der, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil {
+		return nil, fmt.Errorf("decoding release key: %w", err)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("parsing release key: %w", err)
+	}
+	publicKey, ok := parsed.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("release key is a %T, not an ECDSA key", parsed)
+	}
+	return publicKey, nil
+}
+
+// verifySignature checks a cosign signature, the base64 encoded ASN.1 ECDSA signature of the SHA-256 digest of data
+func verifySignature(publicKey *ecdsa.PublicKey, data, signature []byte) error {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return fmt.Errorf("decoding signature: %w", err)
+	}
+	digest := sha256.Sum256(data)
+	if !ecdsa.VerifyASN1(publicKey, digest[:], sig) {
+		return ErrSignatureMismatch
+	}
+	return nil
+}
@@ -0,0 +1,378 @@
+package update
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefaultAPIURL is the GitHub API the latest release is looked up from
+	DefaultAPIURL = "https://api.github.com"
+	// Repository is the GitHub repository releases are published to
+	Repository = "aauren/tailscale-bind-ddns"
+
+	projectName = "tailscale-bind-ddns"
+	// maxDownloadSize bounds how much of a release asset is read, releases are a few tens of megabytes
+	maxDownloadSize = 256 << 20
+	requestTimeout  = 5 * time.Minute
+)
+
+// ErrChecksumMismatch is returned when a downloaded archive doesn't match the checksum published with the release
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Release is a published release of the application
+type Release struct {
+	TagName string  `json:"tag_name"`
+	Assets  []Asset `json:"assets"`
+}
+
+// Asset is a file attached to a release
+type Asset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+// Version returns the release version without the leading v, as used in asset names
+func (r *Release) Version() string {
+	return strings.TrimPrefix(r.TagName, "v")
+}
+
+// asset returns the asset with the given name
+func (r *Release) asset(name string) (Asset, error) {
+	for _, asset := range r.Assets {
+		if asset.Name == name {
+			return asset, nil
+		}
+	}
+	return Asset{}, fmt.Errorf("release %s has no asset %s", r.TagName, name)
+}
+
+// Updater replaces the running binary with the build of the latest release for its platform
+type Updater struct {
+	// APIURL is the GitHub API base URL
+	APIURL string
+	// Repository is the owner/name of the repository releases are looked up in
+	Repository string
+	// OS and Arch select the release archive, they default to the platform the binary was built for
+	OS   string
+	Arch string
+	// PublicKey verifies the signature of the release checksums, updates are refused without it
+	PublicKey *ecdsa.PublicKey
+
+	client *http.Client
+}
+
+// NewUpdater creates an updater for the official releases of the platform the binary was built for
+func NewUpdater() *Updater {
+	u := &Updater{
+		APIURL:     DefaultAPIURL,
+		Repository: Repository,
+		OS:         runtime.GOOS,
+		Arch:       buildArch(),
+		client:     &http.Client{Timeout: requestTimeout},
+	}
+	if releaseKey != "" {
+		publicKey, err := parseReleaseKey(releaseKey)
+		if err != nil {
+			klog.Errorf("Invalid release key in this build, updates can't be verified: %v", err)
+		}
+		u.PublicKey = publicKey
+	}
+	return u
+}
+
+// buildArch returns the architecture as named in release archives, which carry the ARM version for 32-bit ARM builds
+func buildArch() string {
+	if runtime.GOARCH != "arm" {
+		return runtime.GOARCH
+	}
+	goarm := "7"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "GOARM" && setting.Value != "" {
+				// GOARM may carry a float ABI suffix such as 7,softfloat
+				goarm = strings.SplitN(setting.Value, ",", 2)[0]
+			}
+		}
+	}
+	return "armv" + goarm
+}
+
+// archiveName returns the name of the release archive holding the binary for the updater's platform
+func (u *Updater) archiveName(release *Release) string {
+	ext := "tar.gz"
+	if u.OS == "windows" {
+		ext = "zip"
+	}
+	return fmt.Sprintf("%s_%s_%s_%s.%s", projectName, release.Version(), u.OS, u.Arch, ext)
+}
+
+// binaryName returns the name of the binary inside release archives
+func (u *Updater) binaryName() string {
+	if u.OS == "windows" {
+		return projectName + ".exe"
+	}
+	return projectName
+}
+
+// Latest looks up the latest release
+func (u *Updater) Latest(ctx context.Context) (*Release, error) {
+	url := fmt.Sprintf("%s/repos/%s/releases/latest", strings.TrimSuffix(u.APIURL, "/"), u.Repository)
+	body, err := u.get(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("fetching latest release: %w", err)
+	}
+
+	var release Release
+	if err := json.Unmarshal(body, &release); err != nil {
+		return nil, fmt.Errorf("decoding latest release: %w", err)
+	}
+	if release.TagName == "" {
+		return nil, fmt.Errorf("latest release has no tag")
+	}
+	return &release, nil
+}
+
+// Download fetches the release archive for the updater's platform, verifies the signature of the checksums published
+// with the release and the archive against them, and returns the binary the archive contains
+func (u *Updater) Download(ctx context.Context, release *Release) ([]byte, error) {
+	if u.PublicKey == nil {
+		return nil, ErrNoReleaseKey
+	}
+	archiveName := u.archiveName(release)
+	archiveAsset, err := release.asset(archiveName)
+	if err != nil {
+		return nil, fmt.Errorf("no release archive for %s/%s: %w", u.OS, u.Arch, err)
+	}
+	checksumsName := fmt.Sprintf("%s_%s_checksums.txt", projectName, release.Version())
+	checksumAsset, err := release.asset(checksumsName)
+	if err != nil {
+		return nil, err
+	}
+	signatureAsset, err := release.asset(checksumsName + ".sig")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSignatureMissing, err)
+	}
+
+	checksums, err := u.get(ctx, checksumAsset.URL)
+	if err != nil {
+		return nil, fmt.Errorf("fetching checksums: %w", err)
+	}
+	signature, err := u.get(ctx, signatureAsset.URL)
+	if err != nil {
+		return nil, fmt.Errorf("fetching checksums signature: %w", err)
+	}
+	if err := verifySignature(u.PublicKey, checksums, signature); err != nil {
+		return nil, fmt.Errorf("%s: %w", checksumsName, err)
+	}
+	expected, err := findChecksum(checksums, archiveName)
+	if err != nil {
+		return nil, err
+	}
+
+	klog.V(1).Infof("Downloading %s", archiveAsset.URL)
+	archive, err := u.get(ctx, archiveAsset.URL)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", archiveName, err)
+	}
+	sum := sha256.Sum256(archive)
+	if actual := hex.EncodeToString(sum[:]); actual != expected {
+		return nil, fmt.Errorf("%s: %w (expected %s, got %s)", archiveName, ErrChecksumMismatch, expected, actual)
+	}
+
+	if strings.HasSuffix(archiveName, ".zip") {
+		return extractZip(archive, u.binaryName())
+	}
+	return extractTarGz(archive, u.binaryName())
+}
+
+// get fetches a URL and returns its body
+func (u *Updater) get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("User-Agent", projectName)
+
+	client := u.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxDownloadSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+	if len(body) > maxDownloadSize {
+		return nil, fmt.Errorf("response is larger than %d bytes", maxDownloadSize)
+	}
+	return body, nil
+}
+
+// findChecksum returns the SHA-256 checksum of a file from a sha256sum style checksums file
+func findChecksum(checksums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("no checksum published for %s", name)
+}
+
+// extractTarGz returns the contents of the named file from a gzipped tarball
+func extractTarGz(archive []byte, name string) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, fmt.Errorf("reading archive: %w", err)
+	}
+	defer gz.Close()
+
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("archive has no %s", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading archive: %w", err)
+		}
+		if header.Typeflag == tar.TypeReg && filepath.Base(header.Name) == name {
+			return io.ReadAll(io.LimitReader(reader, maxDownloadSize))
+		}
+	}
+}
+
+// extractZip returns the contents of the named file from a zip archive
+func extractZip(archive []byte, name string) ([]byte, error) {
+	reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return nil, fmt.Errorf("reading archive: %w", err)
+	}
+	for _, file := range reader.File {
+		if filepath.Base(file.Name) != name {
+			continue
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("reading %s from archive: %w", name, err)
+		}
+		defer rc.Close()
+		return io.ReadAll(io.LimitReader(rc, maxDownloadSize))
+	}
+	return nil, fmt.Errorf("archive has no %s", name)
+}
+
+// Replace atomically replaces the binary at path with a new one, keeping its permissions. The new binary is written
+// next to the old one and renamed over it, so a failed update leaves the old binary in place.
+func Replace(path string, binary []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("reading current binary: %w", err)
+	}
+
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".new-*")
+	if err != nil {
+		return fmt.Errorf("creating new binary: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing new binary: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing new binary: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("setting permissions of new binary: %w", err)
+	}
+
+	// Windows doesn't allow replacing a running executable, but it does allow renaming it out of the way
+	if runtime.GOOS == "windows" {
+		old := path + ".old"
+		_ = os.Remove(old)
+		if err := os.Rename(path, old); err != nil {
+			return fmt.Errorf("moving current binary aside: %w", err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replacing binary: %w", err)
+	}
+	return nil
+}
+
+// Newer reports whether version a is newer than version b. Versions are compared by their dot separated numeric
+// components, anything that isn't a release version (such as a dev build) is older than every release.
+func Newer(a, b string) bool {
+	aParts, aOK := parseVersion(a)
+	bParts, bOK := parseVersion(b)
+	if !aOK || !bOK {
+		return aOK && !bOK
+	}
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		var x, y int
+		if i < len(aParts) {
+			x = aParts[i]
+		}
+		if i < len(bParts) {
+			y = bParts[i]
+		}
+		if x != y {
+			return x > y
+		}
+	}
+	return false
+}
+
+// parseVersion splits a version such as v1.2.3 into its numeric components, ignoring pre-release and build suffixes
+func parseVersion(version string) ([]int, bool) {
+	version = strings.TrimPrefix(version, "v")
+	if i := strings.IndexAny(version, "-+"); i >= 0 {
+		version = version[:i]
+	}
+	if version == "" {
+		return nil, false
+	}
+
+	var parts []int
+	for _, field := range strings.Split(version, ".") {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		parts = append(parts, n)
+	}
+	return parts, true
+}
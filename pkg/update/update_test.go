@@ -0,0 +1,248 @@
+package update
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTarGz builds a gzipped tarball holding the given files
+func testTarGz(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name: name, Mode: 0o755, Size: int64(len(content)), Typeflag: tar.TypeReg,
+		}))
+		_, err := tw.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+// testSign signs data the way cosign sign-blob does, returning the base64 encoded signature
+func testSign(t *testing.T, key *ecdsa.PrivateKey, data string) string {
+	t.Helper()
+	digest := sha256.Sum256([]byte(data))
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	require.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+// startTestReleaseServer serves a latest release for linux/arm64 with the given archive, checksums file and its
+// signature, the release has no signature when it's empty
+func startTestReleaseServer(t *testing.T, archive []byte, checksums, signature string) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	mux.HandleFunc("/repos/aauren/tailscale-bind-ddns/releases/latest", func(w http.ResponseWriter, r *http.Request) {
+		assets := []Asset{
+			{Name: "tailscale-bind-ddns_1.4.0_linux_arm64.tar.gz", URL: server.URL + "/download/archive"},
+			{Name: "tailscale-bind-ddns_1.4.0_checksums.txt", URL: server.URL + "/download/checksums"},
+		}
+		if signature != "" {
+			assets = append(assets, Asset{Name: "tailscale-bind-ddns_1.4.0_checksums.txt.sig",
+				URL: server.URL + "/download/signature"})
+		}
+		_ = json.NewEncoder(w).Encode(Release{TagName: "v1.4.0", Assets: assets})
+	})
+	mux.HandleFunc("/download/archive", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(archive)
+	})
+	mux.HandleFunc("/download/checksums", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(checksums))
+	})
+	mux.HandleFunc("/download/signature", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(signature))
+	})
+	return server
+}
+
+func TestUpdater(t *testing.T) {
+	binary := []byte("#!/bin/sh\necho new release\n")
+	archive := testTarGz(t, map[string][]byte{
+		"README.md":           []byte("readme"),
+		"tailscale-bind-ddns": binary,
+	})
+	sum := sha256.Sum256(archive)
+	checksum := hex.EncodeToString(sum[:])
+	checksums := fmt.Sprintf("%s  tailscale-bind-ddns_1.4.0_linux_amd64.tar.gz\n%s  tailscale-bind-ddns_1.4.0_linux_arm64.tar.gz\n",
+		"00", checksum)
+	mismatched := fmt.Sprintf("%064d  tailscale-bind-ddns_1.4.0_linux_arm64.tar.gz\n", 0)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		arch      string
+		checksums string
+		signature string
+		noKey     bool
+		wantErr   error
+		errMsg    string
+	}{
+		{
+			name:      "verified download",
+			arch:      "arm64",
+			checksums: checksums,
+			signature: testSign(t, key, checksums),
+		},
+		{
+			name:      "checksum mismatch",
+			arch:      "arm64",
+			checksums: mismatched,
+			signature: testSign(t, key, mismatched),
+			wantErr:   ErrChecksumMismatch,
+		},
+		{
+			name:      "archive without checksum",
+			arch:      "arm64",
+			checksums: "",
+			signature: testSign(t, key, ""),
+			errMsg:    "no checksum published",
+		},
+		{
+			name:      "platform without archive",
+			arch:      "armv7",
+			checksums: checksums,
+			signature: testSign(t, key, checksums),
+			errMsg:    "no release archive for linux/armv7",
+		},
+		{
+			name:      "unsigned checksums",
+			arch:      "arm64",
+			checksums: checksums,
+			wantErr:   ErrSignatureMissing,
+		},
+		{
+			name:      "checksums signed with another key",
+			arch:      "arm64",
+			checksums: checksums,
+			signature: testSign(t, otherKey, checksums),
+			wantErr:   ErrSignatureMismatch,
+		},
+		{
+			name:      "checksums changed after signing",
+			arch:      "arm64",
+			checksums: mismatched,
+			signature: testSign(t, key, checksums),
+			wantErr:   ErrSignatureMismatch,
+		},
+		{
+			name:      "build without release key",
+			arch:      "arm64",
+			checksums: checksums,
+			signature: testSign(t, key, checksums),
+			noKey:     true,
+			wantErr:   ErrNoReleaseKey,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startTestReleaseServer(t, archive, tt.checksums, tt.signature)
+			updater := &Updater{APIURL: server.URL, Repository: Repository, OS: "linux", Arch: tt.arch}
+			if !tt.noKey {
+				updater.PublicKey = &key.PublicKey
+			}
+
+			release, err := updater.Latest(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, "1.4.0", release.Version())
+
+			got, err := updater.Download(context.Background(), release)
+			switch {
+			case tt.wantErr != nil:
+				assert.ErrorIs(t, err, tt.wantErr)
+			case tt.errMsg != "":
+				assert.ErrorContains(t, err, tt.errMsg)
+			default:
+				require.NoError(t, err)
+				assert.Equal(t, binary, got)
+			}
+		})
+	}
+}
+
+func TestParseReleaseKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+
+	// The body of cosign.pub, as set by the release build
+	parsed, err := parseReleaseKey(base64.StdEncoding.EncodeToString(der))
+	require.NoError(t, err)
+	assert.True(t, key.PublicKey.Equal(parsed))
+
+	_, err = parseReleaseKey("not a key")
+	assert.Error(t, err)
+}
+
+func TestReplace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tailscale-bind-ddns")
+	require.NoError(t, os.WriteFile(path, []byte("old"), 0o750))
+
+	require.NoError(t, Replace(path, []byte("new")))
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(content))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o750), info.Mode().Perm())
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "temporary files should be cleaned up")
+}
+
+func TestNewer(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"v1.4.0", "v1.3.9", true},
+		{"v1.10.0", "v1.9.0", true},
+		{"v1.4.0", "1.4.0", false},
+		{"v1.4.0", "v1.4.1", false},
+		{"v1.4.1", "v1.4", true},
+		{"v1.4.0", "v1.4.0-rc1", false},
+		{"v1.4.0", "dev", true},
+		{"dev", "v1.4.0", false},
+		{"dev", "dev", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+" vs "+tt.b, func(t *testing.T) {
+			assert.Equal(t, tt.want, Newer(tt.a, tt.b))
+		})
+	}
+}
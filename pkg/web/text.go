@@ -0,0 +1,90 @@
+package web
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// maxTextErrors is how many of the most recent errors the text view shows
+const maxTextErrors = 5
+
+// WriteText writes the dashboard as plain text for terminals: the state of publishing, the records that aren't synced
+// yet as pending changes, the machines, the records and the most recent errors
+func WriteText(w io.Writer, d Dashboard) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	counts := make(map[string]int)
+	var pending []Record
+	for _, record := range d.Records {
+		counts[record.Status]++
+		if record.Status != RecordSynced {
+			pending = append(pending, record)
+		}
+	}
+	fmt.Fprintf(tw, "Last sync: %s    Records: %d synced, %d pending, %d failed, %d skipped\n",
+		ago(d.Generated, d.LastSync), counts[RecordSynced], counts[RecordPending], counts[RecordFailed],
+		counts[RecordSkipped])
+	if d.Paused {
+		fmt.Fprintln(tw, "! Publishing is paused.")
+	}
+	if d.DryRun {
+		fmt.Fprintln(tw, "! Dry run: no changes are sent to DNS.")
+	}
+	if !d.StaleMachines.IsZero() {
+		fmt.Fprintf(tw, "! Tailscale is unavailable: the machines are those polled %s.\n",
+			ago(d.Generated, d.StaleMachines))
+	}
+
+	fmt.Fprintf(tw, "\nPENDING CHANGES (%d)\n", len(pending))
+	if len(pending) == 0 {
+		fmt.Fprintln(tw, "none")
+	} else {
+		writeRecords(tw, pending)
+	}
+
+	fmt.Fprintf(tw, "\nMACHINES (%d)\n", len(d.Machines))
+	fmt.Fprintln(tw, "MACHINE\tSTATE\tLAST SEEN\tADDRESSES\tRECORD NAME\tZONE")
+	for _, machine := range d.Machines {
+		state := "offline"
+		if machine.Online {
+			state = "online"
+		}
+		recordName := machine.RecordName
+		if recordName == "" {
+			recordName = "- (no records)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", machine.Name, state, ago(d.Generated, machine.LastSeen),
+			strings.Join(machine.Addresses, ","), recordName, machine.Zone)
+	}
+
+	fmt.Fprintf(tw, "\nRECORDS (%d)\n", len(d.Records))
+	writeRecords(tw, d.Records)
+
+	errs := d.Errors
+	if len(errs) > maxTextErrors {
+		errs = errs[:maxTextErrors]
+	}
+	fmt.Fprintf(tw, "\nRECENT ERRORS (%d)\n", len(d.Errors))
+	if len(errs) == 0 {
+		fmt.Fprintln(tw, "none")
+	}
+	for _, err := range errs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", ago(d.Generated, err.Time), err.Source, err.Message)
+	}
+	return tw.Flush()
+}
+
+// writeRecords writes records as a table with their status
+func writeRecords(tw *tabwriter.Writer, records []Record) {
+	fmt.Fprintln(tw, "TYPE\tNAME\tVALUE\tTTL\tZONE\tSTATUS")
+	for _, record := range records {
+		status := record.Status
+		if record.Detail != "" {
+			status += ": " + record.Detail
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\n", record.Type, record.Name, record.Value, record.TTL, record.Zone,
+			status)
+	}
+}
@@ -0,0 +1,124 @@
+// Package web serves a small status page showing the machines of the most recent poll, the records last handed to the
+// DNS provider with the outcome of their update and the most recent errors, to tell at a glance why a name doesn't
+// resolve.
+package web
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// Statuses of a record as of the most recent update
+const (
+	RecordSynced  = "synced"  // The update of its zone succeeded
+	RecordFailed  = "failed"  // The update of its zone failed
+	RecordSkipped = "skipped" // It was left out of the update, e.g. because another instance owns the name
+	RecordDryRun  = "dry-run" // The update was a dry run
+	RecordPending = "pending" // No update covered it yet
+)
+
+// refreshInterval is how often the page reloads itself
+const refreshInterval = 30 * time.Second
+
+//go:embed dashboard.html
+var dashboardHTML string
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"ago": ago,
+}).Parse(dashboardHTML))
+
+// ago returns how long before now t was, never when it's zero
+func ago(now, t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return now.Sub(t).Round(time.Second).String() + " ago"
+}
+
+// Dashboard is what the status page shows
+type Dashboard struct {
+	Generated time.Time `json:"generated"`
+	Paused    bool      `json:"paused"`
+	DryRun    bool      `json:"dry_run"`
+	// LastSync is when the records were last handed to the DNS provider, zero before the first time
+	LastSync time.Time `json:"last_sync,omitzero"`
+	// StaleMachines is when the machines shown were polled while they were read from the cache because Tailscale is
+	// unavailable, zero otherwise
+	StaleMachines time.Time `json:"stale_machines,omitzero"`
+	Machines      []Machine `json:"machines"`
+	Records       []Record  `json:"records"`
+	// Errors are the most recent errors, newest first
+	Errors []Error `json:"errors"`
+}
+
+// Machine is a machine of the most recent poll
+type Machine struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Online    bool      `json:"online"`
+	LastSeen  time.Time `json:"last_seen,omitzero"`
+	Addresses []string  `json:"addresses,omitempty"`
+	// RecordName is the name its records are published under, empty when it gets none
+	RecordName string `json:"record_name,omitempty"`
+	Zone       string `json:"zone,omitempty"`
+}
+
+// Record is a record last handed to the DNS provider and the outcome of its update
+type Record struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Value  string `json:"value"`
+	TTL    uint32 `json:"ttl"`
+	Zone   string `json:"zone,omitempty"`
+	Status string `json:"status"`           // One of the Record constants
+	Detail string `json:"detail,omitempty"` // Why the record failed or was skipped
+}
+
+// Error is an error of an update or a poll
+type Error struct {
+	Time    time.Time `json:"time"`
+	Source  string    `json:"source"` // e.g. the zone whose update failed
+	Message string    `json:"message"`
+}
+
+// templateData is what the template renders from
+type templateData struct {
+	Dashboard
+	Refresh int
+}
+
+// NewHandler returns the handler serving the status page, rendered from what snapshot returns on every request. With
+// the query parameter format=json the dashboard is served as JSON instead, e.g. for the watch command.
+func NewHandler(snapshot func() Dashboard) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if r.URL.Query().Get("format") == "json" {
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(snapshot()); err != nil {
+				klog.Errorf("Failed to encode the status page: %v", err)
+			}
+			return
+		}
+
+		// Rendered into a buffer first, so that a failing template doesn't leave half a page behind
+		var page bytes.Buffer
+		data := templateData{Dashboard: snapshot(), Refresh: int(refreshInterval.Seconds())}
+		if err := dashboardTemplate.Execute(&page, data); err != nil {
+			klog.Errorf("Failed to render the status page: %v", err)
+			http.Error(w, "rendering status page", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = page.WriteTo(w)
+	})
+}
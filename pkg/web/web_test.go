@@ -0,0 +1,91 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testDashboard returns a dashboard with a synced and a failed record
+func testDashboard() Dashboard {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	return Dashboard{
+		Generated: now,
+		Paused:    true,
+		LastSync:  now.Add(-90 * time.Second),
+		// Machines read from the cache
+		StaleMachines: now.Add(-time.Hour),
+		Machines: []Machine{
+			{ID: "n1", Name: "laptop.tailnet.ts.net", Online: true, LastSeen: now,
+				Addresses: []string{"100.64.0.1"}, RecordName: "laptop", Zone: "ts.example.com"},
+			{ID: "n2", Name: "<script>alert(1)</script>", Online: true},
+		},
+		Records: []Record{
+			{Name: "laptop", Type: "A", Value: "100.64.0.1", TTL: 300, Zone: "ts.example.com",
+				Status: RecordSynced},
+			{Name: "1.0.64.100.in-addr.arpa.", Type: "PTR", Value: "laptop.ts.example.com", TTL: 300,
+				Zone: "64.100.in-addr.arpa", Status: RecordFailed, Detail: "update refused: REFUSED"},
+		},
+		Errors: []Error{{Time: now.Add(-time.Minute), Source: "zone 64.100.in-addr.arpa",
+			Message: "update refused: REFUSED"}},
+	}
+}
+
+func TestHandler(t *testing.T) {
+	handler := NewHandler(testDashboard)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/dashboard", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+
+	page := rec.Body.String()
+	assert.Contains(t, page, "Publishing is paused.")
+	assert.Contains(t, page, "Tailscale is unavailable: the machines are those polled 1h0m0s ago.")
+	assert.Contains(t, page, "Records last handed to the DNS provider 1m30s ago.")
+	assert.Contains(t, page, "<h2>Machines (2)</h2>")
+	assert.Contains(t, page, `<td class="failed">failed: update refused: REFUSED</td>`)
+	assert.Contains(t, page, `<td class="synced">synced</td>`)
+	assert.Contains(t, page, "<td>1m0s ago</td><td>zone 64.100.in-addr.arpa</td>")
+	assert.Contains(t, page, "no records")
+	assert.NotContains(t, page, "<script>", "machine names are escaped")
+
+	// The watch command reads the dashboard as JSON
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/dashboard?format=json", nil))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var dashboard Dashboard
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&dashboard))
+	assert.Equal(t, testDashboard(), dashboard)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/dashboard", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestWriteText(t *testing.T) {
+	var out strings.Builder
+	require.NoError(t, WriteText(&out, testDashboard()))
+
+	view := out.String()
+	assert.Contains(t, view, "Last sync: 1m30s ago    Records: 1 synced, 0 pending, 1 failed, 0 skipped\n")
+	assert.Contains(t, view, "! Publishing is paused.\n")
+	assert.Contains(t, view, "! Tailscale is unavailable: the machines are those polled 1h0m0s ago.\n")
+	assert.NotContains(t, view, "Dry run")
+
+	// Records that aren't synced are listed as pending changes, before the machines
+	pending := view[strings.Index(view, "PENDING CHANGES (1)"):strings.Index(view, "MACHINES (2)")]
+	assert.Contains(t, pending, "failed: update refused: REFUSED")
+	assert.NotContains(t, pending, "100.64.0.1")
+
+	assert.Regexp(t, `laptop\.tailnet\.ts\.net +online +0s ago +100\.64\.0\.1 +laptop +ts\.example\.com`, view)
+	assert.Contains(t, view, "- (no records)")
+	assert.Regexp(t, `A +laptop +100\.64\.0\.1 +300 +ts\.example\.com +synced`, view)
+	assert.Regexp(t, `RECENT ERRORS \(1\)\n1m0s ago +zone 64\.100\.in-addr\.arpa +update refused: REFUSED`, view)
+}
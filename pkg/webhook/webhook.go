@@ -0,0 +1,202 @@
+// Package webhook receives Tailscale webhook events, so that device changes are published straight away instead of
+// with the next poll.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// SignatureHeader carries the signature of a webhook request, e.g. t=1700000000,v1=<hex HMAC-SHA256>
+	SignatureHeader = "Tailscale-Webhook-Signature"
+
+	// MaxClockSkew is how old or how far in the future the timestamp of a signed request may be, to limit replays
+	MaxClockSkew = 5 * time.Minute
+
+	// maxBodySize bounds the request bodies read, Tailscale sends events in small batches
+	maxBodySize = 1 << 20
+)
+
+// Event types that change which devices are in the tailnet
+const (
+	EventNodeCreated  = "nodeCreated"
+	EventNodeDeleted  = "nodeDeleted"
+	EventNodeApproved = "nodeApproved"
+)
+
+var (
+	// ErrMissingSignature is returned for requests without a signature header
+	ErrMissingSignature = errors.New("missing webhook signature")
+	// ErrInvalidSignature is returned for requests whose signature doesn't match their body
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	// ErrStaleSignature is returned for requests whose timestamp is further off than MaxClockSkew
+	ErrStaleSignature = errors.New("webhook signature timestamp out of range")
+)
+
+// Event is a single Tailscale webhook event
+type Event struct {
+	Timestamp time.Time       `json:"timestamp"`
+	Version   int             `json:"version"`
+	Type      string          `json:"type"`
+	Tailnet   string          `json:"tailnet"`
+	Message   string          `json:"message"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+// Join is a device created in the tailnet, as reported by a nodeCreated event
+type Join struct {
+	NodeID string
+	// DeviceName is the MagicDNS name of the device, e.g. host.tailnet.ts.net
+	DeviceName string
+	// Created is when Tailscale created the device, the event timestamp
+	Created time.Time
+}
+
+// nodeData is the data of node events
+type nodeData struct {
+	NodeID     string `json:"nodeID"`
+	DeviceName string `json:"deviceName"`
+}
+
+// Sign returns the signature header value of a body sent at t, as Tailscale computes it
+func Sign(secret string, t time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(signature(secret, timestamp, body))
+}
+
+// signature computes the HMAC-SHA256 of the timestamp and body
+func signature(secret, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// Verify checks the signature header of a request body against the secret
+func Verify(secret, header string, body []byte, now time.Time) error {
+	if header == "" {
+		return ErrMissingSignature
+	}
+
+	var timestamp string
+	var signatures [][]byte
+	for part := range strings.SplitSeq(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			if sig, err := hex.DecodeString(value); err == nil {
+				signatures = append(signatures, sig)
+			}
+		}
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return fmt.Errorf("%w: malformed header", ErrInvalidSignature)
+	}
+	if skew := now.Sub(time.Unix(seconds, 0)); skew > MaxClockSkew || skew < -MaxClockSkew {
+		return ErrStaleSignature
+	}
+
+	expected := signature(secret, timestamp, body)
+	for _, sig := range signatures {
+		if hmac.Equal(sig, expected) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// Handler receives webhook requests, verifies them and calls sync once per batch that holds an event changing which
+// devices are in the tailnet. sync must not block, requests are answered once it returns.
+type Handler struct {
+	secret string
+	sync   func()
+	join   func(Join)
+	now    func() time.Time
+}
+
+// NewHandler returns a handler verifying requests with secret and calling sync for device changes
+func NewHandler(secret string, sync func()) *Handler {
+	return &Handler{secret: secret, sync: sync, now: time.Now}
+}
+
+// SetJoinHook makes the handler call hook for every device a nodeCreated event reports, before sync is called. Like
+// sync it must not block.
+func (h *Handler) SetJoinHook(hook func(Join)) {
+	h.join = hook
+}
+
+// ServeHTTP handles a webhook request
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, "reading body", http.StatusBadRequest)
+		return
+	}
+
+	if err := Verify(h.secret, r.Header.Get(SignatureHeader), body, h.now()); err != nil {
+		klog.Warningf("Rejected webhook request from %s: %v", r.RemoteAddr, err)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var events []Event
+	if err := json.Unmarshal(body, &events); err != nil {
+		http.Error(w, fmt.Sprintf("decoding events: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	changed := false
+	for _, event := range events {
+		klog.V(1).Infof("Received webhook event %s: %s", event.Type, event.Message)
+		switch event.Type {
+		case EventNodeCreated:
+			h.reportJoin(event)
+			changed = true
+		case EventNodeDeleted, EventNodeApproved:
+			changed = true
+		}
+	}
+	if changed {
+		h.sync()
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// reportJoin hands the device a nodeCreated event reports to the join hook, events without a device name are ignored
+func (h *Handler) reportJoin(event Event) {
+	if h.join == nil {
+		return
+	}
+
+	var data nodeData
+	if err := json.Unmarshal(event.Data, &data); err != nil || data.DeviceName == "" {
+		klog.V(1).Infof("Ignoring %s event without device name: %s", event.Type, event.Message)
+		return
+	}
+	created := event.Timestamp
+	if created.IsZero() {
+		created = h.now()
+	}
+	h.join(Join{NodeID: data.NodeID, DeviceName: data.DeviceName, Created: created})
+}
@@ -0,0 +1,176 @@
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`[{"type":"nodeCreated"}]`)
+
+	tests := []struct {
+		name    string
+		header  string
+		wantErr error
+	}{
+		{
+			name:   "valid signature",
+			header: Sign("secret", now, body),
+		},
+		{
+			name:   "valid signature among several",
+			header: Sign("secret", now, body) + ",v1=00ff",
+		},
+		{
+			name:   "slightly old signature",
+			header: Sign("secret", now.Add(-time.Minute), body),
+		},
+		{
+			name:    "missing header",
+			wantErr: ErrMissingSignature,
+		},
+		{
+			name:    "wrong secret",
+			header:  Sign("other", now, body),
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "missing timestamp",
+			header:  "v1=00ff",
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "stale signature",
+			header:  Sign("secret", now.Add(-MaxClockSkew-time.Second), body),
+			wantErr: ErrStaleSignature,
+		},
+		{
+			name:    "signature from the future",
+			header:  Sign("secret", now.Add(MaxClockSkew+time.Second), body),
+			wantErr: ErrStaleSignature,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify("secret", tt.header, body, now)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		secret     string
+		wantStatus int
+		wantSync   int
+	}{
+		{
+			name:       "device change syncs once per batch",
+			body:       `[{"type":"nodeCreated","message":"Node created"},{"type":"nodeApproved"}]`,
+			secret:     "secret",
+			wantStatus: http.StatusOK,
+			wantSync:   1,
+		},
+		{
+			name:       "deleted device",
+			body:       `[{"type":"nodeDeleted","tailnet":"example.com","data":{"nodeID":"n1"}}]`,
+			secret:     "secret",
+			wantStatus: http.StatusOK,
+			wantSync:   1,
+		},
+		{
+			name:       "other events are acknowledged",
+			body:       `[{"type":"test"},{"type":"policyUpdate"}]`,
+			secret:     "secret",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "bad signature",
+			body:       `[{"type":"nodeCreated"}]`,
+			secret:     "other",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "malformed events",
+			body:       `{"type":"nodeCreated"}`,
+			secret:     "secret",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "wrong method",
+			method:     http.MethodGet,
+			secret:     "secret",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			syncs := 0
+			handler := NewHandler("secret", func() { syncs++ })
+			handler.now = func() time.Time { return now }
+
+			method := tt.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			req := httptest.NewRequest(method, "/webhook", strings.NewReader(tt.body))
+			req.Header.Set(SignatureHeader, Sign(tt.secret, now, []byte(tt.body)))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Equal(t, tt.wantSync, syncs)
+		})
+	}
+}
+
+func TestHandlerJoins(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	created := now.Add(-2 * time.Second)
+	body := `[{"timestamp":"` + created.UTC().Format(time.RFC3339) + `","type":"nodeCreated",` +
+		`"data":{"nodeID":"n1CNTRL","deviceName":"laptop.tailnet.ts.net"}},` +
+		`{"type":"nodeCreated","data":{"nodeID":"n2CNTRL","deviceName":"phone.tailnet.ts.net"}},` +
+		`{"type":"nodeCreated","data":{"nodeID":"n3CNTRL"}},` +
+		`{"type":"nodeDeleted","data":{"nodeID":"n4CNTRL","deviceName":"old.tailnet.ts.net"}}]`
+
+	var joins []Join
+	synced := false
+	handler := NewHandler("secret", func() {
+		synced = true
+		assert.Len(t, joins, 2, "joins are reported before the sync")
+	})
+	handler.SetJoinHook(func(join Join) { joins = append(joins, join) })
+	handler.now = func() time.Time { return now }
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+	req.Header.Set(SignatureHeader, Sign("secret", now, []byte(body)))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, synced)
+	require.Len(t, joins, 2)
+	assert.Equal(t, "n1CNTRL", joins[0].NodeID)
+	assert.Equal(t, "laptop.tailnet.ts.net", joins[0].DeviceName)
+	assert.True(t, created.Equal(joins[0].Created))
+	assert.Equal(t, "phone.tailnet.ts.net", joins[1].DeviceName)
+	assert.True(t, now.Equal(joins[1].Created), "events without timestamp count as created when received")
+}
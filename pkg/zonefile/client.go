@@ -0,0 +1,432 @@
+package zonefile
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/aauren/tailscale-bind-ddns/pkg/provider"
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// Servers that load zones from files instead of accepting dynamic updates, e.g. CoreDNS with the file plugin or NSD,
+// are served by writing the records into zone files in RFC 1035 format. A file is only rewritten when its records
+// changed, atomically so that the server never loads a partial one, after which the server is told to reload. The
+// files belong to this client entirely: records that are no longer desired are simply not written again.
+
+const (
+	// SOA timers of full zones, the TTLs of the SOA and NS records are the default record TTL
+	soaRefresh = 3600
+	soaRetry   = 600
+	soaExpire  = 604800
+
+	defaultTTL = 300
+)
+
+// Client writes records into the zone files of a DNS server
+type Client struct {
+	path          string   // Path of the zone files, with config.ZoneFilePlaceholder
+	zone          string   // Main forward zone, without a trailing dot
+	zones         []string // Canonical names of every managed zone
+	fullZone      bool
+	nameservers   []string // Fully qualified
+	hostmaster    string   // Fully qualified, empty for hostmaster.<zone>
+	serialFile    string
+	reloadCommand string
+	reloadPIDFile string
+	ttl           uint32
+
+	// Clock of update timings, retries and serials, result hook and update loop
+	provider.Updater
+
+	// Serializes updates. Holds what every zone file was last written with and whether the server still has to be
+	// told to reload since a reload failed.
+	updateMu      sync.Mutex
+	written       map[string]zoneFile
+	reloadPending bool
+}
+
+// zoneFile is the content of a zone file
+type zoneFile struct {
+	records []string // Records other than the SOA and NS records of full zones, in presentation format and sorted
+	serial  uint32   // Serial of the SOA record of full zones
+}
+
+// NewClientFromConfig creates a client writing the managed zones of the configuration into files
+func NewClientFromConfig(cfg *config.Config) (*Client, error) {
+	zoneCfg := &cfg.Providers.ZoneFile
+	if zoneCfg.Path == "" {
+		return nil, fmt.Errorf("path is required")
+	}
+	if cfg.Bind.Zone == "" {
+		return nil, fmt.Errorf("zone is required")
+	}
+
+	var zones []string
+	for _, zone := range cfg.ManagedZones() {
+		if zone := dns.CanonicalName(zone); !slices.Contains(zones, zone) {
+			zones = append(zones, zone)
+		}
+	}
+	nameservers := make([]string, 0, len(zoneCfg.Nameservers))
+	for _, nameserver := range zoneCfg.Nameservers {
+		nameservers = append(nameservers, dns.Fqdn(nameserver))
+	}
+	var hostmaster string
+	if zoneCfg.Hostmaster != "" {
+		hostmaster = dns.Fqdn(zoneCfg.Hostmaster)
+	}
+	ttl := uint32(cfg.Bind.TTL.Seconds())
+	if ttl == 0 {
+		ttl = defaultTTL
+	}
+
+	return &Client{
+		path:          zoneCfg.Path,
+		zone:          strings.TrimSuffix(cfg.Bind.Zone, "."),
+		zones:         zones,
+		fullZone:      zoneCfg.FullZone,
+		nameservers:   nameservers,
+		hostmaster:    hostmaster,
+		serialFile:    zoneCfg.SerialFile,
+		reloadCommand: zoneCfg.ReloadCommand,
+		reloadPIDFile: zoneCfg.ReloadPIDFile,
+		ttl:           ttl,
+	}, nil
+}
+
+// ValidateConnection checks that the directories of the zone files exist
+func (c *Client) ValidateConnection(context.Context) error {
+	for _, zone := range c.zones {
+		dir := filepath.Dir(zonePath(c.path, zone))
+		info, err := os.Stat(dir)
+		if err != nil {
+			return fmt.Errorf("zone file directory of %s: %w", zone, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("zone file directory of %s: %s is not a directory", zone, dir)
+		}
+	}
+	return nil
+}
+
+// UpdateRecords writes the zone files whose records changed and tells the server to reload them. Every managed zone
+// gets a file, one without records too. The returned error joins the errors of the failed zones and of the reload, the
+// result is returned in either case.
+func (c *Client) UpdateRecords(ctx context.Context, records []bind.DNSRecord, dryRun bool) (*bind.SyncResult, error) {
+	clk := c.Clock()
+	result := &bind.SyncResult{Started: clk.Now(), DryRun: dryRun}
+	defer func() { result.Duration = clk.Since(result.Started) }()
+
+	if dryRun {
+		klog.Infof("DRY RUN: Would write %d DNS records into zone files", len(records))
+		for _, record := range records {
+			klog.V(1).Infof("DRY RUN: Would write %s record %s -> %s (TTL: %d)", record.Key().Type, record.Name,
+				record.Value, record.TTL)
+		}
+		return result, nil
+	}
+
+	c.updateMu.Lock()
+	defer c.updateMu.Unlock()
+
+	recordsByZone := make(map[string][]bind.DNSRecord, len(c.zones))
+	for _, record := range records {
+		zone := c.recordZone(record)
+		if !slices.Contains(c.zones, zone) {
+			result.Skipped = append(result.Skipped, bind.SkippedRecord{Record: record, Reason: bind.SkipOutsideZones})
+			continue
+		}
+		recordsByZone[zone] = append(recordsByZone[zone], record)
+	}
+
+	var errs []error
+	for _, zone := range c.zones {
+		zoneResult := c.writeZone(zone, recordsByZone[zone])
+		if err := zoneResult.Err(); err != nil {
+			errs = append(errs, fmt.Errorf("zone %s: %w", zone, err))
+		}
+		result.Zones = append(result.Zones, zoneResult)
+	}
+
+	if c.reloadPending {
+		if err := c.reload(ctx); err != nil {
+			errs = append(errs, err)
+		} else {
+			c.reloadPending = false
+		}
+	}
+	return result, errors.Join(errs...)
+}
+
+// recordZone returns the canonical name of the zone a record belongs to, the most specific managed zone for PTR
+// records and empty when no managed zone holds their name
+func (c *Client) recordZone(record bind.DNSRecord) string {
+	if record.Key().Type != bind.TypePTR {
+		if record.Zone != "" {
+			return dns.CanonicalName(record.Zone)
+		}
+		return dns.CanonicalName(c.zone)
+	}
+
+	name, best := dns.CanonicalName(record.Name), ""
+	for _, zone := range c.zones {
+		if dns.IsSubDomain(zone, name) && dns.CountLabel(zone) > dns.CountLabel(best) {
+			best = zone
+		}
+	}
+	return best
+}
+
+// writeZone writes the file of a zone unless it already holds the records
+func (c *Client) writeZone(zone string, records []bind.DNSRecord) bind.ZoneResult {
+	clk := c.Clock()
+	started := clk.Now()
+	result := bind.ZoneResult{Zone: strings.TrimSuffix(zone, "."), Rcode: bind.NoResponse, Records: len(records)}
+	finish := func(err error) bind.ZoneResult {
+		result.Duration = clk.Since(started)
+		if err != nil {
+			result.SetErr(err)
+			return result
+		}
+		result.Rcode = dns.RcodeSuccess
+		return result
+	}
+
+	var rrs []dns.RR
+	for _, record := range records {
+		rr := record.RR(zone)
+		if rr == nil {
+			klog.Warningf("Skipping %s record %s: invalid value %q", record.Key().Type, record.Name, record.Value)
+			continue
+		}
+		rrs = append(rrs, rr)
+	}
+	desired := zoneFile{records: presentation(rrs)}
+
+	path := zonePath(c.path, zone)
+	current, ok := c.written[zone]
+	if !ok {
+		current = c.readZoneFile(path, zone)
+	}
+	if current.records != nil && slices.Equal(current.records, desired.records) {
+		klog.V(1).Infof("Zone file %s is up to date", path)
+		for _, record := range records {
+			result.Skipped = append(result.Skipped, bind.SkippedRecord{Record: record, Reason: bind.SkipAlreadyApplied})
+		}
+		result.Serial = current.serial
+		return finish(nil)
+	}
+
+	var content strings.Builder
+	content.WriteString("; Written by tailscale-bind-ddns, changes are overwritten\n")
+	if c.fullZone {
+		desired.serial = nextSerial(current.serial, clk.Now())
+		for _, rr := range c.apexRRs(zone, desired.serial) {
+			content.WriteString(rr.String() + "\n")
+		}
+	}
+	for _, record := range desired.records {
+		content.WriteString(record + "\n")
+	}
+
+	klog.V(1).Infof("Writing %d records to zone file %s", len(desired.records), path)
+	if err := writeFile(path, []byte(content.String())); err != nil {
+		return finish(err)
+	}
+	if c.serialFile != "" {
+		serial, err := bumpSerialFile(zonePath(c.serialFile, zone), clk.Now())
+		if err != nil {
+			return finish(err)
+		}
+		desired.serial = serial
+	}
+
+	if c.written == nil {
+		c.written = make(map[string]zoneFile)
+	}
+	c.written[zone] = desired
+	c.reloadPending = true
+
+	result.Sent = len(desired.records)
+	for _, record := range current.records {
+		if !slices.Contains(desired.records, record) {
+			result.Removed++
+		}
+	}
+	result.Serial = desired.serial
+	return finish(nil)
+}
+
+// apexRRs returns the SOA and NS records of a full zone
+func (c *Client) apexRRs(zone string, serial uint32) []dns.RR {
+	header := func(rrtype uint16) dns.RR_Header {
+		return dns.RR_Header{Name: zone, Rrtype: rrtype, Class: dns.ClassINET, Ttl: c.ttl}
+	}
+	hostmaster := c.hostmaster
+	if hostmaster == "" {
+		hostmaster = "hostmaster." + zone
+	}
+
+	rrs := []dns.RR{&dns.SOA{
+		Hdr:     header(dns.TypeSOA),
+		Ns:      c.nameservers[0],
+		Mbox:    hostmaster,
+		Serial:  serial,
+		Refresh: soaRefresh,
+		Retry:   soaRetry,
+		Expire:  soaExpire,
+		Minttl:  c.ttl,
+	}}
+	for _, nameserver := range c.nameservers {
+		rrs = append(rrs, &dns.NS{Hdr: header(dns.TypeNS), Ns: nameserver})
+	}
+	return rrs
+}
+
+// readZoneFile reads the records of a zone file written earlier, e.g. before a restart. A file that is missing or
+// can't be parsed yields no records, so that it's written again.
+func (c *Client) readZoneFile(path, zone string) zoneFile {
+	f, err := os.Open(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			klog.Warningf("Failed to read zone file %s, writing it again: %v", path, err)
+		}
+		return zoneFile{}
+	}
+	defer f.Close()
+
+	var (
+		current zoneFile
+		rrs     []dns.RR
+	)
+	parser := dns.NewZoneParser(f, zone, path)
+	for rr, ok := parser.Next(); ok; rr, ok = parser.Next() {
+		switch {
+		case rr.Header().Rrtype == dns.TypeSOA:
+			current.serial = rr.(*dns.SOA).Serial
+		case c.fullZone && rr.Header().Rrtype == dns.TypeNS && dns.CanonicalName(rr.Header().Name) == zone:
+		default:
+			rrs = append(rrs, rr)
+		}
+	}
+	if err := parser.Err(); err != nil {
+		klog.Warningf("Failed to parse zone file %s, writing it again: %v", path, err)
+		return zoneFile{serial: current.serial}
+	}
+	current.records = presentation(rrs)
+	if current.records == nil {
+		current.records = []string{}
+	}
+	return current
+}
+
+// presentation returns records in presentation format, sorted by name and type and without duplicates
+func presentation(rrs []dns.RR) []string {
+	slices.SortStableFunc(rrs, func(a, b dns.RR) int {
+		return cmp.Or(
+			strings.Compare(dns.CanonicalName(a.Header().Name), dns.CanonicalName(b.Header().Name)),
+			cmp.Compare(a.Header().Rrtype, b.Header().Rrtype),
+			strings.Compare(a.String(), b.String()),
+		)
+	})
+
+	records := make([]string, 0, len(rrs))
+	for _, rr := range rrs {
+		records = append(records, rr.String())
+	}
+	return slices.Compact(records)
+}
+
+// zonePath returns the path of the file of a zone, replacing the placeholder with the zone name
+func zonePath(path, zone string) string {
+	return strings.ReplaceAll(path, config.ZoneFilePlaceholder, strings.TrimSuffix(zone, "."))
+}
+
+// writeFile replaces a file atomically, so that the server never loads a partial zone
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("creating zone file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing zone file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing zone file: %w", err)
+	}
+	// Temporary files are only readable by their owner, the server may run as another user
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return fmt.Errorf("writing zone file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replacing zone file: %w", err)
+	}
+	return nil
+}
+
+// reload runs the reload command and sends SIGHUP to the process of the PID file
+func (c *Client) reload(ctx context.Context) error {
+	if c.reloadCommand != "" {
+		klog.V(1).Infof("Running reload command: %s", c.reloadCommand)
+		if output, err := shellCommand(ctx, c.reloadCommand).CombinedOutput(); err != nil {
+			return fmt.Errorf("running reload command: %w: %s", err, strings.TrimSpace(string(output)))
+		}
+	}
+
+	if c.reloadPIDFile != "" {
+		data, err := os.ReadFile(c.reloadPIDFile)
+		if err != nil {
+			return fmt.Errorf("reading reload PID file: %w", err)
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			return fmt.Errorf("reading reload PID file %s: %w", c.reloadPIDFile, err)
+		}
+		process, err := os.FindProcess(pid)
+		if err != nil {
+			return fmt.Errorf("finding process %d: %w", pid, err)
+		}
+		klog.V(1).Infof("Sending SIGHUP to process %d", pid)
+		if err := process.Signal(syscall.SIGHUP); err != nil {
+			return fmt.Errorf("sending SIGHUP to process %d: %w", pid, err)
+		}
+	}
+	return nil
+}
+
+// shellCommand builds a command run through the shell of the platform
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "/bin/sh", "-c", command)
+}
+
+// StartUpdating writes record sets received on recordChan until the context is cancelled or the channel is closed.
+// A record set that fails is retried every update interval until it succeeds or a newer one arrives.
+func (c *Client) StartUpdating(
+	ctx context.Context,
+	updateInterval time.Duration,
+	recordChan <-chan []bind.DNSRecord,
+	dryRun bool,
+) {
+	c.Run(ctx, "zone file", updateInterval, recordChan, dryRun, c.UpdateRecords)
+}
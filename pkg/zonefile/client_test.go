@@ -0,0 +1,200 @@
+package zonefile
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aauren/tailscale-bind-ddns/pkg/bind"
+	"github.com/aauren/tailscale-bind-ddns/pkg/clock"
+	"github.com/aauren/tailscale-bind-ddns/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestClient(t *testing.T, zoneCfg config.ZoneFileConfig) *Client {
+	client, err := NewClientFromConfig(&config.Config{
+		Bind: config.BindConfig{
+			Zone: "ts.example.com",
+			TTL:  5 * time.Minute,
+			PTR:  config.PTRConfig{Enabled: true, IPv4Zone: "64.100.in-addr.arpa"},
+		},
+		Providers: config.ProvidersConfig{ZoneFile: zoneCfg},
+	})
+	require.NoError(t, err)
+	client.SetClock(clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+	return client
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestUpdateRecordsFullZone(t *testing.T) {
+	dir := t.TempDir()
+	zoneCfg := config.ZoneFileConfig{
+		Path:          filepath.Join(dir, "{zone}.zone"),
+		FullZone:      true,
+		Nameservers:   []string{"ns1.example.com", "ns2.example.com"},
+		ReloadCommand: "echo reload >> " + filepath.Join(dir, "reloads"),
+	}
+	client := newTestClient(t, zoneCfg)
+	require.NoError(t, client.ValidateConnection(context.Background()))
+	reloads := func() int { return strings.Count(readFile(t, filepath.Join(dir, "reloads")), "reload") }
+
+	records := []bind.DNSRecord{
+		{Name: "server", Value: "100.64.0.2", TTL: 60, Type: "A"},
+		{Name: "laptop", Value: "100.64.0.1", TTL: 300, Type: "A"},
+		{Name: "laptop", Value: "fd7a:115c::1", TTL: 300, Type: "AAAA"},
+		{Name: "1.0.64.100.in-addr.arpa.", Value: "laptop.ts.example.com", TTL: 300, Type: "PTR"},
+		{Name: "1.2.0.192.ip6.arpa.", Value: "laptop.ts.example.com", TTL: 300, Type: "PTR"},
+	}
+	result, err := client.UpdateRecords(context.Background(), records, false)
+	require.NoError(t, err)
+	assert.Equal(t, []bind.SkippedRecord{{Record: records[4], Reason: bind.SkipOutsideZones}}, result.Skipped)
+	require.Len(t, result.Zones, 2)
+	assert.Equal(t, "ts.example.com", result.Zones[0].Zone)
+	assert.Equal(t, 3, result.Zones[0].Sent)
+	assert.Equal(t, uint32(2024010100), result.Zones[0].Serial)
+
+	assert.Equal(t, "; Written by tailscale-bind-ddns, changes are overwritten\n"+
+		"ts.example.com.\t300\tIN\tSOA\tns1.example.com. hostmaster.ts.example.com. 2024010100 3600 600 604800 300\n"+
+		"ts.example.com.\t300\tIN\tNS\tns1.example.com.\n"+
+		"ts.example.com.\t300\tIN\tNS\tns2.example.com.\n"+
+		"laptop.ts.example.com.\t300\tIN\tA\t100.64.0.1\n"+
+		"laptop.ts.example.com.\t300\tIN\tAAAA\tfd7a:115c::1\n"+
+		"server.ts.example.com.\t60\tIN\tA\t100.64.0.2\n",
+		readFile(t, filepath.Join(dir, "ts.example.com.zone")))
+	assert.Contains(t, readFile(t, filepath.Join(dir, "64.100.in-addr.arpa.zone")),
+		"1.0.64.100.in-addr.arpa.\t300\tIN\tPTR\tlaptop.ts.example.com.\n")
+	assert.Equal(t, 1, reloads())
+
+	// Unchanged zones aren't written again and the server isn't reloaded
+	result, err = client.UpdateRecords(context.Background(), records, false)
+	require.NoError(t, err)
+	assert.Zero(t, result.Zones[0].Sent)
+	assert.Len(t, result.Zones[0].Skipped, 3)
+	assert.Equal(t, 1, reloads())
+
+	// Changes increase the serial and records no longer desired are left out
+	result, err = client.UpdateRecords(context.Background(), records[1:4], false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Zones[0].Removed)
+	assert.Equal(t, uint32(2024010101), result.Zones[0].Serial)
+	assert.NotContains(t, readFile(t, filepath.Join(dir, "ts.example.com.zone")), "server")
+	assert.Equal(t, 2, reloads())
+
+	// After a restart, the files written earlier are only replaced once their records change
+	client = newTestClient(t, zoneCfg)
+	result, err = client.UpdateRecords(context.Background(), records[1:4], false)
+	require.NoError(t, err)
+	assert.Zero(t, result.Zones[0].Sent)
+	assert.Equal(t, 2, reloads())
+	result, err = client.UpdateRecords(context.Background(), records[1:2], false)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2024010102), result.Zones[0].Serial)
+	assert.Equal(t, 3, reloads())
+}
+
+func TestUpdateRecordsFragment(t *testing.T) {
+	dir := t.TempDir()
+	serialFile := filepath.Join(dir, "ts.example.com.db")
+	zone := "$TTL 300\n" +
+		"; SOA ns1.example.com. admin.example.com. 1\n" +
+		"@ IN SOA ns1.example.com. admin.example.com. ( ; primary and mailbox\n" +
+		"    2023123105 ; serial\n" +
+		"    3600 600 604800 300 )\n" +
+		"$INCLUDE tailnet.zone\n"
+	require.NoError(t, os.WriteFile(serialFile, []byte(zone), 0o644))
+
+	client := newTestClient(t, config.ZoneFileConfig{
+		Path:       filepath.Join(dir, "tailnet.zone"),
+		SerialFile: serialFile,
+	})
+	// Records of the reverse zone have no file of their own
+	client.zones = client.zones[:1]
+
+	records := []bind.DNSRecord{{Name: "laptop", Value: "100.64.0.1", TTL: 300, Type: "A"}}
+	result, err := client.UpdateRecords(context.Background(), records, false)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2024010100), result.Zones[0].Serial)
+	assert.Equal(t, "; Written by tailscale-bind-ddns, changes are overwritten\n"+
+		"laptop.ts.example.com.\t300\tIN\tA\t100.64.0.1\n", readFile(t, filepath.Join(dir, "tailnet.zone")))
+	assert.Equal(t, strings.Replace(zone, "2023123105", "2024010100", 1), readFile(t, serialFile))
+
+	// The serial is only increased when the fragment changed
+	_, err = client.UpdateRecords(context.Background(), records, false)
+	require.NoError(t, err)
+	_, err = client.UpdateRecords(context.Background(), nil, false)
+	require.NoError(t, err)
+	assert.Contains(t, readFile(t, serialFile), "2024010101 ; serial")
+
+	// A serial file without an SOA record fails the update
+	require.NoError(t, os.WriteFile(serialFile, []byte("$INCLUDE tailnet.zone\n"), 0o644))
+	result, err = client.UpdateRecords(context.Background(), records, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "has no SOA record")
+	assert.Equal(t, 1, result.Zones[0].Records)
+}
+
+func TestReloadPIDFile(t *testing.T) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	dir := t.TempDir()
+	pidFile := filepath.Join(dir, "coredns.pid")
+	require.NoError(t, os.WriteFile(pidFile, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0o644))
+	client := newTestClient(t, config.ZoneFileConfig{Path: filepath.Join(dir, "{zone}.zone"), ReloadPIDFile: pidFile})
+
+	_, err := client.UpdateRecords(context.Background(), nil, false)
+	require.NoError(t, err)
+	select {
+	case <-hangups:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no SIGHUP received")
+	}
+
+	// A failed reload is retried with the next update, even without changes
+	require.NoError(t, os.WriteFile(pidFile, []byte("not a pid"), 0o644))
+	client.written = nil
+	require.NoError(t, os.Remove(filepath.Join(dir, "ts.example.com.zone")))
+	_, err = client.UpdateRecords(context.Background(), nil, false)
+	require.Error(t, err)
+	require.NoError(t, os.WriteFile(pidFile, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0o644))
+	_, err = client.UpdateRecords(context.Background(), nil, false)
+	require.NoError(t, err)
+	select {
+	case <-hangups:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reload not retried")
+	}
+}
+
+func TestNextSerial(t *testing.T) {
+	now := time.Date(2024, 3, 9, 23, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		previous uint32
+		want     uint32
+	}{
+		{name: "new zone", previous: 0, want: 2024030900},
+		{name: "earlier day", previous: 2024030812, want: 2024030900},
+		{name: "same day", previous: 2024030907, want: 2024030908},
+		{name: "counter serial ahead of the date", previous: 3000000000, want: 3000000001},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, nextSerial(tt.previous, now))
+		})
+	}
+}
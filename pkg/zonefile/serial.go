@@ -0,0 +1,75 @@
+package zonefile
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Serials follow the common YYYYMMDDnn convention where they can, and simply count up once a day saw more than a
+// hundred changes or when the zone already had a larger serial.
+
+// soaSerial matches an SOA record up to its serial, which follows the primary name server and the mailbox, possibly
+// after the opening parenthesis of a record spanning several lines. Comments have to be masked beforehand.
+var soaSerial = regexp.MustCompile(`(?i)\sSOA\s+(?:\(\s*)?\S+\s+\S+\s+(?:\(\s*)?(\d+)`)
+
+// nextSerial returns the serial following previous
+func nextSerial(previous uint32, now time.Time) uint32 {
+	year, month, day := now.UTC().Date()
+	return max(previous+1, uint32(year*1000000+int(month)*10000+day*100))
+}
+
+// bumpSerialFile increases the SOA serial of a zone file maintained by hand, keeping the rest of it as it is, and
+// returns the new serial
+func bumpSerialFile(path string, now time.Time) (uint32, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("reading serial file: %w", err)
+	}
+
+	match := soaSerial.FindSubmatchIndex(maskComments(data))
+	if match == nil {
+		return 0, fmt.Errorf("serial file %s has no SOA record", path)
+	}
+	start, end := match[2], match[3]
+	previous, err := strconv.ParseUint(string(data[start:end]), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("serial file %s: invalid serial %q", path, data[start:end])
+	}
+
+	serial := nextSerial(uint32(previous), now)
+	bumped := make([]byte, 0, len(data)+2)
+	bumped = append(bumped, data[:start]...)
+	bumped = strconv.AppendUint(bumped, uint64(serial), 10)
+	bumped = append(bumped, data[end:]...)
+	if err := writeFile(path, bumped); err != nil {
+		return 0, fmt.Errorf("serial file %s: %w", path, err)
+	}
+	return serial, nil
+}
+
+// maskComments returns a copy of a zone file with its comments replaced by spaces, so that offsets stay the same
+func maskComments(data []byte) []byte {
+	masked := make([]byte, len(data))
+	var quoted, comment, escaped bool
+	for i, b := range data {
+		switch {
+		case comment && b == '\n':
+			comment = false
+		case comment:
+			b = ' '
+		case escaped:
+			escaped = false
+		case b == '\\':
+			escaped = true
+		case b == '"':
+			quoted = !quoted
+		case b == ';' && !quoted:
+			comment, b = true, ' '
+		}
+		masked[i] = b
+	}
+	return masked
+}